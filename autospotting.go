@@ -8,10 +8,12 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"os"
 	"strconv"
 
 	autospotting "github.com/AutoSpotting/AutoSpotting/core"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 )
 
 var as *autospotting.AutoSpotting
@@ -31,6 +33,22 @@ func main() {
 
 	if autospotting.RunningFromLambda() {
 		lambda.Start(Handler)
+	} else if conf.Analyze {
+		runAnalysis()
+	} else if conf.ExplainASG != "" || conf.ExplainInstance != "" {
+		runExplain()
+	} else if conf.Cleanup {
+		runCleanup()
+	} else if conf.RevertASGs != "" {
+		runRevert()
+	} else if conf.Deploy {
+		runDeploy()
+	} else if conf.RenderTemplate {
+		runRenderTemplate()
+	} else if conf.IAMPolicy {
+		runIAMPolicy()
+	} else if conf.DaemonMode {
+		runDaemon()
 	} else if eventFile != "" {
 		parseEvent, err := ioutil.ReadFile(eventFile)
 		if err != nil {
@@ -57,6 +75,78 @@ func eventHandler(event *json.RawMessage) {
 	log.Println("Execution completed, nothing left to do")
 }
 
+func runDaemon() {
+	log.Println("Starting autospotting daemon, build ", Version, "expiring on", ExpirationDate, "charging", SavingsCut, "percent of savings via AWS Marketplace")
+
+	if isExpired(ExpirationDate) {
+		log.Println("Autospotting expired, please install a newer nightly version, build it from source or get a stable build.")
+		return
+	}
+
+	log.Printf("Configuration flags: %#v", conf)
+
+	if err := as.RunDaemon(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Daemon stopped, nothing left to do")
+}
+
+func runAnalysis() {
+	log.Println("Analyzing AutoScaling groups, build", Version)
+
+	if err := as.Analyze(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runExplain() {
+	target := conf.ExplainASG
+	if conf.ExplainInstance != "" {
+		target = conf.ExplainInstance
+	}
+	log.Println("Explaining the evaluation of", target, "build", Version)
+
+	if err := as.Explain(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runCleanup() {
+	log.Println("Looking for resources left over by AutoSpotting, build", Version)
+
+	if err := as.Cleanup(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runRevert() {
+	log.Println("Reverting AutoScaling groups to on-demand instances, build", Version)
+
+	if err := as.Revert(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runDeploy() {
+	log.Println("Deploying AutoSpotting, build", Version)
+
+	if err := as.Deploy(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runRenderTemplate() {
+	if err := as.RenderTemplate(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runIAMPolicy() {
+	if err := as.IAMPolicy(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
 // this is the equivalent of a main for when running from Lambda, but on Lambda
 // the runFromCronEvent() is executed within the handler function every time we have an event
 func init() {
@@ -78,5 +168,8 @@ func init() {
 
 // Handler implements the AWS Lambda handler interface
 func Handler(ctx context.Context, rawEvent json.RawMessage) {
+	deadline, _ := ctx.Deadline()
+	as.SetExecutionBudget(deadline, lambdacontext.MemoryLimitInMB)
+
 	eventHandler(&rawEvent)
 }
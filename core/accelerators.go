@@ -0,0 +1,79 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// acceleratorInformation describes the GPUs or machine learning accelerators,
+// such as Inferentia or Trainium, attached to an instance type.
+type acceleratorInformation struct {
+	manufacturer string
+	model        string
+	count        int
+
+	// total accelerator memory, in MiB
+	memory int64
+}
+
+// setAcceleratorInformation adds the accelerator details to the instance type
+// information, since they're missing from the static instance type data.
+func (r *region) setAcceleratorInformation(instanceType string, accelerator acceleratorInformation) {
+	info, found := r.instanceTypeInformation[instanceType]
+	if !found || accelerator.model == "" {
+		return
+	}
+	info.accelerator = accelerator
+	r.instanceTypeInformation[instanceType] = info
+}
+
+// describeAccelerators converts the GPU or inference accelerator details
+// returned by the EC2 API.
+func describeAccelerators(it *ec2.InstanceTypeInfo) acceleratorInformation {
+	var accelerator acceleratorInformation
+
+	if it.GpuInfo != nil && len(it.GpuInfo.Gpus) > 0 {
+		for _, gpu := range it.GpuInfo.Gpus {
+			accelerator.count += int(aws.Int64Value(gpu.Count))
+		}
+		accelerator.manufacturer = aws.StringValue(it.GpuInfo.Gpus[0].Manufacturer)
+		accelerator.model = aws.StringValue(it.GpuInfo.Gpus[0].Name)
+		accelerator.memory = aws.Int64Value(it.GpuInfo.TotalGpuMemoryInMiB)
+		return accelerator
+	}
+
+	if it.InferenceAcceleratorInfo != nil && len(it.InferenceAcceleratorInfo.Accelerators) > 0 {
+		for _, device := range it.InferenceAcceleratorInfo.Accelerators {
+			accelerator.count += int(aws.Int64Value(device.Count))
+		}
+		accelerator.manufacturer = aws.StringValue(it.InferenceAcceleratorInfo.Accelerators[0].Manufacturer)
+		accelerator.model = aws.StringValue(it.InferenceAcceleratorInfo.Accelerators[0].Name)
+	}
+
+	return accelerator
+}
+
+// isAcceleratorCompatible makes sure the instances having accelerators are
+// only replaced by instance types using the same accelerator model, which
+// need the same drivers, in at least the same number and with at least as
+// much memory.
+func (i *instance) isAcceleratorCompatible(spotCandidate instanceTypeInformation) bool {
+	current, candidate := i.typeInfo.accelerator, spotCandidate.accelerator
+
+	if current.model == "" {
+		return true
+	}
+
+	if candidate.manufacturer == current.manufacturer &&
+		candidate.model == current.model &&
+		candidate.count >= current.count &&
+		candidate.memory >= current.memory {
+		return true
+	}
+
+	debug.Println("\tNot accelerator compatible, current", current, "candidate", candidate)
+	return false
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var (
+	v100 = acceleratorInformation{manufacturer: "NVIDIA", model: "V100", count: 1, memory: 16384}
+	t4   = acceleratorInformation{manufacturer: "NVIDIA", model: "T4", count: 1, memory: 16384}
+)
+
+func Test_describeAccelerators(t *testing.T) {
+	tests := []struct {
+		name string
+		info *ec2.InstanceTypeInfo
+		want acceleratorInformation
+	}{
+		{
+			name: "no accelerators",
+			info: &ec2.InstanceTypeInfo{InstanceType: aws.String("m5.large")},
+			want: acceleratorInformation{},
+		},
+		{
+			name: "GPUs",
+			info: &ec2.InstanceTypeInfo{
+				InstanceType: aws.String("p3.8xlarge"),
+				GpuInfo: &ec2.GpuInfo{
+					Gpus: []*ec2.GpuDeviceInfo{
+						{Count: aws.Int64(4), Manufacturer: aws.String("NVIDIA"), Name: aws.String("V100")},
+					},
+					TotalGpuMemoryInMiB: aws.Int64(65536),
+				},
+			},
+			want: acceleratorInformation{manufacturer: "NVIDIA", model: "V100", count: 4, memory: 65536},
+		},
+		{
+			name: "inference accelerators",
+			info: &ec2.InstanceTypeInfo{
+				InstanceType: aws.String("inf1.6xlarge"),
+				InferenceAcceleratorInfo: &ec2.InferenceAcceleratorInfo{
+					Accelerators: []*ec2.InferenceDeviceInfo{
+						{Count: aws.Int64(4), Manufacturer: aws.String("AWS"), Name: aws.String("Inferentia")},
+					},
+				},
+			},
+			want: acceleratorInformation{manufacturer: "AWS", model: "Inferentia", count: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeAccelerators(tt.info); got != tt.want {
+				t.Errorf("describeAccelerators() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isAcceleratorCompatible(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   acceleratorInformation
+		candidate acceleratorInformation
+		want      bool
+	}{
+		{
+			name:      "no accelerators",
+			current:   acceleratorInformation{},
+			candidate: acceleratorInformation{},
+			want:      true,
+		},
+		{
+			name:      "same model",
+			current:   v100,
+			candidate: acceleratorInformation{manufacturer: "NVIDIA", model: "V100", count: 4, memory: 65536},
+			want:      true,
+		},
+		{
+			name:      "different model",
+			current:   v100,
+			candidate: t4,
+			want:      false,
+		},
+		{
+			name:      "less memory",
+			current:   v100,
+			candidate: acceleratorInformation{manufacturer: "NVIDIA", model: "V100", count: 1, memory: 8192},
+			want:      false,
+		},
+		{
+			name:      "fewer accelerators",
+			current:   acceleratorInformation{manufacturer: "AWS", model: "Inferentia", count: 4},
+			candidate: acceleratorInformation{manufacturer: "AWS", model: "Inferentia", count: 1},
+			want:      false,
+		},
+		{
+			name:      "missing accelerator information for the candidate",
+			current:   v100,
+			candidate: acceleratorInformation{},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{typeInfo: instanceTypeInformation{accelerator: tt.current}}
+			if got := i.isAcceleratorCompatible(instanceTypeInformation{accelerator: tt.candidate}); got != tt.want {
+				t.Errorf("isAcceleratorCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
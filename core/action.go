@@ -13,7 +13,7 @@ type target struct {
 }
 
 type runer interface {
-	run()
+	run() error
 }
 
 // No-op run
@@ -21,16 +21,16 @@ type skipRun struct {
 	reason string
 }
 
-func (s skipRun) run() {}
+func (s skipRun) run() error { return nil }
 
 // terminates a random spot instance after enabling the event-based logic
 type terminateSpotInstance struct {
 	target target
 }
 
-func (tsi terminateSpotInstance) run() {
+func (tsi terminateSpotInstance) run() error {
 	asg := tsi.target.asg
-	asg.terminateRandomSpotInstanceIfHavingEnough(
+	return asg.terminateRandomSpotInstanceIfHavingEnough(
 		tsi.target.totalInstances, true)
 }
 
@@ -39,47 +39,85 @@ type launchSpotReplacement struct {
 	target target
 }
 
-func (lsr launchSpotReplacement) run() {
+func (lsr launchSpotReplacement) run() error {
 	spotInstanceID, err := lsr.target.onDemandInstance.launchSpotReplacement()
 	if err != nil {
 		log.Printf("Could not launch cheapest spot instance: %s", err)
-		return
+		return err
 	}
 	log.Printf("Successfully launched spot instance %s, exiting...", *spotInstanceID)
+	return nil
+}
+
+// launches the spot replacements of a batch of on-demand instances
+type launchSpotReplacements struct {
+	target            target
+	onDemandInstances []*instance
+}
+
+func (lsr launchSpotReplacements) run() error {
+	var firstErr error
+	for _, onDemandInstance := range lsr.onDemandInstances {
+		spotInstanceID, err := onDemandInstance.launchSpotReplacement()
+		if err != nil {
+			log.Printf("Could not launch cheapest spot instance: %s", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Printf("Successfully launched spot instance %s", *spotInstanceID)
+	}
+	return firstErr
 }
 
 type terminateUnneededSpotInstance struct {
 	target target
 }
 
-func (tusi terminateUnneededSpotInstance) run() {
+func (tusi terminateUnneededSpotInstance) run() error {
 	asg := tusi.target.asg
 	spotInstance := tusi.target.spotInstance
 	spotInstanceID := *spotInstance.InstanceId
 
+	if asg.isActionDisabled(TerminateSpotAction) {
+		return asg.actionDisabledError(TerminateSpotAction)
+	}
+
 	log.Println("Spot instance", spotInstanceID, "is not need anymore by ASG",
 		asg.name, "terminating the spot instance.")
-	spotInstance.terminate()
+	asg.region.annotateTermination(spotInstanceID, asg.name, unneededSpotTermination, "")
+	return spotInstance.terminate()
 }
 
 type swapSpotInstance struct {
 	target target
 }
 
-func (ssi swapSpotInstance) run() {
+func (ssi swapSpotInstance) run() error {
 	asg := ssi.target.asg
 	spotInstanceID := *ssi.target.spotInstance.InstanceId
-	asg.replaceOnDemandInstanceWithSpot(spotInstanceID)
+	return asg.replaceOnDemandInstanceWithSpot(spotInstanceID)
+}
+
+// swaps a batch of spot instances with the on-demand instances they replace
+type swapSpotInstances struct {
+	target        target
+	spotInstances []*instance
+}
+
+func (ssi swapSpotInstances) run() error {
+	return ssi.target.asg.replaceOnDemandInstancesWithSpot(ssi.spotInstances)
 }
 
 type sqsSendMessageOnInstanceLaunch struct {
 	target target
 }
 
-func (ssmoil sqsSendMessageOnInstanceLaunch) run() {
+func (ssmoil sqsSendMessageOnInstanceLaunch) run() error {
 	asg := ssmoil.target.asg
 	onDemandInstanceID := ssmoil.target.onDemandInstance.InstanceId
 	region := ssmoil.target.onDemandInstance.region
 	state := ssmoil.target.onDemandInstance.State.Name
-	region.sqsSendMessageOnInstanceLaunch(&asg.name, onDemandInstanceID, state, "cron-spot-instance-launch")
+	return region.sqsSendMessageOnInstanceLaunch(&asg.name, onDemandInstanceID, state, "cron-spot-instance-launch")
 }
@@ -0,0 +1,164 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// The instance actions of the scaling activities, parsed from their
+// descriptions.
+const (
+	launchActivity    = "launch"
+	terminateActivity = "terminate"
+	attachActivity    = "attach"
+	detachActivity    = "detach"
+)
+
+// activityDescription matches the descriptions of the scaling activities
+// acting on a single instance, such as "Terminating EC2 instance: i-0123".
+var activityDescription = regexp.MustCompile(
+	`^(Launching a new|Terminating|Attaching an existing|Detaching) EC2 instance: (i-[0-9a-z]+)`)
+
+var activityActions = map[string]string{
+	"Launching a new":       launchActivity,
+	"Terminating":           terminateActivity,
+	"Attaching an existing": attachActivity,
+	"Detaching":             detachActivity,
+}
+
+// scalingActivity is a scaling activity of the group, correlated with the
+// actions taken by AutoSpotting during a swap.
+type scalingActivity struct {
+	action      string
+	instanceID  string
+	description string
+	cause       string
+	start       time.Time
+
+	// whether the activity was caused by AutoSpotting acting on one of the
+	// swapped instances
+	byAutoSpotting bool
+
+	// the on-demand instance terminated by AutoSpotting which the group
+	// replaced with the instance it launched
+	replaces string
+}
+
+func (s scalingActivity) String() string {
+	origin := "group"
+	if s.byAutoSpotting {
+		origin = "AutoSpotting"
+	}
+	return fmt.Sprintf("%s %q [%s]", s.start.UTC().Format(time.RFC3339), s.description, origin)
+}
+
+// parseScalingActivity determines the instance action of a scaling activity,
+// if any.
+func parseScalingActivity(a *autoscaling.Activity) scalingActivity {
+	s := scalingActivity{
+		description: aws.StringValue(a.Description),
+		cause:       aws.StringValue(a.Cause),
+		start:       aws.TimeValue(a.StartTime),
+	}
+	if m := activityDescription.FindStringSubmatch(s.description); m != nil {
+		s.action, s.instanceID = activityActions[m[1]], m[2]
+	}
+	return s
+}
+
+// correlateScalingActivities reads the scaling activities of the group
+// started since the beginning of a swap, and correlates them with the actions
+// taken by AutoSpotting on the swapped instances, given as the spot instances
+// replacing each on-demand instance. The instances launched by the group
+// after AutoSpotting terminated an on-demand instance, without its desired
+// capacity being increased, are reported as replacing the terminated
+// instance, which is a sign of misordered operations.
+func (a *autoScalingGroup) correlateScalingActivities(since time.Time, replacements map[string]string) []scalingActivity {
+	var activities []scalingActivity
+	err := a.region.services.autoScaling.DescribeScalingActivitiesPages(
+		&autoscaling.DescribeScalingActivitiesInput{
+			AutoScalingGroupName: aws.String(a.name),
+		},
+		func(page *autoscaling.DescribeScalingActivitiesOutput, lastPage bool) bool {
+			// the activities are returned from the most recent one
+			for _, activity := range page.Activities {
+				if aws.TimeValue(activity.StartTime).Before(since) {
+					return false
+				}
+				activities = append(activities, parseScalingActivity(activity))
+			}
+			return true
+		})
+	if err != nil {
+		log.Println(a.name, "Couldn't describe the scaling activities of the group:", err.Error())
+		return nil
+	}
+
+	correlateActivities(activities, replacements)
+
+	for _, s := range activities {
+		log.Println(a.name, "Scaling activity during the swap:", s)
+		if s.replaces == "" {
+			continue
+		}
+		log.Printf("%s The group launched instance %s to replace on-demand instance %s terminated by AutoSpotting",
+			a.name, s.instanceID, s.replaces)
+		recapText := fmt.Sprintf("%s Group launched instance %s replacing on-demand instance %s [misordered swap]",
+			a.name, s.instanceID, s.replaces)
+		a.region.conf.addRecap(a.region.name, recapText)
+	}
+	return activities
+}
+
+// correlateActivities marks the activities caused by AutoSpotting, and the
+// launches replacing the on-demand instances it terminated.
+func correlateActivities(activities []scalingActivity, replacements map[string]string) {
+	spotInstances := make(map[string]bool, len(replacements))
+	for _, spot := range replacements {
+		spotInstances[spot] = true
+	}
+
+	// the on-demand instances terminated by AutoSpotting, from the most
+	// recent one like the activities
+	var terminated []scalingActivity
+	for i, s := range activities {
+		_, onDemand := replacements[s.instanceID]
+
+		switch {
+		case s.action == attachActivity && spotInstances[s.instanceID],
+			s.action == detachActivity && (onDemand || spotInstances[s.instanceID]),
+			s.action == terminateActivity && (onDemand || spotInstances[s.instanceID]):
+			activities[i].byAutoSpotting = true
+		}
+
+		if s.action == terminateActivity && onDemand {
+			terminated = append(terminated, s)
+		}
+	}
+
+	// each launch replaces the earliest on-demand instance terminated before
+	// it which wasn't already replaced, considering the oldest launches first
+	for i := len(activities) - 1; i >= 0; i-- {
+		s := activities[i]
+		if s.action != launchActivity || spotInstances[s.instanceID] ||
+			strings.Contains(s.cause, "changing the desired capacity") {
+			continue
+		}
+		for j := len(terminated) - 1; j >= 0; j-- {
+			if !s.start.Before(terminated[j].start) {
+				activities[i].replaces = terminated[j].instanceID
+				terminated = append(terminated[:j], terminated[j+1:]...)
+				break
+			}
+		}
+	}
+}
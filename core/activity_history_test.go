@@ -0,0 +1,243 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func Test_parseScalingActivity(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		description    string
+		wantAction     string
+		wantInstanceID string
+	}{
+		{
+			name:           "launch",
+			description:    "Launching a new EC2 instance: i-0123456789abcdef0",
+			wantAction:     launchActivity,
+			wantInstanceID: "i-0123456789abcdef0",
+		},
+		{
+			name:           "termination",
+			description:    "Terminating EC2 instance: i-0a1b",
+			wantAction:     terminateActivity,
+			wantInstanceID: "i-0a1b",
+		},
+		{
+			name:           "attachment",
+			description:    "Attaching an existing EC2 instance: i-0a1b",
+			wantAction:     attachActivity,
+			wantInstanceID: "i-0a1b",
+		},
+		{
+			name:           "detachment",
+			description:    "Detaching EC2 instance: i-0a1b",
+			wantAction:     detachActivity,
+			wantInstanceID: "i-0a1b",
+		},
+		{
+			name:        "failed launch",
+			description: "Launching a new EC2 instance.  Status Reason: Your quota allows for 0 more running instance(s).",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseScalingActivity(&autoscaling.Activity{
+				Description: aws.String(tt.description),
+				StartTime:   aws.Time(start),
+			})
+			if got.action != tt.wantAction || got.instanceID != tt.wantInstanceID {
+				t.Errorf("parseScalingActivity() = %s %s, want %s %s",
+					got.action, got.instanceID, tt.wantAction, tt.wantInstanceID)
+			}
+			if !got.start.Equal(start) || got.description != tt.description {
+				t.Errorf("parseScalingActivity() = %v, unexpected start time or description", got)
+			}
+		})
+	}
+}
+
+func Test_correlateActivities(t *testing.T) {
+	at := func(minutes int) time.Time {
+		return time.Date(2021, 1, 1, 0, minutes, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name               string
+		activities         []scalingActivity
+		replacements       map[string]string
+		wantByAutoSpotting []bool
+		wantReplacements   []string
+	}{
+		{
+			name: "swap without group activities",
+			activities: []scalingActivity{
+				{action: terminateActivity, instanceID: "i-od", start: at(2)},
+				{action: attachActivity, instanceID: "i-spot", start: at(1)},
+			},
+			replacements:       map[string]string{"i-od": "i-spot"},
+			wantByAutoSpotting: []bool{true, true},
+			wantReplacements:   []string{"", ""},
+		},
+		{
+			name: "on-demand instance replaced by the group",
+			activities: []scalingActivity{
+				{action: launchActivity, instanceID: "i-new", start: at(3),
+					cause: "an instance was started in response to a difference between desired and actual capacity, increasing the capacity from 1 to 2."},
+				{action: terminateActivity, instanceID: "i-od", start: at(2)},
+				{action: attachActivity, instanceID: "i-spot", start: at(1)},
+			},
+			replacements:       map[string]string{"i-od": "i-spot"},
+			wantByAutoSpotting: []bool{false, true, true},
+			wantReplacements:   []string{"i-od", "", ""},
+		},
+		{
+			name: "scaling out after the swap",
+			activities: []scalingActivity{
+				{action: launchActivity, instanceID: "i-new", start: at(3),
+					cause: "a user request update of AutoScalingGroup constraints to min: 1, max: 4, desired: 3 changing the desired capacity from 2 to 3."},
+				{action: terminateActivity, instanceID: "i-od", start: at(2)},
+				{action: attachActivity, instanceID: "i-spot", start: at(1)},
+			},
+			replacements:       map[string]string{"i-od": "i-spot"},
+			wantByAutoSpotting: []bool{false, true, true},
+			wantReplacements:   []string{"", "", ""},
+		},
+		{
+			name: "launch before the termination",
+			activities: []scalingActivity{
+				{action: terminateActivity, instanceID: "i-od", start: at(2)},
+				{action: launchActivity, instanceID: "i-new", start: at(1)},
+			},
+			replacements:       map[string]string{"i-od": "i-spot"},
+			wantByAutoSpotting: []bool{true, false},
+			wantReplacements:   []string{"", ""},
+		},
+		{
+			name: "surge replacing several instances",
+			activities: []scalingActivity{
+				{action: launchActivity, instanceID: "i-new2", start: at(6)},
+				{action: launchActivity, instanceID: "i-new1", start: at(5)},
+				{action: terminateActivity, instanceID: "i-od2", start: at(4)},
+				{action: terminateActivity, instanceID: "i-od1", start: at(3)},
+				{action: attachActivity, instanceID: "i-spot2", start: at(2)},
+				{action: attachActivity, instanceID: "i-spot1", start: at(1)},
+			},
+			replacements:       map[string]string{"i-od1": "i-spot1", "i-od2": "i-spot2"},
+			wantByAutoSpotting: []bool{false, false, true, true, true, true},
+			wantReplacements:   []string{"i-od2", "i-od1", "", "", "", ""},
+		},
+		{
+			name: "termination of other instances",
+			activities: []scalingActivity{
+				{action: launchActivity, instanceID: "i-new", start: at(3)},
+				{action: terminateActivity, instanceID: "i-other", start: at(2)},
+			},
+			replacements:       map[string]string{"i-od": "i-spot"},
+			wantByAutoSpotting: []bool{false, false},
+			wantReplacements:   []string{"", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			correlateActivities(tt.activities, tt.replacements)
+
+			var byAutoSpotting []bool
+			var replacements []string
+			for _, s := range tt.activities {
+				byAutoSpotting = append(byAutoSpotting, s.byAutoSpotting)
+				replacements = append(replacements, s.replaces)
+			}
+			if !reflect.DeepEqual(byAutoSpotting, tt.wantByAutoSpotting) {
+				t.Errorf("correlateActivities() by AutoSpotting = %v, want %v", byAutoSpotting, tt.wantByAutoSpotting)
+			}
+			if !reflect.DeepEqual(replacements, tt.wantReplacements) {
+				t.Errorf("correlateActivities() replacements = %v, want %v", replacements, tt.wantReplacements)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_correlateScalingActivities(t *testing.T) {
+	since := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		dsaco          *autoscaling.DescribeScalingActivitiesOutput
+		dsacerr        error
+		wantActivities int
+		wantRecap      []string
+	}{
+		{
+			name:    "failing to describe the activities",
+			dsacerr: errors.New("throttled"),
+		},
+		{
+			name: "ignores the activities started before the swap",
+			dsaco: &autoscaling.DescribeScalingActivitiesOutput{
+				Activities: []*autoscaling.Activity{
+					{
+						Description: aws.String("Terminating EC2 instance: i-od"),
+						StartTime:   aws.Time(since.Add(time.Minute)),
+					},
+					{
+						Description: aws.String("Launching a new EC2 instance: i-old"),
+						StartTime:   aws.Time(since.Add(-time.Minute)),
+					},
+				},
+			},
+			wantActivities: 1,
+		},
+		{
+			name: "reports the misordered swaps",
+			dsaco: &autoscaling.DescribeScalingActivitiesOutput{
+				Activities: []*autoscaling.Activity{
+					{
+						Description: aws.String("Launching a new EC2 instance: i-new"),
+						StartTime:   aws.Time(since.Add(2 * time.Minute)),
+					},
+					{
+						Description: aws.String("Terminating EC2 instance: i-od"),
+						StartTime:   aws.Time(since.Add(time.Minute)),
+					},
+				},
+			},
+			wantActivities: 2,
+			wantRecap:      []string{"asg Group launched instance i-new replacing on-demand instance i-od [misordered swap]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "asg",
+				region: &region{
+					name: "us-east-1",
+					conf: &Config{FinalRecap: map[string][]string{}},
+					services: connections{autoScaling: mockASG{
+						dsaco:   tt.dsaco,
+						dsacerr: tt.dsacerr,
+					}},
+				},
+			}
+
+			got := a.correlateScalingActivities(since, map[string]string{"i-od": "i-spot"})
+			if len(got) != tt.wantActivities {
+				t.Errorf("correlateScalingActivities() returned %d activities, want %d", len(got), tt.wantActivities)
+			}
+			if recap := a.region.conf.FinalRecap["us-east-1"]; !reflect.DeepEqual(recap, tt.wantRecap) {
+				t.Errorf("correlateScalingActivities() recap = %v, want %v", recap, tt.wantRecap)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// isExternalSpotInstance determines if the instance is a spot instance which
+// wasn't launched by AutoSpotting, such as those launched by the mixed
+// instances policy of the group or attached to it manually.
+func (i *instance) isExternalSpotInstance() bool {
+	return i.isSpot() && !i.isLaunchedByAutoSpotting()
+}
+
+// adoptExternalSpotInstances detects the running spot instances of the group
+// which weren't launched by AutoSpotting, and adopts them when enabled, so
+// that they're accounted like the spot instances launched by AutoSpotting.
+func (a *autoScalingGroup) adoptExternalSpotInstances() {
+	var external []*instance
+	for _, i := range a.instances.instances() {
+		if i.isExternalSpotInstance() && i.stateName() == ec2.InstanceStateNameRunning {
+			external = append(external, i)
+		}
+	}
+
+	if len(external) == 0 {
+		return
+	}
+
+	if !a.config.AdoptSpotInstances {
+		ids := make([]string, 0, len(external))
+		for _, i := range external {
+			ids = append(ids, aws.StringValue(i.InstanceId))
+		}
+		log.Printf("%s %s Found %d spot instances not launched by AutoSpotting: %s",
+			a.region.name, a.name, len(external), strings.Join(ids, ", "))
+		return
+	}
+
+	if reason, blocked := a.region.actionsBlocked(); blocked {
+		log.Printf("%s %s Not adopting %d spot instances: %s",
+			a.region.name, a.name, len(external), reason)
+		return
+	}
+
+	for _, i := range external {
+		if err := i.adopt(a); err != nil {
+			log.Printf("%s %s Couldn't adopt spot instance %s: %s",
+				a.region.name, a.name, aws.StringValue(i.InstanceId), err.Error())
+		}
+	}
+}
+
+// adopt tags the spot instance as if it was launched by AutoSpotting for the
+// group, marking it as adopted.
+func (i *instance) adopt(asg *autoScalingGroup) error {
+	tags := []*ec2.Tag{
+		{Key: aws.String(i.tagKey(launchedByAutoSpottingTag)), Value: aws.String("true")},
+		{Key: aws.String(i.tagKey(adoptedByAutoSpottingTag)), Value: aws.String("true")},
+		{Key: aws.String(i.tagKey(tagSchemaVersionTag)), Value: aws.String(currentTagSchemaVersion)},
+	}
+	if i.tagValue(launchedForASGTag) == nil {
+		tags = append(tags, &ec2.Tag{Key: aws.String(i.tagKey(launchedForASGTag)), Value: aws.String(asg.name)})
+	}
+
+	if _, err := i.region.services.ec2.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{i.InstanceId},
+		Tags:      tags,
+	}); err != nil {
+		return err
+	}
+	i.Tags = append(i.Tags, tags...)
+
+	log.Printf("%s %s Adopted spot instance %s", i.region.name, asg.name, aws.StringValue(i.InstanceId))
+	recapText := fmt.Sprintf("%s Adopted spot instance %s", asg.name, aws.StringValue(i.InstanceId))
+	i.region.conf.addRecap(i.region.name, recapText)
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_adoptExternalSpotInstances(t *testing.T) {
+	newInstance := func(id, lifecycle, state string, tags ...*ec2.Tag) *instance {
+		return &instance{Instance: &ec2.Instance{
+			InstanceId:        aws.String(id),
+			InstanceLifecycle: aws.String(lifecycle),
+			State:             &ec2.InstanceState{Name: aws.String(state)},
+			Tags:              tags,
+		}}
+	}
+
+	tests := []struct {
+		name            string
+		adopt           bool
+		budget          *errorBudget
+		cterr           error
+		instances       []*instance
+		expectedTagged  []string
+		expectedAdopted []string
+	}{
+		{
+			name:  "adoption disabled",
+			adopt: false,
+			instances: []*instance{
+				newInstance("i-external", Spot, ec2.InstanceStateNameRunning),
+			},
+		},
+		{
+			name:  "adopts running external spot instances",
+			adopt: true,
+			instances: []*instance{
+				newInstance("i-external", Spot, ec2.InstanceStateNameRunning),
+				newInstance("i-pending", Spot, ec2.InstanceStateNamePending),
+				newInstance("i-ondemand", "", ec2.InstanceStateNameRunning),
+				newInstance("i-launched", Spot, ec2.InstanceStateNameRunning,
+					&ec2.Tag{Key: aws.String(launchedByAutoSpottingTag), Value: aws.String("true")}),
+			},
+			expectedTagged:  []string{"i-external"},
+			expectedAdopted: []string{"i-external"},
+		},
+		{
+			name:   "error budget exhausted",
+			adopt:  true,
+			budget: &errorBudget{exhausted: true},
+			instances: []*instance{
+				newInstance("i-external", Spot, ec2.InstanceStateNameRunning),
+			},
+		},
+		{
+			name:  "tagging error",
+			adopt: true,
+			cterr: errors.New("access denied"),
+			instances: []*instance{
+				newInstance("i-external", Spot, ec2.InstanceStateNameRunning),
+			},
+			expectedTagged: []string{"i-external"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tagged []*ec2.CreateTagsInput
+
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{FinalRecap: map[string][]string{}, errorBudget: tt.budget},
+				services: connections{
+					ec2: mockEC2{ctin: &tagged, cterr: tt.cterr},
+				},
+			}
+			a := &autoScalingGroup{
+				name:      "asg",
+				Group:     &autoscaling.Group{},
+				region:    r,
+				instances: makeInstances(),
+				config:    AutoScalingConfig{AdoptSpotInstances: tt.adopt},
+			}
+			for _, i := range tt.instances {
+				i.region = r
+				a.instances.add(i)
+			}
+
+			a.adoptExternalSpotInstances()
+
+			var taggedIDs []string
+			for _, in := range tagged {
+				taggedIDs = append(taggedIDs, aws.StringValue(in.Resources[0]))
+			}
+			if len(taggedIDs) != len(tt.expectedTagged) {
+				t.Fatalf("tagged %v, expected %v", taggedIDs, tt.expectedTagged)
+			}
+			for n, id := range tt.expectedTagged {
+				if taggedIDs[n] != id {
+					t.Errorf("tagged %v, expected %v", taggedIDs, tt.expectedTagged)
+				}
+			}
+
+			var adopted []string
+			for _, i := range a.instances.instances() {
+				if i.tagValue(adoptedByAutoSpottingTag) != nil {
+					adopted = append(adopted, aws.StringValue(i.InstanceId))
+				}
+			}
+			if len(adopted) != len(tt.expectedAdopted) {
+				t.Fatalf("adopted %v, expected %v", adopted, tt.expectedAdopted)
+			}
+			for _, id := range tt.expectedAdopted {
+				i := a.instances.get(id)
+				if !i.isLaunchedByAutoSpotting() {
+					t.Errorf("adopted instance %s isn't accounted as launched by AutoSpotting", id)
+				}
+				if got := aws.StringValue(i.tagValue(launchedForASGTag)); got != "asg" {
+					t.Errorf("adopted instance %s launched for %q, expected asg", id, got)
+				}
+			}
+			if len(r.conf.FinalRecap["us-east-1"]) != len(tt.expectedAdopted) {
+				t.Errorf("final recap %v, expected %d entries", r.conf.FinalRecap, len(tt.expectedAdopted))
+			}
+		})
+	}
+}
+
+func TestLoadAdoptSpotInstances(t *testing.T) {
+	tests := []struct {
+		name     string
+		asgTags  []*autoscaling.TagDescription
+		global   bool
+		expected bool
+	}{
+		{
+			name:     "no tag, global default",
+			asgTags:  []*autoscaling.TagDescription{},
+			global:   false,
+			expected: false,
+		},
+		{
+			name:     "no tag, globally enabled",
+			asgTags:  []*autoscaling.TagDescription{},
+			global:   true,
+			expected: true,
+		},
+		{
+			name: "disabled by tag",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(AdoptSpotInstancesTag), Value: aws.String("false")},
+			},
+			global:   true,
+			expected: false,
+		},
+		{
+			name: "invalid tag value",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(AdoptSpotInstancesTag), Value: aws.String("foo")},
+			},
+			global:   true,
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.asgTags},
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{AdoptSpotInstances: tt.global},
+				}},
+			}
+			a.loadAdoptSpotInstances()
+			if a.config.AdoptSpotInstances != tt.expected {
+				t.Errorf("loadAdoptSpotInstances() = %v, expected %v",
+					a.config.AdoptSpotInstances, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// hoursPerMonth is the average number of hours in a month, used for
+	// estimating the monthly savings
+	hoursPerMonth = 730
+
+	// maxReportedCandidates is the number of cheapest compatible spot
+	// instance types listed for each group in the analysis report
+	maxReportedCandidates = 3
+)
+
+// groupAnalysis is the outcome of the simulated processing of an AutoScaling
+// group, without making any changes to it.
+type groupAnalysis struct {
+	region string
+	name   string
+
+	// whether the group's tags match the current filtering configuration
+	enabled bool
+
+	onDemandInstances int
+	spotInstances     int
+
+	// the potential savings if all the replaceable on-demand instances were
+	// replaced with the cheapest compatible spot instance types
+	monthlySavings float64
+
+	// the cheapest compatible spot instance types, sorted by price
+	candidates []string
+
+	// the replacements which would be made for the enabled groups
+	plan []string
+
+	// the reasons for which some or all of the on-demand instances can't be
+	// replaced
+	blockers []string
+
+	// the side effects the replacements may have on the group
+	warnings []string
+
+	// the on-demand instances skipped by the last run which processed the
+	// group, counted for each reason
+	skipReasons string
+}
+
+// Analyze scans all the AutoScaling groups from all the enabled regions,
+// regardless of their tags, and writes a report of the potential savings,
+// best spot candidate instance types and the reasons blocking the
+// replacement of the on-demand instances, without making any changes.
+func (a *AutoSpotting) Analyze(w io.Writer) error {
+	a.config.FinalRecap = make(map[string][]string)
+	a.config.addDefaultFilteringMode()
+	a.config.addDefaultFilter()
+
+	allRegions, err := a.getRegions()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var resultsLock sync.Mutex
+	var results []groupAnalysis
+
+	for _, name := range allRegions {
+		r := &region{name: name, conf: a.config}
+		if !r.enabled() {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			analysis := r.analyze()
+			resultsLock.Lock()
+			results = append(results, analysis...)
+			resultsLock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].region != results[j].region {
+			return results[i].region < results[j].region
+		}
+		return results[i].name < results[j].name
+	})
+
+	return writeAnalysisReport(w, results, a.config.reportingCurrency())
+}
+
+// analyze simulates the processing of all the groups from the region.
+func (r *region) analyze() []groupAnalysis {
+	log.Println("Analyzing AutoScaling groups in", r.name)
+
+	r.services.connect(r.name, r.conf)
+	r.setupAsgFilters()
+
+	var groups []*autoscaling.Group
+	err := r.services.autoScaling.DescribeAutoScalingGroupsPages(
+		&autoscaling.DescribeAutoScalingGroupsInput{},
+		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			groups = append(groups, page.AutoScalingGroups...)
+			return true
+		},
+	)
+	if err != nil {
+		log.Println("Failed to describe AutoScalingGroups in", r.name, err.Error())
+		return nil
+	}
+
+	if len(groups) == 0 {
+		return nil
+	}
+
+	// all the groups are considered, so that the instances can be matched to
+	// their groups regardless of the tags
+	for _, group := range groups {
+		r.addEnabledASGs(&autoScalingGroup{
+			Group:  group,
+			name:   aws.StringValue(group.AutoScalingGroupName),
+			region: r,
+		})
+	}
+
+	r.determineInstanceTypeInformation(r.conf)
+
+	if err := r.scanInstances(); err != nil {
+		log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+	}
+
+	var results []groupAnalysis
+	for _, asg := range r.enabledASGList() {
+		results = append(results, asg.analyze())
+	}
+	return results
+}
+
+// analyze determines the potential savings and the blockers for replacing
+// the on-demand instances of the group.
+func (a *autoScalingGroup) analyze() groupAnalysis {
+	optInFilterMode := a.region.conf.TagFilteringMode != "opt-out"
+
+	result := groupAnalysis{
+		region:      a.region.name,
+		name:        a.name,
+		enabled:     optInFilterMode == isASGWithMatchingTags(a.Group, a.region.tagsToFilterASGsBy),
+		skipReasons: a.lastSkipReasons(),
+	}
+
+	if a.MixedInstancesPolicy != nil {
+		if !isOnDemandMixedInstancesPolicy(a.MixedInstancesPolicy) {
+			result.blockers = append(result.blockers, "uses a mixed instances policy")
+			return result
+		}
+		useMixedInstancesPolicyLaunchTemplate(a.Group)
+	}
+
+	a.config = a.region.groupDefaultConfig()
+	a.scanInstances()
+	a.loadDefaultConfig()
+	a.loadConfigFromTags()
+	a.loadLaunchConfiguration()
+	a.loadLaunchTemplate()
+	a.loadScalingPolicies()
+
+	for _, p := range a.sizeSensitivePolicies {
+		if a.requiresSameSize() {
+			result.warnings = append(result.warnings, p+" limits the replacements to the same size")
+			continue
+		}
+		result.warnings = append(result.warnings, p+" may be skewed by differently sized replacements")
+	}
+
+	candidates := map[string]float64{}
+
+	for _, i := range a.instances.instances() {
+		id := aws.StringValue(i.InstanceId)
+
+		if i.isSpot() {
+			result.spotInstances++
+			continue
+		}
+
+		if i.stateName() != ec2.InstanceStateNameRunning {
+			continue
+		}
+		result.onDemandInstances++
+
+		if a.isBlockedByScaleInProtection(i) {
+			result.blockers = append(result.blockers, id+" is protected from scale-in")
+			continue
+		}
+
+		if a.isBlockedByTerminationProtection(i) {
+			result.blockers = append(result.blockers, id+" is protected from termination")
+			continue
+		}
+
+		if !i.isTenancyReplaceable() {
+			result.blockers = append(result.blockers, id+" has dedicated tenancy")
+			continue
+		}
+
+		if a.kubernetesAutoscalerPolicy() == SkipKubernetesAutoscalerPolicy {
+			result.blockers = append(result.blockers, id+" is left to "+a.kubernetesAutoscaler())
+			continue
+		}
+
+		i.price = i.onDemandPriceOf(i.typeInfo) / i.region.onDemandPriceMultiplier() * a.config.OnDemandPriceMultiplier
+		types, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
+			a.getAllowedInstanceTypes(i),
+			a.getDisallowedInstanceTypes(i))
+		if err != nil || len(types) == 0 {
+			result.blockers = append(result.blockers,
+				fmt.Sprintf("%s has no cheaper compatible spot instance type than %s", id, aws.StringValue(i.InstanceType)))
+			continue
+		}
+
+		result.monthlySavings += (i.price - i.calculatePrice(types[0])) * hoursPerMonth
+
+		if result.enabled {
+			result.plan = append(result.plan, fmt.Sprintf("replace %s (%s) with %s",
+				id, aws.StringValue(i.InstanceType), types[0].instanceType))
+		}
+
+		for _, t := range types {
+			price := i.calculatePrice(t)
+			if p, found := candidates[t.instanceType]; !found || price < p {
+				candidates[t.instanceType] = price
+			}
+		}
+	}
+
+	result.candidates = cheapestCandidates(candidates, maxReportedCandidates)
+
+	if a.minOnDemand > 0 && result.onDemandInstances > 0 {
+		result.blockers = append(result.blockers,
+			fmt.Sprintf("configured to keep %d on-demand instances", a.minOnDemand))
+
+		// the on-demand instances kept running aren't replaced
+		keep := int(a.minOnDemand) - (result.onDemandInstances - len(result.plan))
+		if keep > len(result.plan) {
+			keep = len(result.plan)
+		}
+		if keep > 0 {
+			result.plan = result.plan[:len(result.plan)-keep]
+		}
+	}
+
+	return result
+}
+
+// cheapestCandidates returns up to count instance types, sorted ascending by
+// their price.
+func cheapestCandidates(prices map[string]float64, count int) []string {
+	types := make([]string, 0, len(prices))
+	for t := range prices {
+		types = append(types, t)
+	}
+
+	sort.Slice(types, func(i, j int) bool {
+		if prices[types[i]] != prices[types[j]] {
+			return prices[types[i]] < prices[types[j]]
+		}
+		return types[i] < types[j]
+	})
+
+	if len(types) > count {
+		types = types[:count]
+	}
+	return types
+}
+
+func writeAnalysisReport(w io.Writer, results []groupAnalysis, currency reportingCurrency) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "REGION\tGROUP\tENABLED\tON-DEMAND\tSPOT\tMONTHLY SAVINGS (%s)\tCANDIDATES\tPLAN\tBLOCKERS\tWARNINGS\tLAST SKIPPED\n",
+		currency.code)
+
+	var total float64
+	for _, r := range results {
+		total += r.monthlySavings
+
+		blockers := "-"
+		if len(r.blockers) > 0 {
+			blockers = strings.Join(r.blockers, "; ")
+		}
+
+		candidates := "-"
+		if len(r.candidates) > 0 {
+			candidates = strings.Join(r.candidates, ",")
+		}
+
+		plan := "-"
+		if len(r.plan) > 0 {
+			plan = strings.Join(r.plan, "; ")
+		}
+
+		warnings := "-"
+		if len(r.warnings) > 0 {
+			warnings = strings.Join(r.warnings, "; ")
+		}
+
+		skipped := "-"
+		if r.skipReasons != "" {
+			skipped = r.skipReasons
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%d\t%d\t%.2f\t%s\t%s\t%s\t%s\t%s\n",
+			r.region, r.name, r.enabled, r.onDemandInstances, r.spotInstances,
+			currency.convert(r.monthlySavings), candidates, plan, blockers, warnings, skipped)
+	}
+
+	fmt.Fprintf(tw, "\nTotal potential monthly savings: %s\n", currency.format(total, 2))
+	if skipped := skipReasonsReport(results); skipped != "" {
+		fmt.Fprintf(tw, "On-demand instances skipped by the last runs: %s\n", skipped)
+	}
+	return tw.Flush()
+}
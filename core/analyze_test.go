@@ -0,0 +1,336 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_cheapestCandidates(t *testing.T) {
+	tests := []struct {
+		name     string
+		prices   map[string]float64
+		count    int
+		expected []string
+	}{
+		{
+			name:     "no candidates",
+			prices:   map[string]float64{},
+			count:    3,
+			expected: []string{},
+		},
+		{
+			name: "sorted by price then name",
+			prices: map[string]float64{
+				"m5.large":  0.03,
+				"m5a.large": 0.02,
+				"m4.large":  0.03,
+			},
+			count:    3,
+			expected: []string{"m5a.large", "m4.large", "m5.large"},
+		},
+		{
+			name: "truncated",
+			prices: map[string]float64{
+				"m5.large":  0.03,
+				"m5a.large": 0.02,
+				"m4.large":  0.04,
+			},
+			count:    2,
+			expected: []string{"m5a.large", "m5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cheapestCandidates(tt.prices, tt.count); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("cheapestCandidates() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_analyze(t *testing.T) {
+	typeInfo := map[string]instanceTypeInformation{
+		"m5.large": {
+			instanceType:      "m5.large",
+			PhysicalProcessor: "Intel Xeon",
+			vCPU:              2,
+			memory:            8,
+			pricing: prices{
+				onDemand: 0.1,
+				spot:     spotPriceMap{"us-east-1a": 0.04},
+			},
+		},
+		"m5a.large": {
+			instanceType:      "m5a.large",
+			PhysicalProcessor: "Intel Xeon",
+			vCPU:              2,
+			memory:            8,
+			pricing: prices{
+				onDemand: 0.09,
+				spot:     spotPriceMap{"us-east-1a": 0.03},
+			},
+		},
+		"m5.xlarge": {
+			instanceType:      "m5.xlarge",
+			PhysicalProcessor: "Intel Xeon",
+			vCPU:              4,
+			memory:            16,
+			pricing: prices{
+				onDemand: 0.2,
+				spot:     spotPriceMap{"us-east-1a": 0.2},
+			},
+		},
+	}
+
+	newInstance := func(id, lifecycle string) *instance {
+		return &instance{
+			Instance: &ec2.Instance{
+				InstanceId:         aws.String(id),
+				InstanceType:       aws.String("m5.large"),
+				InstanceLifecycle:  aws.String(lifecycle),
+				VirtualizationType: aws.String("hvm"),
+				Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			},
+			typeInfo: typeInfo["m5.large"],
+		}
+	}
+
+	tests := []struct {
+		name             string
+		group            *autoscaling.Group
+		instances        instanceMap
+		diao             *ec2.DescribeInstanceAttributeOutput
+		dpo              *autoscaling.DescribePoliciesOutput
+		expectedOnDemand int
+		expectedSpot     int
+		expectedSavings  float64
+		expectedTypes    []string
+		expectedBlockers []string
+		expectedPlan     []string
+		expectedWarnings []string
+		expectedEnabled  bool
+	}{
+		{
+			name: "mixed instances policy",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("mixed"),
+				MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{},
+			},
+			expectedBlockers: []string{"uses a mixed instances policy"},
+		},
+		{
+			name: "replaceable on-demand instance",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-od")},
+					{InstanceId: aws.String("i-spot")},
+				},
+				Tags: []*autoscaling.TagDescription{
+					{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+				},
+			},
+			instances: instanceMap{
+				"i-od":   newInstance("i-od", ""),
+				"i-spot": newInstance("i-spot", Spot),
+			},
+			diao:             &ec2.DescribeInstanceAttributeOutput{},
+			expectedEnabled:  true,
+			expectedOnDemand: 1,
+			expectedSpot:     1,
+			expectedSavings:  (0.1 - 0.03) * hoursPerMonth,
+			expectedTypes:    []string{"m5a.large", "m5.large"},
+			expectedPlan:     []string{"replace i-od (m5.large) with m5a.large"},
+		},
+		{
+			name: "replaceable on-demand instance of a group not enabled",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-od")},
+				},
+			},
+			instances: instanceMap{
+				"i-od": newInstance("i-od", ""),
+			},
+			diao:             &ec2.DescribeInstanceAttributeOutput{},
+			expectedOnDemand: 1,
+			expectedSavings:  (0.1 - 0.03) * hoursPerMonth,
+			expectedTypes:    []string{"m5a.large", "m5.large"},
+		},
+		{
+			name: "target tracking on CPU utilization",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-od")},
+				},
+			},
+			instances: instanceMap{
+				"i-od": newInstance("i-od", ""),
+			},
+			diao: &ec2.DescribeInstanceAttributeOutput{},
+			dpo: &autoscaling.DescribePoliciesOutput{
+				ScalingPolicies: []*autoscaling.ScalingPolicy{{
+					PolicyName: aws.String("cpu"),
+					PolicyType: aws.String("TargetTrackingScaling"),
+					TargetTrackingConfiguration: &autoscaling.TargetTrackingConfiguration{
+						PredefinedMetricSpecification: &autoscaling.PredefinedMetricSpecification{
+							PredefinedMetricType: aws.String(autoscaling.MetricTypeAsgaverageCpuutilization),
+						},
+					},
+				}},
+			},
+			expectedOnDemand: 1,
+			expectedSavings:  (0.1 - 0.03) * hoursPerMonth,
+			expectedTypes:    []string{"m5a.large", "m5.large"},
+			expectedWarnings: []string{
+				"target tracking policy cpu on ASGAverageCPUUtilization may be skewed by differently sized replacements",
+			},
+		},
+		{
+			name: "protected from scale-in",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-od"), ProtectedFromScaleIn: aws.Bool(true)},
+				},
+			},
+			instances: instanceMap{
+				"i-od": newInstance("i-od", ""),
+			},
+			expectedOnDemand: 1,
+			expectedTypes:    []string{},
+			expectedBlockers: []string{"i-od is protected from scale-in"},
+		},
+		{
+			name: "protected from termination",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-od")},
+				},
+			},
+			instances: instanceMap{
+				"i-od": newInstance("i-od", ""),
+			},
+			diao: &ec2.DescribeInstanceAttributeOutput{
+				DisableApiTermination: &ec2.AttributeBooleanValue{Value: aws.Bool(true)},
+			},
+			expectedOnDemand: 1,
+			expectedTypes:    []string{},
+			expectedBlockers: []string{"i-od is protected from termination"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{
+					TagFilteringMode: "opt-in",
+					AutoScalingConfig: AutoScalingConfig{
+						OnDemandPriceMultiplier: 1,
+					},
+				},
+				instanceTypeInformation: typeInfo,
+				instances:               makeInstancesWithCatalog(tt.instances),
+				tagsToFilterASGsBy:      []Tag{{Key: "spot-enabled", Value: "true"}},
+				services: connections{
+					ec2:         mockEC2{diao: tt.diao},
+					autoScaling: mockASG{dpo: tt.dpo},
+				},
+			}
+			a := &autoScalingGroup{
+				Group:  tt.group,
+				name:   aws.StringValue(tt.group.AutoScalingGroupName),
+				region: r,
+			}
+
+			got := a.analyze()
+
+			if got.enabled != tt.expectedEnabled {
+				t.Errorf("enabled = %v, expected %v", got.enabled, tt.expectedEnabled)
+			}
+			if got.onDemandInstances != tt.expectedOnDemand || got.spotInstances != tt.expectedSpot {
+				t.Errorf("instances = %d/%d, expected %d/%d", got.onDemandInstances,
+					got.spotInstances, tt.expectedOnDemand, tt.expectedSpot)
+			}
+			if math.Abs(got.monthlySavings-tt.expectedSavings) > 0.0001 {
+				t.Errorf("monthlySavings = %f, expected %f", got.monthlySavings, tt.expectedSavings)
+			}
+			if !reflect.DeepEqual(got.candidates, tt.expectedTypes) {
+				t.Errorf("candidates = %v, expected %v", got.candidates, tt.expectedTypes)
+			}
+			if !reflect.DeepEqual(got.blockers, tt.expectedBlockers) {
+				t.Errorf("blockers = %v, expected %v", got.blockers, tt.expectedBlockers)
+			}
+			if !reflect.DeepEqual(got.plan, tt.expectedPlan) {
+				t.Errorf("plan = %v, expected %v", got.plan, tt.expectedPlan)
+			}
+			if !reflect.DeepEqual(got.warnings, tt.expectedWarnings) {
+				t.Errorf("warnings = %v, expected %v", got.warnings, tt.expectedWarnings)
+			}
+		})
+	}
+}
+
+func Test_writeAnalysisReport(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := writeAnalysisReport(&buf, []groupAnalysis{
+		{
+			region:            "us-east-1",
+			name:              "web",
+			enabled:           true,
+			onDemandInstances: 2,
+			monthlySavings:    102.2,
+			candidates:        []string{"m5a.large", "m5.large"},
+			plan:              []string{"replace i-2 (m5.large) with m5a.large"},
+			warnings:          []string{"predictive scaling policy forecast may be skewed by differently sized replacements"},
+		},
+		{
+			region:            "us-east-1",
+			name:              "db",
+			onDemandInstances: 1,
+			blockers:          []string{"i-1 is protected from termination"},
+			skipReasons:       "no-capacity=1 protected-from-termination=1",
+		},
+		{
+			region:      "us-west-2",
+			name:        "batch",
+			skipReasons: "no-capacity=2",
+		},
+	}, usdReportingCurrency)
+	if err != nil {
+		t.Fatalf("writeAnalysisReport() unexpected error: %v", err)
+	}
+
+	report := buf.String()
+	for _, expected := range []string{
+		"us-east-1  web    true",
+		"102.20",
+		"m5a.large,m5.large",
+		"replace i-2 (m5.large) with m5a.large",
+		"i-1 is protected from termination",
+		"predictive scaling policy forecast may be skewed",
+		"MONTHLY SAVINGS (USD)",
+		"Total potential monthly savings: 102.20 USD",
+		"no-capacity=1 protected-from-termination=1",
+		"On-demand instances skipped by the last runs: no-capacity=3 protected-from-termination=1",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("report doesn't contain %q:\n%s", expected, report)
+		}
+	}
+}
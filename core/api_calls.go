@@ -0,0 +1,167 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// apiCallsMetricName is the CloudWatch metric counting the AWS API calls made
+// by each run, by service
+const apiCallsMetricName = "APICalls"
+
+// apiCallBudget counts the AWS API calls made during a run by service,
+// including the retried ones. Once the configured number of calls is
+// exceeded, the remaining mutating actions are only reported but no longer
+// executed, leaving the API rate limits shared with the other automation
+// running in the same accounts to them.
+type apiCallBudget struct {
+	sync.Mutex
+
+	maxCalls int64
+
+	counts    map[string]int64
+	total     int64
+	exhausted bool
+}
+
+func newAPICallBudget(maxCalls int64) *apiCallBudget {
+	return &apiCallBudget{
+		maxCalls: maxCalls,
+		counts:   make(map[string]int64),
+	}
+}
+
+// attach registers the counting handler on the handlers of a session, which
+// are inherited by all the service clients created from it.
+func (b *apiCallBudget) attach(h *request.Handlers) {
+	if b == nil {
+		return
+	}
+	h.Send.PushFront(func(req *request.Request) {
+		b.record(req.ClientInfo.ServiceName)
+	})
+}
+
+// startRun resets the counts at the start of each run.
+func (b *apiCallBudget) startRun() {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.counts = make(map[string]int64)
+	b.total = 0
+	b.exhausted = false
+}
+
+// record accounts for an API call made to the given service.
+func (b *apiCallBudget) record(service string) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.counts[service]++
+	b.total++
+
+	if b.maxCalls > 0 && b.total > b.maxCalls && !b.exhausted {
+		log.Printf("API call budget exhausted after %d calls, the remaining actions "+
+			"will only be reported", b.total)
+		b.exhausted = true
+	}
+}
+
+// isExhausted tells whether the mutating actions should be skipped for the
+// rest of the run.
+func (b *apiCallBudget) isExhausted() bool {
+	if b == nil {
+		return false
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	return b.exhausted
+}
+
+// snapshot returns the counts of the current run by service.
+func (b *apiCallBudget) snapshot() map[string]int64 {
+	if b == nil {
+		return nil
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	counts := make(map[string]int64, len(b.counts))
+	for service, count := range b.counts {
+		counts[service] = count
+	}
+	return counts
+}
+
+func (b *apiCallBudget) String() string {
+	counts := b.snapshot()
+	if len(counts) == 0 {
+		return ""
+	}
+
+	var total int64
+	var summary []string
+	for service, count := range counts {
+		total += count
+		summary = append(summary, fmt.Sprintf("%s=%d", service, count))
+	}
+	sort.Strings(summary)
+	return fmt.Sprintf("%s total=%d", strings.Join(summary, " "), total)
+}
+
+// emitAPICallMetrics records the API calls made by the run in CloudWatch, as
+// the APICalls metric with the service as dimension.
+func (a *AutoSpotting) emitAPICallMetrics() {
+	if a.cloudWatchConn == nil || !a.config.APICallMetrics {
+		return
+	}
+
+	counts := a.config.apiCalls.snapshot()
+	if len(counts) == 0 {
+		return
+	}
+
+	services := make([]string, 0, len(counts))
+	for service := range counts {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	now := a.config.getClock().Now()
+	var data []*cloudwatch.MetricDatum
+	for _, service := range services {
+		data = append(data, &cloudwatch.MetricDatum{
+			MetricName: aws.String(apiCallsMetricName),
+			Dimensions: []*cloudwatch.Dimension{{
+				Name:  aws.String("Service"),
+				Value: aws.String(service),
+			}},
+			Timestamp: aws.Time(now),
+			Unit:      aws.String(cloudwatch.StandardUnitCount),
+			Value:     aws.Float64(float64(counts[service])),
+		})
+	}
+
+	if _, err := a.cloudWatchConn.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(heartbeatNamespace),
+		MetricData: data,
+	}); err != nil {
+		log.Println("Failed to emit the API call metrics:", err.Error())
+	}
+}
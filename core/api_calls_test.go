@@ -0,0 +1,134 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+func Test_apiCallBudget(t *testing.T) {
+	tests := []struct {
+		name              string
+		maxCalls          int64
+		calls             []string
+		expectedExhausted bool
+		expectedSummary   string
+	}{
+		{
+			name:            "unlimited",
+			calls:           []string{"ec2", "ec2", "autoscaling"},
+			expectedSummary: "autoscaling=1 ec2=2 total=3",
+		},
+		{
+			name:            "within the budget",
+			maxCalls:        3,
+			calls:           []string{"ec2", "ec2", "autoscaling"},
+			expectedSummary: "autoscaling=1 ec2=2 total=3",
+		},
+		{
+			name:              "exceeded",
+			maxCalls:          2,
+			calls:             []string{"ec2", "ec2", "autoscaling"},
+			expectedExhausted: true,
+			expectedSummary:   "autoscaling=1 ec2=2 total=3",
+		},
+		{
+			name: "no calls",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newAPICallBudget(tt.maxCalls)
+
+			var h request.Handlers
+			b.attach(&h)
+			for _, service := range tt.calls {
+				h.Send.Run(&request.Request{ClientInfo: metadata.ClientInfo{ServiceName: service}})
+			}
+
+			if got := b.isExhausted(); got != tt.expectedExhausted {
+				t.Errorf("isExhausted() = %v, expected %v", got, tt.expectedExhausted)
+			}
+			if got := b.String(); got != tt.expectedSummary {
+				t.Errorf("String() = %q, expected %q", got, tt.expectedSummary)
+			}
+
+			b.startRun()
+			if b.isExhausted() || b.String() != "" {
+				t.Errorf("startRun() left %q, exhausted %v", b.String(), b.isExhausted())
+			}
+		})
+	}
+
+	// without a budget nothing is counted nor limited
+	var b *apiCallBudget
+	b.attach(&request.Handlers{})
+	b.startRun()
+	if b.isExhausted() || b.String() != "" {
+		t.Errorf("nil budget = %q, exhausted %v", b.String(), b.isExhausted())
+	}
+}
+
+func TestAutoSpotting_emitAPICallMetrics(t *testing.T) {
+	tests := []struct {
+		name             string
+		enabled          bool
+		counts           map[string]int64
+		expectedServices []string
+	}{
+		{
+			name:   "disabled",
+			counts: map[string]int64{"ec2": 3},
+		},
+		{
+			name:    "no calls",
+			enabled: true,
+		},
+		{
+			name:             "by service",
+			enabled:          true,
+			counts:           map[string]int64{"ec2": 3, "autoscaling": 1},
+			expectedServices: []string{"autoscaling", "ec2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			var inputs []*cloudwatch.PutMetricDataInput
+
+			budget := newAPICallBudget(0)
+			for service, count := range tt.counts {
+				for n := int64(0); n < count; n++ {
+					budget.record(service)
+				}
+			}
+
+			a := &AutoSpotting{
+				config:         &Config{APICallMetrics: tt.enabled, apiCalls: budget},
+				cloudWatchConn: mockCloudWatch{calls: &calls, pmdin: &inputs},
+			}
+			a.emitAPICallMetrics()
+
+			var services []string
+			for _, in := range inputs {
+				for _, d := range in.MetricData {
+					service := aws.StringValue(d.Dimensions[0].Value)
+					services = append(services, service)
+					if aws.Float64Value(d.Value) != float64(tt.counts[service]) {
+						t.Errorf("emitAPICallMetrics() %s = %v, expected %d", service, aws.Float64Value(d.Value), tt.counts[service])
+					}
+				}
+			}
+			if !reflect.DeepEqual(services, tt.expectedServices) {
+				t.Errorf("emitAPICallMetrics() services = %v, expected %v", services, tt.expectedServices)
+			}
+		})
+	}
+}
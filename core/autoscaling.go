@@ -4,15 +4,16 @@
 package autospotting
 
 import (
-	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
 )
 
 type autoScalingGroup struct {
@@ -22,9 +23,34 @@ type autoScalingGroup struct {
 	region              *region
 	launchConfiguration *launchConfiguration
 	launchTemplate      *launchTemplate
+	eksNodegroup        *eks.Nodegroup
 	instances           instances
 	minOnDemand         int64
 	config              AutoScalingConfig
+
+	instanceRequirements instanceRequirements
+	licenseConstraints   licenseConstraints
+
+	// the scaling policies sensitive to the size of the instances, loaded
+	// only when needed
+	sizeSensitivePolicies []string
+	scalingPoliciesLoaded bool
+
+	// the termination lifecycle hooks handled by custom handlers, loaded only
+	// when needed
+	terminationHooks       []*autoscaling.LifecycleHook
+	terminationHooksLoaded bool
+
+	// the on-demand instances skipped by the current run
+	skipReasons skipReasons
+
+	// whether the instances and configuration of the group were loaded for
+	// binding it to the instances processed by events
+	loaded bool
+
+	// Serializes the loading of the group, so that the instances processed
+	// concurrently only wait for the group they're bound to.
+	loadLock sync.Mutex
 }
 
 func (a *autoScalingGroup) loadLaunchConfiguration() (*launchConfiguration, error) {
@@ -36,7 +62,7 @@ func (a *autoScalingGroup) loadLaunchConfiguration() (*launchConfiguration, erro
 	lcName := a.LaunchConfigurationName
 
 	if lcName == nil {
-		return nil, errors.New("missing launch configuration")
+		return nil, ErrMissingLaunchConfiguration
 	}
 
 	svc := a.region.services.autoScaling
@@ -66,41 +92,31 @@ func (a *autoScalingGroup) loadLaunchTemplate() (*launchTemplate, error) {
 	lt := a.LaunchTemplate
 
 	if lt == nil {
-		return nil, errors.New("missing launch template")
+		return nil, ErrMissingLaunchTemplate
 	}
 
 	ltID := lt.LaunchTemplateId
 	ltVer := lt.Version
 
 	if ltID == nil || ltVer == nil {
-		return nil, errors.New("missing launch template")
-	}
-
-	svc := a.region.services.ec2
-
-	params := &ec2.DescribeLaunchTemplateVersionsInput{
-		LaunchTemplateId: ltID,
-		Versions:         []*string{ltVer},
+		return nil, ErrMissingLaunchTemplate
 	}
 
-	resp, err := svc.DescribeLaunchTemplateVersions(params)
-
+	ltv, err := a.region.describeLaunchTemplateVersion(ltID, ltVer)
 	if err != nil {
-		log.Println(err.Error())
 		return nil, err
 	}
 
-	if len(resp.LaunchTemplateVersions) == 0 {
-		return nil, errors.New("missing launch template")
+	imageID, err := a.region.resolveImageID(ltv.LaunchTemplateData.ImageId)
+	if err != nil {
+		return nil, err
 	}
 
-	ltv := resp.LaunchTemplateVersions[0]
-
 	params2 := &ec2.DescribeImagesInput{
-		ImageIds: []*string{ltv.LaunchTemplateData.ImageId},
+		ImageIds: []*string{imageID},
 	}
 
-	resp2, err2 := svc.DescribeImages(params2)
+	resp2, err2 := a.region.services.ec2.DescribeImages(params2)
 
 	if err2 != nil {
 		log.Println(err2.Error())
@@ -108,7 +124,7 @@ func (a *autoScalingGroup) loadLaunchTemplate() (*launchTemplate, error) {
 	}
 
 	if len(resp2.Images) == 0 {
-		return nil, errors.New("missing launch template image")
+		return nil, fmt.Errorf("%w image", ErrMissingLaunchTemplate)
 	}
 
 	a.launchTemplate = &launchTemplate{
@@ -150,17 +166,21 @@ func (a *autoScalingGroup) terminateRandomSpotInstanceIfHavingEnough(totalRunnin
 	}
 
 	if allInstancesAreRunning, onDemandRunning := a.allInstancesRunning(); allInstancesAreRunning {
-		if a.instances.count64() == *a.DesiredCapacity && onDemandRunning == a.minOnDemand {
+		if a.instances.count64() == aws.Int64Value(a.DesiredCapacity) && onDemandRunning == a.minOnDemand {
 			log.Println("Currently Spot running equals to the required number, skipping termination")
 			return nil
 		}
 
-		if a.instances.count64() < *a.DesiredCapacity {
+		if a.instances.count64() < aws.Int64Value(a.DesiredCapacity) {
 			log.Println("Not enough capacity in the group")
 			return nil
 		}
 	}
 
+	if a.isActionDisabled(TerminateSpotAction) {
+		return a.actionDisabledError(TerminateSpotAction)
+	}
+
 	randomSpot := a.getAnySpotInstance()
 	if randomSpot == nil {
 		log.Println("Couldn't pick a random spot instance")
@@ -168,7 +188,9 @@ func (a *autoScalingGroup) terminateRandomSpotInstanceIfHavingEnough(totalRunnin
 	}
 
 	log.Println("Terminating randomly-selected spot instance",
-		*randomSpot.Instance.InstanceId)
+		aws.StringValue(randomSpot.Instance.InstanceId))
+
+	a.region.annotateTermination(aws.StringValue(randomSpot.Instance.InstanceId), a.name, unneededSpotTermination, "")
 
 	var isTerminated error
 	switch a.config.TerminationMethod {
@@ -180,8 +202,8 @@ func (a *autoScalingGroup) terminateRandomSpotInstanceIfHavingEnough(totalRunnin
 
 	if isTerminated == nil {
 		// add to FinalRecap
-		recapText := fmt.Sprintf("%s Terminated random spot instance %s [too few onDemands]", a.name, *randomSpot.Instance.InstanceId)
-		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+		recapText := fmt.Sprintf("%s Terminated random spot instance %s [too few onDemands]", a.name, aws.StringValue(randomSpot.Instance.InstanceId))
+		a.region.conf.addRecap(a.region.name, recapText)
 	}
 
 	return isTerminated
@@ -192,17 +214,32 @@ func (a *autoScalingGroup) allInstancesRunning() (bool, int64) {
 	return totalRunning == a.instances.count64(), onDemandRunning
 }
 
-func (a *autoScalingGroup) cronEventAction() runer {
+// loadConfiguration scans the instances of the group and loads its
+// configuration, marking it as loaded so that the instances processed by
+// events during the run bind to it without resetting its configuration.
+func (a *autoScalingGroup) loadConfiguration() {
+	a.loadLock.Lock()
+	defer a.loadLock.Unlock()
 
 	a.scanInstances()
 	a.loadDefaultConfig()
 	a.loadConfigFromTags()
+	a.loaded = true
+}
+
+func (a *autoScalingGroup) cronEventAction() runer {
+
+	a.loadConfiguration()
+	a.reconcileMaxSize()
+	a.recordProtectedInstances()
+	a.adoptExternalSpotInstances()
+	a.reportSpotCoverage()
 
 	log.Println("Finding spot instances created for", a.name)
 
 	spotInstance := a.findUnattachedInstanceLaunchedForThisASG()
 
-	shouldRun := cronRunAction(time.Now(), a.config.CronSchedule, a.config.CronTimezone, a.config.CronScheduleState)
+	shouldRun := cronRunAction(a.region.conf.getClock().Now(), a.config.CronSchedule, a.config.CronTimezone, a.config.CronScheduleState)
 	debug.Println(a.region.name, a.name, "Should take replacement actions:", shouldRun)
 
 	if !shouldRun {
@@ -211,6 +248,12 @@ func (a *autoScalingGroup) cronEventAction() runer {
 		return skipRun{reason: "outside-cron-schedule"}
 	}
 
+	if a.surgeEnabled() {
+		if need, _ := a.needReplaceOnDemandInstances(); need {
+			return a.surgeEventAction()
+		}
+	}
+
 	if spotInstance == nil {
 		log.Println("No spot instances were found for ", a.name)
 
@@ -228,6 +271,14 @@ func (a *autoScalingGroup) cronEventAction() runer {
 			return skipRun{reason: "no-instances-to-replace"}
 		}
 
+		if allowed, reason := a.eksDisruptionAllowed(onDemandInstance.InstanceId); !allowed {
+			return skipRun{reason: reason}
+		}
+
+		if !a.kubernetesAutoscalerAllowsReplacement(onDemandInstance) {
+			return skipRun{reason: "kubernetes-autoscaler-" + a.kubernetesAutoscalerPolicy()}
+		}
+
 		a.loadLaunchConfiguration()
 		a.loadLaunchTemplate()
 
@@ -243,13 +294,13 @@ func (a *autoScalingGroup) cronEventAction() runer {
 		}
 	}
 
-	spotInstanceID := *spotInstance.InstanceId
+	spotInstanceID := aws.StringValue(spotInstance.InstanceId)
 	log.Println("Found unattached spot instance", spotInstanceID)
 
 	if need, total := a.needReplaceOnDemandInstances(); !need || !shouldRun {
 		// add to FinalRecap
 		recapText := fmt.Sprintf("%s Terminated spot instance %s [not needed]", a.name, spotInstanceID)
-		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+		a.region.conf.addRecap(a.region.name, recapText)
 		return terminateUnneededSpotInstance{
 			target{
 				asg:            a,
@@ -278,32 +329,32 @@ func (a *autoScalingGroup) scanInstances() instances {
 	log.Println("Adding instances to", a.name)
 	a.instances = makeInstances()
 	for _, inst := range a.Instances {
-		i := a.region.instances.get(*inst.InstanceId)
+		i := a.region.instances.get(aws.StringValue(inst.InstanceId))
 
 		if i == nil {
-			debug.Println("Missing instance data for ", *inst.InstanceId, "scanning it again")
+			debug.Println("Missing instance data for ", aws.StringValue(inst.InstanceId), "scanning it again")
 			a.region.scanInstance(inst.InstanceId)
 
-			i = a.region.instances.get(*inst.InstanceId)
+			i = a.region.instances.get(aws.StringValue(inst.InstanceId))
 			if i == nil {
-				debug.Println("Failed to scan instance", *inst.InstanceId)
+				debug.Println("Failed to scan instance", aws.StringValue(inst.InstanceId))
 				continue
 			}
 		}
 
 		i.asg, i.region = a, a.region
 		if inst.ProtectedFromScaleIn != nil {
-			i.protected = i.protected || *inst.ProtectedFromScaleIn
+			i.protected = i.protected || aws.BoolValue(inst.ProtectedFromScaleIn)
 		}
 
 		if i.isSpot() {
-			i.price = i.typeInfo.pricing.spot[*i.Placement.AvailabilityZone]
+			i.price = i.spotPriceOf(i.typeInfo)
 		} else {
-			i.price = i.typeInfo.pricing.onDemand + i.typeInfo.pricing.premium
+			i.price = i.onDemandPriceOf(i.typeInfo) + i.premiumOf(i.typeInfo)
 		}
 
 		// Avoid adding instance in Terminating (Wait|Proceed) Lifecycle State
-		if strings.HasPrefix(*inst.LifecycleState, "Terminating") {
+		if strings.HasPrefix(aws.StringValue(inst.LifecycleState), "Terminating") {
 			continue
 		}
 
@@ -320,19 +371,19 @@ func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(spotInstanceID string
 	log.Println(a.name, "Retrieving instance details for ", spotInstanceID)
 	spotInst := a.region.instances.get(spotInstanceID)
 	if spotInst == nil {
-		return errors.New("couldn't find spot instance to use")
+		return fmt.Errorf("couldn't find spot instance to use: %w", ErrInstanceNotFound)
 	}
 
 	if len(a.region.conf.SQSQueueURL) == 0 {
 		if odInstance, err = spotInst.swapWithGroupMember(a); err != nil {
 			log.Printf("%s, couldn't perform spot replacement of %s ",
-				a.region.name, *spotInst.InstanceId)
+				a.region.name, aws.StringValue(spotInst.InstanceId))
 			return err
 		}
 		// add to FinalRecap
 		recapText := fmt.Sprintf("%s OnDemand instance %s replaced with spot instance %s",
-			a.name, *odInstance.InstanceId, *spotInst.InstanceId)
-		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+			a.name, aws.StringValue(odInstance.InstanceId), aws.StringValue(spotInst.InstanceId))
+		a.region.conf.addRecap(a.region.name, recapText)
 
 	} else {
 
@@ -340,8 +391,8 @@ func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(spotInstanceID string
 			return err
 		}
 		// add to FinalRecap
-		recapText := fmt.Sprintf("%s Sent spot instance %s event message to SQSQueue", a.name, *spotInst.InstanceId)
-		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+		recapText := fmt.Sprintf("%s Sent spot instance %s event message to SQSQueue", a.name, aws.StringValue(spotInst.InstanceId))
+		a.region.conf.addRecap(a.region.name, recapText)
 	}
 	return nil
 }
@@ -349,45 +400,68 @@ func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(spotInstanceID string
 // Returns the information about the first running instance found in
 // the group, while iterating over all instances from the
 // group. It can also filter by AZ and Lifecycle.
+// isBlockedByTerminationProtection tells whether the termination protection of
+// the instance prevents its replacement within this group.
+func (a *autoScalingGroup) isBlockedByTerminationProtection(i *instance) bool {
+	protT, err := i.isProtectedFromTermination()
+	if err != nil {
+		debug.Println(a.name, "failed to determine termination protection for", aws.StringValue(i.InstanceId))
+	}
+	return protT && !a.config.CopyTerminationProtection
+}
+
 func (a *autoScalingGroup) getInstance(
 	availabilityZone *string,
 	onDemand bool,
 	considerInstanceProtection bool,
 ) *instance {
 
-	for i := range a.instances.instances() {
+	for _, i := range a.instances.instances() {
+		if a.isInstanceMatching(i, availabilityZone, onDemand, considerInstanceProtection) {
+			return i
+		}
+	}
+	return nil
+}
 
-		// instance is running
-		if *i.State.Name == ec2.InstanceStateNameRunning {
+// isInstanceMatching determines if the instance is running and matches the
+// lifecycle, protection and AZ filters of the getInstance lookups.
+func (a *autoScalingGroup) isInstanceMatching(
+	i *instance,
+	availabilityZone *string,
+	onDemand bool,
+	considerInstanceProtection bool,
+) bool {
 
-			// the InstanceLifecycle attribute is non-nil only for spot instances,
-			// where it contains the value "spot", if we're looking for on-demand
-			// instances only, then we have to skip the current instance.
-			if (onDemand && i.isSpot()) || (!onDemand && !i.isSpot()) {
-				debug.Println(a.name, "skipping instance", *i.InstanceId,
-					"having different lifecycle than what we're looking for")
-				continue
-			}
+	// instance is running
+	if i.stateName() != ec2.InstanceStateNameRunning {
+		return false
+	}
 
-			protT, err := i.isProtectedFromTermination()
-			if err != nil {
-				debug.Println(a.name, "failed to determine termination protection for", *i.InstanceId)
-			}
+	// the InstanceLifecycle attribute is non-nil only for spot instances,
+	// where it contains the value "spot", if we're looking for on-demand
+	// instances only, then we have to skip the current instance.
+	if (onDemand && i.isSpot()) || (!onDemand && !i.isSpot()) {
+		debug.Println(a.name, "skipping instance", aws.StringValue(i.InstanceId),
+			"having different lifecycle than what we're looking for")
+		return false
+	}
 
-			if considerInstanceProtection && (i.isProtectedFromScaleIn() || protT) {
-				debug.Println(a.name, "skipping protected instance", *i.InstanceId)
-				continue
-			}
+	if considerInstanceProtection && (a.isBlockedByScaleInProtection(i) || a.isBlockedByTerminationProtection(i)) {
+		debug.Println(a.name, "skipping protected instance", aws.StringValue(i.InstanceId))
+		return false
+	}
 
-			if (availabilityZone != nil) && (*availabilityZone != *i.Placement.AvailabilityZone) {
-				debug.Println(a.name, "skipping instance", *i.InstanceId,
-					"placed in a different AZ than what we're looking for")
-				continue
-			}
-			return i
-		}
+	if onDemand && !i.isTenancyReplaceable() {
+		return false
 	}
-	return nil
+
+	if (availabilityZone != nil) && (*availabilityZone != i.availabilityZone()) {
+		debug.Println(a.name, "skipping instance", aws.StringValue(i.InstanceId),
+			"placed in a different AZ than what we're looking for")
+		return false
+	}
+	return true
 }
 
 func (a *autoScalingGroup) getAnyUnprotectedOnDemandInstance() *instance {
@@ -400,7 +474,7 @@ func (a *autoScalingGroup) getAnySpotInstance() *instance {
 
 func (a *autoScalingGroup) hasMemberInstance(inst *instance) bool {
 	for _, member := range a.Instances {
-		if *member.InstanceId == *inst.InstanceId {
+		if aws.StringValue(member.InstanceId) == aws.StringValue(inst.InstanceId) {
 			return true
 		}
 	}
@@ -428,7 +502,7 @@ func (a *autoScalingGroup) waitForInstanceStatus(instanceID *string, status stri
 			autoScalingInstances := result.AutoScalingInstances
 
 			if len(autoScalingInstances) > 0 {
-				if instanceStatus := *autoScalingInstances[0].LifecycleState; instanceStatus != status {
+				if instanceStatus := aws.StringValue(autoScalingInstances[0].LifecycleState); instanceStatus != status {
 					log.Printf("Waiting for instance %s to be in status %s [%s]",
 						*instanceID, status, instanceStatus)
 				} else {
@@ -444,21 +518,18 @@ func (a *autoScalingGroup) waitForInstanceStatus(instanceID *string, status stri
 			if sleepTime <= 0 {
 				sleepTime = 1
 			}
-			time.Sleep(time.Duration(sleepTime) * time.Second)
+			a.region.conf.getClock().Sleep(time.Duration(sleepTime) * time.Second)
 		}
 	}
 
-	return errors.New("")
+	return fmt.Errorf("timed out waiting for instance %s to be in status %s",
+		aws.StringValue(instanceID), status)
 }
 
 func (a *autoScalingGroup) findUnattachedInstanceLaunchedForThisASG() *instance {
-	for inst := range a.region.instances.instances() {
-		for _, tag := range inst.Tags {
-			if *tag.Key == "launched-for-asg" && *tag.Value == a.name {
-				if !a.hasMemberInstance(inst) {
-					return inst
-				}
-			}
+	for _, inst := range a.region.instances.instances() {
+		if aws.StringValue(inst.tagValue(launchedForASGTag)) == a.name && !a.hasMemberInstance(inst) {
+			return inst
 		}
 	}
 	return nil
@@ -472,13 +543,16 @@ func (a *autoScalingGroup) getAllowedInstanceTypes(baseInstance *instance) []str
 
 	// Check option of allowed instance types
 	// If we have that option we don't need to calculate the compatible instance type.
-	if tagValue := a.getTagValue(AllowedInstanceTypesTag); tagValue != nil {
+	if tagValue := a.getOwnTagValue(AllowedInstanceTypesTag); tagValue != nil {
 		allowedInstanceTypesTag = strings.Replace(*tagValue, " ", ",", -1)
 	}
 
-	// ASG Tag config has a priority to override
+	// ASG Tag config has a priority to override, followed by the instance
+	// types the group itself would launch from its mixed instances policy
 	if allowedInstanceTypesTag != "" {
 		allowed = allowedInstanceTypesTag
+	} else if overrides := a.launchTemplateOverrides(); len(overrides) > 0 {
+		allowed = strings.Join(overrides, ",")
 	}
 
 	if allowed == "current" {
@@ -499,7 +573,7 @@ func (a *autoScalingGroup) getDisallowedInstanceTypes(baseInstance *instance) []
 
 	// Check option of disallowed instance types
 	// If we have that option we don't need to calculate the compatible instance type.
-	if tagValue := a.getTagValue(DisallowedInstanceTypesTag); tagValue != nil {
+	if tagValue := a.getOwnTagValue(DisallowedInstanceTypesTag); tagValue != nil {
 		disallowedInstanceTypesTag = strings.Replace(*tagValue, " ", ",", -1)
 	}
 
@@ -609,9 +683,13 @@ func (a *autoScalingGroup) detachAndTerminateOnDemandInstance(
 	}
 
 	// Wait till detachment initialize is complete before terminate instance
-	time.Sleep(20 * time.Second * a.region.conf.SleepMultiplier)
+	a.region.conf.getClock().Sleep(20 * time.Second * a.region.conf.SleepMultiplier)
 
-	return a.region.instances.get(*instanceID).terminate()
+	inst := a.region.instances.get(aws.StringValue(instanceID))
+	if inst == nil {
+		return fmt.Errorf("couldn't find instance %s: %w", aws.StringValue(instanceID), ErrInstanceNotFound)
+	}
+	return inst.terminate()
 }
 
 // Terminates an instance from the group using the
@@ -654,6 +732,10 @@ func (a *autoScalingGroup) terminateInstanceInAutoScalingGroup(
 	}
 
 	for _, hook := range resDLH.LifecycleHooks {
+		// the custom termination hooks are left to their handlers
+		if isCustomTerminationHook(hook) {
+			continue
+		}
 		asSvc.CompleteLifecycleAction(
 			&autoscaling.CompleteLifecycleActionInput{
 				AutoScalingGroupName:  a.AutoScalingGroupName,
@@ -675,7 +757,7 @@ func (a *autoScalingGroup) terminateInstanceInAutoScalingGroup(
 	}
 
 	if resTIIASG != nil && resTIIASG.Activity != nil && resTIIASG.Activity.Description != nil {
-		log.Println(*resTIIASG.Activity.Description)
+		log.Println(aws.StringValue(resTIIASG.Activity.Description))
 	}
 
 	return nil
@@ -692,12 +774,12 @@ func (a *autoScalingGroup) alreadyRunningInstanceCount(
 		instanceCategory = OnDemand
 	}
 	log.Println(a.name, "Counting already running", instanceCategory, "instances")
-	for inst := range a.instances.instances() {
+	for _, inst := range a.instances.instances() {
 
-		if *inst.Instance.State.Name == "running" {
+		if inst.stateName() == "running" {
 			// Count total running instances
 			total++
-			if availabilityZone == nil || *inst.Placement.AvailabilityZone == *availabilityZone {
+			if availabilityZone == nil || inst.availabilityZone() == *availabilityZone {
 				if (spot && inst.isSpot()) || (!spot && !inst.isSpot()) {
 					count++
 				}
@@ -7,6 +7,9 @@ import (
 	"log"
 	"math"
 	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
 )
 
 const (
@@ -38,6 +41,14 @@ const (
 	// current spot price to place the bid
 	SpotPriceBufferPercentageTag = "autospotting_spot_price_buffer_percentage"
 
+	// SpotMaxPriceTag stores the fixed maximum price bid for the spot
+	// instances when using the fixed bidding policy
+	SpotMaxPriceTag = "autospotting_spot_max_price"
+
+	// SpotPriceOnDemandPercentageTag stores the percentage of the on-demand
+	// price bid for the spot instances when using the percentage bidding policy
+	SpotPriceOnDemandPercentageTag = "autospotting_spot_price_on_demand_percentage"
+
 	// AllowedInstanceTypesTag is the name of a tag that can indicate which
 	// instance types are allowed in the current group
 	AllowedInstanceTypesTag = "autospotting_allowed_instance_types"
@@ -68,6 +79,20 @@ const (
 	// the spot bid on a per-group level
 	DefaultBiddingPolicy = "normal"
 
+	// AggressiveBiddingPolicy bids a percentage above the current spot price,
+	// capped at the on-demand price
+	AggressiveBiddingPolicy = "aggressive"
+
+	// FixedBiddingPolicy bids a fixed maximum price
+	FixedBiddingPolicy = "fixed"
+
+	// PercentageBiddingPolicy bids a percentage of the on-demand price
+	PercentageBiddingPolicy = "percentage"
+
+	// DefaultSpotPriceOnDemandPercentage stores the default percentage of the
+	// on-demand price bid when using the percentage bidding policy
+	DefaultSpotPriceOnDemandPercentage = 100.0
+
 	// DefaultOnDemandPriceMultiplier stores the default OnDemand price multiplier
 	// on a per-group level
 	DefaultOnDemandPriceMultiplier = 1.0
@@ -106,6 +131,119 @@ const (
 	// GP2ConversionThresholdTag is the name of the tag set on the AutoScaling Group that
 	// can override the global value of the GP2ConversionThreshold parameter
 	GP2ConversionThresholdTag = "autospotting_gp2_conversion_threshold"
+
+	// EBSVolumeConversionsTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the EBSVolumeConversions parameter
+	EBSVolumeConversionsTag = "autospotting_ebs_volume_conversions"
+
+	// AllowDedicatedTenancyTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the AllowDedicatedTenancy parameter
+	AllowDedicatedTenancyTag = "autospotting_allow_dedicated_tenancy"
+
+	// CopyTerminationProtectionTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the CopyTerminationProtection parameter
+	CopyTerminationProtectionTag = "autospotting_copy_termination_protection"
+
+	// ScaleInProtectionTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the ScaleInProtection parameter
+	ScaleInProtectionTag = "autospotting_scale_in_protection"
+
+	// KubernetesAutoscalerPolicyTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// KubernetesAutoscalerPolicy parameter
+	KubernetesAutoscalerPolicyTag = "autospotting_kubernetes_autoscaler_policy"
+
+	// NodeTerminationHandlerQueueTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// NodeTerminationHandlerQueue parameter
+	NodeTerminationHandlerQueueTag = "autospotting_node_termination_handler_queue"
+
+	// SkipTerminationProtectionCheckTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the SkipTerminationProtectionCheck parameter
+	SkipTerminationProtectionCheckTag = "autospotting_skip_termination_protection_check"
+
+	// InstanceStoreCompatibilityTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the InstanceStoreCompatibility parameter
+	InstanceStoreCompatibilityTag = "autospotting_instance_store_compatibility"
+
+	// DiversificationTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the Diversification parameter
+	DiversificationTag = "autospotting_diversification"
+
+	// DisabledActionsTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the DisabledActions parameter
+	DisabledActionsTag = "autospotting_disabled_actions"
+
+	// MaxSizeAlternativeTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the MaxSizeAlternative parameter
+	MaxSizeAlternativeTag = "autospotting_max_size_alternative"
+
+	// SameSizeForScalingPoliciesTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the SameSizeForScalingPolicies parameter
+	SameSizeForScalingPoliciesTag = "autospotting_same_size_for_scaling_policies"
+
+	// CPUVendorTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the CPUVendor parameter
+	CPUVendorTag = "autospotting_cpu_vendor"
+
+	// SpotInterruptionBehaviorTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the SpotInterruptionBehavior parameter
+	SpotInterruptionBehaviorTag = "autospotting_spot_interruption_behavior"
+
+	// GracePeriodTag is the name of the tag set on the AutoScaling Group that
+	// overrides its HealthCheckGracePeriod as the number of seconds the spot
+	// instances need to be running for before being attached to the group
+	GracePeriodTag = "autospotting_grace_period"
+
+	// RootVolumeSizeTag is the name of the tag set on the AutoScaling Group
+	// that sets the minimum size in GiB of the root volumes of the spot
+	// instances
+	RootVolumeSizeTag = "autospotting_root_volume_size"
+
+	// RootVolumeIOPSTag is the name of the tag set on the AutoScaling Group
+	// that sets the provisioned IOPS of the root volumes of the spot instances
+	RootVolumeIOPSTag = "autospotting_root_volume_iops"
+
+	// ReadinessChecksTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the ReadinessChecks parameter
+	ReadinessChecksTag = "autospotting_readiness_checks"
+
+	// SwapStrategyTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the SwapStrategy parameter
+	SwapStrategyTag = "autospotting_swap_strategy"
+
+	// SurgeTag is the name of the tag set on the AutoScaling Group that can
+	// override the global value of the Surge parameter
+	SurgeTag = "autospotting_surge"
+
+	// AdoptSpotInstancesTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the AdoptSpotInstances parameter
+	AdoptSpotInstancesTag = "autospotting_adopt_spot_instances"
+
+	// DrainTimeoutTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the DrainTimeout parameter
+	DrainTimeoutTag = "autospotting_drain_timeout"
+
+	// LifecycleHookTimeoutTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the LifecycleHookTimeout parameter
+	LifecycleHookTimeoutTag = "autospotting_lifecycle_hook_timeout"
+
+	// SnapshotBeforeTerminateTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the SnapshotBeforeTerminate parameter
+	SnapshotBeforeTerminateTag = "autospotting_snapshot_before_terminate"
+
+	// RelaxedInstanceStoreCompatibility only requires the spot instances to have
+	// enough instance store volumes for the block device mappings
+	RelaxedInstanceStoreCompatibility = "relaxed"
+
+	// DefaultInstanceStoreCompatibility also requires the spot instances to have
+	// at least the same total instance store capacity, without downgrading from
+	// SSD to HDD or from NVMe to SATA
+	DefaultInstanceStoreCompatibility = "default"
+
+	// StrictInstanceStoreCompatibility also requires the spot instances to have
+	// volumes at least as large and of the same NVMe or SATA interface
+	StrictInstanceStoreCompatibility = "strict"
 )
 
 // AutoScalingConfig stores some group-specific configurations that can override
@@ -124,6 +262,13 @@ type AutoScalingConfig struct {
 
 	BiddingPolicy string
 
+	// The maximum price bid when using the fixed bidding policy
+	SpotMaxPrice float64
+
+	// The percentage of the on-demand price bid when using the percentage
+	// bidding policy
+	SpotPriceOnDemandPercentage float64
+
 	TerminationMethod string
 
 	// Instance termination method
@@ -140,6 +285,99 @@ type AutoScalingConfig struct {
 
 	// Threshold for converting EBS volumes from GP2 to GP3, since after a certain size GP2 may be more performant than GP3.
 	GP2ConversionThreshold int64
+
+	// Comma-separated list of the EBS volume type conversions, such as
+	// io1:io2, replacing the default conversions driven by the
+	// GP2ConversionThreshold.
+	EBSVolumeConversions string
+
+	// Allows replacing instances running on dedicated instances, by launching
+	// spot instances with the same tenancy.
+	AllowDedicatedTenancy bool
+
+	// Allows replacing on-demand instances protected from termination, by
+	// enabling the termination protection on their spot replacements.
+	CopyTerminationProtection bool
+
+	// How the spot instances are protected from scale-in once attached to
+	// the group, mirroring either the group or the replaced instance.
+	ScaleInProtection string
+
+	// How the groups managed by Kubernetes autoscalers such as the Cluster
+	// Autoscaler or Karpenter are handled.
+	KubernetesAutoscalerPolicy string
+
+	// Treats all the instances as unprotected from termination, avoiding the
+	// API calls for determining their termination protection.
+	SkipTerminationProtectionCheck bool
+
+	// How strictly the instance store volumes of the spot instance types are
+	// compared with the ones of the replaced instances.
+	InstanceStoreCompatibility string
+
+	// Number of the cheapest compatible spot instance types across which the
+	// spot instances of the group are spread.
+	Diversification int64
+
+	// Comma-separated list of the mutating actions which aren't performed on
+	// the group, such as terminating its on-demand instances.
+	DisabledActions string
+
+	// How the spot instances are attached to groups running at their maximum
+	// capacity when the modify_max_size action is disabled.
+	MaxSizeAlternative string
+
+	// Restricts the replacements to instance types of the same size for the
+	// groups using scaling policies sensitive to the size of the instances.
+	SameSizeForScalingPolicies bool
+
+	// The CPU vendor the replacements are pinned to, instead of the default
+	// interchangeable Intel and AMD instance types.
+	CPUVendor string
+
+	// What happens to the spot instances when they're interrupted: terminate,
+	// stop or hibernate.
+	SpotInterruptionBehavior string
+
+	// The number of seconds the spot instances need to be running for before
+	// being attached to the group, overriding its HealthCheckGracePeriod.
+	GracePeriod *int64
+
+	// The minimum size in GiB of the root volumes of the spot instances,
+	// growing the ones configured by the launch configuration or template.
+	RootVolumeSize int64
+
+	// The provisioned IOPS of the root volumes of the spot instances, when
+	// their volume type supports it.
+	RootVolumeIOPS int64
+
+	// Comma-separated list of active checks determining when the spot
+	// instances are ready to be attached, instead of the grace period.
+	ReadinessChecks string
+
+	// The order in which the spot instances are attached and the on-demand
+	// instances they replace are terminated.
+	SwapStrategy string
+
+	// The number of on-demand instances replaced at once, by temporarily
+	// raising the capacity of the group with their spot replacements.
+	Surge int64
+
+	// The longest time the replaced on-demand instances are drained by the
+	// load balancers of the group for, before being terminated.
+	DrainTimeout time.Duration
+
+	// The longest time the swaps wait for the custom termination lifecycle
+	// hooks of the replaced on-demand instances to be completed.
+	LifecycleHookTimeout time.Duration
+
+	// Adopts the spot instances of the group which weren't launched by
+	// AutoSpotting, accounting them like the ones it launched.
+	AdoptSpotInstances bool
+
+	// Snapshots the EBS volumes of the replaced on-demand instances before
+	// terminating them, keeping them running when the snapshots fail.
+	SnapshotBeforeTerminate bool
 }
 
 func (a *autoScalingGroup) loadPercentageOnDemand(tagValue *string) (int64, bool) {
@@ -204,6 +442,16 @@ func (a *autoScalingGroup) loadOnDemandPriceMultiplier(tagValue *string) (float6
 	return onDemandPriceMultiplier, true
 }
 
+// getOwnTagValue returns the value of one of the AutoSpotting tags of the
+// group, whose key is namespaced by the configured tag key prefix.
+func (a *autoScalingGroup) getOwnTagValue(keyMatch string) *string {
+	if a.region != nil {
+		keyMatch = a.region.conf.tagKey(keyMatch)
+	}
+	return a.getTagValue(keyMatch)
+}
+
+// getTagValue returns the value of the group tag with the given key, as is.
 func (a *autoScalingGroup) getTagValue(keyMatch string) *string {
 	for _, asgTag := range a.Tags {
 		if *asgTag.Key == keyMatch {
@@ -229,7 +477,7 @@ func (a *autoScalingGroup) loadConfOnDemand() bool {
 
 	foundLimit := false
 	for _, tagKey := range tagList {
-		if tagValue := a.getTagValue(tagKey); tagValue != nil {
+		if tagValue := a.getOwnTagValue(tagKey); tagValue != nil {
 			if _, ok := loadDyn[tagKey]; ok {
 				if newValue, done := loadDyn[tagKey](tagValue); done {
 					foundLimit = a.setMinOnDemandIfLarger(newValue, foundLimit)
@@ -242,7 +490,7 @@ func (a *autoScalingGroup) loadConfOnDemand() bool {
 }
 
 func (a *autoScalingGroup) loadPatchBeanstalkUserdata() {
-	tagValue := a.getTagValue(PatchBeanstalkUserdataTag)
+	tagValue := a.getOwnTagValue(PatchBeanstalkUserdataTag)
 
 	if tagValue != nil {
 		log.Printf("Loaded PatchBeanstalkUserdata value %v from tag %v\n", *tagValue, PatchBeanstalkUserdataTag)
@@ -258,7 +506,7 @@ func (a *autoScalingGroup) loadGP2ConversionThreshold() {
 	// setting the default value
 	a.config.GP2ConversionThreshold = a.region.conf.GP2ConversionThreshold
 
-	tagValue := a.getTagValue(GP2ConversionThresholdTag)
+	tagValue := a.getOwnTagValue(GP2ConversionThresholdTag)
 	if tagValue == nil {
 		log.Printf("Couldn't load the GP2ConversionThreshold from tag %v, using the globally configured value of %v\n", GP2ConversionThresholdTag, a.config.GP2ConversionThreshold)
 		return
@@ -277,9 +525,459 @@ func (a *autoScalingGroup) loadGP2ConversionThreshold() {
 
 }
 
+func (a *autoScalingGroup) loadEBSVolumeConversions() {
+	// setting the default value
+	a.config.EBSVolumeConversions = a.region.conf.EBSVolumeConversions
+
+	tagValue := a.getOwnTagValue(EBSVolumeConversionsTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", EBSVolumeConversionsTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	if _, err := parseEBSVolumeConversions(*tagValue); err != nil {
+		log.Printf("Ignoring invalid EBSVolumeConversions value %v from tag %v: %s\n", *tagValue, EBSVolumeConversionsTag, err.Error())
+		return
+	}
+
+	log.Printf("Loaded EBSVolumeConversions value %v from tag %v\n", *tagValue, EBSVolumeConversionsTag)
+	a.config.EBSVolumeConversions = *tagValue
+}
+
+func (a *autoScalingGroup) loadDiversification() {
+	// setting the default value
+	a.config.Diversification = a.region.conf.Diversification
+
+	tagValue := a.getOwnTagValue(DiversificationTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", DiversificationTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	diversification, err := strconv.ParseInt(*tagValue, 10, 64)
+	if err != nil {
+		log.Printf("Error parsing %v as integer: %s\n", *tagValue, err.Error())
+		return
+	} else if diversification < 1 {
+		log.Printf("Ignoring out of range Diversification value %v from tag %v\n", diversification, DiversificationTag)
+		return
+	}
+
+	log.Printf("Loaded Diversification value %v from tag %v\n", diversification, DiversificationTag)
+	a.config.Diversification = diversification
+}
+
+func (a *autoScalingGroup) loadAllowDedicatedTenancy() {
+	// setting the default value
+	a.config.AllowDedicatedTenancy = a.region.conf.AllowDedicatedTenancy
+
+	tagValue := a.getOwnTagValue(AllowDedicatedTenancyTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", AllowDedicatedTenancyTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	allow, err := strconv.ParseBool(*tagValue)
+	if err != nil {
+		log.Printf("Error parsing %v as boolean: %s\n", *tagValue, err.Error())
+		return
+	}
+
+	log.Printf("Loaded AllowDedicatedTenancy value %v from tag %v\n", allow, AllowDedicatedTenancyTag)
+	a.config.AllowDedicatedTenancy = allow
+}
+
+func (a *autoScalingGroup) loadCopyTerminationProtection() {
+	// setting the default value
+	a.config.CopyTerminationProtection = a.region.conf.CopyTerminationProtection
+
+	tagValue := a.getOwnTagValue(CopyTerminationProtectionTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", CopyTerminationProtectionTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	copyProtection, err := strconv.ParseBool(*tagValue)
+	if err != nil {
+		log.Printf("Error parsing %v as boolean: %s\n", *tagValue, err.Error())
+		return
+	}
+
+	log.Printf("Loaded CopyTerminationProtection value %v from tag %v\n", copyProtection, CopyTerminationProtectionTag)
+	a.config.CopyTerminationProtection = copyProtection
+}
+
+func (a *autoScalingGroup) loadScaleInProtection() {
+	// setting the default value
+	a.config.ScaleInProtection = a.region.conf.ScaleInProtection
+
+	tagValue := a.getOwnTagValue(ScaleInProtectionTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", ScaleInProtectionTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	if !isValidScaleInProtection(*tagValue) {
+		log.Printf("Ignoring invalid ScaleInProtection value %v from tag %v\n", *tagValue, ScaleInProtectionTag)
+		return
+	}
+
+	log.Printf("Loaded ScaleInProtection value %v from tag %v\n", *tagValue, ScaleInProtectionTag)
+	a.config.ScaleInProtection = *tagValue
+}
+
+func (a *autoScalingGroup) loadKubernetesAutoscalerPolicy() {
+	// setting the default value
+	a.config.KubernetesAutoscalerPolicy = a.region.conf.KubernetesAutoscalerPolicy
+
+	tagValue := a.getOwnTagValue(KubernetesAutoscalerPolicyTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", KubernetesAutoscalerPolicyTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	if !isValidKubernetesAutoscalerPolicy(*tagValue) {
+		log.Printf("Ignoring invalid KubernetesAutoscalerPolicy value %v from tag %v\n", *tagValue, KubernetesAutoscalerPolicyTag)
+		return
+	}
+
+	log.Printf("Loaded KubernetesAutoscalerPolicy value %v from tag %v\n", *tagValue, KubernetesAutoscalerPolicyTag)
+	a.config.KubernetesAutoscalerPolicy = *tagValue
+}
+
+func (a *autoScalingGroup) loadSkipTerminationProtectionCheck() {
+	// setting the default value
+	a.config.SkipTerminationProtectionCheck = a.region.conf.SkipTerminationProtectionCheck
+
+	tagValue := a.getOwnTagValue(SkipTerminationProtectionCheckTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", SkipTerminationProtectionCheckTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	skip, err := strconv.ParseBool(*tagValue)
+	if err != nil {
+		log.Printf("Error parsing %v as boolean: %s\n", *tagValue, err.Error())
+		return
+	}
+
+	log.Printf("Loaded SkipTerminationProtectionCheck value %v from tag %v\n", skip, SkipTerminationProtectionCheckTag)
+	a.config.SkipTerminationProtectionCheck = skip
+}
+
+func (a *autoScalingGroup) loadInstanceStoreCompatibility() {
+	// setting the default value
+	a.config.InstanceStoreCompatibility = a.region.conf.InstanceStoreCompatibility
+
+	tagValue := a.getOwnTagValue(InstanceStoreCompatibilityTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", InstanceStoreCompatibilityTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	switch *tagValue {
+	case RelaxedInstanceStoreCompatibility, DefaultInstanceStoreCompatibility, StrictInstanceStoreCompatibility:
+		log.Printf("Loaded InstanceStoreCompatibility value %v from tag %v\n", *tagValue, InstanceStoreCompatibilityTag)
+		a.config.InstanceStoreCompatibility = *tagValue
+	default:
+		log.Printf("Ignoring invalid InstanceStoreCompatibility value %v from tag %v\n", *tagValue, InstanceStoreCompatibilityTag)
+	}
+}
+
+func (a *autoScalingGroup) loadDisabledActions() {
+	// setting the default value
+	a.config.DisabledActions = a.region.conf.DisabledActions
+
+	tagValue := a.getOwnTagValue(DisabledActionsTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", DisabledActionsTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	if _, err := parseDisabledActions(*tagValue); err != nil {
+		log.Printf("Ignoring invalid DisabledActions value %v from tag %v: %s\n", *tagValue, DisabledActionsTag, err.Error())
+		return
+	}
+
+	log.Printf("Loaded DisabledActions value %v from tag %v\n", *tagValue, DisabledActionsTag)
+	a.config.DisabledActions = *tagValue
+}
+
+func (a *autoScalingGroup) loadMaxSizeAlternative() {
+	// setting the default value
+	a.config.MaxSizeAlternative = a.region.conf.MaxSizeAlternative
+
+	tagValue := a.getOwnTagValue(MaxSizeAlternativeTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MaxSizeAlternativeTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	switch *tagValue {
+	case StandbyMaxSizeAlternative, DetachMaxSizeAlternative:
+		log.Printf("Loaded MaxSizeAlternative value %v from tag %v\n", *tagValue, MaxSizeAlternativeTag)
+		a.config.MaxSizeAlternative = *tagValue
+	default:
+		log.Printf("Ignoring invalid MaxSizeAlternative value %v from tag %v\n", *tagValue, MaxSizeAlternativeTag)
+	}
+}
+
+func (a *autoScalingGroup) loadSameSizeForScalingPolicies() {
+	// setting the default value
+	a.config.SameSizeForScalingPolicies = a.region.conf.SameSizeForScalingPolicies
+
+	tagValue := a.getOwnTagValue(SameSizeForScalingPoliciesTag)
+	if tagValue != nil {
+		sameSize, err := strconv.ParseBool(*tagValue)
+		if err != nil {
+			log.Printf("Error parsing %v as boolean: %s\n", *tagValue, err.Error())
+		} else {
+			log.Printf("Loaded SameSizeForScalingPolicies value %v from tag %v\n", sameSize, SameSizeForScalingPoliciesTag)
+			a.config.SameSizeForScalingPolicies = sameSize
+		}
+	}
+
+	if a.config.SameSizeForScalingPolicies {
+		a.loadScalingPolicies()
+	}
+}
+
+func (a *autoScalingGroup) loadCPUVendor() {
+	// setting the default value
+	a.config.CPUVendor = a.region.conf.CPUVendor
+
+	tagValue := a.getOwnTagValue(CPUVendorTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", CPUVendorTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	switch *tagValue {
+	case AnyCPUVendor, SameCPUVendor, IntelCPUVendor, AMDCPUVendor:
+		log.Printf("Loaded CPUVendor value %v from tag %v\n", *tagValue, CPUVendorTag)
+		a.config.CPUVendor = *tagValue
+	default:
+		log.Printf("Ignoring invalid CPUVendor value %v from tag %v\n", *tagValue, CPUVendorTag)
+	}
+}
+
+func (a *autoScalingGroup) loadSpotInterruptionBehavior() {
+	// setting the default value
+	a.config.SpotInterruptionBehavior = a.region.conf.SpotInterruptionBehavior
+
+	tagValue := a.getOwnTagValue(SpotInterruptionBehaviorTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", SpotInterruptionBehaviorTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	if !isValidInterruptionBehavior(*tagValue) {
+		log.Printf("Ignoring invalid SpotInterruptionBehavior value %v from tag %v\n", *tagValue, SpotInterruptionBehaviorTag)
+		return
+	}
+
+	log.Printf("Loaded SpotInterruptionBehavior value %v from tag %v\n", *tagValue, SpotInterruptionBehaviorTag)
+	a.config.SpotInterruptionBehavior = *tagValue
+}
+
+func (a *autoScalingGroup) loadGracePeriod() {
+	a.config.GracePeriod = nil
+
+	tagValue := a.getOwnTagValue(GracePeriodTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", GracePeriodTag, "on the group", a.name, "using its health check grace period")
+		return
+	}
+
+	gracePeriod, err := strconv.ParseInt(*tagValue, 10, 64)
+	if err != nil || gracePeriod < 0 {
+		log.Printf("Ignoring invalid GracePeriod value %v from tag %v\n", *tagValue, GracePeriodTag)
+		return
+	}
+
+	log.Printf("Loaded GracePeriod value %v from tag %v\n", gracePeriod, GracePeriodTag)
+	a.config.GracePeriod = aws.Int64(gracePeriod)
+}
+
+func (a *autoScalingGroup) loadRootVolumeSize() {
+	a.config.RootVolumeSize = a.loadRootVolumeSetting(RootVolumeSizeTag)
+}
+
+func (a *autoScalingGroup) loadRootVolumeIOPS() {
+	a.config.RootVolumeIOPS = a.loadRootVolumeSetting(RootVolumeIOPSTag)
+}
+
+// loadRootVolumeSetting parses the positive integer set by the tag, or 0 when
+// the tag is missing or invalid.
+func (a *autoScalingGroup) loadRootVolumeSetting(tag string) int64 {
+	tagValue := a.getOwnTagValue(tag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", tag, "on the group", a.name, "keeping the root volume unchanged")
+		return 0
+	}
+
+	value, err := parsePositiveInt(*tagValue)
+	if err != nil {
+		log.Printf("Ignoring invalid value %v from tag %v\n", *tagValue, tag)
+		return 0
+	}
+
+	log.Printf("Loaded value %v from tag %v\n", value, tag)
+	return value
+}
+
+func (a *autoScalingGroup) loadReadinessChecks() {
+	// setting the default value
+	a.config.ReadinessChecks = a.region.conf.ReadinessChecks
+
+	tagValue := a.getOwnTagValue(ReadinessChecksTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", ReadinessChecksTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	if _, err := parseReadinessChecks(*tagValue); err != nil {
+		log.Printf("Ignoring invalid ReadinessChecks value %v from tag %v: %s\n", *tagValue, ReadinessChecksTag, err.Error())
+		return
+	}
+
+	log.Printf("Loaded ReadinessChecks value %v from tag %v\n", *tagValue, ReadinessChecksTag)
+	a.config.ReadinessChecks = *tagValue
+}
+
+func (a *autoScalingGroup) loadSwapStrategy() {
+	// setting the default value
+	a.config.SwapStrategy = a.region.conf.SwapStrategy
+
+	tagValue := a.getOwnTagValue(SwapStrategyTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", SwapStrategyTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	if !isValidSwapStrategy(*tagValue) {
+		log.Printf("Ignoring invalid SwapStrategy value %v from tag %v\n", *tagValue, SwapStrategyTag)
+		return
+	}
+
+	log.Printf("Loaded SwapStrategy value %v from tag %v\n", *tagValue, SwapStrategyTag)
+	a.config.SwapStrategy = *tagValue
+}
+
+func (a *autoScalingGroup) loadSurge() {
+	// setting the default value
+	a.config.Surge = a.region.conf.Surge
+
+	tagValue := a.getOwnTagValue(SurgeTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", SurgeTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	surge, err := strconv.ParseInt(*tagValue, 10, 64)
+	if err != nil || surge < 0 {
+		log.Printf("Ignoring invalid Surge value %v from tag %v\n", *tagValue, SurgeTag)
+		return
+	}
+
+	log.Printf("Loaded Surge value %v from tag %v\n", surge, SurgeTag)
+	a.config.Surge = surge
+}
+
+func (a *autoScalingGroup) loadDrainTimeout() {
+	// setting the default value
+	a.config.DrainTimeout = a.region.conf.DrainTimeout
+
+	tagValue := a.getOwnTagValue(DrainTimeoutTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", DrainTimeoutTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	timeout, err := time.ParseDuration(*tagValue)
+	if err != nil || timeout < 0 {
+		log.Printf("Ignoring invalid DrainTimeout value %v from tag %v\n", *tagValue, DrainTimeoutTag)
+		return
+	}
+
+	log.Printf("Loaded DrainTimeout value %v from tag %v\n", timeout, DrainTimeoutTag)
+	a.config.DrainTimeout = timeout
+}
+
+func (a *autoScalingGroup) loadLifecycleHookTimeout() {
+	// setting the default value
+	a.config.LifecycleHookTimeout = a.region.conf.LifecycleHookTimeout
+
+	tagValue := a.getOwnTagValue(LifecycleHookTimeoutTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", LifecycleHookTimeoutTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	timeout, err := time.ParseDuration(*tagValue)
+	if err != nil || timeout < 0 {
+		log.Printf("Ignoring invalid LifecycleHookTimeout value %v from tag %v\n", *tagValue, LifecycleHookTimeoutTag)
+		return
+	}
+
+	log.Printf("Loaded LifecycleHookTimeout value %v from tag %v\n", timeout, LifecycleHookTimeoutTag)
+	a.config.LifecycleHookTimeout = timeout
+}
+
+func (a *autoScalingGroup) loadAdoptSpotInstances() {
+	// setting the default value
+	a.config.AdoptSpotInstances = a.region.conf.AdoptSpotInstances
+
+	tagValue := a.getOwnTagValue(AdoptSpotInstancesTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", AdoptSpotInstancesTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	adopt, err := strconv.ParseBool(*tagValue)
+	if err != nil {
+		log.Printf("Error parsing %v as boolean: %s\n", *tagValue, err.Error())
+		return
+	}
+
+	log.Printf("Loaded AdoptSpotInstances value %v from tag %v\n", adopt, AdoptSpotInstancesTag)
+	a.config.AdoptSpotInstances = adopt
+}
+
+func (a *autoScalingGroup) loadSnapshotBeforeTerminate() {
+	// setting the default value
+	a.config.SnapshotBeforeTerminate = a.region.conf.SnapshotBeforeTerminate
+
+	tagValue := a.getOwnTagValue(SnapshotBeforeTerminateTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", SnapshotBeforeTerminateTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	snapshot, err := strconv.ParseBool(*tagValue)
+	if err != nil {
+		log.Printf("Error parsing %v as boolean: %s\n", *tagValue, err.Error())
+		return
+	}
+
+	log.Printf("Loaded SnapshotBeforeTerminate value %v from tag %v\n", snapshot, SnapshotBeforeTerminateTag)
+	a.config.SnapshotBeforeTerminate = snapshot
+}
+
+// readinessGracePeriod returns the number of seconds the spot instances need
+// to be running for before being attached to the group.
+func (a *autoScalingGroup) readinessGracePeriod() int64 {
+	if a.config.GracePeriod != nil {
+		return *a.config.GracePeriod
+	}
+	return aws.Int64Value(a.HealthCheckGracePeriod)
+}
+
 func (a *autoScalingGroup) loadBiddingPolicy(tagValue *string) (string, bool) {
 	biddingPolicy := *tagValue
-	if biddingPolicy != "aggressive" {
+	switch biddingPolicy {
+	case DefaultBiddingPolicy, AggressiveBiddingPolicy, FixedBiddingPolicy, PercentageBiddingPolicy:
+	default:
 		return DefaultBiddingPolicy, false
 	}
 
@@ -287,8 +985,24 @@ func (a *autoScalingGroup) loadBiddingPolicy(tagValue *string) (string, bool) {
 	return biddingPolicy, true
 }
 
+// loadPositiveFloat parses the value of a tag holding a positive number.
+func (a *autoScalingGroup) loadPositiveFloat(tagValue *string, tag string) (float64, bool) {
+	value, err := strconv.ParseFloat(*tagValue, 64)
+
+	if err != nil {
+		log.Printf("Error with ParseFloat: %s\n", err.Error())
+		return 0, false
+	} else if value <= 0 {
+		log.Printf("Ignoring out of range value : %f\n", value)
+		return 0, false
+	}
+
+	log.Printf("Loaded value %f from tag %s\n", value, tag)
+	return value, true
+}
+
 func (a *autoScalingGroup) LoadCronSchedule() {
-	tagValue := a.getTagValue(ScheduleTag)
+	tagValue := a.getOwnTagValue(ScheduleTag)
 
 	if tagValue != nil {
 		log.Printf("Loaded CronSchedule value %v from tag %v\n", *tagValue, ScheduleTag)
@@ -301,7 +1015,7 @@ func (a *autoScalingGroup) LoadCronSchedule() {
 }
 
 func (a *autoScalingGroup) LoadCronTimezone() {
-	tagValue := a.getTagValue(TimezoneTag)
+	tagValue := a.getOwnTagValue(TimezoneTag)
 
 	if tagValue != nil {
 		log.Printf("Loaded CronTimezone value %v from tag %v\n", *tagValue, TimezoneTag)
@@ -314,7 +1028,7 @@ func (a *autoScalingGroup) LoadCronTimezone() {
 }
 
 func (a *autoScalingGroup) LoadCronScheduleState() {
-	tagValue := a.getTagValue(CronScheduleStateTag)
+	tagValue := a.getOwnTagValue(CronScheduleStateTag)
 	if tagValue != nil {
 		log.Printf("Loaded CronScheduleState value %v from tag %v\n", *tagValue, CronScheduleStateTag)
 		a.config.CronScheduleState = *tagValue
@@ -326,22 +1040,48 @@ func (a *autoScalingGroup) LoadCronScheduleState() {
 }
 
 func (a *autoScalingGroup) loadConfSpot() bool {
-	tagValue := a.getTagValue(BiddingPolicyTag)
+	tagValue := a.getOwnTagValue(BiddingPolicyTag)
 	if tagValue == nil {
 		debug.Println("Couldn't find tag", BiddingPolicyTag)
 		return false
 	}
 	if newValue, done := a.loadBiddingPolicy(tagValue); done {
-		a.region.conf.BiddingPolicy = newValue
-		debug.Println("BiddingPolicy =", a.region.conf.BiddingPolicy)
+		a.config.BiddingPolicy = newValue
+		debug.Println("BiddingPolicy =", a.config.BiddingPolicy)
 		return done
 	}
 	return false
 }
 
+func (a *autoScalingGroup) loadConfSpotMaxPrice() bool {
+	tagValue := a.getOwnTagValue(SpotMaxPriceTag)
+	if tagValue == nil {
+		return false
+	}
+
+	newValue, done := a.loadPositiveFloat(tagValue, SpotMaxPriceTag)
+	if done {
+		a.config.SpotMaxPrice = newValue
+	}
+	return done
+}
+
+func (a *autoScalingGroup) loadConfSpotPriceOnDemandPercentage() bool {
+	tagValue := a.getOwnTagValue(SpotPriceOnDemandPercentageTag)
+	if tagValue == nil {
+		return false
+	}
+
+	newValue, done := a.loadPositiveFloat(tagValue, SpotPriceOnDemandPercentageTag)
+	if done {
+		a.config.SpotPriceOnDemandPercentage = newValue
+	}
+	return done
+}
+
 func (a *autoScalingGroup) loadConfSpotPrice() bool {
 
-	tagValue := a.getTagValue(SpotPriceBufferPercentageTag)
+	tagValue := a.getOwnTagValue(SpotPriceBufferPercentageTag)
 	if tagValue == nil {
 		return false
 	}
@@ -352,13 +1092,13 @@ func (a *autoScalingGroup) loadConfSpotPrice() bool {
 		return false
 	}
 
-	a.region.conf.SpotPriceBufferPercentage = newValue
+	a.config.SpotPriceBufferPercentage = newValue
 	return done
 }
 
 func (a *autoScalingGroup) loadConfOnDemandPriceMultiplier() bool {
 
-	tagValue := a.getTagValue(OnDemandPriceMultiplierTag)
+	tagValue := a.getOwnTagValue(OnDemandPriceMultiplierTag)
 	if tagValue == nil {
 		return false
 	}
@@ -384,11 +1124,40 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 
 	resSpotPriceConf := a.loadConfSpotPrice()
 
+	resSpotMaxPriceConf := a.loadConfSpotMaxPrice()
+
+	resSpotPriceOnDemandPercentageConf := a.loadConfSpotPriceOnDemandPercentage()
+
 	a.LoadCronSchedule()
 	a.LoadCronTimezone()
 	a.LoadCronScheduleState()
 	a.loadPatchBeanstalkUserdata()
 	a.loadGP2ConversionThreshold()
+	a.loadEBSVolumeConversions()
+	a.loadAllowDedicatedTenancy()
+	a.loadCopyTerminationProtection()
+	a.loadScaleInProtection()
+	a.loadKubernetesAutoscalerPolicy()
+	a.loadSkipTerminationProtectionCheck()
+	a.loadInstanceRequirements()
+	a.loadLicenseConstraints()
+	a.loadInstanceStoreCompatibility()
+	a.loadDiversification()
+	a.loadDisabledActions()
+	a.loadMaxSizeAlternative()
+	a.loadSameSizeForScalingPolicies()
+	a.loadCPUVendor()
+	a.loadSpotInterruptionBehavior()
+	a.loadGracePeriod()
+	a.loadRootVolumeSize()
+	a.loadRootVolumeIOPS()
+	a.loadReadinessChecks()
+	a.loadSwapStrategy()
+	a.loadSurge()
+	a.loadDrainTimeout()
+	a.loadLifecycleHookTimeout()
+	a.loadAdoptSpotInstances()
+	a.loadSnapshotBeforeTerminate()
 
 	if resOnDemandConf {
 		log.Println("Found and applied configuration for OnDemand value")
@@ -402,7 +1171,14 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 	if resSpotPriceConf {
 		log.Println("Found and applied configuration for Spot Price")
 	}
-	if resOnDemandConf || resOnDemandPriceMultiplierConf || resSpotConf || resSpotPriceConf {
+	if resSpotMaxPriceConf {
+		log.Println("Found and applied configuration for Spot Max Price")
+	}
+	if resSpotPriceOnDemandPercentageConf {
+		log.Println("Found and applied configuration for Spot Price On-Demand Percentage")
+	}
+	if resOnDemandConf || resOnDemandPriceMultiplierConf || resSpotConf || resSpotPriceConf ||
+		resSpotMaxPriceConf || resSpotPriceOnDemandPercentageConf {
 		return true
 	}
 	return false
@@ -434,8 +1210,12 @@ func (a *autoScalingGroup) loadDefaultConfig() bool {
 	done := false
 	a.minOnDemand = DefaultMinOnDemandValue
 
-	if a.region.conf.SpotPriceBufferPercentage <= 0 {
-		a.region.conf.SpotPriceBufferPercentage = DefaultSpotPriceBufferPercentage
+	if a.config.SpotPriceBufferPercentage <= 0 {
+		a.config.SpotPriceBufferPercentage = DefaultSpotPriceBufferPercentage
+	}
+
+	if a.config.SpotPriceOnDemandPercentage <= 0 {
+		a.config.SpotPriceOnDemandPercentage = DefaultSpotPriceOnDemandPercentage
 	}
 
 	if a.region.conf.MinOnDemandNumber != 0 {
@@ -488,6 +488,14 @@ func TestLoadBiddingPolicy(t *testing.T) {
 			tagValue:      aws.String("normal"),
 			valueExpected: "normal",
 		},
+		{name: "Loading a fixed policy tag",
+			tagValue:      aws.String("fixed"),
+			valueExpected: "fixed",
+		},
+		{name: "Loading a percentage policy tag",
+			tagValue:      aws.String("percentage"),
+			valueExpected: "percentage",
+		},
 		{name: "Loading a fake tag",
 			tagValue:      aws.String("autospotting"),
 			valueExpected: "normal",
@@ -546,6 +554,16 @@ func TestLoadConfSpot(t *testing.T) {
 					Value: aws.String("normal"),
 				},
 			},
+			loadingExpected: true,
+			valueExpected:   "normal",
+		},
+		{name: "Loading an invalid tag",
+			asgTags: []*autoscaling.TagDescription{
+				{
+					Key:   aws.String(BiddingPolicyTag),
+					Value: aws.String("autospotting"),
+				},
+			},
 			loadingExpected: false,
 			valueExpected:   "normal",
 		},
@@ -560,13 +578,16 @@ func TestLoadConfSpot(t *testing.T) {
 				name: "us-east-1",
 				conf: cfg,
 			},
+			config: cfg.AutoScalingConfig,
 		}
 		a.Tags = tt.asgTags
 		done := a.loadConfSpot()
 		if tt.loadingExpected != done {
 			t.Errorf("LoadSpotConf retured: %t expected %t", done, tt.loadingExpected)
-		} else if tt.valueExpected != a.region.conf.BiddingPolicy {
-			t.Errorf("LoadSpotConf loaded: %s expected %s", a.region.conf.BiddingPolicy, tt.valueExpected)
+		} else if tt.valueExpected != a.config.BiddingPolicy {
+			t.Errorf("LoadSpotConf loaded: %s expected %s", a.config.BiddingPolicy, tt.valueExpected)
+		} else if a.region.conf.BiddingPolicy != "normal" {
+			t.Errorf("LoadSpotConf changed the global policy to %s", a.region.conf.BiddingPolicy)
 		}
 
 	}
@@ -629,18 +650,69 @@ func TestLoadConfSpotPrice(t *testing.T) {
 				name: "us-east-1",
 				conf: cfg,
 			},
+			config: cfg.AutoScalingConfig,
 		}
 		a.Tags = tt.asgTags
 		done := a.loadConfSpotPrice()
 		if tt.loadingExpected != done {
 			t.Errorf("LoadSpotConf retured: %t expected %t", done, tt.loadingExpected)
-		} else if tt.valueExpected != a.region.conf.SpotPriceBufferPercentage {
-			t.Errorf("LoadSpotConf loaded: %f expected %f", a.region.conf.SpotPriceBufferPercentage, tt.valueExpected)
+		} else if tt.valueExpected != a.config.SpotPriceBufferPercentage {
+			t.Errorf("LoadSpotConf loaded: %f expected %f", a.config.SpotPriceBufferPercentage, tt.valueExpected)
 		}
 
 	}
 }
 
+func TestLoadConfSpotBidPrices(t *testing.T) {
+	tests := []struct {
+		name               string
+		asgTags            []*autoscaling.TagDescription
+		expectedMaxPrice   float64
+		expectedPercentage float64
+	}{
+		{name: "No tags",
+			expectedMaxPrice:   0.5,
+			expectedPercentage: 100,
+		},
+		{name: "Valid tags",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(SpotMaxPriceTag), Value: aws.String("0.2")},
+				{Key: aws.String(SpotPriceOnDemandPercentageTag), Value: aws.String("80")},
+			},
+			expectedMaxPrice:   0.2,
+			expectedPercentage: 80,
+		},
+		{name: "Invalid tags",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(SpotMaxPriceTag), Value: aws.String("-1")},
+				{Key: aws.String(SpotPriceOnDemandPercentageTag), Value: aws.String("text")},
+			},
+			expectedMaxPrice:   0.5,
+			expectedPercentage: 100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := autoScalingGroup{Group: &autoscaling.Group{Tags: tt.asgTags},
+				config: AutoScalingConfig{
+					SpotMaxPrice:                0.5,
+					SpotPriceOnDemandPercentage: 100,
+				},
+			}
+			a.loadConfSpotMaxPrice()
+			a.loadConfSpotPriceOnDemandPercentage()
+
+			if a.config.SpotMaxPrice != tt.expectedMaxPrice {
+				t.Errorf("SpotMaxPrice = %f, expected %f", a.config.SpotMaxPrice, tt.expectedMaxPrice)
+			}
+			if a.config.SpotPriceOnDemandPercentage != tt.expectedPercentage {
+				t.Errorf("SpotPriceOnDemandPercentage = %f, expected %f",
+					a.config.SpotPriceOnDemandPercentage, tt.expectedPercentage)
+			}
+		})
+	}
+}
+
 func TestLoadConfigFromTags(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -1199,3 +1271,272 @@ func Test_autoScalingGroup_LoadPatchBeanstalkUserdata(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadAllowDedicatedTenancy(t *testing.T) {
+	tests := []struct {
+		name     string
+		asgTags  []*autoscaling.TagDescription
+		global   bool
+		expected bool
+	}{
+		{
+			name:     "no tag, global default",
+			asgTags:  []*autoscaling.TagDescription{},
+			global:   false,
+			expected: false,
+		},
+		{
+			name:     "no tag, globally enabled",
+			asgTags:  []*autoscaling.TagDescription{},
+			global:   true,
+			expected: true,
+		},
+		{
+			name: "enabled by tag",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(AllowDedicatedTenancyTag), Value: aws.String("true")},
+			},
+			global:   false,
+			expected: true,
+		},
+		{
+			name: "disabled by tag",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(AllowDedicatedTenancyTag), Value: aws.String("false")},
+			},
+			global:   true,
+			expected: false,
+		},
+		{
+			name: "invalid tag value",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(AllowDedicatedTenancyTag), Value: aws.String("foo")},
+			},
+			global:   true,
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.asgTags},
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{AllowDedicatedTenancy: tt.global},
+				}},
+			}
+			a.loadAllowDedicatedTenancy()
+			if a.config.AllowDedicatedTenancy != tt.expected {
+				t.Errorf("loadAllowDedicatedTenancy() = %v, expected %v",
+					a.config.AllowDedicatedTenancy, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadCopyTerminationProtection(t *testing.T) {
+	tests := []struct {
+		name     string
+		asgTags  []*autoscaling.TagDescription
+		global   bool
+		expected bool
+	}{
+		{
+			name:     "no tag, global default",
+			asgTags:  []*autoscaling.TagDescription{},
+			global:   false,
+			expected: false,
+		},
+		{
+			name:     "no tag, globally enabled",
+			asgTags:  []*autoscaling.TagDescription{},
+			global:   true,
+			expected: true,
+		},
+		{
+			name: "enabled by tag",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(CopyTerminationProtectionTag), Value: aws.String("true")},
+			},
+			global:   false,
+			expected: true,
+		},
+		{
+			name: "invalid tag value",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(CopyTerminationProtectionTag), Value: aws.String("foo")},
+			},
+			global:   true,
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.asgTags},
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{CopyTerminationProtection: tt.global},
+				}},
+			}
+			a.loadCopyTerminationProtection()
+			if a.config.CopyTerminationProtection != tt.expected {
+				t.Errorf("loadCopyTerminationProtection() = %v, expected %v",
+					a.config.CopyTerminationProtection, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadSkipTerminationProtectionCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		asgTags  []*autoscaling.TagDescription
+		global   bool
+		expected bool
+	}{
+		{
+			name:     "no tag, global default",
+			asgTags:  []*autoscaling.TagDescription{},
+			global:   false,
+			expected: false,
+		},
+		{
+			name:     "no tag, globally enabled",
+			asgTags:  []*autoscaling.TagDescription{},
+			global:   true,
+			expected: true,
+		},
+		{
+			name: "enabled by tag",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(SkipTerminationProtectionCheckTag), Value: aws.String("true")},
+			},
+			global:   false,
+			expected: true,
+		},
+		{
+			name: "invalid tag value",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(SkipTerminationProtectionCheckTag), Value: aws.String("foo")},
+			},
+			global:   true,
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.asgTags},
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{SkipTerminationProtectionCheck: tt.global},
+				}},
+			}
+			a.loadSkipTerminationProtectionCheck()
+			if a.config.SkipTerminationProtectionCheck != tt.expected {
+				t.Errorf("loadSkipTerminationProtectionCheck() = %v, expected %v",
+					a.config.SkipTerminationProtectionCheck, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadInstanceStoreCompatibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		asgTags  []*autoscaling.TagDescription
+		expected string
+	}{
+		{
+			name:     "no tag",
+			asgTags:  []*autoscaling.TagDescription{},
+			expected: DefaultInstanceStoreCompatibility,
+		},
+		{
+			name: "strict compatibility set by tag",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(InstanceStoreCompatibilityTag), Value: aws.String(StrictInstanceStoreCompatibility)},
+			},
+			expected: StrictInstanceStoreCompatibility,
+		},
+		{
+			name: "invalid tag value",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(InstanceStoreCompatibilityTag), Value: aws.String("loose")},
+			},
+			expected: DefaultInstanceStoreCompatibility,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.asgTags},
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						InstanceStoreCompatibility: DefaultInstanceStoreCompatibility,
+					},
+				}},
+			}
+			a.loadInstanceStoreCompatibility()
+			if a.config.InstanceStoreCompatibility != tt.expected {
+				t.Errorf("loadInstanceStoreCompatibility() = %v, expected %v",
+					a.config.InstanceStoreCompatibility, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadGracePeriod(t *testing.T) {
+	tests := []struct {
+		name     string
+		asgTags  []*autoscaling.TagDescription
+		expected int64
+	}{
+		{
+			name:     "no tag",
+			asgTags:  []*autoscaling.TagDescription{},
+			expected: 600,
+		},
+		{
+			name: "grace period set by tag",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(GracePeriodTag), Value: aws.String("60")},
+			},
+			expected: 60,
+		},
+		{
+			name: "zero grace period set by tag",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(GracePeriodTag), Value: aws.String("0")},
+			},
+			expected: 0,
+		},
+		{
+			name: "invalid tag value",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(GracePeriodTag), Value: aws.String("1m")},
+			},
+			expected: 600,
+		},
+		{
+			name: "negative tag value",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(GracePeriodTag), Value: aws.String("-1")},
+			},
+			expected: 600,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := autoScalingGroup{
+				Group: &autoscaling.Group{
+					HealthCheckGracePeriod: aws.Int64(600),
+					Tags:                   tt.asgTags,
+				},
+				region: &region{conf: &Config{}},
+			}
+			a.loadGracePeriod()
+			if got := a.readinessGracePeriod(); got != tt.expected {
+				t.Errorf("readinessGracePeriod() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
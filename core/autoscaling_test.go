@@ -1835,7 +1835,7 @@ func TestReplaceOnDemandInstanceWithSpot(t *testing.T) {
 		},
 		{name: "no spot instances found in region",
 			spotID:   "spot-not-found",
-			expected: errors.New("couldn't find spot instance to use"),
+			expected: fmt.Errorf("couldn't find spot instance to use: %w", ErrInstanceNotFound),
 			asg: &autoScalingGroup{
 				name: "test-asg",
 				Group: &autoscaling.Group{
@@ -1889,7 +1889,7 @@ func TestReplaceOnDemandInstanceWithSpot(t *testing.T) {
 		},
 		{name: "no OnDemand instances found in asg",
 			spotID:   "spot-running",
-			expected: errors.New("couldn't find target instance for spot-running"),
+			expected: fmt.Errorf("couldn't find target instance for spot-running: %w", ErrInstanceNotFound),
 			asg: &autoScalingGroup{
 				name: "test-asg",
 				Group: &autoscaling.Group{
@@ -1943,7 +1943,7 @@ func TestReplaceOnDemandInstanceWithSpot(t *testing.T) {
 
 		{name: "found OnDemand instance in asg, without lifecycle hooks",
 			spotID:   "spot-running",
-			expected: errors.New("couldn't find target instance for spot-running"),
+			expected: fmt.Errorf("couldn't find target instance for spot-running: %w", ErrInstanceNotFound),
 			asg: &autoScalingGroup{
 				name: "test-asg",
 				Group: &autoscaling.Group{
@@ -2259,6 +2259,51 @@ func TestGetAllowedInstanceTypes(t *testing.T) {
 			},
 			asgtags: []*autoscaling.TagDescription{},
 		},
+		{name: "Mixed instances policy overrides",
+			expected: []string{"m5.large", "m5a.large"},
+			instanceInfo: &instance{
+				typeInfo: instanceTypeInformation{
+					instanceType: "typeX",
+				},
+				region: &region{},
+			},
+			asg: &autoScalingGroup{
+				name: "TestASG",
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{
+							AllowedInstanceTypes: "c2.xlarge",
+						}},
+				},
+				Group: &autoscaling.Group{
+					DesiredCapacity:      aws.Int64(4),
+					MixedInstancesPolicy: mixedInstancesPolicy(nil, "m5.large", "m5a.large"),
+				},
+			},
+			asgtags: []*autoscaling.TagDescription{},
+		},
+		{name: "Tag takes precedence over mixed instances policy overrides",
+			expected: []string{"c3.small"},
+			instanceInfo: &instance{
+				typeInfo: instanceTypeInformation{
+					instanceType: "typeX",
+				},
+				region: &region{},
+			},
+			asg: &autoScalingGroup{
+				name: "TestASG",
+				region: &region{
+					conf: &Config{},
+				},
+				Group: &autoscaling.Group{
+					DesiredCapacity:      aws.Int64(4),
+					MixedInstancesPolicy: mixedInstancesPolicy(nil, "m5.large", "m5a.large"),
+				},
+			},
+			asgtags: []*autoscaling.TagDescription{
+				{Key: aws.String(AllowedInstanceTypesTag), Value: aws.String("c3.small")},
+			},
+		},
 		{name: "No empty elements in space separated list",
 			expected: []string{"c2.xlarge", "t2.medium", "c3.small"},
 			instanceInfo: &instance{
@@ -2629,6 +2674,7 @@ func Test_autoScalingGroup_getAnyUnprotectedOnDemandInstance(t *testing.T) {
 					},
 				},
 				region: &region{
+					terminationProtection: map[string]bool{"ondemand-unprotected": false},
 					services: connections{
 						ec2: mockEC2{
 							diao: &ec2.DescribeInstanceAttributeOutput{
@@ -2727,6 +2773,7 @@ func Test_autoScalingGroup_getAnyUnprotectedOnDemandInstance(t *testing.T) {
 					},
 				},
 				region: &region{
+					terminationProtection: map[string]bool{"ondemand-unprotected": false},
 					services: connections{
 						ec2: mockEC2{
 							diao: &ec2.DescribeInstanceAttributeOutput{
@@ -3406,3 +3453,90 @@ func Test_autoScalingGroup_cronEventAction(t *testing.T) {
 		})
 	}
 }
+
+func Test_autoScalingGroup_waitForInstanceStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		dasio         *autoscaling.DescribeAutoScalingInstancesOutput
+		expectedSleep time.Duration
+		wantErr       bool
+	}{
+		{
+			name: "already in status",
+			dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+				AutoScalingInstances: []*autoscaling.InstanceDetails{
+					{LifecycleState: aws.String("InService")},
+				},
+			},
+			expectedSleep: 0,
+			wantErr:       false,
+		},
+		{
+			name: "never reaching the status",
+			dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+				AutoScalingInstances: []*autoscaling.InstanceDetails{
+					{LifecycleState: aws.String("Pending")},
+				},
+			},
+			// decreasing backoff of 10, 8, 6 and 4 seconds
+			expectedSleep: 28 * time.Second,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &mockClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+			a := &autoScalingGroup{
+				name: "asg",
+				region: &region{
+					conf:     &Config{clock: clock},
+					services: connections{autoScaling: mockASG{dasio: tt.dasio}},
+				},
+			}
+
+			err := a.waitForInstanceStatus(aws.String("i-foo"), "InService", 3)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("waitForInstanceStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if clock.slept != tt.expectedSleep {
+				t.Errorf("waitForInstanceStatus() slept %v, expected %v", clock.slept, tt.expectedSleep)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_isBlockedByTerminationProtection(t *testing.T) {
+	tests := []struct {
+		name      string
+		protected bool
+		copy      bool
+		want      bool
+	}{
+		{name: "unprotected", protected: false, copy: false, want: false},
+		{name: "protected", protected: true, copy: false, want: true},
+		{name: "protected, copied to the replacement", protected: true, copy: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				config: AutoScalingConfig{CopyTerminationProtection: tt.copy},
+			}
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-foo")},
+				region: &region{
+					name: "us-east-1",
+					services: connections{ec2: mockEC2{
+						diao: &ec2.DescribeInstanceAttributeOutput{
+							DisableApiTermination: &ec2.AttributeBooleanValue{Value: aws.Bool(tt.protected)},
+						},
+					}},
+				},
+				asg: a,
+			}
+			if got := a.isBlockedByTerminationProtection(i); got != tt.want {
+				t.Errorf("isBlockedByTerminationProtection() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
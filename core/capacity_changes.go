@@ -0,0 +1,79 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// currentDesiredCapacity describes the group again, since its desired capacity
+// may have been changed by scaling policies or scheduled actions since it was
+// loaded.
+func (a *autoScalingGroup) currentDesiredCapacity() (int64, error) {
+	out, err := a.region.services.autoScaling.DescribeAutoScalingGroups(
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []*string{aws.String(a.name)},
+		})
+	if err != nil {
+		log.Println(a.name, "Couldn't describe the group:", err.Error())
+		return 0, err
+	}
+
+	if out == nil || len(out.AutoScalingGroups) == 0 {
+		return 0, fmt.Errorf("group %s not found", a.name)
+	}
+	return aws.Int64Value(out.AutoScalingGroups[0].DesiredCapacity), nil
+}
+
+// terminateReplacedOnDemandInstance terminates the on-demand instance once its
+// spot replacement was attached, reconciling any change of the desired
+// capacity made by scaling activities since the swap started. The attachment
+// is expected to have increased the desired capacity to expectedCapacity. The
+// on-demand instance is deregistered from Cloud Map and drained by the load
+// balancers before it's terminated, and waits for its custom termination
+// lifecycle hooks to be completed.
+func (a *autoScalingGroup) terminateReplacedOnDemandInstance(odInstanceID *string, spotInstanceID string, expectedCapacity int64) error {
+	current, err := a.currentDesiredCapacity()
+	if err != nil {
+		// the desired capacity couldn't be checked, assume it didn't change
+		current = expectedCapacity
+	}
+
+	switch {
+	case current > expectedCapacity:
+		// the group is scaling out, terminating the on-demand instance would
+		// cause a capacity dip, so it's kept running and replaced later
+		log.Printf("%s Desired capacity changed from %d to %d during the swap, keeping on-demand instance %s",
+			a.name, expectedCapacity, current, *odInstanceID)
+		recapText := fmt.Sprintf("%s Kept on-demand instance %s [scaling out during the swap]", a.name, *odInstanceID)
+		a.region.conf.addRecap(a.region.name, recapText)
+		return fmt.Errorf("desired capacity of %s changed from %d to %d: %w",
+			a.name, expectedCapacity, current, ErrScalingActivity)
+
+	case current < expectedCapacity:
+		// the group scaled in meanwhile, so the desired capacity already
+		// accounts for the on-demand instance being removed
+		log.Printf("%s Desired capacity changed from %d to %d during the swap, terminating on-demand instance %s without decrementing it",
+			a.name, expectedCapacity, current, *odInstanceID)
+		a.deregisterFromServiceDiscovery(*odInstanceID)
+		a.drainInstance(*odInstanceID)
+		a.region.annotateTermination(*odInstanceID, a.name, replacedBySpotTermination, spotInstanceID)
+		if err := a.terminateInstanceInAutoScalingGroup(odInstanceID, true, false); err != nil {
+			return err
+		}
+		return a.waitForTerminationHooks(*odInstanceID)
+	}
+
+	a.deregisterFromServiceDiscovery(*odInstanceID)
+	a.drainInstance(*odInstanceID)
+	a.region.annotateTermination(*odInstanceID, a.name, replacedBySpotTermination, spotInstanceID)
+	if err := a.terminateInstanceInAutoScalingGroup(odInstanceID, true, true); err != nil {
+		return err
+	}
+	return a.waitForTerminationHooks(*odInstanceID)
+}
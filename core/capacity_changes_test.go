@@ -0,0 +1,73 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func Test_autoScalingGroup_terminateReplacedOnDemandInstance(t *testing.T) {
+	groupWithCapacity := func(desired int64) *autoscaling.DescribeAutoScalingGroupsOutput {
+		return &autoscaling.DescribeAutoScalingGroupsOutput{
+			AutoScalingGroups: []*autoscaling.Group{{DesiredCapacity: aws.Int64(desired)}},
+		}
+	}
+
+	tests := []struct {
+		name          string
+		dasgo         *autoscaling.DescribeAutoScalingGroupsOutput
+		dasgerr       error
+		expectedErr   error
+		expectedRecap []string
+	}{
+		{name: "unchanged desired capacity", dasgo: groupWithCapacity(3)},
+		{name: "scaled in during the swap", dasgo: groupWithCapacity(2)},
+		{name: "desired capacity couldn't be checked", dasgerr: errors.New("describe")},
+		{
+			name:          "scaling out during the swap",
+			dasgo:         groupWithCapacity(5),
+			expectedErr:   ErrScalingActivity,
+			expectedRecap: []string{"asg Kept on-demand instance i-od [scaling out during the swap]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{
+				clock:      &mockClock{},
+				FinalRecap: map[string][]string{},
+			}
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+				name:  "asg",
+				region: &region{
+					name: "us-east-1",
+					conf: conf,
+					services: connections{
+						ec2: mockEC2{},
+						autoScaling: mockASG{
+							dasgo:      tt.dasgo,
+							dasgerr:    tt.dasgerr,
+							dasiStates: map[string]string{"i-od": autoscaling.LifecycleStateInService},
+							dlho:       &autoscaling.DescribeLifecycleHooksOutput{},
+						},
+					},
+				},
+			}
+
+			err := a.terminateReplacedOnDemandInstance(aws.String("i-od"), "i-spot", 3)
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("terminateReplacedOnDemandInstance() error = %v, expected %v", err, tt.expectedErr)
+			}
+			if got := conf.FinalRecap["us-east-1"]; !reflect.DeepEqual(got, tt.expectedRecap) {
+				t.Errorf("FinalRecap = %v, expected %v", got, tt.expectedRecap)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// SimulateChaosMode runs the spot interruption handling for the selected
+	// instances as if an interruption warning was received for them
+	SimulateChaosMode = "simulate"
+
+	// TerminateChaosMode terminates the selected instances without any
+	// warning, and should only be used in sandbox accounts
+	TerminateChaosMode = "terminate"
+)
+
+// chaosCandidates returns the running spot instances launched by AutoSpotting
+// which are already attached to one of the enabled groups.
+func (r *region) chaosCandidates() []*instance {
+	var candidates []*instance
+
+	for _, inst := range r.instances.instances() {
+		if !inst.isSpot() || !inst.isLaunchedByAutoSpotting() ||
+			inst.stateName() != ec2.InstanceStateNameRunning {
+			continue
+		}
+
+		belongs, asgName := inst.belongsToAnASG()
+		if !belongs || r.findEnabledASGByName(aws.StringValue(asgName)) == nil {
+			continue
+		}
+		candidates = append(candidates, inst)
+	}
+	return candidates
+}
+
+// selectChaosVictims randomly picks the given percentage of the candidates,
+// rounded to the nearest number of instances.
+func selectChaosVictims(candidates []*instance, percentage float64) []*instance {
+	if percentage <= 0 {
+		return nil
+	}
+
+	count := int(math.Round(float64(len(candidates)) * math.Min(percentage, 100) / 100))
+
+	victims := make([]*instance, 0, count)
+	for _, idx := range rand.Perm(len(candidates))[:count] {
+		victims = append(victims, candidates[idx])
+	}
+	return victims
+}
+
+// injectChaos interrupts a random subset of the spot instances launched by
+// AutoSpotting, in order to validate the fallback and interruption handling
+// logic end to end. It does nothing unless a chaos mode is configured.
+func (r *region) injectChaos() {
+	mode := r.conf.ChaosMode
+	if mode != SimulateChaosMode && mode != TerminateChaosMode {
+		return
+	}
+
+	for _, inst := range selectChaosVictims(r.chaosCandidates(), r.conf.ChaosPercentage) {
+		id := aws.StringValue(inst.InstanceId)
+		log.Printf("%s Chaos testing: interrupting spot instance %s [%s]", r.name, id, mode)
+
+		var err error
+		switch mode {
+		case SimulateChaosMode:
+			spotTermination := SpotTermination{
+				asSvc:     r.services.autoScaling,
+				ec2Svc:    r.services.ec2,
+				eventsSvc: r.services.eventBridge,
+				region:    r.name,
+				conf:      r.conf,
+				// no need to wait before terminating simulated interruptions
+				SleepMultiplier: 0,
+			}
+			err = spotTermination.executeAction(inst.InstanceId,
+				r.conf.TerminationNotificationAction, SpotInstanceInterruptionWarningCode)
+		case TerminateChaosMode:
+			_, err = r.services.ec2.TerminateInstances(&ec2.TerminateInstancesInput{
+				InstanceIds: []*string{inst.InstanceId},
+			})
+		}
+
+		recapText := fmt.Sprintf("Chaos testing: interrupted spot instance %s [%s]", id, mode)
+		if err != nil {
+			log.Printf("%s Chaos testing: failed to interrupt %s: %s", r.name, id, err.Error())
+			recapText = fmt.Sprintf("Chaos testing: failed to interrupt spot instance %s [%s]", id, mode)
+		}
+		r.conf.addRecap(r.name, recapText)
+	}
+}
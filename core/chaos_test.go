@@ -0,0 +1,161 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func chaosTestInstance(id, asgName string, spot, launchedByAutoSpotting bool) *instance {
+	inst := &instance{Instance: &ec2.Instance{
+		InstanceId: aws.String(id),
+		State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		Tags: []*ec2.Tag{
+			{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String(asgName)},
+		},
+	}}
+	if spot {
+		inst.InstanceLifecycle = aws.String("spot")
+	}
+	if launchedByAutoSpotting {
+		inst.Tags = append(inst.Tags, &ec2.Tag{
+			Key: aws.String("launched-by-autospotting"), Value: aws.String("true"),
+		})
+	}
+	return inst
+}
+
+func Test_region_chaosCandidates(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		enabledASGs: []*autoScalingGroup{
+			{name: "enabled"},
+		},
+		instances: makeInstancesWithCatalog(instanceMap{
+			"i-spot":     chaosTestInstance("i-spot", "enabled", true, true),
+			"i-ondemand": chaosTestInstance("i-ondemand", "enabled", false, false),
+			"i-external": chaosTestInstance("i-external", "enabled", true, false),
+			"i-otherasg": chaosTestInstance("i-otherasg", "disabled", true, true),
+			"i-noasg":    {Instance: &ec2.Instance{InstanceId: aws.String("i-noasg")}},
+		}),
+	}
+
+	candidates := r.chaosCandidates()
+	if len(candidates) != 1 || aws.StringValue(candidates[0].InstanceId) != "i-spot" {
+		t.Errorf("chaosCandidates() = %v, expected only i-spot", candidates)
+	}
+}
+
+func Test_selectChaosVictims(t *testing.T) {
+	candidates := []*instance{
+		chaosTestInstance("i-1", "asg", true, true),
+		chaosTestInstance("i-2", "asg", true, true),
+		chaosTestInstance("i-3", "asg", true, true),
+		chaosTestInstance("i-4", "asg", true, true),
+	}
+
+	tests := []struct {
+		name       string
+		percentage float64
+		expected   int
+	}{
+		{name: "disabled", percentage: 0, expected: 0},
+		{name: "negative", percentage: -10, expected: 0},
+		{name: "rounded down", percentage: 10, expected: 0},
+		{name: "rounded up", percentage: 20, expected: 1},
+		{name: "half", percentage: 50, expected: 2},
+		{name: "all", percentage: 100, expected: 4},
+		{name: "capped", percentage: 300, expected: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			victims := selectChaosVictims(candidates, tt.percentage)
+			if len(victims) != tt.expected {
+				t.Fatalf("selectChaosVictims() returned %d instances, expected %d", len(victims), tt.expected)
+			}
+
+			seen := map[*instance]bool{}
+			for _, v := range victims {
+				if seen[v] {
+					t.Errorf("instance %s selected twice", aws.StringValue(v.InstanceId))
+				}
+				seen[v] = true
+			}
+		})
+	}
+}
+
+func Test_region_injectChaos(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           string
+		ec2            mockEC2
+		asg            mockASG
+		expectedRecaps []string
+	}{
+		{
+			name:           "disabled",
+			mode:           "",
+			expectedRecaps: nil,
+		},
+		{
+			name: "simulated interruption",
+			mode: SimulateChaosMode,
+			asg: mockASG{
+				dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+					AutoScalingInstances: []*autoscaling.InstanceDetails{
+						{AutoScalingGroupName: aws.String("enabled")},
+					},
+				},
+			},
+			expectedRecaps: []string{"Chaos testing: interrupted spot instance i-spot [simulate]"},
+		},
+		{
+			name:           "terminated instance",
+			mode:           TerminateChaosMode,
+			expectedRecaps: []string{"Chaos testing: interrupted spot instance i-spot [terminate]"},
+		},
+		{
+			name: "failed termination",
+			mode: TerminateChaosMode,
+			ec2: mockEC2{
+				tierr: errors.New("UnauthorizedOperation"),
+			},
+			expectedRecaps: []string{"Chaos testing: failed to interrupt spot instance i-spot [terminate]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{
+					ChaosMode:       tt.mode,
+					ChaosPercentage: 100,
+					FinalRecap:      make(map[string][]string),
+					AutoScalingConfig: AutoScalingConfig{
+						TerminationNotificationAction: DetachTerminationNotificationAction,
+					},
+				},
+				enabledASGs: []*autoScalingGroup{{name: "enabled"}},
+				instances: makeInstancesWithCatalog(instanceMap{
+					"i-spot": chaosTestInstance("i-spot", "enabled", true, true),
+				}),
+				services: connections{ec2: tt.ec2, autoScaling: tt.asg},
+			}
+
+			r.injectChaos()
+
+			got := r.conf.FinalRecap[r.name]
+			if strings.Join(got, "\n") != strings.Join(tt.expectedRecaps, "\n") {
+				t.Errorf("FinalRecap = %v, expected %v", got, tt.expectedRecaps)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// cleanupFinding is a resource left over by AutoSpotting in a region, along
+// with the change reverting it.
+type cleanupFinding struct {
+	region   string
+	resource string
+	id       string
+	issue    string
+	action   string
+
+	// revert makes the change described by the action
+	revert func() error
+
+	// the outcome of the change, empty when not applied
+	result string
+}
+
+// Cleanup finds the resources left over by AutoSpotting in all the enabled
+// regions and writes a report of them: the orphan spot instances, the tags
+// it set on the groups and instances, the MaxSizes left increased and the
+// dangling persistent spot requests. The changes are only made when
+// CleanupApply is set, for safely decommissioning AutoSpotting once stopped.
+func (a *AutoSpotting) Cleanup(w io.Writer) error {
+	a.config.FinalRecap = make(map[string][]string)
+	apply := a.config.CleanupApply
+
+	allRegions, err := a.getRegions()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var findingsLock sync.Mutex
+	var findings []cleanupFinding
+
+	for _, name := range allRegions {
+		r := &region{name: name, conf: a.config}
+		if !r.enabled() {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.services.connect(r.name, r.conf)
+			found := r.cleanup(apply)
+			findingsLock.Lock()
+			findings = append(findings, found...)
+			findingsLock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].region != findings[j].region {
+			return findings[i].region < findings[j].region
+		}
+		return findings[i].resource < findings[j].resource
+	})
+
+	return writeCleanupReport(w, findings, apply)
+}
+
+// cleanup finds the resources left over by AutoSpotting in the region,
+// reverting them when apply is set.
+func (r *region) cleanup(apply bool) []cleanupFinding {
+	log.Println("Looking for resources left over by AutoSpotting in", r.name)
+
+	var groups []*autoscaling.Group
+	err := r.services.autoScaling.DescribeAutoScalingGroupsPages(
+		&autoscaling.DescribeAutoScalingGroupsInput{},
+		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			groups = append(groups, page.AutoScalingGroups...)
+			return true
+		},
+	)
+	if err != nil {
+		log.Println("Failed to describe AutoScalingGroups in", r.name, err.Error())
+		return nil
+	}
+
+	if err := r.scanInstances(); err != nil {
+		log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+		return nil
+	}
+
+	var findings []cleanupFinding
+	for _, group := range groups {
+		asg := &autoScalingGroup{
+			Group:  group,
+			name:   aws.StringValue(group.AutoScalingGroupName),
+			region: r,
+		}
+		findings = append(findings, asg.cleanupFindings()...)
+	}
+
+	for _, i := range r.instances.instances() {
+		if f := i.cleanupFinding(); f != nil {
+			findings = append(findings, *f)
+		}
+	}
+
+	findings = append(findings, r.danglingSpotRequests()...)
+
+	if apply {
+		for n := range findings {
+			f := &findings[n]
+			if err := f.revert(); err != nil {
+				log.Printf("%s Couldn't %s for %s %s: %s", r.name, f.action, f.resource, f.id, err.Error())
+				f.result = "failed: " + err.Error()
+				continue
+			}
+			f.result = "done"
+		}
+	}
+	return findings
+}
+
+// cleanupFindings returns the tags set by AutoSpotting on the group and its
+// MaxSize, if left increased by an interrupted swap.
+func (a *autoScalingGroup) cleanupFindings() []cleanupFinding {
+	var findings []cleanupFinding
+
+	var leftoverTags []*autoscaling.Tag
+	for _, key := range []string{OriginalMaxSizeTag, MaxSizeIncreasedAtTag, SkipReasonsTag} {
+		if a.getOwnTagValue(key) != nil {
+			leftoverTags = append(leftoverTags, a.groupTag(key, ""))
+		}
+	}
+	if len(leftoverTags) == 0 {
+		return nil
+	}
+
+	deleteTags := func() error {
+		_, err := a.region.services.autoScaling.DeleteTags(&autoscaling.DeleteTagsInput{
+			Tags: leftoverTags,
+		})
+		return err
+	}
+
+	if original := a.getOwnTagValue(OriginalMaxSizeTag); original != nil {
+		maxSize, err := strconv.ParseInt(*original, 10, 64)
+		if err == nil && aws.Int64Value(a.MaxSize) > maxSize {
+			findings = append(findings, cleanupFinding{
+				region:   a.region.name,
+				resource: "group",
+				id:       a.name,
+				issue:    fmt.Sprintf("MaxSize increased from %d to %d", maxSize, aws.Int64Value(a.MaxSize)),
+				action:   fmt.Sprintf("restore the MaxSize to %d", maxSize),
+				revert: func() error {
+					if err := a.setAutoScalingMaxSize(maxSize); err != nil {
+						return err
+					}
+					a.MaxSize = aws.Int64(maxSize)
+					return nil
+				},
+			})
+		}
+	}
+
+	keys := make([]string, 0, len(leftoverTags))
+	for _, t := range leftoverTags {
+		keys = append(keys, aws.StringValue(t.Key))
+	}
+	findings = append(findings, cleanupFinding{
+		region:   a.region.name,
+		resource: "group",
+		id:       a.name,
+		issue:    "tagged with " + strings.Join(keys, ", "),
+		action:   "delete the tags",
+		revert:   deleteTags,
+	})
+	return findings
+}
+
+// cleanupFinding returns the spot instance launched by AutoSpotting if it was
+// left outside of any group, which is terminated, or the tags it set on the
+// instance otherwise.
+func (i *instance) cleanupFinding() *cleanupFinding {
+	if !i.isLaunchedByAutoSpotting() {
+		return nil
+	}
+
+	id := aws.StringValue(i.InstanceId)
+
+	if belongs, _ := i.belongsToAnASG(); !belongs && i.isSpot() {
+		return &cleanupFinding{
+			region:   i.region.name,
+			resource: "instance",
+			id:       id,
+			issue:    fmt.Sprintf("orphan spot instance launched for %s", aws.StringValue(i.getReplacementTargetASGName())),
+			action:   "terminate the instance",
+			revert:   i.terminateOrphan,
+		}
+	}
+
+	var leftoverTags []*ec2.Tag
+	var keys []string
+	for _, tag := range i.Tags {
+		key := aws.StringValue(tag.Key)
+		if strings.HasPrefix(key, "aws:") || !isReservedTagKey(key, i.region.conf.TagKeyPrefix) {
+			continue
+		}
+		leftoverTags = append(leftoverTags, &ec2.Tag{Key: tag.Key})
+		keys = append(keys, key)
+	}
+
+	return &cleanupFinding{
+		region:   i.region.name,
+		resource: "instance",
+		id:       id,
+		issue:    "tagged with " + strings.Join(keys, ", "),
+		action:   "delete the tags",
+		revert: func() error {
+			_, err := i.region.services.ec2.DeleteTags(&ec2.DeleteTagsInput{
+				Resources: []*string{i.InstanceId},
+				Tags:      leftoverTags,
+			})
+			return err
+		},
+	}
+}
+
+// terminateOrphan terminates a spot instance which isn't attached to any
+// group, after cancelling its spot request which may otherwise replace it.
+func (i *instance) terminateOrphan() error {
+	i.region.annotateTermination(aws.StringValue(i.InstanceId), "", orphanTermination, "")
+
+	if i.SpotInstanceRequestId != nil {
+		if _, err := i.region.services.ec2.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+			SpotInstanceRequestIds: []*string{i.SpotInstanceRequestId},
+		}); err != nil {
+			return err
+		}
+	}
+
+	_, err := i.region.services.ec2.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: []*string{i.InstanceId},
+	})
+	return err
+}
+
+// danglingSpotRequests returns the persistent spot requests of the instances
+// launched by AutoSpotting which are no longer running, such as those
+// stopped by spot interruptions, which would otherwise relaunch them.
+func (r *region) danglingSpotRequests() []cleanupFinding {
+	requests := make(map[string]*ec2.SpotInstanceRequest)
+	var instanceIDs []*string
+
+	err := r.services.ec2.DescribeSpotInstanceRequestsPages(&ec2.DescribeSpotInstanceRequestsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("type"), Values: []*string{aws.String(ec2.SpotInstanceTypePersistent)}},
+			{Name: aws.String("state"), Values: []*string{
+				aws.String(ec2.SpotInstanceStateOpen),
+				aws.String(ec2.SpotInstanceStateActive),
+				aws.String("disabled"),
+			}},
+		},
+	}, func(page *ec2.DescribeSpotInstanceRequestsOutput, lastPage bool) bool {
+		for _, req := range page.SpotInstanceRequests {
+			id := aws.StringValue(req.InstanceId)
+			// the requests of the running instances are handled with them
+			if id == "" || r.instances.get(id) != nil {
+				continue
+			}
+			requests[id] = req
+			instanceIDs = append(instanceIDs, req.InstanceId)
+		}
+		return true
+	})
+	if err != nil {
+		log.Println("Failed to describe the spot requests in", r.name, err.Error())
+		return nil
+	}
+
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+
+	var findings []cleanupFinding
+	err = r.services.ec2.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		InstanceIds: instanceIDs,
+	}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, res := range page.Reservations {
+			for _, inst := range res.Instances {
+				i := &instance{Instance: inst, region: r}
+				req := requests[aws.StringValue(inst.InstanceId)]
+				if req == nil || !i.isLaunchedByAutoSpotting() {
+					continue
+				}
+				findings = append(findings, cleanupFinding{
+					region:   r.name,
+					resource: "spot-request",
+					id:       aws.StringValue(req.SpotInstanceRequestId),
+					issue:    fmt.Sprintf("persistent request of the %s instance %s", i.stateName(), aws.StringValue(inst.InstanceId)),
+					action:   "cancel the spot request",
+					revert: func() error {
+						_, err := r.services.ec2.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+							SpotInstanceRequestIds: []*string{req.SpotInstanceRequestId},
+						})
+						return err
+					},
+				})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		log.Println("Failed to describe the instances of the spot requests in", r.name, err.Error())
+		return nil
+	}
+	return findings
+}
+
+// writeCleanupReport writes the resources left over by AutoSpotting as a
+// table, along with the outcome of reverting them.
+func writeCleanupReport(w io.Writer, findings []cleanupFinding, apply bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "REGION\tRESOURCE\tID\tFINDING\tACTION\tRESULT")
+	for _, f := range findings {
+		result := f.result
+		if !apply {
+			result = "dry run"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", f.region, f.resource, f.id, f.issue, f.action, result)
+	}
+
+	if !apply && len(findings) > 0 {
+		fmt.Fprintf(tw, "\n%d leftover resources found, run with --cleanup_apply to revert them\n", len(findings))
+	} else {
+		fmt.Fprintf(tw, "\n%d leftover resources found\n", len(findings))
+	}
+	return tw.Flush()
+}
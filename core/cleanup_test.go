@@ -0,0 +1,306 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_cleanupFindings(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxSize         int64
+		tags            []*autoscaling.TagDescription
+		expectedActions []string
+	}{
+		{
+			name:    "no AutoSpotting tags",
+			maxSize: 3,
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+			},
+		},
+		{
+			name:    "skip reasons",
+			maxSize: 3,
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(SkipReasonsTag), Value: aws.String("protected=1")},
+			},
+			expectedActions: []string{"delete the tags"},
+		},
+		{
+			name:    "MaxSize left increased",
+			maxSize: 4,
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(OriginalMaxSizeTag), Value: aws.String("3")},
+				{Key: aws.String(MaxSizeIncreasedAtTag), Value: aws.String("2021-01-01T00:00:00Z")},
+			},
+			expectedActions: []string{"restore the MaxSize to 3", "delete the tags"},
+		},
+		{
+			name:    "MaxSize already restored",
+			maxSize: 3,
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(OriginalMaxSizeTag), Value: aws.String("3")},
+			},
+			expectedActions: []string{"delete the tags"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{
+					MaxSize: aws.Int64(tt.maxSize),
+					Tags:    tt.tags,
+				},
+				region: &region{name: "us-east-1", conf: &Config{}},
+			}
+
+			findings := a.cleanupFindings()
+
+			if len(findings) != len(tt.expectedActions) {
+				t.Fatalf("cleanupFindings() = %v, expected the actions %v", findings, tt.expectedActions)
+			}
+			for n, f := range findings {
+				if f.action != tt.expectedActions[n] {
+					t.Errorf("cleanupFindings()[%d].action = %q, expected %q", n, f.action, tt.expectedActions[n])
+				}
+			}
+		})
+	}
+}
+
+func Test_instance_cleanupFinding(t *testing.T) {
+	tests := []struct {
+		name           string
+		prefix         string
+		lifecycle      string
+		tags           []*ec2.Tag
+		expectedAction string
+		expectedIssue  string
+	}{
+		{
+			name:      "not launched by AutoSpotting",
+			lifecycle: Spot,
+			tags: []*ec2.Tag{
+				{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("asg")},
+			},
+		},
+		{
+			name:      "orphan spot instance",
+			lifecycle: Spot,
+			tags: []*ec2.Tag{
+				{Key: aws.String(launchedByAutoSpottingTag), Value: aws.String("true")},
+				{Key: aws.String(launchedForASGTag), Value: aws.String("asg")},
+			},
+			expectedAction: "terminate the instance",
+			expectedIssue:  "orphan spot instance launched for asg",
+		},
+		{
+			name:      "attached spot instance",
+			lifecycle: Spot,
+			tags: []*ec2.Tag{
+				{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("asg")},
+				{Key: aws.String(launchedByAutoSpottingTag), Value: aws.String("true")},
+				{Key: aws.String(launchedForASGTag), Value: aws.String("asg")},
+				{Key: aws.String("team"), Value: aws.String("platform")},
+			},
+			expectedAction: "delete the tags",
+			expectedIssue:  "tagged with launched-by-autospotting, launched-for-asg",
+		},
+		{
+			name:      "tag namespace",
+			prefix:    "acme:",
+			lifecycle: Spot,
+			tags: []*ec2.Tag{
+				{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("asg")},
+				{Key: aws.String("acme:" + launchedByAutoSpottingTag), Value: aws.String("true")},
+			},
+			expectedAction: "delete the tags",
+			expectedIssue:  "tagged with acme:launched-by-autospotting",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId:        aws.String("i-1"),
+					InstanceLifecycle: aws.String(tt.lifecycle),
+					Tags:              tt.tags,
+				},
+				region: &region{name: "us-east-1", conf: &Config{TagKeyPrefix: tt.prefix}},
+			}
+
+			f := i.cleanupFinding()
+
+			if tt.expectedAction == "" {
+				if f != nil {
+					t.Errorf("cleanupFinding() = %v, expected nil", f)
+				}
+				return
+			}
+			if f == nil {
+				t.Fatalf("cleanupFinding() = nil, expected %q", tt.expectedAction)
+			}
+			if f.action != tt.expectedAction || f.issue != tt.expectedIssue {
+				t.Errorf("cleanupFinding() = %q/%q, expected %q/%q",
+					f.issue, f.action, tt.expectedIssue, tt.expectedAction)
+			}
+		})
+	}
+}
+
+func Test_region_danglingSpotRequests(t *testing.T) {
+	r := &region{
+		name:      "us-east-1",
+		conf:      &Config{},
+		instances: makeInstances(),
+		services: connections{ec2: mockEC2{
+			dsiro: &ec2.DescribeSpotInstanceRequestsOutput{
+				SpotInstanceRequests: []*ec2.SpotInstanceRequest{
+					{SpotInstanceRequestId: aws.String("sir-running"), InstanceId: aws.String("i-running")},
+					{SpotInstanceRequestId: aws.String("sir-stopped"), InstanceId: aws.String("i-stopped")},
+					{SpotInstanceRequestId: aws.String("sir-other"), InstanceId: aws.String("i-other")},
+					{SpotInstanceRequestId: aws.String("sir-open")},
+				},
+			},
+			dio: &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+				Instances: []*ec2.Instance{
+					{
+						InstanceId: aws.String("i-stopped"),
+						State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameStopped)},
+						Tags: []*ec2.Tag{
+							{Key: aws.String(launchedByAutoSpottingTag), Value: aws.String("true")},
+						},
+					},
+					{
+						InstanceId: aws.String("i-other"),
+						State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameStopped)},
+					},
+				},
+			}}},
+		}},
+	}
+	r.instances.add(&instance{Instance: &ec2.Instance{InstanceId: aws.String("i-running")}})
+
+	findings := r.danglingSpotRequests()
+
+	if len(findings) != 1 || findings[0].id != "sir-stopped" {
+		t.Fatalf("danglingSpotRequests() = %v, expected only sir-stopped", findings)
+	}
+	if expected := "persistent request of the stopped instance i-stopped"; findings[0].issue != expected {
+		t.Errorf("danglingSpotRequests() issue = %q, expected %q", findings[0].issue, expected)
+	}
+}
+
+func Test_region_cleanup(t *testing.T) {
+	tests := []struct {
+		name            string
+		apply           bool
+		tierr           error
+		expectedResults []string
+	}{
+		{name: "dry run", expectedResults: []string{"", ""}},
+		{name: "applied", apply: true, expectedResults: []string{"done", "done"}},
+		{
+			name:            "termination failure",
+			apply:           true,
+			tierr:           errors.New("unauthorized"),
+			expectedResults: []string{"done", "failed: unauthorized"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{},
+				services: connections{
+					autoScaling: mockASG{dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+						AutoScalingGroups: []*autoscaling.Group{{
+							AutoScalingGroupName: aws.String("asg"),
+							MaxSize:              aws.Int64(3),
+							Tags: []*autoscaling.TagDescription{
+								{Key: aws.String(SkipReasonsTag), Value: aws.String("protected=1")},
+							},
+						}},
+					}},
+					ec2: mockEC2{
+						tierr: tt.tierr,
+						dio: &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+							Instances: []*ec2.Instance{{
+								InstanceId:        aws.String("i-orphan"),
+								InstanceType:      aws.String("m5.large"),
+								InstanceLifecycle: aws.String(Spot),
+								State:             &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+								Tags: []*ec2.Tag{
+									{Key: aws.String(launchedByAutoSpottingTag), Value: aws.String("true")},
+								},
+							}},
+						}}},
+					},
+				},
+			}
+
+			findings := r.cleanup(tt.apply)
+
+			if len(findings) != len(tt.expectedResults) {
+				t.Fatalf("cleanup() = %v, expected %d findings", findings, len(tt.expectedResults))
+			}
+			for n, f := range findings {
+				if f.result != tt.expectedResults[n] {
+					t.Errorf("cleanup()[%d] %s %s result = %q, expected %q",
+						n, f.resource, f.id, f.result, tt.expectedResults[n])
+				}
+			}
+		})
+	}
+}
+
+func Test_writeCleanupReport(t *testing.T) {
+	findings := []cleanupFinding{{
+		region:   "us-east-1",
+		resource: "instance",
+		id:       "i-orphan",
+		issue:    "orphan spot instance launched for asg",
+		action:   "terminate the instance",
+		result:   "done",
+	}}
+
+	tests := []struct {
+		name     string
+		apply    bool
+		expected []string
+	}{
+		{
+			name:     "dry run",
+			expected: []string{"i-orphan", "dry run", "run with --cleanup_apply"},
+		},
+		{
+			name:     "applied",
+			apply:    true,
+			expected: []string{"i-orphan", "done", "1 leftover resources found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeCleanupReport(&out, findings, tt.apply); err != nil {
+				t.Fatalf("writeCleanupReport() error = %v", err)
+			}
+			for _, s := range tt.expected {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("writeCleanupReport() = %q, expected to contain %q", out.String(), s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import "time"
+
+// Clock provides the current time and sleeps between retries. It's used for
+// all the time-based decisions, such as the grace period of new instances and
+// the cron schedule, so that tests can simulate the passing of time instead of
+// depending on the system clock.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// systemClock is the Clock backed by the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// getClock returns the configured clock, defaulting to the system clock.
+func (cfg *Config) getClock() Clock {
+	if cfg == nil || cfg.clock == nil {
+		return systemClock{}
+	}
+	return cfg.clock
+}
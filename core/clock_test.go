@@ -0,0 +1,30 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig_getClock(t *testing.T) {
+	var nilConfig *Config
+	if _, ok := nilConfig.getClock().(systemClock); !ok {
+		t.Errorf("getClock() on nil config should return the system clock")
+	}
+
+	if _, ok := (&Config{}).getClock().(systemClock); !ok {
+		t.Errorf("getClock() without clock should return the system clock")
+	}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &mockClock{now: start}
+	cfg := &Config{clock: clock}
+
+	cfg.getClock().Sleep(time.Minute)
+
+	if got := cfg.getClock().Now(); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("getClock().Now() = %v, expected the configured clock to advance", got)
+	}
+}
@@ -0,0 +1,450 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// deployedScheduleExpression is the default of how often the deployed Lambda
+// function runs.
+const deployedScheduleExpression = "rate(5 minutes)"
+
+// cfnTemplate is a CloudFormation template, rendered as JSON which is also
+// accepted by CloudFormation.
+type cfnTemplate struct {
+	AWSTemplateFormatVersion string                  `json:"AWSTemplateFormatVersion"`
+	Description              string                  `json:"Description"`
+	Parameters               map[string]cfnParameter `json:"Parameters,omitempty"`
+	Resources                map[string]cfnResource  `json:"Resources"`
+	Outputs                  map[string]cfnOutput    `json:"Outputs,omitempty"`
+}
+
+type cfnParameter struct {
+	Type          string   `json:"Type"`
+	Description   string   `json:"Description,omitempty"`
+	Default       string   `json:"Default"`
+	AllowedValues []string `json:"AllowedValues,omitempty"`
+}
+
+type cfnResource struct {
+	Type       string                 `json:"Type"`
+	DependsOn  []string               `json:"DependsOn,omitempty"`
+	Properties map[string]interface{} `json:"Properties"`
+}
+
+type cfnOutput struct {
+	Description string      `json:"Description,omitempty"`
+	Value       interface{} `json:"Value"`
+}
+
+// iamPolicyDocument is an IAM policy, used inline in the templates.
+type iamPolicyDocument struct {
+	Version   string         `json:"Version"`
+	Statement []iamStatement `json:"Statement"`
+}
+
+type iamStatement struct {
+	Sid       string                       `json:"Sid,omitempty"`
+	Effect    string                       `json:"Effect"`
+	Principal map[string]string            `json:"Principal,omitempty"`
+	Action    []string                     `json:"Action"`
+	Resource  interface{}                  `json:"Resource,omitempty"`
+	Condition map[string]map[string]string `json:"Condition,omitempty"`
+}
+
+func cfnRef(name string) map[string]interface{} {
+	return map[string]interface{}{"Ref": name}
+}
+
+func cfnGetAtt(resource, attribute string) map[string]interface{} {
+	return map[string]interface{}{"Fn::GetAtt": []string{resource, attribute}}
+}
+
+func cfnSub(value string) map[string]interface{} {
+	return map[string]interface{}{"Fn::Sub": value}
+}
+
+// deployedFlag is a flag given to the deploy command, which becomes part of
+// the configuration of the deployed Lambda function.
+type deployedFlag struct {
+	name  string
+	value string
+	usage string
+}
+
+// parameterName names the template parameter set from the flag, such as
+// TagFilteringMode for tag_filtering_mode.
+func (f deployedFlag) parameterName() string {
+	name := ""
+	for _, word := range strings.FieldsFunc(f.name, func(c rune) bool { return c == '_' || c == '-' }) {
+		name += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return name
+}
+
+// description returns the usage of the flag without its examples, within the
+// size limit of the parameter descriptions.
+func (f deployedFlag) description() string {
+	usage := f.usage
+	if i := strings.Index(usage, "Example:"); i >= 0 {
+		usage = usage[:i]
+	}
+	usage = strings.Join(strings.Fields(usage), " ")
+	if len(usage) > 4000 {
+		usage = usage[:4000]
+	}
+	return usage
+}
+
+// templateFeature adds the resources of an optional feature to the template,
+// when enabled by the configuration. It may also change the configuration of
+// the deployed function, such as for using the resources it created instead
+// of creating them at runtime, which then determines its permissions.
+type templateFeature struct {
+	enabled func(conf *Config) bool
+	add     func(b *templateBuilder)
+}
+
+// templateFeatures are the optional features with resources of their own.
+var templateFeatures = []templateFeature{
+	{
+		enabled: func(conf *Config) bool { return !conf.DisableEventBasedInstanceReplacement },
+		add:     addEventBasedReplacement,
+	},
+	{
+		enabled: func(conf *Config) bool { return conf.SQSQueueURL == "" && !conf.DisableEventBasedInstanceReplacement },
+		add:     addSQSQueue,
+	},
+	{
+		enabled: func(conf *Config) bool { return conf.HeartbeatMetric && conf.HeartbeatAlarmStaleness > 0 },
+		add:     addHeartbeatAlarm,
+	},
+}
+
+// lambdaLogsActions are the IAM actions needed for the logs of the Lambda
+// function, on top of those needed by AutoSpotting itself.
+var lambdaLogsActions = []string{
+	"logs:CreateLogGroup",
+	"logs:CreateLogStream",
+	"logs:PutLogEvents",
+}
+
+// templateBuilder renders the template of a regional AutoSpotting deployment,
+// from the configuration given to the deploy command.
+type templateBuilder struct {
+	template cfnTemplate
+
+	// the configuration of the deployed function, as changed by the features
+	conf  Config
+	flags []deployedFlag
+
+	// the environment variables and the IAM policy statements set by the
+	// features, and the resources replacing the ones of the policy statements
+	env        map[string]interface{}
+	statements []iamStatement
+	resources  map[string]interface{}
+}
+
+// renderTemplate renders the template of a regional AutoSpotting deployment.
+// The flags given to the deploy command become parameters defaulting to
+// their given values, and each enabled feature adds its own resources and
+// the permissions it needs.
+func renderTemplate(conf *Config) cfnTemplate {
+	b := &templateBuilder{
+		template: cfnTemplate{
+			AWSTemplateFormatVersion: "2010-09-09",
+			Description:              "AutoSpotting " + conf.Version + ", implementing spot instance automation",
+			Parameters:               make(map[string]cfnParameter),
+			Resources:                make(map[string]cfnResource),
+			Outputs:                  make(map[string]cfnOutput),
+		},
+		conf:  *conf,
+		flags: append([]deployedFlag(nil), conf.deployedFlags...),
+		env:   make(map[string]interface{}),
+		statements: []iamStatement{{
+			Sid:      "Logs",
+			Effect:   "Allow",
+			Action:   lambdaLogsActions,
+			Resource: cfnSub("arn:${AWS::Partition}:logs:${AWS::Region}:${AWS::AccountId}:log-group:/aws/lambda/*"),
+		}},
+		resources: make(map[string]interface{}),
+	}
+
+	for _, feature := range templateFeatures {
+		if feature.enabled(&b.conf) {
+			feature.add(b)
+		}
+	}
+	b.addFunction()
+	return b.template
+}
+
+// dropFlag removes the flag from the configuration of the deployed function,
+// when the template took over its handling.
+func (b *templateBuilder) dropFlag(name string) {
+	var flags []deployedFlag
+	for _, f := range b.flags {
+		if f.name != name {
+			flags = append(flags, f)
+		}
+	}
+	b.flags = flags
+}
+
+func (b *templateBuilder) addParameter(name string, p cfnParameter) {
+	b.template.Parameters[name] = p
+}
+
+func (b *templateBuilder) addResource(name string, r cfnResource) {
+	b.template.Resources[name] = r
+}
+
+// addEventRule adds a rule triggering the function, along with the
+// permission allowing it.
+func (b *templateBuilder) addEventRule(name, description string, properties map[string]interface{}) {
+	properties["Description"] = description
+	properties["State"] = "ENABLED"
+	properties["Targets"] = []map[string]interface{}{{
+		"Id":  "AutoSpotting",
+		"Arn": cfnGetAtt("LambdaFunction", "Arn"),
+	}}
+	b.addResource(name, cfnResource{Type: "AWS::Events::Rule", Properties: properties})
+	b.addResource(name+"Permission", cfnResource{
+		Type: "AWS::Lambda::Permission",
+		Properties: map[string]interface{}{
+			"Action":       "lambda:InvokeFunction",
+			"FunctionName": cfnRef("LambdaFunction"),
+			"Principal":    "events.amazonaws.com",
+			"SourceArn":    cfnGetAtt(name, "Arn"),
+		},
+	})
+}
+
+// addFunction adds the Lambda function and the resources it always needs:
+// its role, log group and schedule, and the handling of the spot instance
+// interruptions.
+func (b *templateBuilder) addFunction() {
+	// the buckets named after their regions can't be parameters, since
+	// Fn::Sub only accepts literal strings
+	var bucket interface{} = cfnRef("LambdaPackageBucket")
+	if strings.Contains(b.conf.DeployBucket, regionPlaceholder) {
+		bucket = cfnSub(strings.Replace(b.conf.DeployBucket, regionPlaceholder, "${AWS::Region}", -1))
+	} else {
+		b.addParameter("LambdaPackageBucket", cfnParameter{
+			Type:        "String",
+			Description: "S3 bucket of the Lambda function package",
+			Default:     b.conf.DeployBucket,
+		})
+	}
+	b.addParameter("LambdaPackageKey", cfnParameter{
+		Type:        "String",
+		Description: "S3 key of the Lambda function package",
+	})
+	b.addParameter("LambdaArchitecture", cfnParameter{
+		Type:          "String",
+		Description:   "Instruction set architecture the Lambda function package is built for",
+		Default:       "x86_64",
+		AllowedValues: []string{"x86_64", "arm64"},
+	})
+	b.addParameter("LambdaMemorySize", cfnParameter{
+		Type:        "Number",
+		Description: "Memory allocated to the Lambda function",
+		Default:     "1024",
+	})
+	b.addParameter("LogRetentionPeriod", cfnParameter{
+		Type:        "Number",
+		Description: "Number of days to keep the Lambda function logs in CloudWatch",
+		Default:     "7",
+	})
+	b.addParameter("ExecutionFrequency", cfnParameter{
+		Type:        "String",
+		Description: "Frequency of executing the Lambda function, as rate or cron expression",
+		Default:     deployedScheduleExpression,
+	})
+
+	b.addResource("LambdaExecutionRole", cfnResource{
+		Type: "AWS::IAM::Role",
+		Properties: map[string]interface{}{
+			"AssumeRolePolicyDocument": iamPolicyDocument{
+				Version: "2012-10-17",
+				Statement: []iamStatement{{
+					Effect:    "Allow",
+					Principal: map[string]string{"Service": "lambda.amazonaws.com"},
+					Action:    []string{"sts:AssumeRole"},
+				}},
+			},
+			"Policies": []map[string]interface{}{{
+				"PolicyName":     "AutoSpotting",
+				"PolicyDocument": b.policy(),
+			}},
+		},
+	})
+
+	b.addResource("LambdaFunction", cfnResource{
+		Type: "AWS::Lambda::Function",
+		Properties: map[string]interface{}{
+			"Description":   "Implements Spot instance automation",
+			"Runtime":       "provided.al2",
+			"Architectures": []interface{}{cfnRef("LambdaArchitecture")},
+			"Handler":       "bootstrap",
+			"Code": map[string]interface{}{
+				"S3Bucket": bucket,
+				"S3Key":    cfnRef("LambdaPackageKey"),
+			},
+			"Environment": map[string]interface{}{"Variables": b.environment()},
+			"MemorySize":  cfnRef("LambdaMemorySize"),
+			"Timeout":     900,
+			"Role":        cfnGetAtt("LambdaExecutionRole", "Arn"),
+		},
+	})
+
+	b.addResource("LogGroup", cfnResource{
+		Type: "AWS::Logs::LogGroup",
+		Properties: map[string]interface{}{
+			"LogGroupName":    cfnSub("/aws/lambda/${LambdaFunction}"),
+			"RetentionInDays": cfnRef("LogRetentionPeriod"),
+		},
+	})
+
+	b.addEventRule("ScheduledRule", "ScheduledRule for launching the AutoSpotting Lambda function",
+		map[string]interface{}{"ScheduleExpression": cfnRef("ExecutionFrequency")})
+
+	detailTypes := []string{"EC2 Spot Instance Interruption Warning"}
+	if !b.conf.DisableInstanceRebalanceRecommendation {
+		detailTypes = append(detailTypes, "EC2 Instance Rebalance Recommendation")
+	}
+	b.addEventRule("SpotTerminationRule", "Handles the spot instance interruptions",
+		map[string]interface{}{"EventPattern": map[string]interface{}{
+			"source":      []string{"aws.ec2"},
+			"detail-type": detailTypes,
+		}})
+
+	b.template.Outputs["LambdaFunction"] = cfnOutput{
+		Description: "The AutoSpotting Lambda function",
+		Value:       cfnGetAtt("LambdaFunction", "Arn"),
+	}
+}
+
+// addEventBasedReplacement replaces the on-demand instances as soon as they
+// are running, and handles the lifecycle hooks failed to be completed.
+func addEventBasedReplacement(b *templateBuilder) {
+	b.addEventRule("InstanceRunningRule", "Replaces the on-demand instances as soon as they are running",
+		map[string]interface{}{"EventPattern": map[string]interface{}{
+			"source":      []string{"aws.ec2"},
+			"detail-type": []string{"EC2 Instance State-change Notification"},
+			"detail":      map[string][]string{"state": {"running"}},
+		}})
+
+	b.addEventRule("LifecycleHookRule", "Triggered after failing to complete a lifecycle hook",
+		map[string]interface{}{"EventPattern": map[string]interface{}{
+			"source":      []string{"aws.autoscaling"},
+			"detail-type": []string{"AWS API Call via CloudTrail"},
+			"detail": map[string]interface{}{
+				"eventName":         []string{"CompleteLifecycleAction"},
+				"errorCode":         []string{"ValidationException"},
+				"requestParameters": map[string][]string{"lifecycleActionResult": {"CONTINUE"}},
+			},
+		}})
+}
+
+// addSQSQueue creates the FIFO queue serializing the replacements of the
+// instances of each group, consumed by the function.
+func addSQSQueue(b *templateBuilder) {
+	b.addResource("SQSQueue", cfnResource{
+		Type: "AWS::SQS::Queue",
+		Properties: map[string]interface{}{
+			"ContentBasedDeduplication": true,
+			"FifoQueue":                 true,
+			"MessageRetentionPeriod":    86400,
+			// the generated name would be too long within StackSets
+			"QueueName":         cfnSub("${AWS::StackName}.fifo"),
+			"VisibilityTimeout": 900,
+		},
+	})
+	b.addResource("LambdaEventSourceMapping", cfnResource{
+		Type:      "AWS::Lambda::EventSourceMapping",
+		DependsOn: []string{"LambdaExecutionRole"},
+		Properties: map[string]interface{}{
+			"BatchSize":      1,
+			"EventSourceArn": cfnGetAtt("SQSQueue", "Arn"),
+			"FunctionName":   cfnRef("LambdaFunction"),
+		},
+	})
+
+	b.conf.SQSQueueURL = "SQSQueue"
+	b.env[flagEnvName("sqs_queue_url")] = cfnRef("SQSQueue")
+	b.resources[sqsQueueStatement] = cfnGetAtt("SQSQueue", "Arn")
+	b.statements = append(b.statements, iamStatement{
+		Sid:      "SQSQueueEventSource",
+		Effect:   "Allow",
+		Action:   []string{"sqs:GetQueueAttributes"},
+		Resource: cfnGetAtt("SQSQueue", "Arn"),
+	})
+}
+
+// addHeartbeatAlarm creates the heartbeat alarm, which would otherwise be
+// maintained by the function.
+func addHeartbeatAlarm(b *templateBuilder) {
+	input := heartbeatAlarmInput(b.conf.HeartbeatAlarmStaleness, b.conf.HeartbeatAlarmTopic)
+
+	properties := map[string]interface{}{
+		"AlarmDescription":   *input.AlarmDescription,
+		"Namespace":          *input.Namespace,
+		"MetricName":         *input.MetricName,
+		"Statistic":          *input.Statistic,
+		"Period":             *input.Period,
+		"EvaluationPeriods":  *input.EvaluationPeriods,
+		"Threshold":          *input.Threshold,
+		"ComparisonOperator": *input.ComparisonOperator,
+		"TreatMissingData":   *input.TreatMissingData,
+	}
+	if b.conf.HeartbeatAlarmTopic != "" {
+		properties["AlarmActions"] = []string{b.conf.HeartbeatAlarmTopic}
+		properties["OKActions"] = []string{b.conf.HeartbeatAlarmTopic}
+	}
+	b.addResource("HeartbeatAlarm", cfnResource{Type: "AWS::CloudWatch::Alarm", Properties: properties})
+
+	b.conf.HeartbeatAlarmStaleness = 0
+	b.dropFlag("heartbeat_alarm_staleness")
+	b.dropFlag("heartbeat_alarm_topic")
+}
+
+// environment returns the environment variables of the function, set from
+// the parameters of the flags given to the deploy command and from the
+// features. Unless configured otherwise, each regional function only
+// processes its own region.
+func (b *templateBuilder) environment() map[string]interface{} {
+	env := make(map[string]interface{})
+	for _, f := range b.flags {
+		b.addParameter(f.parameterName(), cfnParameter{
+			Type:        "String",
+			Description: f.description(),
+			Default:     f.value,
+		})
+		env[flagEnvName(f.name)] = cfnRef(f.parameterName())
+	}
+	for name, value := range b.env {
+		env[name] = value
+	}
+	if _, found := env["REGIONS"]; !found {
+		env["REGIONS"] = cfnRef("AWS::Region")
+	}
+	return env
+}
+
+// policy returns the least privilege policy of the function, allowing the
+// actions needed with its configuration.
+func (b *templateBuilder) policy() iamPolicyDocument {
+	policy := leastPrivilegePolicy(&b.conf, b.resources)
+	policy.Statement = append(policy.Statement, b.statements...)
+	return policy
+}
+
+// JSON renders the template.
+func (t cfnTemplate) JSON() (string, error) {
+	body, err := json.MarshalIndent(t, "", "  ")
+	return string(body), err
+}
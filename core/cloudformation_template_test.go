@@ -0,0 +1,235 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_deployedFlag_parameterName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "regions", want: "Regions"},
+		{name: "tag_filtering_mode", want: "TagFilteringMode"},
+		{name: "sqs_queue_url", want: "SqsQueueUrl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (deployedFlag{name: tt.name}).parameterName(); got != tt.want {
+				t.Errorf("parameterName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deployedFlag_description(t *testing.T) {
+	f := deployedFlag{usage: "\n\tControls the behavior of the tag_filters option.\n\tExample: ./AutoSpotting --tag_filtering_mode opt-out\n"}
+
+	if got := f.description(); got != "Controls the behavior of the tag_filters option." {
+		t.Errorf("description() = %q", got)
+	}
+}
+
+// renderedTemplate is the subset of a rendered template checked by the tests.
+type renderedTemplate struct {
+	Parameters map[string]cfnParameter
+	Resources  map[string]struct {
+		Type       string
+		Properties map[string]json.RawMessage
+	}
+}
+
+func render(t *testing.T, conf *Config) renderedTemplate {
+	body, err := renderTemplate(conf).JSON()
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+
+	var got renderedTemplate
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("the rendered template isn't valid JSON: %v", err)
+	}
+	return got
+}
+
+func (r renderedTemplate) resources() []string {
+	var names []string
+	for name := range r.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r renderedTemplate) property(t *testing.T, resource, name string, value interface{}) {
+	if err := json.Unmarshal(r.Resources[resource].Properties[name], value); err != nil {
+		t.Fatalf("couldn't parse the %s property of %s: %v", name, resource, err)
+	}
+}
+
+func (r renderedTemplate) actions(t *testing.T) map[string]bool {
+	var policies []struct{ PolicyDocument iamPolicyDocument }
+	r.property(t, "LambdaExecutionRole", "Policies", &policies)
+
+	actions := make(map[string]bool)
+	for _, statement := range policies[0].PolicyDocument.Statement {
+		for _, action := range statement.Action {
+			actions[action] = true
+		}
+	}
+	return actions
+}
+
+func Test_renderTemplate(t *testing.T) {
+	base := []string{
+		"LambdaExecutionRole", "LambdaFunction", "LogGroup",
+		"ScheduledRule", "ScheduledRulePermission",
+		"SpotTerminationRule", "SpotTerminationRulePermission",
+	}
+	eventBased := []string{
+		"InstanceRunningRule", "InstanceRunningRulePermission",
+		"LifecycleHookRule", "LifecycleHookRulePermission",
+	}
+
+	tests := []struct {
+		name            string
+		conf            Config
+		wantResources   [][]string
+		wantActions     []string
+		unwantedActions []string
+		wantEnv         map[string]interface{}
+	}{
+		{
+			name:          "default features",
+			conf:          Config{},
+			wantResources: [][]string{base, eventBased, {"LambdaEventSourceMapping", "SQSQueue"}},
+			wantActions:   []string{"ec2:RunInstances", "logs:PutLogEvents", "sqs:GetQueueAttributes", "sqs:ReceiveMessage"},
+			wantEnv: map[string]interface{}{
+				"REGIONS":       map[string]interface{}{"Ref": "AWS::Region"},
+				"SQS_QUEUE_URL": map[string]interface{}{"Ref": "SQSQueue"},
+			},
+		},
+		{
+			name: "cron mode",
+			conf: Config{
+				DisableEventBasedInstanceReplacement: true,
+				deployedFlags: []deployedFlag{
+					{name: "disable_event_based_instance_replacement", value: "true"},
+					{name: "regions", value: "eu-*"},
+				},
+			},
+			wantResources:   [][]string{base},
+			unwantedActions: []string{"sqs:ReceiveMessage", "cloudwatch:PutMetricData"},
+			wantEnv: map[string]interface{}{
+				"DISABLE_EVENT_BASED_INSTANCE_REPLACEMENT": map[string]interface{}{"Ref": "DisableEventBasedInstanceReplacement"},
+				"REGIONS": map[string]interface{}{"Ref": "Regions"},
+			},
+		},
+		{
+			name: "existing queue and heartbeat alarm",
+			conf: Config{
+				SQSQueueURL:             "https://sqs.us-east-1.amazonaws.com/123456789012/autospotting.fifo",
+				HeartbeatMetric:         true,
+				HeartbeatAlarmStaleness: time.Hour,
+				deployedFlags: []deployedFlag{
+					{name: "heartbeat_alarm_staleness", value: "1h0m0s"},
+					{name: "heartbeat_metric", value: "true"},
+					{name: "sqs_queue_url", value: "https://sqs.us-east-1.amazonaws.com/123456789012/autospotting.fifo"},
+				},
+			},
+			wantResources:   [][]string{base, eventBased, {"HeartbeatAlarm"}},
+			wantActions:     []string{"cloudwatch:PutMetricData", "sqs:ReceiveMessage"},
+			unwantedActions: []string{"cloudwatch:PutMetricAlarm", "sqs:GetQueueAttributes"},
+			wantEnv: map[string]interface{}{
+				"HEARTBEAT_METRIC": map[string]interface{}{"Ref": "HeartbeatMetric"},
+				"REGIONS":          map[string]interface{}{"Ref": "AWS::Region"},
+				"SQS_QUEUE_URL":    map[string]interface{}{"Ref": "SqsQueueUrl"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render(t, &tt.conf)
+
+			var wantResources []string
+			for _, resources := range tt.wantResources {
+				wantResources = append(wantResources, resources...)
+			}
+			sort.Strings(wantResources)
+			if !reflect.DeepEqual(got.resources(), wantResources) {
+				t.Errorf("renderTemplate() resources = %v, want %v", got.resources(), wantResources)
+			}
+
+			actions := got.actions(t)
+			for _, action := range tt.wantActions {
+				if !actions[action] {
+					t.Errorf("renderTemplate() policy is missing the %s action", action)
+				}
+			}
+			for _, action := range tt.unwantedActions {
+				if actions[action] {
+					t.Errorf("renderTemplate() policy has the unneeded %s action", action)
+				}
+			}
+
+			var env struct{ Variables map[string]interface{} }
+			got.property(t, "LambdaFunction", "Environment", &env)
+			if !reflect.DeepEqual(env.Variables, tt.wantEnv) {
+				t.Errorf("renderTemplate() environment = %v, want %v", env.Variables, tt.wantEnv)
+			}
+
+			for _, f := range tt.conf.deployedFlags {
+				if _, found := tt.wantEnv[flagEnvName(f.name)]; !found {
+					continue
+				}
+				if p := got.Parameters[f.parameterName()]; p.Default != f.value {
+					t.Errorf("renderTemplate() parameter %s defaults to %q, want %q", f.parameterName(), p.Default, f.value)
+				}
+			}
+		})
+	}
+}
+
+func Test_renderTemplate_packageBucket(t *testing.T) {
+	tests := []struct {
+		name          string
+		bucket        string
+		want          interface{}
+		wantParameter bool
+	}{
+		{
+			name:          "shared bucket",
+			bucket:        "my-bucket",
+			want:          map[string]interface{}{"Ref": "LambdaPackageBucket"},
+			wantParameter: true,
+		},
+		{
+			name:   "regional buckets",
+			bucket: "my-bucket-" + regionPlaceholder,
+			want:   map[string]interface{}{"Fn::Sub": "my-bucket-${AWS::Region}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := render(t, &Config{DeployBucket: tt.bucket})
+
+			var code struct{ S3Bucket interface{} }
+			got.property(t, "LambdaFunction", "Code", &code)
+			if !reflect.DeepEqual(code.S3Bucket, tt.want) {
+				t.Errorf("renderTemplate() bucket = %v, want %v", code.S3Bucket, tt.want)
+			}
+
+			p, found := got.Parameters["LambdaPackageBucket"]
+			if found != tt.wantParameter || (found && p.Default != tt.bucket) {
+				t.Errorf("renderTemplate() bucket parameter = %v, %v", p, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// candidateEvaluationWorkers is the maximum number of spot candidate instance
+// types evaluated concurrently for each replaced instance.
+const candidateEvaluationWorkers = 8
+
+// typeCompatibilityKey identifies the outcome of the compatibility checks of a
+// candidate instance type, which is the same for all the instances of the
+// same type, group and virtualization type using the same instance store
+// volumes.
+type typeCompatibilityKey struct {
+	asg             string
+	current         string
+	candidate       string
+	virtualization  string
+	attachedVolumes int
+}
+
+// isTypeCompatible runs the compatibility checks of a candidate instance type
+// that don't depend on its price or on the instance's zone, memoizing their
+// outcome for the duration of the run.
+func (i *instance) isTypeCompatible(candidate instanceTypeInformation, attachedVolumes int) bool {
+	key := typeCompatibilityKey{
+		current:         i.typeInfo.instanceType,
+		candidate:       candidate.instanceType,
+		virtualization:  aws.StringValue(i.VirtualizationType),
+		attachedVolumes: attachedVolumes,
+	}
+	if i.asg != nil {
+		key.asg = i.asg.name
+	}
+
+	r := i.region
+	r.typeCompatibilityLock.Lock()
+	compatible, found := r.typeCompatibility[key]
+	r.typeCompatibilityLock.Unlock()
+
+	if found {
+		return compatible
+	}
+
+	compatible = i.failedTypeCompatibilityCheck(candidate, attachedVolumes) == ""
+
+	r.typeCompatibilityLock.Lock()
+	if r.typeCompatibility == nil {
+		r.typeCompatibility = make(map[typeCompatibilityKey]bool)
+	}
+	r.typeCompatibility[key] = compatible
+	r.typeCompatibilityLock.Unlock()
+
+	return compatible
+}
+
+// typeCompatibilityCheck is one of the compatibility checks of the candidate
+// instance types which don't depend on their price or on the instance's zone.
+type typeCompatibilityCheck struct {
+	name   string
+	passes func(candidate instanceTypeInformation) bool
+}
+
+// typeCompatibilityChecks returns the compatibility checks of the candidate
+// instance types, in the order they're run.
+func (i *instance) typeCompatibilityChecks(attachedVolumes int) []typeCompatibilityCheck {
+	return []typeCompatibilityCheck{
+		{"EBS optimization", i.isEBSCompatible},
+		{"instance requirements", func(candidate instanceTypeInformation) bool {
+			return i.meetsRequirements(candidate, attachedVolumes)
+		}},
+		{"size", i.isSizeCompatible},
+		{"CPU vendor", i.isCPUVendorCompatible},
+		{"license constraints", i.isLicenseCompatible},
+		{"virtualization type", func(candidate instanceTypeInformation) bool {
+			return i.isVirtualizationCompatible(candidate.virtualizationTypes)
+		}},
+	}
+}
+
+// failedTypeCompatibilityCheck returns the name of the first compatibility
+// check failed by the candidate instance type, or an empty string if it
+// passes all of them.
+func (i *instance) failedTypeCompatibilityCheck(candidate instanceTypeInformation, attachedVolumes int) string {
+	for _, check := range i.typeCompatibilityChecks(attachedVolumes) {
+		if !check.passes(candidate) {
+			return check.name
+		}
+	}
+	return ""
+}
+
+// evaluateCandidates runs the evaluate function for all the candidates using
+// a bounded number of goroutines, returning the accepted ones in the same
+// order as the candidates were given.
+func evaluateCandidates(candidates []instanceTypeInformation,
+	evaluate func(instanceTypeInformation) (acceptableInstance, bool)) []acceptableInstance {
+
+	results := make([]*acceptableInstance, len(candidates))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, candidateEvaluationWorkers)
+
+	for n, candidate := range candidates {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n int, candidate instanceTypeInformation) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if ai, ok := evaluate(candidate); ok {
+				results[n] = &ai
+			}
+		}(n, candidate)
+	}
+	wg.Wait()
+
+	var accepted []acceptableInstance
+	for _, ai := range results {
+		if ai != nil {
+			accepted = append(accepted, *ai)
+		}
+	}
+	return accepted
+}
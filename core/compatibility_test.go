@@ -0,0 +1,121 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_evaluateCandidates(t *testing.T) {
+	var candidates []instanceTypeInformation
+	for _, it := range []string{"a1.large", "c5.large", "m5.large", "m5a.large", "r5.large",
+		"t3.large", "t3a.large", "x1.large", "z1d.large", "m6g.large"} {
+		candidates = append(candidates, instanceTypeInformation{instanceType: it})
+	}
+
+	tests := []struct {
+		name     string
+		accept   func(instanceTypeInformation) bool
+		expected []string
+	}{
+		{
+			name:     "none accepted",
+			accept:   func(instanceTypeInformation) bool { return false },
+			expected: nil,
+		},
+		{
+			name:   "all accepted in order",
+			accept: func(instanceTypeInformation) bool { return true },
+			expected: []string{"a1.large", "c5.large", "m5.large", "m5a.large", "r5.large",
+				"t3.large", "t3a.large", "x1.large", "z1d.large", "m6g.large"},
+		},
+		{
+			name: "some accepted in order",
+			accept: func(c instanceTypeInformation) bool {
+				return c.instanceType[0] == 'm' || c.instanceType[0] == 't'
+			},
+			expected: []string{"m5.large", "m5a.large", "t3.large", "t3a.large", "m6g.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evaluateCandidates(candidates,
+				func(c instanceTypeInformation) (acceptableInstance, bool) {
+					return acceptableInstance{instanceTI: c}, tt.accept(c)
+				})
+
+			var types []string
+			for _, ai := range got {
+				types = append(types, ai.instanceTI.instanceType)
+			}
+			if !reflect.DeepEqual(types, tt.expected) {
+				t.Errorf("evaluateCandidates() = %v, expected %v", types, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isTypeCompatible(t *testing.T) {
+	current := instanceTypeInformation{
+		instanceType:      "m5.large",
+		PhysicalProcessor: "Intel",
+		vCPU:              2,
+		memory:            8,
+	}
+	candidate := instanceTypeInformation{
+		instanceType:      "m5.xlarge",
+		PhysicalProcessor: "Intel",
+		vCPU:              4,
+		memory:            16,
+	}
+
+	tests := []struct {
+		name     string
+		memo     map[typeCompatibilityKey]bool
+		expected bool
+	}{
+		{
+			name:     "evaluated",
+			expected: true,
+		},
+		{
+			name: "memoized",
+			memo: map[typeCompatibilityKey]bool{
+				{asg: "asg", current: "m5.large", candidate: "m5.xlarge", virtualization: "hvm"}: false,
+			},
+			expected: false,
+		},
+		{
+			name: "memoized for another group",
+			memo: map[typeCompatibilityKey]bool{
+				{asg: "other", current: "m5.large", candidate: "m5.xlarge", virtualization: "hvm"}: false,
+			},
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{typeCompatibility: tt.memo}
+			i := &instance{
+				Instance: &ec2.Instance{VirtualizationType: aws.String("hvm")},
+				typeInfo: current,
+				region:   r,
+				asg:      &autoScalingGroup{name: "asg"},
+			}
+
+			if got := i.isTypeCompatible(candidate, 0); got != tt.expected {
+				t.Errorf("isTypeCompatible() = %v, expected %v", got, tt.expected)
+			}
+
+			key := typeCompatibilityKey{asg: "asg", current: "m5.large", candidate: "m5.xlarge", virtualization: "hvm"}
+			if got, found := r.typeCompatibility[key]; !found || got != tt.expected {
+				t.Errorf("memoized compatibility = %v/%v, expected %v", got, found, tt.expected)
+			}
+		})
+	}
+}
@@ -4,10 +4,13 @@
 package autospotting
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/endpoints"
@@ -74,6 +77,18 @@ type Config struct {
 	// The regions where it should be running, given as a single CSV-string
 	Regions string
 
+	// Per-region overrides of the availability of features such as the newer
+	// EBS volume types, given as a single CSV-string of
+	// region:feature=true|false entries
+	RegionFeatures string
+
+	// regionFeatures determines the features available in each region
+	regionFeatures *regionFeatures
+
+	// Per-region overrides of the on-demand price multiplier, given as a
+	// single CSV-string of region=multiplier pairs
+	RegionalOnDemandPriceMultipliers string
+
 	// The region where the Lambda function is deployed
 	MainRegion string
 
@@ -85,6 +100,29 @@ type Config struct {
 	// Filter on ASG tags
 	// for example: spot-enabled=true,environment=dev,team=interactive
 	FilterByTags string
+
+	// Profiles is the JSON list of the configuration profiles processed in
+	// isolation by each run, overriding the tag filters, regions,
+	// notification targets and budgets of the global configuration
+	Profiles string
+
+	// profileName is the name of the profile of this configuration, if any
+	profileName string
+
+	// OrganizationRole is the name of the role assumed in each member account
+	// of the organization, enabling the Organizations mode
+	OrganizationRole string
+
+	// OrganizationUnitsInclude and OrganizationUnitsExclude select the member
+	// accounts processed in the Organizations mode by their organizational
+	// units, roots or account IDs
+	OrganizationUnitsInclude string
+	OrganizationUnitsExclude string
+
+	// accountID and roleARN are set when processing a member account of the
+	// organization, whose role is assumed by the sessions
+	accountID string
+	roleARN   string
 	// Controls how are the tags used to filter the groups.
 	// Available options: 'opt-in' and 'opt-out', default: 'opt-in'
 	TagFilteringMode string
@@ -120,6 +158,290 @@ type Config struct {
 
 	// DisableInstanceRebalanceRecommendation disable the handling of Instance Rebalance Recommendation events.
 	DisableInstanceRebalanceRecommendation bool
+
+	// ReadOnly only reports the planned replacements and the potential savings
+	// of the enabled groups on each run, without making any changes, so that
+	// AutoSpotting can be evaluated using read-only IAM permissions
+	ReadOnly bool
+
+	// PermissionPreflight checks all the IAM permissions needed by the runs at
+	// startup and reports the missing ones
+	PermissionPreflight bool
+
+	// HeartbeatMetric emits a CloudWatch metric at the end of each completed
+	// run, which can be used for alarming on silent failures
+	HeartbeatMetric bool
+
+	// HeartbeatAlarmStaleness is the time without any completed run after
+	// which the heartbeat alarm maintained by AutoSpotting is triggered, 0
+	// disables the alarm
+	HeartbeatAlarmStaleness time.Duration
+
+	// HeartbeatAlarmTopic is the ARN of the SNS topic notified by the
+	// heartbeat alarm
+	HeartbeatAlarmTopic string
+
+	// StreamingScan processes each enabled group as soon as the pages of
+	// DescribeInstances results contain all its instances, instead of scanning
+	// all the instances of the region before processing any group.
+	StreamingScan bool
+
+	// DaemonMode keeps AutoSpotting running as a long-lived process which
+	// triggers the cron event logic from an internal scheduler, useful when
+	// running outside of Lambda on ECS, EC2 or Kubernetes.
+	DaemonMode bool
+
+	// DaemonInterval is the time between two consecutive runs in daemon mode
+	DaemonInterval time.Duration
+
+	// HealthCheckListenAddress is the address on which the health and readiness
+	// HTTP endpoints are served when running in daemon mode
+	HealthCheckListenAddress string
+
+	// LeaderElection controls the mechanism used for electing a single active
+	// replica when running multiple daemon instances
+	LeaderElection string
+
+	// LeaderElectionNamespace is the Kubernetes namespace of the leader election
+	// Lease, defaults to the namespace of the current pod
+	LeaderElectionNamespace string
+
+	// LeaderElectionLeaseName is the name of the Kubernetes leader election Lease
+	LeaderElectionLeaseName string
+
+	// LeaderElectionLeaseDuration is how long the leadership is kept without
+	// being renewed
+	LeaderElectionLeaseDuration time.Duration
+
+	// EndpointURL overrides the endpoint of all the AWS services, useful for
+	// VPC interface endpoints or for testing against LocalStack
+	EndpointURL string
+
+	// ServiceEndpoints overrides the endpoints of individual AWS services, such
+	// as FIPS endpoints, given as a CSV of service=url pairs
+	ServiceEndpoints string
+
+	// ProxyURL is the HTTP(S) proxy used for the AWS API calls, overriding the
+	// HTTP_PROXY and HTTPS_PROXY environment variables
+	ProxyURL string
+
+	// CustomCABundle is the path of a PEM file containing additional CA
+	// certificates trusted when making AWS API calls
+	CustomCABundle string
+
+	// MaxErrors is the number of failed actions after which the rest of the
+	// run only reports the actions it would take, 0 means unlimited
+	MaxErrors int64
+
+	// MaxErrorRate is the percentage of failed actions after which the rest of
+	// the run only reports the actions it would take, 0 means unlimited
+	MaxErrorRate float64
+
+	// errorBudget tracks the failed actions during the current run
+	errorBudget *errorBudget
+
+	// MaxAPICalls is the number of AWS API calls after which the rest of the
+	// run only reports the actions it would take, 0 means unlimited
+	MaxAPICalls int64
+
+	// APICallMetrics emits the number of AWS API calls made by each run as
+	// CloudWatch metrics, by service
+	APICallMetrics bool
+
+	// apiCalls counts the AWS API calls made during the current run
+	apiCalls *apiCallBudget
+
+	// runID identifies the current run on the instances it terminates
+	runID string
+
+	// launchFailures counts the spot instance launch failures of the current
+	// run by category
+	launchFailures *launchFailures
+
+	// MaxConcurrentSwaps is the maximum number of swaps in flight across all
+	// the regions, 0 means unlimited
+	MaxConcurrentSwaps int
+
+	// swapLimiter enforces MaxConcurrentSwaps
+	swapLimiter *swapLimiter
+
+	// ScheduledActionWindow is the time before and after the scheduled actions
+	// of the groups during which no swaps are started
+	ScheduledActionWindow time.Duration
+
+	// ExecutionTimeReserve is the time kept aside before the deadline of the
+	// execution for finishing the run and reporting its outcome
+	ExecutionTimeReserve time.Duration
+
+	// executionDeadline and memoryLimitMB are the limits of the current
+	// execution, such as those of the Lambda function invocation
+	executionDeadline time.Time
+	memoryLimitMB     int
+
+	// leadership is cancelled when the daemon replica loses the leadership
+	// during the current run, nil when not running as an elected daemon
+	leadership context.Context
+
+	// executionBudget tracks the remaining execution time of the current run
+	executionBudget *executionBudget
+
+	// clock is used for all the time-based decisions, it can be replaced in
+	// tests in order to simulate the passing of time
+	clock Clock
+
+	// RecordAPICalls is the file where all the AWS API calls are recorded
+	RecordAPICalls string
+
+	// ReplayAPICalls is the file from which previously recorded AWS API calls
+	// are replayed instead of calling AWS
+	ReplayAPICalls string
+
+	// apiRecorder records or replays the AWS API calls when configured
+	apiRecorder *apiRecorder
+
+	// SpotInstanceTags are additional tags set on the launched spot instances
+	// unless already set on the group or the original instance, given as a CSV
+	// of key=value pairs
+	SpotInstanceTags string
+
+	// Analyze only reports the potential savings and the blockers of all the
+	// groups, regardless of their tags, without making any changes
+	Analyze bool
+
+	// ExplainASG and ExplainInstance select the group, or the instance, whose
+	// evaluation is explained step by step, without making any changes
+	ExplainASG      string
+	ExplainInstance string
+
+	// Cleanup only reports the resources left over by AutoSpotting, which are
+	// also reverted when CleanupApply is set
+	Cleanup      bool
+	CleanupApply bool
+
+	// RevertASGs is the list of groups converted back to on-demand instances
+	RevertASGs string
+
+	// Deploy packages AutoSpotting as a Lambda function and deploys it with
+	// a StackSet, configured with the other flags given to the deploy command
+	Deploy                    bool
+	DeployBinary              string
+	DeployBucket              string
+	DeployStackSet            string
+	DeployAccounts            string
+	DeployOrganizationalUnits string
+	DeployRegions             string
+
+	// RenderTemplate only prints the template deployed by the deploy command
+	RenderTemplate bool
+
+	// IAMPolicy only prints the least privilege IAM policy needed with the
+	// rest of the configuration
+	IAMPolicy bool
+
+	// deployedFlags are the flags given to the deploy command, which become
+	// the configuration of the deployed Lambda function
+	deployedFlags []deployedFlag
+
+	// SavingsReconciliationInterval is how often the projected savings are
+	// compared with the realized savings reported by Cost Explorer, 0 disables
+	// the reconciliation
+	SavingsReconciliationInterval time.Duration
+
+	// lastSavingsReconciliation is when the savings were last reconciled
+	lastSavingsReconciliation time.Time
+
+	// ChaosMode enables the chaos testing of the spot interruption handling,
+	// available options: 'simulate' and 'terminate', disabled by default
+	ChaosMode string
+
+	// ChaosPercentage is the percentage of the spot instances launched by
+	// AutoSpotting interrupted on each run when chaos testing is enabled
+	ChaosPercentage float64
+
+	// SpotPriceHistoryDays is the number of days of spot price history used
+	// for ranking the spot instance types, 0 ranks them by current price only
+	SpotPriceHistoryDays int
+
+	// SpotPriceAverageWeight is the weight of the average price in the ranking
+	// score, between 0 and 1, the current price having the remaining weight
+	SpotPriceAverageWeight float64
+
+	// SpotPriceVolatilityWeight is the weight of the price's standard
+	// deviation added to the ranking score
+	SpotPriceVolatilityWeight float64
+
+	// MaxBidDeviationPercentage is how much the bid price may exceed the live
+	// spot price, as percentage, 0 disables the check
+	MaxBidDeviationPercentage float64
+
+	// SpotPriceTTL is the maximum age of the spot prices used for bidding, 0
+	// disables the check
+	SpotPriceTTL time.Duration
+
+	// SnapshotRetention is how long the snapshots of the replaced on-demand
+	// instances are kept for, before being deleted by a later run
+	SnapshotRetention time.Duration
+
+	// TerminationEventBus is the EventBridge event bus where an event is
+	// published for each instance terminated by AutoSpotting
+	TerminationEventBus string
+
+	// NodeTerminationHandlerQueue is the URL of the SQS queue of the
+	// aws-node-termination-handler notified of the interruptions of the
+	// Kubernetes nodes
+	NodeTerminationHandlerQueue string
+
+	// PriceOverrideFile is the S3 URL of a JSON or CSV file overriding the
+	// on-demand prices of the instance types
+	PriceOverrideFile string
+
+	// PricingAPI fetches the on-demand prices from the AWS Pricing API, falling
+	// back to the static data for the instance types it doesn't know
+	PricingAPI bool
+
+	// priceSources resolve the on-demand prices of the instance types
+	priceSources priceSources
+
+	// RegionDataCache is the S3 URL prefix or the local directory where the
+	// instance type information and prices of the regions are persisted for
+	// the next runs
+	RegionDataCache string
+
+	// RegionDataCacheTTL is how long the region data is reused by the next runs
+	RegionDataCacheTTL time.Duration
+
+	// regionData shares the instance type information and prices of the
+	// regions between the steps of a run
+	regionData *regionDataCache
+
+	// ReportingCurrency is the currency in which the savings are reported,
+	// while the prices and bids are always handled in USD
+	ReportingCurrency string
+
+	// FXRate is the number of units of the reporting currency for one USD, 0
+	// uses the daily reference rates of the European Central Bank
+	FXRate float64
+
+	// currencyConverter converts the reported amounts into ReportingCurrency
+	currencyConverter *currencyConverter
+
+	// TagKeyPrefix is the namespace of the tag keys read and written by
+	// AutoSpotting, replacing the autospotting_ prefix of the group
+	// configuration tags and prefixing all the other tag keys
+	TagKeyPrefix string
+
+	// SpotCoverageThreshold is the percentage of spot instances below which
+	// the groups drifted from their target coverage are reported and
+	// processed first, 0 disables it
+	SpotCoverageThreshold float64
+
+	// SpotCoverageTopic is the ARN of the SNS topic notified at the end of
+	// the runs about the groups below the spot coverage threshold
+	SpotCoverageTopic string
+
+	// spotCoverage collects the spot coverage of the groups during the
+	// current run
+	spotCoverage *spotCoverageReport
 }
 
 // ParseConfig loads configuration from command line flags, environments variables, and config files.
@@ -148,13 +470,18 @@ func ParseConfig(conf *Config) {
 
 	flagSet.StringVar(&conf.AllowedInstanceTypes, "allowed_instance_types", "",
 		"\n\tIf specified, the spot instances will be searched only among these types.\n\tIf missing, any instance type is allowed.\n"+
+			"\tThe instance type overrides of groups using mixed instances policies take precedence over it.\n"+
 			"\tAccepts a list of comma or whitespace separated instance types (supports globs).\n"+
 			"\tExample: ./AutoSpotting -allowed_instance_types 'c5.*,c4.xlarge'\n")
 
 	flagSet.StringVar(&conf.BiddingPolicy, "bidding_policy", DefaultBiddingPolicy,
 		"\n\tPolicy choice for spot bid. If set to 'normal', we bid at the on-demand price(times the multiplier).\n"+
 			"\tIf set to 'aggressive', we bid at a percentage value above the spot price \n"+
-			"\tconfigurable using the spot_price_buffer_percentage.\n")
+			"\tconfigurable using the spot_price_buffer_percentage.\n"+
+			"\tIf set to 'fixed', we bid the price configured using spot_max_price.\n"+
+			"\tIf set to 'percentage', we bid a percentage of the on-demand price configurable\n"+
+			"\tusing spot_price_on_demand_percentage.\n"+
+			"\tThe tag "+BiddingPolicyTag+" can be used to override this on a group level.\n")
 
 	flagSet.StringVar(&conf.DisallowedInstanceTypes, "disallowed_instance_types", "",
 		"\n\tIf specified, the spot instances will _never_ be of these types.\n"+
@@ -187,19 +514,43 @@ func ParseConfig(conf *Config) {
 			"\tExample: ./AutoSpotting -on_demand_price_multiplier 0.6 will have the on-demand price "+
 			"considered at 60% of the actual value.\n")
 
+	flagSet.StringVar(&conf.RegionalOnDemandPriceMultipliers, "regional_on_demand_price_multipliers", "",
+		"\n\tPer-region overrides of the on_demand_price_multiplier (separated by comma or whitespace,\n"+
+			"\tregion names support globs, the first matching entry is used).\n"+
+			"\tThe tag "+OnDemandPriceMultiplierTag+" still takes precedence on a group level.\n"+
+			"\tExample: ./AutoSpotting -regional_on_demand_price_multipliers 'us-east-1=0.7,eu-*=0.8'\n")
+
 	flagSet.StringVar(&conf.Regions, "regions", "",
 		"\n\tRegions where it should be activated (separated by comma or whitespace, also supports globs).\n"+
 			"\tBy default it runs on all regions.\n"+
 			"\tExample: ./AutoSpotting -regions 'eu-*,us-east-1'\n")
 
+	flagSet.StringVar(&conf.RegionFeatures, "region_features", "",
+		"\n\tOverrides the availability of the features which aren't available in all the regions, otherwise\n"+
+			"\tprobed using DryRun API calls or taken from the regions known not to support them.\n"+
+			"\tSupported features: "+io2VolumeFeature+", "+gp3VolumeFeature+"\n"+
+			"\tExample: ./AutoSpotting -region_features 'eu-west-3:io2=true,af-south-1:io2=false'\n")
+
 	flagSet.Float64Var(&conf.SpotPriceBufferPercentage, "spot_price_buffer_percentage", DefaultSpotPriceBufferPercentage,
 		"\n\tBid a given percentage above the current spot price.\n\tProtects the group from running spot"+
 			"instances that got significantly more expensive than when they were initially launched\n"+
 			"\tThe tag "+SpotPriceBufferPercentageTag+" can be used to override this on a group level.\n"+
 			"\tIf the bid exceeds the on-demand price, we place a bid at on-demand price itself.\n")
 
+	flagSet.Float64Var(&conf.SpotMaxPrice, "spot_max_price", 0,
+		"\n\tHourly price bid for the spot instances when using the 'fixed' bidding policy.\n"+
+			"\tThe tag "+SpotMaxPriceTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --bidding_policy fixed --spot_max_price 0.1\n")
+
+	flagSet.Float64Var(&conf.SpotPriceOnDemandPercentage, "spot_price_on_demand_percentage", DefaultSpotPriceOnDemandPercentage,
+		"\n\tPercentage of the on-demand price bid for the spot instances when using the 'percentage'\n"+
+			"\tbidding policy.\n"+
+			"\tThe tag "+SpotPriceOnDemandPercentageTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --bidding_policy percentage --spot_price_on_demand_percentage 80\n")
+
 	flagSet.StringVar(&conf.SpotProductDescription, "spot_product_description", DefaultSpotProductDescription,
-		"\n\tThe Spot Product to use when looking up spot price history in the market.\n"+
+		"\n\tThe Spot Product to use when looking up spot price history in the market, for the instances\n"+
+			"\twhose platform can't be determined from their AMI.\n"+
 			"\tValid choices: Linux/UNIX | SUSE Linux | Windows | Linux/UNIX (Amazon VPC) | \n"+
 			"\tSUSE Linux (Amazon VPC) | Windows (Amazon VPC) | Red Hat Enterprise Linux\n\tDefault value: "+DefaultSpotProductDescription+"\n")
 
@@ -207,6 +558,13 @@ func ParseConfig(conf *Config) {
 		"\n\tThe Product Premium to apply to the on demand price to improve spot selection and savings calculations\n"+
 			"\twhen using a premium instance type such as RHEL.")
 
+	flagSet.StringVar(&conf.TagKeyPrefix, "tag_key_prefix", "",
+		"\n\tNamespace of the tag keys read and written by AutoSpotting, for complying with tag governance\n"+
+			"\tpolicies. It replaces the autospotting_ prefix of the group configuration tags and prefixes the\n"+
+			"\tother tags, such as spot-enabled and launched-by-autospotting. The keys given in tag_filters\n"+
+			"\tare used as they are.\n"+
+			"\tExample: ./AutoSpotting --tag_key_prefix mycorp:autospotting/\n")
+
 	flagSet.StringVar(&conf.TagFilteringMode, "tag_filtering_mode", "opt-in", "\n\tControls the behavior of the tag_filters option.\n"+
 		"\tValid choices: opt-in | opt-out\n\tDefault value: 'opt-in'\n\tExample: ./AutoSpotting --tag_filtering_mode opt-out\n")
 
@@ -215,6 +573,29 @@ func ParseConfig(conf *Config) {
 		"\tIn case the tag_filtering_mode is set to opt-out, it defaults to 'spot-enabled=false'\n"+
 		"\tExample: ./AutoSpotting --tag_filters 'spot-enabled=true,Environment=dev,Team=vision'\n")
 
+	flagSet.StringVar(&conf.Profiles, "profiles", "", "\n\tJSON list of named configuration profiles, such as one per business unit, each\n"+
+		"\tprocessed in isolation and reported separately by the runs. A profile can override the\n"+
+		"\ttag_filters, tag_filtering_mode, regions, spot_coverage_topic, spot_coverage_threshold,\n"+
+		"\ttermination_event_bus, max_errors, max_error_rate, max_api_calls and max_concurrent_swaps options.\n"+
+		"\tBy default the global configuration is processed as a single profile.\n"+
+		"\tExample: ./AutoSpotting --profiles '[{\"name\":\"payments\",\"tag_filters\":\"team=payments\",\"max_errors\":5}]'\n")
+
+	flagSet.StringVar(&conf.OrganizationRole, "organization_role", "",
+		"\n\tName of the role assumed in each active member account of the AWS organization, enabling the\n"+
+			"\tOrganizations mode when running from its management or delegated administrator account. The\n"+
+			"\tmember accounts are processed in turn, each reported separately, followed by a summary.\n"+
+			"\tExample: ./AutoSpotting --organization_role AutoSpotting\n")
+
+	flagSet.StringVar(&conf.OrganizationUnitsInclude, "organization_units_include", "",
+		"\n\tComma separated list of organizational unit, root or account IDs whose accounts are processed\n"+
+			"\tin the Organizations mode, including those of the nested organizational units. All by default.\n"+
+			"\tExample: ./AutoSpotting --organization_units_include ou-ab12-11111111,ou-ab12-22222222\n")
+
+	flagSet.StringVar(&conf.OrganizationUnitsExclude, "organization_units_exclude", "",
+		"\n\tComma separated list of organizational unit, root or account IDs whose accounts are skipped\n"+
+			"\tin the Organizations mode, taking precedence over organization_units_include.\n"+
+			"\tExample: ./AutoSpotting --organization_units_exclude ou-ab12-33333333,123456789012\n")
+
 	flagSet.StringVar(&conf.CronSchedule, "cron_schedule", DefaultCronSchedule, "\n\tCron-like schedule in which to"+
 		"\tperform(or not) spot replacement actions. Format: hour day-of-week\n"+
 		"\tExample: ./AutoSpotting --cron_schedule '9-18 1-5' # workdays during the office hours \n")
@@ -252,6 +633,185 @@ func ParseConfig(conf *Config) {
 			"1TB GP2 also has better IOPS than a baseline GP3 volume.\n"+
 			"\tExample: ./AutoSpotting --ebs_gp2_conversion_threshold 170\n")
 
+	flagSet.StringVar(&conf.EBSVolumeConversions, "ebs_volume_conversions", "",
+		"\n\tComma-separated list of the EBS volume type conversions done when launching spot instances,\n"+
+			"\tas source:target volume types followed by space-separated options: min_size and max_size\n"+
+			"\tlimit the converted volume sizes in GiB, max_iops limits their provisioned IOPS, while iops\n"+
+			"\tand throughput set the performance of the converted volumes, either as numbers or as '"+matchPerformance+"'\n"+
+			"\tfor matching the baseline performance of the original volume type. The first matching\n"+
+			"\tconversion to a volume type available in the zone is used. By default io1 volumes are\n"+
+			"\tconverted to io2 and gp2 volumes up to the ebs_gp2_conversion_threshold to gp3, while\n"+
+			"\t'"+NoEBSVolumeConversions+"' disables the conversions.\n"+
+			"\tThe tag "+EBSVolumeConversionsTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --ebs_volume_conversions 'io1:io2,gp2:gp3 max_size=500 throughput=match'\n")
+
+	flagSet.BoolVar(&conf.AllowDedicatedTenancy, "allow_dedicated_tenancy", false,
+		"\n\tAllows replacing on-demand instances running with dedicated tenancy, by launching spot\n"+
+			"\tinstances with the same tenancy. By default such instances are skipped. The instances running\n"+
+			"\ton Dedicated Hosts are always skipped, since spot instances can't run on Dedicated Hosts.\n"+
+			"\tThe tag "+AllowDedicatedTenancyTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --allow_dedicated_tenancy=true\n")
+
+	flagSet.StringVar(&conf.InstanceStoreCompatibility, "instance_store_compatibility", DefaultInstanceStoreCompatibility,
+		"\n\tControls how the instance store volumes of the spot instance types are compared with the ones\n"+
+			"\tof the replaced instances, when the launch configuration or template maps any of them.\n"+
+			"\tAllowed options: '"+RelaxedInstanceStoreCompatibility+"' only requires enough volumes, '"+
+			DefaultInstanceStoreCompatibility+"' also requires\n"+
+			"\tthe same total capacity without downgrading from SSD to HDD or from NVMe to SATA, and '"+
+			StrictInstanceStoreCompatibility+"'\n"+
+			"\talso requires volumes at least as large, using the same NVMe or SATA interface.\n"+
+			"\tThe tag "+InstanceStoreCompatibilityTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --instance_store_compatibility strict\n")
+
+	flagSet.Int64Var(&conf.Diversification, "diversification", 1,
+		"\n\tNumber of the cheapest compatible spot instance types across which the spot instances\n"+
+			"\tof each group are spread, in order to reduce the risk of correlated spot interruptions.\n"+
+			"\tEach replacement uses the least used of them in the group. By default the cheapest one is used.\n"+
+			"\tThe tag "+DiversificationTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --diversification 3\n")
+
+	flagSet.StringVar(&conf.DisabledActions, "disabled_actions", "",
+		"\n\tComma-separated list of mutating actions which are never performed, so that AutoSpotting can be\n"+
+			"\trolled out gradually. Allowed options: '"+strings.Join(mutatingActions, "', '")+"'.\n"+
+			"\tThe replacements needing any of the disabled actions are skipped. By default all actions are enabled.\n"+
+			"\tThe tag "+DisabledActionsTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --disabled_actions terminate_on_demand,modify_max_size\n")
+
+	flagSet.StringVar(&conf.MaxSizeAlternative, "max_size_alternative", StandbyMaxSizeAlternative,
+		"\n\tControls how spot instances are attached to groups running at their MaxSize when the\n"+
+			"\t"+ModifyMaxSizeAction+" action is disabled, for environments where the MaxSize can't be changed.\n"+
+			"\tAllowed options: '"+StandbyMaxSizeAlternative+"' (default) puts the on-demand instance in standby and\n"+
+			"\t'"+DetachMaxSizeAlternative+"' detaches it before attaching the spot instance. In both cases the\n"+
+			"\ton-demand instance is brought back if the spot instance fails to be attached.\n"+
+			"\tThe tag "+MaxSizeAlternativeTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --disabled_actions modify_max_size --max_size_alternative detach\n")
+
+	flagSet.BoolVar(&conf.SameSizeForScalingPolicies, "same_size_for_scaling_policies", false,
+		"\n\tRestricts the replacements to instance types with the same number of vCPUs and memory for\n"+
+			"\tthe groups using predictive scaling, or target tracking on metrics averaged across their\n"+
+			"\tinstances, which would otherwise be skewed by differently sized replacements.\n"+
+			"\tThe tag "+SameSizeForScalingPoliciesTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --same_size_for_scaling_policies=true\n")
+
+	flagSet.StringVar(&conf.CPUVendor, "cpu_vendor", AnyCPUVendor,
+		"\n\tPins the replacements to a CPU vendor, for workloads with licensing or instruction set\n"+
+			"\trequirements such as AVX-512. Allowed options: '"+AnyCPUVendor+"' (default) allows replacing Intel\n"+
+			"\tinstances with AMD ones and vice versa, '"+SameCPUVendor+"' keeps the vendor of the replaced instance,\n"+
+			"\t'"+IntelCPUVendor+"' and '"+AMDCPUVendor+"' only allow instance types with CPUs from that vendor.\n"+
+			"\tThe tag "+CPUVendorTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --cpu_vendor same\n")
+
+	flagSet.StringVar(&conf.SpotInterruptionBehavior, "spot_interruption_behavior", TerminateInterruptionBehavior,
+		"\n\tWhat happens to the spot instances when they're interrupted. Allowed options: '"+
+			TerminateInterruptionBehavior+"' (default),\n"+
+			"\t'"+StopInterruptionBehavior+"' and '"+HibernateInterruptionBehavior+"', for workloads which can resume after being interrupted.\n"+
+			"\tThe stopped or hibernated instances are started again once capacity is available, using\n"+
+			"\tpersistent spot requests, which are cancelled when AutoSpotting terminates their instances.\n"+
+			"\tThe tag "+SpotInterruptionBehaviorTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --spot_interruption_behavior hibernate\n")
+
+	flagSet.StringVar(&conf.ReadinessChecks, "readiness_checks", "",
+		"\n\tComma-separated list of active checks determining when the spot instances are ready to be\n"+
+			"\tattached to the group, instead of waiting for its health check grace period. Allowed options:\n"+
+			"\t'"+StatusChecksReadiness+"' requires the EC2 status checks to pass, '"+SSMAgentReadiness+"' requires the SSM agent to be online.\n"+
+			"\tThe tag "+ReadinessChecksTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --readiness_checks status_checks,ssm_agent\n")
+
+	flagSet.StringVar(&conf.SwapStrategy, "swap_strategy", AttachFirstSwapStrategy,
+		"\n\tThe order of the swap operations. Allowed options: '"+AttachFirstSwapStrategy+"' (default) attaches the spot instance\n"+
+			"\tbefore terminating the on-demand instance, '"+TerminateFirstSwapStrategy+"' takes the on-demand instance out of\n"+
+			"\tthe group before attaching the spot instance, as configured by max_size_alternative, and\n"+
+			"\t'"+OverlapSwapStrategy+"' also keeps the on-demand instance running until the spot instance is healthy in the group.\n"+
+			"\tThe tag "+SwapStrategyTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --swap_strategy overlap\n")
+
+	flagSet.Int64Var(&conf.Surge, "surge", 0,
+		"\n\tThe number of on-demand instances replaced at once in each group, by launching as many spot\n"+
+			"\tinstances in parallel, attaching them all to the group and terminating the on-demand instances\n"+
+			"\tonce the spot instances are healthy. By default the instances are replaced one at a time.\n"+
+			"\tThe tag "+SurgeTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --surge 3\n")
+
+	flagSet.BoolVar(&conf.AdoptSpotInstances, "adopt_spot_instances", false,
+		"\n\tAdopts the spot instances of the groups which weren't launched by AutoSpotting, such as those\n"+
+			"\tlaunched by mixed instances policies, by tagging them like the ones it launched, so they're\n"+
+			"\tcounted in the reported savings. By default they're only logged.\n"+
+			"\tThe tag "+AdoptSpotInstancesTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --adopt_spot_instances=true\n")
+
+	flagSet.DurationVar(&conf.DrainTimeout, "drain_timeout", DefaultDrainTimeout,
+		"\n\tThe longest time the replaced on-demand instances are drained for after being deregistered\n"+
+			"\tfrom the load balancers and target groups of the group, before being terminated. The draining\n"+
+			"\tends earlier when the connection draining timeout or deregistration delay is shorter.\n"+
+			"\tSetting it to 0 deregisters the instances without waiting for them to be drained.\n"+
+			"\tThe tag "+DrainTimeoutTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --drain_timeout 2m\n")
+
+	flagSet.DurationVar(&conf.LifecycleHookTimeout, "lifecycle_hook_timeout", DefaultLifecycleHookTimeout,
+		"\n\tThe longest time the swaps wait for the termination lifecycle hooks of the replaced on-demand\n"+
+			"\tinstances to be completed by their handlers, such as Lambda functions notified through SNS or SQS.\n"+
+			"\tThe wait ends earlier when the global timeout of the hooks is shorter. The hooks not completed\n"+
+			"\tin time are reported as lifecycle hook timeouts. Setting it to 0 disables the wait.\n"+
+			"\tThe tag "+LifecycleHookTimeoutTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --lifecycle_hook_timeout 5m\n")
+
+	flagSet.BoolVar(&conf.SnapshotBeforeTerminate, "snapshot_before_terminate", false,
+		"\n\tSnapshots the EBS volumes of the replaced on-demand instances before terminating them, as a\n"+
+			"\tsafety net for stateful instances. The snapshots are tagged with the replaced and replacing\n"+
+			"\tinstances and their group, and the on-demand instances are kept running when they fail.\n"+
+			"\tThe tag "+SnapshotBeforeTerminateTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --snapshot_before_terminate=true\n")
+
+	flagSet.StringVar(&conf.TerminationEventBus, "termination_event_bus", "",
+		"\n\tThe name or ARN of an EventBridge event bus where an event is published for each instance\n"+
+			"\tterminated by AutoSpotting, with the reason, the replacing instance and the run ID also set\n"+
+			"\tin the tags of the instance. By default no events are published.\n"+
+			"\tExample: ./AutoSpotting --termination_event_bus default\n")
+
+	flagSet.StringVar(&conf.NodeTerminationHandlerQueue, "node_termination_handler_queue", "",
+		"\n\tThe URL of the SQS queue processed by the aws-node-termination-handler running in queue mode\n"+
+			"\tin the EKS clusters. The spot interruption and rebalance recommendation events of the\n"+
+			"\tinstances of groups backing Kubernetes nodes are forwarded to it, so that the nodes are\n"+
+			"\tdrained by the handler. By default the events aren't forwarded.\n"+
+			"\tThe tag "+NodeTerminationHandlerQueueTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --node_termination_handler_queue https://sqs.us-east-1.amazonaws.com/123456789012/nth\n")
+
+	flagSet.DurationVar(&conf.SnapshotRetention, "snapshot_retention", DefaultSnapshotRetention,
+		"\n\tHow long the snapshots of the replaced on-demand instances are kept for. They're tagged with\n"+
+			"\ttheir expiration time and deleted by the first run after it.\n"+
+			"\tExample: ./AutoSpotting --snapshot_retention 72h\n")
+
+	flagSet.BoolVar(&conf.CopyTerminationProtection, "copy_termination_protection", false,
+		"\n\tAllows replacing on-demand instances protected from termination, by enabling the\n"+
+			"\ttermination protection on their spot replacements once they're attached to the group.\n"+
+			"\tBy default such instances are skipped. See scale_in_protection for the instances protected from scale-in.\n"+
+			"\tThe tag "+CopyTerminationProtectionTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --copy_termination_protection=true\n")
+
+	flagSet.StringVar(&conf.ScaleInProtection, "scale_in_protection", NoScaleInProtection,
+		"\n\tProtects the spot instances from scale-in once attached to the group, for groups managed by\n"+
+			"\tautoscalers such as the Kubernetes Cluster Autoscaler. Allowed options: '"+NoScaleInProtection+"' (default) doesn't\n"+
+			"\tprotect them and skips the on-demand instances protected from scale-in, '"+GroupScaleInProtection+"' mirrors the\n"+
+			"\tNewInstancesProtectedFromScaleIn setting of the group and '"+InstanceScaleInProtection+"' mirrors the protection of\n"+
+			"\tthe replaced on-demand instances, which are then no longer skipped when protected.\n"+
+			"\tThe tag "+ScaleInProtectionTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --scale_in_protection group\n")
+
+	flagSet.StringVar(&conf.KubernetesAutoscalerPolicy, "kubernetes_autoscaler_policy", IgnoreKubernetesAutoscalerPolicy,
+		"\n\tHow the groups managed by Kubernetes autoscalers are handled, as detected from their\n"+
+			"\tk8s.io/cluster-autoscaler/* or karpenter.sh/* tags. Allowed options: '"+IgnoreKubernetesAutoscalerPolicy+"' (default) replaces\n"+
+			"\ttheir instances like for any other group, '"+SkipKubernetesAutoscalerPolicy+"' leaves them to the autoscaler,\n"+
+			"\t'"+ReportOnlyKubernetesAutoscalerPolicy+"' only reports the instances which would be replaced and '"+CoordinateKubernetesAutoscalerPolicy+"'\n"+
+			"\treplaces them, copying their scale-in protection and Kubernetes tags to the spot instances.\n"+
+			"\tThe tag "+KubernetesAutoscalerPolicyTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --kubernetes_autoscaler_policy coordinate\n")
+
+	flagSet.BoolVar(&conf.SkipTerminationProtectionCheck, "skip_termination_protection_check", false,
+		"\n\tSkips checking the termination protection of the on-demand instances, saving an API call\n"+
+			"\tper instance, for groups known not to use it. Protected instances fail to be terminated.\n"+
+			"\tThe tag "+SkipTerminationProtectionCheckTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --skip_termination_protection_check=true\n")
+
 	flagSet.BoolVar(&conf.DisableEventBasedInstanceReplacement, "disable_event_based_instance_replacement", false,
 		"\n\tDisables the event based instance replacement, forcing the legacy cron mode.\n"+
 			"\tExample: ./AutoSpotting --disable_event_based_instance_replacement=true\n")
@@ -260,6 +820,324 @@ func ParseConfig(conf *Config) {
 		"\n\tDisables handling of instance rebalance recommendation events.\n"+
 			"\tExample: ./AutoSpotting --disable_instance_rebalance_recommendation=true\n")
 
+	flagSet.BoolVar(&conf.StreamingScan, "streaming_scan", false,
+		"\n\tOnly scans the instances of the enabled groups and processes each group as soon as all its\n"+
+			"\tinstances were scanned, reducing the memory usage and the time until the first replacement\n"+
+			"\tin regions with many instances.\n"+
+			"\tExample: ./AutoSpotting --streaming_scan=true\n")
+
+	flagSet.BoolVar(&conf.DaemonMode, "daemon", false,
+		"\n\tRuns AutoSpotting as a long-lived process that periodically processes all the enabled\n"+
+			"\tgroups, instead of relying on Lambda and a CloudWatch Events cron rule.\n"+
+			"\tExample: ./AutoSpotting --daemon=true\n")
+
+	flagSet.DurationVar(&conf.DaemonInterval, "daemon_interval", DefaultDaemonInterval,
+		"\n\tThe time between two consecutive runs when running in daemon mode.\n"+
+			"\tExample: ./AutoSpotting --daemon=true --daemon_interval 10m\n")
+
+	flagSet.StringVar(&conf.HealthCheckListenAddress, "health_check_listen_address", DefaultHealthCheckListenAddress,
+		"\n\tThe address on which the /healthz and /readyz HTTP endpoints are served in daemon mode.\n"+
+			"\tExample: ./AutoSpotting --daemon=true --health_check_listen_address :8080\n")
+
+	flagSet.StringVar(&conf.LeaderElection, "leader_election", DefaultLeaderElection,
+		"\n\tLeader election mechanism used to make sure a single daemon replica takes actions.\n"+
+			"\tValid choices: "+NoLeaderElection+" | "+KubernetesLeaderElection+"\n"+
+			"\tExample: ./AutoSpotting --daemon=true --leader_election "+KubernetesLeaderElection+"\n")
+
+	flagSet.StringVar(&conf.LeaderElectionNamespace, "leader_election_namespace", "",
+		"\n\tThe Kubernetes namespace of the leader election Lease, defaults to the namespace of the current pod.\n"+
+			"\tExample: ./AutoSpotting --leader_election kubernetes --leader_election_namespace kube-system\n")
+
+	flagSet.StringVar(&conf.LeaderElectionLeaseName, "leader_election_lease_name", DefaultLeaderElectionLeaseName,
+		"\n\tThe name of the Kubernetes Lease used for leader election.\n"+
+			"\tExample: ./AutoSpotting --leader_election kubernetes --leader_election_lease_name autospotting\n")
+
+	flagSet.DurationVar(&conf.LeaderElectionLeaseDuration, "leader_election_lease_duration", DefaultLeaderElectionLeaseDuration,
+		"\n\tHow long the leadership is kept without being renewed, after which another replica can take over.\n"+
+			"\tExample: ./AutoSpotting --leader_election kubernetes --leader_election_lease_duration 30s\n")
+
+	flagSet.StringVar(&conf.EndpointURL, "endpoint_url", "",
+		"\n\tCustom endpoint URL used for all the AWS services, such as a VPC interface endpoint or a\n"+
+			"\tLocalStack instance. The "+regionPlaceholder+" placeholder is replaced with the region name.\n"+
+			"\tExample: ./AutoSpotting --endpoint_url http://localhost:4566\n")
+
+	flagSet.StringVar(&conf.ServiceEndpoints, "service_endpoints", "",
+		"\n\tCustom endpoint URLs for individual AWS services, given as comma or whitespace separated\n"+
+			"\tservice=url pairs, taking precedence over endpoint_url. Supported services: autoscaling,\n"+
+			"\tcloudformation, ec2, eks, lambda and sqs. The "+regionPlaceholder+" placeholder is replaced\n"+
+			"\twith the region name, which allows using FIPS endpoints.\n"+
+			"\tExample: ./AutoSpotting --service_endpoints 'ec2=https://ec2-fips."+regionPlaceholder+".amazonaws.com'\n")
+
+	flagSet.StringVar(&conf.ProxyURL, "proxy_url", "",
+		"\n\tProxy used for all the AWS API calls. When not set, the standard HTTP_PROXY, HTTPS_PROXY\n"+
+			"\tand NO_PROXY environment variables are honored.\n"+
+			"\tExample: ./AutoSpotting --proxy_url http://proxy.example.com:3128\n")
+
+	flagSet.StringVar(&conf.CustomCABundle, "custom_ca_bundle", "",
+		"\n\tPEM file containing CA certificates trusted in addition to the system ones when making\n"+
+			"\tAWS API calls, such as the certificate of a TLS-inspecting proxy.\n"+
+			"\tExample: ./AutoSpotting --custom_ca_bundle /etc/ssl/certs/proxy-ca.pem\n")
+
+	flagSet.IntVar(&conf.MaxConcurrentSwaps, "max_concurrent_swaps", 0,
+		"\n\tMaximum number of swaps in flight across all the regions, protecting the systems shared\n"+
+			"\tby the instances, such as configuration management or service discovery, from registration\n"+
+			"\tstorms. The swaps exceeding it wait for the ones in progress to finish. Unlimited by default.\n"+
+			"\tExample: ./AutoSpotting --max_concurrent_swaps 20\n")
+
+	flagSet.Int64Var(&conf.MaxErrors, "max_errors", 0,
+		"\n\tNumber of failed actions after which the rest of the run stops making changes and only\n"+
+			"\treports the actions it would take. Disabled by default.\n"+
+			"\tExample: ./AutoSpotting --max_errors 10\n")
+
+	flagSet.Int64Var(&conf.MaxAPICalls, "max_api_calls", 0,
+		"\n\tNumber of AWS API calls after which the rest of the run stops making changes and only reports\n"+
+			"\tthe actions it would take, leaving the API rate limits to the other automation running in the\n"+
+			"\tsame accounts. Disabled by default.\n"+
+			"\tExample: ./AutoSpotting --max_api_calls 5000\n")
+
+	flagSet.BoolVar(&conf.APICallMetrics, "api_call_metrics", false,
+		"\n\tEmits the number of AWS API calls made by each run as the "+heartbeatNamespace+"/"+apiCallsMetricName+" CloudWatch\n"+
+			"\tmetric in the main region, with the called service as dimension.\n"+
+			"\tExample: ./AutoSpotting --api_call_metrics=true\n")
+
+	flagSet.Float64Var(&conf.MaxErrorRate, "max_error_rate", 0,
+		"\n\tPercentage of failed actions after which the rest of the run stops making changes and only\n"+
+			"\treports the actions it would take. Disabled by default.\n"+
+			"\tExample: ./AutoSpotting --max_error_rate 50\n")
+
+	flagSet.StringVar(&conf.RecordAPICalls, "record_api_calls", "",
+		"\n\tRecords all the AWS API calls and their responses into the given JSON file, which can be\n"+
+			"\tlater replayed for reproducing issues or as regression test fixture.\n"+
+			"\tExample: ./AutoSpotting --record_api_calls recording.json\n")
+
+	flagSet.StringVar(&conf.ReplayAPICalls, "replay_api_calls", "",
+		"\n\tReplays the AWS API responses previously recorded into the given JSON file, without\n"+
+			"\tmaking any calls to AWS.\n"+
+			"\tExample: ./AutoSpotting --replay_api_calls recording.json\n")
+
+	flagSet.StringVar(&conf.SpotInstanceTags, "spot_instance_tags", "",
+		"\n\tAdditional tags set on the launched spot instances, such as cost allocation tags, given as\n"+
+			"\tcomma or whitespace separated key=value pairs. They are only applied when not already set on\n"+
+			"\tthe group as tags propagated at launch or on the replaced on-demand instance.\n"+
+			"\tExample: ./AutoSpotting --spot_instance_tags 'cost-center=1234,team=platform'\n")
+
+	flagSet.BoolVar(&conf.Analyze, "analyze", false,
+		"\n\tScans all the AutoScaling groups, including those not enabled for AutoSpotting, and prints\n"+
+			"\ta report of the potential monthly savings, the best spot candidate instance types and the\n"+
+			"\treasons blocking the replacement of the on-demand instances, without making any changes.\n"+
+			"\tExample: ./AutoSpotting --analyze\n")
+
+	flagSet.StringVar(&conf.ExplainASG, "explain_asg", "",
+		"\n\tEvaluates the given AutoScaling group and prints the decisions taken for each of its\n"+
+			"\ton-demand instances: the price comparison, the outcome of the compatibility checks of all the\n"+
+			"\tspot candidate instance types and the chosen candidates, without making any changes.\n"+
+			"\tExample: ./AutoSpotting --explain_asg my-group\n")
+
+	flagSet.StringVar(&conf.ExplainInstance, "explain_instance", "",
+		"\n\tLike explain_asg, but only for the given instance, evaluated within its AutoScaling group.\n"+
+			"\tExample: ./AutoSpotting --explain_instance i-0123456789abcdef0\n")
+
+	flagSet.BoolVar(&conf.Cleanup, "cleanup", false,
+		"\n\tFinds the resources left over by AutoSpotting in the enabled regions, such as orphan spot\n"+
+			"\tinstances, the tags it set on the groups and instances, MaxSizes left increased and dangling\n"+
+			"\tpersistent spot requests, and prints a report of them without making any changes.\n"+
+			"\tExample: ./AutoSpotting --cleanup\n")
+
+	flagSet.BoolVar(&conf.CleanupApply, "cleanup_apply", false,
+		"\n\tUsed with cleanup, also reverts the resources left over by AutoSpotting, terminating the orphan\n"+
+			"\tspot instances. Only meant for decommissioning AutoSpotting, once it was stopped.\n"+
+			"\tExample: ./AutoSpotting --cleanup --cleanup_apply\n")
+
+	flagSet.StringVar(&conf.RevertASGs, "revert_asgs", "",
+		"\n\tConverts the given AutoScaling groups back to on-demand instances and prints a report of their\n"+
+			"\trestored state. The AutoSpotting tags are removed from the groups, their MaxSize and scaling\n"+
+			"\tprocesses are restored and the spot instances it launched are terminated, in batches of the\n"+
+			"\tgroup's surge, for being replaced by on-demand instances launched by the groups.\n"+
+			"\tExample: ./AutoSpotting --revert_asgs 'my-group,my-other-group'\n")
+
+	flagSet.BoolVar(&conf.Deploy, "deploy", false,
+		"\n\tPackages the binary as a Lambda function, uploads it to deploy_bucket and creates or updates\n"+
+			"\ta CloudFormation StackSet running it in the selected accounts and regions. The other flags\n"+
+			"\tgiven to the deploy command, set as arguments or environment variables, become the\n"+
+			"\tconfiguration of the deployed function.\n"+
+			"\tExample: ./AutoSpotting --deploy --deploy_bucket my-bucket --tag_filtering_mode opt-out\n")
+
+	flagSet.StringVar(&conf.DeployBinary, "deploy_binary", "",
+		"\n\tUsed with deploy, the Linux build of AutoSpotting packaged as Lambda function, by default the\n"+
+			"\trunning binary.\n"+
+			"\tExample: ./AutoSpotting --deploy --deploy_bucket my-bucket --deploy_binary ./build/AutoSpotting\n")
+
+	flagSet.StringVar(&conf.DeployBucket, "deploy_bucket", "",
+		"\n\tUsed with deploy, the S3 bucket the Lambda function package is uploaded to, in the region of\n"+
+			"\tthe function. When deploying to multiple regions, the "+regionPlaceholder+" placeholder is\n"+
+			"\treplaced by the name of each region, which needs its own bucket.\n"+
+			"\tExample: ./AutoSpotting --deploy --deploy_bucket 'my-bucket-"+regionPlaceholder+"'\n")
+
+	flagSet.StringVar(&conf.DeployStackSet, "deploy_stack_set", DefaultDeployStackSet,
+		"\n\tUsed with deploy, the name of the StackSet created or updated.\n"+
+			"\tExample: ./AutoSpotting --deploy --deploy_bucket my-bucket --deploy_stack_set AutoSpotting-test\n")
+
+	flagSet.StringVar(&conf.DeployAccounts, "deploy_accounts", "",
+		"\n\tUsed with deploy, the comma separated list of accounts the StackSet is deployed to, using\n"+
+			"\tself-managed permissions. By default it's deployed to the current account.\n"+
+			"\tExample: ./AutoSpotting --deploy --deploy_bucket my-bucket --deploy_accounts '111111111111,222222222222'\n")
+
+	flagSet.StringVar(&conf.DeployOrganizationalUnits, "deploy_organizational_units", "",
+		"\n\tUsed with deploy, the comma separated list of organizational units the StackSet is deployed\n"+
+			"\tto, using service-managed permissions from the management account of the organization.\n"+
+			"\tThe accounts later added to them are deployed to automatically.\n"+
+			"\tExample: ./AutoSpotting --deploy --deploy_bucket my-bucket --deploy_organizational_units ou-ab12-prod\n")
+
+	flagSet.StringVar(&conf.DeployRegions, "deploy_regions", "",
+		"\n\tUsed with deploy, the comma separated list of regions the StackSet is deployed to, by default\n"+
+			"\tthe main region. Unless regions is set, each deployed function only processes its own region.\n"+
+			"\tExample: ./AutoSpotting --deploy --deploy_bucket 'my-bucket-"+regionPlaceholder+"' --deploy_regions 'us-east-1,eu-west-1'\n")
+
+	flagSet.BoolVar(&conf.RenderTemplate, "render_template", false,
+		"\n\tPrints the CloudFormation template deployed by the deploy command with the other flags given\n"+
+			"\tto it, without deploying it. The flags become template parameters defaulting to their given\n"+
+			"\tvalues, and the enabled features add their resources and permissions.\n"+
+			"\tExample: ./AutoSpotting --render_template --deploy_bucket my-bucket --heartbeat_metric=true\n")
+
+	flagSet.BoolVar(&conf.IAMPolicy, "iam_policy", false,
+		"\n\tPrints the least privilege IAM policy needed with the other flags given to it, only allowing\n"+
+			"\tthe actions used by the enabled features, restricted to the resources they're used with where\n"+
+			"\tknown. RunInstances is only allowed for launching spot instances tagged at launch as\n"+
+			"\tlaunched by AutoSpotting.\n"+
+			"\tExample: ./AutoSpotting --iam_policy --sqs_queue_url https://sqs.us-east-1.amazonaws.com/123456789012/autospotting.fifo\n")
+
+	flagSet.DurationVar(&conf.SavingsReconciliationInterval, "savings_reconciliation_interval", 0,
+		"\n\tHow often the projected savings are compared with the savings realized during the previous\n"+
+			"\tday according to the Cost Explorer billing data, which requires the launched-by-autospotting\n"+
+			"\ttag to be activated as cost allocation tag. Each reconciliation makes paid Cost Explorer API\n"+
+			"\tcalls. Disabled by default.\n"+
+			"\tExample: ./AutoSpotting --savings_reconciliation_interval 24h\n")
+
+	flagSet.StringVar(&conf.ChaosMode, "chaos_mode", "",
+		"\n\tTest mode which interrupts a random subset of the spot instances launched by AutoSpotting\n"+
+			"\tafter each run, in order to validate the interruption handling end to end. Disabled by default.\n"+
+			"\tValid choices: "+SimulateChaosMode+" | "+TerminateChaosMode+"\n"+
+			"\t'"+SimulateChaosMode+"' handles the instances as if a spot interruption warning was received\n"+
+			"\tfor them, using the configured termination_notification_action.\n"+
+			"\t'"+TerminateChaosMode+"' terminates the instances without any warning, only use it in\n"+
+			"\tsandbox accounts.\n"+
+			"\tExample: ./AutoSpotting --chaos_mode "+SimulateChaosMode+" --chaos_percentage 20\n")
+
+	flagSet.Float64Var(&conf.ChaosPercentage, "chaos_percentage", 0,
+		"\n\tPercentage of the spot instances launched by AutoSpotting which are interrupted on each\n"+
+			"\trun when chaos_mode is enabled.\n"+
+			"\tExample: ./AutoSpotting --chaos_mode "+SimulateChaosMode+" --chaos_percentage 20\n")
+
+	flagSet.IntVar(&conf.SpotPriceHistoryDays, "spot_price_history_days", 0,
+		"\n\tNumber of days of spot price history used for ranking the spot instance types, so that\n"+
+			"\tbriefly cheap but volatile spot pools are deprioritized. Disabled by default, when the\n"+
+			"\tinstance types are ranked by their current spot price.\n"+
+			"\tExample: ./AutoSpotting --spot_price_history_days 7\n")
+
+	flagSet.Float64Var(&conf.SpotPriceAverageWeight, "spot_price_average_weight", 0.5,
+		"\n\tWeight of the average spot price over the history when ranking the instance types,\n"+
+			"\tbetween 0 and 1, the current spot price having the remaining weight.\n"+
+			"\tExample: ./AutoSpotting --spot_price_history_days 7 --spot_price_average_weight 0.7\n")
+
+	flagSet.Float64Var(&conf.SpotPriceVolatilityWeight, "spot_price_volatility_weight", 1,
+		"\n\tWeight of the standard deviation of the spot price over the history, added to the\n"+
+			"\tranking score of the instance types.\n"+
+			"\tExample: ./AutoSpotting --spot_price_history_days 7 --spot_price_volatility_weight 2\n")
+
+	flagSet.Float64Var(&conf.MaxBidDeviationPercentage, "max_bid_deviation_percentage", 0,
+		"\n\tRefuses to bid for spot instance types when the bid price exceeds their live spot price,\n"+
+			"\tqueried right before launching them, by more than this percentage. Protects against runaway\n"+
+			"\tbids caused by stale pricing data. Disabled by default. Keep in mind that the 'normal'\n"+
+			"\tbidding policy bids the on-demand price, which is often several times the spot price.\n"+
+			"\tExample: ./AutoSpotting --max_bid_deviation_percentage 500\n")
+
+	flagSet.DurationVar(&conf.SpotPriceTTL, "spot_price_ttl", 0,
+		"\n\tRefuses to bid when the spot prices of the region were fetched longer than this ago,\n"+
+			"\twhich may happen for long runs. Disabled by default.\n"+
+			"\tExample: ./AutoSpotting --spot_price_ttl 30m\n")
+
+	flagSet.StringVar(&conf.PriceOverrideFile, "price_override_file", "",
+		"\n\tS3 URL of a JSON or CSV file overriding the on-demand prices of the instance types, such as\n"+
+			"\tprivately negotiated prices or internal chargeback rates. The prices it doesn't cover are\n"+
+			"\ttaken from the Pricing API when enabled, then from the static data.\n"+
+			"\tExample: ./AutoSpotting --price_override_file s3://my-bucket/prices.csv\n")
+
+	flagSet.StringVar(&conf.RegionDataCache, "region_data_cache", "",
+		"\n\tS3 URL prefix or local directory where the instance type information and the prices of the\n"+
+			"\tregions are persisted, for being reused by the other processes instead of being fetched again.\n"+
+			"\tBy default they are only kept in memory, being reused by the next runs of the same process,\n"+
+			"\tsuch as the warm invocations of the Lambda function.\n"+
+			"\tExample: ./AutoSpotting --region_data_cache s3://my-bucket/autospotting-cache\n")
+
+	flagSet.DurationVar(&conf.RegionDataCacheTTL, "region_data_cache_ttl", DefaultRegionDataCacheTTL,
+		"\n\tHow long the region data is reused by the next runs, bounded by spot_price_ttl when set,\n"+
+			"\tsince the cached spot prices age meanwhile. Set it to 0 for fetching them on each run.\n"+
+			"\tExample: ./AutoSpotting --region_data_cache_ttl 15m\n")
+
+	flagSet.BoolVar(&conf.PricingAPI, "pricing_api", false,
+		"\n\tFetches the on-demand prices from the AWS Pricing API instead of the static data shipped\n"+
+			"\twith AutoSpotting, which is still used for the instance types missing from the API.\n"+
+			"\tExample: ./AutoSpotting --pricing_api=true\n")
+
+	flagSet.StringVar(&conf.ReportingCurrency, "reporting_currency", DefaultReportingCurrency,
+		"\n\tThe currency in which the savings are reported, given as ISO 4217 code. The prices and bids\n"+
+			"\tare always handled in USD.\n"+
+			"\tExample: ./AutoSpotting --reporting_currency EUR\n")
+
+	flagSet.Float64Var(&conf.FXRate, "fx_rate", 0,
+		"\n\tThe number of units of the reporting currency for one USD. By default the daily reference\n"+
+			"\texchange rates published by the European Central Bank are used.\n"+
+			"\tExample: ./AutoSpotting --reporting_currency EUR --fx_rate 0.92\n")
+
+	flagSet.DurationVar(&conf.ScheduledActionWindow, "scheduled_action_window", 0,
+		"\n\tPostpones the swaps starting within this time before or after any scheduled action of the\n"+
+			"\tgroups, since the processes suspended during a swap may break scheduled scaling activities.\n"+
+			"\tDisabled by default.\n"+
+			"\tExample: ./AutoSpotting --scheduled_action_window 10m\n")
+
+	flagSet.DurationVar(&conf.ExecutionTimeReserve, "execution_time_reserve", DefaultExecutionTimeReserve,
+		"\n\tTime kept aside before the Lambda timeout for finishing the run. When running out of time\n"+
+			"\tthe remaining regions and groups are skipped, as well as low priority work such as chaos\n"+
+			"\ttesting and savings reconciliation, and the skipped work is reported at the end of the run.\n"+
+			"\tExample: ./AutoSpotting --execution_time_reserve 1m\n")
+
+	flagSet.BoolVar(&conf.ReadOnly, "read_only", false,
+		"\n\tRead-only mode, which on each run only reports the replacements that would be made and the\n"+
+			"\tpotential savings, without making any changes and ignoring the instance events. It only needs\n"+
+			"\tthe read-only IAM permissions documented in START.md.\n"+
+			"\tExample: ./AutoSpotting --read_only=true\n")
+
+	flagSet.BoolVar(&conf.PermissionPreflight, "permission_preflight", false,
+		"\n\tChecks at startup all the IAM permissions needed by the runs, using DryRun EC2 API calls and\n"+
+			"\tthe IAM policy simulator, and reports the missing ones up front.\n"+
+			"\tExample: ./AutoSpotting --permission_preflight=true\n")
+
+	flagSet.BoolVar(&conf.HeartbeatMetric, "heartbeat_metric", false,
+		"\n\tEmits the "+heartbeatNamespace+"/"+heartbeatMetricName+" CloudWatch metric in the main region at the end of\n"+
+			"\teach completed run, so that silent failures of the scheduler or of the runs can be detected.\n"+
+			"\tExample: ./AutoSpotting --heartbeat_metric=true\n")
+
+	flagSet.DurationVar(&conf.HeartbeatAlarmStaleness, "heartbeat_alarm_staleness", 0,
+		"\n\tCreates and maintains the "+heartbeatAlarmName+" CloudWatch alarm, triggered when no run\n"+
+			"\tcompleted for this long. Requires heartbeat_metric. Disabled by default, the CloudFormation\n"+
+			"\tstack creates its own alarm.\n"+
+			"\tExample: ./AutoSpotting --heartbeat_metric=true --heartbeat_alarm_staleness 1h\n")
+
+	flagSet.StringVar(&conf.HeartbeatAlarmTopic, "heartbeat_alarm_topic", "",
+		"\n\tARN of the SNS topic notified when the heartbeat alarm changes its state.\n"+
+			"\tExample: ./AutoSpotting --heartbeat_alarm_topic arn:aws:sns:us-east-1:123456789012:alerts\n")
+
+	flagSet.Float64Var(&conf.SpotCoverageThreshold, "spot_coverage_threshold", 0,
+		"\n\tPercentage of spot instances below which the groups drifted from their target spot coverage,\n"+
+			"\tsuch as after scaling out with on-demand instances, are reported and processed first.\n"+
+			"\tThe spot coverage of all the groups is logged on each run regardless. Disabled by default.\n"+
+			"\tExample: ./AutoSpotting --spot_coverage_threshold 80\n")
+
+	flagSet.StringVar(&conf.SpotCoverageTopic, "spot_coverage_topic", "",
+		"\n\tARN of the SNS topic notified at the end of each run about the groups below the spot coverage\n"+
+			"\tthreshold. Requires spot_coverage_threshold.\n"+
+			"\tExample: ./AutoSpotting --spot_coverage_threshold 80 --spot_coverage_topic arn:aws:sns:us-east-1:123456789012:alerts\n")
+
 	printVersion := flagSet.Bool("version", false, "Print version number and exit.\n")
 
 	if err := flagSet.Parse(os.Args[1:]); err != nil {
@@ -271,6 +1149,100 @@ func ParseConfig(conf *Config) {
 		os.Exit(0)
 	}
 
+	conf.deployedFlags = nil
+	flagSet.Visit(func(f *flag.Flag) {
+		if isDeployedFlag(f.Name) {
+			conf.deployedFlags = append(conf.deployedFlags, deployedFlag{name: f.Name, value: f.Value.String(), usage: f.Usage})
+		}
+	})
+
+	if !isValidInterruptionBehavior(conf.SpotInterruptionBehavior) {
+		log.Fatalf("Invalid spot_interruption_behavior value: %s", conf.SpotInterruptionBehavior)
+	}
+
+	if !isValidSwapStrategy(conf.SwapStrategy) {
+		log.Fatalf("Invalid swap_strategy value: %s", conf.SwapStrategy)
+	}
+
+	if !isValidScaleInProtection(conf.ScaleInProtection) {
+		log.Fatalf("Invalid scale_in_protection value: %s", conf.ScaleInProtection)
+	}
+
+	if !isValidKubernetesAutoscalerPolicy(conf.KubernetesAutoscalerPolicy) {
+		log.Fatalf("Invalid kubernetes_autoscaler_policy value: %s", conf.KubernetesAutoscalerPolicy)
+	}
+
+	if conf.Surge < 0 {
+		log.Fatalf("Invalid surge value: %d", conf.Surge)
+	}
+
+	if conf.SnapshotRetention <= 0 {
+		log.Fatalf("Invalid snapshot_retention value: %s", conf.SnapshotRetention)
+	}
+
+	if conf.DrainTimeout < 0 {
+		log.Fatalf("Invalid drain_timeout value: %s", conf.DrainTimeout)
+	}
+
+	if conf.LifecycleHookTimeout < 0 {
+		log.Fatalf("Invalid lifecycle_hook_timeout value: %s", conf.LifecycleHookTimeout)
+	}
+
+	if conf.SpotCoverageThreshold < 0 || conf.SpotCoverageThreshold > 100 {
+		log.Fatalf("Invalid spot_coverage_threshold value: %v", conf.SpotCoverageThreshold)
+	}
+
+	if conf.SpotCoverageTopic != "" && conf.SpotCoverageThreshold == 0 {
+		log.Fatalf("The spot_coverage_topic option requires spot_coverage_threshold")
+	}
+
+	if conf.PriceOverrideFile != "" {
+		if _, _, err := parseS3URL(conf.PriceOverrideFile); err != nil {
+			log.Fatalf("Invalid price_override_file value: %s", err.Error())
+		}
+	}
+
+	if conf.FXRate < 0 {
+		log.Fatalf("Invalid fx_rate value: %v", conf.FXRate)
+	}
+
+	if conf.Deploy && conf.DeployBucket == "" {
+		log.Fatalf("The deploy option requires deploy_bucket")
+	}
+
+	if conf.DeployAccounts != "" && conf.DeployOrganizationalUnits != "" {
+		log.Fatalf("The deploy_accounts and deploy_organizational_units options are mutually exclusive")
+	}
+
+	if conf.DaemonInterval <= 0 {
+		log.Fatalf("Invalid daemon_interval value: %s", conf.DaemonInterval)
+	}
+
+	if conf.LeaderElectionLeaseDuration < MinLeaderElectionLeaseDuration {
+		log.Fatalf("Invalid leader_election_lease_duration value: %s, the minimum is %s",
+			conf.LeaderElectionLeaseDuration, MinLeaderElectionLeaseDuration)
+	}
+
+	if _, err := parseProfiles(conf.Profiles); err != nil {
+		log.Fatalf("Invalid profiles value: %s", err.Error())
+	}
+
+	if _, err := parseReadinessChecks(conf.ReadinessChecks); err != nil {
+		log.Fatalf("Invalid readiness_checks value: %s", err.Error())
+	}
+
+	if _, err := parseDisabledActions(conf.DisabledActions); err != nil {
+		log.Fatalf("Invalid disabled_actions value: %s", err.Error())
+	}
+
+	if _, err := parseEBSVolumeConversions(conf.EBSVolumeConversions); err != nil {
+		log.Fatalf("Invalid ebs_volume_conversions value: %s", err.Error())
+	}
+
+	if _, err := parseRegionFeatures(conf.RegionFeatures); err != nil {
+		log.Fatalf("Invalid region_features value: %s", err.Error())
+	}
+
 	data, err := ec2instancesinfo.Data()
 	if err != nil {
 		log.Fatal(err.Error())
@@ -279,3 +1251,18 @@ func ParseConfig(conf *Config) {
 
 	conf.FinalRecap = make(map[string][]string)
 }
+
+// finalRecapLock guards the FinalRecap entries, which are added concurrently
+// while processing the regions and their groups.
+var finalRecapLock sync.Mutex
+
+// addRecap adds an entry to the final recap of the given region.
+func (cfg *Config) addRecap(region, text string) {
+	finalRecapLock.Lock()
+	defer finalRecapLock.Unlock()
+
+	if cfg.FinalRecap == nil {
+		cfg.FinalRecap = make(map[string][]string)
+	}
+	cfg.FinalRecap[region] = append(cfg.FinalRecap[region], text)
+}
@@ -6,6 +6,7 @@ package autospotting
 import (
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -77,3 +78,19 @@ func TestParseConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestConfig_addRecap(t *testing.T) {
+	cfg := &Config{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg.addRecap("us-east-1", "asg Skipped action")
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, len(cfg.FinalRecap["us-east-1"]), 50)
+}
@@ -7,7 +7,6 @@
 package autospotting
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
@@ -15,33 +14,50 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/aws/aws-sdk-go/service/servicediscovery/servicediscoveryiface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
 type connections struct {
-	session        *session.Session
-	autoScaling    autoscalingiface.AutoScalingAPI
-	ec2            ec2iface.EC2API
-	cloudFormation cloudformationiface.CloudFormationAPI
-	lambda         lambdaiface.LambdaAPI
-	sqs            sqsiface.SQSAPI
-	region         string
+	session          *session.Session
+	autoScaling      autoscalingiface.AutoScalingAPI
+	ec2              ec2iface.EC2API
+	cloudFormation   cloudformationiface.CloudFormationAPI
+	lambda           lambdaiface.LambdaAPI
+	sqs              sqsiface.SQSAPI
+	eks              eksiface.EKSAPI
+	ssm              ssmiface.SSMAPI
+	elb              elbiface.ELBAPI
+	elbv2            elbv2iface.ELBV2API
+	serviceDiscovery servicediscoveryiface.ServiceDiscoveryAPI
+	eventBridge      eventbridgeiface.EventBridgeAPI
+	region           string
 }
 
-func (c *connections) setSession(region string) {
-	c.session = session.Must(
-		session.NewSession(&aws.Config{Region: aws.String(region)}))
+func (c *connections) setSession(region string, conf *Config) {
+	c.session = session.Must(newSession(region, conf))
 }
 
-func (c *connections) connect(region, mainRegion string) {
+func (c *connections) connect(region string, conf *Config) {
 
 	debug.Println("Creating service connections in", region)
 
 	if c.session == nil {
-		c.setSession(region)
+		c.setSession(region, conf)
 	}
 
 	asConn := make(chan *autoscaling.AutoScaling)
@@ -49,14 +65,38 @@ func (c *connections) connect(region, mainRegion string) {
 	cloudformationConn := make(chan *cloudformation.CloudFormation)
 	lambdaConn := make(chan *lambda.Lambda)
 	sqsConn := make(chan *sqs.SQS)
+	eksConn := make(chan *eks.EKS)
+	ssmConn := make(chan *ssm.SSM)
+	elbConn := make(chan *elb.ELB)
+	elbv2Conn := make(chan *elbv2.ELBV2)
+	serviceDiscoveryConn := make(chan *servicediscovery.ServiceDiscovery)
+	eventBridgeConn := make(chan *eventbridge.EventBridge)
 
-	go func() { asConn <- autoscaling.New(c.session) }()
-	go func() { ec2Conn <- ec2.New(c.session) }()
-	go func() { lambdaConn <- lambda.New(c.session) }()
-	go func() { cloudformationConn <- cloudformation.New(c.session) }()
-	go func() { sqsConn <- sqs.New(c.session, aws.NewConfig().WithRegion(mainRegion)) }()
+	mainRegion := region
+	if conf != nil && conf.MainRegion != "" {
+		mainRegion = conf.MainRegion
+	}
+
+	go func() { asConn <- autoscaling.New(c.session, conf.serviceConfig(autoscaling.EndpointsID, region)) }()
+	go func() { ec2Conn <- ec2.New(c.session, conf.serviceConfig(ec2.EndpointsID, region)) }()
+	go func() { lambdaConn <- lambda.New(c.session, conf.serviceConfig(lambda.EndpointsID, region)) }()
+	go func() {
+		cloudformationConn <- cloudformation.New(c.session, conf.serviceConfig(cloudformation.EndpointsID, region))
+	}()
+	go func() { sqsConn <- sqs.New(c.session, conf.serviceConfig(sqs.EndpointsID, mainRegion)) }()
+	go func() { eksConn <- eks.New(c.session, conf.serviceConfig(eks.EndpointsID, region)) }()
+	go func() { ssmConn <- ssm.New(c.session, conf.serviceConfig(ssm.EndpointsID, region)) }()
+	go func() { elbConn <- elb.New(c.session, conf.serviceConfig(elb.EndpointsID, region)) }()
+	go func() { elbv2Conn <- elbv2.New(c.session, conf.serviceConfig(elbv2.EndpointsID, region)) }()
+	go func() {
+		serviceDiscoveryConn <- servicediscovery.New(c.session, conf.serviceConfig(servicediscovery.EndpointsID, region))
+	}()
+	go func() {
+		eventBridgeConn <- eventbridge.New(c.session, conf.serviceConfig(eventbridge.EndpointsID, region))
+	}()
 
-	c.autoScaling, c.ec2, c.cloudFormation, c.lambda, c.sqs, c.region = <-asConn, <-ec2Conn, <-cloudformationConn, <-lambdaConn, <-sqsConn, region
+	c.autoScaling, c.ec2, c.cloudFormation, c.lambda, c.sqs, c.eks, c.ssm, c.region = <-asConn, <-ec2Conn, <-cloudformationConn, <-lambdaConn, <-sqsConn, <-eksConn, <-ssmConn, region
+	c.elb, c.elbv2, c.serviceDiscovery, c.eventBridge = <-elbConn, <-elbv2Conn, <-serviceDiscoveryConn, <-eventBridgeConn
 
 	debug.Println("Created service connections in", region)
 }
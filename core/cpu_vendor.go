@@ -0,0 +1,60 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+const (
+	// AnyCPUVendor allows replacing Intel instances with AMD ones and vice
+	// versa, since they share the same CPU architecture
+	AnyCPUVendor = "any"
+
+	// SameCPUVendor only allows instance types with the same CPU vendor as
+	// the replaced instance
+	SameCPUVendor = "same"
+
+	// IntelCPUVendor only allows instance types with Intel CPUs
+	IntelCPUVendor = "intel"
+
+	// AMDCPUVendor only allows instance types with AMD CPUs
+	AMDCPUVendor = "amd"
+)
+
+// cpuVendor returns the vendor of the CPU, as used for the CPUVendor option.
+func cpuVendor(cpuName string) string {
+	switch {
+	case isIntel(cpuName):
+		return IntelCPUVendor
+	case isAMD(cpuName):
+		return AMDCPUVendor
+	case isARM(cpuName):
+		return "arm"
+	}
+	return ""
+}
+
+// isCPUVendorCompatible checks if the CPU vendor of the candidate instance
+// type matches the one the group is pinned to, for workloads with licensing or
+// instruction set requirements, such as AVX-512.
+func (i *instance) isCPUVendorCompatible(candidate instanceTypeInformation) bool {
+	if i.asg == nil {
+		return true
+	}
+
+	candidateVendor := cpuVendor(candidate.PhysicalProcessor)
+
+	var ret bool
+	switch i.asg.config.CPUVendor {
+	case SameCPUVendor:
+		ret = candidateVendor == cpuVendor(i.typeInfo.PhysicalProcessor)
+	case IntelCPUVendor, AMDCPUVendor:
+		ret = candidateVendor == i.asg.config.CPUVendor
+	default:
+		return true
+	}
+
+	if !ret {
+		debug.Println("\tCPU vendor mismatch, candidate CPU", candidate.PhysicalProcessor,
+			"doesn't match the", i.asg.config.CPUVendor, "CPU vendor configuration")
+	}
+	return ret
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func Test_instance_isCPUVendorCompatible(t *testing.T) {
+	intel := instanceTypeInformation{instanceType: "m5.large", PhysicalProcessor: "Intel Xeon Platinum 8175"}
+	amd := instanceTypeInformation{instanceType: "m5a.large", PhysicalProcessor: "AMD EPYC 7571"}
+
+	tests := []struct {
+		name      string
+		asg       *autoScalingGroup
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		expected  bool
+	}{
+		{name: "no group", current: intel, candidate: amd, expected: true},
+		{
+			name:      "any vendor",
+			asg:       &autoScalingGroup{config: AutoScalingConfig{CPUVendor: AnyCPUVendor}},
+			current:   intel,
+			candidate: amd,
+			expected:  true,
+		},
+		{
+			name:      "same vendor mismatch",
+			asg:       &autoScalingGroup{config: AutoScalingConfig{CPUVendor: SameCPUVendor}},
+			current:   intel,
+			candidate: amd,
+			expected:  false,
+		},
+		{
+			name:      "same vendor match",
+			asg:       &autoScalingGroup{config: AutoScalingConfig{CPUVendor: SameCPUVendor}},
+			current:   amd,
+			candidate: amd,
+			expected:  true,
+		},
+		{
+			name:      "pinned to AMD",
+			asg:       &autoScalingGroup{config: AutoScalingConfig{CPUVendor: AMDCPUVendor}},
+			current:   intel,
+			candidate: amd,
+			expected:  true,
+		},
+		{
+			name:      "pinned to Intel",
+			asg:       &autoScalingGroup{config: AutoScalingConfig{CPUVendor: IntelCPUVendor}},
+			current:   intel,
+			candidate: amd,
+			expected:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{typeInfo: tt.current, asg: tt.asg}
+			if got := i.isCPUVendorCompatible(tt.candidate); got != tt.expected {
+				t.Errorf("isCPUVendorCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadCPUVendor(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected string
+	}{
+		{name: "no tag", expected: AnyCPUVendor},
+		{name: "valid tag", tagValue: aws.String(IntelCPUVendor), expected: IntelCPUVendor},
+		{name: "invalid tag", tagValue: aws.String("arm"), expected: AnyCPUVendor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{CPUVendor: AnyCPUVendor}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(CPUVendorTag), Value: tt.tagValue}}
+			}
+
+			a.loadCPUVendor()
+
+			if a.config.CPUVendor != tt.expected {
+				t.Errorf("CPUVendor = %q, expected %q", a.config.CPUVendor, tt.expected)
+			}
+		})
+	}
+}
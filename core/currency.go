@@ -0,0 +1,185 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReportingCurrency is the currency of the prices used internally,
+	// in which the savings are reported by default.
+	DefaultReportingCurrency = "USD"
+
+	// ecbFXRatesURL serves the daily reference exchange rates of the European
+	// Central Bank.
+	ecbFXRatesURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+
+	// fxRateTTL is how long an exchange rate is used before being refreshed.
+	fxRateTTL = 24 * time.Hour
+
+	// fxRateRetryInterval is the time after which a failed exchange rate
+	// lookup is retried.
+	fxRateRetryInterval = time.Hour
+)
+
+// reportingCurrency converts the amounts computed in USD into the currency
+// used for reporting them.
+type reportingCurrency struct {
+	code string
+	// the units of the currency for one USD
+	rate float64
+}
+
+var usdReportingCurrency = reportingCurrency{code: DefaultReportingCurrency, rate: 1}
+
+func (c reportingCurrency) convert(usd float64) float64 {
+	return usd * c.rate
+}
+
+// format converts the amount given in USD and renders it with the given
+// number of decimals, followed by the currency code.
+func (c reportingCurrency) format(usd float64, decimals int) string {
+	return fmt.Sprintf("%.*f %s", decimals, c.convert(usd), c.code)
+}
+
+// fxRateSource provides the exchange rates from USD to other currencies.
+type fxRateSource interface {
+	name() string
+	rate(currency string) (float64, error)
+}
+
+// staticFXRate is a fixed exchange rate given in the configuration.
+type staticFXRate float64
+
+func (s staticFXRate) name() string {
+	return "configured rate"
+}
+
+func (s staticFXRate) rate(string) (float64, error) {
+	return float64(s), nil
+}
+
+// ecbFXRates provides the daily reference exchange rates published by the
+// European Central Bank, which are quoted against EUR.
+type ecbFXRates struct {
+	client *http.Client
+	url    string
+}
+
+func (e ecbFXRates) name() string {
+	return "European Central Bank"
+}
+
+func (e ecbFXRates) rate(currency string) (float64, error) {
+	resp, err := e.client.Get(e.url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+
+	var envelope struct {
+		Rates []struct {
+			Currency string  `xml:"currency,attr"`
+			Rate     float64 `xml:"rate,attr"`
+		} `xml:"Cube>Cube>Cube"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return 0, err
+	}
+
+	eurRates := map[string]float64{"EUR": 1}
+	for _, r := range envelope.Rates {
+		eurRates[r.Currency] = r.Rate
+	}
+
+	if eurRates["USD"] <= 0 || eurRates[currency] <= 0 {
+		return 0, fmt.Errorf("no exchange rate published for %s", currency)
+	}
+	return eurRates[currency] / eurRates["USD"], nil
+}
+
+// currencyConverter caches the exchange rate of the reporting currency,
+// refreshing it daily.
+type currencyConverter struct {
+	sync.Mutex
+	code   string
+	source fxRateSource
+	clock  Clock
+
+	rate      float64
+	fetchedAt time.Time
+	failedAt  time.Time
+}
+
+// newCurrencyConverter sets up the conversion into the configured reporting
+// currency, using the configured exchange rate if any, or the rates published
+// by the European Central Bank otherwise.
+func newCurrencyConverter(conf *Config) *currencyConverter {
+	code := strings.ToUpper(conf.ReportingCurrency)
+	if code == "" || code == DefaultReportingCurrency {
+		return nil
+	}
+
+	c := &currencyConverter{code: code, clock: conf.getClock()}
+
+	if conf.FXRate > 0 {
+		c.source = staticFXRate(conf.FXRate)
+	} else {
+		client, err := newHTTPClient(conf)
+		if err != nil {
+			log.Println("Reporting in USD, failed to configure the HTTP client:", err.Error())
+			return nil
+		}
+		c.source = ecbFXRates{client: client, url: ecbFXRatesURL}
+	}
+
+	log.Println("Reporting the savings in", code, "using the exchange rate from the", c.source.name())
+	return c
+}
+
+// current returns the reporting currency with an up to date exchange rate,
+// falling back to the last known rate, or to USD if no rate is known.
+func (c *currencyConverter) current() reportingCurrency {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock.Now()
+	stale := now.Sub(c.fetchedAt) >= fxRateTTL
+	canRetry := now.Sub(c.failedAt) >= fxRateRetryInterval
+
+	if stale && canRetry {
+		rate, err := c.source.rate(c.code)
+		if err != nil {
+			log.Println("Failed to get the exchange rate of", c.code, "from the", c.source.name()+":", err.Error())
+			c.failedAt = now
+		} else {
+			c.rate, c.fetchedAt = rate, now
+		}
+	}
+
+	if c.rate <= 0 {
+		return usdReportingCurrency
+	}
+	return reportingCurrency{code: c.code, rate: c.rate}
+}
+
+// reportingCurrency returns the currency in which the savings are reported,
+// while USD is still used internally for all the prices and bids.
+func (cfg *Config) reportingCurrency() reportingCurrency {
+	if cfg.currencyConverter == nil {
+		return usdReportingCurrency
+	}
+	return cfg.currencyConverter.current()
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const ecbTestRates = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2021-01-01">
+			<Cube currency="USD" rate="1.25"/>
+			<Cube currency="JPY" rate="125.0"/>
+			<Cube currency="GBP" rate="0.9"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func Test_ecbFXRates_rate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, ecbTestRates)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		currency    string
+		expected    float64
+		expectedErr bool
+	}{
+		{name: "EUR", currency: "EUR", expected: 0.8},
+		{name: "JPY", currency: "JPY", expected: 100},
+		{name: "GBP", currency: "GBP", expected: 0.72},
+		{name: "unknown currency", currency: "XYZ", expectedErr: true},
+		{name: "HTTP error", path: "/missing", currency: "EUR", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := ecbFXRates{client: server.Client(), url: server.URL + tt.path}
+			got, err := e.rate(tt.currency)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("rate() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if math.Abs(got-tt.expected) > 1e-9 {
+				t.Errorf("rate() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+type mockFXRateSource struct {
+	rates []float64
+	err   error
+	calls int
+}
+
+func (m *mockFXRateSource) name() string {
+	return "mock"
+}
+
+func (m *mockFXRateSource) rate(string) (float64, error) {
+	m.calls++
+	if m.err != nil {
+		return 0, m.err
+	}
+	return m.rates[m.calls-1], nil
+}
+
+func Test_currencyConverter_current(t *testing.T) {
+	clock := &mockClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	source := &mockFXRateSource{rates: []float64{0.8, 0.9}}
+	c := &currencyConverter{code: "EUR", source: source, clock: clock}
+
+	if got := c.current(); got != (reportingCurrency{code: "EUR", rate: 0.8}) {
+		t.Errorf("current() = %v, expected the fetched rate", got)
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	if got := c.current(); got.rate != 0.8 || source.calls != 1 {
+		t.Errorf("current() = %v after %d calls, expected the cached rate", got, source.calls)
+	}
+
+	clock.now = clock.now.Add(fxRateTTL)
+	if got := c.current(); got.rate != 0.9 || source.calls != 2 {
+		t.Errorf("current() = %v after %d calls, expected the refreshed rate", got, source.calls)
+	}
+
+	source.err = errors.New("unavailable")
+	clock.now = clock.now.Add(fxRateTTL)
+	if got := c.current(); got.rate != 0.9 {
+		t.Errorf("current() = %v, expected the last known rate", got)
+	}
+	clock.now = clock.now.Add(time.Minute)
+	if c.current(); source.calls != 3 {
+		t.Errorf("the rate was fetched %d times, expected no retry before %v", source.calls, fxRateRetryInterval)
+	}
+}
+
+func Test_currencyConverter_currentWithoutRate(t *testing.T) {
+	c := &currencyConverter{
+		code:   "EUR",
+		source: &mockFXRateSource{err: errors.New("unavailable")},
+		clock:  &mockClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	if got := c.current(); got != usdReportingCurrency {
+		t.Errorf("current() = %v, expected falling back to USD", got)
+	}
+}
+
+func Test_newCurrencyConverter(t *testing.T) {
+	if c := newCurrencyConverter(&Config{ReportingCurrency: "usd"}); c != nil {
+		t.Errorf("newCurrencyConverter() = %v, expected no conversion for USD", c)
+	}
+
+	conf := &Config{ReportingCurrency: "eur", FXRate: 0.85}
+	conf.currencyConverter = newCurrencyConverter(conf)
+	if got := conf.reportingCurrency(); got != (reportingCurrency{code: "EUR", rate: 0.85}) {
+		t.Errorf("reportingCurrency() = %v, expected the configured rate", got)
+	}
+}
+
+func Test_writeAnalysisReport_currency(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := writeAnalysisReport(&buf, []groupAnalysis{
+		{region: "us-east-1", name: "web", monthlySavings: 100},
+	}, reportingCurrency{code: "EUR", rate: 0.8})
+	if err != nil {
+		t.Fatalf("writeAnalysisReport() unexpected error: %v", err)
+	}
+
+	report := buf.String()
+	for _, expected := range []string{"MONTHLY SAVINGS (EUR)", "80.00", "Total potential monthly savings: 80.00 EUR"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("report doesn't contain %q:\n%s", expected, report)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultDaemonInterval is the default time between two consecutive runs
+	// when running in daemon mode, matching the default Lambda cron schedule.
+	DefaultDaemonInterval = 5 * time.Minute
+
+	// DefaultHealthCheckListenAddress is the default address of the health and
+	// readiness HTTP endpoints served in daemon mode.
+	DefaultHealthCheckListenAddress = ":8080"
+
+	// NoLeaderElection disables leader election, every daemon replica will
+	// take actions. Only use it when running a single replica.
+	NoLeaderElection = "none"
+
+	// DefaultLeaderElection is the default leader election mechanism
+	DefaultLeaderElection = NoLeaderElection
+
+	// daemonShutdownTimeout is how long we wait for the HTTP server to finish
+	// serving in-flight requests when shutting down.
+	daemonShutdownTimeout = 5 * time.Second
+)
+
+// leaderElector decides if the current replica is allowed to take actions.
+type leaderElector interface {
+	// isLeader returns true when the current replica holds the leadership,
+	// acquiring or renewing it if needed, together with a context which is
+	// cancelled once the leadership is lost.
+	isLeader(ctx context.Context) (context.Context, bool)
+
+	// release gives up the leadership, called on shutdown.
+	release()
+}
+
+// noopLeaderElector is used when leader election is disabled, so every
+// replica considers itself the leader.
+type noopLeaderElector struct{}
+
+func (noopLeaderElector) isLeader(ctx context.Context) (context.Context, bool) {
+	return context.Background(), true
+}
+
+func (noopLeaderElector) release() {}
+
+func newLeaderElector(cfg *Config) (leaderElector, error) {
+	switch cfg.LeaderElection {
+	case NoLeaderElection, "":
+		return noopLeaderElector{}, nil
+	case KubernetesLeaderElection:
+		return newKubernetesLeaderElector(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported leader election mechanism: %s",
+			cfg.LeaderElection)
+	}
+}
+
+// daemon periodically runs the cron event logic and reports its status over
+// HTTP, for use when running as a long-lived process.
+type daemon struct {
+	interval time.Duration
+	elector  leaderElector
+	process  func(leadership context.Context)
+
+	sync.RWMutex
+	ready       bool
+	lastRun     time.Time
+	lastLeading bool
+
+	// the start time of the run in progress, zero between runs
+	runStarted time.Time
+}
+
+func newDaemon(a *AutoSpotting) (*daemon, error) {
+	elector, err := newLeaderElector(a.config)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := a.config.DaemonInterval
+	if interval <= 0 {
+		interval = DefaultDaemonInterval
+	}
+
+	return &daemon{
+		interval: interval,
+		elector:  elector,
+		process: func(leadership context.Context) {
+			a.config.leadership = leadership
+			a.ProcessCronEvent()
+			a.config.apiRecorder.save()
+		},
+	}, nil
+}
+
+// RunDaemon keeps processing all the enabled groups at the configured interval
+// until receiving SIGTERM or SIGINT, in which case it waits for the current run
+// to complete before returning.
+func (a *AutoSpotting) RunDaemon() error {
+	d, err := newDaemon(a)
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	go func() {
+		select {
+		case s := <-signals:
+			log.Printf("Received %s, shutting down after the current run completes", s)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	srv := &http.Server{
+		Addr:    a.config.HealthCheckListenAddress,
+		Handler: d.handler(),
+	}
+
+	go func() {
+		log.Println("Serving health checks on", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println("Health check server failed:", err.Error())
+		}
+	}()
+
+	d.run(ctx)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), daemonShutdownTimeout)
+	defer shutdownCancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// run executes the first iteration immediately and then keeps running at the
+// configured interval until the context is cancelled.
+func (d *daemon) run(ctx context.Context) {
+	log.Println("Running in daemon mode, processing every", d.interval)
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	defer d.elector.release()
+
+	d.tick(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Daemon stopped")
+			return
+		case <-ticker.C:
+			d.tick(ctx)
+		}
+	}
+}
+
+func (d *daemon) tick(ctx context.Context) {
+	leadership, leading := d.elector.isLeader(ctx)
+
+	d.Lock()
+	d.ready = true
+	d.lastLeading = leading
+	d.Unlock()
+
+	if !leading {
+		log.Println("Not the elected leader, skipping run")
+		return
+	}
+
+	d.Lock()
+	d.runStarted = time.Now()
+	d.Unlock()
+
+	d.process(leadership)
+
+	d.Lock()
+	d.lastRun = time.Now()
+	d.runStarted = time.Time{}
+	d.Unlock()
+}
+
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", d.healthz)
+	mux.HandleFunc("/livez", d.healthz)
+	mux.HandleFunc("/readyz", d.readyz)
+	return mux
+}
+
+// healthz reports the process as healthy unless the current run or the last
+// successful run is older than a few intervals, which would mean the scheduler
+// got stuck.
+func (d *daemon) healthz(w http.ResponseWriter, r *http.Request) {
+	d.RLock()
+	defer d.RUnlock()
+
+	if !d.runStarted.IsZero() && time.Since(d.runStarted) > 3*d.interval {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "run started at %s still in progress\n", d.runStarted.Format(time.RFC3339))
+		return
+	}
+
+	if d.lastLeading && !d.lastRun.IsZero() &&
+		time.Since(d.lastRun) > 3*d.interval {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "last run completed at %s\n", d.lastRun.Format(time.RFC3339))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz reports the daemon as ready once the scheduler started its first
+// iteration.
+func (d *daemon) readyz(w http.ResponseWriter, r *http.Request) {
+	d.RLock()
+	defer d.RUnlock()
+
+	if !d.ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "not ready")
+		return
+	}
+	fmt.Fprintf(w, "ready, leader: %t\n", d.lastLeading)
+}
+
+// leadershipLost tells whether the daemon replica lost the leadership during
+// the current run, after which another replica may be taking actions.
+func (cfg *Config) leadershipLost() bool {
+	return cfg.leadership != nil && cfg.leadership.Err() != nil
+}
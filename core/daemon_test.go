@@ -0,0 +1,172 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockLeaderElector struct {
+	leader   bool
+	released bool
+}
+
+func (m *mockLeaderElector) isLeader(context.Context) (context.Context, bool) {
+	return context.Background(), m.leader
+}
+
+func (m *mockLeaderElector) release() { m.released = true }
+
+func Test_newLeaderElector(t *testing.T) {
+	tests := []struct {
+		name           string
+		leaderElection string
+		wantErr        bool
+	}{
+		{name: "default", leaderElection: "", wantErr: false},
+		{name: "none", leaderElection: NoLeaderElection, wantErr: false},
+		{name: "unsupported", leaderElection: "foo", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newLeaderElector(&Config{LeaderElection: tt.leaderElection})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newLeaderElector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_daemonTick(t *testing.T) {
+	tests := []struct {
+		name     string
+		leader   bool
+		wantRuns int
+	}{
+		{name: "leader processes", leader: true, wantRuns: 1},
+		{name: "follower skips", leader: false, wantRuns: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs := 0
+			d := &daemon{
+				interval: time.Minute,
+				elector:  &mockLeaderElector{leader: tt.leader},
+				process:  func(context.Context) { runs++ },
+			}
+
+			d.tick(context.Background())
+
+			if runs != tt.wantRuns {
+				t.Errorf("tick() ran %d times, expected %d", runs, tt.wantRuns)
+			}
+			if !d.ready {
+				t.Errorf("tick() should mark the daemon as ready")
+			}
+			if !d.runStarted.IsZero() {
+				t.Errorf("tick() should clear the start time of the completed run")
+			}
+		})
+	}
+}
+
+func Test_daemonRunStopsOnCancel(t *testing.T) {
+	elector := &mockLeaderElector{leader: true}
+	runs := 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &daemon{
+		interval: time.Hour,
+		elector:  elector,
+		process: func(context.Context) {
+			runs++
+			cancel()
+		},
+	}
+
+	d.run(ctx)
+
+	if runs != 1 {
+		t.Errorf("run() processed %d times, expected 1", runs)
+	}
+	if !elector.released {
+		t.Errorf("run() should release the leadership on shutdown")
+	}
+}
+
+func Test_daemonHealthEndpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		daemon     *daemon
+		path       string
+		wantStatus int
+	}{
+		{
+			name:       "not ready before the first run",
+			daemon:     &daemon{interval: time.Minute},
+			path:       "/readyz",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "ready after the first run",
+			daemon:     &daemon{interval: time.Minute, ready: true},
+			path:       "/readyz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "healthy before the first run",
+			daemon:     &daemon{interval: time.Minute},
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "healthy after a recent run",
+			daemon: &daemon{interval: time.Minute, ready: true,
+				lastLeading: true, lastRun: time.Now()},
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "unhealthy when the leader didn't run for a while",
+			daemon: &daemon{interval: time.Minute, ready: true,
+				lastLeading: true, lastRun: time.Now().Add(-time.Hour)},
+			path:       "/healthz",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "healthy during a recent first run",
+			daemon: &daemon{interval: time.Minute, ready: true,
+				lastLeading: true, runStarted: time.Now()},
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "unhealthy when the first run hangs",
+			daemon: &daemon{interval: time.Minute, ready: true,
+				lastLeading: true, runStarted: time.Now().Add(-time.Hour)},
+			path:       "/healthz",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "healthy follower",
+			daemon: &daemon{interval: time.Minute, ready: true,
+				lastLeading: false, lastRun: time.Now().Add(-time.Hour)},
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.daemon.handler().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s returned %d, expected %d", tt.path, rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
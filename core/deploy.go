@@ -0,0 +1,438 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	// DefaultDeployStackSet is the default name of the deployed StackSet
+	DefaultDeployStackSet = "AutoSpotting"
+
+	// stackSetOperationPollInterval is how often the StackSet operations are
+	// checked while waiting for them to complete
+	stackSetOperationPollInterval = 15 * time.Second
+)
+
+// localOnlyFlags are the flags of the commands run locally, which are never
+// passed to the deployed Lambda function.
+var localOnlyFlags = map[string]bool{
+	"analyze":          true,
+	"cleanup":          true,
+	"cleanup_apply":    true,
+	"daemon":           true,
+	"daemon_interval":  true,
+	"event_file":       true,
+	"explain_asg":      true,
+	"explain_instance": true,
+	"iam_policy":       true,
+	"record_api_calls": true,
+	"render_template":  true,
+	"replay_api_calls": true,
+	"revert_asgs":      true,
+	"version":          true,
+}
+
+// isDeployedFlag determines whether the flag given to the deploy command is
+// part of the configuration of the deployed Lambda function.
+func isDeployedFlag(name string) bool {
+	return !localOnlyFlags[name] && name != "deploy" && !strings.HasPrefix(name, "deploy_")
+}
+
+// flagEnvName returns the environment variable setting the flag, as parsed
+// by the flag library.
+func flagEnvName(name string) string {
+	return strings.Replace(strings.ToUpper(name), "-", "_", -1)
+}
+
+// deployer deploys the Lambda function with a StackSet, in the accounts and
+// regions selected by the deploy flags.
+type deployer struct {
+	conf           *Config
+	cloudFormation cloudformationiface.CloudFormationAPI
+	s3             func(region string) s3iface.S3API
+
+	// the account running the deploy command, the default target of the
+	// self-managed StackSets
+	accountID string
+
+	out io.Writer
+}
+
+// Deploy packages the Lambda function, uploads it to the deployment bucket
+// and creates or updates the StackSet running it in the selected accounts and
+// regions, with the configuration given to the deploy command.
+func (a *AutoSpotting) Deploy(w io.Writer) error {
+	sess, err := newSession(a.config.MainRegion, a.config)
+	if err != nil {
+		return err
+	}
+
+	identity, err := sts.New(sess, a.config.serviceConfig(sts.EndpointsID, a.config.MainRegion)).
+		GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Println("Failed to determine the current account:", err.Error())
+		return err
+	}
+
+	r := &region{name: a.config.MainRegion, conf: a.config}
+	r.services.connect(r.name, r.conf)
+
+	d := deployer{
+		conf:           a.config,
+		cloudFormation: r.services.cloudFormation,
+		s3: func(region string) s3iface.S3API {
+			return s3.New(sess, a.config.serviceConfig(s3.EndpointsID, region))
+		},
+		accountID: aws.StringValue(identity.Account),
+		out:       w,
+	}
+	return d.deploy()
+}
+
+// RenderTemplate writes the template deployed by the deploy command with the
+// current configuration.
+func (a *AutoSpotting) RenderTemplate(w io.Writer) error {
+	body, err := renderTemplate(a.config).JSON()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, body)
+	return err
+}
+
+func (d deployer) deploy() error {
+	regions := d.regions()
+	if len(regions) > 1 && !strings.Contains(d.conf.DeployBucket, regionPlaceholder) {
+		return fmt.Errorf("deploying to multiple regions needs a bucket in each region, "+
+			"named using the %s placeholder", regionPlaceholder)
+	}
+
+	binary := d.conf.DeployBinary
+	if binary == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		binary = executable
+	}
+
+	zipped, architecture, err := packageLambda(binary)
+	if err != nil {
+		return err
+	}
+
+	key := packageKey(zipped)
+	if err := d.upload(key, zipped, regions); err != nil {
+		return err
+	}
+
+	body, err := renderTemplate(d.conf).JSON()
+	if err != nil {
+		return err
+	}
+	return d.deployStackSet(body, key, architecture, regions)
+}
+
+// regions returns the regions the function is deployed to, defaulting to the
+// main region.
+func (d deployer) regions() []string {
+	if regions := parseOrganizationUnits(d.conf.DeployRegions); len(regions) > 0 {
+		return regions
+	}
+	return []string{d.conf.MainRegion}
+}
+
+// serviceManaged determines whether the StackSet is deployed to the
+// organizational units, using the service-managed permissions of the
+// organization, instead of the self-managed permissions of the accounts.
+func (d deployer) serviceManaged() bool {
+	return d.conf.DeployOrganizationalUnits != ""
+}
+
+func (d deployer) accounts() []string {
+	if accounts := parseOrganizationUnits(d.conf.DeployAccounts); len(accounts) > 0 {
+		return accounts
+	}
+	return []string{d.accountID}
+}
+
+// packageLambda zips the binary as the bootstrap of a custom Lambda runtime,
+// and returns the Lambda architecture it was built for.
+func packageLambda(binary string) ([]byte, string, error) {
+	content, err := ioutil.ReadFile(binary)
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't read the binary to deploy: %w", err)
+	}
+
+	architecture, err := lambdaArchitecture(content)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	header := &zip.FileHeader{Name: "bootstrap", Method: zip.Deflate}
+	header.SetMode(0755)
+
+	f, err := zw.CreateHeader(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := f.Write(content); err != nil {
+		return nil, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), architecture, nil
+}
+
+// lambdaArchitecture returns the Lambda architecture of the binary, which needs
+// to be built for Linux on x86_64 or arm64 in order to run as the bootstrap of
+// the function.
+func lambdaArchitecture(content []byte) (string, error) {
+	f, err := elf.NewFile(bytes.NewReader(content))
+	if err != nil {
+		return "", fmt.Errorf("the binary to deploy isn't a Linux executable, "+
+			"build it with GOOS=linux and pass it using deploy_binary: %w", err)
+	}
+
+	if f.OSABI != elf.ELFOSABI_NONE && f.OSABI != elf.ELFOSABI_LINUX {
+		return "", fmt.Errorf("the binary to deploy is built for %s instead of Linux", f.OSABI)
+	}
+
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "x86_64", nil
+	case elf.EM_AARCH64:
+		return "arm64", nil
+	}
+	return "", fmt.Errorf("the binary to deploy is built for %s, which isn't supported by Lambda", f.Machine)
+}
+
+// packageKey names the package after its content, so that the functions are
+// only updated when the binary changed.
+func packageKey(zipped []byte) string {
+	return fmt.Sprintf("autospotting/%x.zip", sha256.Sum256(zipped))
+}
+
+// upload copies the package to the bucket of each region, or once when all
+// the regions share the same bucket.
+func (d deployer) upload(key string, zipped []byte, regions []string) error {
+	uploaded := make(map[string]bool)
+	for _, region := range regions {
+		bucket := strings.Replace(d.conf.DeployBucket, regionPlaceholder, region, -1)
+		if uploaded[bucket] {
+			continue
+		}
+
+		_, err := d.s3(region).PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(zipped),
+		})
+		if err != nil {
+			log.Println("Failed to upload the Lambda package to", bucket, err.Error())
+			return err
+		}
+		uploaded[bucket] = true
+		fmt.Fprintf(d.out, "Uploaded the Lambda package to s3://%s/%s\n", bucket, key)
+	}
+	return nil
+}
+
+// deployStackSet creates or updates the StackSet and adds the stack instances
+// missing from the selected accounts and regions. The other parameters are
+// reset to their defaults, which are the values given to the deploy command.
+func (d deployer) deployStackSet(body, key, architecture string, regions []string) error {
+	name := aws.String(d.conf.DeployStackSet)
+	capabilities := aws.StringSlice([]string{cloudformation.CapabilityCapabilityIam})
+	parameters := []*cloudformation.Parameter{{
+		ParameterKey:   aws.String("LambdaPackageKey"),
+		ParameterValue: aws.String(key),
+	}, {
+		ParameterKey:   aws.String("LambdaArchitecture"),
+		ParameterValue: aws.String(architecture),
+	}}
+
+	_, err := d.cloudFormation.DescribeStackSet(&cloudformation.DescribeStackSetInput{StackSetName: name})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cloudformation.ErrCodeStackSetNotFoundException {
+		input := &cloudformation.CreateStackSetInput{
+			StackSetName:    name,
+			Description:     aws.String("Spot instance automation"),
+			TemplateBody:    aws.String(body),
+			Parameters:      parameters,
+			Capabilities:    capabilities,
+			PermissionModel: aws.String(cloudformation.PermissionModelsSelfManaged),
+		}
+		if d.serviceManaged() {
+			input.PermissionModel = aws.String(cloudformation.PermissionModelsServiceManaged)
+			input.AutoDeployment = &cloudformation.AutoDeployment{
+				Enabled:                      aws.Bool(true),
+				RetainStacksOnAccountRemoval: aws.Bool(false),
+			}
+		}
+		if _, err := d.cloudFormation.CreateStackSet(input); err != nil {
+			log.Println("Failed to create the StackSet", *name, err.Error())
+			return err
+		}
+		fmt.Fprintln(d.out, "Created the StackSet", *name)
+	} else if err != nil {
+		log.Println("Failed to describe the StackSet", *name, err.Error())
+		return err
+	} else {
+		out, err := d.cloudFormation.UpdateStackSet(&cloudformation.UpdateStackSetInput{
+			StackSetName: name,
+			TemplateBody: aws.String(body),
+			Parameters:   parameters,
+			Capabilities: capabilities,
+		})
+		if err != nil {
+			log.Println("Failed to update the StackSet", *name, err.Error())
+			return err
+		}
+		fmt.Fprintln(d.out, "Updating the existing stack instances of the StackSet", *name)
+		if err := d.waitForOperation(aws.StringValue(out.OperationId)); err != nil {
+			return err
+		}
+	}
+
+	return d.createStackInstances(regions)
+}
+
+// createStackInstances adds the stack instances missing from the selected
+// accounts and regions. The organizational units only get their stack
+// instances created in the regions without any, since the StackSet is
+// automatically deployed to the accounts later added to them.
+func (d deployer) createStackInstances(regions []string) error {
+	name := aws.String(d.conf.DeployStackSet)
+
+	existing := make(map[string]bool)
+	err := d.cloudFormation.ListStackInstancesPages(&cloudformation.ListStackInstancesInput{StackSetName: name},
+		func(page *cloudformation.ListStackInstancesOutput, lastPage bool) bool {
+			for _, i := range page.Summaries {
+				existing[aws.StringValue(i.Region)] = true
+				existing[aws.StringValue(i.Account)+"/"+aws.StringValue(i.Region)] = true
+			}
+			return true
+		})
+	if err != nil {
+		log.Println("Failed to list the stack instances of", *name, err.Error())
+		return err
+	}
+
+	var inputs []*cloudformation.CreateStackInstancesInput
+	if d.serviceManaged() {
+		var missing []string
+		for _, region := range regions {
+			if !existing[region] {
+				missing = append(missing, region)
+			}
+		}
+		if len(missing) > 0 {
+			inputs = append(inputs, &cloudformation.CreateStackInstancesInput{
+				StackSetName: name,
+				Regions:      aws.StringSlice(missing),
+				DeploymentTargets: &cloudformation.DeploymentTargets{
+					OrganizationalUnitIds: aws.StringSlice(parseOrganizationUnits(d.conf.DeployOrganizationalUnits)),
+				},
+			})
+		}
+	} else {
+		// group the regions missing the same accounts into a single operation
+		missing := make(map[string][]string)
+		for _, region := range regions {
+			var accounts []string
+			for _, account := range d.accounts() {
+				if !existing[account+"/"+region] {
+					accounts = append(accounts, account)
+				}
+			}
+			if len(accounts) > 0 {
+				key := strings.Join(accounts, ",")
+				missing[key] = append(missing[key], region)
+			}
+		}
+
+		var keys []string
+		for key := range missing {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			inputs = append(inputs, &cloudformation.CreateStackInstancesInput{
+				StackSetName: name,
+				Accounts:     aws.StringSlice(strings.Split(key, ",")),
+				Regions:      aws.StringSlice(missing[key]),
+			})
+		}
+	}
+
+	for _, input := range inputs {
+		out, err := d.cloudFormation.CreateStackInstances(input)
+		if err != nil {
+			log.Println("Failed to create the stack instances of", *name, err.Error())
+			return err
+		}
+		targets := aws.StringValueSlice(input.Accounts)
+		if input.DeploymentTargets != nil {
+			targets = aws.StringValueSlice(input.DeploymentTargets.OrganizationalUnitIds)
+		}
+		fmt.Fprintf(d.out, "Creating stack instances in %s, regions %s\n",
+			strings.Join(targets, ", "), strings.Join(aws.StringValueSlice(input.Regions), ", "))
+		if err := d.waitForOperation(aws.StringValue(out.OperationId)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForOperation waits for the StackSet operation to complete, since the
+// next operation on the same StackSet can only start afterwards.
+func (d deployer) waitForOperation(id string) error {
+	clock := d.conf.getClock()
+	for {
+		out, err := d.cloudFormation.DescribeStackSetOperation(&cloudformation.DescribeStackSetOperationInput{
+			StackSetName: aws.String(d.conf.DeployStackSet),
+			OperationId:  aws.String(id),
+		})
+		if err != nil {
+			log.Println("Failed to describe the StackSet operation", id, err.Error())
+			return err
+		}
+
+		switch status := aws.StringValue(out.StackSetOperation.Status); status {
+		case cloudformation.StackSetOperationStatusSucceeded:
+			fmt.Fprintln(d.out, "StackSet operation", id, "succeeded")
+			return nil
+		case cloudformation.StackSetOperationStatusFailed, cloudformation.StackSetOperationStatusStopped:
+			return errors.New("StackSet operation " + id + " " + strings.ToLower(status))
+		}
+		clock.Sleep(stackSetOperationPollInterval)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+func Test_isDeployedFlag(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "tag_filtering_mode", want: true},
+		{name: "regions", want: true},
+		{name: "deploy"},
+		{name: "deploy_bucket"},
+		{name: "cleanup_apply"},
+		{name: "event_file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDeployedFlag(tt.name); got != tt.want {
+				t.Errorf("isDeployedFlag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeELFBinary writes the ELF header of a Linux executable built for the
+// given machine.
+func writeELFBinary(t *testing.T, machine elf.Machine) string {
+	header := elf.Header64{
+		Ident:   [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)},
+		Type:    uint16(elf.ET_EXEC),
+		Machine: uint16(machine),
+		Version: uint32(elf.EV_CURRENT),
+		Ehsize:  64,
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "AutoSpotting")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_lambdaArchitecture(t *testing.T) {
+	tests := []struct {
+		name    string
+		machine elf.Machine
+		want    string
+		wantErr bool
+	}{
+		{name: "x86_64", machine: elf.EM_X86_64, want: "x86_64"},
+		{name: "arm64", machine: elf.EM_AARCH64, want: "arm64"},
+		{name: "unsupported", machine: elf.EM_386, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := ioutil.ReadFile(writeELFBinary(t, tt.machine))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := lambdaArchitecture(content)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("lambdaArchitecture() = %q, %v, want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := lambdaArchitecture([]byte("\xcf\xfa\xed\xfe macOS binary")); err == nil {
+		t.Errorf("lambdaArchitecture() didn't fail for a binary which isn't an ELF executable")
+	}
+}
+
+func Test_packageLambda(t *testing.T) {
+	binary := writeELFBinary(t, elf.EM_AARCH64)
+
+	zipped, architecture, err := packageLambda(binary)
+	if err != nil {
+		t.Fatalf("packageLambda() error = %v", err)
+	}
+	if architecture != "arm64" {
+		t.Errorf("packageLambda() architecture = %s, want arm64", architecture)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(zipped), int64(len(zipped)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "bootstrap" || zr.File[0].Mode() != 0755 {
+		t.Fatalf("packageLambda() didn't package the binary as an executable bootstrap")
+	}
+
+	if _, _, err := packageLambda(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("packageLambda() didn't fail for a missing binary")
+	}
+}
+
+func Test_deployer_deploy(t *testing.T) {
+	linuxBinary := writeELFBinary(t, elf.EM_X86_64)
+	otherBinary := filepath.Join(t.TempDir(), "AutoSpotting")
+	if err := ioutil.WriteFile(otherBinary, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	notFound := awserr.New(cloudformation.ErrCodeStackSetNotFoundException, "not found", nil)
+	instance := func(account, region string) *cloudformation.StackInstanceSummary {
+		return &cloudformation.StackInstanceSummary{Account: aws.String(account), Region: aws.String(region)}
+	}
+
+	tests := []struct {
+		name                string
+		conf                Config
+		binary              string
+		cf                  mockCloudFormation
+		wantUploads         []string
+		wantPermissionModel string
+		wantUpdate          bool
+		wantInstances       []*cloudformation.CreateStackInstancesInput
+		wantErr             bool
+	}{
+		{
+			name:                "new self-managed StackSet in the current account",
+			conf:                Config{DeployBucket: "my-bucket"},
+			cf:                  mockCloudFormation{dsserr: notFound, dssostatus: cloudformation.StackSetOperationStatusSucceeded},
+			wantUploads:         []string{"my-bucket"},
+			wantPermissionModel: cloudformation.PermissionModelsSelfManaged,
+			wantInstances: []*cloudformation.CreateStackInstancesInput{{
+				StackSetName: aws.String("AutoSpotting"),
+				Accounts:     aws.StringSlice([]string{"123456789012"}),
+				Regions:      aws.StringSlice([]string{"us-east-1"}),
+			}},
+		},
+		{
+			name: "existing StackSet missing some stack instances",
+			conf: Config{
+				DeployBucket:   "my-bucket-" + regionPlaceholder,
+				DeployAccounts: "111111111111,222222222222",
+				DeployRegions:  "us-east-1,eu-west-1",
+			},
+			cf: mockCloudFormation{
+				lsio: &cloudformation.ListStackInstancesOutput{Summaries: []*cloudformation.StackInstanceSummary{
+					instance("111111111111", "us-east-1"),
+					instance("222222222222", "us-east-1"),
+					instance("111111111111", "eu-west-1"),
+				}},
+				dssostatus: cloudformation.StackSetOperationStatusSucceeded,
+			},
+			wantUploads: []string{"my-bucket-us-east-1", "my-bucket-eu-west-1"},
+			wantUpdate:  true,
+			wantInstances: []*cloudformation.CreateStackInstancesInput{{
+				StackSetName: aws.String("AutoSpotting"),
+				Accounts:     aws.StringSlice([]string{"222222222222"}),
+				Regions:      aws.StringSlice([]string{"eu-west-1"}),
+			}},
+		},
+		{
+			name: "new service-managed StackSet",
+			conf: Config{
+				DeployBucket:              "my-bucket-" + regionPlaceholder,
+				DeployOrganizationalUnits: "ou-ab12-prod",
+				DeployRegions:             "us-east-1,eu-west-1",
+			},
+			cf:                  mockCloudFormation{dsserr: notFound, dssostatus: cloudformation.StackSetOperationStatusSucceeded},
+			wantUploads:         []string{"my-bucket-us-east-1", "my-bucket-eu-west-1"},
+			wantPermissionModel: cloudformation.PermissionModelsServiceManaged,
+			wantInstances: []*cloudformation.CreateStackInstancesInput{{
+				StackSetName: aws.String("AutoSpotting"),
+				Regions:      aws.StringSlice([]string{"us-east-1", "eu-west-1"}),
+				DeploymentTargets: &cloudformation.DeploymentTargets{
+					OrganizationalUnitIds: aws.StringSlice([]string{"ou-ab12-prod"}),
+				},
+			}},
+		},
+		{
+			name:    "multiple regions sharing a bucket",
+			conf:    Config{DeployBucket: "my-bucket", DeployRegions: "us-east-1,eu-west-1"},
+			wantErr: true,
+		},
+		{
+			name:        "failed update",
+			conf:        Config{DeployBucket: "my-bucket"},
+			cf:          mockCloudFormation{dssostatus: cloudformation.StackSetOperationStatusFailed},
+			wantUploads: []string{"my-bucket"},
+			wantUpdate:  true,
+			wantErr:     true,
+		},
+		{
+			name:    "binary which can't run on Lambda",
+			conf:    Config{DeployBucket: "my-bucket"},
+			binary:  otherBinary,
+			wantErr: true,
+		},
+		{
+			name:        "failing to describe the StackSet",
+			conf:        Config{DeployBucket: "my-bucket"},
+			cf:          mockCloudFormation{dsserr: errors.New("AccessDenied")},
+			wantUploads: []string{"my-bucket"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.MainRegion = "us-east-1"
+			tt.conf.DeployBinary = linuxBinary
+			if tt.binary != "" {
+				tt.conf.DeployBinary = tt.binary
+			}
+			tt.conf.DeployStackSet = DefaultDeployStackSet
+			tt.conf.clock = &mockClock{}
+
+			tt.cf.cssin = &[]*cloudformation.CreateStackSetInput{}
+			tt.cf.ussin = &[]*cloudformation.UpdateStackSetInput{}
+			tt.cf.csiin = &[]*cloudformation.CreateStackInstancesInput{}
+
+			var uploads []string
+			buckets := make(map[string]map[string][]byte)
+
+			var out bytes.Buffer
+			d := deployer{
+				conf:           &tt.conf,
+				cloudFormation: tt.cf,
+				s3: func(region string) s3iface.S3API {
+					bucket := "my-bucket"
+					if tt.conf.DeployBucket != bucket {
+						bucket += "-" + region
+					}
+					uploads = append(uploads, bucket)
+					buckets[bucket] = make(map[string][]byte)
+					return mockS3{poBodies: buckets[bucket]}
+				},
+				accountID: "123456789012",
+				out:       &out,
+			}
+
+			err := d.deploy()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deploy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(uploads, tt.wantUploads) {
+				t.Errorf("deploy() uploaded the package to %v, want %v", uploads, tt.wantUploads)
+			}
+			for bucket, objects := range buckets {
+				if len(objects) != 1 {
+					t.Errorf("deploy() uploaded %d objects to %s", len(objects), bucket)
+				}
+			}
+
+			var permissionModel string
+			if len(*tt.cf.cssin) > 0 {
+				permissionModel = aws.StringValue((*tt.cf.cssin)[0].PermissionModel)
+
+				want := &cloudformation.Parameter{ParameterKey: aws.String("LambdaArchitecture"), ParameterValue: aws.String("x86_64")}
+				if got := (*tt.cf.cssin)[0].Parameters; len(got) != 2 || !reflect.DeepEqual(got[1], want) {
+					t.Errorf("deploy() created the StackSet with the parameters %v, want the architecture %v", got, want)
+				}
+			}
+			if permissionModel != tt.wantPermissionModel {
+				t.Errorf("deploy() created a StackSet with %q permissions, want %q", permissionModel, tt.wantPermissionModel)
+			}
+			if got := len(*tt.cf.ussin) > 0; got != tt.wantUpdate {
+				t.Errorf("deploy() updated the StackSet: %v, want %v", got, tt.wantUpdate)
+			}
+			if len(*tt.cf.csiin) != 0 || len(tt.wantInstances) != 0 {
+				if !reflect.DeepEqual(*tt.cf.csiin, tt.wantInstances) {
+					t.Errorf("deploy() created the stack instances %v, want %v", *tt.cf.csiin, tt.wantInstances)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// The mutating actions which can be disabled independently of each other.
+const (
+	// LaunchSpotAction launches the spot instances replacing the on-demand
+	// instances
+	LaunchSpotAction = "launch_spot"
+
+	// AttachSpotAction attaches the spot instances to their groups
+	AttachSpotAction = "attach_spot"
+
+	// TerminateOnDemandAction terminates the on-demand instances once their
+	// spot replacements are attached
+	TerminateOnDemandAction = "terminate_on_demand"
+
+	// TerminateSpotAction terminates the spot instances which are no longer
+	// needed, such as orphaned or excess ones
+	TerminateSpotAction = "terminate_spot"
+
+	// ModifyMaxSizeAction temporarily increases the MaxSize of the groups
+	// running at their maximum capacity, so that spot instances can be
+	// attached to them
+	ModifyMaxSizeAction = "modify_max_size"
+)
+
+// mutatingActions lists all the actions that can be disabled.
+var mutatingActions = []string{
+	LaunchSpotAction,
+	AttachSpotAction,
+	TerminateOnDemandAction,
+	TerminateSpotAction,
+	ModifyMaxSizeAction,
+}
+
+// parseDisabledActions validates the comma-separated list of disabled actions,
+// returning an error for unknown actions.
+func parseDisabledActions(value string) (map[string]bool, error) {
+	known := make(map[string]bool, len(mutatingActions))
+	for _, action := range mutatingActions {
+		known[action] = true
+	}
+
+	actions := make(map[string]bool)
+	for _, action := range strings.Split(value, ",") {
+		action = strings.TrimSpace(action)
+		if action == "" {
+			continue
+		}
+		if !known[action] {
+			return nil, fmt.Errorf("unknown action %q, expected one of %s",
+				action, strings.Join(mutatingActions, ","))
+		}
+		actions[action] = true
+	}
+	return actions, nil
+}
+
+// isActionDisabled determines if the given mutating action was disabled for
+// the group, globally or using its tags.
+func (a *autoScalingGroup) isActionDisabled(action string) bool {
+	if a == nil {
+		return false
+	}
+
+	actions, err := parseDisabledActions(a.config.DisabledActions)
+	if err != nil {
+		return false
+	}
+	return actions[action]
+}
+
+// actionDisabledError is returned when a replacement can't proceed because one
+// of the actions it needs was disabled.
+func (a *autoScalingGroup) actionDisabledError(action string) error {
+	log.Printf("The %s action is disabled for the group %s, skipping", action, a.name)
+	return fmt.Errorf("%s for the group %s: %w", action, a.name, ErrActionDisabled)
+}
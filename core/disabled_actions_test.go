@@ -0,0 +1,126 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_parseDisabledActions(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    map[string]bool
+		expectedErr bool
+	}{
+		{name: "empty", value: "", expected: map[string]bool{}},
+		{
+			name:     "several actions with spaces",
+			value:    "launch_spot, modify_max_size",
+			expected: map[string]bool{LaunchSpotAction: true, ModifyMaxSizeAction: true},
+		},
+		{name: "unknown action", value: "launch_spot,reboot", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDisabledActions(tt.value)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("parseDisabledActions() error = %v, expectedErr %v", err, tt.expectedErr)
+			}
+			if !tt.expectedErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseDisabledActions() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_isActionDisabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		asg      *autoScalingGroup
+		action   string
+		expected bool
+	}{
+		{name: "nil group", action: LaunchSpotAction, expected: false},
+		{
+			name:     "disabled action",
+			asg:      &autoScalingGroup{config: AutoScalingConfig{DisabledActions: "terminate_on_demand"}},
+			action:   TerminateOnDemandAction,
+			expected: true,
+		},
+		{
+			name:     "enabled action",
+			asg:      &autoScalingGroup{config: AutoScalingConfig{DisabledActions: "terminate_on_demand"}},
+			action:   AttachSpotAction,
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.asg.isActionDisabled(tt.action); got != tt.expected {
+				t.Errorf("isActionDisabled() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadDisabledActions(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected string
+	}{
+		{name: "no tag", expected: "modify_max_size"},
+		{name: "valid tag", tagValue: aws.String("launch_spot"), expected: "launch_spot"},
+		{name: "empty tag enabling all actions", tagValue: aws.String(""), expected: ""},
+		{name: "invalid tag", tagValue: aws.String("reboot"), expected: "modify_max_size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{DisabledActions: "modify_max_size"}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(DisabledActionsTag), Value: tt.tagValue}}
+			}
+
+			a.loadDisabledActions()
+
+			if a.config.DisabledActions != tt.expected {
+				t.Errorf("DisabledActions = %q, expected %q", a.config.DisabledActions, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_disabledActionsSkipChanges(t *testing.T) {
+	asg := &autoScalingGroup{
+		name:   "asg",
+		config: AutoScalingConfig{DisabledActions: "launch_spot,terminate_spot"},
+	}
+	spot := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:        aws.String("i-spot"),
+			InstanceLifecycle: aws.String(Spot),
+		},
+		region: &region{services: connections{ec2: mockEC2{}}},
+		asg:    asg,
+	}
+
+	if _, err := (&instance{asg: asg}).launchSpotReplacement(); !errors.Is(err, ErrActionDisabled) {
+		t.Errorf("launchSpotReplacement() error = %v, expected %v", err, ErrActionDisabled)
+	}
+
+	action := terminateUnneededSpotInstance{target: target{asg: asg, spotInstance: spot}}
+	if err := action.run(); !errors.Is(err, ErrActionDisabled) {
+		t.Errorf("terminateUnneededSpotInstance.run() error = %v, expected %v", err, ErrActionDisabled)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// spotInstanceTypeUsage counts the running and pending spot instances of the
+// group by instance type.
+func (a *autoScalingGroup) spotInstanceTypeUsage() map[string]int {
+	usage := make(map[string]int)
+
+	for _, inst := range a.instances.instances() {
+		if !inst.isSpot() {
+			continue
+		}
+		switch inst.stateName() {
+		case ec2.InstanceStateNameRunning, ec2.InstanceStateNamePending:
+			usage[aws.StringValue(inst.InstanceType)]++
+		}
+	}
+	return usage
+}
+
+// diversifyInstanceTypes reorders the cheapest compatible spot instance types,
+// up to the group's Diversification setting, so that the least used of them
+// in the group is attempted first, spreading the spot instances across
+// multiple spot pools. The more expensive instance types keep their order.
+func (a *autoScalingGroup) diversifyInstanceTypes(instanceTypes []instanceTypeInformation) []instanceTypeInformation {
+	n := int(a.config.Diversification)
+	if n > len(instanceTypes) {
+		n = len(instanceTypes)
+	}
+	if n <= 1 {
+		return instanceTypes
+	}
+
+	usage := a.spotInstanceTypeUsage()
+
+	diversified := make([]instanceTypeInformation, len(instanceTypes))
+	copy(diversified, instanceTypes)
+
+	sort.SliceStable(diversified[:n], func(i, j int) bool {
+		return usage[diversified[i].instanceType] < usage[diversified[j].instanceType]
+	})
+
+	return diversified
+}
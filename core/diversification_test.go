@@ -0,0 +1,122 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func diversificationTestInstance(id, instanceType, lifecycle, state string) *instance {
+	return &instance{Instance: &ec2.Instance{
+		InstanceId:        aws.String(id),
+		InstanceType:      aws.String(instanceType),
+		InstanceLifecycle: aws.String(lifecycle),
+		State:             &ec2.InstanceState{Name: aws.String(state)},
+	}}
+}
+
+func Test_autoScalingGroup_diversifyInstanceTypes(t *testing.T) {
+	candidates := []instanceTypeInformation{
+		{instanceType: "m5.large"},
+		{instanceType: "m5a.large"},
+		{instanceType: "m4.large"},
+		{instanceType: "c5.xlarge"},
+	}
+
+	instances := instanceMap{
+		"i-1": diversificationTestInstance("i-1", "m5.large", Spot, ec2.InstanceStateNameRunning),
+		"i-2": diversificationTestInstance("i-2", "m5.large", Spot, ec2.InstanceStateNamePending),
+		"i-3": diversificationTestInstance("i-3", "m5a.large", Spot, ec2.InstanceStateNameRunning),
+		"i-4": diversificationTestInstance("i-4", "m4.large", Spot, ec2.InstanceStateNameTerminated),
+		"i-5": diversificationTestInstance("i-5", "m4.large", "", ec2.InstanceStateNameRunning),
+	}
+
+	tests := []struct {
+		name            string
+		diversification int64
+		expected        []string
+	}{
+		{
+			name:            "disabled",
+			diversification: 1,
+			expected:        []string{"m5.large", "m5a.large", "m4.large", "c5.xlarge"},
+		},
+		{
+			name:            "unset",
+			diversification: 0,
+			expected:        []string{"m5.large", "m5a.large", "m4.large", "c5.xlarge"},
+		},
+		{
+			name:            "two cheapest types",
+			diversification: 2,
+			expected:        []string{"m5a.large", "m5.large", "m4.large", "c5.xlarge"},
+		},
+		{
+			name:            "three cheapest types",
+			diversification: 3,
+			expected:        []string{"m4.large", "m5a.large", "m5.large", "c5.xlarge"},
+		},
+		{
+			name:            "more than available",
+			diversification: 10,
+			expected:        []string{"m4.large", "c5.xlarge", "m5a.large", "m5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:     &autoscaling.Group{},
+				instances: makeInstancesWithCatalog(instances),
+				config:    AutoScalingConfig{Diversification: tt.diversification},
+			}
+
+			var got []string
+			for _, it := range a.diversifyInstanceTypes(candidates) {
+				got = append(got, it.instanceType)
+			}
+
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("diversifyInstanceTypes() = %v, expected %v", got, tt.expected)
+			}
+			if candidates[0].instanceType != "m5.large" {
+				t.Errorf("diversifyInstanceTypes() modified its input")
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadDiversification(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected int64
+	}{
+		{name: "no tag", expected: 2},
+		{name: "valid tag", tagValue: aws.String("4"), expected: 4},
+		{name: "invalid tag", tagValue: aws.String("many"), expected: 2},
+		{name: "out of range tag", tagValue: aws.String("0"), expected: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{Diversification: 2}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(DiversificationTag), Value: tt.tagValue}}
+			}
+
+			a.loadDiversification()
+
+			if a.config.Diversification != tt.expected {
+				t.Errorf("Diversification = %d, expected %d", a.config.Diversification, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+//go:build e2e
+// +build e2e
+
+package autospotting
+
+// The end-to-end tests run the full replacement flow against a local AWS API
+// emulator such as LocalStack or moto, and are only built with the e2e tag:
+//
+//   docker run -d -p 4566:4566 localstack/localstack
+//   make e2e-test
+//
+// The emulator endpoint can be changed with AUTOSPOTTING_E2E_ENDPOINT.
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+const (
+	e2eDefaultEndpoint = "http://localhost:4566"
+	e2eRegion          = "us-east-1"
+	e2eGroupName       = "autospotting-e2e"
+)
+
+func e2eConfig(t *testing.T) *Config {
+	endpoint := os.Getenv("AUTOSPOTTING_E2E_ENDPOINT")
+	if endpoint == "" {
+		endpoint = e2eDefaultEndpoint
+	}
+
+	// the emulators accept any credentials
+	for _, v := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		if os.Getenv(v) == "" {
+			os.Setenv(v, "test")
+		}
+	}
+
+	data, err := ec2instancesinfo.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Config{
+		MainRegion:       e2eRegion,
+		EndpointURL:      endpoint,
+		InstanceData:     data,
+		FinalRecap:       make(map[string][]string),
+		FilterByTags:     "spot-enabled=true",
+		TagFilteringMode: "opt-in",
+		errorBudget:      newErrorBudget(0, 0),
+		AutoScalingConfig: AutoScalingConfig{
+			CronSchedule:              "* *",
+			CronTimezone:              "UTC",
+			CronScheduleState:         "on",
+			OnDemandPriceMultiplier:   1,
+			SpotPriceBufferPercentage: DefaultSpotPriceBufferPercentage,
+			SpotProductDescription:    DefaultSpotProductDescription,
+		},
+	}
+}
+
+// e2eSetupGroup creates a spot-enabled group running a single on-demand
+// instance.
+func e2eSetupGroup(t *testing.T, r *region) {
+	lcName := e2eGroupName + "-lc"
+
+	_, err := r.services.autoScaling.CreateLaunchConfiguration(&autoscaling.CreateLaunchConfigurationInput{
+		LaunchConfigurationName: aws.String(lcName),
+		ImageId:                 aws.String("ami-03cf127a"),
+		InstanceType:            aws.String("m5.large"),
+	})
+	if err != nil {
+		t.Fatalf("failed to create the launch configuration: %v", err)
+	}
+
+	_, err = r.services.autoScaling.CreateAutoScalingGroup(&autoscaling.CreateAutoScalingGroupInput{
+		AutoScalingGroupName:    aws.String(e2eGroupName),
+		LaunchConfigurationName: aws.String(lcName),
+		AvailabilityZones:       []*string{aws.String(e2eRegion + "a")},
+		MinSize:                 aws.Int64(1),
+		MaxSize:                 aws.Int64(1),
+		DesiredCapacity:         aws.Int64(1),
+		HealthCheckGracePeriod:  aws.Int64(0),
+		Tags: []*autoscaling.Tag{
+			{
+				Key:               aws.String("spot-enabled"),
+				Value:             aws.String("true"),
+				PropagateAtLaunch: aws.Bool(false),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create the AutoScaling group: %v", err)
+	}
+}
+
+func e2eTeardownGroup(r *region) {
+	r.services.autoScaling.DeleteAutoScalingGroup(&autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(e2eGroupName),
+		ForceDelete:          aws.Bool(true),
+	})
+	r.services.autoScaling.DeleteLaunchConfiguration(&autoscaling.DeleteLaunchConfigurationInput{
+		LaunchConfigurationName: aws.String(e2eGroupName + "-lc"),
+	})
+}
+
+// e2eGroupInstances returns the lifecycle of the running instances attached to
+// the group, keyed by instance ID.
+func e2eGroupInstances(t *testing.T, r *region) map[string]string {
+	out, err := r.services.autoScaling.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(e2eGroupName)},
+	})
+	if err != nil || len(out.AutoScalingGroups) != 1 {
+		t.Fatalf("failed to describe the AutoScaling group: %v", err)
+	}
+
+	ids := []*string{}
+	for _, i := range out.AutoScalingGroups[0].Instances {
+		ids = append(ids, i.InstanceId)
+	}
+
+	result := make(map[string]string)
+	if len(ids) == 0 {
+		return result
+	}
+
+	instances, err := r.services.ec2.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: ids})
+	if err != nil {
+		t.Fatalf("failed to describe the group instances: %v", err)
+	}
+	for _, res := range instances.Reservations {
+		for _, i := range res.Instances {
+			if aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning {
+				continue
+			}
+			lifecycle := aws.StringValue(i.InstanceLifecycle)
+			if lifecycle == "" {
+				lifecycle = "on-demand"
+			}
+			result[aws.StringValue(i.InstanceId)] = lifecycle
+		}
+	}
+	return result
+}
+
+// e2eLaunchedSpotInstances returns the IDs of the spot instances launched by
+// AutoSpotting for the group.
+func e2eLaunchedSpotInstances(t *testing.T, r *region) []string {
+	out, err := r.services.ec2.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:launched-for-asg"), Values: []*string{aws.String(e2eGroupName)}},
+			{Name: aws.String("instance-state-name"), Values: []*string{aws.String(ec2.InstanceStateNameRunning)}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to describe the launched spot instances: %v", err)
+	}
+
+	var ids []string
+	for _, res := range out.Reservations {
+		for _, i := range res.Instances {
+			ids = append(ids, aws.StringValue(i.InstanceId))
+		}
+	}
+	return ids
+}
+
+func TestE2E_replaceOnDemandInstance(t *testing.T) {
+	conf := e2eConfig(t)
+
+	setup := &region{name: e2eRegion, conf: conf}
+	setup.services.connect(e2eRegion, conf)
+
+	e2eSetupGroup(t, setup)
+	defer e2eTeardownGroup(setup)
+
+	before := e2eGroupInstances(t, setup)
+	if len(before) != 1 {
+		t.Fatalf("group instances = %v, expected a single on-demand instance", before)
+	}
+
+	// the first run launches the spot replacement
+	(&region{name: e2eRegion, conf: conf}).processRegion()
+
+	launched := e2eLaunchedSpotInstances(t, setup)
+	if len(launched) != 1 {
+		t.Fatalf("launched spot instances = %v, expected one", launched)
+	}
+
+	// the second run swaps the spot instance with the on-demand one
+	conf.clock = &mockClock{now: time.Now().Add(time.Hour)}
+	(&region{name: e2eRegion, conf: conf}).processRegion()
+
+	after := e2eGroupInstances(t, setup)
+	if len(after) != 1 || after[launched[0]] != "spot" {
+		t.Errorf("group instances = %v, expected only the spot instance %s", after, launched[0])
+	}
+
+	for id := range before {
+		if _, attached := after[id]; attached {
+			t.Errorf("on-demand instance %s is still attached to the group", id)
+		}
+	}
+}
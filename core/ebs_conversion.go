@@ -0,0 +1,298 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// NoEBSVolumeConversions disables the conversion of the EBS volume types
+	NoEBSVolumeConversions = "none"
+
+	// matchPerformance sets the IOPS or throughput of the converted volumes
+	// to the baseline performance of their original volume type
+	matchPerformance = "match"
+
+	// the baseline performance included in the price of the gp3 volumes
+	gp3BaselineIOPS       = 3000
+	gp3BaselineThroughput = 125
+
+	// the largest gp2 volumes with the lower throughput, in GiB
+	gp2LowThroughputSize = 170
+)
+
+// ebsVolumeConversion converts the EBS volumes of a source volume type to a
+// target volume type when they match its size and IOPS thresholds.
+type ebsVolumeConversion struct {
+	source string
+	target string
+
+	// the size range of the converted volumes in GiB, 0 meaning no limit
+	minSize int64
+	maxSize int64
+
+	// the highest provisioned IOPS of the converted volumes, 0 meaning no
+	// limit
+	maxIOPS int64
+
+	// the IOPS and throughput of the converted volumes, either a number,
+	// matchPerformance or empty for keeping the original value
+	iops       string
+	throughput string
+}
+
+// parseEBSVolumeConversions parses the comma-separated list of volume type
+// conversions, such as "io1:io2,gp2:gp3 max_size=500 throughput=match". An
+// empty value falls back to the default conversions, while "none" disables
+// them.
+func parseEBSVolumeConversions(value string) ([]ebsVolumeConversion, error) {
+	if strings.TrimSpace(value) == NoEBSVolumeConversions {
+		return []ebsVolumeConversion{}, nil
+	}
+
+	var conversions []ebsVolumeConversion
+	for _, rule := range strings.Split(value, ",") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+
+		c, err := parseEBSVolumeConversion(fields)
+		if err != nil {
+			return nil, err
+		}
+		conversions = append(conversions, c)
+	}
+	return conversions, nil
+}
+
+func parseEBSVolumeConversion(fields []string) (ebsVolumeConversion, error) {
+	var c ebsVolumeConversion
+
+	c.source, c.target = splitKeyValue(fields[0], ":")
+	if !isEBSVolumeType(c.source) || !isEBSVolumeType(c.target) || c.source == c.target {
+		return c, fmt.Errorf("invalid volume type conversion %q, expected source:target volume types", fields[0])
+	}
+
+	for _, option := range fields[1:] {
+		key, value := splitKeyValue(option, "=")
+
+		var err error
+		switch key {
+		case "min_size":
+			c.minSize, err = parsePositiveInt(value)
+		case "max_size":
+			c.maxSize, err = parsePositiveInt(value)
+		case "max_iops":
+			c.maxIOPS, err = parsePositiveInt(value)
+		case "iops":
+			if !supportsIOPS(c.target) {
+				return c, fmt.Errorf("the %s volume type doesn't support provisioned IOPS", c.target)
+			}
+			c.iops = value
+			if value != matchPerformance {
+				_, err = parsePositiveInt(value)
+			}
+		case "throughput":
+			if c.target != ec2.VolumeTypeGp3 {
+				return c, fmt.Errorf("the %s volume type doesn't support provisioned throughput", c.target)
+			}
+			c.throughput = value
+			if value != matchPerformance {
+				_, err = parsePositiveInt(value)
+			}
+		default:
+			return c, fmt.Errorf("unknown volume type conversion option %q", option)
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid volume type conversion option %q", option)
+		}
+	}
+	return c, nil
+}
+
+func parsePositiveInt(value string) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err == nil && n <= 0 {
+		err = fmt.Errorf("%d isn't positive", n)
+	}
+	return n, err
+}
+
+func isEBSVolumeType(volumeType string) bool {
+	for _, t := range ec2.VolumeType_Values() {
+		if t == volumeType {
+			return true
+		}
+	}
+	return false
+}
+
+func supportsIOPS(volumeType string) bool {
+	switch volumeType {
+	case ec2.VolumeTypeIo1, ec2.VolumeTypeIo2, ec2.VolumeTypeGp3:
+		return true
+	}
+	return false
+}
+
+// defaultEBSVolumeConversions converts io1 volumes to io2, and the gp2
+// volumes up to the given size to gp3.
+func defaultEBSVolumeConversions(gp2Threshold int64) []ebsVolumeConversion {
+	return []ebsVolumeConversion{
+		{source: ec2.VolumeTypeIo1, target: ec2.VolumeTypeIo2},
+		{source: ec2.VolumeTypeGp2, target: ec2.VolumeTypeGp3, maxSize: gp2Threshold},
+	}
+}
+
+// ebsVolumeConversions returns the volume type conversions configured for the
+// group.
+func (a *autoScalingGroup) ebsVolumeConversions() []ebsVolumeConversion {
+	if a.config.EBSVolumeConversions == "" {
+		return defaultEBSVolumeConversions(a.config.GP2ConversionThreshold)
+	}
+
+	conversions, err := parseEBSVolumeConversions(a.config.EBSVolumeConversions)
+	if err != nil {
+		return nil
+	}
+	return conversions
+}
+
+// matches determines if the conversion applies to the volume.
+func (c ebsVolumeConversion) matches(ebs *ec2.EbsBlockDevice) bool {
+	size := aws.Int64Value(ebs.VolumeSize)
+	iops := aws.Int64Value(ebs.Iops)
+
+	return aws.StringValue(ebs.VolumeType) == c.source &&
+		(c.minSize == 0 || size >= c.minSize) &&
+		(c.maxSize == 0 || size <= c.maxSize) &&
+		(c.maxIOPS == 0 || iops <= c.maxIOPS)
+}
+
+// apply converts the volume to the target volume type, setting its IOPS and
+// throughput.
+func (c ebsVolumeConversion) apply(ebs *ec2.EbsBlockDevice) {
+	iops := c.targetIOPS(ebs)
+	throughput := c.targetThroughput(ebs)
+
+	ebs.VolumeType = aws.String(c.target)
+	ebs.Iops = iops
+	ebs.Throughput = throughput
+}
+
+func (c ebsVolumeConversion) targetIOPS(ebs *ec2.EbsBlockDevice) *int64 {
+	if !supportsIOPS(c.target) {
+		return nil
+	}
+
+	switch c.iops {
+	case "":
+		return ebs.Iops
+	case matchPerformance:
+		iops := baselineIOPS(ebs)
+		if c.target == ec2.VolumeTypeGp3 && iops <= gp3BaselineIOPS {
+			return nil
+		}
+		if iops == 0 {
+			return ebs.Iops
+		}
+		return aws.Int64(iops)
+	}
+
+	iops, _ := strconv.ParseInt(c.iops, 10, 64)
+	return aws.Int64(iops)
+}
+
+func (c ebsVolumeConversion) targetThroughput(ebs *ec2.EbsBlockDevice) *int64 {
+	if c.target != ec2.VolumeTypeGp3 {
+		return nil
+	}
+
+	switch c.throughput {
+	case "":
+		return ebs.Throughput
+	case matchPerformance:
+		throughput := baselineThroughput(ebs)
+		if throughput <= gp3BaselineThroughput {
+			return nil
+		}
+		return aws.Int64(throughput)
+	}
+
+	throughput, _ := strconv.ParseInt(c.throughput, 10, 64)
+	return aws.Int64(throughput)
+}
+
+// baselineIOPS returns the IOPS the volume gets without bursting, or 0 when
+// not known.
+func baselineIOPS(ebs *ec2.EbsBlockDevice) int64 {
+	switch aws.StringValue(ebs.VolumeType) {
+	case ec2.VolumeTypeGp2:
+		// 3 IOPS per GiB, between 100 and 16000 IOPS
+		iops := 3 * aws.Int64Value(ebs.VolumeSize)
+		if iops < 100 {
+			return 100
+		}
+		if iops > 16000 {
+			return 16000
+		}
+		return iops
+	case ec2.VolumeTypeGp3:
+		if ebs.Iops == nil {
+			return gp3BaselineIOPS
+		}
+	}
+	return aws.Int64Value(ebs.Iops)
+}
+
+// baselineThroughput returns the throughput of the volume in MiB/s, or 0
+// when not known.
+func baselineThroughput(ebs *ec2.EbsBlockDevice) int64 {
+	switch aws.StringValue(ebs.VolumeType) {
+	case ec2.VolumeTypeGp2:
+		if aws.Int64Value(ebs.VolumeSize) <= gp2LowThroughputSize {
+			return 128
+		}
+		return 250
+	case ec2.VolumeTypeGp3:
+		if ebs.Throughput == nil {
+			return gp3BaselineThroughput
+		}
+	}
+	return aws.Int64Value(ebs.Throughput)
+}
+
+// convertEBSVolume converts the volume type of an EBS volume of a new
+// instance launched for the group in the given zone, using the first
+// conversion matching the volume whose target volume type is available there.
+func (a *autoScalingGroup) convertEBSVolume(ebs *ec2.EbsBlockDevice, az string) {
+	r := a.region.name
+	asg := a.name
+
+	if ebs.VolumeType == nil {
+		log.Println(r, ": Empty EBS VolumeType while converting volume for ASG", asg)
+		return
+	}
+
+	for _, c := range a.ebsVolumeConversions() {
+		// the features are named after the volume types they support
+		if !c.matches(ebs) || !a.region.hasFeature(c.target, az) {
+			continue
+		}
+
+		log.Println(r, ": Converting", c.source, "EBS volume to", c.target,
+			"for new instance launched for", asg)
+		c.apply(ebs)
+		return
+	}
+	log.Println(r, ": No EBS volume conversion could be done for", asg)
+}
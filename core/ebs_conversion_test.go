@@ -0,0 +1,256 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_parseEBSVolumeConversions(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []ebsVolumeConversion
+		wantErr  bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "disabled",
+			value:    "none",
+			expected: []ebsVolumeConversion{},
+		},
+		{
+			name:  "multiple conversions",
+			value: "io1:io2 max_iops=64000, gp2:gp3 min_size=10 max_size=500 iops=match throughput=250,",
+			expected: []ebsVolumeConversion{
+				{source: "io1", target: "io2", maxIOPS: 64000},
+				{source: "gp2", target: "gp3", minSize: 10, maxSize: 500, iops: "match", throughput: "250"},
+			},
+		},
+		{
+			name:    "unknown volume type",
+			value:   "gp2:gp4",
+			wantErr: true,
+		},
+		{
+			name:    "same volume type",
+			value:   "gp2:gp2",
+			wantErr: true,
+		},
+		{
+			name:    "missing target",
+			value:   "gp2",
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			value:   "gp2:gp3 size=10",
+			wantErr: true,
+		},
+		{
+			name:    "invalid size",
+			value:   "gp2:gp3 max_size=-1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid IOPS",
+			value:   "gp2:gp3 iops=fast",
+			wantErr: true,
+		},
+		{
+			name:    "IOPS of a volume type without provisioned IOPS",
+			value:   "gp3:gp2 iops=3000",
+			wantErr: true,
+		},
+		{
+			name:    "throughput of a volume type without provisioned throughput",
+			value:   "io1:io2 throughput=match",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEBSVolumeConversions(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEBSVolumeConversions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseEBSVolumeConversions() = %+v, expected %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_convertEBSVolume(t *testing.T) {
+	tests := []struct {
+		name           string
+		conversions    string
+		regionFeatures string
+		probeErr       error
+		ebs            *ec2.EbsBlockDevice
+		expected       *ec2.EbsBlockDevice
+	}{
+		{
+			name:     "missing volume type",
+			ebs:      &ec2.EbsBlockDevice{VolumeSize: aws.Int64(10)},
+			expected: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(10)},
+		},
+		{
+			name:     "default io1 conversion",
+			ebs:      &ec2.EbsBlockDevice{VolumeType: aws.String("io1"), Iops: aws.Int64(5000)},
+			expected: &ec2.EbsBlockDevice{VolumeType: aws.String("io2"), Iops: aws.Int64(5000)},
+		},
+		{
+			name:     "default gp2 conversion of a volume of unknown size",
+			ebs:      &ec2.EbsBlockDevice{VolumeType: aws.String("gp2")},
+			expected: &ec2.EbsBlockDevice{VolumeType: aws.String("gp3")},
+		},
+		{
+			name:     "default gp2 conversion above the threshold",
+			ebs:      &ec2.EbsBlockDevice{VolumeType: aws.String("gp2"), VolumeSize: aws.Int64(200)},
+			expected: &ec2.EbsBlockDevice{VolumeType: aws.String("gp2"), VolumeSize: aws.Int64(200)},
+		},
+		{
+			name:           "target volume type unavailable in the region",
+			regionFeatures: "us-east-1:io2=false",
+			ebs:            &ec2.EbsBlockDevice{VolumeType: aws.String("io1"), Iops: aws.Int64(5000)},
+			expected:       &ec2.EbsBlockDevice{VolumeType: aws.String("io1"), Iops: aws.Int64(5000)},
+		},
+		{
+			name:        "target volume type unavailable in the zone",
+			conversions: "gp2:gp3",
+			probeErr:    awserr.New("UnsupportedOperation", "not supported", nil),
+			ebs:         &ec2.EbsBlockDevice{VolumeType: aws.String("gp2"), VolumeSize: aws.Int64(10)},
+			expected:    &ec2.EbsBlockDevice{VolumeType: aws.String("gp2"), VolumeSize: aws.Int64(10)},
+		},
+		{
+			name:        "disabled conversions",
+			conversions: "none",
+			ebs:         &ec2.EbsBlockDevice{VolumeType: aws.String("io1"), Iops: aws.Int64(5000)},
+			expected:    &ec2.EbsBlockDevice{VolumeType: aws.String("io1"), Iops: aws.Int64(5000)},
+		},
+		{
+			name:        "first matching conversion",
+			conversions: "gp2:gp3 max_size=100, gp2:st1 min_size=500, gp2:io2 iops=match",
+			ebs:         &ec2.EbsBlockDevice{VolumeType: aws.String("gp2"), VolumeSize: aws.Int64(200)},
+			expected:    &ec2.EbsBlockDevice{VolumeType: aws.String("io2"), VolumeSize: aws.Int64(200), Iops: aws.Int64(600)},
+		},
+		{
+			name:        "IOPS above the limit",
+			conversions: "io1:io2 max_iops=1000",
+			ebs:         &ec2.EbsBlockDevice{VolumeType: aws.String("io1"), Iops: aws.Int64(5000)},
+			expected:    &ec2.EbsBlockDevice{VolumeType: aws.String("io1"), Iops: aws.Int64(5000)},
+		},
+		{
+			name:        "large gp2 volume matching its performance",
+			conversions: "gp2:gp3 iops=match throughput=match",
+			ebs:         &ec2.EbsBlockDevice{VolumeType: aws.String("gp2"), VolumeSize: aws.Int64(2000)},
+			expected: &ec2.EbsBlockDevice{
+				VolumeType: aws.String("gp3"),
+				VolumeSize: aws.Int64(2000),
+				Iops:       aws.Int64(6000),
+				Throughput: aws.Int64(250),
+			},
+		},
+		{
+			name:        "small gp2 volume matching its performance",
+			conversions: "gp2:gp3 iops=match throughput=match",
+			ebs:         &ec2.EbsBlockDevice{VolumeType: aws.String("gp2"), VolumeSize: aws.Int64(100)},
+			expected: &ec2.EbsBlockDevice{
+				VolumeType: aws.String("gp3"),
+				VolumeSize: aws.Int64(100),
+				Throughput: aws.Int64(128),
+			},
+		},
+		{
+			name:        "provisioned performance",
+			conversions: "gp2:gp3 iops=4000 throughput=500",
+			ebs:         &ec2.EbsBlockDevice{VolumeType: aws.String("gp2"), VolumeSize: aws.Int64(100)},
+			expected: &ec2.EbsBlockDevice{
+				VolumeType: aws.String("gp3"),
+				VolumeSize: aws.Int64(100),
+				Iops:       aws.Int64(4000),
+				Throughput: aws.Int64(500),
+			},
+		},
+		{
+			name:        "conversion to a volume type without provisioned performance",
+			conversions: "gp3:gp2",
+			ebs: &ec2.EbsBlockDevice{
+				VolumeType: aws.String("gp3"),
+				VolumeSize: aws.Int64(100),
+				Iops:       aws.Int64(4000),
+				Throughput: aws.Int64(500),
+			},
+			expected: &ec2.EbsBlockDevice{VolumeType: aws.String("gp2"), VolumeSize: aws.Int64(100)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{RegionFeatures: tt.regionFeatures}
+			conf.regionFeatures = newRegionFeatures(conf)
+
+			probeErr := tt.probeErr
+			if probeErr == nil {
+				probeErr = awserr.New("DryRunOperation", "would have succeeded", nil)
+			}
+
+			a := &autoScalingGroup{
+				name: "asg",
+				region: &region{
+					name:     "us-east-1",
+					conf:     conf,
+					services: connections{ec2: mockEC2{cverr: probeErr}},
+				},
+				config: AutoScalingConfig{
+					GP2ConversionThreshold: DefaultGP2ConversionThreshold,
+					EBSVolumeConversions:   tt.conversions,
+				},
+			}
+
+			a.convertEBSVolume(tt.ebs, "us-east-1a")
+			if !reflect.DeepEqual(tt.ebs, tt.expected) {
+				t.Errorf("convertEBSVolume() = %v, expected %v", tt.ebs, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadEBSVolumeConversions(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected string
+	}{
+		{name: "no tag", expected: "io1:io2"},
+		{name: "valid tag", tagValue: aws.String("gp2:gp3 max_size=500"), expected: "gp2:gp3 max_size=500"},
+		{name: "tag disabling the conversions", tagValue: aws.String("none"), expected: "none"},
+		{name: "invalid tag", tagValue: aws.String("gp2:gp4"), expected: "io1:io2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{EBSVolumeConversions: "io1:io2"}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(EBSVolumeConversionsTag), Value: tt.tagValue}}
+			}
+
+			a.loadEBSVolumeConversions()
+
+			if a.config.EBSVolumeConversions != tt.expected {
+				t.Errorf("EBSVolumeConversions = %q, expected %q", a.config.EBSVolumeConversions, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"math"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/eks"
+)
+
+const (
+	// the tags set by EKS on the AutoScaling groups backing managed nodegroups
+	eksClusterNameTag   = "eks:cluster-name"
+	eksNodegroupNameTag = "eks:nodegroup-name"
+
+	// used when the nodegroup has no update configuration, matching the EKS
+	// default behavior of updating a single node at a time
+	defaultEKSMaxUnavailable = 1
+)
+
+// eksNodegroupNames returns the EKS cluster and nodegroup names in case the
+// group is backing an EKS managed nodegroup.
+func (a *autoScalingGroup) eksNodegroupNames() (*string, *string) {
+	cluster, nodegroup := a.getTagValue(eksClusterNameTag), a.getTagValue(eksNodegroupNameTag)
+	if cluster == nil || nodegroup == nil {
+		return nil, nil
+	}
+	return cluster, nodegroup
+}
+
+func (a *autoScalingGroup) loadEKSNodegroup() (*eks.Nodegroup, error) {
+	//already done
+	if a.eksNodegroup != nil {
+		return a.eksNodegroup, nil
+	}
+
+	cluster, nodegroup := a.eksNodegroupNames()
+	if cluster == nil {
+		return nil, nil
+	}
+
+	resp, err := a.region.services.eks.DescribeNodegroup(&eks.DescribeNodegroupInput{
+		ClusterName:   cluster,
+		NodegroupName: nodegroup,
+	})
+
+	if err != nil {
+		log.Println(a.name, "Failed to describe EKS nodegroup", *nodegroup,
+			"of cluster", *cluster, err.Error())
+		return nil, err
+	}
+
+	a.eksNodegroup = resp.Nodegroup
+	return a.eksNodegroup, nil
+}
+
+// eksMaxUnavailable converts the nodegroup update configuration into the
+// number of nodes that can be disrupted at once.
+func (a *autoScalingGroup) eksMaxUnavailable(ng *eks.Nodegroup) int64 {
+	uc := ng.UpdateConfig
+	if uc == nil {
+		return defaultEKSMaxUnavailable
+	}
+
+	if uc.MaxUnavailable != nil {
+		return *uc.MaxUnavailable
+	}
+
+	if uc.MaxUnavailablePercentage != nil {
+		desired := float64(aws.Int64Value(a.DesiredCapacity))
+		maxUnavailable := int64(math.Floor(desired * float64(*uc.MaxUnavailablePercentage) / 100.0))
+		if maxUnavailable < 1 {
+			return 1
+		}
+		return maxUnavailable
+	}
+
+	return defaultEKSMaxUnavailable
+}
+
+// eksDisruptionAllowed checks whether replacing instances from this group
+// respects the disruption budget of the EKS managed nodegroup it belongs to.
+// The instances given as parameters are those about to be replaced together,
+// such as a surge batch, and all of them count against the budget instead of
+// being counted as unavailable. Groups not backing EKS nodegroups are always
+// allowed.
+func (a *autoScalingGroup) eksDisruptionAllowed(replacedInstanceIDs ...*string) (bool, string) {
+	cluster, nodegroup := a.eksNodegroupNames()
+	if cluster == nil {
+		return true, ""
+	}
+
+	ng, err := a.loadEKSNodegroup()
+	if err != nil || ng == nil {
+		// better safe than sorry
+		return false, "eks-nodegroup-unknown"
+	}
+
+	if ng.Status != nil && *ng.Status != eks.NodegroupStatusActive {
+		log.Printf("%s EKS nodegroup %s of cluster %s is in state %s, skipping replacements",
+			a.name, *nodegroup, *cluster, *ng.Status)
+		return false, "eks-nodegroup-not-active"
+	}
+
+	replaced := make(map[string]bool, len(replacedInstanceIDs))
+	for _, id := range replacedInstanceIDs {
+		replaced[aws.StringValue(id)] = true
+	}
+
+	var unavailable int64
+	for _, inst := range a.Instances {
+		if replaced[aws.StringValue(inst.InstanceId)] {
+			continue
+		}
+		if aws.StringValue(inst.LifecycleState) != autoscaling.LifecycleStateInService {
+			unavailable++
+		}
+	}
+
+	maxUnavailable := a.eksMaxUnavailable(ng)
+	if unavailable+int64(len(replacedInstanceIDs)) > maxUnavailable {
+		log.Printf("%s EKS nodegroup %s has %d unavailable nodes, replacing %d more would exceed the maximum of %d, skipping replacements",
+			a.name, *nodegroup, unavailable, len(replacedInstanceIDs), maxUnavailable)
+		return false, "eks-nodegroup-disruption-budget"
+	}
+
+	debug.Println(a.name, "EKS nodegroup", *nodegroup, "has", unavailable,
+		"unavailable nodes out of the allowed", maxUnavailable)
+	return true, ""
+}
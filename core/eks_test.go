@@ -0,0 +1,187 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/eks"
+)
+
+func Test_eksMaxUnavailable(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  int64
+		ng       *eks.Nodegroup
+		expected int64
+	}{
+		{
+			name:     "no update config",
+			desired:  10,
+			ng:       &eks.Nodegroup{},
+			expected: 1,
+		},
+		{
+			name:    "absolute number",
+			desired: 10,
+			ng: &eks.Nodegroup{UpdateConfig: &eks.NodegroupUpdateConfig{
+				MaxUnavailable: aws.Int64(3),
+			}},
+			expected: 3,
+		},
+		{
+			name:    "percentage",
+			desired: 10,
+			ng: &eks.Nodegroup{UpdateConfig: &eks.NodegroupUpdateConfig{
+				MaxUnavailablePercentage: aws.Int64(25),
+			}},
+			expected: 2,
+		},
+		{
+			name:    "percentage of a small group",
+			desired: 2,
+			ng: &eks.Nodegroup{UpdateConfig: &eks.NodegroupUpdateConfig{
+				MaxUnavailablePercentage: aws.Int64(10),
+			}},
+			expected: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{Group: &autoscaling.Group{
+				DesiredCapacity: aws.Int64(tt.desired),
+			}}
+			if got := a.eksMaxUnavailable(tt.ng); got != tt.expected {
+				t.Errorf("eksMaxUnavailable() = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_eksDisruptionAllowed(t *testing.T) {
+	eksTags := []*autoscaling.TagDescription{
+		{Key: aws.String(eksClusterNameTag), Value: aws.String("cluster")},
+		{Key: aws.String(eksNodegroupNameTag), Value: aws.String("nodegroup")},
+	}
+
+	activeNodegroup := &eks.DescribeNodegroupOutput{
+		Nodegroup: &eks.Nodegroup{
+			Status: aws.String(eks.NodegroupStatusActive),
+			UpdateConfig: &eks.NodegroupUpdateConfig{
+				MaxUnavailable: aws.Int64(1),
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		tags       []*autoscaling.TagDescription
+		instances  []*autoscaling.Instance
+		eks        mockEKS
+		replaced   []*string
+		expected   bool
+		wantReason string
+	}{
+		{
+			name:     "not an EKS nodegroup",
+			tags:     []*autoscaling.TagDescription{},
+			expected: true,
+		},
+		{
+			name:       "nodegroup can't be described",
+			tags:       eksTags,
+			eks:        mockEKS{dnerr: errors.New("denied")},
+			expected:   false,
+			wantReason: "eks-nodegroup-unknown",
+		},
+		{
+			name: "nodegroup is updating",
+			tags: eksTags,
+			eks: mockEKS{dno: &eks.DescribeNodegroupOutput{
+				Nodegroup: &eks.Nodegroup{Status: aws.String(eks.NodegroupStatusUpdating)},
+			}},
+			expected:   false,
+			wantReason: "eks-nodegroup-not-active",
+		},
+		{
+			name: "all nodes available",
+			tags: eksTags,
+			instances: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-1"), LifecycleState: aws.String("InService")},
+				{InstanceId: aws.String("i-2"), LifecycleState: aws.String("InService")},
+			},
+			eks:      mockEKS{dno: activeNodegroup},
+			expected: true,
+		},
+		{
+			name: "budget used by another node",
+			tags: eksTags,
+			instances: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-1"), LifecycleState: aws.String("InService")},
+				{InstanceId: aws.String("i-2"), LifecycleState: aws.String("Terminating")},
+			},
+			eks:        mockEKS{dno: activeNodegroup},
+			replaced:   []*string{aws.String("i-1")},
+			expected:   false,
+			wantReason: "eks-nodegroup-disruption-budget",
+		},
+		{
+			name: "replaced node is not counted",
+			tags: eksTags,
+			instances: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-1"), LifecycleState: aws.String("Pending")},
+				{InstanceId: aws.String("i-2"), LifecycleState: aws.String("InService")},
+			},
+			eks:      mockEKS{dno: activeNodegroup},
+			replaced: []*string{aws.String("i-1")},
+			expected: true,
+		},
+		{
+			name: "instance without ID",
+			tags: eksTags,
+			instances: []*autoscaling.Instance{
+				{LifecycleState: aws.String("Pending")},
+				{InstanceId: aws.String("i-2"), LifecycleState: aws.String("InService")},
+			},
+			eks:        mockEKS{dno: activeNodegroup},
+			replaced:   []*string{aws.String("i-2")},
+			expected:   false,
+			wantReason: "eks-nodegroup-disruption-budget",
+		},
+		{
+			name: "batch exceeding the budget",
+			tags: eksTags,
+			instances: []*autoscaling.Instance{
+				{InstanceId: aws.String("i-1"), LifecycleState: aws.String("InService")},
+				{InstanceId: aws.String("i-2"), LifecycleState: aws.String("InService")},
+				{InstanceId: aws.String("i-3"), LifecycleState: aws.String("InService")},
+			},
+			eks:        mockEKS{dno: activeNodegroup},
+			replaced:   []*string{aws.String("i-1"), aws.String("i-2")},
+			expected:   false,
+			wantReason: "eks-nodegroup-disruption-budget",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{
+					Tags:            tt.tags,
+					Instances:       tt.instances,
+					DesiredCapacity: aws.Int64(int64(len(tt.instances))),
+				},
+				region: &region{services: connections{eks: tt.eks}},
+			}
+			got, reason := a.eksDisruptionAllowed(tt.replaced...)
+			if got != tt.expected || reason != tt.wantReason {
+				t.Errorf("eksDisruptionAllowed() = %v, %q expected %v, %q",
+					got, reason, tt.expected, tt.wantReason)
+			}
+		})
+	}
+}
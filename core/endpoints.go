@@ -0,0 +1,65 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// regionPlaceholder can be used in the custom endpoint URLs and is replaced
+// with the name of the region the connection is made to, for example
+// https://ec2-fips.{region}.amazonaws.com
+const regionPlaceholder = "{region}"
+
+// serviceConfig returns the AWS client configuration used for connecting to a
+// service in the given region, overriding the service endpoint in case a
+// custom one was configured either globally using endpoint_url or for that
+// particular service using service_endpoints. The service is identified by the
+// endpoint ID of its SDK package, such as ec2 or autoscaling.
+func (cfg *Config) serviceConfig(service, region string) *aws.Config {
+	c := aws.NewConfig().WithRegion(region)
+
+	if cfg == nil {
+		return c
+	}
+
+	if url := cfg.serviceEndpoint(service, region); url != "" {
+		debug.Println("Using custom endpoint", url, "for", service, "in", region)
+		c = c.WithEndpoint(url)
+	}
+	return c
+}
+
+func (cfg *Config) serviceEndpoint(service, region string) string {
+	url := cfg.EndpointURL
+
+	if u, ok := parseServiceEndpoints(cfg.ServiceEndpoints)[service]; ok {
+		url = u
+	}
+
+	return strings.Replace(url, regionPlaceholder, region, -1)
+}
+
+// parseServiceEndpoints parses a comma or whitespace separated list of
+// service=url pairs into a map keyed by the service name.
+func parseServiceEndpoints(endpoints string) map[string]string {
+	result := make(map[string]string)
+
+	for _, e := range strings.Split(replaceWhitespace(endpoints), ",") {
+		if e == "" {
+			continue
+		}
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.Println("Ignoring invalid service endpoint", e,
+				"expected to be given as service=url")
+			continue
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func Test_parseServiceEndpoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints string
+		expected  map[string]string
+	}{
+		{
+			name:      "empty",
+			endpoints: "",
+			expected:  map[string]string{},
+		},
+		{
+			name:      "comma separated",
+			endpoints: "ec2=https://ec2-fips.{region}.amazonaws.com,sqs=http://localhost:4566",
+			expected: map[string]string{
+				"ec2": "https://ec2-fips.{region}.amazonaws.com",
+				"sqs": "http://localhost:4566",
+			},
+		},
+		{
+			name:      "whitespace separated",
+			endpoints: " ec2=https://ec2.example.com autoscaling=https://as.example.com ",
+			expected: map[string]string{
+				"ec2":         "https://ec2.example.com",
+				"autoscaling": "https://as.example.com",
+			},
+		},
+		{
+			name:      "invalid entries are ignored",
+			endpoints: "ec2,=https://example.com,sqs=,eks=https://eks.example.com",
+			expected: map[string]string{
+				"eks": "https://eks.example.com",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseServiceEndpoints(tt.endpoints); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseServiceEndpoints() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConfig_serviceConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      *Config
+		service  string
+		expected *string
+	}{
+		{
+			name:     "no configuration",
+			cfg:      nil,
+			service:  "ec2",
+			expected: nil,
+		},
+		{
+			name:     "default endpoints",
+			cfg:      &Config{},
+			service:  "ec2",
+			expected: nil,
+		},
+		{
+			name:     "global endpoint",
+			cfg:      &Config{EndpointURL: "http://localhost:4566"},
+			service:  "ec2",
+			expected: aws.String("http://localhost:4566"),
+		},
+		{
+			name: "service endpoint with region placeholder",
+			cfg: &Config{
+				EndpointURL:      "http://localhost:4566",
+				ServiceEndpoints: "ec2=https://ec2-fips.{region}.amazonaws.com",
+			},
+			service:  "ec2",
+			expected: aws.String("https://ec2-fips.us-east-1.amazonaws.com"),
+		},
+		{
+			name: "other services fall back to the global endpoint",
+			cfg: &Config{
+				EndpointURL:      "http://localhost:4566",
+				ServiceEndpoints: "ec2=https://ec2-fips.{region}.amazonaws.com",
+			},
+			service:  "autoscaling",
+			expected: aws.String("http://localhost:4566"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.cfg.serviceConfig(tt.service, "us-east-1")
+
+			if aws.StringValue(got.Region) != "us-east-1" {
+				t.Errorf("serviceConfig() region = %v, expected us-east-1",
+					aws.StringValue(got.Region))
+			}
+
+			if !reflect.DeepEqual(got.Endpoint, tt.expected) {
+				t.Errorf("serviceConfig() endpoint = %v, expected %v",
+					aws.StringValue(got.Endpoint), aws.StringValue(tt.expected))
+			}
+		})
+	}
+}
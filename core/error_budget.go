@@ -0,0 +1,86 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"sync"
+)
+
+// minActionsForErrorRate is the number of actions that need to be attempted
+// before the error rate is taken into account, so that a single failure at
+// the beginning of a run doesn't exhaust the budget.
+const minActionsForErrorRate = 5
+
+// errorBudget keeps track of the failed actions across all the regions
+// processed during a run. Once the configured number or rate of errors is
+// exceeded, the remaining mutating actions are only reported but no longer
+// executed, in order to avoid cascading damage when something is broken
+// account-wide, such as missing IAM permissions or deregistered AMIs.
+type errorBudget struct {
+	sync.Mutex
+
+	maxErrors    int64
+	maxErrorRate float64
+
+	actions   int64
+	errors    int64
+	exhausted bool
+}
+
+func newErrorBudget(maxErrors int64, maxErrorRate float64) *errorBudget {
+	return &errorBudget{
+		maxErrors:    maxErrors,
+		maxErrorRate: maxErrorRate,
+	}
+}
+
+// record accounts for the outcome of an action, given as the error it
+// returned.
+func (e *errorBudget) record(err error) {
+	if e == nil {
+		return
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.actions++
+
+	// expected and transient errors aren't accounted as failures
+	switch handlingOf(err) {
+	case skipError, retryError:
+		return
+	}
+	e.errors++
+
+	if e.exhausted {
+		return
+	}
+
+	errorRate := float64(e.errors) / float64(e.actions) * 100.0
+
+	if e.maxErrors > 0 && e.errors >= e.maxErrors {
+		log.Printf("Error budget exhausted after %d failed actions, the remaining actions "+
+			"will only be reported", e.errors)
+		e.exhausted = true
+	} else if e.maxErrorRate > 0 && e.actions >= minActionsForErrorRate &&
+		errorRate >= e.maxErrorRate {
+		log.Printf("Error budget exhausted, %.1f%% of the %d actions failed, the remaining actions "+
+			"will only be reported", errorRate, e.actions)
+		e.exhausted = true
+	}
+}
+
+// isExhausted tells whether the mutating actions should be skipped for the
+// rest of the run.
+func (e *errorBudget) isExhausted() bool {
+	if e == nil {
+		return false
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	return e.exhausted
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_errorBudget(t *testing.T) {
+	failure := errors.New("UnauthorizedOperation")
+
+	tests := []struct {
+		name         string
+		budget       *errorBudget
+		outcomes     []error
+		expected     bool
+		expectedErrs int64
+	}{
+		{
+			name:     "nil budget is never exhausted",
+			budget:   nil,
+			outcomes: []error{failure, failure, failure},
+			expected: false,
+		},
+		{
+			name:         "unlimited budget",
+			budget:       newErrorBudget(0, 0),
+			outcomes:     []error{failure, failure, failure},
+			expected:     false,
+			expectedErrs: 3,
+		},
+		{
+			name:         "below the maximum number of errors",
+			budget:       newErrorBudget(3, 0),
+			outcomes:     []error{failure, nil, failure},
+			expected:     false,
+			expectedErrs: 2,
+		},
+		{
+			name:         "maximum number of errors reached",
+			budget:       newErrorBudget(3, 0),
+			outcomes:     []error{failure, nil, failure, failure},
+			expected:     true,
+			expectedErrs: 3,
+		},
+		{
+			name:         "error rate ignored for few actions",
+			budget:       newErrorBudget(0, 50),
+			outcomes:     []error{failure, failure},
+			expected:     false,
+			expectedErrs: 2,
+		},
+		{
+			name:         "error rate exceeded",
+			budget:       newErrorBudget(0, 50),
+			outcomes:     []error{nil, failure, nil, failure, failure},
+			expected:     true,
+			expectedErrs: 3,
+		},
+		{
+			name:         "error rate not exceeded",
+			budget:       newErrorBudget(0, 50),
+			outcomes:     []error{nil, failure, nil, nil, failure, nil},
+			expected:     false,
+			expectedErrs: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, err := range tt.outcomes {
+				tt.budget.record(err)
+			}
+
+			if got := tt.budget.isExhausted(); got != tt.expected {
+				t.Errorf("isExhausted() = %v, expected %v", got, tt.expected)
+			}
+
+			if tt.budget != nil && tt.budget.errors != tt.expectedErrs {
+				t.Errorf("errors = %d, expected %d", tt.budget.errors, tt.expectedErrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// The errors returned by AutoSpotting wrap one of these, so they can be
+// checked using errors.Is regardless of the context added to them.
+var (
+	// ErrMissingLaunchConfiguration is returned for groups without a launch
+	// configuration, when one is expected
+	ErrMissingLaunchConfiguration = errors.New("missing launch configuration")
+
+	// ErrMissingLaunchTemplate is returned for groups without a launch
+	// template, or with a launch template that couldn't be described
+	ErrMissingLaunchTemplate = errors.New("missing launch template")
+
+	// ErrInstanceNotFound is returned when an instance involved in a
+	// replacement couldn't be found
+	ErrInstanceNotFound = errors.New("instance not found")
+
+	// ErrInstanceNotRunning is returned for instances not yet, or no longer,
+	// in the running state
+	ErrInstanceNotRunning = errors.New("instance not in running state")
+
+	// ErrProtectedInstance is returned for on-demand instances that shouldn't
+	// be replaced, such as those protected from termination or scale-in
+	ErrProtectedInstance = errors.New("instance should not be replaced")
+
+	// ErrDisruptionBudgetExceeded is returned when replacing an instance would
+	// exceed the disruption budget of its EKS nodegroup
+	ErrDisruptionBudgetExceeded = errors.New("disruption budget exceeded")
+
+	// ErrNotPriceCompatible is returned when no compatible spot instance type
+	// is cheaper than the replaced on-demand instance
+	ErrNotPriceCompatible = errors.New("no cheaper spot instance types could be found")
+	// ErrBidRefused is returned when the bid price was refused because it
+	// was computed from stale pricing data
+	ErrBidRefused = errors.New("bid price refused")
+
+	// ErrNoCapacity is returned when spot instances couldn't be launched for
+	// lack of spot capacity or because their price exceeded the bid
+	ErrNoCapacity = errors.New("insufficient spot capacity")
+
+	// ErrQuotaExceeded is returned when spot instances couldn't be launched
+	// because of the service quotas of the account
+	ErrQuotaExceeded = errors.New("service quota exceeded")
+
+	// ErrPermissionDenied is returned when an action isn't allowed by the
+	// IAM permissions of AutoSpotting
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrInvalidImage is returned when spot instances couldn't be launched
+	// because their AMI is invalid or was deregistered
+	ErrInvalidImage = errors.New("invalid AMI")
+
+	// ErrRegionNotEnabled is returned for events from regions where
+	// AutoSpotting isn't enabled
+	ErrRegionNotEnabled = errors.New("region not enabled")
+
+	// ErrActionDisabled is returned when a replacement needs a mutating action
+	// which was disabled by the configuration
+	ErrActionDisabled = errors.New("action disabled")
+
+	// ErrScalingActivity is returned when the desired capacity of a group was
+	// changed by scaling activities in the middle of a replacement
+	ErrScalingActivity = errors.New("scaling activity in progress")
+
+	// ErrInstanceNotHealthy is returned when a spot instance attached to a
+	// group isn't reported healthy by it in time
+	ErrInstanceNotHealthy = errors.New("instance not healthy")
+
+	// ErrLifecycleHookTimeout is returned when the termination lifecycle
+	// hooks of a replaced instance weren't completed by their handlers in time
+	ErrLifecycleHookTimeout = errors.New("termination lifecycle hook timed out")
+)
+
+// errorHandling is how the error returned by an action is handled.
+type errorHandling int
+
+const (
+	// failError is an unexpected failure, accounted in the error budget
+	failError errorHandling = iota
+
+	// skipError is expected, the action is skipped without being accounted
+	// as failed
+	skipError
+
+	// retryError is transient, the action is retried on a later run without
+	// being accounted as failed
+	retryError
+
+	// alertError needs human attention, such as missing permissions or
+	// exceeded quotas, and is reported in the final recap
+	alertError
+)
+
+// handlingOf determines how an error returned by an action is handled.
+func handlingOf(err error) errorHandling {
+	switch {
+	case err == nil:
+		return skipError
+	case errors.Is(err, ErrNotPriceCompatible), errors.Is(err, ErrProtectedInstance),
+		errors.Is(err, ErrRegionNotEnabled), errors.Is(err, ErrActionDisabled):
+		return skipError
+	case errors.Is(err, ErrNoCapacity), errors.Is(err, ErrInstanceNotRunning),
+		errors.Is(err, ErrDisruptionBudgetExceeded), errors.Is(err, ErrBidRefused),
+		errors.Is(err, ErrScalingActivity), errors.Is(err, ErrInstanceNotHealthy),
+		errors.Is(err, ErrLifecycleHookTimeout):
+		return retryError
+	case errors.Is(err, ErrPermissionDenied), errors.Is(err, ErrQuotaExceeded),
+		errors.Is(err, ErrInvalidImage):
+		return alertError
+	}
+
+	// the AWS errors not wrapped in any of the sentinel errors are handled
+	// according to their error code
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch classifyLaunchError(aerr) {
+		case capacityLaunchFailure, priceLaunchFailure:
+			return retryError
+		case quotaLaunchFailure, permissionLaunchFailure, imageLaunchFailure:
+			return alertError
+		}
+	}
+	return failError
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func Test_handlingOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected errorHandling
+	}{
+		{name: "no error", err: nil, expected: skipError},
+		{name: "not price compatible", err: ErrNotPriceCompatible, expected: skipError},
+		{
+			name:     "wrapped protected instance",
+			err:      fmt.Errorf("target instance i-1: %w", ErrProtectedInstance),
+			expected: skipError,
+		},
+		{
+			name:     "wrapped disabled action",
+			err:      fmt.Errorf("terminate_on_demand for the group asg: %w", ErrActionDisabled),
+			expected: skipError,
+		},
+		{name: "instance not running", err: ErrInstanceNotRunning, expected: retryError},
+		{name: "scaling activity", err: ErrScalingActivity, expected: retryError},
+		{name: "instance not healthy", err: ErrInstanceNotHealthy, expected: retryError},
+		{name: "lifecycle hook timeout", err: ErrLifecycleHookTimeout, expected: retryError},
+		{
+			name:     "launch failure for lack of capacity",
+			err:      &launchError{reason: capacityLaunchFailure, err: errors.New("InsufficientInstanceCapacity")},
+			expected: retryError,
+		},
+		{
+			name:     "launch failure for exceeded quota",
+			err:      &launchError{reason: quotaLaunchFailure, err: errors.New("VcpuLimitExceeded")},
+			expected: alertError,
+		},
+		{
+			name: "wrapped AWS permission error",
+			err: fmt.Errorf("couldn't attach spot instance i-1: %w",
+				awserr.New("AccessDenied", "not authorized", nil)),
+			expected: alertError,
+		},
+		{
+			name:     "AWS capacity error",
+			err:      awserr.New("InsufficientInstanceCapacity", "no capacity", nil),
+			expected: retryError,
+		},
+		{
+			name:     "other AWS error",
+			err:      awserr.New("InternalError", "internal error", nil),
+			expected: failError,
+		},
+		{name: "unknown error", err: errors.New("failed"), expected: failError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := handlingOf(tt.err); got != tt.expected {
+				t.Errorf("handlingOf() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultExecutionTimeReserve is the default time kept aside before the
+	// Lambda deadline for finishing the run and reporting its outcome.
+	DefaultExecutionTimeReserve = 30 * time.Second
+
+	// regionMemoryMB is the approximate memory needed for processing a region
+	// concurrently with the others.
+	regionMemoryMB = 256
+
+	// lowPriorityWorkMinTime is the time that should be left in the execution
+	// budget in order to run low priority work such as chaos testing.
+	lowPriorityWorkMinTime = 2 * time.Minute
+)
+
+// SetExecutionBudget sets the deadline and memory limit of the current
+// execution, such as those of the Lambda function invocation, so that the run
+// is adjusted to finish within them. A zero deadline or memory limit means
+// there is no such limit.
+func (a *AutoSpotting) SetExecutionBudget(deadline time.Time, memoryLimitMB int) {
+	a.config.executionDeadline = deadline
+	a.config.memoryLimitMB = memoryLimitMB
+}
+
+// inheritRunSettings copies the settings only known when the run starts, such
+// as the execution limits, from the configuration the current one is derived
+// from, since the derived configurations are kept between runs.
+func (cfg *Config) inheritRunSettings(parent *Config) {
+	cfg.executionDeadline = parent.executionDeadline
+	cfg.memoryLimitMB = parent.memoryLimitMB
+	cfg.ReadOnly = parent.ReadOnly
+	cfg.leadership = parent.leadership
+}
+
+// executionBudget tracks the remaining execution time of the current run, and
+// the work skipped in order to finish within it.
+type executionBudget struct {
+	sync.Mutex
+	deadline      time.Time
+	memoryLimitMB int
+	clock         Clock
+	skipped       []string
+}
+
+func newExecutionBudget(cfg *Config) *executionBudget {
+	b := &executionBudget{
+		memoryLimitMB: cfg.memoryLimitMB,
+		clock:         cfg.getClock(),
+	}
+	if !cfg.executionDeadline.IsZero() {
+		b.deadline = cfg.executionDeadline.Add(-cfg.ExecutionTimeReserve)
+	}
+	return b
+}
+
+// remaining returns the execution time left, or a negative duration when
+// there is no deadline.
+func (b *executionBudget) remaining() time.Duration {
+	if b == nil || b.deadline.IsZero() {
+		return -1
+	}
+	if left := b.deadline.Sub(b.clock.Now()); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// allows tells whether there is enough time left for the given work,
+// otherwise the work is recorded as skipped. Low priority work needs more
+// time left, so that it doesn't delay the rest of the run.
+func (b *executionBudget) allows(work string, lowPriority bool) bool {
+	left := b.remaining()
+	if left < 0 || (left > 0 && !lowPriority) || left >= lowPriorityWorkMinTime {
+		return true
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.skipped = append(b.skipped, work)
+	return false
+}
+
+// regionConcurrency returns how many of the given regions can be processed
+// concurrently within the memory limit.
+func (b *executionBudget) regionConcurrency(regions int) int {
+	if b == nil || b.memoryLimitMB <= 0 {
+		return regions
+	}
+
+	concurrency := b.memoryLimitMB / regionMemoryMB
+	if concurrency < 1 {
+		return 1
+	}
+	if concurrency > regions {
+		return regions
+	}
+	return concurrency
+}
+
+// String lists the work skipped because of the execution budget.
+func (b *executionBudget) String() string {
+	if b == nil {
+		return ""
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	return strings.Join(b.skipped, ", ")
+}
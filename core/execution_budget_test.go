@@ -0,0 +1,153 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_executionBudget_allows(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		deadline        time.Time
+		reserve         time.Duration
+		lowPriority     bool
+		expected        bool
+		expectedSkipped string
+	}{
+		{
+			name:        "no deadline",
+			lowPriority: true,
+			expected:    true,
+		},
+		{
+			name:     "time left",
+			deadline: now.Add(time.Minute),
+			expected: true,
+		},
+		{
+			name:            "time left within the reserve",
+			deadline:        now.Add(time.Minute),
+			reserve:         time.Minute,
+			expected:        false,
+			expectedSkipped: "work",
+		},
+		{
+			name:        "plenty of time left for low priority work",
+			deadline:    now.Add(5 * time.Minute),
+			lowPriority: true,
+			expected:    true,
+		},
+		{
+			name:            "not enough time left for low priority work",
+			deadline:        now.Add(time.Minute),
+			lowPriority:     true,
+			expected:        false,
+			expectedSkipped: "work",
+		},
+		{
+			name:            "deadline passed",
+			deadline:        now.Add(-time.Minute),
+			expected:        false,
+			expectedSkipped: "work",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newExecutionBudget(&Config{
+				ExecutionTimeReserve: tt.reserve,
+				executionDeadline:    tt.deadline,
+				clock:                &mockClock{now: now},
+			})
+
+			if got := b.allows("work", tt.lowPriority); got != tt.expected {
+				t.Errorf("allows() = %v, expected %v", got, tt.expected)
+			}
+			if got := b.String(); got != tt.expectedSkipped {
+				t.Errorf("String() = %q, expected %q", got, tt.expectedSkipped)
+			}
+		})
+	}
+}
+
+func Test_executionBudget_nil(t *testing.T) {
+	var b *executionBudget
+
+	if !b.allows("work", true) {
+		t.Errorf("allows() = false, expected true without a budget")
+	}
+	if got := b.regionConcurrency(3); got != 3 {
+		t.Errorf("regionConcurrency() = %d, expected 3", got)
+	}
+	if got := b.String(); got != "" {
+		t.Errorf("String() = %q, expected empty", got)
+	}
+}
+
+func Test_executionBudget_regionConcurrency(t *testing.T) {
+	tests := []struct {
+		name          string
+		memoryLimitMB int
+		regions       int
+		expected      int
+	}{
+		{
+			name:     "no memory limit",
+			regions:  16,
+			expected: 16,
+		},
+		{
+			name:          "small memory limit",
+			memoryLimitMB: 128,
+			regions:       16,
+			expected:      1,
+		},
+		{
+			name:          "limited by memory",
+			memoryLimitMB: 1024,
+			regions:       16,
+			expected:      4,
+		},
+		{
+			name:          "limited by regions",
+			memoryLimitMB: 10240,
+			regions:       16,
+			expected:      16,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newExecutionBudget(&Config{memoryLimitMB: tt.memoryLimitMB})
+
+			if got := b.regionConcurrency(tt.regions); got != tt.expected {
+				t.Errorf("regionConcurrency() = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestConfig_inheritRunSettings(t *testing.T) {
+	a := &AutoSpotting{config: &Config{Profiles: `[{"name":"payments"}]`}}
+	if err := a.loadProfiles(); err != nil {
+		t.Fatalf("loadProfiles() error = %v", err)
+	}
+
+	// the profiles are loaded before the Lambda handler sets the limits
+	deadline := time.Date(2021, 1, 1, 0, 5, 0, 0, time.UTC)
+	a.SetExecutionBudget(deadline, 512)
+
+	conf := a.profiles[0].config
+	conf.inheritRunSettings(a.config)
+
+	if !conf.executionDeadline.Equal(deadline) || conf.memoryLimitMB != 512 {
+		t.Errorf("inheritRunSettings() = %v, %d, expected %v, 512",
+			conf.executionDeadline, conf.memoryLimitMB, deadline)
+	}
+	if got := newExecutionBudget(conf).regionConcurrency(16); got != 2 {
+		t.Errorf("regionConcurrency() = %d, expected the memory limit to apply", got)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Explain evaluates a single AutoScaling group, or the group of a single
+// instance, and writes the decisions taken for its on-demand instances as a
+// tree, without making any changes.
+func (a *AutoSpotting) Explain(w io.Writer) error {
+	a.config.FinalRecap = make(map[string][]string)
+	a.config.addDefaultFilteringMode()
+	a.config.addDefaultFilter()
+
+	allRegions, err := a.getRegions()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range allRegions {
+		r := &region{name: name, conf: a.config}
+		if !r.enabled() {
+			continue
+		}
+
+		if asg := r.findExplainedGroup(); asg != nil {
+			asg.explain(w, a.config.ExplainInstance)
+			return nil
+		}
+	}
+
+	if a.config.ExplainInstance != "" {
+		return fmt.Errorf("instance %s wasn't found in any AutoScaling group of the enabled regions", a.config.ExplainInstance)
+	}
+	return fmt.Errorf("AutoScaling group %s wasn't found in the enabled regions", a.config.ExplainASG)
+}
+
+// findExplainedGroup looks up the explained group in the region, loading the
+// instance data needed for evaluating it.
+func (r *region) findExplainedGroup() *autoScalingGroup {
+	r.services.connect(r.name, r.conf)
+	r.setupAsgFilters()
+
+	name := r.conf.ExplainASG
+	if id := r.conf.ExplainInstance; id != "" {
+		out, err := r.services.autoScaling.DescribeAutoScalingInstances(
+			&autoscaling.DescribeAutoScalingInstancesInput{
+				InstanceIds: []*string{aws.String(id)},
+			})
+		if err != nil {
+			log.Println("Failed to describe instance", id, "in", r.name, err.Error())
+			return nil
+		}
+		if len(out.AutoScalingInstances) == 0 {
+			return nil
+		}
+		name = aws.StringValue(out.AutoScalingInstances[0].AutoScalingGroupName)
+	}
+
+	out, err := r.services.autoScaling.DescribeAutoScalingGroups(
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []*string{aws.String(name)},
+		})
+	if err != nil {
+		log.Println("Failed to describe AutoScaling group", name, "in", r.name, err.Error())
+		return nil
+	}
+	if len(out.AutoScalingGroups) == 0 {
+		return nil
+	}
+
+	asg := &autoScalingGroup{
+		Group:  out.AutoScalingGroups[0],
+		name:   name,
+		region: r,
+	}
+	r.addEnabledASGs(asg)
+
+	r.determineInstanceTypeInformation(r.conf)
+	if err := r.scanInstances(); err != nil {
+		log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+	}
+	return asg
+}
+
+// explain writes the evaluation of the group, and of all its instances or
+// only of the given one.
+func (a *autoScalingGroup) explain(w io.Writer, instanceID string) {
+	optInFilterMode := a.region.conf.TagFilteringMode != "opt-out"
+	enabled := optInFilterMode == isASGWithMatchingTags(a.Group, a.region.tagsToFilterASGsBy)
+
+	fmt.Fprintf(w, "Group %s in %s\n", a.name, a.region.name)
+	fmt.Fprintf(w, "  enabled for AutoSpotting: %t\n", enabled)
+
+	if a.MixedInstancesPolicy != nil {
+		if !isOnDemandMixedInstancesPolicy(a.MixedInstancesPolicy) {
+			fmt.Fprintln(w, "  decision: not replaced, the group uses a mixed instances policy with spot instances")
+			return
+		}
+		useMixedInstancesPolicyLaunchTemplate(a.Group)
+	}
+
+	a.config = a.region.groupDefaultConfig()
+	a.scanInstances()
+	a.loadDefaultConfig()
+	a.loadConfigFromTags()
+	a.loadLaunchConfiguration()
+	a.loadLaunchTemplate()
+	a.loadScalingPolicies()
+
+	onDemandRunning, totalRunning := a.alreadyRunningInstanceCount(false, nil)
+	fmt.Fprintf(w, "  running instances: %d, on-demand: %d, kept on-demand: %d\n",
+		totalRunning, onDemandRunning, a.minOnDemand)
+
+	if need, _ := a.needReplaceOnDemandInstances(); !need {
+		fmt.Fprintln(w, "  decision: not replacing more on-demand instances, the group keeps the configured minimum")
+	}
+
+	instances := a.instances.instances()
+	sort.Slice(instances, func(i, j int) bool {
+		return aws.StringValue(instances[i].InstanceId) < aws.StringValue(instances[j].InstanceId)
+	})
+
+	for _, i := range instances {
+		if instanceID != "" && aws.StringValue(i.InstanceId) != instanceID {
+			continue
+		}
+		i.explain(w)
+	}
+}
+
+// explain writes the evaluation of the instance: the checks deciding if it's
+// replaced, its price, the outcome of the compatibility checks of each spot
+// candidate instance type and the chosen candidates.
+func (i *instance) explain(w io.Writer) {
+	a := i.asg
+
+	fmt.Fprintf(w, "  instance %s (%s in %s)\n",
+		aws.StringValue(i.InstanceId), aws.StringValue(i.InstanceType), i.availabilityZone())
+
+	switch {
+	case i.isSpot():
+		fmt.Fprintln(w, "    decision: already a spot instance")
+		return
+	case i.stateName() != ec2.InstanceStateNameRunning:
+		fmt.Fprintf(w, "    decision: not replaced, the instance is %s\n", i.stateName())
+		return
+	case a.isBlockedByScaleInProtection(i):
+		fmt.Fprintln(w, "    decision: not replaced, protected from scale-in")
+		return
+	case a.isBlockedByTerminationProtection(i):
+		fmt.Fprintln(w, "    decision: not replaced, protected from termination")
+		return
+	case i.isOnDedicatedHost():
+		fmt.Fprintln(w, "    decision: not replaced, running on a Dedicated Host")
+		return
+	case !i.isTenancyReplaceable():
+		fmt.Fprintf(w, "    decision: not replaced, running with %s tenancy\n", i.tenancy())
+		return
+	case a.isBlockedByKubernetesAutoscaler():
+		fmt.Fprintf(w, "    decision: not replaced, the group is managed by %s (%s policy)\n",
+			a.kubernetesAutoscaler(), a.kubernetesAutoscalerPolicy())
+		return
+	}
+
+	i.price = i.onDemandPriceOf(i.typeInfo) / i.region.onDemandPriceMultiplier() * a.config.OnDemandPriceMultiplier
+	fmt.Fprintf(w, "    on-demand price: %.4f\n", i.price)
+
+	allowedList, disallowedList := a.getAllowedInstanceTypes(i), a.getDisallowedInstanceTypes(i)
+	attachedVolumes := i.attachedInstanceStoreVolumes()
+
+	candidates := make([]instanceTypeInformation, 0, len(i.region.instanceTypeInformation))
+	for _, candidate := range i.region.instanceTypeInformation {
+		candidates = append(candidates, candidate)
+	}
+	sort.Slice(candidates, func(m, n int) bool {
+		pm, pn := i.calculatePrice(candidates[m]), i.calculatePrice(candidates[n])
+		if pm != pn {
+			return pm < pn
+		}
+		return candidates[m].instanceType < candidates[n].instanceType
+	})
+
+	fmt.Fprintln(w, "    candidates:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, candidate := range candidates {
+		outcome := "compatible"
+		if rejection := i.candidateRejection(candidate, allowedList, disallowedList, attachedVolumes); rejection != "" {
+			outcome = "rejected: " + rejection
+		}
+		fmt.Fprintf(tw, "      %s\t%.4f\t%s\n", candidate.instanceType, i.calculatePrice(candidate), outcome)
+	}
+	tw.Flush()
+
+	instanceTypes, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allowedList, disallowedList)
+	if err != nil {
+		fmt.Fprintf(w, "    decision: not replaced, %s\n", i.compatibilitySkipReason(err, allowedList, disallowedList))
+		return
+	}
+	instanceTypes = a.diversifyInstanceTypes(instanceTypes)
+
+	chosen := instanceTypes[0]
+	bidPrice := i.getPriceToBid(i.price, i.spotPriceOf(chosen), i.premiumOf(chosen))
+	fmt.Fprintf(w, "    decision: launch a %s spot instance bidding %.4f, falling back to %d other candidates\n",
+		chosen.instanceType, bidPrice, len(instanceTypes)-1)
+}
+
+// candidateRejection returns the reason for which the candidate instance type
+// can't replace the instance, or an empty string if it's compatible.
+func (i *instance) candidateRejection(candidate instanceTypeInformation,
+	allowedList, disallowedList []string, attachedVolumes int) string {
+
+	switch {
+	case !i.isAllowed(candidate.instanceType, allowedList, disallowedList):
+		return "not allowed"
+	case !i.isPriceCompatible(i.calculatePrice(candidate)):
+		return "not cheaper, or not available as spot in the zone"
+	case !i.isOfferedInZone(candidate):
+		return "not offered in the zone"
+	}
+
+	if check := i.failedTypeCompatibilityCheck(candidate, attachedVolumes); check != "" {
+		return "incompatible " + check
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_explain(t *testing.T) {
+	typeInfo := map[string]instanceTypeInformation{
+		"m5.large": {
+			instanceType:      "m5.large",
+			PhysicalProcessor: "Intel Xeon",
+			vCPU:              2,
+			memory:            8,
+			pricing: prices{
+				onDemand: 0.1,
+				spot:     spotPriceMap{"us-east-1a": 0.04},
+			},
+		},
+		"m5a.large": {
+			instanceType:      "m5a.large",
+			PhysicalProcessor: "Intel Xeon",
+			vCPU:              2,
+			memory:            8,
+			pricing: prices{
+				onDemand: 0.09,
+				spot:     spotPriceMap{"us-east-1a": 0.03},
+			},
+		},
+		"m5.xlarge": {
+			instanceType:      "m5.xlarge",
+			PhysicalProcessor: "Intel Xeon",
+			vCPU:              4,
+			memory:            16,
+			pricing: prices{
+				onDemand: 0.2,
+				spot:     spotPriceMap{"us-east-1a": 0.2},
+			},
+		},
+		"t3.nano": {
+			instanceType:      "t3.nano",
+			PhysicalProcessor: "Intel Xeon",
+			vCPU:              2,
+			memory:            0.5,
+			pricing: prices{
+				onDemand: 0.005,
+				spot:     spotPriceMap{"us-east-1a": 0.002},
+			},
+		},
+	}
+
+	newInstance := func(id, lifecycle string) *instance {
+		return &instance{
+			Instance: &ec2.Instance{
+				InstanceId:         aws.String(id),
+				InstanceType:       aws.String("m5.large"),
+				InstanceLifecycle:  aws.String(lifecycle),
+				VirtualizationType: aws.String("hvm"),
+				Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			},
+			typeInfo: typeInfo["m5.large"],
+		}
+	}
+
+	tests := []struct {
+		name        string
+		group       *autoscaling.Group
+		instanceID  string
+		expected    []string
+		notExpected []string
+	}{
+		{
+			name: "whole group",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-od")},
+					{InstanceId: aws.String("i-protected"), ProtectedFromScaleIn: aws.Bool(true)},
+					{InstanceId: aws.String("i-spot")},
+				},
+				Tags: []*autoscaling.TagDescription{
+					{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+				},
+			},
+			expected: []string{
+				"Group asg in us-east-1",
+				"enabled for AutoSpotting: true",
+				"running instances: 3, on-demand: 2, kept on-demand: 0",
+				"instance i-od (m5.large in us-east-1a)",
+				"on-demand price: 0.1000",
+				"m5a.large  0.0300  compatible",
+				"m5.xlarge  0.2000  rejected: not cheaper",
+				"t3.nano    0.0020  rejected: incompatible instance requirements",
+				"decision: launch a m5a.large spot instance bidding 0.1000, falling back to 1 other candidates",
+				"instance i-protected (m5.large in us-east-1a)",
+				"decision: not replaced, protected from scale-in",
+				"decision: already a spot instance",
+			},
+		},
+		{
+			name: "single instance",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-od")},
+					{InstanceId: aws.String("i-spot")},
+				},
+			},
+			instanceID: "i-od",
+			expected: []string{
+				"enabled for AutoSpotting: false",
+				"instance i-od",
+			},
+			notExpected: []string{"i-spot"},
+		},
+		{
+			name: "mixed instances policy",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{},
+			},
+			expected:    []string{"decision: not replaced, the group uses a mixed instances policy"},
+			notExpected: []string{"instance i-od"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{
+					TagFilteringMode: "opt-in",
+					AutoScalingConfig: AutoScalingConfig{
+						OnDemandPriceMultiplier: 1,
+					},
+				},
+				instanceTypeInformation: typeInfo,
+				instances: makeInstancesWithCatalog(instanceMap{
+					"i-od":        newInstance("i-od", ""),
+					"i-protected": newInstance("i-protected", ""),
+					"i-spot":      newInstance("i-spot", Spot),
+				}),
+				tagsToFilterASGsBy: []Tag{{Key: "spot-enabled", Value: "true"}},
+				services: connections{
+					ec2:         mockEC2{diao: &ec2.DescribeInstanceAttributeOutput{}},
+					autoScaling: mockASG{},
+				},
+			}
+			a := &autoScalingGroup{
+				Group:  tt.group,
+				name:   aws.StringValue(tt.group.AutoScalingGroupName),
+				region: r,
+			}
+
+			var buf bytes.Buffer
+			a.explain(&buf, tt.instanceID)
+
+			output := buf.String()
+			for _, expected := range tt.expected {
+				if !strings.Contains(output, expected) {
+					t.Errorf("explanation doesn't contain %q:\n%s", expected, output)
+				}
+			}
+			for _, notExpected := range tt.notExpected {
+				if strings.Contains(output, notExpected) {
+					t.Errorf("explanation unexpectedly contains %q:\n%s", notExpected, output)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+const (
+	// heartbeatNamespace and heartbeatMetricName identify the CloudWatch
+	// metric emitted at the end of each completed run
+	heartbeatNamespace  = "AutoSpotting"
+	heartbeatMetricName = "RunCompleted"
+
+	// heartbeatAlarmName is the name of the CloudWatch alarm triggered when no
+	// run completed for longer than the configured staleness period
+	heartbeatAlarmName = "AutoSpotting-heartbeat"
+
+	// heartbeatAlarmPeriod is the period over which the heartbeat metric is
+	// evaluated by the alarm
+	heartbeatAlarmPeriod = 5 * time.Minute
+)
+
+func connectCloudWatch(conf *Config) cloudwatchiface.CloudWatchAPI {
+	sess, err := newSession(conf.MainRegion, conf)
+	if err != nil {
+		panic(err)
+	}
+
+	return cloudwatch.New(sess, conf.serviceConfig(cloudwatch.EndpointsID, conf.MainRegion))
+}
+
+// emitHeartbeat records the completion of a run in CloudWatch, so that silent
+// failures of the scheduler or of the runs can be detected using an alarm on
+// the staleness of the heartbeat metric.
+func (a *AutoSpotting) emitHeartbeat() {
+	if a.cloudWatchConn == nil {
+		return
+	}
+
+	_, err := a.cloudWatchConn.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(heartbeatNamespace),
+		MetricData: []*cloudwatch.MetricDatum{
+			{
+				MetricName: aws.String(heartbeatMetricName),
+				Timestamp:  aws.Time(a.config.getClock().Now()),
+				Unit:       aws.String(cloudwatch.StandardUnitCount),
+				Value:      aws.Float64(1),
+			},
+		},
+	})
+	if err != nil {
+		log.Println("Failed to emit the heartbeat metric:", err.Error())
+		return
+	}
+
+	if a.config.HeartbeatAlarmStaleness > 0 {
+		if err := a.putHeartbeatAlarm(); err != nil {
+			log.Println("Failed to maintain the heartbeat alarm:", err.Error())
+		}
+	}
+}
+
+// putHeartbeatAlarm creates or updates the alarm triggered when no run
+// completed during the configured staleness period.
+func (a *AutoSpotting) putHeartbeatAlarm() error {
+	_, err := a.cloudWatchConn.PutMetricAlarm(heartbeatAlarmInput(
+		a.config.HeartbeatAlarmStaleness, a.config.HeartbeatAlarmTopic))
+	return err
+}
+
+func heartbeatAlarmInput(staleness time.Duration, topic string) *cloudwatch.PutMetricAlarmInput {
+	periods := int64(math.Ceil(staleness.Seconds() / heartbeatAlarmPeriod.Seconds()))
+	if periods < 1 {
+		periods = 1
+	}
+
+	input := &cloudwatch.PutMetricAlarmInput{
+		AlarmName:          aws.String(heartbeatAlarmName),
+		AlarmDescription:   aws.String("No AutoSpotting run completed for " + staleness.String()),
+		Namespace:          aws.String(heartbeatNamespace),
+		MetricName:         aws.String(heartbeatMetricName),
+		Statistic:          aws.String(cloudwatch.StatisticSum),
+		Period:             aws.Int64(int64(heartbeatAlarmPeriod.Seconds())),
+		EvaluationPeriods:  aws.Int64(periods),
+		Threshold:          aws.Float64(1),
+		ComparisonOperator: aws.String(cloudwatch.ComparisonOperatorLessThanThreshold),
+		TreatMissingData:   aws.String("breaching"),
+	}
+
+	if topic != "" {
+		input.AlarmActions = []*string{aws.String(topic)}
+		input.OKActions = []*string{aws.String(topic)}
+	}
+	return input
+}
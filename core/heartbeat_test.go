@@ -0,0 +1,99 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestAutoSpotting_emitHeartbeat(t *testing.T) {
+	tests := []struct {
+		name      string
+		staleness time.Duration
+		pmderr    error
+		expected  []string
+	}{
+		{
+			name:     "metric only",
+			expected: []string{"PutMetricData"},
+		},
+		{
+			name:      "metric and alarm",
+			staleness: time.Hour,
+			expected:  []string{"PutMetricData", "PutMetricAlarm"},
+		},
+		{
+			name:      "alarm not maintained when the metric fails",
+			staleness: time.Hour,
+			pmderr:    errors.New("AccessDenied"),
+			expected:  []string{"PutMetricData"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			a := &AutoSpotting{
+				config:         &Config{HeartbeatAlarmStaleness: tt.staleness},
+				cloudWatchConn: mockCloudWatch{pmderr: tt.pmderr, calls: &calls},
+			}
+
+			a.emitHeartbeat()
+
+			if !reflect.DeepEqual(calls, tt.expected) {
+				t.Errorf("emitHeartbeat() calls = %v, expected %v", calls, tt.expected)
+			}
+		})
+	}
+
+	// without a CloudWatch connection the heartbeat is disabled
+	(&AutoSpotting{config: &Config{}}).emitHeartbeat()
+}
+
+func Test_heartbeatAlarmInput(t *testing.T) {
+	tests := []struct {
+		name            string
+		staleness       time.Duration
+		topic           string
+		expectedPeriods int64
+		expectedActions []*string
+	}{
+		{
+			name:            "short staleness",
+			staleness:       time.Minute,
+			expectedPeriods: 1,
+		},
+		{
+			name:            "rounded up",
+			staleness:       32 * time.Minute,
+			expectedPeriods: 7,
+		},
+		{
+			name:            "with topic",
+			staleness:       time.Hour,
+			topic:           "arn:aws:sns:us-east-1:123456789012:alerts",
+			expectedPeriods: 12,
+			expectedActions: []*string{aws.String("arn:aws:sns:us-east-1:123456789012:alerts")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := heartbeatAlarmInput(tt.staleness, tt.topic)
+
+			if got := aws.Int64Value(input.EvaluationPeriods); got != tt.expectedPeriods {
+				t.Errorf("EvaluationPeriods = %d, expected %d", got, tt.expectedPeriods)
+			}
+			if !reflect.DeepEqual(input.AlarmActions, tt.expectedActions) {
+				t.Errorf("AlarmActions = %v, expected %v", input.AlarmActions, tt.expectedActions)
+			}
+			if aws.StringValue(input.TreatMissingData) != "breaching" {
+				t.Errorf("TreatMissingData = %s, expected breaching", aws.StringValue(input.TreatMissingData))
+			}
+		})
+	}
+}
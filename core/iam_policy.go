@@ -0,0 +1,294 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// sqsQueueStatement is the ID of the policy statement of the queue of the
+// instance replacements, whose resource is replaced when the queue is created
+// by the deployed template.
+const sqsQueueStatement = "SQSQueue"
+
+// runInstancesResourceTypes are the existing resources used by RunInstances,
+// which are allowed regardless of the launched instances being spot instances.
+// The resources created at launch are listed in launchTaggedResourceTypes.
+var runInstancesResourceTypes = []string{
+	"capacity-reservation",
+	"elastic-gpu",
+	"elastic-inference",
+	"key-pair",
+	"launch-template",
+	"placement-group",
+	"security-group",
+	"subnet",
+}
+
+// regionPartition returns the partition of the region, defaulting to the
+// commercial one.
+func regionPartition(region string) string {
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return p.ID()
+	}
+	return endpoints.AwsPartitionID
+}
+
+// policyBuilder assigns the actions allowed by the policy to statements
+// restricted to the resources they're used with.
+type policyBuilder struct {
+	conf      *Config
+	partition string
+
+	statements []iamStatement
+
+	// the actions used with any resource
+	unrestricted []string
+}
+
+// leastPrivilegePolicy returns the minimal IAM policy needed with the given
+// configuration. Where known, the actions are restricted to the resources
+// they're used with, and RunInstances can only launch spot instances tagged as
+// launched by AutoSpotting. The resources of the statements can be replaced,
+// such as with references to the resources created by a template.
+func leastPrivilegePolicy(conf *Config, resources map[string]interface{}) iamPolicyDocument {
+	b := policyBuilder{conf: conf, partition: regionPartition(conf.MainRegion)}
+
+	handlers := map[string]func(action string){
+		"ec2:RunInstances":   b.runInstances,
+		"events:PutEvents":   b.putEvents,
+		"iam:PassRole":       b.passRole,
+		"s3:GetObject":       b.s3Objects,
+		"s3:PutObject":       b.s3Objects,
+		"sns:Publish":        b.publish,
+		"sqs:DeleteMessage":  b.sqsQueue,
+		"sqs:ReceiveMessage": b.sqsQueue,
+		"sqs:SendMessage":    b.sqsQueue,
+		"sts:AssumeRole":     b.assumeRole,
+	}
+
+	for _, action := range requiredActionsFor(conf) {
+		handler, found := handlers[action]
+		if !found {
+			handler = b.unrestrictedAction
+		}
+		handler(action)
+	}
+
+	statements := []iamStatement{{
+		Sid:      "AutoSpotting",
+		Effect:   "Allow",
+		Action:   b.unrestricted,
+		Resource: "*",
+	}}
+	statements = append(statements, b.statements...)
+
+	if conf.PermissionPreflight {
+		statements = append(statements, iamStatement{
+			Sid:      "PermissionPreflight",
+			Effect:   "Allow",
+			Action:   []string{"iam:GetRole", "iam:SimulatePrincipalPolicy", "sts:GetCallerIdentity"},
+			Resource: "*",
+		})
+	}
+
+	for i, s := range statements {
+		if resource, found := resources[s.Sid]; found {
+			statements[i].Resource = resource
+		}
+	}
+
+	return iamPolicyDocument{Version: "2012-10-17", Statement: statements}
+}
+
+func (b *policyBuilder) unrestrictedAction(action string) {
+	b.unrestricted = append(b.unrestricted, action)
+}
+
+// addStatement adds the action to the statement with the given ID, creating
+// it when missing.
+func (b *policyBuilder) addStatement(sid, action string, resource interface{}, condition map[string]map[string]string) {
+	for i, s := range b.statements {
+		if s.Sid != sid {
+			continue
+		}
+		for _, a := range s.Action {
+			if a == action {
+				return
+			}
+		}
+		b.statements[i].Action = append(s.Action, action)
+		return
+	}
+	b.statements = append(b.statements, iamStatement{
+		Sid:       sid,
+		Effect:    "Allow",
+		Action:    []string{action},
+		Resource:  resource,
+		Condition: condition,
+	})
+}
+
+// arn returns the ARN of a resource in the partition of the main region.
+func (b *policyBuilder) arn(service, region, account, resource string) string {
+	return fmt.Sprintf("arn:%s:%s:%s:%s:%s", b.partition, service, region, account, resource)
+}
+
+// runInstances only allows launching spot instances tagged at launch as
+// launched by AutoSpotting, creating the volumes, network interfaces and spot
+// requests with the same tag, and using any of the other resources needed by
+// RunInstances.
+func (b *policyBuilder) runInstances(action string) {
+	launchedByTag := "aws:RequestTag/" + b.conf.tagKey(launchedByAutoSpottingTag)
+
+	b.addStatement("LaunchSpotInstances", action, b.arn("ec2", "*", "*", "instance/*"),
+		map[string]map[string]string{"StringEquals": {
+			"ec2:InstanceMarketType": "spot",
+			launchedByTag:            "true",
+		}})
+
+	var tagged []string
+	for _, resourceType := range launchTaggedResourceTypes {
+		tagged = append(tagged, b.arn("ec2", "*", "*", resourceType+"/*"))
+	}
+	b.addStatement("LaunchSpotInstancesTaggedResources", action, tagged,
+		map[string]map[string]string{"StringEquals": {launchedByTag: "true"}})
+
+	resources := []string{
+		b.arn("ec2", "*", "", "image/*"),
+		b.arn("ec2", "*", "", "snapshot/*"),
+	}
+	for _, resourceType := range runInstancesResourceTypes {
+		resources = append(resources, b.arn("ec2", "*", "*", resourceType+"/*"))
+	}
+	b.addStatement("LaunchSpotInstancesResources", action, resources, nil)
+}
+
+// passRole only allows passing the instance profile roles of the groups to
+// the launched instances.
+func (b *policyBuilder) passRole(action string) {
+	b.addStatement("PassInstanceRoles", action, "*",
+		map[string]map[string]string{"StringEquals": {"iam:PassedToService": "ec2.amazonaws.com"}})
+}
+
+// assumeRole only allows assuming the role of the member accounts of the
+// organization.
+func (b *policyBuilder) assumeRole(action string) {
+	b.addStatement("AssumeOrganizationRole", action,
+		b.arn("iam", "", "*", "role/"+b.conf.OrganizationRole), nil)
+}
+
+// publish only allows publishing to the spot coverage topics, including the
+// ones of the profiles.
+func (b *policyBuilder) publish(action string) {
+	topics := []string{b.conf.SpotCoverageTopic}
+	profiles, _ := parseProfiles(b.conf.Profiles)
+	for _, p := range profiles {
+		topics = append(topics, p.SpotCoverageTopic)
+	}
+	b.addStatement("PublishSpotCoverage", action, uniqueResources(topics), nil)
+}
+
+// putEvents only allows putting the termination events on the configured
+// event buses, given by name or ARN, including the ones of the profiles.
+func (b *policyBuilder) putEvents(action string) {
+	buses := []string{b.conf.TerminationEventBus}
+	profiles, _ := parseProfiles(b.conf.Profiles)
+	for _, p := range profiles {
+		buses = append(buses, p.TerminationEventBus)
+	}
+
+	var resources []string
+	for _, bus := range buses {
+		if bus != "" && !strings.HasPrefix(bus, "arn:") {
+			bus = b.arn("events", "*", "*", "event-bus/"+bus)
+		}
+		resources = append(resources, bus)
+	}
+	b.addStatement("PutTerminationEvents", action, uniqueResources(resources), nil)
+}
+
+// sqsQueue only allows using the queue of the instance replacements, and
+// sending messages to the queue of the node termination handler.
+func (b *policyBuilder) sqsQueue(action string) {
+	if b.conf.SQSQueueURL != "" {
+		b.addStatement(sqsQueueStatement, action, b.sqsQueueARN(b.conf.SQSQueueURL), nil)
+	}
+	if action == "sqs:SendMessage" && b.conf.NodeTerminationHandlerQueue != "" {
+		// the groups may override the queue, which is usually in the same
+		// account as the configured one
+		resource := "*"
+		if queue := b.sqsQueueARN(b.conf.NodeTerminationHandlerQueue); queue != "*" {
+			resource = b.arn("sqs", "*", strings.Split(queue, ":")[4], "*")
+		}
+		b.addStatement("NodeTerminationHandlerQueues", action, resource, nil)
+	}
+}
+
+// sqsQueueARN converts the URL of a queue, such as
+// https://sqs.us-east-1.amazonaws.com/123456789012/name, into its ARN,
+// allowing any queue when it can't be converted.
+func (b *policyBuilder) sqsQueueARN(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return "*"
+	}
+	host := strings.Split(u.Host, ".")
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(host) < 2 || host[0] != "sqs" || len(path) != 2 {
+		return "*"
+	}
+	return b.arn("sqs", host[1], path[0], path[1])
+}
+
+// s3Objects only allows accessing the objects of the region data cache and
+// reading the price override file.
+func (b *policyBuilder) s3Objects(action string) {
+	if strings.HasPrefix(b.conf.RegionDataCache, "s3://") {
+		b.addStatement("RegionDataCache", action, b.s3ObjectARN(strings.TrimSuffix(b.conf.RegionDataCache, "/")+"/*"), nil)
+	}
+	if action == "s3:GetObject" && b.conf.PriceOverrideFile != "" {
+		b.addStatement("PriceOverrideFile", action, b.s3ObjectARN(b.conf.PriceOverrideFile), nil)
+	}
+}
+
+func (b *policyBuilder) s3ObjectARN(location string) string {
+	return fmt.Sprintf("arn:%s:s3:::%s", b.partition, strings.TrimPrefix(location, "s3://"))
+}
+
+// uniqueResources returns the sorted unique non-empty resources, or any
+// resource when none is known.
+func uniqueResources(resources []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, r := range resources {
+		if r != "" && !seen[r] {
+			seen[r] = true
+			unique = append(unique, r)
+		}
+	}
+	if len(unique) == 0 {
+		return []string{"*"}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// IAMPolicy writes the least privilege IAM policy needed with the current
+// configuration.
+func (a *AutoSpotting) IAMPolicy(w io.Writer) error {
+	body, err := json.MarshalIndent(leastPrivilegePolicy(a.config, nil), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(body))
+	return err
+}
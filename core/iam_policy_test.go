@@ -0,0 +1,281 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_policyBuilder_sqsQueueARN(t *testing.T) {
+	tests := []struct {
+		name     string
+		queueURL string
+		want     string
+	}{
+		{
+			name:     "queue URL",
+			queueURL: "https://sqs.eu-west-1.amazonaws.com/123456789012/autospotting.fifo",
+			want:     "arn:aws:sqs:eu-west-1:123456789012:autospotting.fifo",
+		},
+		{
+			name:     "not a queue URL",
+			queueURL: "autospotting",
+			want:     "*",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := policyBuilder{partition: "aws"}
+			if got := b.sqsQueueARN(tt.queueURL); got != tt.want {
+				t.Errorf("sqsQueueARN() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_leastPrivilegePolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		conf           Config
+		resources      map[string]interface{}
+		wantStatements map[string]iamStatement
+		unwanted       []string
+	}{
+		{
+			name: "spot instances launched by AutoSpotting",
+			conf: Config{MainRegion: "cn-north-1", TagKeyPrefix: "acme:"},
+			wantStatements: map[string]iamStatement{
+				"LaunchSpotInstances": {
+					Sid:      "LaunchSpotInstances",
+					Effect:   "Allow",
+					Action:   []string{"ec2:RunInstances"},
+					Resource: "arn:aws-cn:ec2:*:*:instance/*",
+					Condition: map[string]map[string]string{"StringEquals": {
+						"ec2:InstanceMarketType":                       "spot",
+						"aws:RequestTag/acme:launched-by-autospotting": "true",
+					}},
+				},
+				"LaunchSpotInstancesTaggedResources": {
+					Sid:    "LaunchSpotInstancesTaggedResources",
+					Effect: "Allow",
+					Action: []string{"ec2:RunInstances"},
+					Resource: []interface{}{
+						"arn:aws-cn:ec2:*:*:volume/*",
+						"arn:aws-cn:ec2:*:*:network-interface/*",
+						"arn:aws-cn:ec2:*:*:spot-instances-request/*",
+					},
+					Condition: map[string]map[string]string{"StringEquals": {
+						"aws:RequestTag/acme:launched-by-autospotting": "true",
+					}},
+				},
+				"PassInstanceRoles": {
+					Sid:       "PassInstanceRoles",
+					Effect:    "Allow",
+					Action:    []string{"iam:PassRole"},
+					Resource:  "*",
+					Condition: map[string]map[string]string{"StringEquals": {"iam:PassedToService": "ec2.amazonaws.com"}},
+				},
+			},
+			unwanted: []string{"SQSQueue", "PermissionPreflight", "AssumeOrganizationRole"},
+		},
+		{
+			name: "restricted resources",
+			conf: Config{
+				MainRegion:                  "us-east-1",
+				SQSQueueURL:                 "https://sqs.us-east-1.amazonaws.com/123456789012/autospotting.fifo",
+				NodeTerminationHandlerQueue: "https://sqs.us-east-1.amazonaws.com/123456789012/nth",
+				OrganizationRole:            "AutoSpotting",
+				SpotCoverageTopic:           "arn:aws:sns:us-east-1:123456789012:coverage",
+				SpotCoverageThreshold:       80,
+				TerminationEventBus:         "default",
+				Profiles:                    `[{"name":"payments","termination_event_bus":"arn:aws:events:us-east-1:123456789012:event-bus/payments"}]`,
+				RegionDataCache:             "s3://my-bucket/cache/",
+				PriceOverrideFile:           "s3://my-bucket/prices.json",
+				PermissionPreflight:         true,
+			},
+			wantStatements: map[string]iamStatement{
+				"SQSQueue": {
+					Sid:      "SQSQueue",
+					Effect:   "Allow",
+					Action:   []string{"sqs:DeleteMessage", "sqs:ReceiveMessage", "sqs:SendMessage"},
+					Resource: "arn:aws:sqs:us-east-1:123456789012:autospotting.fifo",
+				},
+				"NodeTerminationHandlerQueues": {
+					Sid:      "NodeTerminationHandlerQueues",
+					Effect:   "Allow",
+					Action:   []string{"sqs:SendMessage"},
+					Resource: "arn:aws:sqs:*:123456789012:*",
+				},
+				"AssumeOrganizationRole": {
+					Sid:      "AssumeOrganizationRole",
+					Effect:   "Allow",
+					Action:   []string{"sts:AssumeRole"},
+					Resource: "arn:aws:iam::*:role/AutoSpotting",
+				},
+				"PublishSpotCoverage": {
+					Sid:      "PublishSpotCoverage",
+					Effect:   "Allow",
+					Action:   []string{"sns:Publish"},
+					Resource: []interface{}{"arn:aws:sns:us-east-1:123456789012:coverage"},
+				},
+				"PutTerminationEvents": {
+					Sid:    "PutTerminationEvents",
+					Effect: "Allow",
+					Action: []string{"events:PutEvents"},
+					Resource: []interface{}{
+						"arn:aws:events:*:*:event-bus/default",
+						"arn:aws:events:us-east-1:123456789012:event-bus/payments",
+					},
+				},
+				"RegionDataCache": {
+					Sid:      "RegionDataCache",
+					Effect:   "Allow",
+					Action:   []string{"s3:GetObject", "s3:PutObject"},
+					Resource: "arn:aws:s3:::my-bucket/cache/*",
+				},
+				"PriceOverrideFile": {
+					Sid:      "PriceOverrideFile",
+					Effect:   "Allow",
+					Action:   []string{"s3:GetObject"},
+					Resource: "arn:aws:s3:::my-bucket/prices.json",
+				},
+				"PermissionPreflight": {
+					Sid:      "PermissionPreflight",
+					Effect:   "Allow",
+					Action:   []string{"iam:GetRole", "iam:SimulatePrincipalPolicy", "sts:GetCallerIdentity"},
+					Resource: "*",
+				},
+			},
+		},
+		{
+			name:      "replaced resources",
+			conf:      Config{MainRegion: "us-east-1", SQSQueueURL: "SQSQueue"},
+			resources: map[string]interface{}{sqsQueueStatement: cfnGetAtt("SQSQueue", "Arn")},
+			wantStatements: map[string]iamStatement{
+				"SQSQueue": {
+					Sid:      "SQSQueue",
+					Effect:   "Allow",
+					Action:   []string{"sqs:DeleteMessage", "sqs:ReceiveMessage", "sqs:SendMessage"},
+					Resource: map[string]interface{}{"Fn::GetAtt": []interface{}{"SQSQueue", "Arn"}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// compare the policies as rendered, since the resources may be
+			// either strings or lists
+			body, err := json.Marshal(leastPrivilegePolicy(&tt.conf, tt.resources))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var policy struct {
+				Statement []struct {
+					Sid       string
+					Effect    string
+					Action    []string
+					Resource  interface{}
+					Condition map[string]map[string]string
+				}
+			}
+			if err := json.Unmarshal(body, &policy); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]iamStatement)
+			for _, s := range policy.Statement {
+				got[s.Sid] = iamStatement{Sid: s.Sid, Effect: s.Effect, Action: s.Action, Resource: s.Resource, Condition: s.Condition}
+			}
+
+			if _, found := got["AutoSpotting"]; !found {
+				t.Errorf("leastPrivilegePolicy() is missing the unrestricted actions")
+			}
+			for _, action := range got["AutoSpotting"].Action {
+				if action == "ec2:RunInstances" || action == "iam:PassRole" {
+					t.Errorf("leastPrivilegePolicy() allows %s for any resource", action)
+				}
+			}
+			for sid, want := range tt.wantStatements {
+				if !reflect.DeepEqual(got[sid], want) {
+					t.Errorf("leastPrivilegePolicy() statement %s = %+v, want %+v", sid, got[sid], want)
+				}
+			}
+			for _, sid := range tt.unwanted {
+				if _, found := got[sid]; found {
+					t.Errorf("leastPrivilegePolicy() has the unneeded statement %s", sid)
+				}
+			}
+		})
+	}
+}
+
+func TestAutoSpotting_IAMPolicy(t *testing.T) {
+	a := &AutoSpotting{config: &Config{MainRegion: "us-east-1"}}
+
+	var out bytes.Buffer
+	if err := a.IAMPolicy(&out); err != nil {
+		t.Fatalf("IAMPolicy() error = %v", err)
+	}
+
+	var policy iamPolicyDocument
+	if err := json.Unmarshal(out.Bytes(), &policy); err != nil {
+		t.Fatalf("IAMPolicy() didn't print a valid policy: %v", err)
+	}
+	if policy.Version != "2012-10-17" || len(policy.Statement) == 0 {
+		t.Errorf("IAMPolicy() = %+v", policy)
+	}
+}
+
+func Test_leastPrivilegePolicy_featureProbes(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// record the API calls made by the probes instead of sending them
+	called := make(map[string]bool)
+	svc := ec2.New(sess)
+	svc.Handlers.Send.Clear()
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		called["ec2:"+r.Operation.Name] = true
+		r.Error = awserr.New("DryRunOperation", "Request would have succeeded", nil)
+	})
+
+	r := &region{name: "us-east-1", services: connections{ec2: svc}}
+	for feature, probe := range featureProbes {
+		if _, err := probe(r, "us-east-1a"); err != nil {
+			t.Errorf("probing %s failed: %v", feature, err)
+		}
+	}
+	if len(called) == 0 {
+		t.Fatal("the feature probes didn't call any API")
+	}
+
+	allowed := make(map[string]bool)
+	for _, s := range leastPrivilegePolicy(&Config{MainRegion: "us-east-1"}, nil).Statement {
+		for _, action := range s.Action {
+			allowed[action] = true
+		}
+	}
+	for action := range called {
+		if !allowed[action] {
+			t.Errorf("leastPrivilegePolicy() doesn't allow %s, called by the feature probes", action)
+		}
+	}
+}
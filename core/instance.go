@@ -21,18 +21,6 @@ import (
 	"github.com/davecgh/go-spew/spew"
 )
 
-var unsupportedIO2Regions = [...]string{
-	"us-gov-west-1",
-	"us-gov-east-1",
-	"sa-east-1",
-	"cn-north-1",
-	"cn-northwest-1",
-	"eu-south-1",
-	"af-south-1",
-	"eu-west-3",
-	"ap-northeast-3",
-}
-
 // The key in this map is the instance ID, useful for quick retrieval of
 // instance attributes.
 type instanceMap map[string]*instance
@@ -48,7 +36,7 @@ type instances interface {
 	count() int
 	count64() int64
 	make()
-	instances() <-chan *instance
+	instances() []*instance
 	dump() string
 }
 
@@ -78,7 +66,7 @@ func (is *instanceManager) add(inst *instance) {
 
 	is.Lock()
 	defer is.Unlock()
-	is.catalog[*inst.InstanceId] = inst
+	is.catalog[aws.StringValue(inst.InstanceId)] = inst
 }
 
 func (is *instanceManager) get(id string) (inst *instance) {
@@ -98,18 +86,24 @@ func (is *instanceManager) count64() int64 {
 	return int64(is.count())
 }
 
-func (is *instanceManager) instances() <-chan *instance {
-	retC := make(chan *instance)
-	go func() {
-		is.RLock()
-		defer is.RUnlock()
-		defer close(retC)
-		for _, i := range is.catalog {
-			retC <- i
-		}
-	}()
+// instances returns a snapshot of the instances, sorted by their ID, which
+// is safe to iterate over while the catalog is being modified and doesn't
+// need to be fully consumed.
+func (is *instanceManager) instances() []*instance {
+	is.RLock()
+	defer is.RUnlock()
 
-	return retC
+	ids := make([]string, 0, len(is.catalog))
+	for id := range is.catalog {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	snapshot := make([]*instance, 0, len(ids))
+	for _, id := range ids {
+		snapshot = append(snapshot, is.catalog[id])
+	}
+	return snapshot
 }
 
 // instance wraps an ec2.instance and has some additional fields and functions
@@ -120,16 +114,22 @@ type instance struct {
 	region    *region
 	protected bool
 	asg       *autoScalingGroup
+
+	// whether only some of the instance details were retained when scanning
+	// the region, see slimInstance
+	slim bool
 }
 
 type acceptableInstance struct {
 	instanceTI instanceTypeInformation
 	price      float64
+	score      float64
 }
 
 type instanceTypeInformation struct {
 	instanceType             string
 	vCPU                     int
+	cores                    int
 	PhysicalProcessor        string
 	GPU                      int
 	pricing                  prices
@@ -139,15 +139,19 @@ type instanceTypeInformation struct {
 	instanceStoreDeviceSize  float32
 	instanceStoreDeviceCount int
 	instanceStoreIsSSD       bool
+	instanceStoreIsNVMe      bool
 	hasEBSOptimization       bool
 	EBSThroughput            float32
+	ebsBaselineThroughput    float64
+	ebsBaselineIOPS          int64
+	accelerator              acceleratorInformation
 }
 
 func (i *instance) calculatePrice(spotCandidate instanceTypeInformation) float64 {
-	spotPrice := spotCandidate.pricing.spot[*i.Placement.AvailabilityZone]
+	spotPrice := i.spotPriceOf(spotCandidate)
 	debug.Println("Comparing price spot/instance:")
 
-	if i.EbsOptimized != nil && *i.EbsOptimized {
+	if aws.BoolValue(i.EbsOptimized) {
 		spotPrice += spotCandidate.pricing.ebsSurcharge
 		debug.Println("\tEBS Surcharge : ", spotCandidate.pricing.ebsSurcharge)
 	}
@@ -157,21 +161,59 @@ func (i *instance) calculatePrice(spotCandidate instanceTypeInformation) float64
 	return spotPrice
 }
 
+// rankingScore blends the current price of the spot candidate with its
+// average price and volatility over the configured spot price history, so
+// that briefly cheap but volatile spot pools are deprioritized. Without price
+// history the candidates are ranked by their current price.
+func (i *instance) rankingScore(spotCandidate instanceTypeInformation, price float64) float64 {
+	if i.region == nil || i.region.conf == nil || i.region.conf.SpotPriceHistoryDays <= 0 {
+		return price
+	}
+
+	az := i.availabilityZone()
+	stats, found := spotCandidate.pricing.spotStats[az]
+	if !found {
+		return price
+	}
+
+	// the surcharges included in the price also apply to the average price
+	surcharge := price - spotCandidate.pricing.spot[az]
+	weight := math.Max(0, math.Min(1, i.region.conf.SpotPriceAverageWeight))
+
+	return price*(1-weight) + (stats.average+surcharge)*weight +
+		i.region.conf.SpotPriceVolatilityWeight*stats.volatility
+}
+
 func (i *instance) isSpot() bool {
-	return i.InstanceLifecycle != nil &&
-		*i.InstanceLifecycle == Spot
+	return aws.StringValue(i.InstanceLifecycle) == Spot
 }
 
 func (i *instance) getSavings() float64 {
-	odPrice := i.typeInfo.pricing.onDemand
-	spotPrice := i.typeInfo.pricing.spot[*i.Placement.AvailabilityZone]
+	odPrice := i.onDemandPriceOf(i.typeInfo)
+	spotPrice := i.spotPriceOf(i.typeInfo)
 
-	log.Printf("Calculating savings for instance %s with OD price %f and Spot price %f\n", *i.InstanceId, odPrice, spotPrice)
+	log.Printf("Calculating savings for instance %s with OD price %f and Spot price %f\n", aws.StringValue(i.InstanceId), odPrice, spotPrice)
 	return odPrice - spotPrice
 }
 
+// isProtectedFromTermination determines the API termination protection of the
+// instance, which is memoized for the rest of the run. The lookup is skipped
+// for groups configured not to check it.
 func (i *instance) isProtectedFromTermination() (bool, error) {
-	debug.Println("\tChecking termination protection for instance: ", *i.InstanceId)
+	if i.asg != nil && i.asg.config.SkipTerminationProtectionCheck {
+		return false, nil
+	}
+
+	id := aws.StringValue(i.InstanceId)
+
+	i.region.terminationProtectionLock.Lock()
+	defer i.region.terminationProtectionLock.Unlock()
+
+	if protected, ok := i.region.terminationProtection[id]; ok {
+		return protected, nil
+	}
+
+	debug.Println("\tChecking termination protection for instance: ", id)
 
 	// determine and set the API termination protection field
 	diaRes, err := i.region.services.ec2.DescribeInstanceAttribute(
@@ -181,21 +223,54 @@ func (i *instance) isProtectedFromTermination() (bool, error) {
 		})
 
 	if err != nil {
-		// better safe than sorry!
-		log.Printf("Couldn't describe instance attributes, assuming instance %v is protected: %v\n",
-			*i.InstanceId, err.Error())
+		// better safe than sorry, but the result isn't memoized so that
+		// transient errors such as throttling are retried on the next lookup
+		log.Printf("Couldn't describe instance attributes, assuming instance %v is protected until the next check: %v\n",
+			id, err.Error())
 		return true, err
 	}
 
-	if diaRes != nil &&
+	protected := diaRes != nil &&
 		diaRes.DisableApiTermination != nil &&
-		diaRes.DisableApiTermination.Value != nil &&
-		*diaRes.DisableApiTermination.Value {
+		aws.BoolValue(diaRes.DisableApiTermination.Value)
+
+	if protected {
 		log.Printf("\t: %v Instance, %v is protected from termination\n",
-			*i.Placement.AvailabilityZone, *i.InstanceId)
-		return true, nil
+			i.availabilityZone(), id)
 	}
-	return false, nil
+
+	if i.region.terminationProtection == nil {
+		i.region.terminationProtection = make(map[string]bool)
+	}
+	i.region.terminationProtection[id] = protected
+
+	return protected, nil
+}
+
+// setTerminationProtection enables or disables the API termination protection
+// of the instance.
+func (i *instance) setTerminationProtection(enabled bool) error {
+	_, err := i.region.services.ec2.ModifyInstanceAttribute(
+		&ec2.ModifyInstanceAttributeInput{
+			InstanceId:            i.InstanceId,
+			DisableApiTermination: &ec2.AttributeBooleanValue{Value: aws.Bool(enabled)},
+		})
+
+	if err != nil {
+		log.Printf("Couldn't set termination protection to %v for instance %s: %v\n",
+			enabled, aws.StringValue(i.InstanceId), err.Error())
+		return err
+	}
+
+	i.region.terminationProtectionLock.Lock()
+	defer i.region.terminationProtectionLock.Unlock()
+
+	if i.region.terminationProtection == nil {
+		i.region.terminationProtection = make(map[string]bool)
+	}
+	i.region.terminationProtection[aws.StringValue(i.InstanceId)] = enabled
+
+	return nil
 }
 
 func (i *instance) isProtectedFromScaleIn() bool {
@@ -204,11 +279,11 @@ func (i *instance) isProtectedFromScaleIn() bool {
 	}
 
 	for _, inst := range i.asg.Instances {
-		if *inst.InstanceId == *i.InstanceId &&
-			*inst.ProtectedFromScaleIn {
+		if aws.StringValue(inst.InstanceId) == aws.StringValue(i.InstanceId) &&
+			aws.BoolValue(inst.ProtectedFromScaleIn) {
 			log.Printf("\t: %v Instance, %v is protected from scale-in\n",
-				*inst.AvailabilityZone,
-				*inst.InstanceId)
+				aws.StringValue(inst.AvailabilityZone),
+				aws.StringValue(inst.InstanceId))
 			return true
 		}
 	}
@@ -216,72 +291,132 @@ func (i *instance) isProtectedFromScaleIn() bool {
 }
 
 func (i *instance) canTerminate() bool {
-	return *i.State.Name != ec2.InstanceStateNameTerminated &&
-		*i.State.Name != ec2.InstanceStateNameShuttingDown
+	return i.stateName() != ec2.InstanceStateNameTerminated &&
+		i.stateName() != ec2.InstanceStateNameShuttingDown
 }
 
 func (i *instance) terminate() error {
 	var err error
 	log.Printf("Instance: %v\n", i)
 
-	log.Printf("Terminating %v", *i.InstanceId)
+	log.Printf("Terminating %v", aws.StringValue(i.InstanceId))
 	svc := i.region.services.ec2
 
 	if !i.canTerminate() {
 		log.Printf("Can't terminate %v, current state: %s",
-			*i.InstanceId, *i.State.Name)
-		return fmt.Errorf("can't terminate %s", *i.InstanceId)
+			aws.StringValue(i.InstanceId), i.stateName())
+		return fmt.Errorf("can't terminate %s", aws.StringValue(i.InstanceId))
 	}
 
+	// the cancellation error is already logged, terminating the instance is
+	// still needed even if its spot request may launch another one
+	_ = i.cancelSpotInstanceRequest()
+
 	_, err = svc.TerminateInstances(&ec2.TerminateInstancesInput{
 		InstanceIds: []*string{i.InstanceId},
 	})
 
 	if err != nil {
-		log.Printf("Issue while terminating %v: %v", *i.InstanceId, err.Error())
+		log.Printf("Issue while terminating %v: %v", aws.StringValue(i.InstanceId), err.Error())
 	}
 
 	return err
 }
 
 func (i *instance) shouldBeReplacedWithSpot() bool {
-	protT, _ := i.isProtectedFromTermination()
 	return i.belongsToEnabledASG() &&
 		i.asgNeedsReplacement() &&
 		!i.isSpot() &&
-		!i.isProtectedFromScaleIn() &&
-		!protT
+		!i.asg.isBlockedByScaleInProtection(i) &&
+		!i.asg.isBlockedByTerminationProtection(i) &&
+		i.isTenancyReplaceable() &&
+		i.asg.kubernetesAutoscalerAllowsReplacement(i)
+}
+
+// availabilityZone returns the zone of the instance, or an empty string when
+// its placement is unknown.
+func (i *instance) availabilityZone() string {
+	if i.Instance == nil || i.Placement == nil {
+		return ""
+	}
+	return aws.StringValue(i.Placement.AvailabilityZone)
+}
+
+// stateName returns the state of the instance, or an empty string when it's
+// unknown.
+func (i *instance) stateName() string {
+	if i.State == nil {
+		return ""
+	}
+	return aws.StringValue(i.State.Name)
+}
+
+func (i *instance) tenancy() string {
+	if i.Placement == nil || aws.StringValue(i.Placement.Tenancy) == "" {
+		return ec2.TenancyDefault
+	}
+	return *i.Placement.Tenancy
+}
+
+// isOnDedicatedHost tells if the instance runs on a Dedicated Host, where spot
+// instances can't run.
+func (i *instance) isOnDedicatedHost() bool {
+	return i.Placement != nil && (i.tenancy() == ec2.TenancyHost ||
+		i.Placement.HostId != nil || i.Placement.HostResourceGroupArn != nil)
+}
+
+// isTenancyReplaceable returns false for instances running on Dedicated Hosts,
+// which can't be replaced by spot instances, and for dedicated instances
+// unless explicitly allowed for the group, in which case the tenancy is copied
+// from the placement of the original instance when launching its replacement.
+func (i *instance) isTenancyReplaceable() bool {
+	if i.isOnDedicatedHost() {
+		log.Printf("%s instance %s is running on a Dedicated Host, skipping it because "+
+			"spot instances can't run on Dedicated Hosts\n",
+			i.region.name, aws.StringValue(i.InstanceId))
+		return false
+	}
+
+	tenancy := i.tenancy()
+	if tenancy == ec2.TenancyDefault {
+		return true
+	}
+
+	if i.asg != nil && i.asg.config.AllowDedicatedTenancy {
+		debug.Println("Instance", aws.StringValue(i.InstanceId), "has", tenancy,
+			"tenancy, replacement allowed by the group configuration")
+		return true
+	}
+
+	log.Printf("%s instance %s is running with %s tenancy, skipping it because "+
+		"replacing dedicated tenancy instances wasn't enabled using %s\n",
+		i.region.name, aws.StringValue(i.InstanceId), tenancy, AllowDedicatedTenancyTag)
+	return false
 }
 
 func (i *instance) belongsToEnabledASG() bool {
 	belongs, asgName := i.belongsToAnASG()
 	if !belongs {
 		log.Printf("%s instane %s doesn't belong to any ASG",
-			i.region.name, *i.InstanceId)
+			i.region.name, aws.StringValue(i.InstanceId))
 		return false
 	}
 
-	for _, asg := range i.region.enabledASGs {
-		if asg.name == *asgName {
-			asg.config = i.region.conf.AutoScalingConfig
-			asg.scanInstances()
-			asg.loadDefaultConfig()
-			asg.loadConfigFromTags()
-			asg.loadLaunchConfiguration()
-			asg.loadLaunchTemplate()
-			i.asg = &asg
-			i.price = i.typeInfo.pricing.onDemand / i.region.conf.OnDemandPriceMultiplier * i.asg.config.OnDemandPriceMultiplier
-			log.Printf("%s instace %s belongs to enabled ASG %s", i.region.name,
-				*i.InstanceId, i.asg.name)
-			return true
-		}
+	asg := i.region.loadEnabledASG(*asgName)
+	if asg == nil {
+		return false
 	}
-	return false
+
+	i.asg = asg
+	i.price = i.onDemandPriceOf(i.typeInfo) / i.region.onDemandPriceMultiplier() * asg.config.OnDemandPriceMultiplier
+	log.Printf("%s instace %s belongs to enabled ASG %s", i.region.name,
+		aws.StringValue(i.InstanceId), asg.name)
+	return true
 }
 
 func (i *instance) belongsToAnASG() (bool, *string) {
 	for _, tag := range i.Tags {
-		if *tag.Key == "aws:autoscaling:groupName" {
+		if aws.StringValue(tag.Key) == "aws:autoscaling:groupName" {
 			return true, tag.Value
 		}
 	}
@@ -319,7 +454,8 @@ func (i *instance) isClassCompatible(spotCandidate instanceTypeInformation) bool
 	if i.isSameArch(spotCandidate) &&
 		spotCandidate.vCPU >= current.vCPU &&
 		spotCandidate.memory >= current.memory &&
-		spotCandidate.GPU >= current.GPU {
+		spotCandidate.GPU >= current.GPU &&
+		i.isAcceleratorCompatible(spotCandidate) {
 		return true
 	}
 	debug.Println("\tNot class compatible (CPU/memory/GPU)")
@@ -358,9 +494,26 @@ func isARM(cpuName string) bool {
 	return strings.Contains(cpuName, "AWS")
 }
 
+// isEBSCompatible compares the baseline EBS throughput and IOPS of the
+// instance types when known, so that sustained EBS workloads don't get
+// throttled on instance types only matching the current one when bursting.
+// Otherwise it falls back to the EBS throughput from the static data.
 func (i *instance) isEBSCompatible(spotCandidate instanceTypeInformation) bool {
-	if spotCandidate.EBSThroughput < i.typeInfo.EBSThroughput {
-		debug.Println("\tEBS throughput insufficient:", spotCandidate.EBSThroughput, "<", i.typeInfo.EBSThroughput)
+	current := i.typeInfo
+
+	if current.ebsBaselineThroughput > 0 && spotCandidate.ebsBaselineThroughput > 0 {
+		if spotCandidate.ebsBaselineThroughput < current.ebsBaselineThroughput ||
+			spotCandidate.ebsBaselineIOPS < current.ebsBaselineIOPS {
+			debug.Println("\tEBS baseline throughput/IOPS insufficient:",
+				spotCandidate.ebsBaselineThroughput, "/", spotCandidate.ebsBaselineIOPS, "<",
+				current.ebsBaselineThroughput, "/", current.ebsBaselineIOPS)
+			return false
+		}
+		return true
+	}
+
+	if spotCandidate.EBSThroughput < current.EBSThroughput {
+		debug.Println("\tEBS throughput insufficient:", spotCandidate.EBSThroughput, "<", current.EBSThroughput)
 		return false
 	}
 	return true
@@ -378,28 +531,52 @@ func (i *instance) isStorageCompatible(spotCandidate instanceTypeInformation, at
 	existing := i.typeInfo
 
 	debug.Println("Comparing storage spot/instance:")
-	debug.Println("\tSpot volumes/size/ssd: ",
+	debug.Println("\tSpot volumes/size/ssd/nvme: ",
 		spotCandidate.instanceStoreDeviceCount,
 		spotCandidate.instanceStoreDeviceSize,
-		spotCandidate.instanceStoreIsSSD)
-	debug.Println("\tInstance volumes/size/ssd: ",
+		spotCandidate.instanceStoreIsSSD,
+		spotCandidate.instanceStoreIsNVMe)
+	debug.Println("\tInstance volumes/size/ssd/nvme: ",
 		attachedVolumes,
 		existing.instanceStoreDeviceSize,
-		existing.instanceStoreIsSSD)
+		existing.instanceStoreIsSSD,
+		existing.instanceStoreIsNVMe)
 
-	if attachedVolumes == 0 ||
-		(spotCandidate.instanceStoreDeviceCount >= attachedVolumes &&
-			spotCandidate.instanceStoreDeviceSize >= existing.instanceStoreDeviceSize &&
-			(spotCandidate.instanceStoreIsSSD ||
-				spotCandidate.instanceStoreIsSSD == existing.instanceStoreIsSSD)) {
+	if attachedVolumes == 0 {
 		return true
 	}
-	debug.Println("\tNot storage compatible")
-	return false
+
+	compatibility := DefaultInstanceStoreCompatibility
+	if i.asg != nil && i.asg.config.InstanceStoreCompatibility != "" {
+		compatibility = i.asg.config.InstanceStoreCompatibility
+	}
+
+	compatible := spotCandidate.instanceStoreDeviceCount >= attachedVolumes
+
+	if compatibility != RelaxedInstanceStoreCompatibility {
+		spotCapacity := spotCandidate.instanceStoreDeviceSize * float32(spotCandidate.instanceStoreDeviceCount)
+		usedCapacity := existing.instanceStoreDeviceSize * float32(attachedVolumes)
+
+		compatible = compatible &&
+			spotCapacity >= usedCapacity &&
+			(spotCandidate.instanceStoreIsSSD || !existing.instanceStoreIsSSD) &&
+			(spotCandidate.instanceStoreIsNVMe || !existing.instanceStoreIsNVMe)
+	}
+
+	if compatibility == StrictInstanceStoreCompatibility {
+		compatible = compatible &&
+			spotCandidate.instanceStoreDeviceSize >= existing.instanceStoreDeviceSize &&
+			spotCandidate.instanceStoreIsNVMe == existing.instanceStoreIsNVMe
+	}
+
+	if !compatible {
+		debug.Println("\tNot storage compatible using the", compatibility, "instance store compatibility")
+	}
+	return compatible
 }
 
 func (i *instance) isVirtualizationCompatible(spotVirtualizationTypes []string) bool {
-	current := *i.VirtualizationType
+	current := aws.StringValue(i.VirtualizationType)
 	if len(spotVirtualizationTypes) == 0 {
 		spotVirtualizationTypes = []string{"HVM"}
 	}
@@ -442,36 +619,36 @@ func (i *instance) isAllowed(instanceType string, allowedList []string, disallow
 
 func (i *instance) handleInstanceStates() (bool, error) {
 	log.Printf("%s Found instance %s in state %s",
-		i.region.name, *i.InstanceId, *i.State.Name)
+		i.region.name, aws.StringValue(i.InstanceId), i.stateName())
 
-	if *i.State.Name != "running" {
+	if i.stateName() != "running" {
 		log.Printf("%s Instance %s is not in the running state",
-			i.region.name, *i.InstanceId)
-		return true, errors.New("instance not in running state")
+			i.region.name, aws.StringValue(i.InstanceId))
+		return true, ErrInstanceNotRunning
 	}
 
 	unattached := i.isUnattachedSpotInstanceLaunchedForAnEnabledASG()
 	if !unattached {
 		log.Printf("%s Instance %s is already attached to an ASG, skipping it",
-			i.region.name, *i.InstanceId)
+			i.region.name, aws.StringValue(i.InstanceId))
 		return true, nil
 	}
 	return false, nil
 }
 
-func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allowedList []string,
-	disallowedList []string) ([]instanceTypeInformation, error) {
-	current := i.typeInfo
-	var acceptableInstanceTypes []acceptableInstance
-
-	// Count the ephemeral volumes attached to the original instance's block
-	// device mappings, this number is used later when comparing with each
-	// instance type.
-
+// attachedInstanceStoreVolumes counts the ephemeral volumes attached to the
+// original instance's block device mappings, which is used when comparing
+// with each instance type.
+func (i *instance) attachedInstanceStoreVolumes() int {
 	lcMappings := i.asg.launchConfiguration.countLaunchConfigEphemeralVolumes()
 	ltMappings := i.asg.launchTemplate.countLaunchTemplateEphemeralVolumes()
 	usedMappings := max(lcMappings, ltMappings)
-	attachedVolumesNumber := min(usedMappings, current.instanceStoreDeviceCount)
+	return min(usedMappings, i.typeInfo.instanceStoreDeviceCount)
+}
+
+func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allowedList []string,
+	disallowedList []string) ([]instanceTypeInformation, error) {
+	attachedVolumesNumber := i.attachedInstanceStoreVolumes()
 
 	// Iterate alphabetically by instance type
 	keys := make([]string, 0)
@@ -485,29 +662,35 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 
 	sort.Strings(keys)
 
-	// Find all compatible and not blocked instance types
+	candidates := make([]instanceTypeInformation, 0, len(keys))
 	for _, k := range keys {
-		candidate := i.region.instanceTypeInformation[k]
-
-		candidatePrice := i.calculatePrice(candidate)
-		debug.Println("Comparing current type", current.instanceType, "with price", i.price,
-			"with candidate", candidate.instanceType, "with price", candidatePrice)
-
-		if i.isAllowed(candidate.instanceType, allowedList, disallowedList) &&
-			i.isPriceCompatible(candidatePrice) &&
-			i.isEBSCompatible(candidate) &&
-			i.isClassCompatible(candidate) &&
-			i.isStorageCompatible(candidate, attachedVolumesNumber) &&
-			i.isVirtualizationCompatible(candidate.virtualizationTypes) {
-			acceptableInstanceTypes = append(acceptableInstanceTypes, acceptableInstance{candidate, candidatePrice})
-			log.Println("\tMATCH FOUND, added", candidate.instanceType, "to launch candidates list for instance", *i.InstanceId)
-		} else if candidate.instanceType != "" {
-			debug.Println("Non compatible option found:", candidate.instanceType, "at", candidatePrice, " - discarding")
-		}
+		candidates = append(candidates, i.region.instanceTypeInformation[k])
 	}
 
+	// Find all compatible and not blocked instance types
+	acceptableInstanceTypes := evaluateCandidates(candidates,
+		func(candidate instanceTypeInformation) (acceptableInstance, bool) {
+			candidatePrice := i.calculatePrice(candidate)
+
+			if i.isAllowed(candidate.instanceType, allowedList, disallowedList) &&
+				i.isPriceCompatible(candidatePrice) &&
+				i.isOfferedInZone(candidate) &&
+				i.isTypeCompatible(candidate, attachedVolumesNumber) {
+				log.Println("\tMATCH FOUND, added", candidate.instanceType, "to launch candidates list for instance", aws.StringValue(i.InstanceId))
+				return acceptableInstance{candidate, candidatePrice, i.rankingScore(candidate, candidatePrice)}, true
+			}
+
+			if candidate.instanceType != "" {
+				debug.Println("Non compatible option found:", candidate.instanceType, "at", candidatePrice, " - discarding")
+			}
+			return acceptableInstance{}, false
+		})
+
 	if acceptableInstanceTypes != nil {
 		sort.Slice(acceptableInstanceTypes, func(i, j int) bool {
+			if acceptableInstanceTypes[i].score != acceptableInstanceTypes[j].score {
+				return acceptableInstanceTypes[i].score < acceptableInstanceTypes[j].score
+			}
 			return acceptableInstanceTypes[i].price < acceptableInstanceTypes[j].price
 		})
 		debug.Println("List of cheapest compatible spot instances found, sorted ascending by price: ",
@@ -519,25 +702,46 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 		return result, nil
 	}
 
-	return nil, fmt.Errorf("no cheaper spot instance types could be found")
+	return nil, ErrNotPriceCompatible
 }
 
 func (i *instance) launchSpotReplacement() (*string, error) {
-	i.price = i.typeInfo.pricing.onDemand / i.region.conf.OnDemandPriceMultiplier * i.asg.config.OnDemandPriceMultiplier
+	if i.asg.isActionDisabled(LaunchSpotAction) {
+		return nil, i.asg.actionDisabledError(LaunchSpotAction)
+	}
+
+	i.price = i.onDemandPriceOf(i.typeInfo) / i.region.onDemandPriceMultiplier() * i.asg.config.OnDemandPriceMultiplier
+	allowedList, disallowedList := i.asg.getAllowedInstanceTypes(i), i.asg.getDisallowedInstanceTypes(i)
 	instanceTypes, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
-		i.asg.getAllowedInstanceTypes(i),
-		i.asg.getDisallowedInstanceTypes(i))
+		allowedList, disallowedList)
 
 	if err != nil {
 		log.Println("Couldn't determine the cheapest compatible spot instance type")
+		i.asg.recordSkipReason(i.compatibilitySkipReason(err, allowedList, disallowedList))
 		return nil, err
 	}
 
+	instanceTypes = i.asg.diversifyInstanceTypes(instanceTypes)
+
+	var lastFailure *launchError
+
 	//Go through all compatible instances until one type launches or we are out of options.
 	for _, instanceType := range instanceTypes {
-		az := *i.Placement.AvailabilityZone
-		bidPrice := i.getPriceToBid(i.price,
-			instanceType.pricing.spot[az], instanceType.pricing.premium)
+		az := i.availabilityZone()
+		spotPrice := i.spotPriceOf(instanceType)
+		bidPrice := i.getPriceToBid(i.price, spotPrice, i.premiumOf(instanceType))
+
+		if err := i.validateBidPrice(instanceType.instanceType, bidPrice); err != nil {
+			log.Println(az, i.asg.name, "Refusing to bid", bidPrice, "for instance type",
+				instanceType.instanceType, err.Error())
+			continue
+		}
+
+		if bidPrice < spotPrice {
+			log.Println(az, i.asg.name, "Bid price", bidPrice, "is below the current spot price",
+				spotPrice, "skipping instance type", instanceType.instanceType)
+			continue
+		}
 
 		runInstancesInput, err := i.createRunInstancesInput(instanceType.instanceType, bidPrice)
 		if err != nil {
@@ -550,46 +754,107 @@ func (i *instance) launchSpotReplacement() (*string, error) {
 		resp, err := i.region.services.ec2.RunInstances(runInstancesInput)
 
 		if err != nil {
-			if strings.Contains(err.Error(), "InsufficientInstanceCapacity") {
+			reason := classifyLaunchError(err)
+			i.region.conf.launchFailures.record(reason)
+			lastFailure = &launchError{reason: reason, err: err}
+
+			if reason == capacityLaunchFailure {
 				log.Println("Couldn't launch spot instance due to lack of capacity, trying next instance type:", err.Error())
 			} else {
-				log.Println("Couldn't launch spot instance:", err.Error(), "trying next instance type")
+				log.Println("Couldn't launch spot instance:", err.Error(), "failure reason:", reason, "trying next instance type")
 				debug.Println(runInstancesInput)
 			}
 		} else {
 			spotInst := resp.Instances[0]
-			log.Println(i.asg.name, "Successfully launched spot instance", *spotInst.InstanceId,
-				"of type", *spotInst.InstanceType,
+			log.Println(i.asg.name, "Successfully launched spot instance", aws.StringValue(spotInst.InstanceId),
+				"of type", aws.StringValue(spotInst.InstanceType),
 				"with bid price", bidPrice,
-				"current spot price", instanceType.pricing.spot[az])
+				"current spot price", spotPrice)
 
 			debug.Println("RunInstances response:", spew.Sdump(resp))
 			// add to FinalRecap
-			recapText := fmt.Sprintf("%s Launched spot instance %s", i.asg.name, *spotInst.InstanceId)
-			i.region.conf.FinalRecap[i.region.name] = append(i.region.conf.FinalRecap[i.region.name], recapText)
+			recapText := fmt.Sprintf("%s Launched spot instance %s", i.asg.name, aws.StringValue(spotInst.InstanceId))
+			i.region.conf.addRecap(i.region.name, recapText)
 			return spotInst.InstanceId, nil
 		}
 	}
 
 	log.Println(i.asg.name, "Exhausted all compatible instance types without launch success. Aborting.")
+	if lastFailure != nil {
+		recapText := fmt.Sprintf("%s Failed launching spot instance [%s]", i.asg.name, lastFailure.reason)
+		i.region.conf.addRecap(i.region.name, recapText)
+
+		lastFailure.err = fmt.Errorf("exhausted all compatible instance types, last error: %w", lastFailure.err)
+		i.asg.recordSkipReason(skipReasonOf(lastFailure))
+		return nil, lastFailure
+	}
 	return nil, errors.New("exhausted all compatible instance types")
 
 }
 
+// validateBidPrice guards against runaway bids caused by stale pricing data,
+// by refusing bids computed from spot prices older than the configured TTL, or
+// exceeding the live spot price by more than the configured percentage.
+func (i *instance) validateBidPrice(instanceType string, bidPrice float64) error {
+	conf := i.region.conf
+
+	if conf.SpotPriceTTL > 0 {
+		age := conf.getClock().Now().Sub(i.region.spotPricesFetchedAt)
+		if age > conf.SpotPriceTTL {
+			return fmt.Errorf("%w: the spot prices were fetched %s ago, longer than the %s TTL",
+				ErrBidRefused, age.Round(time.Second), conf.SpotPriceTTL)
+		}
+	}
+
+	if conf.MaxBidDeviationPercentage > 0 {
+		livePrice, err := i.region.liveSpotPrice(i.spotProduct(), instanceType, i.availabilityZone())
+		if err != nil {
+			return fmt.Errorf("%w: couldn't determine the live spot price: %s", ErrBidRefused, err.Error())
+		}
+
+		if maxBid := livePrice * (1 + conf.MaxBidDeviationPercentage/100); bidPrice > maxBid {
+			return fmt.Errorf("%w: the bid exceeds the live spot price %v by more than %v%%",
+				ErrBidRefused, livePrice, conf.MaxBidDeviationPercentage)
+		}
+	}
+
+	return nil
+}
+
+// getPriceToBid determines the spot bid price according to the bidding policy
+// of the instance's group, which defaults to the global configuration.
 func (i *instance) getPriceToBid(
 	baseOnDemandPrice float64, currentSpotPrice float64, spotPremium float64) float64 {
 
-	debug.Println("BiddingPolicy: ", i.region.conf.BiddingPolicy)
+	conf := i.asg.config
+	debug.Println("BiddingPolicy: ", conf.BiddingPolicy)
 
-	if i.region.conf.BiddingPolicy == DefaultBiddingPolicy {
-		log.Println("Bidding base on demand price", baseOnDemandPrice, "to replace instance", *i.InstanceId)
-		return baseOnDemandPrice
+	switch conf.BiddingPolicy {
+	case AggressiveBiddingPolicy:
+		bufferPrice := math.Min(baseOnDemandPrice, ((currentSpotPrice-spotPremium)*(1.0+conf.SpotPriceBufferPercentage/100.0))+spotPremium)
+		log.Println("Bidding buffer-based price of", bufferPrice, "based on current spot price of", currentSpotPrice,
+			"and buffer percentage of", conf.SpotPriceBufferPercentage, "to replace instance", aws.StringValue(i.InstanceId))
+		return bufferPrice
+
+	case FixedBiddingPolicy:
+		if conf.SpotMaxPrice > 0 {
+			log.Println("Bidding fixed price of", conf.SpotMaxPrice, "to replace instance", aws.StringValue(i.InstanceId))
+			return conf.SpotMaxPrice
+		}
+		log.Println("Missing fixed maximum price, falling back to bidding the on demand price")
+
+	case PercentageBiddingPolicy:
+		if conf.SpotPriceOnDemandPercentage > 0 {
+			percentagePrice := baseOnDemandPrice * conf.SpotPriceOnDemandPercentage / 100.0
+			log.Println("Bidding", conf.SpotPriceOnDemandPercentage, "percent of the on demand price", baseOnDemandPrice,
+				"to replace instance", aws.StringValue(i.InstanceId))
+			return percentagePrice
+		}
+		log.Println("Missing on demand price percentage, falling back to bidding the on demand price")
 	}
 
-	bufferPrice := math.Min(baseOnDemandPrice, ((currentSpotPrice-spotPremium)*(1.0+i.region.conf.SpotPriceBufferPercentage/100.0))+spotPremium)
-	log.Println("Bidding buffer-based price of", bufferPrice, "based on current spot price of", currentSpotPrice,
-		"and buffer percentage of", i.region.conf.SpotPriceBufferPercentage, "to replace instance", i.InstanceId)
-	return bufferPrice
+	log.Println("Bidding base on demand price", baseOnDemandPrice, "to replace instance", aws.StringValue(i.InstanceId))
+	return baseOnDemandPrice
 }
 
 func (i *instance) convertLaunchConfigurationBlockDeviceMappings(BDMs []*autoscaling.BlockDeviceMapping) []*ec2.BlockDeviceMapping {
@@ -613,12 +878,14 @@ func (i *instance) convertLaunchConfigurationBlockDeviceMappings(BDMs []*autosca
 				Iops:                BDM.Ebs.Iops,
 				SnapshotId:          BDM.Ebs.SnapshotId,
 				VolumeSize:          BDM.Ebs.VolumeSize,
-				VolumeType:          convertLaunchConfigurationEBSVolumeType(BDM.Ebs, i.asg),
+				VolumeType:          BDM.Ebs.VolumeType,
+				Throughput:          BDM.Ebs.Throughput,
 			}
+			i.asg.convertEBSVolume(ec2BDM.Ebs, i.availabilityZone())
 		}
 
 		// handle the noDevice field directly by skipping the device if set to true
-		if BDM.NoDevice != nil && *BDM.NoDevice {
+		if aws.BoolValue(BDM.NoDevice) {
 			continue
 		}
 		bds = append(bds, ec2BDM)
@@ -651,12 +918,14 @@ func (i *instance) convertLaunchTemplateBlockDeviceMappings(BDMs []*ec2.LaunchTe
 				Iops:                BDM.Ebs.Iops,
 				SnapshotId:          BDM.Ebs.SnapshotId,
 				VolumeSize:          BDM.Ebs.VolumeSize,
-				VolumeType:          convertLaunchTemplateEBSVolumeType(BDM.Ebs, i.asg),
+				VolumeType:          BDM.Ebs.VolumeType,
+				Throughput:          BDM.Ebs.Throughput,
 			}
+			i.asg.convertEBSVolume(ec2BDM.Ebs, i.availabilityZone())
 		}
 
 		// handle the noDevice field directly by skipping the device if set to true, apparently NoDevice is here a string instead of a bool.
-		if BDM.NoDevice != nil && *BDM.NoDevice == "true" {
+		if aws.StringValue(BDM.NoDevice) == "true" {
 			continue
 		}
 		bds = append(bds, ec2BDM)
@@ -689,12 +958,14 @@ func (i *instance) convertImageBlockDeviceMappings(BDMs []*ec2.BlockDeviceMappin
 				Iops:                BDM.Ebs.Iops,
 				SnapshotId:          BDM.Ebs.SnapshotId,
 				VolumeSize:          BDM.Ebs.VolumeSize,
-				VolumeType:          convertImageEBSVolumeType(BDM.Ebs, i.asg),
+				VolumeType:          BDM.Ebs.VolumeType,
+				Throughput:          BDM.Ebs.Throughput,
 			}
+			i.asg.convertEBSVolume(ec2BDM.Ebs, i.availabilityZone())
 		}
 
 		// handle the noDevice field directly by skipping the device if set to true, apparently NoDevice is here a string instead of a bool.
-		if BDM.NoDevice != nil && *BDM.NoDevice == "true" {
+		if aws.StringValue(BDM.NoDevice) == "true" {
 			continue
 		}
 		bds = append(bds, ec2BDM)
@@ -706,76 +977,6 @@ func (i *instance) convertImageBlockDeviceMappings(BDMs []*ec2.BlockDeviceMappin
 	return bds
 }
 
-func convertLaunchConfigurationEBSVolumeType(ebs *autoscaling.Ebs, a *autoScalingGroup) *string {
-	// convert IO1 to IO2 in supported regions
-	r := a.region.name
-	asg := a.name
-
-	if ebs.VolumeType == nil {
-		log.Println(r, ": Empty EBS VolumeType while converting LC volume for ASG", asg)
-		return nil
-	}
-
-	if *ebs.VolumeType == "io1" && supportedIO2region(r) {
-		log.Println(r, ": Converting IO1 volume to IO2 for new instance launched for", asg)
-		return aws.String("io2")
-	}
-
-	// convert GP2 to GP3 below the threshold where GP2 becomes more performant. The Threshold is configurable
-	if *ebs.VolumeType == "gp2" && *ebs.VolumeSize <= a.config.GP2ConversionThreshold {
-		log.Println(r, ": Converting GP2 EBS volume to GP3 for new instance launched for", asg)
-		return aws.String("gp3")
-	}
-	log.Println(r, ": No EBS volume conversion could be done for", asg)
-	return ebs.VolumeType
-}
-
-func convertLaunchTemplateEBSVolumeType(ebs *ec2.LaunchTemplateEbsBlockDevice, a *autoScalingGroup) *string {
-	// convert IO1 to IO2 in supported regions
-	r := a.region.name
-	asg := a.name
-	if *ebs.VolumeType == "io1" && supportedIO2region(r) {
-		log.Println(r, ": Converting IO1 volume to IO2 for new instance launched for", asg)
-		return aws.String("io2")
-	}
-
-	// convert GP2 to GP3 below the threshold where GP2 becomes more performant. The Threshold is configurable
-	if *ebs.VolumeType == "gp2" && *ebs.VolumeSize <= a.config.GP2ConversionThreshold {
-		log.Println(r, ": Converting GP2 EBS volume to GP3 for new instance launched for", asg)
-		return aws.String("gp3")
-	}
-	log.Println(r, ": No EBS volume conversion could be done for", asg)
-	return ebs.VolumeType
-}
-
-func convertImageEBSVolumeType(ebs *ec2.EbsBlockDevice, a *autoScalingGroup) *string {
-	// convert IO1 to IO2 in supported regions
-	r := a.region.name
-	asg := a.name
-	if *ebs.VolumeType == "io1" && supportedIO2region(r) {
-		log.Println(r, ": Converting IO1 volume to IO2 for new instance launched for", asg)
-		return aws.String("io2")
-	}
-
-	// convert GP2 to GP3 below the threshold where GP2 becomes more performant. The Threshold is configurable
-	if *ebs.VolumeType == "gp2" && *ebs.VolumeSize <= a.config.GP2ConversionThreshold {
-		log.Println(r, ": Converting GP2 EBS volume to GP3 for new instance launched for", asg)
-		return aws.String("gp3")
-	}
-	log.Println(r, ": No EBS volume conversion could be done for", asg)
-	return ebs.VolumeType
-}
-
-func supportedIO2region(region string) bool {
-	for _, r := range unsupportedIO2Regions {
-		if region == r {
-			log.Println("IO2 EBS volumes are not available in", region)
-			return false
-		}
-	}
-	return true
-}
-
 func (i *instance) convertSecurityGroups() []*string {
 	groupIDs := []*string{}
 	for _, sg := range i.SecurityGroups {
@@ -784,28 +985,9 @@ func (i *instance) convertSecurityGroups() []*string {
 	return groupIDs
 }
 
-func (i *instance) getlaunchTemplate(id, ver *string) (*ec2.ResponseLaunchTemplateData, error) {
-	res, err := i.region.services.ec2.DescribeLaunchTemplateVersions(
-		&ec2.DescribeLaunchTemplateVersionsInput{
-			Versions:         []*string{ver},
-			LaunchTemplateId: id,
-		},
-	)
-
-	if err != nil {
-		log.Println("Failed to describe launch template", *id, "version", *ver,
-			"encountered error:", err.Error())
-		return nil, err
-	}
-	if len(res.LaunchTemplateVersions) == 1 {
-		return res.LaunchTemplateVersions[0].LaunchTemplateData, nil
-	}
-	return nil, fmt.Errorf("missing launch template version information")
-}
-
 func (i *instance) launchTemplateHasNetworkInterfaces(ltData *ec2.ResponseLaunchTemplateData) (bool, []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification) {
 	if ltData == nil {
-		log.Println("Missing launch template data for ", *i.InstanceId)
+		log.Println("Missing launch template data for ", aws.StringValue(i.InstanceId))
 		return false, nil
 	}
 
@@ -820,18 +1002,34 @@ func (i *instance) processLaunchTemplate(retval *ec2.RunInstancesInput) error {
 	ver := i.asg.LaunchTemplate.Version
 	id := i.asg.LaunchTemplate.LaunchTemplateId
 
+	ltv, err := i.region.describeLaunchTemplateVersion(id, ver)
+	if err != nil {
+		return err
+	}
+
 	retval.LaunchTemplate = &ec2.LaunchTemplateSpecification{
 		LaunchTemplateId: id,
-		Version:          ver,
+		Version:          launchTemplateVersionNumber(ltv, ver),
 	}
 
-	ltData, err := i.getlaunchTemplate(id, ver)
-	if err != nil {
-		return err
+	ltData := ltv.LaunchTemplateData
+	if ltData == nil {
+		return fmt.Errorf("%w version information", ErrMissingLaunchTemplate)
 	}
 
 	retval.BlockDeviceMappings = i.convertLaunchTemplateBlockDeviceMappings(ltData.BlockDeviceMappings)
 
+	i.processLaunchTemplatePlacement(retval, ltData)
+	i.mergeLaunchTemplateMarketOptions(retval.InstanceMarketOptions, ltData.InstanceMarketOptions)
+
+	// the AMI aliases are resolved explicitly, so that the spot instances use
+	// the same AMI as the one their block device mappings were computed for
+	if strings.HasPrefix(aws.StringValue(ltData.ImageId), ssmImageAliasPrefix) {
+		if retval.ImageId, err = i.region.resolveImageID(ltData.ImageId); err != nil {
+			return err
+		}
+	}
+
 	if having, nis := i.launchTemplateHasNetworkInterfaces(ltData); having {
 		for _, ni := range nis {
 			retval.NetworkInterfaces = append(retval.NetworkInterfaces,
@@ -851,7 +1049,7 @@ func (i *instance) processLaunchTemplate(retval *ec2.RunInstancesInput) error {
 func (i *instance) processLaunchConfiguration(retval *ec2.RunInstancesInput) {
 	lc := i.asg.launchConfiguration
 
-	if lc.KeyName != nil && *lc.KeyName != "" {
+	if aws.StringValue(lc.KeyName) != "" {
 		retval.KeyName = lc.KeyName
 	}
 
@@ -899,7 +1097,11 @@ func (i *instance) processLaunchConfiguration(retval *ec2.RunInstancesInput) {
 	}
 }
 
-func (i *instance) processImageBlockDevices(rii *ec2.RunInstancesInput) {
+// processImageBlockDevices sets the block device mappings of the AMI, and
+// returns the mapping of its root device, which is kept for sizing the root
+// volume even when the mappings are replaced by the launch template or
+// configuration.
+func (i *instance) processImageBlockDevices(rii *ec2.RunInstancesInput) *ec2.BlockDeviceMapping {
 	svc := i.region.services.ec2
 
 	resp, err := svc.DescribeImages(
@@ -909,17 +1111,29 @@ func (i *instance) processImageBlockDevices(rii *ec2.RunInstancesInput) {
 
 	if err != nil {
 		log.Println(err.Error())
-		return
+		return nil
 	}
 	if len(resp.Images) == 0 {
 		log.Println("missing image data")
-		return
+		return nil
 	}
 
-	rii.BlockDeviceMappings = i.convertImageBlockDeviceMappings(resp.Images[0].BlockDeviceMappings)
+	image := resp.Images[0]
+	rii.BlockDeviceMappings = i.convertImageBlockDeviceMappings(image.BlockDeviceMappings)
+
+	for _, bdm := range rii.BlockDeviceMappings {
+		if aws.StringValue(bdm.DeviceName) == aws.StringValue(image.RootDeviceName) {
+			return bdm
+		}
+	}
+	return nil
 }
 
 func (i *instance) createRunInstancesInput(instanceType string, price float64) (*ec2.RunInstancesInput, error) {
+	if err := i.loadDetails(); err != nil {
+		return nil, err
+	}
+
 	// information we must (or can safely) copy/convert from the currently running
 	// on-demand instance or we had to compute in order to place the spot bid
 	retval := ec2.RunInstancesInput{
@@ -937,7 +1151,7 @@ func (i *instance) createRunInstancesInput(instanceType string, price float64) (
 		MaxCount:     aws.Int64(1),
 		MinCount:     aws.Int64(1),
 
-		Placement: i.Placement,
+		Placement: i.spotPlacement(),
 
 		SecurityGroupIds: i.convertSecurityGroups(),
 
@@ -945,7 +1159,9 @@ func (i *instance) createRunInstancesInput(instanceType string, price float64) (
 		TagSpecifications: i.generateTagsList(),
 	}
 
-	i.processImageBlockDevices(&retval)
+	i.asg.setInterruptionBehavior(retval.InstanceMarketOptions.SpotOptions)
+
+	imageRoot := i.processImageBlockDevices(&retval)
 
 	//populate the rest of the retval fields from launch Template and launch Configuration
 	if i.asg.LaunchTemplate != nil {
@@ -958,89 +1174,215 @@ func (i *instance) createRunInstancesInput(instanceType string, price float64) (
 	if i.asg.launchConfiguration != nil {
 		i.processLaunchConfiguration(&retval)
 	}
+
+	i.resizeRootVolume(&retval, imageRoot)
 	return &retval, nil
 }
 
+// maxTagsPerResource is the maximum number of tags of an EC2 resource.
+const maxTagsPerResource = 50
+
+// launchTaggedResourceTypes are the resources created together with the spot
+// instances, which are tagged at launch like the instances.
+var launchTaggedResourceTypes = []string{
+	ec2.ResourceTypeVolume,
+	ec2.ResourceTypeNetworkInterface,
+	ec2.ResourceTypeSpotInstancesRequest,
+}
+
 func (i *instance) generateTagsList() []*ec2.TagSpecification {
 	tags := ec2.TagSpecification{
 		ResourceType: aws.String("instance"),
 		Tags: []*ec2.Tag{
 			{
-				Key:   aws.String("launched-by-autospotting"),
+				Key:   aws.String(i.tagKey(launchedByAutoSpottingTag)),
 				Value: aws.String("true"),
 			},
 			{
-				Key:   aws.String("launched-for-asg"),
+				Key:   aws.String(i.tagKey(launchedForASGTag)),
 				Value: aws.String(i.asg.name),
 			},
 			{
-				Key:   aws.String("launched-for-replacing-instance"),
+				Key:   aws.String(i.tagKey(launchedForReplacingInstanceTag)),
 				Value: i.InstanceId,
 			},
+			{
+				Key:   aws.String(i.tagKey(tagSchemaVersionTag)),
+				Value: aws.String(currentTagSchemaVersion),
+			},
 		},
 	}
 
 	if i.asg.LaunchTemplate != nil {
 		tags.Tags = append(tags.Tags, &ec2.Tag{
-			Key:   aws.String("LaunchTemplateID"),
+			Key:   aws.String(i.tagKey(launchTemplateIDTag)),
 			Value: i.asg.LaunchTemplate.LaunchTemplateId,
 		})
 		tags.Tags = append(tags.Tags, &ec2.Tag{
-			Key:   aws.String("LaunchTemplateVersion"),
-			Value: i.asg.LaunchTemplate.Version,
+			Key:   aws.String(i.tagKey(launchTemplateVersionTag)),
+			Value: i.launchTemplateVersion(),
 		})
 	} else if i.asg.LaunchConfigurationName != nil {
 		tags.Tags = append(tags.Tags, &ec2.Tag{
-			Key:   aws.String("LaunchConfigurationName"),
+			Key:   aws.String(i.tagKey(launchConfigurationNameTag)),
 			Value: i.asg.LaunchConfigurationName,
 		})
 	}
 
-	for _, tag := range i.Tags {
-		if !strings.HasPrefix(*tag.Key, "aws:") &&
-			*tag.Key != "launched-by-autospotting" &&
-			*tag.Key != "launched-for-asg" &&
-			*tag.Key != "launched-for-replacing-instance" &&
-			*tag.Key != "LaunchTemplateID" &&
-			*tag.Key != "LaunchTemplateVersion" &&
-			*tag.Key != "LaunchConfiguationName" {
-			tags.Tags = append(tags.Tags, tag)
+	tags.Tags = append(tags.Tags, i.costAllocationTags()...)
+
+	seen := make(map[string]bool)
+	for _, tag := range tags.Tags {
+		seen[aws.StringValue(tag.Key)] = true
+	}
+
+	var prefix string
+	if i.region != nil && i.region.conf != nil {
+		prefix = i.region.conf.TagKeyPrefix
+	}
+
+	addTag := func(key, value *string) {
+		k := aws.StringValue(key)
+		if k == "" || isReservedTagKey(k, prefix) || seen[k] {
+			return
 		}
+		seen[k] = true
+		tags.Tags = append(tags.Tags, &ec2.Tag{Key: key, Value: value})
 	}
-	return []*ec2.TagSpecification{&tags}
-}
 
-func (i *instance) getReplacementTargetASGName() *string {
-	for _, tag := range i.Tags {
-		if *tag.Key == "launched-for-asg" {
-			return tag.Value
+	// the Kubernetes autoscalers identify their nodes by these tags, so they're
+	// copied first, even when not propagated at launch, to never be dropped
+	if i.asg.kubernetesAutoscalerPolicy() == CoordinateKubernetesAutoscalerPolicy {
+		for _, tag := range i.asg.Tags {
+			if isKubernetesTagKey(aws.StringValue(tag.Key)) {
+				addTag(tag.Key, tag.Value)
+			}
+		}
+		for _, tag := range i.Tags {
+			if isKubernetesTagKey(aws.StringValue(tag.Key)) {
+				addTag(tag.Key, tag.Value)
+			}
+		}
+	}
+
+	// The group's tags take precedence over the ones copied from the original
+	// instance, which may be outdated if the group's tags were changed since
+	// the instance was launched.
+	for _, tag := range i.asg.Tags {
+		if aws.BoolValue(tag.PropagateAtLaunch) {
+			addTag(tag.Key, tag.Value)
 		}
 	}
-	return nil
-}
 
-func (i *instance) getReplacementTargetInstanceID() *string {
 	for _, tag := range i.Tags {
-		if *tag.Key == "launched-for-replacing-instance" {
-			return tag.Value
+		addTag(tag.Key, tag.Value)
+	}
+
+	// the configured tags are only defaults, applied unless already set
+	if i.region != nil && i.region.conf != nil {
+		for _, tag := range parseTags(i.region.conf.SpotInstanceTags) {
+			addTag(aws.String(tag.Key), aws.String(tag.Value))
 		}
 	}
-	return nil
+
+	// the tags set by AutoSpotting come first, so only the copied tags are
+	// dropped when exceeding the limit
+	if len(tags.Tags) > maxTagsPerResource {
+		var dropped []string
+		for _, tag := range tags.Tags[maxTagsPerResource:] {
+			dropped = append(dropped, aws.StringValue(tag.Key))
+		}
+		log.Printf("%s Warning: the replacement of %s would have %d tags, more than the limit of %d, "+
+			"not copying the tags %s", i.asg.name, aws.StringValue(i.InstanceId), len(tags.Tags),
+			maxTagsPerResource, strings.Join(dropped, ", "))
+		tags.Tags = tags.Tags[:maxTagsPerResource]
+	}
+
+	// the other resources created at launch get the same tags as the instance,
+	// in order to comply with the tagging policies and with the IAM conditions
+	// on the tags of the created resources
+	specs := []*ec2.TagSpecification{&tags}
+	for _, resourceType := range launchTaggedResourceTypes {
+		specs = append(specs, &ec2.TagSpecification{
+			ResourceType: aws.String(resourceType),
+			Tags:         append([]*ec2.Tag(nil), tags.Tags...),
+		})
+	}
+	return specs
 }
 
-func (i *instance) isLaunchedByAutoSpotting() bool {
-	for _, tag := range i.Tags {
-		if *tag.Key == "launched-by-autospotting" {
-			return true
+// costAllocationTags returns the tags used for attributing the costs of the
+// spot instances to AutoSpotting and to the instances they replaced.
+func (i *instance) costAllocationTags() []*ec2.Tag {
+	var tags []*ec2.Tag
+
+	if i.region != nil && i.region.conf != nil && i.region.conf.Version != "" {
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String(i.tagKey(autoSpottingVersionTag)),
+			Value: aws.String(i.region.conf.Version),
+		})
+	}
+
+	if i.InstanceType != nil {
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String(i.tagKey(originalInstanceTypeTag)),
+			Value: i.InstanceType,
+		})
+	}
+	return tags
+}
+
+// isReservedTagKey tells whether a tag is set by AWS or AutoSpotting, and
+// therefore shouldn't be copied to the launched spot instances. The tags set
+// by AutoSpotting are recognized with and without the tag key prefix, as well
+// as under their legacy keys.
+func isReservedTagKey(key, prefix string) bool {
+	if prefix != "" {
+		key = strings.TrimPrefix(key, prefix)
+	}
+	switch canonicalTagKey(key) {
+	case launchedByAutoSpottingTag,
+		launchedForASGTag,
+		launchedForReplacingInstanceTag,
+		autoSpottingVersionTag,
+		originalInstanceTypeTag,
+		tagSchemaVersionTag,
+		launchTemplateIDTag,
+		launchTemplateVersionTag,
+		launchConfigurationNameTag,
+		adoptedByAutoSpottingTag:
+		return true
+	}
+	return strings.HasPrefix(key, "aws:")
+}
+
+// parseTags parses a comma or whitespace separated list of key=value pairs.
+func parseTags(tags string) []Tag {
+	var result []Tag
+	for _, t := range strings.Split(replaceWhitespace(tags), ",") {
+		if tag := splitTagAndValue(t); tag != nil && tag.Key != "" {
+			result = append(result, *tag)
 		}
 	}
-	return false
+	return result
+}
+
+func (i *instance) getReplacementTargetASGName() *string {
+	return i.tagValue(launchedForASGTag)
+}
+
+func (i *instance) getReplacementTargetInstanceID() *string {
+	return i.tagValue(launchedForReplacingInstanceTag)
+}
+
+func (i *instance) isLaunchedByAutoSpotting() bool {
+	return i.tagValue(launchedByAutoSpottingTag) != nil
 }
 
 func (i *instance) isUnattachedSpotInstanceLaunchedForAnEnabledASG() bool {
 	asgName := i.getReplacementTargetASGName()
 	if asgName == nil {
-		log.Printf("%s is missing the tag value for 'launched-for-asg'", *i.InstanceId)
+		log.Printf("%s is missing the tag value for '%s'", aws.StringValue(i.InstanceId), i.tagKey(launchedForASGTag))
 		return false
 	}
 	asg := i.region.findEnabledASGByName(*asgName)
@@ -1048,100 +1390,231 @@ func (i *instance) isUnattachedSpotInstanceLaunchedForAnEnabledASG() bool {
 	if asg != nil &&
 		!asg.hasMemberInstance(i) &&
 		i.isSpot() {
-		log.Println("Found unattached spot instance", *i.InstanceId)
+		log.Println("Found unattached spot instance", aws.StringValue(i.InstanceId))
 		return true
 	}
 	return false
 }
 
-func (i *instance) swapWithGroupMember(asg *autoScalingGroup) (*instance, error) {
+// replacementTarget returns the on-demand instance the spot instance was
+// launched to replace, terminating the spot instance if its target shouldn't
+// be replaced anymore.
+func (i *instance) replacementTarget(asg *autoScalingGroup) (*instance, error) {
 	odInstanceID := i.getReplacementTargetInstanceID()
 	if odInstanceID == nil {
-		log.Println("Couldn't find target on-demand instance of", *i.InstanceId)
-		return nil, fmt.Errorf("couldn't find target instance for %s", *i.InstanceId)
+		log.Println("Couldn't find target on-demand instance of", aws.StringValue(i.InstanceId))
+		return nil, fmt.Errorf("couldn't find target instance for %s: %w", aws.StringValue(i.InstanceId), ErrInstanceNotFound)
 	}
 
 	if err := i.region.scanInstance(odInstanceID); err != nil {
 		log.Printf("Couldn't describe the target on-demand instance %s", *odInstanceID)
-		return nil, fmt.Errorf("target instance %s couldn't be described", *odInstanceID)
+		return nil, fmt.Errorf("target instance %s couldn't be described: %w", *odInstanceID, err)
 	}
 
 	odInstance := i.region.instances.get(*odInstanceID)
 	if odInstance == nil {
 		log.Printf("Target on-demand instance %s couldn't be found", *odInstanceID)
-		return nil, fmt.Errorf("target instance %s is missing", *odInstanceID)
+		return nil, fmt.Errorf("target instance %s is missing: %w", *odInstanceID, ErrInstanceNotFound)
 	}
 
 	if !odInstance.shouldBeReplacedWithSpot() {
 		log.Printf("Target on-demand instance %s shouldn't be replaced", *odInstanceID)
-		i.terminate()
-		return nil, fmt.Errorf("target instance %s should not be replaced with spot",
-			*odInstanceID)
+		if !asg.isActionDisabled(TerminateSpotAction) {
+			i.region.annotateTermination(aws.StringValue(i.InstanceId), asg.name, failedSwapTermination, "")
+			i.terminate()
+		}
+		return nil, fmt.Errorf("target instance %s: %w", *odInstanceID, ErrProtectedInstance)
+	}
+	return odInstance, nil
+}
+
+func (i *instance) swapWithGroupMember(asg *autoScalingGroup) (*instance, error) {
+	odInstance, err := i.replacementTarget(asg)
+	if err != nil {
+		return nil, err
+	}
+	odInstanceID := odInstance.InstanceId
+
+	// the spot instance is left running, so that the swap can be resumed once
+	// the actions are enabled again
+	for _, action := range []string{AttachSpotAction, TerminateOnDemandAction} {
+		if asg.isActionDisabled(action) {
+			return nil, asg.actionDisabledError(action)
+		}
 	}
 
+	if allowed, reason := asg.eksDisruptionAllowed(odInstanceID); !allowed {
+		log.Printf("Not replacing on-demand instance %s from the group %s yet: %s",
+			*odInstanceID, asg.name, reason)
+		return nil, fmt.Errorf("replacing %s would exceed the EKS nodegroup %w",
+			*odInstanceID, ErrDisruptionBudgetExceeded)
+	}
+
+	if action, near := asg.nearScheduledAction(); near {
+		return nil, asg.scheduledActionError(action)
+	}
+
+	i.migrateLegacyTags()
+
+	release := i.region.conf.swapLimiter.acquire(asg.name)
+	defer release()
+
+	// deferred before resuming the processes, so that it runs after them
+	defer asg.correlateScalingActivities(i.region.conf.getClock().Now(),
+		map[string]string{*odInstanceID: aws.StringValue(i.InstanceId)})
+
 	asg.suspendProcesses()
 	defer asg.resumeProcesses()
 
-	desiredCapacity, maxSize := *asg.DesiredCapacity, *asg.MaxSize
+	desiredCapacity, maxSize := aws.Int64Value(asg.DesiredCapacity), aws.Int64Value(asg.MaxSize)
+
+	// the desired capacity may have changed since the group was loaded
+	if current, err := asg.currentDesiredCapacity(); err == nil {
+		desiredCapacity = current
+	}
+
+	// the on-demand instance is taken out of the group first when configured,
+	// or when the MaxSize can't be increased for a group running at capacity
+	if asg.config.SwapStrategy == TerminateFirstSwapStrategy ||
+		(desiredCapacity == maxSize && asg.isActionDisabled(ModifyMaxSizeAction)) {
+		if err := asg.swapWithoutMaxSizeChange(i, odInstance); err != nil {
+			return nil, err
+		}
+		return odInstance, nil
+	}
 
 	// temporarily increase AutoScaling group in case the desired capacity reaches the max size,
 	// otherwise attachSpotInstance might fail
 	if desiredCapacity == maxSize {
 		log.Println(asg.name, "Temporarily increasing MaxSize")
-		asg.setAutoScalingMaxSize(maxSize + 1)
-		defer asg.setAutoScalingMaxSize(maxSize)
+		if err := asg.increaseMaxSize(maxSize, 1); err != nil {
+			return nil, err
+		}
+		defer asg.restoreMaxSize(maxSize)
 	}
 
 	log.Printf("Attaching spot instance %s to the group %s",
-		*i.InstanceId, asg.name)
-	err := asg.attachSpotInstance(*i.InstanceId, true)
+		aws.StringValue(i.InstanceId), asg.name)
+	err = asg.attachSpotInstance(aws.StringValue(i.InstanceId), true)
 
 	if err != nil {
 		log.Printf("Spot instance %s couldn't be attached to the group %s, terminating it...",
-			*i.InstanceId, asg.name)
-		i.terminate()
-		return nil, fmt.Errorf("couldn't attach spot instance %s ", *i.InstanceId)
+			aws.StringValue(i.InstanceId), asg.name)
+		if !asg.isActionDisabled(TerminateSpotAction) {
+			i.region.annotateTermination(aws.StringValue(i.InstanceId), asg.name, failedSwapTermination, "")
+			i.terminate()
+		}
+		return nil, fmt.Errorf("couldn't attach spot instance %s: %w", aws.StringValue(i.InstanceId), err)
+	}
+
+	if err := i.copyTerminationProtection(odInstance); err != nil {
+		return nil, fmt.Errorf("couldn't copy termination protection from on-demand instance %s: %w",
+			*odInstanceID, err)
+	}
+
+	if err := i.copyScaleInProtection(odInstance); err != nil {
+		return nil, fmt.Errorf("couldn't copy scale-in protection from on-demand instance %s: %w",
+			*odInstanceID, err)
+	}
+
+	// both instances keep running until the spot instance is healthy, the
+	// on-demand instance is otherwise left running and replaced later, while
+	// the unhealthy spot instance is removed from the group so that the
+	// MaxSize can be restored
+	if asg.config.SwapStrategy == OverlapSwapStrategy {
+		if err := asg.waitForHealthyInstance(i.InstanceId); err != nil {
+			log.Printf("Keeping on-demand instance %s in the group %s: %s", *odInstanceID, asg.name, err.Error())
+			if removeErr := asg.removeUnhealthySpotInstance(i); removeErr != nil {
+				log.Println(asg.name, removeErr.Error())
+			}
+			return nil, err
+		}
+	}
+
+	if err := asg.registerInServiceDiscovery(i, *odInstanceID); err != nil {
+		log.Printf("Keeping on-demand instance %s in the group %s", *odInstanceID, asg.name)
+		return nil, fmt.Errorf("couldn't register spot instance %s to Cloud Map: %w",
+			aws.StringValue(i.InstanceId), err)
+	}
+
+	if err := asg.snapshotReplacedInstance(*odInstanceID, aws.StringValue(i.InstanceId)); err != nil {
+		log.Printf("Keeping on-demand instance %s in the group %s", *odInstanceID, asg.name)
+		return nil, err
 	}
 
 	log.Printf("Terminating on-demand instance %s from the group %s",
 		*odInstanceID, asg.name)
-	if err := asg.terminateInstanceInAutoScalingGroup(odInstanceID, true, true); err != nil {
+	if err := asg.terminateReplacedOnDemandInstance(odInstanceID, aws.StringValue(i.InstanceId), desiredCapacity+1); err != nil {
+		if errors.Is(err, ErrScalingActivity) || errors.Is(err, ErrLifecycleHookTimeout) {
+			return nil, err
+		}
 		log.Printf("On-demand instance %s couldn't be terminated, re-trying...",
 			*odInstanceID)
-		return nil, fmt.Errorf("couldn't terminate on-demand instance %s",
-			*odInstanceID)
+		return nil, fmt.Errorf("couldn't terminate on-demand instance %s: %w",
+			*odInstanceID, err)
 	}
 
 	return odInstance, nil
 }
 
+// copyTerminationProtection enables the termination protection of the spot
+// instance and disables it on the on-demand instance it replaces, so that the
+// latter can be terminated.
+func (i *instance) copyTerminationProtection(odInstance *instance) error {
+	if odInstance.asg == nil || !odInstance.asg.config.CopyTerminationProtection {
+		return nil
+	}
+
+	if protT, _ := odInstance.isProtectedFromTermination(); !protT {
+		return nil
+	}
+
+	log.Printf("Copying termination protection from on-demand instance %s to spot instance %s",
+		aws.StringValue(odInstance.InstanceId), aws.StringValue(i.InstanceId))
+
+	if err := i.setTerminationProtection(true); err != nil {
+		return err
+	}
+	return odInstance.setTerminationProtection(false)
+}
+
 // returns an instance ID as *string, set to nil if we need to wait for the next
 // run in case there are no spot instances
 func (i *instance) isReadyToAttach(asg *autoScalingGroup) bool {
 
-	log.Println("Considering ", *i.InstanceId, "for attaching to", asg.name)
+	log.Println("Considering ", aws.StringValue(i.InstanceId), "for attaching to", asg.name)
 
-	gracePeriod := *asg.HealthCheckGracePeriod
+	gracePeriod := asg.readinessGracePeriod()
 
-	instanceUpTime := time.Now().Unix() - i.LaunchTime.Unix()
+	// instances with unknown launch time are considered as just launched
+	var instanceUpTime int64
+	if i.LaunchTime != nil {
+		instanceUpTime = asg.region.conf.getClock().Now().Unix() - i.LaunchTime.Unix()
+	}
 
 	log.Println("Instance uptime:", time.Duration(instanceUpTime)*time.Second)
 
+	// the active readiness checks replace the grace period when configured
+	if checks := asg.activeReadinessChecks(); len(checks) > 0 &&
+		i.stateName() == ec2.InstanceStateNameRunning {
+		return i.passesReadinessChecks(checks)
+	}
+
 	// Check if the spot instance is out of the grace period, so in that case we
 	// can replace an on-demand instance with it
-	if *i.State.Name == ec2.InstanceStateNameRunning &&
+	if i.stateName() == ec2.InstanceStateNameRunning &&
 		instanceUpTime > gracePeriod {
-		log.Println("The spot instance", *i.InstanceId,
+		log.Println("The spot instance", aws.StringValue(i.InstanceId),
 			" has passed grace period and is ready to attach to the group.")
 		return true
-	} else if *i.State.Name == ec2.InstanceStateNameRunning &&
+	} else if i.stateName() == ec2.InstanceStateNameRunning &&
 		instanceUpTime < gracePeriod {
-		log.Println("The spot instance", *i.InstanceId,
+		log.Println("The spot instance", aws.StringValue(i.InstanceId),
 			"is still in the grace period,",
 			"waiting for it to be ready before we can attach it to the group...")
 		return false
-	} else if *i.State.Name == ec2.InstanceStateNamePending {
-		log.Println("The spot instance", *i.InstanceId,
+	} else if i.stateName() == ec2.InstanceStateNamePending {
+		log.Println("The spot instance", aws.StringValue(i.InstanceId),
 			"is still pending,",
 			"waiting for it to be running before we can attach it to the group...")
 		return false
@@ -0,0 +1,69 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// slimInstance copies only the fields used when evaluating the instances of
+// the region, leaving out the likes of the network interfaces and block
+// device mappings, which can take a lot of memory on large accounts. The rest
+// of the instance details are fetched when launching a replacement.
+func slimInstance(inst *ec2.Instance) *ec2.Instance {
+	return &ec2.Instance{
+		EbsOptimized:          inst.EbsOptimized,
+		ImageId:               inst.ImageId,
+		InstanceId:            inst.InstanceId,
+		InstanceLifecycle:     inst.InstanceLifecycle,
+		InstanceType:          inst.InstanceType,
+		LaunchTime:            inst.LaunchTime,
+		Placement:             inst.Placement,
+		Platform:              inst.Platform,
+		SpotInstanceRequestId: inst.SpotInstanceRequestId,
+		State:                 inst.State,
+		Tags:                  inst.Tags,
+		VirtualizationType:    inst.VirtualizationType,
+	}
+}
+
+// loadDetails replaces the slimmed down instance data kept in the catalog with
+// the full instance details, before they are used for launching a replacement.
+func (i *instance) loadDetails() error {
+	if !i.slim {
+		return nil
+	}
+
+	var details *ec2.Instance
+
+	err := i.region.services.ec2.DescribeInstancesPages(
+		&ec2.DescribeInstancesInput{
+			InstanceIds: []*string{i.InstanceId},
+		},
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, res := range page.Reservations {
+				for _, inst := range res.Instances {
+					if aws.StringValue(inst.InstanceId) == aws.StringValue(i.InstanceId) {
+						details = inst
+						return false
+					}
+				}
+			}
+			return true
+		})
+
+	if err != nil {
+		return fmt.Errorf("failed to describe instance %s: %w", aws.StringValue(i.InstanceId), err)
+	}
+
+	if details == nil {
+		return fmt.Errorf("%s: %w", aws.StringValue(i.InstanceId), ErrInstanceNotFound)
+	}
+
+	i.Instance, i.slim = details, false
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_slimInstance(t *testing.T) {
+	full := &ec2.Instance{
+		InstanceId:         aws.String("i-1"),
+		InstanceType:       aws.String("m5.large"),
+		InstanceLifecycle:  aws.String(Spot),
+		ImageId:            aws.String("ami-1"),
+		EbsOptimized:       aws.Bool(true),
+		Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		Tags:               []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+		VirtualizationType: aws.String("hvm"),
+		SubnetId:           aws.String("subnet-1"),
+		SecurityGroups:     []*ec2.GroupIdentifier{{GroupId: aws.String("sg-1")}},
+		NetworkInterfaces:  []*ec2.InstanceNetworkInterface{{NetworkInterfaceId: aws.String("eni-1")}},
+		BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda")},
+		},
+	}
+
+	expected := &ec2.Instance{
+		InstanceId:         aws.String("i-1"),
+		InstanceType:       aws.String("m5.large"),
+		InstanceLifecycle:  aws.String(Spot),
+		ImageId:            aws.String("ami-1"),
+		EbsOptimized:       aws.Bool(true),
+		Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		Tags:               []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+		VirtualizationType: aws.String("hvm"),
+	}
+
+	if got := slimInstance(full); !reflect.DeepEqual(got, expected) {
+		t.Errorf("slimInstance() = %v, expected %v", got, expected)
+	}
+}
+
+func Test_instance_loadDetails(t *testing.T) {
+	details := &ec2.Instance{
+		InstanceId: aws.String("i-1"),
+		SubnetId:   aws.String("subnet-1"),
+	}
+
+	tests := []struct {
+		name        string
+		slim        bool
+		dio         *ec2.DescribeInstancesOutput
+		diperr      error
+		expected    *ec2.Instance
+		expectedErr error
+	}{
+		{
+			name:     "not slimmed down",
+			expected: &ec2.Instance{InstanceId: aws.String("i-1")},
+		},
+		{
+			name: "details loaded",
+			slim: true,
+			dio: &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{details}}},
+			},
+			expected: details,
+		},
+		{
+			name:        "instance not found",
+			slim:        true,
+			dio:         &ec2.DescribeInstancesOutput{},
+			expected:    &ec2.Instance{InstanceId: aws.String("i-1")},
+			expectedErr: ErrInstanceNotFound,
+		},
+		{
+			name:        "describe error",
+			slim:        true,
+			dio:         &ec2.DescribeInstancesOutput{},
+			diperr:      errors.New("throttled"),
+			expected:    &ec2.Instance{InstanceId: aws.String("i-1")},
+			expectedErr: errors.New("throttled"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-1")},
+				slim:     tt.slim,
+				region: &region{
+					services: connections{
+						ec2: mockEC2{dio: tt.dio, diperr: tt.diperr},
+					},
+				},
+			}
+
+			err := i.loadDetails()
+			if (err != nil) != (tt.expectedErr != nil) {
+				t.Fatalf("loadDetails() error = %v, expected %v", err, tt.expectedErr)
+			}
+			if errors.Is(tt.expectedErr, ErrInstanceNotFound) && !errors.Is(err, ErrInstanceNotFound) {
+				t.Errorf("loadDetails() error = %v, expected %v", err, tt.expectedErr)
+			}
+			if !reflect.DeepEqual(i.Instance, tt.expected) {
+				t.Errorf("loadDetails() instance = %v, expected %v", i.Instance, tt.expected)
+			}
+			if i.slim != (tt.slim && err != nil) {
+				t.Errorf("loadDetails() slim = %v", i.slim)
+			}
+		})
+	}
+}
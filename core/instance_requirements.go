@@ -0,0 +1,101 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"strconv"
+)
+
+const (
+	// MinVCPUTag is the name of the tag set on the AutoScaling Group that
+	// defines the minimum number of vCPUs of the spot instance types
+	MinVCPUTag = "autospotting_min_vcpu"
+
+	// MinMemoryTag is the name of the tag set on the AutoScaling Group that
+	// defines the minimum memory of the spot instance types, in GiB
+	MinMemoryTag = "autospotting_min_memory_gib"
+
+	// MinAcceleratorCountTag is the name of the tag set on the AutoScaling
+	// Group that defines the minimum number of GPUs of the spot instance types
+	MinAcceleratorCountTag = "autospotting_min_accelerator_count"
+
+	// MinInstanceStorageTag is the name of the tag set on the AutoScaling Group
+	// that defines the minimum total instance store capacity of the spot
+	// instance types, in GB
+	MinInstanceStorageTag = "autospotting_min_instance_storage_gb"
+)
+
+// instanceRequirements describes the minimum attributes of the spot instance
+// types launched for a group. When set, they replace the comparison of the
+// candidate instance types with the on-demand instance being replaced.
+type instanceRequirements struct {
+	vCPU            int
+	memory          float32
+	accelerators    int
+	instanceStorage float32
+}
+
+func (r instanceRequirements) enabled() bool {
+	return r != instanceRequirements{}
+}
+
+// isSatisfiedBy tells whether the instance type meets all the requirements.
+func (r instanceRequirements) isSatisfiedBy(candidate instanceTypeInformation) bool {
+	storage := candidate.instanceStoreDeviceSize * float32(candidate.instanceStoreDeviceCount)
+
+	if candidate.vCPU >= r.vCPU &&
+		candidate.memory >= r.memory &&
+		candidate.GPU >= r.accelerators &&
+		storage >= r.instanceStorage {
+		return true
+	}
+
+	debug.Println("\tInstance type", candidate.instanceType, "doesn't meet the requirements", r)
+	return false
+}
+
+func (a *autoScalingGroup) loadInstanceRequirements() {
+	a.instanceRequirements = instanceRequirements{
+		vCPU:            int(a.getRequirementFromTag(MinVCPUTag)),
+		memory:          float32(a.getRequirementFromTag(MinMemoryTag)),
+		accelerators:    int(a.getRequirementFromTag(MinAcceleratorCountTag)),
+		instanceStorage: float32(a.getRequirementFromTag(MinInstanceStorageTag)),
+	}
+
+	if a.instanceRequirements.enabled() {
+		log.Printf("Loaded instance requirements %+v for the group %s\n", a.instanceRequirements, a.name)
+	}
+}
+
+// getRequirementFromTag parses the value of a requirement tag, ignoring the
+// missing, invalid and negative values.
+func (a *autoScalingGroup) getRequirementFromTag(tag string) float64 {
+	tagValue := a.getOwnTagValue(tag)
+	if tagValue == nil {
+		return 0
+	}
+
+	value, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || value < 0 {
+		log.Printf("Ignoring invalid value %v of the tag %v on the group %s\n", *tagValue, tag, a.name)
+		return 0
+	}
+	return value
+}
+
+// meetsRequirements checks the compatibility of the candidate instance type
+// with the group's instance requirements if they're set, and otherwise with
+// the replaced instance. The CPU architecture and the instance store volumes
+// used by the launch configuration or template always need to match.
+func (i *instance) meetsRequirements(candidate instanceTypeInformation, attachedVolumes int) bool {
+	if i.asg == nil || !i.asg.instanceRequirements.enabled() {
+		return i.isClassCompatible(candidate) &&
+			i.isStorageCompatible(candidate, attachedVolumes)
+	}
+
+	return i.isSameArch(candidate) &&
+		candidate.instanceStoreDeviceCount >= attachedVolumes &&
+		i.asg.instanceRequirements.isSatisfiedBy(candidate)
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestLoadInstanceRequirements(t *testing.T) {
+	tests := []struct {
+		name     string
+		asgTags  []*autoscaling.TagDescription
+		expected instanceRequirements
+	}{
+		{
+			name:     "no tags",
+			asgTags:  []*autoscaling.TagDescription{},
+			expected: instanceRequirements{},
+		},
+		{
+			name: "all requirements",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(MinVCPUTag), Value: aws.String("4")},
+				{Key: aws.String(MinMemoryTag), Value: aws.String("15.5")},
+				{Key: aws.String(MinAcceleratorCountTag), Value: aws.String("1")},
+				{Key: aws.String(MinInstanceStorageTag), Value: aws.String("100")},
+			},
+			expected: instanceRequirements{vCPU: 4, memory: 15.5, accelerators: 1, instanceStorage: 100},
+		},
+		{
+			name: "invalid values ignored",
+			asgTags: []*autoscaling.TagDescription{
+				{Key: aws.String(MinVCPUTag), Value: aws.String("four")},
+				{Key: aws.String(MinMemoryTag), Value: aws.String("-8")},
+				{Key: aws.String(MinAcceleratorCountTag), Value: aws.String("2")},
+			},
+			expected: instanceRequirements{accelerators: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.asgTags},
+			}
+			a.loadInstanceRequirements()
+			if a.instanceRequirements != tt.expected {
+				t.Errorf("loadInstanceRequirements() = %+v, expected %+v",
+					a.instanceRequirements, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_meetsRequirements(t *testing.T) {
+	current := instanceTypeInformation{
+		instanceType:      "m5.xlarge",
+		PhysicalProcessor: "Intel Xeon",
+		vCPU:              4,
+		memory:            16,
+	}
+
+	tests := []struct {
+		name            string
+		requirements    instanceRequirements
+		candidate       instanceTypeInformation
+		attachedVolumes int
+		want            bool
+	}{
+		{
+			name: "smaller than the current instance",
+			candidate: instanceTypeInformation{
+				instanceType:      "m5.large",
+				PhysicalProcessor: "Intel Xeon",
+				vCPU:              2,
+				memory:            8,
+			},
+			want: false,
+		},
+		{
+			name:         "smaller but meeting the requirements",
+			requirements: instanceRequirements{vCPU: 2, memory: 8},
+			candidate: instanceTypeInformation{
+				instanceType:      "m5.large",
+				PhysicalProcessor: "Intel Xeon",
+				vCPU:              2,
+				memory:            8,
+			},
+			want: true,
+		},
+		{
+			name:         "different architecture",
+			requirements: instanceRequirements{vCPU: 2},
+			candidate: instanceTypeInformation{
+				instanceType:      "m6g.large",
+				PhysicalProcessor: "AWS Graviton2 Processor",
+				vCPU:              2,
+				memory:            8,
+			},
+			want: false,
+		},
+		{
+			name:         "missing accelerators",
+			requirements: instanceRequirements{accelerators: 1},
+			candidate: instanceTypeInformation{
+				instanceType:      "m5.2xlarge",
+				PhysicalProcessor: "Intel Xeon",
+				vCPU:              8,
+				memory:            32,
+			},
+			want: false,
+		},
+		{
+			name:         "enough total instance storage",
+			requirements: instanceRequirements{instanceStorage: 100},
+			candidate: instanceTypeInformation{
+				instanceType:             "m5d.large",
+				PhysicalProcessor:        "Intel Xeon",
+				vCPU:                     2,
+				memory:                   8,
+				instanceStoreDeviceCount: 2,
+				instanceStoreDeviceSize:  75,
+			},
+			want: true,
+		},
+		{
+			name:            "not enough instance store volumes for the launch configuration",
+			requirements:    instanceRequirements{vCPU: 2},
+			attachedVolumes: 1,
+			candidate: instanceTypeInformation{
+				instanceType:      "m5.large",
+				PhysicalProcessor: "Intel Xeon",
+				vCPU:              2,
+				memory:            8,
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-foo")},
+				typeInfo: current,
+				asg:      &autoScalingGroup{instanceRequirements: tt.requirements},
+			}
+			if got := i.meetsRequirements(tt.candidate, tt.attachedVolumes); got != tt.want {
+				t.Errorf("meetsRequirements() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
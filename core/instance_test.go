@@ -6,11 +6,13 @@ package autospotting
 import (
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -163,6 +165,60 @@ func TestCount(t *testing.T) {
 	}
 }
 
+func TestInstances(t *testing.T) {
+	tests := []struct {
+		name     string
+		catalog  instanceMap
+		expected []string
+	}{
+		{name: "map is nil",
+			catalog:  nil,
+			expected: []string{},
+		},
+		{name: "map has several instances",
+			catalog: instanceMap{
+				"id-3": {Instance: &ec2.Instance{InstanceId: aws.String("id-3")}},
+				"id-1": {Instance: &ec2.Instance{InstanceId: aws.String("id-1")}},
+				"id-2": {Instance: &ec2.Instance{InstanceId: aws.String("id-2")}},
+			},
+			expected: []string{"id-1", "id-2", "id-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := &instanceManager{catalog: tt.catalog}
+
+			got := []string{}
+			for _, i := range is.instances() {
+				got = append(got, aws.StringValue(i.InstanceId))
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Value received: %v expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInstancesModifiedDuringIteration(t *testing.T) {
+	is := &instanceManager{catalog: instanceMap{
+		"id-1": {Instance: &ec2.Instance{InstanceId: aws.String("id-1")}},
+		"id-2": {Instance: &ec2.Instance{InstanceId: aws.String("id-2")}},
+	}}
+
+	// stopping early and modifying the catalog while iterating must neither
+	// block nor affect the current iteration
+	for _, i := range is.instances() {
+		is.add(&instance{Instance: &ec2.Instance{InstanceId: aws.String(aws.StringValue(i.InstanceId) + "-new")}})
+		break
+	}
+	is.make()
+
+	if is.count() != 0 {
+		t.Errorf("Value received: %d expected 0", is.count())
+	}
+}
+
 func TestCount64(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -304,6 +360,49 @@ func TestIsEBSCompatible(t *testing.T) {
 			},
 			expected: true,
 		},
+		{name: "Same burst throughput but lower baseline throughput",
+			spotInfo: instanceTypeInformation{
+				EBSThroughput:         593.75,
+				ebsBaselineThroughput: 81.25,
+				ebsBaselineIOPS:       3600,
+			},
+			instanceInfo: instance{
+				typeInfo: instanceTypeInformation{
+					EBSThroughput:         593.75,
+					ebsBaselineThroughput: 593.75,
+					ebsBaselineIOPS:       18750,
+				},
+			},
+			expected: false,
+		},
+		{name: "Enough baseline throughput but fewer baseline IOPS",
+			spotInfo: instanceTypeInformation{
+				ebsBaselineThroughput: 593.75,
+				ebsBaselineIOPS:       12000,
+			},
+			instanceInfo: instance{
+				typeInfo: instanceTypeInformation{
+					ebsBaselineThroughput: 593.75,
+					ebsBaselineIOPS:       18750,
+				},
+			},
+			expected: false,
+		},
+		{name: "Higher baseline throughput and IOPS despite lower burst throughput",
+			spotInfo: instanceTypeInformation{
+				EBSThroughput:         500,
+				ebsBaselineThroughput: 500,
+				ebsBaselineIOPS:       20000,
+			},
+			instanceInfo: instance{
+				typeInfo: instanceTypeInformation{
+					EBSThroughput:         593.75,
+					ebsBaselineThroughput: 81.25,
+					ebsBaselineIOPS:       3600,
+				},
+			},
+			expected: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -484,6 +583,7 @@ func TestIsStorageCompatible(t *testing.T) {
 		spotInfo        instanceTypeInformation
 		instanceInfo    instanceTypeInformation
 		attachedVolumes int
+		compatibility   string
 		expected        bool
 	}{
 		{name: "Instance has no attached volumes",
@@ -590,11 +690,100 @@ func TestIsStorageCompatible(t *testing.T) {
 			attachedVolumes: 1,
 			expected:        true,
 		},
+		{name: "More but smaller volumes with the same total capacity",
+			spotInfo: instanceTypeInformation{
+				instanceStoreDeviceCount: 2,
+				instanceStoreDeviceSize:  100.0,
+				instanceStoreIsSSD:       true,
+			},
+			instanceInfo: instanceTypeInformation{
+				instanceStoreDeviceSize: 200.0,
+				instanceStoreIsSSD:      true,
+			},
+			attachedVolumes: 1,
+			expected:        true,
+		},
+		{name: "More but smaller volumes with the same total capacity, strict compatibility",
+			spotInfo: instanceTypeInformation{
+				instanceStoreDeviceCount: 2,
+				instanceStoreDeviceSize:  100.0,
+				instanceStoreIsSSD:       true,
+			},
+			instanceInfo: instanceTypeInformation{
+				instanceStoreDeviceSize: 200.0,
+				instanceStoreIsSSD:      true,
+			},
+			attachedVolumes: 1,
+			compatibility:   StrictInstanceStoreCompatibility,
+			expected:        false,
+		},
+		{name: "NVMe instance replaced by SATA SSD",
+			spotInfo: instanceTypeInformation{
+				instanceStoreDeviceCount: 1,
+				instanceStoreDeviceSize:  200.0,
+				instanceStoreIsSSD:       true,
+			},
+			instanceInfo: instanceTypeInformation{
+				instanceStoreDeviceSize: 200.0,
+				instanceStoreIsSSD:      true,
+				instanceStoreIsNVMe:     true,
+			},
+			attachedVolumes: 1,
+			expected:        false,
+		},
+		{name: "SATA SSD instance replaced by NVMe",
+			spotInfo: instanceTypeInformation{
+				instanceStoreDeviceCount: 1,
+				instanceStoreDeviceSize:  200.0,
+				instanceStoreIsSSD:       true,
+				instanceStoreIsNVMe:      true,
+			},
+			instanceInfo: instanceTypeInformation{
+				instanceStoreDeviceSize: 200.0,
+				instanceStoreIsSSD:      true,
+			},
+			attachedVolumes: 1,
+			expected:        true,
+		},
+		{name: "SATA SSD instance replaced by NVMe, strict compatibility",
+			spotInfo: instanceTypeInformation{
+				instanceStoreDeviceCount: 1,
+				instanceStoreDeviceSize:  200.0,
+				instanceStoreIsSSD:       true,
+				instanceStoreIsNVMe:      true,
+			},
+			instanceInfo: instanceTypeInformation{
+				instanceStoreDeviceSize: 200.0,
+				instanceStoreIsSSD:      true,
+			},
+			attachedVolumes: 1,
+			compatibility:   StrictInstanceStoreCompatibility,
+			expected:        false,
+		},
+		{name: "Smaller HDD volumes, relaxed compatibility",
+			spotInfo: instanceTypeInformation{
+				instanceStoreDeviceCount: 1,
+				instanceStoreDeviceSize:  25.0,
+				instanceStoreIsSSD:       false,
+			},
+			instanceInfo: instanceTypeInformation{
+				instanceStoreDeviceSize: 50.0,
+				instanceStoreIsSSD:      true,
+			},
+			attachedVolumes: 1,
+			compatibility:   RelaxedInstanceStoreCompatibility,
+			expected:        true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			i := &instance{typeInfo: tt.instanceInfo}
+			if tt.compatibility != "" {
+				i.asg = &autoScalingGroup{
+					config: AutoScalingConfig{InstanceStoreCompatibility: tt.compatibility},
+				}
+			}
 			retValue := i.isStorageCompatible(tt.spotInfo, tt.attachedVolumes)
 			if retValue != tt.expected {
 				t.Errorf("Value received: %t expected %t", retValue, tt.expected)
@@ -1103,12 +1292,176 @@ func TestGetCheapestCompatibleSpotInstanceType(t *testing.T) {
 	}
 }
 
+func Test_instance_rankingScore(t *testing.T) {
+	candidate := instanceTypeInformation{
+		instanceType: "m5.large",
+		pricing: prices{
+			spot: spotPriceMap{"us-east-1a": 0.02, "us-east-1b": 0.03},
+			spotStats: map[string]spotPriceStats{
+				"us-east-1a": {average: 0.04, volatility: 0.01},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		conf     *Config
+		az       string
+		price    float64
+		expected float64
+	}{
+		{
+			name:     "history disabled",
+			conf:     &Config{SpotPriceAverageWeight: 0.5, SpotPriceVolatilityWeight: 1},
+			az:       "us-east-1a",
+			price:    0.02,
+			expected: 0.02,
+		},
+		{
+			name: "missing history",
+			conf: &Config{SpotPriceHistoryDays: 7, SpotPriceAverageWeight: 0.5,
+				SpotPriceVolatilityWeight: 1},
+			az:       "us-east-1b",
+			price:    0.03,
+			expected: 0.03,
+		},
+		{
+			name: "blended score",
+			conf: &Config{SpotPriceHistoryDays: 7, SpotPriceAverageWeight: 0.5,
+				SpotPriceVolatilityWeight: 1},
+			az:       "us-east-1a",
+			price:    0.02,
+			expected: 0.02*0.5 + 0.04*0.5 + 0.01,
+		},
+		{
+			name: "surcharge applied to the average",
+			conf: &Config{SpotPriceHistoryDays: 7, SpotPriceAverageWeight: 1,
+				SpotPriceVolatilityWeight: 0},
+			az:       "us-east-1a",
+			price:    0.03,
+			expected: 0.05,
+		},
+		{
+			name: "weight out of range",
+			conf: &Config{SpotPriceHistoryDays: 7, SpotPriceAverageWeight: 3,
+				SpotPriceVolatilityWeight: 2},
+			az:       "us-east-1a",
+			price:    0.02,
+			expected: 0.04 + 0.02,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{AvailabilityZone: aws.String(tt.az)},
+				},
+				region: &region{conf: tt.conf},
+			}
+			if got := i.rankingScore(candidate, tt.price); math.Abs(got-tt.expected) > 1e-9 {
+				t.Errorf("rankingScore() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_validateBidPrice(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	livePrices := []*ec2.DescribeSpotPriceHistoryOutput{{
+		SpotPriceHistory: []*ec2.SpotPrice{{
+			InstanceType:     aws.String("m5.large"),
+			AvailabilityZone: aws.String("us-east-1a"),
+			SpotPrice:        aws.String("0.04"),
+			Timestamp:        aws.Time(now.Add(-time.Hour)),
+		}},
+	}}
+
+	tests := []struct {
+		name       string
+		conf       *Config
+		fetchedAt  time.Time
+		ec2        mockEC2
+		bidPrice   float64
+		shouldFail bool
+	}{
+		{
+			name:     "checks disabled",
+			conf:     &Config{},
+			bidPrice: 1,
+		},
+		{
+			name:      "fresh spot prices",
+			conf:      &Config{SpotPriceTTL: time.Hour},
+			fetchedAt: now.Add(-time.Minute),
+			bidPrice:  0.1,
+		},
+		{
+			name:       "stale spot prices",
+			conf:       &Config{SpotPriceTTL: time.Hour},
+			fetchedAt:  now.Add(-2 * time.Hour),
+			bidPrice:   0.1,
+			shouldFail: true,
+		},
+		{
+			name:     "bid within the allowed deviation",
+			conf:     &Config{MaxBidDeviationPercentage: 50},
+			ec2:      mockEC2{dsphpo: livePrices},
+			bidPrice: 0.06,
+		},
+		{
+			name:       "bid exceeding the allowed deviation",
+			conf:       &Config{MaxBidDeviationPercentage: 50},
+			ec2:        mockEC2{dsphpo: livePrices},
+			bidPrice:   0.07,
+			shouldFail: true,
+		},
+		{
+			name:       "missing live spot price",
+			conf:       &Config{MaxBidDeviationPercentage: 50},
+			ec2:        mockEC2{dsphpo: []*ec2.DescribeSpotPriceHistoryOutput{{}}},
+			bidPrice:   0.04,
+			shouldFail: true,
+		},
+		{
+			name:       "failed to query the live spot price",
+			conf:       &Config{MaxBidDeviationPercentage: 50},
+			ec2:        mockEC2{dsphperr: errors.New("throttled")},
+			bidPrice:   0.04,
+			shouldFail: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.clock = &mockClock{now: now}
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				region: &region{
+					name:                "us-east-1",
+					conf:                tt.conf,
+					spotPricesFetchedAt: tt.fetchedAt,
+					services:            connections{ec2: tt.ec2},
+				},
+			}
+
+			err := i.validateBidPrice("m5.large", tt.bidPrice)
+			if (err != nil) != tt.shouldFail {
+				t.Errorf("validateBidPrice() error = %v, shouldFail %v", err, tt.shouldFail)
+			}
+		})
+	}
+}
+
 func TestGetPriceToBid(t *testing.T) {
 	tests := []struct {
 		spotPercentage       float64
 		currentSpotPrice     float64
 		currentOnDemandPrice float64
 		spotPremium          float64
+		maxPrice             float64
+		onDemandPercentage   float64
 		policy               string
 		want                 float64
 	}{
@@ -1160,18 +1513,51 @@ func TestGetPriceToBid(t *testing.T) {
 			policy:               "aggressive",
 			want:                 0.0924,
 		},
+		{
+			currentSpotPrice:     0.0216,
+			currentOnDemandPrice: 0.0464,
+			maxPrice:             0.03,
+			policy:               "fixed",
+			want:                 0.03,
+		},
+		{
+			currentSpotPrice:     0.0216,
+			currentOnDemandPrice: 0.0464,
+			policy:               "fixed",
+			want:                 0.0464,
+		},
+		{
+			currentSpotPrice:     0.0216,
+			currentOnDemandPrice: 0.0464,
+			onDemandPercentage:   50.0,
+			policy:               "percentage",
+			want:                 0.0232,
+		},
+		{
+			currentSpotPrice:     0.0216,
+			currentOnDemandPrice: 0.0464,
+			policy:               "",
+			want:                 0.0464,
+		},
 	}
 	for _, tt := range tests {
 		cfg := &Config{
 			AutoScalingConfig: AutoScalingConfig{
-				SpotPriceBufferPercentage: tt.spotPercentage,
-				BiddingPolicy:             tt.policy,
+				BiddingPolicy: "normal",
 			}}
 		i := &instance{
 			region: &region{
 				name: "us-east-1",
 				conf: cfg,
 			},
+			asg: &autoScalingGroup{
+				config: AutoScalingConfig{
+					SpotPriceBufferPercentage:   tt.spotPercentage,
+					SpotMaxPrice:                tt.maxPrice,
+					SpotPriceOnDemandPercentage: tt.onDemandPercentage,
+					BiddingPolicy:               tt.policy,
+				},
+			},
 			Instance: &ec2.Instance{
 				InstanceId: aws.String("i-0000000"),
 			},
@@ -1235,9 +1621,36 @@ func TestTerminate(t *testing.T) {
 			},
 			expected: errors.New(""),
 		},
+		{
+			name: "issue with cancelling the spot request",
+			tags: []*ec2.Tag{},
+			inst: &instance{
+				Instance: &ec2.Instance{
+					InstanceId:            aws.String("id1"),
+					SpotInstanceRequestId: aws.String("sir-123"),
+					State: &ec2.InstanceState{
+						Name: aws.String(ec2.InstanceStateNameRunning),
+					},
+				},
+				asg: &autoScalingGroup{config: AutoScalingConfig{SpotInterruptionBehavior: StopInterruptionBehavior}},
+				region: &region{
+					services: connections{
+						ec2: mockEC2{
+							csirerr: errors.New("throttled"),
+							tierr:   nil,
+						},
+					},
+				},
+			},
+			expected: nil,
+		},
 	}
 	for _, tt := range tests {
 		ret := tt.inst.terminate()
+		if ret != nil && tt.expected == nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, ret.Error())
+			continue
+		}
 		if ret != nil && ret.Error() != tt.expected.Error() {
 			t.Errorf("error actual: %s, expected: %s", ret.Error(), tt.expected.Error())
 		}
@@ -1277,8 +1690,11 @@ func TestGenerateTagList(t *testing.T) {
 		name                     string
 		ASGName                  string
 		ASGLCName                string
+		ASGTags                  []*autoscaling.TagDescription
 		instanceTags             []*ec2.Tag
 		instanceID               string
+		spotInstanceTags         string
+		version                  string
 		expectedTagSpecification []*ec2.TagSpecification
 	}{
 		{name: "no tags on original instance",
@@ -1304,6 +1720,10 @@ func TestGenerateTagList(t *testing.T) {
 							Key:   aws.String("launched-for-replacing-instance"),
 							Value: aws.String("foo"),
 						},
+						{
+							Key:   aws.String("autospotting-tag-schema"),
+							Value: aws.String("2"),
+						},
 					},
 				},
 			},
@@ -1338,6 +1758,10 @@ func TestGenerateTagList(t *testing.T) {
 							Key:   aws.String("launched-for-replacing-instance"),
 							Value: aws.String("bar"),
 						},
+						{
+							Key:   aws.String("autospotting-tag-schema"),
+							Value: aws.String("2"),
+						},
 						{
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("myASG"),
@@ -1354,42 +1778,209 @@ func TestGenerateTagList(t *testing.T) {
 				},
 			},
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-
-			i := instance{
-				Instance: &ec2.Instance{
-					Tags:       tt.instanceTags,
-					InstanceId: aws.String(tt.instanceID),
+		{name: "cost allocation tags",
+			ASGLCName:  "testLC0",
+			ASGName:    "myASG",
+			instanceID: "bar",
+			version:    "1.0.2",
+			ASGTags: []*autoscaling.TagDescription{
+				{
+					Key:               aws.String("team"),
+					Value:             aws.String("platform"),
+					PropagateAtLaunch: aws.Bool(true),
 				},
-				asg: &autoScalingGroup{
-					name: tt.ASGName,
-					Group: &autoscaling.Group{
-						LaunchConfigurationName: aws.String(tt.ASGLCName),
-					},
+				{
+					Key:               aws.String("spot-enabled"),
+					Value:             aws.String("true"),
+					PropagateAtLaunch: aws.Bool(false),
 				},
-			}
-
-			tags := i.generateTagsList()
-
-			// make sure the lists of tags are sorted, otherwise the comparison fails
-			sort.Slice(tags[0].Tags, func(i, j int) bool {
-				return *tags[0].Tags[i].Key < *tags[0].Tags[j].Key
-			})
-			sort.Slice(tt.expectedTagSpecification[0].Tags, func(i, j int) bool {
-				return *tt.expectedTagSpecification[0].Tags[i].Key < *tt.expectedTagSpecification[0].Tags[j].Key
-			})
-
-			if !reflect.DeepEqual(tags[0].Tags, tt.expectedTagSpecification[0].Tags) {
-				t.Errorf("propagatedInstanceTags received: %+v, expected: %+v",
-					tags, tt.expectedTagSpecification)
-			}
-		})
-	}
-}
-
+				{
+					Key:               aws.String("cost-center"),
+					Value:             aws.String("5678"),
+					PropagateAtLaunch: aws.Bool(true),
+				},
+			},
+			instanceTags: []*ec2.Tag{
+				{
+					Key:   aws.String("team"),
+					Value: aws.String("outdated"),
+				},
+				{
+					Key:   aws.String("aws:autoscaling:groupName"),
+					Value: aws.String("myASG"),
+				},
+			},
+			spotInstanceTags: "cost-center=1234,owner=finops",
+			expectedTagSpecification: []*ec2.TagSpecification{
+				{
+					ResourceType: aws.String("instance"),
+					Tags: []*ec2.Tag{
+						{
+							Key:   aws.String("LaunchConfigurationName"),
+							Value: aws.String("testLC0"),
+						},
+						{
+							Key:   aws.String("launched-by-autospotting"),
+							Value: aws.String("true"),
+						},
+						{
+							Key:   aws.String("launched-for-replacing-instance"),
+							Value: aws.String("bar"),
+						},
+						{
+							Key:   aws.String("autospotting-tag-schema"),
+							Value: aws.String("2"),
+						},
+						{
+							Key:   aws.String("launched-for-asg"),
+							Value: aws.String("myASG"),
+						},
+						{
+							Key:   aws.String("autospotting-version"),
+							Value: aws.String("1.0.2"),
+						},
+						{
+							Key:   aws.String("team"),
+							Value: aws.String("platform"),
+						},
+						{
+							Key:   aws.String("cost-center"),
+							Value: aws.String("5678"),
+						},
+						{
+							Key:   aws.String("owner"),
+							Value: aws.String("finops"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			i := instance{
+				Instance: &ec2.Instance{
+					Tags:       tt.instanceTags,
+					InstanceId: aws.String(tt.instanceID),
+				},
+				asg: &autoScalingGroup{
+					name: tt.ASGName,
+					Group: &autoscaling.Group{
+						LaunchConfigurationName: aws.String(tt.ASGLCName),
+						Tags:                    tt.ASGTags,
+					},
+				},
+				region: &region{
+					conf: &Config{
+						SpotInstanceTags: tt.spotInstanceTags,
+						Version:          tt.version,
+					},
+				},
+			}
+
+			tags := i.generateTagsList()
+
+			// make sure the lists of tags are sorted, otherwise the comparison fails
+			sort.Slice(tags[0].Tags, func(i, j int) bool {
+				return *tags[0].Tags[i].Key < *tags[0].Tags[j].Key
+			})
+			sort.Slice(tt.expectedTagSpecification[0].Tags, func(i, j int) bool {
+				return *tt.expectedTagSpecification[0].Tags[i].Key < *tt.expectedTagSpecification[0].Tags[j].Key
+			})
+
+			if !reflect.DeepEqual(tags[0].Tags, tt.expectedTagSpecification[0].Tags) {
+				t.Errorf("propagatedInstanceTags received: %+v, expected: %+v",
+					tags, tt.expectedTagSpecification)
+			}
+
+			if len(tags) != 1+len(launchTaggedResourceTypes) {
+				t.Fatalf("tag specifications received: %+v, expected the instance and %v",
+					tags, launchTaggedResourceTypes)
+			}
+			for n, resourceType := range launchTaggedResourceTypes {
+				spec := tags[n+1]
+				sort.Slice(spec.Tags, func(i, j int) bool {
+					return *spec.Tags[i].Key < *spec.Tags[j].Key
+				})
+				if aws.StringValue(spec.ResourceType) != resourceType ||
+					!reflect.DeepEqual(spec.Tags, tags[0].Tags) {
+					t.Errorf("%s tags received: %+v, expected the instance tags", resourceType, spec)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateTagListOverflow(t *testing.T) {
+	var asgTags []*autoscaling.TagDescription
+	var instanceTags []*ec2.Tag
+	for n := 0; n < 30; n++ {
+		asgTags = append(asgTags, &autoscaling.TagDescription{
+			Key:               aws.String(fmt.Sprintf("group-%02d", n)),
+			Value:             aws.String("value"),
+			PropagateAtLaunch: aws.Bool(true),
+		})
+		// half of the instance tags duplicate the group tags
+		prefix := "instance"
+		if n%2 == 0 {
+			prefix = "group"
+		}
+		instanceTags = append(instanceTags, &ec2.Tag{
+			Key:   aws.String(fmt.Sprintf("%s-%02d", prefix, n)),
+			Value: aws.String("value"),
+		})
+	}
+
+	i := instance{
+		Instance: &ec2.Instance{
+			Tags:         instanceTags,
+			InstanceId:   aws.String("i-1"),
+			InstanceType: aws.String("m5.large"),
+		},
+		asg: &autoScalingGroup{
+			name: "asg",
+			Group: &autoscaling.Group{
+				LaunchConfigurationName: aws.String("lc"),
+				Tags:                    asgTags,
+			},
+		},
+		region: &region{conf: &Config{Version: "1.0.2"}},
+	}
+
+	tags := i.generateTagsList()
+
+	for _, spec := range tags {
+		if len(spec.Tags) != maxTagsPerResource {
+			t.Fatalf("%s got %d tags, expected %d", aws.StringValue(spec.ResourceType),
+				len(spec.Tags), maxTagsPerResource)
+		}
+	}
+
+	keys := make(map[string]bool)
+	for _, tag := range tags[0].Tags {
+		key := aws.StringValue(tag.Key)
+		if keys[key] {
+			t.Errorf("duplicate tag %s", key)
+		}
+		keys[key] = true
+	}
+
+	// the 7 tags set by AutoSpotting, the 30 group tags and the first 13 of
+	// the 15 distinct instance tags
+	for _, key := range []string{"launched-by-autospotting", "launched-for-asg", "launched-for-replacing-instance",
+		"autospotting-tag-schema", "LaunchConfigurationName", "autospotting-version", "original-instance-type",
+		"group-29", "instance-25"} {
+		if !keys[key] {
+			t.Errorf("missing tag %s", key)
+		}
+	}
+	if keys["instance-27"] || keys["instance-29"] {
+		t.Errorf("the last instance tags weren't dropped")
+	}
+}
+
 func Test_instance_convertLaunchConfigurationBlockDeviceMappings(t *testing.T) {
 
 	tests := []struct {
@@ -1765,32 +2356,140 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 
 				SubnetId: aws.String("subnet-123"),
 
-				TagSpecifications: []*ec2.TagSpecification{{
-					ResourceType: aws.String("instance"),
-					Tags: []*ec2.Tag{
-						{
-							Key:   aws.String("LaunchTemplateID"),
-							Value: aws.String("lt-id"),
-						},
-						{
-							Key:   aws.String("LaunchTemplateVersion"),
-							Value: aws.String("v1"),
+				TagSpecifications: []*ec2.TagSpecification{
+					{
+						ResourceType: aws.String("instance"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchTemplateID"),
+								Value: aws.String("lt-id"),
+							},
+							{
+								Key:   aws.String("LaunchTemplateVersion"),
+								Value: aws.String("v1"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-by-autospotting"),
-							Value: aws.String("true"),
+					},
+					{
+						ResourceType: aws.String("volume"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchTemplateID"),
+								Value: aws.String("lt-id"),
+							},
+							{
+								Key:   aws.String("LaunchTemplateVersion"),
+								Value: aws.String("v1"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-for-asg"),
-							Value: aws.String("mygroup"),
+					},
+					{
+						ResourceType: aws.String("network-interface"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchTemplateID"),
+								Value: aws.String("lt-id"),
+							},
+							{
+								Key:   aws.String("LaunchTemplateVersion"),
+								Value: aws.String("v1"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-for-replacing-instance"),
-							Value: aws.String("i-foo"),
+					},
+					{
+						ResourceType: aws.String("spot-instances-request"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchTemplateID"),
+								Value: aws.String("lt-id"),
+							},
+							{
+								Key:   aws.String("LaunchTemplateVersion"),
+								Value: aws.String("v1"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
 					},
 				},
-				},
 			},
 		},
 		{
@@ -1890,32 +2589,140 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					Affinity: aws.String("foo"),
 				},
 
-				TagSpecifications: []*ec2.TagSpecification{{
-					ResourceType: aws.String("instance"),
-					Tags: []*ec2.Tag{
-						{
-							Key:   aws.String("LaunchTemplateID"),
-							Value: aws.String("lt-id"),
-						},
-						{
-							Key:   aws.String("LaunchTemplateVersion"),
-							Value: aws.String("v1"),
+				TagSpecifications: []*ec2.TagSpecification{
+					{
+						ResourceType: aws.String("instance"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchTemplateID"),
+								Value: aws.String("lt-id"),
+							},
+							{
+								Key:   aws.String("LaunchTemplateVersion"),
+								Value: aws.String("v1"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-by-autospotting"),
-							Value: aws.String("true"),
+					},
+					{
+						ResourceType: aws.String("volume"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchTemplateID"),
+								Value: aws.String("lt-id"),
+							},
+							{
+								Key:   aws.String("LaunchTemplateVersion"),
+								Value: aws.String("v1"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-for-asg"),
-							Value: aws.String("mygroup"),
+					},
+					{
+						ResourceType: aws.String("network-interface"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchTemplateID"),
+								Value: aws.String("lt-id"),
+							},
+							{
+								Key:   aws.String("LaunchTemplateVersion"),
+								Value: aws.String("v1"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-for-replacing-instance"),
-							Value: aws.String("i-foo"),
+					},
+					{
+						ResourceType: aws.String("spot-instances-request"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchTemplateID"),
+								Value: aws.String("lt-id"),
+							},
+							{
+								Key:   aws.String("LaunchTemplateVersion"),
+								Value: aws.String("v1"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
 					},
 				},
-				},
 			},
 		},
 		{
@@ -2011,26 +2818,119 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 
 				SubnetId: nil,
 
-				TagSpecifications: []*ec2.TagSpecification{{
-					ResourceType: aws.String("instance"),
-					Tags: []*ec2.Tag{
-						{
-							Key:   aws.String("LaunchConfigurationName"),
-							Value: aws.String("myLC"),
+				TagSpecifications: []*ec2.TagSpecification{
+					{
+						ResourceType: aws.String("instance"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							}, {
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-by-autospotting"),
-							Value: aws.String("true"),
+					},
+					{
+						ResourceType: aws.String("volume"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							}, {
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-for-asg"),
-							Value: aws.String("mygroup"),
-						}, {
-							Key:   aws.String("launched-for-replacing-instance"),
-							Value: aws.String("i-foo"),
+					},
+					{
+						ResourceType: aws.String("network-interface"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							}, {
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
+						},
+					},
+					{
+						ResourceType: aws.String("spot-instances-request"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							}, {
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
 					},
-				},
 				},
 				UserData: aws.String("userdata"),
 			},
@@ -2151,28 +3051,124 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					},
 				},
 
-				TagSpecifications: []*ec2.TagSpecification{{
-					ResourceType: aws.String("instance"),
-					Tags: []*ec2.Tag{
-						{
-							Key:   aws.String("LaunchConfigurationName"),
-							Value: aws.String("myLC"),
+				TagSpecifications: []*ec2.TagSpecification{
+					{
+						ResourceType: aws.String("instance"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-by-autospotting"),
-							Value: aws.String("true"),
+					},
+					{
+						ResourceType: aws.String("volume"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-for-asg"),
-							Value: aws.String("mygroup"),
+					},
+					{
+						ResourceType: aws.String("network-interface"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-for-replacing-instance"),
-							Value: aws.String("i-foo"),
+					},
+					{
+						ResourceType: aws.String("spot-instances-request"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("launched-for-replacing-instance"),
+								Value: aws.String("i-foo"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
 					},
 				},
-				},
 				UserData: aws.String("userdata"),
 			},
 		},
@@ -2293,27 +3289,120 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					},
 				},
 
-				TagSpecifications: []*ec2.TagSpecification{{
-					ResourceType: aws.String("instance"),
-					Tags: []*ec2.Tag{
-						{
-							Key:   aws.String("LaunchConfigurationName"),
-							Value: aws.String("myLC"),
+				TagSpecifications: []*ec2.TagSpecification{
+					{
+						ResourceType: aws.String("instance"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key: aws.String("launched-for-replacing-instance"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-by-autospotting"),
-							Value: aws.String("true"),
+					},
+					{
+						ResourceType: aws.String("volume"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key: aws.String("launched-for-replacing-instance"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key:   aws.String("launched-for-asg"),
-							Value: aws.String("mygroup"),
+					},
+					{
+						ResourceType: aws.String("network-interface"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key: aws.String("launched-for-replacing-instance"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
-						{
-							Key: aws.String("launched-for-replacing-instance"),
+					},
+					{
+						ResourceType: aws.String("spot-instances-request"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("LaunchConfigurationName"),
+								Value: aws.String("myLC"),
+							},
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key: aws.String("launched-for-replacing-instance"),
+							},
+							{
+								Key:   aws.String("autospotting-tag-schema"),
+								Value: aws.String("2"),
+							},
+							{
+								Key:   aws.String("original-instance-type"),
+								Value: aws.String("t2.medium"),
+							},
 						},
 					},
 				},
-				},
 				UserData: aws.String(base64.StdEncoding.EncodeToString(beanstalkUserDataWrappedExample)),
 			},
 		},
@@ -2324,12 +3413,14 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 			got, _ := tt.inst.createRunInstancesInput(tt.args.instanceType, tt.args.price)
 
 			// make sure the lists of tags are sorted, otherwise the comparison fails
-			sort.Slice(got.TagSpecifications[0].Tags, func(i, j int) bool {
-				return *got.TagSpecifications[0].Tags[i].Key < *got.TagSpecifications[0].Tags[j].Key
-			})
-			sort.Slice(tt.want.TagSpecifications[0].Tags, func(i, j int) bool {
-				return *tt.want.TagSpecifications[0].Tags[i].Key < *tt.want.TagSpecifications[0].Tags[j].Key
-			})
+			for _, specs := range [][]*ec2.TagSpecification{got.TagSpecifications, tt.want.TagSpecifications} {
+				for _, spec := range specs {
+					tags := spec.Tags
+					sort.Slice(tags, func(i, j int) bool {
+						return *tags[i].Key < *tags[j].Key
+					})
+				}
+			}
 
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Instance.createRunInstancesInput() = %v, want %v", got, tt.want)
@@ -2636,3 +3727,312 @@ func Test_instance_isUnattachedSpotInstanceLaunchedForAnEnabledASG(t *testing.T)
 
 	}
 }
+
+func Test_instance_isTenancyReplaceable(t *testing.T) {
+	tests := []struct {
+		name      string
+		placement *ec2.Placement
+		allow     bool
+		want      bool
+	}{
+		{
+			name:      "missing placement",
+			placement: nil,
+			want:      true,
+		},
+		{
+			name:      "default tenancy",
+			placement: &ec2.Placement{Tenancy: aws.String(ec2.TenancyDefault)},
+			want:      true,
+		},
+		{
+			name:      "dedicated tenancy not allowed",
+			placement: &ec2.Placement{Tenancy: aws.String(ec2.TenancyDedicated)},
+			want:      false,
+		},
+		{
+			name:      "host tenancy not allowed",
+			placement: &ec2.Placement{Tenancy: aws.String(ec2.TenancyHost)},
+			want:      false,
+		},
+		{
+			name:      "dedicated tenancy allowed",
+			placement: &ec2.Placement{Tenancy: aws.String(ec2.TenancyDedicated)},
+			allow:     true,
+			want:      true,
+		},
+		{
+			name:      "host tenancy allowed",
+			placement: &ec2.Placement{Tenancy: aws.String(ec2.TenancyHost)},
+			allow:     true,
+			want:      false,
+		},
+		{
+			name:      "host ID",
+			placement: &ec2.Placement{HostId: aws.String("h-123")},
+			allow:     true,
+			want:      false,
+		},
+		{
+			name: "host resource group",
+			placement: &ec2.Placement{
+				HostResourceGroupArn: aws.String("arn:aws:resource-groups:us-east-1:123456789012:group/hosts"),
+			},
+			allow: true,
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-foo"),
+					Placement:  tt.placement,
+				},
+				region: &region{name: "us-east-1"},
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{AllowDedicatedTenancy: tt.allow},
+				},
+			}
+			if got := i.isTenancyReplaceable(); got != tt.want {
+				t.Errorf("isTenancyReplaceable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isProtectedFromTermination(t *testing.T) {
+	tests := []struct {
+		name       string
+		skip       bool
+		cached     map[string]bool
+		diao       *ec2.DescribeInstanceAttributeOutput
+		diaerr     error
+		want       bool
+		wantErr    bool
+		wantCached map[string]bool
+	}{
+		{
+			name: "looked up and memoized",
+			diao: &ec2.DescribeInstanceAttributeOutput{
+				DisableApiTermination: &ec2.AttributeBooleanValue{Value: aws.Bool(true)},
+			},
+			want:       true,
+			wantCached: map[string]bool{"i-foo": true},
+		},
+		{
+			name:       "memoized",
+			cached:     map[string]bool{"i-foo": false},
+			diaerr:     errors.New("unexpected call"),
+			want:       false,
+			wantCached: map[string]bool{"i-foo": false},
+		},
+		{
+			name:    "throttled lookup isn't memoized",
+			diaerr:  errors.New("Throttling"),
+			want:    true,
+			wantErr: true,
+		},
+		{
+			name:   "check skipped for the group",
+			skip:   true,
+			diaerr: errors.New("unexpected call"),
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-foo")},
+				region: &region{
+					name:                  "us-east-1",
+					terminationProtection: tt.cached,
+					services: connections{ec2: mockEC2{
+						diao:   tt.diao,
+						diaerr: tt.diaerr,
+					}},
+				},
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{SkipTerminationProtectionCheck: tt.skip},
+				},
+			}
+			got, err := i.isProtectedFromTermination()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("isProtectedFromTermination() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isProtectedFromTermination() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(i.region.terminationProtection, tt.wantCached) {
+				t.Errorf("memoized termination protection = %v, want %v",
+					i.region.terminationProtection, tt.wantCached)
+			}
+		})
+	}
+}
+
+func Test_instance_copyTerminationProtection(t *testing.T) {
+	tests := []struct {
+		name      string
+		protected bool
+		copy      bool
+		miaerr    error
+		wantErr   bool
+	}{
+		{name: "disabled", protected: true, copy: false},
+		{name: "unprotected", protected: false, copy: true, miaerr: errors.New("unexpected call")},
+		{name: "protected", protected: true, copy: true},
+		{name: "failed to modify", protected: true, copy: true, miaerr: errors.New("UnauthorizedOperation"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				services: connections{ec2: mockEC2{
+					diao: &ec2.DescribeInstanceAttributeOutput{
+						DisableApiTermination: &ec2.AttributeBooleanValue{Value: aws.Bool(tt.protected)},
+					},
+					miaerr: tt.miaerr,
+				}},
+			}
+			od := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-ondemand")},
+				region:   r,
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{CopyTerminationProtection: tt.copy},
+				},
+			}
+			spot := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-spot")},
+				region:   r,
+			}
+			if err := spot.copyTerminationProtection(od); (err != nil) != tt.wantErr {
+				t.Errorf("copyTerminationProtection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_instance_nilSafeAccessors(t *testing.T) {
+	tests := []struct {
+		name      string
+		instance  *ec2.Instance
+		wantAZ    string
+		wantState string
+	}{
+		{
+			name:     "missing placement and state",
+			instance: &ec2.Instance{},
+		},
+		{
+			name: "missing zone and state name",
+			instance: &ec2.Instance{
+				Placement: &ec2.Placement{},
+				State:     &ec2.InstanceState{},
+			},
+		},
+		{
+			name: "populated",
+			instance: &ec2.Instance{
+				Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				State:     &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			},
+			wantAZ:    "us-east-1a",
+			wantState: ec2.InstanceStateNameRunning,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{Instance: tt.instance}
+			if got := i.availabilityZone(); got != tt.wantAZ {
+				t.Errorf("availabilityZone() = %q, want %q", got, tt.wantAZ)
+			}
+			if got := i.stateName(); got != tt.wantState {
+				t.Errorf("stateName() = %q, want %q", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func Test_instance_isReadyToAttach_missingFields(t *testing.T) {
+	i := &instance{
+		Instance: &ec2.Instance{
+			State: &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		},
+	}
+	asg := &autoScalingGroup{name: "asg", Group: &autoscaling.Group{}}
+
+	// an instance with unknown launch time is considered as just launched
+	if i.isReadyToAttach(asg) {
+		t.Errorf("isReadyToAttach() = true, want false for unknown launch time")
+	}
+}
+
+func Test_instance_isReadyToAttach(t *testing.T) {
+	launchTime := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		state       string
+		uptime      time.Duration
+		gracePeriod *int64
+		expected    bool
+	}{
+		{
+			name:     "pending",
+			state:    ec2.InstanceStateNamePending,
+			uptime:   time.Hour,
+			expected: false,
+		},
+		{
+			name:     "running within the grace period",
+			state:    ec2.InstanceStateNameRunning,
+			uptime:   2 * time.Minute,
+			expected: false,
+		},
+		{
+			name:     "running past the grace period",
+			state:    ec2.InstanceStateNameRunning,
+			uptime:   10 * time.Minute,
+			expected: true,
+		},
+		{
+			name:        "running past the overridden grace period",
+			state:       ec2.InstanceStateNameRunning,
+			uptime:      2 * time.Minute,
+			gracePeriod: aws.Int64(60),
+			expected:    true,
+		},
+		{
+			name:        "running within the overridden grace period",
+			state:       ec2.InstanceStateNameRunning,
+			uptime:      10 * time.Minute,
+			gracePeriod: aws.Int64(900),
+			expected:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-spot"),
+					LaunchTime: aws.Time(launchTime),
+					State:      &ec2.InstanceState{Name: aws.String(tt.state)},
+				},
+			}
+			asg := &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{
+					HealthCheckGracePeriod: aws.Int64(300),
+				},
+				config: AutoScalingConfig{GracePeriod: tt.gracePeriod},
+				region: &region{conf: &Config{
+					clock: &mockClock{now: launchTime.Add(tt.uptime)},
+				}},
+			}
+			if got := i.isReadyToAttach(asg); got != tt.expected {
+				t.Errorf("isReadyToAttach() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// describeInstanceTypes complements the static instance type data with the
+// details only available from the EC2 API, such as the accelerator models and
+// the baseline EBS performance. On failure the comparisons fall back to the
+// static data.
+func (r *region) describeInstanceTypes() {
+	err := r.services.ec2.DescribeInstanceTypesPages(
+		&ec2.DescribeInstanceTypesInput{},
+		func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
+			for _, it := range page.InstanceTypes {
+				name := aws.StringValue(it.InstanceType)
+				r.setAcceleratorInformation(name, describeAccelerators(it))
+				r.setEBSBaseline(name, it.EbsInfo)
+				r.setCores(name, it.VCpuInfo)
+			}
+			return true
+		})
+
+	if err != nil {
+		log.Println(r.name, "Failed to describe the instance types", err.Error())
+	}
+
+	// Trainium instances aren't described in detail by the EC2 API yet
+	for name := range r.instanceTypeInformation {
+		if strings.HasPrefix(name, "trn") {
+			r.setAcceleratorInformation(name, acceleratorInformation{manufacturer: "AWS", model: "Trainium"})
+		}
+	}
+}
+
+// setCores stores the default number of physical CPU cores of the instance
+// type, which isn't included in the static instance type data.
+func (r *region) setCores(instanceType string, vCPU *ec2.VCpuInfo) {
+	info, found := r.instanceTypeInformation[instanceType]
+	if !found || vCPU == nil {
+		return
+	}
+	info.cores = int(aws.Int64Value(vCPU.DefaultCores))
+	r.instanceTypeInformation[instanceType] = info
+}
+
+// setEBSBaseline stores the baseline EBS performance of the instance type,
+// which can be sustained indefinitely, unlike the burst performance included
+// in the static instance type data for the smaller instance types.
+func (r *region) setEBSBaseline(instanceType string, ebs *ec2.EbsInfo) {
+	info, found := r.instanceTypeInformation[instanceType]
+	if !found || ebs == nil || ebs.EbsOptimizedInfo == nil {
+		return
+	}
+	info.ebsBaselineThroughput = aws.Float64Value(ebs.EbsOptimizedInfo.BaselineThroughputInMBps)
+	info.ebsBaselineIOPS = aws.Int64Value(ebs.EbsOptimizedInfo.BaselineIops)
+	r.instanceTypeInformation[instanceType] = info
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_region_describeInstanceTypes(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large":     {instanceType: "m5.large"},
+			"p3.2xlarge":   {instanceType: "p3.2xlarge"},
+			"trn1.2xlarge": {instanceType: "trn1.2xlarge"},
+		},
+		services: connections{ec2: mockEC2{
+			ditpo: []*ec2.DescribeInstanceTypesOutput{
+				{
+					InstanceTypes: []*ec2.InstanceTypeInfo{
+						{InstanceType: aws.String("m5.large")},
+						{
+							InstanceType: aws.String("p3.2xlarge"),
+							EbsInfo: &ec2.EbsInfo{
+								EbsOptimizedInfo: &ec2.EbsOptimizedInfo{
+									BaselineThroughputInMBps: aws.Float64(212.5),
+									BaselineIops:             aws.Int64(10000),
+								},
+							},
+							GpuInfo: &ec2.GpuInfo{
+								Gpus: []*ec2.GpuDeviceInfo{
+									{Count: aws.Int64(1), Manufacturer: aws.String("NVIDIA"), Name: aws.String("V100")},
+								},
+								TotalGpuMemoryInMiB: aws.Int64(16384),
+							},
+						},
+					},
+				},
+				{
+					InstanceTypes: []*ec2.InstanceTypeInfo{
+						// not available in the region according to the static data
+						{
+							InstanceType: aws.String("g4dn.xlarge"),
+							GpuInfo: &ec2.GpuInfo{
+								Gpus: []*ec2.GpuDeviceInfo{
+									{Count: aws.Int64(1), Manufacturer: aws.String("NVIDIA"), Name: aws.String("T4")},
+								},
+							},
+						},
+					},
+				},
+			},
+			ditperr: errors.New("throttled after the last page"),
+		}},
+	}
+
+	r.describeInstanceTypes()
+
+	want := map[string]acceleratorInformation{
+		"m5.large":     {},
+		"p3.2xlarge":   v100,
+		"trn1.2xlarge": {manufacturer: "AWS", model: "Trainium"},
+	}
+	got := map[string]acceleratorInformation{}
+	for name, info := range r.instanceTypeInformation {
+		got[name] = info.accelerator
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("describeInstanceTypes() accelerators = %+v, want %+v", got, want)
+	}
+
+	if p3 := r.instanceTypeInformation["p3.2xlarge"]; p3.ebsBaselineThroughput != 212.5 || p3.ebsBaselineIOPS != 10000 {
+		t.Errorf("describeInstanceTypes() EBS baseline = %v/%v, want 212.5/10000",
+			p3.ebsBaselineThroughput, p3.ebsBaselineIOPS)
+	}
+}
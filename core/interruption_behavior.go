@@ -0,0 +1,82 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// TerminateInterruptionBehavior terminates the spot instances when they're
+	// interrupted
+	TerminateInterruptionBehavior = ec2.InstanceInterruptionBehaviorTerminate
+
+	// StopInterruptionBehavior stops the spot instances when they're
+	// interrupted, starting them again once capacity is available
+	StopInterruptionBehavior = ec2.InstanceInterruptionBehaviorStop
+
+	// HibernateInterruptionBehavior hibernates the spot instances when they're
+	// interrupted, resuming them once capacity is available
+	HibernateInterruptionBehavior = ec2.InstanceInterruptionBehaviorHibernate
+)
+
+func isValidInterruptionBehavior(behavior string) bool {
+	switch behavior {
+	case TerminateInterruptionBehavior, StopInterruptionBehavior, HibernateInterruptionBehavior:
+		return true
+	}
+	return false
+}
+
+// resumesAfterInterruption determines if the spot instances of the group are
+// stopped or hibernated when interrupted, instead of being terminated.
+func (a *autoScalingGroup) resumesAfterInterruption() bool {
+	if a == nil {
+		return false
+	}
+	b := a.config.SpotInterruptionBehavior
+	return b == StopInterruptionBehavior || b == HibernateInterruptionBehavior
+}
+
+// setInterruptionBehavior sets the interruption behavior of the spot instance
+// when it resumes after interruptions, which is only supported by persistent
+// spot requests. The hibernation also needs to be enabled on the launch
+// template and supported by the AMI and instance type.
+func (a *autoScalingGroup) setInterruptionBehavior(opts *ec2.SpotMarketOptions) {
+	if !a.resumesAfterInterruption() {
+		return
+	}
+	opts.InstanceInterruptionBehavior = aws.String(a.config.SpotInterruptionBehavior)
+	opts.SpotInstanceType = aws.String(ec2.SpotInstanceTypePersistent)
+}
+
+// cancelSpotInstanceRequest cancels the persistent spot request of the spot
+// instance before terminating it, which would otherwise launch another spot
+// instance in its place. A request which no longer exists was already closed
+// or cancelled, so there's nothing left to cancel.
+func (i *instance) cancelSpotInstanceRequest() error {
+	if i.SpotInstanceRequestId == nil || !i.asg.usesPersistentSpotRequests() {
+		return nil
+	}
+
+	_, err := i.region.services.ec2.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []*string{i.SpotInstanceRequestId},
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == "InvalidSpotInstanceRequestID.NotFound" {
+		log.Println(i.region.name, "Spot request", aws.StringValue(i.SpotInstanceRequestId),
+			"of", aws.StringValue(i.InstanceId), "was already closed")
+		return nil
+	}
+	if err != nil {
+		log.Println(i.region.name, "Couldn't cancel the spot request",
+			aws.StringValue(i.SpotInstanceRequestId), "of", aws.StringValue(i.InstanceId), err.Error())
+	}
+	return err
+}
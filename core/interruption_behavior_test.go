@@ -0,0 +1,149 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_setInterruptionBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		asg      *autoScalingGroup
+		expected *ec2.SpotMarketOptions
+	}{
+		{
+			name:     "no group",
+			expected: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.1")},
+		},
+		{
+			name:     "terminate",
+			asg:      &autoScalingGroup{config: AutoScalingConfig{SpotInterruptionBehavior: TerminateInterruptionBehavior}},
+			expected: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.1")},
+		},
+		{
+			name: "stop",
+			asg:  &autoScalingGroup{config: AutoScalingConfig{SpotInterruptionBehavior: StopInterruptionBehavior}},
+			expected: &ec2.SpotMarketOptions{
+				InstanceInterruptionBehavior: aws.String(StopInterruptionBehavior),
+				MaxPrice:                     aws.String("0.1"),
+				SpotInstanceType:             aws.String(ec2.SpotInstanceTypePersistent),
+			},
+		},
+		{
+			name: "hibernate",
+			asg:  &autoScalingGroup{config: AutoScalingConfig{SpotInterruptionBehavior: HibernateInterruptionBehavior}},
+			expected: &ec2.SpotMarketOptions{
+				InstanceInterruptionBehavior: aws.String(HibernateInterruptionBehavior),
+				MaxPrice:                     aws.String("0.1"),
+				SpotInstanceType:             aws.String(ec2.SpotInstanceTypePersistent),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &ec2.SpotMarketOptions{MaxPrice: aws.String("0.1")}
+			tt.asg.setInterruptionBehavior(opts)
+			if !reflect.DeepEqual(opts, tt.expected) {
+				t.Errorf("setInterruptionBehavior() = %v, expected %v", opts, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_cancelSpotInstanceRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		behavior  string
+		requestID *string
+		csirerr   error
+		wantErr   bool
+	}{
+		{
+			name:      "terminate behavior",
+			behavior:  TerminateInterruptionBehavior,
+			requestID: aws.String("sir-123"),
+			csirerr:   errors.New("not called"),
+		},
+		{
+			name:     "no spot request",
+			behavior: StopInterruptionBehavior,
+			csirerr:  errors.New("not called"),
+		},
+		{
+			name:      "cancelled",
+			behavior:  StopInterruptionBehavior,
+			requestID: aws.String("sir-123"),
+		},
+		{
+			name:      "already closed",
+			behavior:  StopInterruptionBehavior,
+			requestID: aws.String("sir-123"),
+			csirerr:   awserr.New("InvalidSpotInstanceRequestID.NotFound", "not found", nil),
+		},
+		{
+			name:      "cancellation error",
+			behavior:  HibernateInterruptionBehavior,
+			requestID: aws.String("sir-123"),
+			csirerr:   errors.New("denied"),
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId:            aws.String("i-123"),
+					SpotInstanceRequestId: tt.requestID,
+				},
+				asg: &autoScalingGroup{config: AutoScalingConfig{SpotInterruptionBehavior: tt.behavior}},
+				region: &region{
+					name:     "us-east-1",
+					services: connections{ec2: mockEC2{csirerr: tt.csirerr}},
+				},
+			}
+			if err := i.cancelSpotInstanceRequest(); (err != nil) != tt.wantErr {
+				t.Errorf("cancelSpotInstanceRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadSpotInterruptionBehavior(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected string
+	}{
+		{name: "no tag", expected: TerminateInterruptionBehavior},
+		{name: "valid tag", tagValue: aws.String(HibernateInterruptionBehavior), expected: HibernateInterruptionBehavior},
+		{name: "invalid tag", tagValue: aws.String("pause"), expected: TerminateInterruptionBehavior},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+					SpotInterruptionBehavior: TerminateInterruptionBehavior,
+				}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(SpotInterruptionBehaviorTag), Value: tt.tagValue}}
+			}
+
+			a.loadSpotInterruptionBehavior()
+
+			if a.config.SpotInterruptionBehavior != tt.expected {
+				t.Errorf("SpotInterruptionBehavior = %q, expected %q", a.config.SpotInterruptionBehavior, tt.expected)
+			}
+		})
+	}
+}
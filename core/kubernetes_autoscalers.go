@@ -0,0 +1,133 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// IgnoreKubernetesAutoscalerPolicy replaces the instances of the groups
+	// managed by Kubernetes autoscalers like those of any other group
+	IgnoreKubernetesAutoscalerPolicy = "ignore"
+
+	// SkipKubernetesAutoscalerPolicy leaves the groups managed by Kubernetes
+	// autoscalers to them, without replacing any of their instances
+	SkipKubernetesAutoscalerPolicy = "skip"
+
+	// ReportOnlyKubernetesAutoscalerPolicy only reports the instances which
+	// would be replaced in the groups managed by Kubernetes autoscalers
+	ReportOnlyKubernetesAutoscalerPolicy = "report-only"
+
+	// CoordinateKubernetesAutoscalerPolicy replaces the instances of the groups
+	// managed by Kubernetes autoscalers, carrying over to the spot instances
+	// the scale-in protection and the Kubernetes tags of the instances they
+	// replace, which the autoscalers rely on
+	CoordinateKubernetesAutoscalerPolicy = "coordinate"
+
+	clusterAutoscaler = "cluster-autoscaler"
+	karpenter         = "karpenter"
+)
+
+// the prefixes of the tag keys used by the Kubernetes autoscalers for
+// discovering the node groups they manage
+var kubernetesAutoscalerTagPrefixes = map[string]string{
+	"k8s.io/cluster-autoscaler/": clusterAutoscaler,
+	"karpenter.sh/":              karpenter,
+}
+
+// the prefixes of the tag keys carrying the Kubernetes node labels, taints
+// and cluster membership
+var kubernetesTagPrefixes = []string{"k8s.io/", "kubernetes.io/", "karpenter.sh/"}
+
+func isValidKubernetesAutoscalerPolicy(policy string) bool {
+	switch policy {
+	case IgnoreKubernetesAutoscalerPolicy, SkipKubernetesAutoscalerPolicy,
+		ReportOnlyKubernetesAutoscalerPolicy, CoordinateKubernetesAutoscalerPolicy:
+		return true
+	}
+	return false
+}
+
+func isKubernetesTagKey(key string) bool {
+	for _, prefix := range kubernetesTagPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// kubernetesAutoscaler returns the name of the Kubernetes autoscaler managing
+// the group, as detected from its tags, or an empty string when there's none.
+func (a *autoScalingGroup) kubernetesAutoscaler() string {
+	if a == nil || a.Group == nil {
+		return ""
+	}
+	for _, tag := range a.Tags {
+		for prefix, autoscaler := range kubernetesAutoscalerTagPrefixes {
+			if strings.HasPrefix(aws.StringValue(tag.Key), prefix) {
+				return autoscaler
+			}
+		}
+	}
+	return ""
+}
+
+// kubernetesAutoscalerPolicy returns the policy applied to the group, which is
+// only relevant when the group is managed by a Kubernetes autoscaler.
+func (a *autoScalingGroup) kubernetesAutoscalerPolicy() string {
+	if a.kubernetesAutoscaler() == "" {
+		return IgnoreKubernetesAutoscalerPolicy
+	}
+	return a.config.KubernetesAutoscalerPolicy
+}
+
+// isBlockedByKubernetesAutoscaler tells whether the instances of the group are
+// left to the Kubernetes autoscaler managing it.
+func (a *autoScalingGroup) isBlockedByKubernetesAutoscaler() bool {
+	switch a.kubernetesAutoscalerPolicy() {
+	case SkipKubernetesAutoscalerPolicy, ReportOnlyKubernetesAutoscalerPolicy:
+		return true
+	}
+	return false
+}
+
+// kubernetesAutoscalerAllowsReplacement checks whether the on-demand instance
+// can be replaced according to the policy configured for the groups managed
+// by Kubernetes autoscalers, reporting the replacement instead when the group
+// is in report-only mode.
+func (a *autoScalingGroup) kubernetesAutoscalerAllowsReplacement(i *instance) bool {
+	autoscaler := a.kubernetesAutoscaler()
+
+	switch a.kubernetesAutoscalerPolicy() {
+	case SkipKubernetesAutoscalerPolicy:
+		log.Printf("%s Not replacing on-demand instance %s, the group is managed by %s",
+			a.name, aws.StringValue(i.InstanceId), autoscaler)
+		return false
+	case ReportOnlyKubernetesAutoscalerPolicy:
+		log.Printf("%s Would replace on-demand instance %s, the group is managed by %s",
+			a.name, aws.StringValue(i.InstanceId), autoscaler)
+		recapText := fmt.Sprintf("%s Would replace on-demand instance %s managed by %s [report-only]",
+			a.name, aws.StringValue(i.InstanceId), autoscaler)
+		a.region.conf.addRecap(a.region.name, recapText)
+		return false
+	}
+	return true
+}
+
+// scaleInProtection returns the scale-in protection mode of the group, which
+// mirrors the protection of the replaced instances when coordinating with a
+// Kubernetes autoscaler, unless configured otherwise.
+func (a *autoScalingGroup) scaleInProtection() string {
+	if a.config.ScaleInProtection == NoScaleInProtection &&
+		a.kubernetesAutoscalerPolicy() == CoordinateKubernetesAutoscalerPolicy {
+		return InstanceScaleInProtection
+	}
+	return a.config.ScaleInProtection
+}
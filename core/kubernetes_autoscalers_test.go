@@ -0,0 +1,259 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_kubernetesAutoscaler(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []*autoscaling.TagDescription
+		want string
+	}{
+		{
+			name: "no tags",
+		},
+		{
+			name: "other tags",
+			tags: []*autoscaling.TagDescription{{Key: aws.String("k8s.io/role"), Value: aws.String("worker")}},
+		},
+		{
+			name: "cluster autoscaler",
+			tags: []*autoscaling.TagDescription{{Key: aws.String("k8s.io/cluster-autoscaler/enabled"), Value: aws.String("true")}},
+			want: clusterAutoscaler,
+		},
+		{
+			name: "karpenter",
+			tags: []*autoscaling.TagDescription{{Key: aws.String("karpenter.sh/discovery"), Value: aws.String("cluster")}},
+			want: karpenter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{Group: &autoscaling.Group{Tags: tt.tags}}
+			if got := a.kubernetesAutoscaler(); got != tt.want {
+				t.Errorf("kubernetesAutoscaler() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_kubernetesAutoscalerAllowsReplacement(t *testing.T) {
+	managed := []*autoscaling.TagDescription{{Key: aws.String("k8s.io/cluster-autoscaler/enabled"), Value: aws.String("true")}}
+
+	tests := []struct {
+		name        string
+		tags        []*autoscaling.TagDescription
+		policy      string
+		want        bool
+		wantBlocked bool
+		wantRecap   []string
+	}{
+		{
+			name:   "unmanaged group",
+			policy: SkipKubernetesAutoscalerPolicy,
+			want:   true,
+		},
+		{
+			name:   "ignore",
+			tags:   managed,
+			policy: IgnoreKubernetesAutoscalerPolicy,
+			want:   true,
+		},
+		{
+			name:        "skip",
+			tags:        managed,
+			policy:      SkipKubernetesAutoscalerPolicy,
+			wantBlocked: true,
+		},
+		{
+			name:        "report-only",
+			tags:        managed,
+			policy:      ReportOnlyKubernetesAutoscalerPolicy,
+			wantBlocked: true,
+			wantRecap:   []string{"asg Would replace on-demand instance i-od managed by cluster-autoscaler [report-only]"},
+		},
+		{
+			name:   "coordinate",
+			tags:   managed,
+			policy: CoordinateKubernetesAutoscalerPolicy,
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:   "asg",
+				Group:  &autoscaling.Group{Tags: tt.tags},
+				config: AutoScalingConfig{KubernetesAutoscalerPolicy: tt.policy},
+				region: &region{
+					name: "us-east-1",
+					conf: &Config{FinalRecap: map[string][]string{}},
+				},
+			}
+			i := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-od")}, asg: a}
+
+			if got := a.kubernetesAutoscalerAllowsReplacement(i); got != tt.want {
+				t.Errorf("kubernetesAutoscalerAllowsReplacement() = %v, want %v", got, tt.want)
+			}
+			if got := a.isBlockedByKubernetesAutoscaler(); got != tt.wantBlocked {
+				t.Errorf("isBlockedByKubernetesAutoscaler() = %v, want %v", got, tt.wantBlocked)
+			}
+			if recap := a.region.conf.FinalRecap["us-east-1"]; !reflect.DeepEqual(recap, tt.wantRecap) {
+				t.Errorf("kubernetesAutoscalerAllowsReplacement() recap = %v, want %v", recap, tt.wantRecap)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_scaleInProtection(t *testing.T) {
+	managed := []*autoscaling.TagDescription{{Key: aws.String("karpenter.sh/discovery"), Value: aws.String("cluster")}}
+
+	tests := []struct {
+		name       string
+		tags       []*autoscaling.TagDescription
+		policy     string
+		protection string
+		want       string
+	}{
+		{
+			name:       "unmanaged group",
+			policy:     CoordinateKubernetesAutoscalerPolicy,
+			protection: NoScaleInProtection,
+			want:       NoScaleInProtection,
+		},
+		{
+			name:       "coordinating",
+			tags:       managed,
+			policy:     CoordinateKubernetesAutoscalerPolicy,
+			protection: NoScaleInProtection,
+			want:       InstanceScaleInProtection,
+		},
+		{
+			name:       "coordinating with a configured protection",
+			tags:       managed,
+			policy:     CoordinateKubernetesAutoscalerPolicy,
+			protection: GroupScaleInProtection,
+			want:       GroupScaleInProtection,
+		},
+		{
+			name:       "not coordinating",
+			tags:       managed,
+			policy:     IgnoreKubernetesAutoscalerPolicy,
+			protection: NoScaleInProtection,
+			want:       NoScaleInProtection,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.tags},
+				config: AutoScalingConfig{
+					KubernetesAutoscalerPolicy: tt.policy,
+					ScaleInProtection:          tt.protection,
+				},
+			}
+			if got := a.scaleInProtection(); got != tt.want {
+				t.Errorf("scaleInProtection() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_generateTagsList_kubernetesTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   map[string]string
+	}{
+		{
+			name:   "coordinating",
+			policy: CoordinateKubernetesAutoscalerPolicy,
+			want: map[string]string{
+				"k8s.io/cluster-autoscaler/enabled": "true",
+				"kubernetes.io/cluster/prod":        "owned",
+			},
+		},
+		{
+			name:   "not coordinating",
+			policy: IgnoreKubernetesAutoscalerPolicy,
+			want: map[string]string{
+				"kubernetes.io/cluster/prod": "owned",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-od"),
+					Tags: []*ec2.Tag{
+						{Key: aws.String("kubernetes.io/cluster/prod"), Value: aws.String("owned")},
+					},
+				},
+				asg: &autoScalingGroup{
+					name: "asg",
+					Group: &autoscaling.Group{
+						Tags: []*autoscaling.TagDescription{{
+							Key:               aws.String("k8s.io/cluster-autoscaler/enabled"),
+							Value:             aws.String("true"),
+							PropagateAtLaunch: aws.Bool(false),
+						}},
+					},
+					config: AutoScalingConfig{KubernetesAutoscalerPolicy: tt.policy},
+				},
+			}
+
+			got := make(map[string]string)
+			for _, tag := range i.generateTagsList()[0].Tags {
+				if isKubernetesTagKey(aws.StringValue(tag.Key)) {
+					got[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("generateTagsList() Kubernetes tags = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadKubernetesAutoscalerPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected string
+	}{
+		{name: "no tag", expected: IgnoreKubernetesAutoscalerPolicy},
+		{name: "skip", tagValue: aws.String(SkipKubernetesAutoscalerPolicy), expected: SkipKubernetesAutoscalerPolicy},
+		{name: "report-only", tagValue: aws.String(ReportOnlyKubernetesAutoscalerPolicy), expected: ReportOnlyKubernetesAutoscalerPolicy},
+		{name: "coordinate", tagValue: aws.String(CoordinateKubernetesAutoscalerPolicy), expected: CoordinateKubernetesAutoscalerPolicy},
+		{name: "invalid tag", tagValue: aws.String("fight"), expected: IgnoreKubernetesAutoscalerPolicy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+					KubernetesAutoscalerPolicy: IgnoreKubernetesAutoscalerPolicy,
+				}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(KubernetesAutoscalerPolicyTag), Value: tt.tagValue}}
+			}
+
+			a.loadKubernetesAutoscalerPolicy()
+
+			if a.config.KubernetesAutoscalerPolicy != tt.expected {
+				t.Errorf("KubernetesAutoscalerPolicy = %s, expected %s", a.config.KubernetesAutoscalerPolicy, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// KubernetesLeaderElection uses a coordination.k8s.io/v1 Lease object for
+	// electing the active replica when running inside a Kubernetes cluster.
+	KubernetesLeaderElection = "kubernetes"
+
+	// DefaultLeaderElectionLeaseName is the default name of the Lease object
+	DefaultLeaderElectionLeaseName = "autospotting"
+
+	// DefaultLeaderElectionLeaseDuration is how long a Lease is valid after
+	// being renewed by its holder.
+	DefaultLeaderElectionLeaseDuration = 30 * time.Second
+
+	// MinLeaderElectionLeaseDuration is the shortest supported Lease duration,
+	// which is stored in whole seconds and renewed three times as often
+	MinLeaderElectionLeaseDuration = 5 * time.Second
+
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// the format used by the Kubernetes MicroTime type
+	kubernetesMicroTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+type kubernetesLeaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int64  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+	LeaseTransitions     *int64  `json:"leaseTransitions,omitempty"`
+}
+
+type kubernetesLeaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type kubernetesLease struct {
+	APIVersion string                  `json:"apiVersion"`
+	Kind       string                  `json:"kind"`
+	Metadata   kubernetesLeaseMetadata `json:"metadata"`
+	Spec       kubernetesLeaseSpec     `json:"spec"`
+}
+
+// kubernetesLeaderElector implements leader election on top of a Kubernetes
+// Lease, talking directly to the API server using the pod's service account.
+type kubernetesLeaderElector struct {
+	client    *http.Client
+	host      string
+	tokenFile string
+
+	namespace     string
+	name          string
+	identity      string
+	leaseDuration time.Duration
+
+	now func() time.Time
+
+	// serializes the Lease updates done by the scheduler and the renew loop
+	updating sync.Mutex
+
+	sync.Mutex
+	leading  bool
+	renewing bool
+
+	// cancelled once the leadership is lost
+	leadership     context.Context
+	stopLeadership context.CancelFunc
+}
+
+func newKubernetesLeaderElector(cfg *Config) (*kubernetesLeaderElector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running inside a Kubernetes cluster, " +
+			"KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+
+	ca, err := ioutil.ReadFile(kubernetesServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the cluster CA certificate: %s", err.Error())
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("couldn't parse the cluster CA certificate")
+	}
+
+	namespace := cfg.LeaderElectionNamespace
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(kubernetesServiceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("couldn't determine the current namespace: %s", err.Error())
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		if identity, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("couldn't determine the leader election identity: %s", err.Error())
+		}
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	return &kubernetesLeaderElector{
+		client:        client,
+		host:          "https://" + net.JoinHostPort(host, port),
+		tokenFile:     kubernetesServiceAccountDir + "/token",
+		namespace:     namespace,
+		name:          cfg.LeaderElectionLeaseName,
+		identity:      identity,
+		leaseDuration: cfg.LeaderElectionLeaseDuration,
+		now:           time.Now,
+	}, nil
+}
+
+func (k *kubernetesLeaderElector) leaseURL(withName bool) string {
+	url := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases",
+		k.host, k.namespace)
+	if withName {
+		url += "/" + k.name
+	}
+	return url
+}
+
+func (k *kubernetesLeaderElector) do(ctx context.Context, method, url string, in *kubernetesLease) (*kubernetesLease, int, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &body)
+	if err != nil {
+		return nil, 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	// the projected service account token is rotated, so we read it every time
+	if k.tokenFile != "" {
+		token, err := ioutil.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, resp.StatusCode, nil
+	}
+
+	var out kubernetesLease
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return &out, resp.StatusCode, nil
+}
+
+func (k *kubernetesLeaderElector) expired(lease *kubernetesLease) bool {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" ||
+		spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return true
+	}
+
+	renewed, err := time.Parse(kubernetesMicroTimeFormat, *spec.RenewTime)
+	if err != nil {
+		debug.Println("Couldn't parse the Lease renew time", *spec.RenewTime)
+		return true
+	}
+
+	return k.now().After(renewed.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second))
+}
+
+// tryAcquireOrRenew creates the Lease if missing, renews it if we're already
+// holding it or takes it over if it expired. It returns true if we're holding
+// the Lease after the call.
+func (k *kubernetesLeaderElector) tryAcquireOrRenew(ctx context.Context) (bool, error) {
+	now := k.now().UTC().Format(kubernetesMicroTimeFormat)
+	duration := int64(k.leaseDuration / time.Second)
+
+	current, status, err := k.do(ctx, http.MethodGet, k.leaseURL(true), nil)
+	if err != nil {
+		return false, err
+	}
+
+	if status == http.StatusNotFound {
+		lease := &kubernetesLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   kubernetesLeaseMetadata{Name: k.name, Namespace: k.namespace},
+			Spec: kubernetesLeaseSpec{
+				HolderIdentity:       &k.identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, status, err = k.do(ctx, http.MethodPost, k.leaseURL(false), lease)
+		if err != nil {
+			return false, err
+		}
+		return status == http.StatusCreated || status == http.StatusOK, nil
+	}
+
+	if current == nil {
+		return false, fmt.Errorf("unexpected status %d while reading the Lease %s/%s",
+			status, k.namespace, k.name)
+	}
+
+	holding := current.Spec.HolderIdentity != nil && *current.Spec.HolderIdentity == k.identity
+
+	if !holding && !k.expired(current) {
+		debug.Println("Lease", k.name, "is held by", *current.Spec.HolderIdentity)
+		return false, nil
+	}
+
+	if !holding {
+		transitions := int64(1)
+		if current.Spec.LeaseTransitions != nil {
+			transitions += *current.Spec.LeaseTransitions
+		}
+		current.Spec.LeaseTransitions = &transitions
+		current.Spec.AcquireTime = &now
+		current.Spec.HolderIdentity = &k.identity
+	}
+	current.Spec.RenewTime = &now
+	current.Spec.LeaseDurationSeconds = &duration
+
+	// the resourceVersion from the GET makes this update fail with a conflict
+	// if another replica updated the Lease in the meantime
+	_, status, err = k.do(ctx, http.MethodPut, k.leaseURL(true), current)
+	if err != nil {
+		return false, err
+	}
+	return status == http.StatusOK, nil
+}
+
+func (k *kubernetesLeaderElector) update(ctx context.Context) bool {
+	k.updating.Lock()
+	defer k.updating.Unlock()
+
+	leading, err := k.tryAcquireOrRenew(ctx)
+	if err != nil {
+		log.Println("Failed to acquire or renew the leader election Lease:", err.Error())
+		leading = false
+	}
+
+	k.Lock()
+	defer k.Unlock()
+	if leading != k.leading {
+		log.Printf("Leader election: %s leading=%t", k.identity, leading)
+	}
+	k.leading = leading
+
+	switch {
+	case leading && k.stopLeadership == nil:
+		k.leadership, k.stopLeadership = context.WithCancel(context.Background())
+	case !leading && k.stopLeadership != nil:
+		k.stopLeadership()
+		k.stopLeadership = nil
+	}
+	return leading
+}
+
+// isLeader acquires or renews the Lease and keeps renewing it in the
+// background, so that the leadership isn't lost during long runs. The returned
+// context is cancelled by the renewals failing to keep the Lease or stopping
+// along with the daemon, so that the run stops taking actions.
+func (k *kubernetesLeaderElector) isLeader(ctx context.Context) (context.Context, bool) {
+	k.Lock()
+	if !k.renewing {
+		k.renewing = true
+		go k.renewLoop(ctx)
+	}
+	k.Unlock()
+
+	if !k.update(ctx) {
+		return nil, false
+	}
+
+	// the renew loop may have lost the leadership in the meantime
+	k.Lock()
+	defer k.Unlock()
+	return k.leadership, k.leading
+}
+
+func (k *kubernetesLeaderElector) renewLoop(ctx context.Context) {
+	ticker := time.NewTicker(k.leaseDuration / 3)
+	defer ticker.Stop()
+	defer k.stopRenewing()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.update(ctx)
+		}
+	}
+}
+
+// stopRenewing cancels the leadership of the run in progress once the Lease
+// isn't renewed anymore, since another replica takes it over after it expires.
+// The Lease itself is still held until it's released or expires.
+func (k *kubernetesLeaderElector) stopRenewing() {
+	k.Lock()
+	defer k.Unlock()
+
+	k.renewing = false
+	if k.stopLeadership != nil {
+		k.stopLeadership()
+		k.stopLeadership = nil
+	}
+}
+
+// release clears the holder of the Lease so that another replica can take over
+// without waiting for it to expire.
+func (k *kubernetesLeaderElector) release() {
+	k.Lock()
+	leading := k.leading
+	k.leading = false
+	if k.stopLeadership != nil {
+		k.stopLeadership()
+		k.stopLeadership = nil
+	}
+	k.Unlock()
+
+	if !leading {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	current, _, err := k.do(ctx, http.MethodGet, k.leaseURL(true), nil)
+	if err != nil || current == nil {
+		log.Println("Couldn't read the leader election Lease while releasing it")
+		return
+	}
+
+	if current.Spec.HolderIdentity == nil || *current.Spec.HolderIdentity != k.identity {
+		return
+	}
+
+	empty := ""
+	current.Spec.HolderIdentity = &empty
+	if _, status, err := k.do(ctx, http.MethodPut, k.leaseURL(true), current); err != nil || status != http.StatusOK {
+		log.Println("Couldn't release the leader election Lease")
+		return
+	}
+	log.Println("Released the leader election Lease", k.name)
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// fakeLeaseServer is a minimal in-memory implementation of the Kubernetes Lease
+// API, including the resourceVersion based optimistic concurrency.
+type fakeLeaseServer struct {
+	sync.Mutex
+	lease   *kubernetesLease
+	version int
+}
+
+func (f *fakeLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if f.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.lease)
+	case http.MethodPost:
+		if f.lease != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		var l kubernetesLease
+		json.NewDecoder(r.Body).Decode(&l)
+		f.store(&l)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f.lease)
+	case http.MethodPut:
+		var l kubernetesLease
+		json.NewDecoder(r.Body).Decode(&l)
+		if f.lease == nil || l.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.store(&l)
+		json.NewEncoder(w).Encode(f.lease)
+	}
+}
+
+func (f *fakeLeaseServer) store(l *kubernetesLease) {
+	f.version++
+	l.Metadata.ResourceVersion = strconv.Itoa(f.version)
+	f.lease = l
+}
+
+func (f *fakeLeaseServer) holder() string {
+	f.Lock()
+	defer f.Unlock()
+	if f.lease == nil || f.lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *f.lease.Spec.HolderIdentity
+}
+
+func newTestLeaderElector(url, identity string, now func() time.Time) *kubernetesLeaderElector {
+	return &kubernetesLeaderElector{
+		client:        http.DefaultClient,
+		host:          url,
+		namespace:     "default",
+		name:          "autospotting",
+		identity:      identity,
+		leaseDuration: 30 * time.Second,
+		now:           now,
+	}
+}
+
+func Test_kubernetesLeaderElector_tryAcquireOrRenew(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	a := newTestLeaderElector(srv.URL, "pod-a", clock)
+	b := newTestLeaderElector(srv.URL, "pod-b", clock)
+	ctx := context.Background()
+
+	if leading, err := a.tryAcquireOrRenew(ctx); !leading || err != nil {
+		t.Fatalf("pod-a should create and acquire the missing Lease, got %t, %v", leading, err)
+	}
+
+	if leading, err := b.tryAcquireOrRenew(ctx); leading || err != nil {
+		t.Errorf("pod-b shouldn't acquire a Lease held by pod-a, got %t, %v", leading, err)
+	}
+
+	if leading, err := a.tryAcquireOrRenew(ctx); !leading || err != nil {
+		t.Errorf("pod-a should renew its own Lease, got %t, %v", leading, err)
+	}
+
+	// pod-a stops renewing and its Lease expires
+	now = now.Add(time.Minute)
+
+	if leading, err := b.tryAcquireOrRenew(ctx); !leading || err != nil {
+		t.Errorf("pod-b should take over the expired Lease, got %t, %v", leading, err)
+	}
+
+	if got := fake.holder(); got != "pod-b" {
+		t.Errorf("Lease holder is %s, expected pod-b", got)
+	}
+
+	if transitions := fake.lease.Spec.LeaseTransitions; transitions == nil || *transitions != 1 {
+		t.Errorf("expected the Lease transitions to be counted")
+	}
+}
+
+func Test_kubernetesLeaderElector_lostLeadership(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	a := newTestLeaderElector(srv.URL, "pod-a", clock)
+	b := newTestLeaderElector(srv.URL, "pod-b", clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leadership, leading := a.isLeader(ctx)
+	if !leading || leadership.Err() != nil {
+		t.Fatalf("pod-a should acquire the Lease, got %t, %v", leading, leadership.Err())
+	}
+
+	// pod-a fails to renew the Lease in time and pod-b takes it over
+	now = now.Add(time.Minute)
+	if _, leading := b.isLeader(ctx); !leading {
+		t.Fatalf("pod-b should take over the expired Lease")
+	}
+
+	if a.update(ctx) {
+		t.Fatalf("pod-a shouldn't renew a Lease held by pod-b")
+	}
+	if leadership.Err() == nil {
+		t.Errorf("the leadership context of pod-a should be cancelled once the Lease is lost")
+	}
+}
+
+func Test_kubernetesLeaderElector_stopRenewing(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	a := newTestLeaderElector(srv.URL, "pod-a", time.Now)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leadership, leading := a.isLeader(ctx)
+	if !leading {
+		t.Fatalf("pod-a should acquire the Lease")
+	}
+
+	// the daemon stops, while its last run may still be in progress
+	cancel()
+
+	select {
+	case <-leadership.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the leadership context should be cancelled once the Lease isn't renewed anymore")
+	}
+
+	// the Lease is still released on shutdown
+	a.release()
+	if got := fake.holder(); got != "" {
+		t.Errorf("Lease holder is %s after release, expected none", got)
+	}
+}
+
+func Test_kubernetesLeaderElector_release(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	a := newTestLeaderElector(srv.URL, "pod-a", time.Now)
+	b := newTestLeaderElector(srv.URL, "pod-b", time.Now)
+
+	if !a.update(context.Background()) {
+		t.Fatalf("pod-a should acquire the Lease")
+	}
+
+	a.release()
+
+	if got := fake.holder(); got != "" {
+		t.Errorf("Lease holder is %s after release, expected none", got)
+	}
+
+	if leading, err := b.tryAcquireOrRenew(context.Background()); !leading || err != nil {
+		t.Errorf("pod-b should acquire a released Lease right away, got %t, %v", leading, err)
+	}
+}
+
+func Test_kubernetesLeaderElector_expired(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	k := newTestLeaderElector("", "pod-a", func() time.Time { return now })
+
+	tests := []struct {
+		name string
+		spec kubernetesLeaseSpec
+		want bool
+	}{
+		{
+			name: "no holder",
+			spec: kubernetesLeaseSpec{},
+			want: true,
+		},
+		{
+			name: "recently renewed",
+			spec: kubernetesLeaseSpec{
+				HolderIdentity:       aws.String("pod-b"),
+				LeaseDurationSeconds: aws.Int64(30),
+				RenewTime:            aws.String(now.Add(-10 * time.Second).Format(kubernetesMicroTimeFormat)),
+			},
+			want: false,
+		},
+		{
+			name: "renewed too long ago",
+			spec: kubernetesLeaseSpec{
+				HolderIdentity:       aws.String("pod-b"),
+				LeaseDurationSeconds: aws.Int64(30),
+				RenewTime:            aws.String(now.Add(-time.Minute).Format(kubernetesMicroTimeFormat)),
+			},
+			want: true,
+		},
+		{
+			name: "invalid renew time",
+			spec: kubernetesLeaseSpec{
+				HolderIdentity:       aws.String("pod-b"),
+				LeaseDurationSeconds: aws.Int64(30),
+				RenewTime:            aws.String("yesterday"),
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := k.expired(&kubernetesLease{Spec: tt.spec}); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// launchFailureReason is the category of the errors returned when launching
+// spot instances, so that quota or permission issues can be told apart from
+// the usual lack of spot capacity.
+type launchFailureReason string
+
+const (
+	capacityLaunchFailure   launchFailureReason = "capacity"
+	priceLaunchFailure      launchFailureReason = "price"
+	quotaLaunchFailure      launchFailureReason = "quota"
+	permissionLaunchFailure launchFailureReason = "permission"
+	imageLaunchFailure      launchFailureReason = "ami"
+	otherLaunchFailure      launchFailureReason = "other"
+)
+
+// launchFailureErrorCodes maps the EC2 error codes, or parts of them, to the
+// category of the launch failure.
+var launchFailureErrorCodes = []struct {
+	code   string
+	reason launchFailureReason
+}{
+	{"InsufficientInstanceCapacity", capacityLaunchFailure},
+	{"InsufficientHostCapacity", capacityLaunchFailure},
+	{"InsufficientCapacity", capacityLaunchFailure},
+	{"InsufficientReservedInstanceCapacity", capacityLaunchFailure},
+	{"SpotMaxPriceTooLow", priceLaunchFailure},
+	{"MaxSpotInstanceCountExceeded", quotaLaunchFailure},
+	{"InstanceLimitExceeded", quotaLaunchFailure},
+	{"VcpuLimitExceeded", quotaLaunchFailure},
+	{"UnauthorizedOperation", permissionLaunchFailure},
+	{"AccessDenied", permissionLaunchFailure},
+	{"AuthFailure", permissionLaunchFailure},
+	{"InvalidAMIID", imageLaunchFailure},
+}
+
+// launchError is returned when no spot instance could be launched, recording
+// the category of the last launch failure.
+type launchError struct {
+	reason launchFailureReason
+	err    error
+}
+
+func (e *launchError) Error() string {
+	return fmt.Sprintf("%s [%s]", e.err.Error(), e.reason)
+}
+
+func (e *launchError) Unwrap() error {
+	return e.err
+}
+
+// Is matches the launch error with the sentinel error of its category.
+func (e *launchError) Is(target error) bool {
+	switch e.reason {
+	case capacityLaunchFailure, priceLaunchFailure:
+		return target == ErrNoCapacity
+	case quotaLaunchFailure:
+		return target == ErrQuotaExceeded
+	case permissionLaunchFailure:
+		return target == ErrPermissionDenied
+	case imageLaunchFailure:
+		return target == ErrInvalidImage
+	}
+	return false
+}
+
+// classifyLaunchError determines the category of an error returned when
+// launching spot instances, based on its EC2 error code.
+func classifyLaunchError(err error) launchFailureReason {
+	code := err.Error()
+	if aerr, ok := err.(awserr.Error); ok {
+		code = aerr.Code()
+	}
+
+	for _, c := range launchFailureErrorCodes {
+		if strings.Contains(code, c.code) {
+			return c.reason
+		}
+	}
+	return otherLaunchFailure
+}
+
+// launchFailures counts the launch failures of the current run by category.
+type launchFailures struct {
+	sync.Mutex
+	counts map[launchFailureReason]int64
+}
+
+func newLaunchFailures() *launchFailures {
+	return &launchFailures{counts: make(map[launchFailureReason]int64)}
+}
+
+func (l *launchFailures) record(reason launchFailureReason) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.counts[reason]++
+}
+
+// String summarizes the launch failures, sorted by category.
+func (l *launchFailures) String() string {
+	if l == nil {
+		return ""
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	var summary []string
+	for reason, count := range l.counts {
+		summary = append(summary, fmt.Sprintf("%s=%d", reason, count))
+	}
+	sort.Strings(summary)
+	return strings.Join(summary, " ")
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func Test_classifyLaunchError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected launchFailureReason
+	}{
+		{
+			name:     "capacity",
+			err:      awserr.New("InsufficientInstanceCapacity", "We currently do not have sufficient capacity", nil),
+			expected: capacityLaunchFailure,
+		},
+		{
+			name:     "price",
+			err:      awserr.New("SpotMaxPriceTooLow", "Your Spot request price is lower than the minimum", nil),
+			expected: priceLaunchFailure,
+		},
+		{
+			name:     "quota",
+			err:      awserr.New("MaxSpotInstanceCountExceeded", "Max spot instance count exceeded", nil),
+			expected: quotaLaunchFailure,
+		},
+		{
+			name:     "vCPU quota",
+			err:      awserr.New("VcpuLimitExceeded", "You have requested more vCPU capacity", nil),
+			expected: quotaLaunchFailure,
+		},
+		{
+			name:     "permission",
+			err:      awserr.New("UnauthorizedOperation", "You are not authorized to perform this operation", nil),
+			expected: permissionLaunchFailure,
+		},
+		{
+			name:     "AMI",
+			err:      awserr.New("InvalidAMIID.NotFound", "The image id does not exist", nil),
+			expected: imageLaunchFailure,
+		},
+		{
+			name:     "message mentioning another category",
+			err:      awserr.New("InvalidParameterValue", "InsufficientInstanceCapacity", nil),
+			expected: otherLaunchFailure,
+		},
+		{
+			name:     "plain error",
+			err:      errors.New("InsufficientInstanceCapacity: no capacity"),
+			expected: capacityLaunchFailure,
+		},
+		{
+			name:     "unknown error",
+			err:      errors.New("RequestLimitExceeded"),
+			expected: otherLaunchFailure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyLaunchError(tt.err); got != tt.expected {
+				t.Errorf("classifyLaunchError() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_launchError(t *testing.T) {
+	cause := errors.New("VcpuLimitExceeded")
+	err := error(&launchError{reason: quotaLaunchFailure, err: cause})
+
+	if err.Error() != "VcpuLimitExceeded [quota]" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("launchError doesn't wrap its cause")
+	}
+
+	var le *launchError
+	if !errors.As(err, &le) || le.reason != quotaLaunchFailure {
+		t.Errorf("launchError reason not available, got %v", le)
+	}
+
+	if !errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrNoCapacity) {
+		t.Errorf("launchError doesn't match the sentinel error of its category")
+	}
+}
+
+func Test_launchFailures(t *testing.T) {
+	var disabled *launchFailures
+	disabled.record(capacityLaunchFailure)
+	if disabled.String() != "" {
+		t.Errorf("nil launchFailures String() = %q, expected empty", disabled.String())
+	}
+
+	l := newLaunchFailures()
+	for _, r := range []launchFailureReason{
+		quotaLaunchFailure, capacityLaunchFailure, capacityLaunchFailure, imageLaunchFailure,
+	} {
+		l.record(r)
+	}
+
+	if got := l.String(); got != "ami=1 capacity=2 quota=1" {
+		t.Errorf("String() = %q, expected %q", got, "ami=1 capacity=2 quota=1")
+	}
+}
@@ -4,12 +4,21 @@
 package autospotting
 
 import (
+	"fmt"
 	"log"
+	"strconv"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
 )
 
+// ssmImageAliasPrefix prefixes the AMI IDs of the launch templates which
+// reference the AMI using a SSM parameter, such as
+// resolve:ssm:/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2
+const ssmImageAliasPrefix = "resolve:ssm:"
+
 type launchTemplate struct {
 	*ec2.LaunchTemplateVersion
 	*ec2.Image
@@ -34,3 +43,118 @@ func (lt *launchTemplate) countLaunchTemplateEphemeralVolumes() int {
 
 	return count
 }
+
+// describeLaunchTemplateVersion returns the given version of a launch template,
+// cached for the rest of the run. Symbolic versions such as $Latest and
+// $Default are resolved only once, so that all the spot instances launched
+// during the run use the same version even if the template is updated
+// meanwhile.
+func (r *region) describeLaunchTemplateVersion(id, version *string) (*ec2.LaunchTemplateVersion, error) {
+	key := aws.StringValue(id) + ":" + aws.StringValue(version)
+
+	r.launchTemplateVersionsLock.Lock()
+	defer r.launchTemplateVersionsLock.Unlock()
+
+	if ltv, ok := r.launchTemplateVersions[key]; ok {
+		return ltv, nil
+	}
+
+	resp, err := r.services.ec2.DescribeLaunchTemplateVersions(
+		&ec2.DescribeLaunchTemplateVersionsInput{
+			LaunchTemplateId: id,
+			Versions:         []*string{version},
+		})
+
+	if err != nil {
+		log.Println("Failed to describe launch template", aws.StringValue(id), "version",
+			aws.StringValue(version), "encountered error:", err.Error())
+		return nil, err
+	}
+
+	if resp == nil || len(resp.LaunchTemplateVersions) == 0 {
+		return nil, ErrMissingLaunchTemplate
+	}
+
+	ltv := resp.LaunchTemplateVersions[0]
+
+	if r.launchTemplateVersions == nil {
+		r.launchTemplateVersions = make(map[string]*ec2.LaunchTemplateVersion)
+	}
+	r.launchTemplateVersions[key] = ltv
+
+	if ltv.VersionNumber != nil {
+		resolved := launchTemplateVersionNumber(ltv, version)
+		debug.Println("Resolved launch template", aws.StringValue(id), "version",
+			aws.StringValue(version), "to", aws.StringValue(resolved))
+		r.launchTemplateVersions[aws.StringValue(id)+":"+aws.StringValue(resolved)] = ltv
+	}
+
+	return ltv, nil
+}
+
+// launchTemplateVersionNumber returns the concrete version number of the
+// launch template version, or the given version if it's unknown.
+func launchTemplateVersionNumber(ltv *ec2.LaunchTemplateVersion, version *string) *string {
+	if ltv == nil || ltv.VersionNumber == nil {
+		return version
+	}
+	return aws.String(strconv.FormatInt(*ltv.VersionNumber, 10))
+}
+
+// launchTemplateVersion returns the concrete version of the launch template of
+// the instance's group, resolving symbolic versions such as $Latest and
+// $Default.
+func (i *instance) launchTemplateVersion() *string {
+	lt := i.asg.LaunchTemplate
+	if lt == nil {
+		return nil
+	}
+
+	ltv, err := i.region.describeLaunchTemplateVersion(lt.LaunchTemplateId, lt.Version)
+	if err != nil {
+		return lt.Version
+	}
+	return launchTemplateVersionNumber(ltv, lt.Version)
+}
+
+// resolveImageID returns the AMI ID referenced by a launch template, resolving
+// the SSM parameter aliases to the AMI ID currently stored in the parameter,
+// the same way the AutoScaling group does when launching instances. Aliases
+// are resolved once per run, so that all the spot instances launched during
+// the run use the same AMI even if the parameter is updated meanwhile.
+func (r *region) resolveImageID(imageID *string) (*string, error) {
+	parameter := strings.TrimPrefix(aws.StringValue(imageID), ssmImageAliasPrefix)
+	if parameter == aws.StringValue(imageID) {
+		return imageID, nil
+	}
+
+	r.imageAliasesLock.Lock()
+	defer r.imageAliasesLock.Unlock()
+
+	if resolved, ok := r.imageAliases[parameter]; ok {
+		return aws.String(resolved), nil
+	}
+
+	resp, err := r.services.ssm.GetParameter(&ssm.GetParameterInput{
+		Name: aws.String(parameter),
+	})
+	if err != nil {
+		log.Println("Failed to resolve the AMI alias", aws.StringValue(imageID),
+			"encountered error:", err.Error())
+		return nil, err
+	}
+
+	if resp == nil || resp.Parameter == nil || aws.StringValue(resp.Parameter.Value) == "" {
+		return nil, fmt.Errorf("%w AMI alias %s", ErrMissingLaunchTemplate, aws.StringValue(imageID))
+	}
+
+	resolved := aws.StringValue(resp.Parameter.Value)
+	debug.Println("Resolved the AMI alias", aws.StringValue(imageID), "to", resolved)
+
+	if r.imageAliases == nil {
+		r.imageAliases = make(map[string]string)
+	}
+	r.imageAliases[parameter] = resolved
+
+	return aws.String(resolved), nil
+}
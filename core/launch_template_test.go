@@ -4,10 +4,12 @@
 package autospotting
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
 )
 
 func Test_countLaunchTemplateEphemeralVolumes(t *testing.T) {
@@ -70,3 +72,194 @@ func Test_countLaunchTemplateEphemeralVolumes(t *testing.T) {
 		})
 	}
 }
+
+func Test_region_describeLaunchTemplateVersion(t *testing.T) {
+	ltv := &ec2.LaunchTemplateVersion{
+		LaunchTemplateId: aws.String("lt-1"),
+		VersionNumber:    aws.Int64(3),
+	}
+
+	tests := []struct {
+		name          string
+		version       string
+		cached        map[string]*ec2.LaunchTemplateVersion
+		ec2           mockEC2
+		want          *ec2.LaunchTemplateVersion
+		wantErr       bool
+		wantCacheKeys []string
+	}{
+		{
+			name:    "symbolic version resolved",
+			version: "$Latest",
+			ec2: mockEC2{
+				dltvo: &ec2.DescribeLaunchTemplateVersionsOutput{
+					LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{ltv},
+				},
+			},
+			want:          ltv,
+			wantCacheKeys: []string{"lt-1:$Latest", "lt-1:3"},
+		},
+		{
+			name:    "cached version",
+			version: "$Default",
+			cached:  map[string]*ec2.LaunchTemplateVersion{"lt-1:$Default": ltv},
+			ec2: mockEC2{
+				dltverr: errors.New("unexpected call"),
+			},
+			want:          ltv,
+			wantCacheKeys: []string{"lt-1:$Default"},
+		},
+		{
+			name:    "missing version",
+			version: "$Latest",
+			ec2: mockEC2{
+				dltvo: &ec2.DescribeLaunchTemplateVersionsOutput{},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "API error isn't cached",
+			version: "$Latest",
+			ec2: mockEC2{
+				dltverr: errors.New("Throttling"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name:                   "us-east-1",
+				launchTemplateVersions: tt.cached,
+				services:               connections{ec2: tt.ec2},
+			}
+
+			got, err := r.describeLaunchTemplateVersion(aws.String("lt-1"), aws.String(tt.version))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("describeLaunchTemplateVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("describeLaunchTemplateVersion() = %v, want %v", got, tt.want)
+			}
+			if len(r.launchTemplateVersions) != len(tt.wantCacheKeys) {
+				t.Errorf("cached versions = %v, want %v", r.launchTemplateVersions, tt.wantCacheKeys)
+			}
+			for _, key := range tt.wantCacheKeys {
+				if r.launchTemplateVersions[key] != tt.want {
+					t.Errorf("cached version for %s = %v, want %v", key, r.launchTemplateVersions[key], tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_launchTemplateVersionNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		ltv     *ec2.LaunchTemplateVersion
+		version *string
+		want    string
+	}{
+		{
+			name:    "unknown version",
+			ltv:     nil,
+			version: aws.String("$Latest"),
+			want:    "$Latest",
+		},
+		{
+			name:    "missing version number",
+			ltv:     &ec2.LaunchTemplateVersion{},
+			version: aws.String("$Default"),
+			want:    "$Default",
+		},
+		{
+			name:    "resolved version number",
+			ltv:     &ec2.LaunchTemplateVersion{VersionNumber: aws.Int64(7)},
+			version: aws.String("$Latest"),
+			want:    "7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aws.StringValue(launchTemplateVersionNumber(tt.ltv, tt.version)); got != tt.want {
+				t.Errorf("launchTemplateVersionNumber() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_resolveImageID(t *testing.T) {
+	tests := []struct {
+		name          string
+		imageID       *string
+		gpo           *ssm.GetParameterOutput
+		gperr         error
+		expected      *string
+		expectedCalls int
+		wantErr       bool
+	}{
+		{
+			name:     "AMI ID",
+			imageID:  aws.String("ami-123"),
+			expected: aws.String("ami-123"),
+		},
+		{
+			name:    "no AMI ID",
+			imageID: nil,
+		},
+		{
+			name:    "SSM alias",
+			imageID: aws.String("resolve:ssm:/aws/service/ami-amazon-linux-latest/amzn2-ami-hvm-x86_64-gp2"),
+			gpo: &ssm.GetParameterOutput{
+				Parameter: &ssm.Parameter{Value: aws.String("ami-456")},
+			},
+			expected:      aws.String("ami-456"),
+			expectedCalls: 1,
+		},
+		{
+			name:          "SSM error",
+			imageID:       aws.String("resolve:ssm:/golden-ami"),
+			gperr:         errors.New("ParameterNotFound"),
+			expectedCalls: 1,
+			wantErr:       true,
+		},
+		{
+			name:          "empty SSM parameter",
+			imageID:       aws.String("resolve:ssm:/golden-ami"),
+			gpo:           &ssm.GetParameterOutput{Parameter: &ssm.Parameter{}},
+			expectedCalls: 1,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			r := &region{
+				services: connections{
+					ssm: mockSSM{gpo: tt.gpo, gperr: tt.gperr, gpcalls: &calls},
+				},
+			}
+
+			// the second call is served from the cache when the alias was
+			// resolved successfully
+			for n := 0; n < 2; n++ {
+				got, err := r.resolveImageID(tt.imageID)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("resolveImageID() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if aws.StringValue(got) != aws.StringValue(tt.expected) {
+					t.Errorf("resolveImageID() = %v, expected %v", aws.StringValue(got), aws.StringValue(tt.expected))
+				}
+			}
+
+			expectedCalls := tt.expectedCalls
+			if tt.wantErr {
+				expectedCalls *= 2
+			}
+			if calls != expectedCalls {
+				t.Errorf("resolveImageID() made %d GetParameter calls, expected %d", calls, expectedCalls)
+			}
+		})
+	}
+}
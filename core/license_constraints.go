@@ -0,0 +1,54 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import "log"
+
+const (
+	// LicenseMaxCoresTag is the name of the tag set on the AutoScaling Group
+	// that defines the maximum number of physical CPU cores of the spot
+	// instance types, for software licensed per core
+	LicenseMaxCoresTag = "autospotting_license_max_cores"
+
+	// LicenseMaxVCPUTag is the name of the tag set on the AutoScaling Group
+	// that defines the maximum number of vCPUs of the spot instance types, for
+	// software licensed per vCPU
+	LicenseMaxVCPUTag = "autospotting_license_max_vcpu"
+)
+
+// licenseConstraints limits the size of the spot instance types launched for
+// a group, so that they stay covered by the licenses brought by the users,
+// such as those of Oracle or Microsoft SQL Server.
+type licenseConstraints struct {
+	maxCores int
+	maxVCPU  int
+}
+
+func (a *autoScalingGroup) loadLicenseConstraints() {
+	a.licenseConstraints = licenseConstraints{
+		maxCores: int(a.getRequirementFromTag(LicenseMaxCoresTag)),
+		maxVCPU:  int(a.getRequirementFromTag(LicenseMaxVCPUTag)),
+	}
+
+	if a.licenseConstraints != (licenseConstraints{}) {
+		log.Printf("Loaded license constraints %+v for the group %s\n", a.licenseConstraints, a.name)
+	}
+}
+
+// isLicenseCompatible checks if the candidate instance type is covered by the
+// group's license constraints. The instance types with an unknown number of
+// cores are rejected when the cores are constrained.
+func (i *instance) isLicenseCompatible(candidate instanceTypeInformation) bool {
+	if i.asg == nil {
+		return true
+	}
+	c := i.asg.licenseConstraints
+
+	if (c.maxCores > 0 && (candidate.cores == 0 || candidate.cores > c.maxCores)) ||
+		(c.maxVCPU > 0 && candidate.vCPU > c.maxVCPU) {
+		debug.Println("\tInstance type", candidate.instanceType, "isn't covered by the license constraints", c)
+		return false
+	}
+	return true
+}
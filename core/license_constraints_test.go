@@ -0,0 +1,105 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func Test_instance_isLicenseCompatible(t *testing.T) {
+	tests := []struct {
+		name      string
+		asg       *autoScalingGroup
+		candidate instanceTypeInformation
+		expected  bool
+	}{
+		{
+			name:      "no group",
+			candidate: instanceTypeInformation{instanceType: "m5.4xlarge", vCPU: 16, cores: 8},
+			expected:  true,
+		},
+		{
+			name:      "no constraints",
+			asg:       &autoScalingGroup{},
+			candidate: instanceTypeInformation{instanceType: "m5.4xlarge", vCPU: 16, cores: 8},
+			expected:  true,
+		},
+		{
+			name:      "within the cores limit",
+			asg:       &autoScalingGroup{licenseConstraints: licenseConstraints{maxCores: 4}},
+			candidate: instanceTypeInformation{instanceType: "m5.2xlarge", vCPU: 8, cores: 4},
+			expected:  true,
+		},
+		{
+			name:      "over the cores limit",
+			asg:       &autoScalingGroup{licenseConstraints: licenseConstraints{maxCores: 4}},
+			candidate: instanceTypeInformation{instanceType: "m5.4xlarge", vCPU: 16, cores: 8},
+			expected:  false,
+		},
+		{
+			name:      "unknown cores",
+			asg:       &autoScalingGroup{licenseConstraints: licenseConstraints{maxCores: 4}},
+			candidate: instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+			expected:  false,
+		},
+		{
+			name:      "over the vCPU limit",
+			asg:       &autoScalingGroup{licenseConstraints: licenseConstraints{maxVCPU: 8}},
+			candidate: instanceTypeInformation{instanceType: "m5.4xlarge", vCPU: 16, cores: 8},
+			expected:  false,
+		},
+		{
+			name:      "within both limits",
+			asg:       &autoScalingGroup{licenseConstraints: licenseConstraints{maxCores: 8, maxVCPU: 16}},
+			candidate: instanceTypeInformation{instanceType: "m5.4xlarge", vCPU: 16, cores: 8},
+			expected:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{asg: tt.asg}
+			if got := i.isLicenseCompatible(tt.candidate); got != tt.expected {
+				t.Errorf("isLicenseCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadLicenseConstraints(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []*autoscaling.TagDescription
+		expected licenseConstraints
+	}{
+		{name: "no tags"},
+		{
+			name: "both tags",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(LicenseMaxCoresTag), Value: aws.String("4")},
+				{Key: aws.String(LicenseMaxVCPUTag), Value: aws.String("8")},
+			},
+			expected: licenseConstraints{maxCores: 4, maxVCPU: 8},
+		},
+		{
+			name: "invalid tag",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(LicenseMaxCoresTag), Value: aws.String("four")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.tags},
+			}
+			a.loadLicenseConstraints()
+			if a.licenseConstraints != tt.expected {
+				t.Errorf("loadLicenseConstraints() = %+v, expected %+v", a.licenseConstraints, tt.expected)
+			}
+		})
+	}
+}
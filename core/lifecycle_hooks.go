@@ -0,0 +1,152 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const (
+	// DefaultLifecycleHookTimeout is the default longest time AutoSpotting
+	// waits for the termination lifecycle hooks of the replaced on-demand
+	// instances to be completed by their handlers
+	DefaultLifecycleHookTimeout = 10 * time.Minute
+
+	// lifecycleHookPollInterval is how often the lifecycle state of the
+	// terminated instances is checked
+	lifecycleHookPollInterval = 10 * time.Second
+
+	// terminatingTransition is the lifecycle transition of the termination
+	// lifecycle hooks
+	terminatingTransition = "autoscaling:EC2_INSTANCE_TERMINATING"
+
+	// terminatingWaitState is the lifecycle state of the instances waiting
+	// for their termination lifecycle hooks to be completed
+	terminatingWaitState = "Terminating:Wait"
+)
+
+// isCustomTerminationHook determines if the lifecycle hook notifies a custom
+// handler, such as a Lambda function subscribed to its SNS topic or SQS queue,
+// of the instances being terminated, and expects it to complete the action.
+func isCustomTerminationHook(hook *autoscaling.LifecycleHook) bool {
+	return aws.StringValue(hook.LifecycleTransition) == terminatingTransition &&
+		aws.StringValue(hook.NotificationTargetARN) != ""
+}
+
+// loadTerminationHooks discovers the termination lifecycle hooks of the group
+// handled by custom handlers, once per run.
+func (a *autoScalingGroup) loadTerminationHooks() ([]*autoscaling.LifecycleHook, error) {
+	if a.terminationHooksLoaded {
+		return a.terminationHooks, nil
+	}
+
+	out, err := a.region.services.autoScaling.DescribeLifecycleHooks(
+		&autoscaling.DescribeLifecycleHooksInput{
+			AutoScalingGroupName: aws.String(a.name),
+		})
+	if err != nil {
+		log.Println(a.name, "Couldn't describe the lifecycle hooks of the group:", err.Error())
+		return nil, err
+	}
+
+	a.terminationHooks = nil
+	for _, hook := range out.LifecycleHooks {
+		if isCustomTerminationHook(hook) {
+			log.Printf("%s Found termination lifecycle hook %s notifying %s",
+				a.name, aws.StringValue(hook.LifecycleHookName), aws.StringValue(hook.NotificationTargetARN))
+			a.terminationHooks = append(a.terminationHooks, hook)
+		}
+	}
+	a.terminationHooksLoaded = true
+	return a.terminationHooks, nil
+}
+
+// lifecycleHookTimeout returns how long the termination of an instance may be
+// held by the hooks, which is the longest global timeout of the hooks, capped
+// by the configured timeout.
+func (a *autoScalingGroup) lifecycleHookTimeout(hooks []*autoscaling.LifecycleHook) time.Duration {
+	var timeout time.Duration
+	for _, hook := range hooks {
+		if t := time.Duration(aws.Int64Value(hook.GlobalTimeout)) * time.Second; t > timeout {
+			timeout = t
+		}
+	}
+	if timeout == 0 || timeout > a.config.LifecycleHookTimeout {
+		timeout = a.config.LifecycleHookTimeout
+	}
+	return timeout
+}
+
+// waitForTerminationHooks waits for the custom termination lifecycle hooks of
+// the group to be completed by their handlers for an instance being
+// terminated. The handlers may extend the heartbeat timeout of the hooks
+// while they're processing the instance, so the wait only times out after the
+// longest global timeout of the hooks, or the configured timeout when shorter.
+func (a *autoScalingGroup) waitForTerminationHooks(instanceID string) error {
+	if a.config.LifecycleHookTimeout <= 0 {
+		return nil
+	}
+
+	hooks, err := a.loadTerminationHooks()
+	if err != nil || len(hooks) == 0 {
+		return nil
+	}
+
+	clock := a.region.conf.getClock()
+	start := clock.Now()
+	timeout := a.lifecycleHookTimeout(hooks)
+	deadline := start.Add(timeout)
+
+	var heartbeatTimeout time.Duration
+	for _, hook := range hooks {
+		if t := time.Duration(aws.Int64Value(hook.HeartbeatTimeout)) * time.Second; t > heartbeatTimeout {
+			heartbeatTimeout = t
+		}
+	}
+	heartbeatsLogged := false
+
+	for {
+		out, err := a.region.services.autoScaling.DescribeAutoScalingInstances(
+			&autoscaling.DescribeAutoScalingInstancesInput{
+				InstanceIds: []*string{aws.String(instanceID)},
+			})
+		if err != nil {
+			log.Println(err.Error())
+		} else if len(out.AutoScalingInstances) == 0 ||
+			aws.StringValue(out.AutoScalingInstances[0].LifecycleState) != terminatingWaitState {
+			log.Printf("%s The termination lifecycle hooks of instance %s were completed after %s",
+				a.name, instanceID, clock.Now().Sub(start))
+			return nil
+		}
+
+		// the instance would have left the wait state once the heartbeat
+		// timeout expired, unless the handler recorded heartbeats
+		if elapsed := clock.Now().Sub(start); !heartbeatsLogged && heartbeatTimeout > 0 && elapsed > heartbeatTimeout {
+			log.Printf("%s The handlers of the termination lifecycle hooks of instance %s are still processing it after %s",
+				a.name, instanceID, elapsed)
+			heartbeatsLogged = true
+		}
+
+		if !clock.Now().Before(deadline) {
+			break
+		}
+		log.Printf("%s Waiting for the termination lifecycle hooks of instance %s to be completed",
+			a.name, instanceID)
+		clock.Sleep(lifecycleHookPollInterval)
+	}
+
+	log.Printf("%s The termination lifecycle hooks of instance %s weren't completed after %s",
+		a.name, instanceID, timeout)
+	recapText := fmt.Sprintf("%s Termination lifecycle hooks of instance %s not completed after %s [lifecycle hook timeout]",
+		a.name, instanceID, timeout)
+	a.region.conf.addRecap(a.region.name, recapText)
+
+	return fmt.Errorf("termination of instance %s held for over %s: %w",
+		instanceID, timeout, ErrLifecycleHookTimeout)
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func customTerminationHook(name string, heartbeat, global int64) *autoscaling.LifecycleHook {
+	return &autoscaling.LifecycleHook{
+		LifecycleHookName:     aws.String(name),
+		LifecycleTransition:   aws.String(terminatingTransition),
+		NotificationTargetARN: aws.String("arn:aws:sqs:us-east-1:123456789012:" + name),
+		HeartbeatTimeout:      aws.Int64(heartbeat),
+		GlobalTimeout:         aws.Int64(global),
+	}
+}
+
+func Test_isCustomTerminationHook(t *testing.T) {
+	tests := []struct {
+		name string
+		hook *autoscaling.LifecycleHook
+		want bool
+	}{
+		{
+			name: "termination hook with a notification target",
+			hook: customTerminationHook("drain", 300, 30000),
+			want: true,
+		},
+		{
+			name: "termination hook without a notification target",
+			hook: &autoscaling.LifecycleHook{
+				LifecycleTransition: aws.String(terminatingTransition),
+			},
+		},
+		{
+			name: "launch hook with a notification target",
+			hook: &autoscaling.LifecycleHook{
+				LifecycleTransition:   aws.String("autoscaling:EC2_INSTANCE_LAUNCHING"),
+				NotificationTargetARN: aws.String("arn:aws:sns:us-east-1:123456789012:launch"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCustomTerminationHook(tt.hook); got != tt.want {
+				t.Errorf("isCustomTerminationHook() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_lifecycleHookTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		hooks []*autoscaling.LifecycleHook
+		want  time.Duration
+	}{
+		{
+			name:  "shorter global timeout",
+			hooks: []*autoscaling.LifecycleHook{customTerminationHook("drain", 30, 120)},
+			want:  2 * time.Minute,
+		},
+		{
+			name: "longest global timeout of the hooks",
+			hooks: []*autoscaling.LifecycleHook{
+				customTerminationHook("drain", 30, 120),
+				customTerminationHook("backup", 30, 240),
+			},
+			want: 4 * time.Minute,
+		},
+		{
+			name:  "capped by the configured timeout",
+			hooks: []*autoscaling.LifecycleHook{customTerminationHook("drain", 300, 30000)},
+			want:  DefaultLifecycleHookTimeout,
+		},
+		{
+			name:  "unknown global timeout",
+			hooks: []*autoscaling.LifecycleHook{{}},
+			want:  DefaultLifecycleHookTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{config: AutoScalingConfig{LifecycleHookTimeout: DefaultLifecycleHookTimeout}}
+			if got := a.lifecycleHookTimeout(tt.hooks); got != tt.want {
+				t.Errorf("lifecycleHookTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_waitForTerminationHooks(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		dlho       *autoscaling.DescribeLifecycleHooksOutput
+		dlherr     error
+		state      string
+		timeout    time.Duration
+		wantErr    error
+		wantSlept  time.Duration
+		wantRecap  []string
+		wantLoaded bool
+		wantHooks  int
+	}{
+		{
+			name:    "hooks can't be described",
+			dlherr:  errors.New("throttled"),
+			timeout: DefaultLifecycleHookTimeout,
+			state:   terminatingWaitState,
+		},
+		{
+			name: "no custom termination hooks",
+			dlho: &autoscaling.DescribeLifecycleHooksOutput{
+				LifecycleHooks: []*autoscaling.LifecycleHook{{
+					LifecycleHookName:   aws.String("plain"),
+					LifecycleTransition: aws.String(terminatingTransition),
+				}},
+			},
+			timeout:    DefaultLifecycleHookTimeout,
+			state:      terminatingWaitState,
+			wantLoaded: true,
+		},
+		{
+			name: "hooks already completed",
+			dlho: &autoscaling.DescribeLifecycleHooksOutput{
+				LifecycleHooks: []*autoscaling.LifecycleHook{customTerminationHook("drain", 60, 600)},
+			},
+			timeout:    DefaultLifecycleHookTimeout,
+			state:      "Terminating:Proceed",
+			wantLoaded: true,
+			wantHooks:  1,
+		},
+		{
+			name: "wait disabled",
+			dlho: &autoscaling.DescribeLifecycleHooksOutput{
+				LifecycleHooks: []*autoscaling.LifecycleHook{customTerminationHook("drain", 60, 600)},
+			},
+			state: terminatingWaitState,
+		},
+		{
+			name: "hooks timing out past their heartbeat timeout",
+			dlho: &autoscaling.DescribeLifecycleHooksOutput{
+				LifecycleHooks: []*autoscaling.LifecycleHook{customTerminationHook("drain", 30, 120)},
+			},
+			timeout:    DefaultLifecycleHookTimeout,
+			state:      terminatingWaitState,
+			wantErr:    ErrLifecycleHookTimeout,
+			wantSlept:  2 * time.Minute,
+			wantRecap:  []string{"asg Termination lifecycle hooks of instance i-od not completed after 2m0s [lifecycle hook timeout]"},
+			wantLoaded: true,
+			wantHooks:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &mockClock{now: now}
+			a := &autoScalingGroup{
+				name:   "asg",
+				config: AutoScalingConfig{LifecycleHookTimeout: tt.timeout},
+				region: &region{
+					name: "us-east-1",
+					conf: &Config{FinalRecap: map[string][]string{}, clock: clock},
+					services: connections{autoScaling: mockASG{
+						dlho:       tt.dlho,
+						dlherr:     tt.dlherr,
+						dasiStates: map[string]string{"i-od": tt.state},
+					}},
+				},
+			}
+
+			err := a.waitForTerminationHooks("i-od")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("waitForTerminationHooks() error = %v, want %v", err, tt.wantErr)
+			}
+			if clock.slept != tt.wantSlept {
+				t.Errorf("waitForTerminationHooks() waited %s, want %s", clock.slept, tt.wantSlept)
+			}
+			if recap := a.region.conf.FinalRecap["us-east-1"]; !reflect.DeepEqual(recap, tt.wantRecap) {
+				t.Errorf("waitForTerminationHooks() recap = %v, want %v", recap, tt.wantRecap)
+			}
+			if a.terminationHooksLoaded != tt.wantLoaded || len(a.terminationHooks) != tt.wantHooks {
+				t.Errorf("waitForTerminationHooks() loaded %v hooks %v, want %d loaded %v",
+					len(a.terminationHooks), a.terminationHooksLoaded, tt.wantHooks, tt.wantLoaded)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadLifecycleHookTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected time.Duration
+	}{
+		{name: "no tag", expected: DefaultLifecycleHookTimeout},
+		{name: "valid tag", tagValue: aws.String("5m"), expected: 5 * time.Minute},
+		{name: "disabled by tag", tagValue: aws.String("0s"), expected: 0},
+		{name: "negative tag", tagValue: aws.String("-1m"), expected: DefaultLifecycleHookTimeout},
+		{name: "invalid tag", tagValue: aws.String("300"), expected: DefaultLifecycleHookTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+					LifecycleHookTimeout: DefaultLifecycleHookTimeout,
+				}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(LifecycleHookTimeoutTag), Value: tt.tagValue}}
+			}
+
+			a.loadLifecycleHookTimeout()
+
+			if a.config.LifecycleHookTimeout != tt.expected {
+				t.Errorf("LifecycleHookTimeout = %s, expected %s", a.config.LifecycleHookTimeout, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+)
+
+const (
+	// DefaultDrainTimeout is the default longest time AutoSpotting waits for
+	// the replaced on-demand instances to be drained by their load balancers,
+	// regardless of the draining timeouts configured on them
+	DefaultDrainTimeout = 5 * time.Minute
+
+	// drainPollInterval is how often the draining state is checked
+	drainPollInterval = 10 * time.Second
+
+	// deregistrationDelayAttribute is the target group attribute holding the
+	// deregistration delay, in seconds
+	deregistrationDelayAttribute = "deregistration_delay.timeout_seconds"
+)
+
+// loadBalancer is a classic load balancer, or the target group of an
+// application or network load balancer, attached to a group. It abstracts the
+// differences between the two APIs, so that the instances are health checked
+// and drained the same way regardless of the type of load balancer.
+type loadBalancer interface {
+	// name identifies the load balancer in the logs
+	name() string
+
+	// isHealthy determines if the instance is registered and passes the
+	// health checks of the load balancer
+	isHealthy(instanceID string) (bool, error)
+
+	// deregister starts deregistering the instance, returning how long its
+	// in-flight requests may still be drained for
+	deregister(instanceID string) (time.Duration, error)
+
+	// isDrained determines if the instance no longer serves in-flight requests
+	isDrained(instanceID string) (bool, error)
+}
+
+// classicLoadBalancer implements loadBalancer for the classic load balancers,
+// which drain the instances when connection draining is enabled on them.
+type classicLoadBalancer struct {
+	svc    elbiface.ELBAPI
+	lbName string
+}
+
+func (lb *classicLoadBalancer) name() string {
+	return "load balancer " + lb.lbName
+}
+
+// instanceState returns the state of the instance in the load balancer, which
+// is empty for the instances not registered to it.
+func (lb *classicLoadBalancer) instanceState(instanceID string) (string, error) {
+	out, err := lb.svc.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{
+		LoadBalancerName: aws.String(lb.lbName),
+		Instances:        []*elb.Instance{{InstanceId: aws.String(instanceID)}},
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == elb.ErrCodeInvalidEndPointException {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if out == nil || len(out.InstanceStates) == 0 {
+		return "", nil
+	}
+	return aws.StringValue(out.InstanceStates[0].State), nil
+}
+
+func (lb *classicLoadBalancer) isHealthy(instanceID string) (bool, error) {
+	state, err := lb.instanceState(instanceID)
+	return state == "InService", err
+}
+
+func (lb *classicLoadBalancer) deregister(instanceID string) (time.Duration, error) {
+	var delay time.Duration
+
+	attrs, err := lb.svc.DescribeLoadBalancerAttributes(&elb.DescribeLoadBalancerAttributesInput{
+		LoadBalancerName: aws.String(lb.lbName),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if attrs != nil && attrs.LoadBalancerAttributes != nil {
+		if cd := attrs.LoadBalancerAttributes.ConnectionDraining; cd != nil && aws.BoolValue(cd.Enabled) {
+			delay = time.Duration(aws.Int64Value(cd.Timeout)) * time.Second
+		}
+	}
+
+	_, err = lb.svc.DeregisterInstancesFromLoadBalancer(&elb.DeregisterInstancesFromLoadBalancerInput{
+		LoadBalancerName: aws.String(lb.lbName),
+		Instances:        []*elb.Instance{{InstanceId: aws.String(instanceID)}},
+	})
+	return delay, err
+}
+
+// isDrained relies on the instances being reported InService for as long as
+// their connections are drained.
+func (lb *classicLoadBalancer) isDrained(instanceID string) (bool, error) {
+	state, err := lb.instanceState(instanceID)
+	if err != nil {
+		return false, err
+	}
+	return state != "InService", nil
+}
+
+// targetGroup implements loadBalancer for the target groups of the
+// application and network load balancers, which drain the targets for their
+// deregistration delay.
+type targetGroup struct {
+	svc elbv2iface.ELBV2API
+	arn string
+}
+
+func (tg *targetGroup) name() string {
+	return "target group " + tg.arn
+}
+
+// targetHealth returns the health of the instance in the target group.
+func (tg *targetGroup) targetHealth(instanceID string) (*elbv2.TargetHealth, error) {
+	out, err := tg.svc.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(tg.arn),
+		Targets:        []*elbv2.TargetDescription{{Id: aws.String(instanceID)}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if out == nil || len(out.TargetHealthDescriptions) == 0 ||
+		out.TargetHealthDescriptions[0].TargetHealth == nil {
+		return &elbv2.TargetHealth{}, nil
+	}
+	return out.TargetHealthDescriptions[0].TargetHealth, nil
+}
+
+// isHealthy also considers healthy the instances of the target groups not
+// used by any load balancer, since they don't serve any traffic.
+func (tg *targetGroup) isHealthy(instanceID string) (bool, error) {
+	health, err := tg.targetHealth(instanceID)
+	if err != nil {
+		return false, err
+	}
+
+	switch aws.StringValue(health.State) {
+	case elbv2.TargetHealthStateEnumHealthy:
+		return true, nil
+	case elbv2.TargetHealthStateEnumUnused:
+		return aws.StringValue(health.Reason) == elbv2.TargetHealthReasonEnumTargetNotInUse, nil
+	}
+	return false, nil
+}
+
+func (tg *targetGroup) deregister(instanceID string) (time.Duration, error) {
+	var delay time.Duration
+
+	attrs, err := tg.svc.DescribeTargetGroupAttributes(&elbv2.DescribeTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(tg.arn),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if attrs != nil {
+		for _, attr := range attrs.Attributes {
+			if aws.StringValue(attr.Key) != deregistrationDelayAttribute {
+				continue
+			}
+			if seconds, err := strconv.Atoi(aws.StringValue(attr.Value)); err == nil {
+				delay = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	_, err = tg.svc.DeregisterTargets(&elbv2.DeregisterTargetsInput{
+		TargetGroupArn: aws.String(tg.arn),
+		Targets:        []*elbv2.TargetDescription{{Id: aws.String(instanceID)}},
+	})
+	return delay, err
+}
+
+func (tg *targetGroup) isDrained(instanceID string) (bool, error) {
+	health, err := tg.targetHealth(instanceID)
+	if err != nil {
+		return false, err
+	}
+	return aws.StringValue(health.State) != elbv2.TargetHealthStateEnumDraining, nil
+}
+
+// loadBalancers returns the classic load balancers and target groups attached
+// to the group.
+func (a *autoScalingGroup) loadBalancers() []loadBalancer {
+	if a.Group == nil {
+		return nil
+	}
+
+	var lbs []loadBalancer
+	for _, name := range a.LoadBalancerNames {
+		lbs = append(lbs, &classicLoadBalancer{
+			svc:    a.region.services.elb,
+			lbName: aws.StringValue(name),
+		})
+	}
+	for _, arn := range a.TargetGroupARNs {
+		lbs = append(lbs, &targetGroup{
+			svc: a.region.services.elbv2,
+			arn: aws.StringValue(arn),
+		})
+	}
+	return lbs
+}
+
+// isHealthyInLoadBalancers determines if the instance passes the health
+// checks of all the load balancers attached to the group.
+func (a *autoScalingGroup) isHealthyInLoadBalancers(instanceID string) bool {
+	for _, lb := range a.loadBalancers() {
+		healthy, err := lb.isHealthy(instanceID)
+		if err != nil {
+			log.Printf("Couldn't check the health of instance %s in the %s: %s",
+				instanceID, lb.name(), err.Error())
+			return false
+		}
+		if !healthy {
+			log.Printf("Instance %s isn't healthy yet in the %s", instanceID, lb.name())
+			return false
+		}
+	}
+	return true
+}
+
+// drainInstance deregisters the instance from all the load balancers attached
+// to the group and waits for them to drain its in-flight requests before it's
+// terminated, for up to the longest draining timeout configured on them, but
+// no longer than the drain timeout of the group. The instance is terminated
+// anyway when the draining fails, since the group also deregisters it when
+// terminating it.
+func (a *autoScalingGroup) drainInstance(instanceID string) {
+	var draining []loadBalancer
+	var delay time.Duration
+
+	for _, lb := range a.loadBalancers() {
+		d, err := lb.deregister(instanceID)
+		if err != nil {
+			log.Printf("Couldn't deregister instance %s from the %s: %s",
+				instanceID, lb.name(), err.Error())
+			continue
+		}
+		log.Printf("Deregistered instance %s from the %s, draining it for up to %s",
+			instanceID, lb.name(), d)
+		if d > 0 {
+			draining = append(draining, lb)
+		}
+		if d > delay {
+			delay = d
+		}
+	}
+
+	if len(draining) == 0 || delay <= 0 {
+		return
+	}
+
+	if delay > a.config.DrainTimeout {
+		delay = a.config.DrainTimeout
+	}
+
+	clock := a.region.conf.getClock()
+	deadline := clock.Now().Add(delay)
+
+	for {
+		var pending []loadBalancer
+		for _, lb := range draining {
+			drained, err := lb.isDrained(instanceID)
+			if err != nil {
+				log.Printf("Couldn't check the draining of instance %s from the %s: %s",
+					instanceID, lb.name(), err.Error())
+			}
+			if err != nil || !drained {
+				pending = append(pending, lb)
+			}
+		}
+		draining = pending
+
+		if len(draining) == 0 {
+			log.Printf("Instance %s was drained by the load balancers of the group %s", instanceID, a.name)
+			return
+		}
+		if !clock.Now().Before(deadline) {
+			log.Printf("Instance %s wasn't drained by the load balancers of the group %s after %s, terminating it anyway",
+				instanceID, a.name, delay)
+			return
+		}
+		clock.Sleep(drainPollInterval)
+	}
+}
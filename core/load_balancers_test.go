@@ -0,0 +1,332 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func Test_classicLoadBalancer_isHealthy(t *testing.T) {
+	state := func(s string) *elb.DescribeInstanceHealthOutput {
+		return &elb.DescribeInstanceHealthOutput{
+			InstanceStates: []*elb.InstanceState{{State: aws.String(s)}},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		diho            *elb.DescribeInstanceHealthOutput
+		diherr          error
+		expectedHealthy bool
+		expectedDrained bool
+		expectedErr     bool
+	}{
+		{name: "in service", diho: state("InService"), expectedHealthy: true},
+		{name: "out of service", diho: state("OutOfService"), expectedDrained: true},
+		{
+			name:            "not registered",
+			diherr:          awserr.New(elb.ErrCodeInvalidEndPointException, "invalid instance", nil),
+			expectedDrained: true,
+		},
+		{name: "describe error", diherr: errors.New("throttled"), expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &classicLoadBalancer{svc: mockELB{diho: tt.diho, diherr: tt.diherr}, lbName: "lb"}
+
+			healthy, err := lb.isHealthy("i-1")
+			if healthy != tt.expectedHealthy || (err != nil) != tt.expectedErr {
+				t.Errorf("isHealthy() = %v, %v, expected %v", healthy, err, tt.expectedHealthy)
+			}
+			drained, err := lb.isDrained("i-1")
+			if drained != tt.expectedDrained || (err != nil) != tt.expectedErr {
+				t.Errorf("isDrained() = %v, %v, expected %v", drained, err, tt.expectedDrained)
+			}
+		})
+	}
+}
+
+func Test_targetGroup_isHealthy(t *testing.T) {
+	health := func(state, reason string) *elbv2.DescribeTargetHealthOutput {
+		return &elbv2.DescribeTargetHealthOutput{
+			TargetHealthDescriptions: []*elbv2.TargetHealthDescription{{
+				TargetHealth: &elbv2.TargetHealth{State: aws.String(state), Reason: aws.String(reason)},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		dtho            *elbv2.DescribeTargetHealthOutput
+		dtherr          error
+		expectedHealthy bool
+		expectedDrained bool
+		expectedErr     bool
+	}{
+		{
+			name:            "healthy",
+			dtho:            health(elbv2.TargetHealthStateEnumHealthy, ""),
+			expectedHealthy: true,
+			expectedDrained: true,
+		},
+		{
+			name:            "initial",
+			dtho:            health(elbv2.TargetHealthStateEnumInitial, elbv2.TargetHealthReasonEnumElbRegistrationInProgress),
+			expectedDrained: true,
+		},
+		{
+			name:            "target group not in use",
+			dtho:            health(elbv2.TargetHealthStateEnumUnused, elbv2.TargetHealthReasonEnumTargetNotInUse),
+			expectedHealthy: true,
+			expectedDrained: true,
+		},
+		{
+			name:            "not registered",
+			dtho:            health(elbv2.TargetHealthStateEnumUnused, elbv2.TargetHealthReasonEnumTargetNotRegistered),
+			expectedDrained: true,
+		},
+		{
+			name: "draining",
+			dtho: health(elbv2.TargetHealthStateEnumDraining, elbv2.TargetHealthReasonEnumTargetDeregistrationInProgress),
+		},
+		{name: "describe error", dtherr: errors.New("throttled"), expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := &targetGroup{svc: mockELBV2{dtho: tt.dtho, dtherr: tt.dtherr}, arn: "arn:tg"}
+
+			healthy, err := tg.isHealthy("i-1")
+			if healthy != tt.expectedHealthy || (err != nil) != tt.expectedErr {
+				t.Errorf("isHealthy() = %v, %v, expected %v", healthy, err, tt.expectedHealthy)
+			}
+			drained, err := tg.isDrained("i-1")
+			if drained != tt.expectedDrained || (err != nil) != tt.expectedErr {
+				t.Errorf("isDrained() = %v, %v, expected %v", drained, err, tt.expectedDrained)
+			}
+		})
+	}
+}
+
+func Test_loadBalancer_deregister(t *testing.T) {
+	tests := []struct {
+		name          string
+		lb            loadBalancer
+		expectedDelay time.Duration
+		expectedErr   bool
+	}{
+		{
+			name: "classic load balancer with connection draining",
+			lb: &classicLoadBalancer{svc: mockELB{
+				dlbao: &elb.DescribeLoadBalancerAttributesOutput{
+					LoadBalancerAttributes: &elb.LoadBalancerAttributes{
+						ConnectionDraining: &elb.ConnectionDraining{Enabled: aws.Bool(true), Timeout: aws.Int64(120)},
+					},
+				},
+			}},
+			expectedDelay: 2 * time.Minute,
+		},
+		{
+			name: "classic load balancer without connection draining",
+			lb: &classicLoadBalancer{svc: mockELB{
+				dlbao: &elb.DescribeLoadBalancerAttributesOutput{
+					LoadBalancerAttributes: &elb.LoadBalancerAttributes{
+						ConnectionDraining: &elb.ConnectionDraining{Enabled: aws.Bool(false), Timeout: aws.Int64(300)},
+					},
+				},
+			}},
+		},
+		{
+			name: "classic load balancer deregistration error",
+			lb: &classicLoadBalancer{svc: mockELB{
+				dlbao:   &elb.DescribeLoadBalancerAttributesOutput{},
+				difserr: errors.New("access denied"),
+			}},
+			expectedErr: true,
+		},
+		{
+			name: "target group",
+			lb: &targetGroup{svc: mockELBV2{
+				dtgao: &elbv2.DescribeTargetGroupAttributesOutput{
+					Attributes: []*elbv2.TargetGroupAttribute{
+						{Key: aws.String("stickiness.enabled"), Value: aws.String("false")},
+						{Key: aws.String(deregistrationDelayAttribute), Value: aws.String("45")},
+					},
+				},
+			}},
+			expectedDelay: 45 * time.Second,
+		},
+		{
+			name:        "target group attributes error",
+			lb:          &targetGroup{svc: mockELBV2{dtgaerr: errors.New("throttled")}},
+			expectedErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, err := tt.lb.deregister("i-1")
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("deregister() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if delay != tt.expectedDelay {
+				t.Errorf("deregister() delay = %s, expected %s", delay, tt.expectedDelay)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_drainInstance(t *testing.T) {
+	delay := func(seconds string) *elbv2.DescribeTargetGroupAttributesOutput {
+		return &elbv2.DescribeTargetGroupAttributesOutput{
+			Attributes: []*elbv2.TargetGroupAttribute{
+				{Key: aws.String(deregistrationDelayAttribute), Value: aws.String(seconds)},
+			},
+		}
+	}
+	state := func(s string) *elbv2.DescribeTargetHealthOutput {
+		return &elbv2.DescribeTargetHealthOutput{
+			TargetHealthDescriptions: []*elbv2.TargetHealthDescription{
+				{TargetHealth: &elbv2.TargetHealth{State: aws.String(s)}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name                string
+		lbNames             []*string
+		targetGroups        []*string
+		dtgao               *elbv2.DescribeTargetGroupAttributesOutput
+		dtho                *elbv2.DescribeTargetHealthOutput
+		drainTimeout        time.Duration
+		expectedDeregisters int
+		expectedSlept       time.Duration
+	}{
+		{name: "no load balancers", drainTimeout: DefaultDrainTimeout},
+		{
+			name:                "drained right away",
+			drainTimeout:        DefaultDrainTimeout,
+			targetGroups:        []*string{aws.String("arn:tg1"), aws.String("arn:tg2")},
+			dtgao:               delay("300"),
+			dtho:                state(elbv2.TargetHealthStateEnumUnused),
+			expectedDeregisters: 2,
+		},
+		{
+			name:                "no deregistration delay",
+			drainTimeout:        DefaultDrainTimeout,
+			targetGroups:        []*string{aws.String("arn:tg")},
+			dtgao:               delay("0"),
+			dtho:                state(elbv2.TargetHealthStateEnumDraining),
+			expectedDeregisters: 1,
+		},
+		{
+			name:                "draining for the deregistration delay",
+			drainTimeout:        DefaultDrainTimeout,
+			targetGroups:        []*string{aws.String("arn:tg")},
+			dtgao:               delay("30"),
+			dtho:                state(elbv2.TargetHealthStateEnumDraining),
+			expectedDeregisters: 1,
+			expectedSlept:       30 * time.Second,
+		},
+		{
+			name:                "draining for longer than the default timeout",
+			lbNames:             []*string{aws.String("lb")},
+			targetGroups:        []*string{aws.String("arn:tg")},
+			dtgao:               delay("3600"),
+			dtho:                state(elbv2.TargetHealthStateEnumDraining),
+			drainTimeout:        DefaultDrainTimeout,
+			expectedDeregisters: 2,
+			expectedSlept:       DefaultDrainTimeout,
+		},
+		{
+			name:                "draining for longer than the group timeout",
+			targetGroups:        []*string{aws.String("arn:tg")},
+			dtgao:               delay("300"),
+			dtho:                state(elbv2.TargetHealthStateEnumDraining),
+			drainTimeout:        time.Minute,
+			expectedDeregisters: 1,
+			expectedSlept:       time.Minute,
+		},
+		{
+			name:                "draining disabled",
+			targetGroups:        []*string{aws.String("arn:tg")},
+			dtgao:               delay("300"),
+			dtho:                state(elbv2.TargetHealthStateEnumDraining),
+			expectedDeregisters: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deregisters int
+			clock := &mockClock{now: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)}
+
+			a := &autoScalingGroup{
+				name:   "asg",
+				config: AutoScalingConfig{DrainTimeout: tt.drainTimeout},
+				Group: &autoscaling.Group{
+					LoadBalancerNames: tt.lbNames,
+					TargetGroupARNs:   tt.targetGroups,
+				},
+				region: &region{
+					conf: &Config{clock: clock},
+					services: connections{
+						elb: mockELB{
+							dlbao:     &elb.DescribeLoadBalancerAttributesOutput{},
+							difscalls: &deregisters,
+						},
+						elbv2: mockELBV2{dtgao: tt.dtgao, dtho: tt.dtho, dtcalls: &deregisters},
+					},
+				},
+			}
+
+			a.drainInstance("i-od")
+
+			if deregisters != tt.expectedDeregisters {
+				t.Errorf("drainInstance() deregistered %d times, expected %d", deregisters, tt.expectedDeregisters)
+			}
+			if clock.slept != tt.expectedSlept {
+				t.Errorf("drainInstance() waited %s, expected %s", clock.slept, tt.expectedSlept)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadDrainTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected time.Duration
+	}{
+		{name: "no tag", expected: DefaultDrainTimeout},
+		{name: "valid tag", tagValue: aws.String("90s"), expected: 90 * time.Second},
+		{name: "disabled by tag", tagValue: aws.String("0s"), expected: 0},
+		{name: "negative tag", tagValue: aws.String("-1m"), expected: DefaultDrainTimeout},
+		{name: "invalid tag", tagValue: aws.String("300"), expected: DefaultDrainTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+					DrainTimeout: DefaultDrainTimeout,
+				}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(DrainTimeoutTag), Value: tt.tagValue}}
+			}
+
+			a.loadDrainTimeout()
+
+			if a.config.DrainTimeout != tt.expected {
+				t.Errorf("DrainTimeout = %s, expected %s", a.config.DrainTimeout, tt.expected)
+			}
+		})
+	}
+}
@@ -5,7 +5,6 @@ package autospotting
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -16,9 +15,12 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/costexplorer/costexploreriface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
 	ec2instancesinfo "github.com/cristim/ec2-instances-info"
 )
 
@@ -28,8 +30,19 @@ var totalSavings float64
 // AutoSpotting hosts global configuration and has as methods all the public
 // entrypoints of this library
 type AutoSpotting struct {
-	config      *Config
-	mainEC2Conn ec2iface.EC2API
+	config           *Config
+	mainEC2Conn      ec2iface.EC2API
+	costExplorerConn costexploreriface.CostExplorerAPI
+	cloudWatchConn   cloudwatchiface.CloudWatchAPI
+	snsConn          snsiface.SNSAPI
+
+	// the configuration profiles processed in turn by each run
+	profiles []*AutoSpotting
+
+	// set in the Organizations mode, along with the instances processing the
+	// member accounts by account ID
+	organizationsConn organizationsiface.OrganizationsAPI
+	accounts          map[string]*AutoSpotting
 }
 
 var as *AutoSpotting
@@ -42,10 +55,42 @@ func (a *AutoSpotting) Init(cfg *Config) {
 	}
 
 	cfg.InstanceData = data
+	cfg.apiCalls = newAPICallBudget(cfg.MaxAPICalls)
+	cfg.priceSources = newPriceSources(cfg)
+	cfg.regionData = newRegionDataCache(cfg)
+	cfg.regionFeatures = newRegionFeatures(cfg)
+	cfg.currencyConverter = newCurrencyConverter(cfg)
+	cfg.swapLimiter = newSwapLimiter(cfg.MaxConcurrentSwaps)
 	a.config = cfg
 	a.config.setupLogging()
+
+	if err := a.config.setupAPIRecorder(); err != nil {
+		log.Fatal(err.Error())
+	}
 	// use this only to list all the other regions
-	a.mainEC2Conn = connectEC2(a.config.MainRegion)
+	a.mainEC2Conn = connectEC2(a.config.MainRegion, a.config)
+
+	if a.config.SavingsReconciliationInterval > 0 {
+		a.costExplorerConn = connectCostExplorer(a.config)
+	}
+
+	if a.config.HeartbeatMetric || a.config.APICallMetrics {
+		a.cloudWatchConn = connectCloudWatch(a.config)
+	}
+
+	if a.config.SpotCoverageTopic != "" {
+		a.snsConn = connectSNS(a.config, a.config.SpotCoverageTopic)
+	}
+
+	if a.config.PermissionPreflight {
+		a.runPermissionPreflight()
+	}
+
+	if a.config.OrganizationRole != "" {
+		a.organizationsConn = connectOrganizations(a.config)
+	} else if err := a.loadProfiles(); err != nil {
+		log.Fatal(err.Error())
+	}
 	as = a
 }
 
@@ -62,9 +107,38 @@ func RunningFromLambda() bool {
 // enabled and taking action by replacing more pricy on-demand instances with
 // compatible and cheaper spot instances.
 func (a *AutoSpotting) ProcessCronEvent() {
+	if a.organizationsConn != nil {
+		a.processOrganization()
+		return
+	}
+
+	if len(a.profiles) > 0 {
+		a.processProfiles()
+		return
+	}
+
+	if a.config.ReadOnly {
+		log.Println("Running in read-only mode, reporting the planned replacements without making any changes")
+		if err := a.Analyze(log.Writer()); err != nil {
+			log.Println("Failed to analyze the AutoScaling groups:", err.Error())
+		}
+		return
+	}
+
 	// Clear FinalRecap map
 	a.config.FinalRecap = make(map[string][]string)
 
+	// Reset the savings, which would otherwise accumulate across runs in daemon mode
+	totalSavings = 0
+
+	a.config.errorBudget = newErrorBudget(a.config.MaxErrors, a.config.MaxErrorRate)
+	a.config.apiCalls.startRun()
+	a.config.launchFailures = newLaunchFailures()
+	a.config.executionBudget = newExecutionBudget(a.config)
+	a.config.spotCoverage = newSpotCoverageReport()
+	a.config.regionData.startRun()
+	a.config.runID = newRunID(a.config.getClock().Now())
+
 	a.config.addDefaultFilteringMode()
 	a.config.addDefaultFilter()
 
@@ -77,13 +151,33 @@ func (a *AutoSpotting) ProcessCronEvent() {
 
 	a.processRegions(allRegions)
 
+	if a.config.executionBudget.allows("savings reconciliation", true) {
+		a.reconcileSavingsIfDue(totalSavings)
+	}
+
 	// Print Final Recap
-	log.Println("####### BEGIN FINAL RECAP #######")
+	log.Printf("####### BEGIN %s #######", a.config.recapTitle())
 	for r, a := range a.config.FinalRecap {
 		for _, t := range a {
 			log.Printf("%s %s\n", r, t)
 		}
 	}
+
+	if failures := a.config.launchFailures.String(); failures != "" {
+		log.Println("Spot instance launch failures by reason:", failures)
+	}
+
+	if skipped := a.config.executionBudget.String(); skipped != "" {
+		log.Println("Skipped in order to finish within the execution budget:", skipped)
+	}
+
+	if calls := a.config.apiCalls.String(); calls != "" {
+		log.Println("AWS API calls by service:", calls)
+	}
+
+	a.notifySpotCoverage()
+	a.emitAPICallMetrics()
+	a.emitHeartbeat()
 }
 
 func (cfg *Config) addDefaultFilteringMode() {
@@ -100,9 +194,9 @@ func (cfg *Config) addDefaultFilter() {
 	if len(strings.TrimSpace(cfg.FilterByTags)) == 0 {
 		switch cfg.TagFilteringMode {
 		case "opt-out":
-			cfg.FilterByTags = "spot-enabled=false"
+			cfg.FilterByTags = cfg.tagKey(spotEnabledTag) + "=false"
 		default:
-			cfg.FilterByTags = "spot-enabled=true"
+			cfg.FilterByTags = cfg.tagKey(spotEnabledTag) + "=true"
 		}
 	}
 }
@@ -126,6 +220,9 @@ func (a *AutoSpotting) processRegions(regions []string) {
 	var wg sync.WaitGroup
 	var savingsMutex sync.RWMutex
 
+	// calculating the savings also prefetches the instance type information
+	// and prices of all the regions concurrently, into the region data cache
+	// reused when processing them below
 	for _, r := range regions {
 		wg.Add(1)
 		r := region{name: r, conf: a.config}
@@ -139,7 +236,7 @@ func (a *AutoSpotting) processRegions(regions []string) {
 	}
 	wg.Wait()
 
-	log.Println("Total hourly savings:", totalSavings)
+	log.Println("Total hourly savings:", a.config.reportingCurrency().format(totalSavings, 4))
 	if strings.Contains(as.config.Version, "stable") {
 		log.Println("Running a stable build, submitting AWS marketplace metering data")
 		if err := meterMarketplaceUsage(totalSavings); err != nil {
@@ -150,17 +247,26 @@ func (a *AutoSpotting) processRegions(regions []string) {
 		log.Println("Not running a stable build, skipped AWS marketplace metering")
 	}
 
+	// limits the number of regions processed concurrently within the memory
+	// limit of the execution
+	sem := make(chan struct{}, a.config.executionBudget.regionConcurrency(len(regions)))
+
 	for _, r := range regions {
 		wg.Add(1)
 		r := region{name: r, conf: a.config}
 
+		sem <- struct{}{}
 		go func() {
-			if r.enabled() {
-				log.Printf("Enabled to run in %s, processing region.\n", r.name)
-				r.processRegion()
-			} else {
+			defer func() { <-sem }()
+
+			if !r.enabled() {
 				debug.Println("Not enabled to run in", r.name)
 				debug.Println("List of enabled regions:", r.conf.Regions)
+			} else if !a.config.executionBudget.allows("region "+r.name, false) {
+				log.Printf("Execution budget exhausted, skipping region %s.\n", r.name)
+			} else {
+				log.Printf("Enabled to run in %s, processing region.\n", r.name)
+				r.processRegion()
 			}
 
 			wg.Done()
@@ -169,15 +275,14 @@ func (a *AutoSpotting) processRegions(regions []string) {
 	wg.Wait()
 }
 
-func connectEC2(region string) *ec2.EC2 {
+func connectEC2(region string, conf *Config) *ec2.EC2 {
 
-	sess, err := session.NewSession()
+	sess, err := newSession(region, conf)
 	if err != nil {
 		panic(err)
 	}
 
-	return ec2.New(sess,
-		aws.NewConfig().WithRegion(region))
+	return ec2.New(sess, conf.serviceConfig(ec2.EndpointsID, region))
 }
 
 // getRegions generates a list of AWS regions.
@@ -240,7 +345,7 @@ func (a *AutoSpotting) convertRawEventToCloudwatchEvent(event *json.RawMessage)
 }
 
 // parse instance events and execute the relative methods
-func (a *AutoSpotting) processEventInstance(eventType string, region string, instanceID *string, instanceState *string) error {
+func (a *AutoSpotting) processEventInstance(event *events.CloudWatchEvent, eventType string, region string, instanceID *string, instanceState *string) error {
 	if eventType == InstanceStateChangeNotificationCode {
 		if a.config.DisableEventBasedInstanceReplacement {
 			log.Println("Event-based instance replacement is disabled, exiting...")
@@ -257,9 +362,13 @@ func (a *AutoSpotting) processEventInstance(eventType string, region string, ins
 			return nil
 		}
 		// If the event is for an Instance Spot Interruption/Rebalance
-		spotTermination := newSpotTermination(region)
+		spotTermination := newSpotTermination(region, a.config)
 
 		if spotTermination.IsInAutoSpottingASG(instanceID, a.config.TagFilteringMode, a.config.FilterByTags) {
+			// the node is drained by the in-cluster tooling while it's
+			// detached or terminated
+			spotTermination.notifyNodeTerminationHandler(event, instanceID)
+
 			err := spotTermination.executeAction(instanceID, a.config.TerminationNotificationAction, eventType)
 			if err != nil {
 				log.Printf("Error executing spot termination/rebalance action: %s\n", err.Error())
@@ -289,6 +398,12 @@ func (a *AutoSpotting) processEvent(event *json.RawMessage) error {
 	}
 
 	log.Println("Triggered by", cloudwatchEvent.DetailType)
+
+	if a.config.ReadOnly && eventType != ScheduledEventCode {
+		log.Println("Running in read-only mode, ignoring the event")
+		return nil
+	}
+
 	t := time.Now()
 	log.SetPrefix(fmt.Sprintf("%s:%s ", eventType, t.Format("2006-01-02T15:04:00")))
 
@@ -298,7 +413,7 @@ func (a *AutoSpotting) processEvent(event *json.RawMessage) error {
 		instanceID != nil {
 		// Handle Instance Events
 		log.SetPrefix(fmt.Sprintf("%s:%s ", eventType, *instanceID))
-		a.processEventInstance(eventType, cloudwatchEvent.Region, instanceID, instanceState)
+		a.processEventInstance(cloudwatchEvent, eventType, cloudwatchEvent.Region, instanceID, instanceState)
 	} else if eventType == AWSAPICallCloudTrailCode {
 		// CloudTrail
 		a.handleLifecycleHookEvent(*cloudwatchEvent)
@@ -314,6 +429,8 @@ func (a *AutoSpotting) processEvent(event *json.RawMessage) error {
 // AutoSpotting
 func (a *AutoSpotting) EventHandler(event *json.RawMessage) {
 
+	defer a.config.apiRecorder.save()
+
 	if event == nil {
 		log.Println("Missing event data, running as if triggered from a cron event...")
 		// Event is Autospotting Cron Scheduling
@@ -321,6 +438,7 @@ func (a *AutoSpotting) EventHandler(event *json.RawMessage) {
 		return
 	}
 
+	a.config.runID = newRunID(a.config.getClock().Now())
 	a.processEvent(event)
 	log.SetPrefix("")
 }
@@ -353,9 +471,9 @@ func (a *AutoSpotting) handleLifecycleHookEvent(event events.CloudWatchEvent) er
 	r := region{name: regionName, conf: a.config, services: connections{}}
 
 	if !r.enabled() {
-		return fmt.Errorf("region %s is not enabled", r.name)
+		return fmt.Errorf("%w: %s", ErrRegionNotEnabled, r.name)
 	}
-	r.services.connect(regionName, r.conf.MainRegion)
+	r.services.connect(regionName, r.conf)
 	r.setupAsgFilters()
 	r.scanForEnabledAutoScalingGroups()
 
@@ -370,7 +488,7 @@ func (a *AutoSpotting) handleLifecycleHookEvent(event events.CloudWatchEvent) er
 	if i == nil {
 		log.Printf("%s Instance %s is missing, skipping...",
 			regionName, instanceID)
-		return errors.New("instance missing")
+		return fmt.Errorf("instance %s: %w", instanceID, ErrInstanceNotFound)
 	}
 
 	if skipRun, err := i.handleInstanceStates(); skipRun {
@@ -406,10 +524,10 @@ func (a *AutoSpotting) handleNewInstanceLaunch(regionName string, instanceID str
 	r := &region{name: regionName, conf: a.config, services: connections{}}
 
 	if !r.enabled() {
-		return fmt.Errorf("region %s is not enabled", regionName)
+		return fmt.Errorf("%w: %s", ErrRegionNotEnabled, regionName)
 	}
 
-	r.services.connect(regionName, a.config.MainRegion)
+	r.services.connect(regionName, a.config)
 	r.setupAsgFilters()
 	r.scanForEnabledAutoScalingGroups()
 
@@ -426,7 +544,7 @@ func (a *AutoSpotting) handleNewInstanceLaunch(regionName string, instanceID str
 	if i == nil {
 		log.Printf("%s Instance %s is missing, skipping...",
 			regionName, instanceID)
-		return errors.New("instance missing")
+		return fmt.Errorf("instance %s: %w", instanceID, ErrInstanceNotFound)
 	}
 	log.Printf("%s Found instance %s in state %s",
 		i.region.name, *i.InstanceId, *i.State.Name)
@@ -434,7 +552,7 @@ func (a *AutoSpotting) handleNewInstanceLaunch(regionName string, instanceID str
 	if state != "running" {
 		log.Printf("%s Instance %s is not in the running state",
 			i.region.name, *i.InstanceId)
-		return errors.New("instance not in running state")
+		return ErrInstanceNotRunning
 	}
 
 	// Try OnDemand
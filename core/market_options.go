@@ -0,0 +1,82 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// mergeLaunchTemplateMarketOptions merges the spot market options set on the
+// launch template into the ones of the spot instance, which would otherwise
+// override them, keeping the result valid for the RunInstances call. The
+// maximum price of the launch template caps the bid price.
+func (i *instance) mergeLaunchTemplateMarketOptions(opts *ec2.InstanceMarketOptionsRequest, ltOpts *ec2.LaunchTemplateInstanceMarketOptions) {
+	if opts == nil || opts.SpotOptions == nil || ltOpts == nil || ltOpts.SpotOptions == nil {
+		return
+	}
+
+	so, lso := opts.SpotOptions, ltOpts.SpotOptions
+	ltID := aws.StringValue(i.asg.LaunchTemplate.LaunchTemplateId)
+
+	if ltMax, err := strconv.ParseFloat(aws.StringValue(lso.MaxPrice), 64); err == nil && ltMax > 0 {
+		if bid, err := strconv.ParseFloat(aws.StringValue(so.MaxPrice), 64); err != nil || ltMax < bid {
+			log.Println(i.region.name, "Capping the bid price of", aws.StringValue(so.MaxPrice),
+				"to the maximum price of", aws.StringValue(lso.MaxPrice), "set on the launch template", ltID)
+			so.MaxPrice = lso.MaxPrice
+		}
+	}
+
+	// the interruption behavior configured on the group takes precedence
+	if so.SpotInstanceType == nil {
+		so.SpotInstanceType = lso.SpotInstanceType
+		so.InstanceInterruptionBehavior = lso.InstanceInterruptionBehavior
+		so.ValidUntil = lso.ValidUntil
+	}
+
+	if lso.BlockDurationMinutes != nil {
+		log.Println(i.region.name, "Ignoring the spot block duration of the launch template", ltID,
+			"since spot blocks are no longer available")
+	}
+
+	persistent := aws.StringValue(so.SpotInstanceType) == ec2.SpotInstanceTypePersistent
+
+	if b := aws.StringValue(so.InstanceInterruptionBehavior); !persistent &&
+		(b == StopInterruptionBehavior || b == HibernateInterruptionBehavior) {
+		log.Println(i.region.name, "Using a persistent spot request for the", b,
+			"interruption behavior set on the launch template", ltID)
+		so.SpotInstanceType = aws.String(ec2.SpotInstanceTypePersistent)
+		persistent = true
+	}
+
+	if so.ValidUntil != nil && !persistent {
+		log.Println(i.region.name, "Ignoring the expiration of the one-time spot request set on the launch template", ltID)
+		so.ValidUntil = nil
+	}
+}
+
+// usesPersistentSpotRequests determines if the spot instances of the group are
+// launched using persistent spot requests, either because of the interruption
+// behavior configured for the group or because of its launch template.
+func (a *autoScalingGroup) usesPersistentSpotRequests() bool {
+	if a.resumesAfterInterruption() {
+		return true
+	}
+
+	if a == nil || a.Group == nil || a.LaunchTemplate == nil {
+		return false
+	}
+
+	ltv, err := a.region.describeLaunchTemplateVersion(a.LaunchTemplate.LaunchTemplateId, a.LaunchTemplate.Version)
+	if err != nil || ltv.LaunchTemplateData == nil {
+		return false
+	}
+
+	mo := ltv.LaunchTemplateData.InstanceMarketOptions
+	return mo != nil && mo.SpotOptions != nil &&
+		aws.StringValue(mo.SpotOptions.SpotInstanceType) == ec2.SpotInstanceTypePersistent
+}
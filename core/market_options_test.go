@@ -0,0 +1,201 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instance_mergeLaunchTemplateMarketOptions(t *testing.T) {
+	validUntil := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		spot     *ec2.SpotMarketOptions
+		ltOpts   *ec2.LaunchTemplateInstanceMarketOptions
+		expected *ec2.SpotMarketOptions
+	}{
+		{
+			name:     "no launch template market options",
+			spot:     &ec2.SpotMarketOptions{MaxPrice: aws.String("0.5")},
+			expected: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.5")},
+		},
+		{
+			name: "lower maximum price",
+			spot: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.5")},
+			ltOpts: &ec2.LaunchTemplateInstanceMarketOptions{
+				MarketType:  aws.String(Spot),
+				SpotOptions: &ec2.LaunchTemplateSpotMarketOptions{MaxPrice: aws.String("0.3")},
+			},
+			expected: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.3")},
+		},
+		{
+			name: "higher maximum price",
+			spot: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.5")},
+			ltOpts: &ec2.LaunchTemplateInstanceMarketOptions{
+				SpotOptions: &ec2.LaunchTemplateSpotMarketOptions{MaxPrice: aws.String("0.8")},
+			},
+			expected: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.5")},
+		},
+		{
+			name: "persistent request with stop behavior",
+			spot: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.5")},
+			ltOpts: &ec2.LaunchTemplateInstanceMarketOptions{
+				SpotOptions: &ec2.LaunchTemplateSpotMarketOptions{
+					InstanceInterruptionBehavior: aws.String(StopInterruptionBehavior),
+					SpotInstanceType:             aws.String(ec2.SpotInstanceTypePersistent),
+					ValidUntil:                   aws.Time(validUntil),
+				},
+			},
+			expected: &ec2.SpotMarketOptions{
+				InstanceInterruptionBehavior: aws.String(StopInterruptionBehavior),
+				MaxPrice:                     aws.String("0.5"),
+				SpotInstanceType:             aws.String(ec2.SpotInstanceTypePersistent),
+				ValidUntil:                   aws.Time(validUntil),
+			},
+		},
+		{
+			name: "one-time request with hibernate behavior",
+			spot: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.5")},
+			ltOpts: &ec2.LaunchTemplateInstanceMarketOptions{
+				SpotOptions: &ec2.LaunchTemplateSpotMarketOptions{
+					InstanceInterruptionBehavior: aws.String(HibernateInterruptionBehavior),
+					SpotInstanceType:             aws.String(ec2.SpotInstanceTypeOneTime),
+				},
+			},
+			expected: &ec2.SpotMarketOptions{
+				InstanceInterruptionBehavior: aws.String(HibernateInterruptionBehavior),
+				MaxPrice:                     aws.String("0.5"),
+				SpotInstanceType:             aws.String(ec2.SpotInstanceTypePersistent),
+			},
+		},
+		{
+			name: "one-time request with expiration and block duration",
+			spot: &ec2.SpotMarketOptions{MaxPrice: aws.String("0.5")},
+			ltOpts: &ec2.LaunchTemplateInstanceMarketOptions{
+				SpotOptions: &ec2.LaunchTemplateSpotMarketOptions{
+					BlockDurationMinutes: aws.Int64(60),
+					SpotInstanceType:     aws.String(ec2.SpotInstanceTypeOneTime),
+					ValidUntil:           aws.Time(validUntil),
+				},
+			},
+			expected: &ec2.SpotMarketOptions{
+				MaxPrice:         aws.String("0.5"),
+				SpotInstanceType: aws.String(ec2.SpotInstanceTypeOneTime),
+			},
+		},
+		{
+			name: "group interruption behavior takes precedence",
+			spot: &ec2.SpotMarketOptions{
+				InstanceInterruptionBehavior: aws.String(HibernateInterruptionBehavior),
+				MaxPrice:                     aws.String("0.5"),
+				SpotInstanceType:             aws.String(ec2.SpotInstanceTypePersistent),
+			},
+			ltOpts: &ec2.LaunchTemplateInstanceMarketOptions{
+				SpotOptions: &ec2.LaunchTemplateSpotMarketOptions{
+					InstanceInterruptionBehavior: aws.String(StopInterruptionBehavior),
+					SpotInstanceType:             aws.String(ec2.SpotInstanceTypePersistent),
+				},
+			},
+			expected: &ec2.SpotMarketOptions{
+				InstanceInterruptionBehavior: aws.String(HibernateInterruptionBehavior),
+				MaxPrice:                     aws.String("0.5"),
+				SpotInstanceType:             aws.String(ec2.SpotInstanceTypePersistent),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				region: &region{name: "us-east-1"},
+				asg: &autoScalingGroup{
+					Group: &autoscaling.Group{
+						LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+							LaunchTemplateId: aws.String("lt-123"),
+						},
+					},
+				},
+			}
+			opts := &ec2.InstanceMarketOptionsRequest{MarketType: aws.String(Spot), SpotOptions: tt.spot}
+			i.mergeLaunchTemplateMarketOptions(opts, tt.ltOpts)
+
+			if !reflect.DeepEqual(opts.SpotOptions, tt.expected) {
+				t.Errorf("mergeLaunchTemplateMarketOptions() = %v, expected %v", opts.SpotOptions, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_usesPersistentSpotRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		behavior string
+		lt       *autoscaling.LaunchTemplateSpecification
+		ltData   *ec2.ResponseLaunchTemplateData
+		expected bool
+	}{
+		{
+			name:     "group interruption behavior",
+			behavior: StopInterruptionBehavior,
+			expected: true,
+		},
+		{
+			name:     "launch configuration",
+			behavior: TerminateInterruptionBehavior,
+			expected: false,
+		},
+		{
+			name:     "one-time launch template",
+			behavior: TerminateInterruptionBehavior,
+			lt: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-123"),
+				Version:          aws.String("1"),
+			},
+			ltData:   &ec2.ResponseLaunchTemplateData{},
+			expected: false,
+		},
+		{
+			name:     "persistent launch template",
+			behavior: TerminateInterruptionBehavior,
+			lt: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-123"),
+				Version:          aws.String("1"),
+			},
+			ltData: &ec2.ResponseLaunchTemplateData{
+				InstanceMarketOptions: &ec2.LaunchTemplateInstanceMarketOptions{
+					SpotOptions: &ec2.LaunchTemplateSpotMarketOptions{
+						SpotInstanceType: aws.String(ec2.SpotInstanceTypePersistent),
+					},
+				},
+			},
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{LaunchTemplate: tt.lt},
+				config: AutoScalingConfig{SpotInterruptionBehavior: tt.behavior},
+				region: &region{
+					services: connections{ec2: mockEC2{
+						dltvo: &ec2.DescribeLaunchTemplateVersionsOutput{
+							LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{
+								{LaunchTemplateData: tt.ltData},
+							},
+						},
+					}},
+				},
+			}
+			if got := a.usesPersistentSpotRequests(); got != tt.expected {
+				t.Errorf("usesPersistentSpotRequests() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -27,7 +27,7 @@ func meterMarketplaceUsage(savings float64) error {
 		return nil
 	}
 
-	mySession := session.Must(session.NewSession())
+	mySession := session.Must(newSession("us-east-1", as.config))
 
 	// Create a MarketplaceMetering client with additional configuration
 	svc := marketplacemetering.New(mySession, aws.NewConfig().WithRegion("us-east-1"))
@@ -56,7 +56,7 @@ func meterMarketplaceUsage(savings float64) error {
 }
 
 func putSSMParameter(status string) {
-	mySession := session.Must(session.NewSession())
+	mySession := session.Must(newSession("us-east-1", as.config))
 
 	// Create a SSM client
 	svc := ssm.New(mySession, aws.NewConfig().WithRegion("us-east-1"))
@@ -81,7 +81,7 @@ func markAsFailingFromFargate() {
 }
 
 func failedFromFargate() bool {
-	mySession := session.Must(session.NewSession())
+	mySession := session.Must(newSession("us-east-1", as.config))
 	// Create a SSM client
 	svc := ssm.New(mySession, aws.NewConfig().WithRegion("us-east-1"))
 	res, err := svc.GetParameter(&ssm.GetParameterInput{
@@ -0,0 +1,137 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const (
+	// OriginalMaxSizeTag is set on the groups while their MaxSize is
+	// temporarily increased, recording the MaxSize to be restored
+	OriginalMaxSizeTag = "autospotting_original_max_size"
+
+	// MaxSizeIncreasedAtTag is set on the groups while their MaxSize is
+	// temporarily increased, recording when that happened
+	MaxSizeIncreasedAtTag = "autospotting_max_size_increased_at"
+
+	// maxSizeRestoreDelay is how long after being increased the MaxSize of a
+	// group is considered to have been left inflated by a crashed run, longer
+	// than the maximum duration of a Lambda function execution
+	maxSizeRestoreDelay = 20 * time.Minute
+)
+
+// increaseMaxSize temporarily increases the MaxSize of the group by the given
+// increment, after recording its original value in tags, so that it can be
+// restored by a later run if the current one crashes or times out before
+// restoring it.
+func (a *autoScalingGroup) increaseMaxSize(maxSize, increment int64) error {
+	now := a.region.conf.getClock().Now().UTC()
+
+	_, err := a.region.services.autoScaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+		Tags: []*autoscaling.Tag{
+			a.groupTag(OriginalMaxSizeTag, strconv.FormatInt(maxSize, 10)),
+			a.groupTag(MaxSizeIncreasedAtTag, now.Format(time.RFC3339)),
+		},
+	})
+	if err != nil {
+		log.Println(a.name, "Couldn't record the original MaxSize:", err.Error())
+		return fmt.Errorf("couldn't record the original MaxSize of the group %s: %w", a.name, err)
+	}
+
+	if err := a.setAutoScalingMaxSize(maxSize + increment); err != nil {
+		a.deleteMaxSizeTags()
+		return fmt.Errorf("couldn't increase the MaxSize of the group %s: %w", a.name, err)
+	}
+	return nil
+}
+
+// restoreMaxSize sets the MaxSize of the group back to its original value and
+// removes the tags recording it.
+func (a *autoScalingGroup) restoreMaxSize(maxSize int64) error {
+	if err := a.setAutoScalingMaxSize(maxSize); err != nil {
+		log.Println(a.name, "Couldn't restore the MaxSize to", maxSize)
+		return err
+	}
+	a.MaxSize = aws.Int64(maxSize)
+	return a.deleteMaxSizeTags()
+}
+
+// reconcileMaxSize restores the MaxSize of the group if it was left increased
+// by a previous run which crashed or timed out in the middle of a swap.
+func (a *autoScalingGroup) reconcileMaxSize() {
+	original := a.getOwnTagValue(OriginalMaxSizeTag)
+	if original == nil {
+		return
+	}
+
+	// the group is left as is, like for the other actions of the run
+	if reason, blocked := a.region.actionsBlocked(); blocked {
+		log.Printf("%s Not restoring the MaxSize left increased by a previous run: %s", a.name, reason)
+		return
+	}
+
+	if increasedAt := a.getOwnTagValue(MaxSizeIncreasedAtTag); increasedAt != nil {
+		t, err := time.Parse(time.RFC3339, *increasedAt)
+		if err == nil && a.region.conf.getClock().Now().Sub(t) < maxSizeRestoreDelay {
+			debug.Println(a.name, "MaxSize increased at", *increasedAt, "possibly by a running swap")
+			return
+		}
+	}
+
+	maxSize, err := strconv.ParseInt(*original, 10, 64)
+	if err != nil {
+		log.Printf("%s Ignoring invalid %s value %s", a.name, OriginalMaxSizeTag, *original)
+		a.deleteMaxSizeTags()
+		return
+	}
+
+	// the MaxSize may have been changed meanwhile by the users, in which case
+	// it's left as is. Swaps increase it by one, or by up to the surge of the
+	// groups replacing their instances in batches.
+	if current := aws.Int64Value(a.MaxSize); current <= maxSize || current > maxSize+a.maxSizeIncrement() {
+		log.Printf("%s MaxSize changed to %d since being increased from %d, not restoring it",
+			a.name, aws.Int64Value(a.MaxSize), maxSize)
+		a.deleteMaxSizeTags()
+		return
+	}
+
+	log.Printf("%s Restoring the MaxSize left increased by a previous run to %d", a.name, maxSize)
+	if err := a.restoreMaxSize(maxSize); err == nil {
+		recapText := fmt.Sprintf("%s Restored MaxSize to %d [left increased by a previous run]", a.name, maxSize)
+		a.region.conf.addRecap(a.region.name, recapText)
+	}
+}
+
+func (a *autoScalingGroup) deleteMaxSizeTags() error {
+	_, err := a.region.services.autoScaling.DeleteTags(&autoscaling.DeleteTagsInput{
+		Tags: []*autoscaling.Tag{
+			a.groupTag(OriginalMaxSizeTag, ""),
+			a.groupTag(MaxSizeIncreasedAtTag, ""),
+		},
+	})
+	if err != nil {
+		log.Println(a.name, "Couldn't delete the original MaxSize tags:", err.Error())
+	}
+	return err
+}
+
+func (a *autoScalingGroup) groupTag(key, value string) *autoscaling.Tag {
+	if a.region != nil {
+		key = a.region.conf.tagKey(key)
+	}
+	return &autoscaling.Tag{
+		Key:               aws.String(key),
+		Value:             aws.String(value),
+		PropagateAtLaunch: aws.Bool(false),
+		ResourceId:        aws.String(a.name),
+		ResourceType:      aws.String("auto-scaling-group"),
+	}
+}
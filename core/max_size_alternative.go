@@ -0,0 +1,142 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const (
+	// StandbyMaxSizeAlternative puts the on-demand instance in standby before
+	// attaching its spot replacement, so that the group keeps its MaxSize
+	StandbyMaxSizeAlternative = "standby"
+
+	// DetachMaxSizeAlternative detaches the on-demand instance before
+	// attaching its spot replacement, so that the group keeps its MaxSize
+	DetachMaxSizeAlternative = "detach"
+)
+
+// swapWithoutMaxSizeChange replaces the on-demand instance of a group running
+// at its maximum capacity with the spot instance, without increasing the
+// MaxSize of the group, for environments where it can't be changed. The
+// on-demand instance is first taken out of the capacity of the group, and
+// brought back if the spot instance fails to be attached.
+func (a *autoScalingGroup) swapWithoutMaxSizeChange(spot, od *instance) error {
+	spotID, odID := aws.StringValue(spot.InstanceId), aws.StringValue(od.InstanceId)
+
+	release, restore := a.enterStandby, a.exitStandby
+	if a.config.MaxSizeAlternative == DetachMaxSizeAlternative {
+		release, restore = a.detachInstance, a.reattachInstance
+	}
+
+	// the on-demand instance is snapshotted while still in the group, so it
+	// can be kept there when the snapshots fail
+	if err := a.snapshotReplacedInstance(odID, spotID); err != nil {
+		return err
+	}
+
+	log.Printf("Taking on-demand instance %s out of the group %s running at its MaxSize",
+		odID, a.name)
+	if err := release(odID); err != nil {
+		return fmt.Errorf("couldn't take on-demand instance %s out of the group: %w", odID, err)
+	}
+
+	log.Printf("Attaching spot instance %s to the group %s", spotID, a.name)
+	if err := a.attachSpotInstance(spotID, true); err != nil {
+		log.Printf("Spot instance %s couldn't be attached to the group %s, restoring on-demand instance %s",
+			spotID, a.name, odID)
+		if rerr := restore(odID); rerr != nil {
+			log.Printf("On-demand instance %s couldn't be restored in the group %s: %s",
+				odID, a.name, rerr.Error())
+		}
+		if !a.isActionDisabled(TerminateSpotAction) {
+			a.region.annotateTermination(spotID, a.name, failedSwapTermination, "")
+			spot.terminate()
+		}
+		return fmt.Errorf("couldn't attach spot instance %s: %w", spotID, err)
+	}
+
+	if err := spot.copyTerminationProtection(od); err != nil {
+		return fmt.Errorf("couldn't copy termination protection from on-demand instance %s: %w",
+			odID, err)
+	}
+
+	if err := spot.copyScaleInProtection(od); err != nil {
+		return fmt.Errorf("couldn't copy scale-in protection from on-demand instance %s: %w",
+			odID, err)
+	}
+
+	// the on-demand instance is already out of the group, so it's terminated
+	// even if the spot instance couldn't be registered to Cloud Map
+	if err := a.registerInServiceDiscovery(spot, odID); err != nil {
+		log.Printf("Spot instance %s couldn't be registered to Cloud Map: %s", spotID, err.Error())
+	}
+
+	// the on-demand instance no longer counts towards the desired capacity of
+	// the group, so it's terminated without decrementing it
+	log.Printf("Terminating on-demand instance %s taken out of the group %s", odID, a.name)
+	a.deregisterFromServiceDiscovery(odID)
+	a.drainInstance(odID)
+	a.region.annotateTermination(odID, a.name, replacedBySpotTermination, spotID)
+	if err := od.terminate(); err != nil {
+		return fmt.Errorf("couldn't terminate on-demand instance %s: %w", odID, err)
+	}
+	return nil
+}
+
+func (a *autoScalingGroup) enterStandby(instanceID string) error {
+	_, err := a.region.services.autoScaling.EnterStandby(&autoscaling.EnterStandbyInput{
+		AutoScalingGroupName:           aws.String(a.name),
+		InstanceIds:                    []*string{aws.String(instanceID)},
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	})
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+	return a.waitForInstanceStatus(aws.String(instanceID), autoscaling.LifecycleStateStandby, 5)
+}
+
+func (a *autoScalingGroup) exitStandby(instanceID string) error {
+	_, err := a.region.services.autoScaling.ExitStandby(&autoscaling.ExitStandbyInput{
+		AutoScalingGroupName: aws.String(a.name),
+		InstanceIds:          []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return err
+}
+
+func (a *autoScalingGroup) detachInstance(instanceID string) error {
+	_, err := a.region.services.autoScaling.DetachInstances(&autoscaling.DetachInstancesInput{
+		AutoScalingGroupName:           aws.String(a.name),
+		InstanceIds:                    []*string{aws.String(instanceID)},
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	})
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+
+	// Wait till detachment initialize is complete before attaching the spot instance
+	a.region.conf.getClock().Sleep(20 * time.Second * a.region.conf.SleepMultiplier)
+	return nil
+}
+
+func (a *autoScalingGroup) reattachInstance(instanceID string) error {
+	_, err := a.region.services.autoScaling.AttachInstances(&autoscaling.AttachInstancesInput{
+		AutoScalingGroupName: aws.String(a.name),
+		InstanceIds:          []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		log.Println(err.Error())
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_swapWithoutMaxSizeChange(t *testing.T) {
+	states := map[string]string{
+		"i-od":   autoscaling.LifecycleStateStandby,
+		"i-spot": autoscaling.LifecycleStateInService,
+	}
+
+	tests := []struct {
+		name        string
+		alternative string
+		asg         mockASG
+		ec2         mockEC2
+		expectedErr error
+	}{
+		{
+			name:        "standby",
+			alternative: StandbyMaxSizeAlternative,
+			asg:         mockASG{dasiStates: states},
+		},
+		{
+			name:        "failing to enter standby",
+			alternative: StandbyMaxSizeAlternative,
+			asg:         mockASG{esberr: errors.New("standby error")},
+			expectedErr: errors.New("standby error"),
+		},
+		{
+			name:        "detach",
+			alternative: DetachMaxSizeAlternative,
+			asg:         mockASG{dasiStates: states},
+		},
+		{
+			name:        "failing to attach after detaching",
+			alternative: DetachMaxSizeAlternative,
+			asg:         mockASG{aierr: errors.New("attach error")},
+			expectedErr: errors.New("attach error"),
+		},
+		{
+			name:        "failing to terminate the on-demand instance",
+			alternative: DetachMaxSizeAlternative,
+			asg:         mockASG{dasiStates: states},
+			ec2:         mockEC2{tierr: errors.New("terminate error")},
+			expectedErr: errors.New("terminate error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				conf: &Config{clock: &mockClock{}},
+				services: connections{
+					autoScaling: tt.asg,
+					ec2:         tt.ec2,
+				},
+			}
+			a := &autoScalingGroup{
+				name:   "asg",
+				region: r,
+				config: AutoScalingConfig{MaxSizeAlternative: tt.alternative},
+			}
+			newInstance := func(id string) *instance {
+				return &instance{
+					Instance: &ec2.Instance{
+						InstanceId: aws.String(id),
+						State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+					},
+					region: r,
+				}
+			}
+
+			err := a.swapWithoutMaxSizeChange(newInstance("i-spot"), newInstance("i-od"))
+
+			if tt.expectedErr == nil && err != nil {
+				t.Errorf("swapWithoutMaxSizeChange() unexpected error: %v", err)
+			}
+			if tt.expectedErr != nil && (err == nil || !strings.Contains(err.Error(), tt.expectedErr.Error())) {
+				t.Errorf("swapWithoutMaxSizeChange() error = %v, expected %v", err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadMaxSizeAlternative(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected string
+	}{
+		{name: "no tag", expected: StandbyMaxSizeAlternative},
+		{name: "valid tag", tagValue: aws.String(DetachMaxSizeAlternative), expected: DetachMaxSizeAlternative},
+		{name: "invalid tag", tagValue: aws.String("resize"), expected: StandbyMaxSizeAlternative},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+					MaxSizeAlternative: StandbyMaxSizeAlternative,
+				}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(MaxSizeAlternativeTag), Value: tt.tagValue}}
+			}
+
+			a.loadMaxSizeAlternative()
+
+			if a.config.MaxSizeAlternative != tt.expected {
+				t.Errorf("MaxSizeAlternative = %q, expected %q", a.config.MaxSizeAlternative, tt.expected)
+			}
+		})
+	}
+}
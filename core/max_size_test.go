@@ -0,0 +1,153 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func Test_autoScalingGroup_increaseMaxSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		asg         mockASG
+		expectedErr bool
+	}{
+		{name: "increased", asg: mockASG{}},
+		{name: "failing to record the original MaxSize", asg: mockASG{couterr: errors.New("tag")}, expectedErr: true},
+		{name: "failing to update the group", asg: mockASG{uasgerr: errors.New("update")}, expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "asg",
+				region: &region{
+					conf:     &Config{clock: &mockClock{now: time.Now()}},
+					services: connections{autoScaling: tt.asg},
+				},
+			}
+			if err := a.increaseMaxSize(2, 1); (err != nil) != tt.expectedErr {
+				t.Errorf("increaseMaxSize() error = %v, expectedErr %v", err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_reconcileMaxSize(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		tags            map[string]string
+		maxSize         int64
+		surge           int64
+		uasgerr         error
+		budget          *errorBudget
+		expectedMaxSize int64
+		expectedRecap   []string
+	}{
+		{name: "no tags", maxSize: 3, expectedMaxSize: 3},
+		{
+			name: "left increased by a crashed run",
+			tags: map[string]string{
+				OriginalMaxSizeTag:    "2",
+				MaxSizeIncreasedAtTag: now.Add(-time.Hour).Format(time.RFC3339),
+			},
+			maxSize:         3,
+			expectedMaxSize: 2,
+			expectedRecap:   []string{"asg Restored MaxSize to 2 [left increased by a previous run]"},
+		},
+		{
+			name: "recently increased by a possibly running swap",
+			tags: map[string]string{
+				OriginalMaxSizeTag:    "2",
+				MaxSizeIncreasedAtTag: now.Add(-time.Minute).Format(time.RFC3339),
+			},
+			maxSize:         3,
+			expectedMaxSize: 3,
+		},
+		{
+			name:            "left increased by a batch",
+			tags:            map[string]string{OriginalMaxSizeTag: "2"},
+			maxSize:         5,
+			surge:           3,
+			expectedMaxSize: 2,
+			expectedRecap:   []string{"asg Restored MaxSize to 2 [left increased by a previous run]"},
+		},
+		{
+			name:            "changed beyond the surge",
+			tags:            map[string]string{OriginalMaxSizeTag: "2"},
+			maxSize:         6,
+			surge:           3,
+			expectedMaxSize: 6,
+		},
+		{
+			name:            "changed meanwhile",
+			tags:            map[string]string{OriginalMaxSizeTag: "2"},
+			maxSize:         10,
+			expectedMaxSize: 10,
+		},
+		{
+			name:            "invalid original MaxSize",
+			tags:            map[string]string{OriginalMaxSizeTag: "two"},
+			maxSize:         3,
+			expectedMaxSize: 3,
+		},
+		{
+			name:            "error budget exhausted",
+			tags:            map[string]string{OriginalMaxSizeTag: "2"},
+			maxSize:         3,
+			budget:          &errorBudget{exhausted: true},
+			expectedMaxSize: 3,
+		},
+		{
+			name:            "failing to restore",
+			tags:            map[string]string{OriginalMaxSizeTag: "2"},
+			maxSize:         3,
+			uasgerr:         errors.New("update"),
+			expectedMaxSize: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tags []*autoscaling.TagDescription
+			for k, v := range tt.tags {
+				tags = append(tags, &autoscaling.TagDescription{Key: aws.String(k), Value: aws.String(v)})
+			}
+
+			conf := &Config{
+				clock:       &mockClock{now: now},
+				FinalRecap:  map[string][]string{},
+				errorBudget: tt.budget,
+			}
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{
+					MaxSize: aws.Int64(tt.maxSize),
+					Tags:    tags,
+				},
+				name:   "asg",
+				config: AutoScalingConfig{Surge: tt.surge},
+				region: &region{
+					name:     "us-east-1",
+					conf:     conf,
+					services: connections{autoScaling: mockASG{uasgerr: tt.uasgerr}},
+				},
+			}
+
+			a.reconcileMaxSize()
+
+			if got := aws.Int64Value(a.MaxSize); got != tt.expectedMaxSize {
+				t.Errorf("MaxSize = %d, expected %d", got, tt.expectedMaxSize)
+			}
+			if got := conf.FinalRecap["us-east-1"]; !reflect.DeepEqual(got, tt.expectedRecap) {
+				t.Errorf("FinalRecap = %v, expected %v", got, tt.expectedRecap)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// isOnDemandMixedInstancesPolicy tells whether the mixed instances policy only
+// launches on-demand instances from a launch template. Such groups can be
+// handled like those using the launch template directly, while the groups
+// already launching spot capacity on their own are left alone.
+func isOnDemandMixedInstancesPolicy(p *autoscaling.MixedInstancesPolicy) bool {
+	if p == nil || p.LaunchTemplate == nil || p.LaunchTemplate.LaunchTemplateSpecification == nil {
+		return false
+	}
+
+	d := p.InstancesDistribution
+	return d == nil ||
+		d.OnDemandPercentageAboveBaseCapacity == nil ||
+		aws.Int64Value(d.OnDemandPercentageAboveBaseCapacity) == 100
+}
+
+// useMixedInstancesPolicyLaunchTemplate sets the launch template of the group
+// to the one from its mixed instances policy, so that the spot instances are
+// launched using the same configuration.
+func useMixedInstancesPolicyLaunchTemplate(group *autoscaling.Group) {
+	if group.LaunchTemplate != nil || !isOnDemandMixedInstancesPolicy(group.MixedInstancesPolicy) {
+		return
+	}
+	group.LaunchTemplate = group.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+}
+
+// launchTemplateOverrides returns the instance types listed in the overrides
+// of the group's mixed instances policy.
+func (a *autoScalingGroup) launchTemplateOverrides() []string {
+	if a.Group == nil || a.MixedInstancesPolicy == nil || a.MixedInstancesPolicy.LaunchTemplate == nil {
+		return nil
+	}
+
+	var types []string
+	for _, o := range a.MixedInstancesPolicy.LaunchTemplate.Overrides {
+		if t := aws.StringValue(o.InstanceType); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func mixedInstancesPolicy(onDemandPercentage *int64, types ...string) *autoscaling.MixedInstancesPolicy {
+	p := &autoscaling.MixedInstancesPolicy{
+		LaunchTemplate: &autoscaling.LaunchTemplate{
+			LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-1"),
+				Version:          aws.String("$Latest"),
+			},
+		},
+	}
+	if onDemandPercentage != nil {
+		p.InstancesDistribution = &autoscaling.InstancesDistribution{
+			OnDemandPercentageAboveBaseCapacity: onDemandPercentage,
+		}
+	}
+	for _, t := range types {
+		p.LaunchTemplate.Overrides = append(p.LaunchTemplate.Overrides,
+			&autoscaling.LaunchTemplateOverrides{InstanceType: aws.String(t)})
+	}
+	return p
+}
+
+func Test_isOnDemandMixedInstancesPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *autoscaling.MixedInstancesPolicy
+		want   bool
+	}{
+		{
+			name:   "no policy",
+			policy: nil,
+			want:   false,
+		},
+		{
+			name:   "missing launch template",
+			policy: &autoscaling.MixedInstancesPolicy{},
+			want:   false,
+		},
+		{
+			name:   "default instances distribution",
+			policy: mixedInstancesPolicy(nil),
+			want:   true,
+		},
+		{
+			name:   "on-demand only",
+			policy: mixedInstancesPolicy(aws.Int64(100)),
+			want:   true,
+		},
+		{
+			name:   "launching spot instances",
+			policy: mixedInstancesPolicy(aws.Int64(50)),
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOnDemandMixedInstancesPolicy(tt.policy); got != tt.want {
+				t.Errorf("isOnDemandMixedInstancesPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_useMixedInstancesPolicyLaunchTemplate(t *testing.T) {
+	ownLT := &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-own")}
+	policy := mixedInstancesPolicy(nil)
+
+	tests := []struct {
+		name  string
+		group *autoscaling.Group
+		want  *autoscaling.LaunchTemplateSpecification
+	}{
+		{
+			name:  "no policy",
+			group: &autoscaling.Group{},
+			want:  nil,
+		},
+		{
+			name:  "launch template set from the policy",
+			group: &autoscaling.Group{MixedInstancesPolicy: policy},
+			want:  policy.LaunchTemplate.LaunchTemplateSpecification,
+		},
+		{
+			name:  "existing launch template kept",
+			group: &autoscaling.Group{LaunchTemplate: ownLT, MixedInstancesPolicy: policy},
+			want:  ownLT,
+		},
+		{
+			name:  "policy launching spot instances",
+			group: &autoscaling.Group{MixedInstancesPolicy: mixedInstancesPolicy(aws.Int64(0))},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMixedInstancesPolicyLaunchTemplate(tt.group)
+			if tt.group.LaunchTemplate != tt.want {
+				t.Errorf("LaunchTemplate = %v, want %v", tt.group.LaunchTemplate, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_launchTemplateOverrides(t *testing.T) {
+	tests := []struct {
+		name  string
+		group *autoscaling.Group
+		want  []string
+	}{
+		{
+			name:  "no policy",
+			group: &autoscaling.Group{},
+			want:  nil,
+		},
+		{
+			name:  "no overrides",
+			group: &autoscaling.Group{MixedInstancesPolicy: mixedInstancesPolicy(nil)},
+			want:  nil,
+		},
+		{
+			name: "instance type overrides",
+			group: &autoscaling.Group{
+				MixedInstancesPolicy: mixedInstancesPolicy(nil, "m5.large", "m5a.large"),
+			},
+			want: []string{"m5.large", "m5a.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{Group: tt.group}
+			if got := a.launchTemplateOverrides(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("launchTemplateOverrides() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,17 +4,50 @@
 package autospotting
 
 import (
+	"io/ioutil"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/costexplorer/costexploreriface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elb/elbiface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+	"github.com/aws/aws-sdk-go/service/servicediscovery/servicediscoveryiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 )
 
 func CheckErrors(t *testing.T, err error, expected error) {
@@ -35,6 +68,9 @@ type mockEC2 struct {
 	// DescribeSpotPriceHistoryPages error
 	dsphperr error
 
+	// DescribeSpotPriceHistoryPages call count
+	dsphcalls *int
+
 	// DescribeInstancesOutput
 	dio *ec2.DescribeInstancesOutput
 
@@ -45,6 +81,18 @@ type mockEC2 struct {
 	diao   *ec2.DescribeInstanceAttributeOutput
 	diaerr error
 
+	// ModifyInstanceAttribute
+	miao   *ec2.ModifyInstanceAttributeOutput
+	miaerr error
+
+	// DescribeInstanceStatus
+	diso   *ec2.DescribeInstanceStatusOutput
+	diserr error
+
+	// CancelSpotInstanceRequests
+	csiro   *ec2.CancelSpotInstanceRequestsOutput
+	csirerr error
+
 	// DescribeImagesOutput
 	damio   *ec2.DescribeImagesOutput
 	damierr error
@@ -52,6 +100,8 @@ type mockEC2 struct {
 	// Terminate Instance
 	tio   *ec2.TerminateInstancesOutput
 	tierr error
+	// the inputs of the TerminateInstances calls
+	tiin *[]*ec2.TerminateInstancesInput
 
 	// Describe Regions
 	dro   *ec2.DescribeRegionsOutput
@@ -60,6 +110,13 @@ type mockEC2 struct {
 	// Delete Tags
 	dto   *ec2.DeleteTagsOutput
 	dterr error
+	// the inputs of the DeleteTags calls
+	dtin *[]*ec2.DeleteTagsInput
+
+	// CreateTags
+	cterr error
+	// the inputs of the CreateTags calls
+	ctin *[]*ec2.CreateTagsInput
 
 	// DescribeLaunchTemplateVersionsOutput
 	dltvo   *ec2.DescribeLaunchTemplateVersionsOutput
@@ -67,9 +124,48 @@ type mockEC2 struct {
 
 	// WaitUntilInstanceRunning error
 	wuirerr error
+
+	// DescribeInstanceTypesPages output
+	ditpo   []*ec2.DescribeInstanceTypesOutput
+	ditperr error
+
+	// DescribeInstanceTypeOfferingsPages output
+	ditopo   []*ec2.DescribeInstanceTypeOfferingsOutput
+	ditoperr error
+
+	// error of the DryRun calls of DescribeInstances, DescribeInstanceTypes
+	// and DescribeSpotPriceHistory
+	dryrunerr error
+
+	// DescribeSpotInstanceRequestsPages
+	dsiro   *ec2.DescribeSpotInstanceRequestsOutput
+	dsirerr error
+
+	// CreateVolume error, used by the DryRun feature probes
+	cverr error
+	// CreateVolume call count
+	cvcalls *int
+
+	// CreateSnapshots
+	csso   *ec2.CreateSnapshotsOutput
+	csserr error
+	// the inputs of the CreateSnapshots calls
+	cssin *[]*ec2.CreateSnapshotsInput
+
+	// DescribeSnapshotsPages
+	dspo   *ec2.DescribeSnapshotsOutput
+	dsperr error
+
+	// DeleteSnapshot
+	dserr error
+	// the inputs of the DeleteSnapshot calls
+	dsin *[]*ec2.DeleteSnapshotInput
 }
 
 func (m mockEC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryInput, f func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool) error {
+	if m.dsphcalls != nil {
+		*m.dsphcalls++
+	}
 	for i, page := range m.dsphpo {
 		f(page, i == len(m.dsphpo)-1)
 	}
@@ -85,30 +181,116 @@ func (m mockEC2) DescribeInstanceAttribute(in *ec2.DescribeInstanceAttributeInpu
 	return m.diao, m.diaerr
 }
 
+func (m mockEC2) ModifyInstanceAttribute(in *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	return m.miao, m.miaerr
+}
+
+func (m mockEC2) DescribeInstanceTypesPages(in *ec2.DescribeInstanceTypesInput, f func(*ec2.DescribeInstanceTypesOutput, bool) bool) error {
+	for i, page := range m.ditpo {
+		f(page, i == len(m.ditpo)-1)
+	}
+	return m.ditperr
+}
+
+func (m mockEC2) DescribeInstanceStatus(in *ec2.DescribeInstanceStatusInput) (*ec2.DescribeInstanceStatusOutput, error) {
+	return m.diso, m.diserr
+}
+
+func (m mockEC2) CancelSpotInstanceRequests(in *ec2.CancelSpotInstanceRequestsInput) (*ec2.CancelSpotInstanceRequestsOutput, error) {
+	return m.csiro, m.csirerr
+}
+
 func (m mockEC2) DescribeImages(in *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
 	return m.damio, m.damierr
 }
 
-func (m mockEC2) TerminateInstances(*ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+func (m mockEC2) TerminateInstances(in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	if m.tiin != nil {
+		*m.tiin = append(*m.tiin, in)
+	}
 	return m.tio, m.tierr
 }
 
+func (m mockEC2) DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return m.dio, m.dryrunerr
+}
+
+func (m mockEC2) DescribeInstanceTypes(*ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	return &ec2.DescribeInstanceTypesOutput{}, m.dryrunerr
+}
+
+func (m mockEC2) DescribeSpotPriceHistory(*ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error) {
+	return &ec2.DescribeSpotPriceHistoryOutput{}, m.dryrunerr
+}
+
 func (m mockEC2) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
 	return m.dro, m.drerr
 }
 
-func (m mockEC2) DeleteTags(*ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+func (m mockEC2) DeleteTags(in *ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+	if m.dtin != nil {
+		*m.dtin = append(*m.dtin, in)
+	}
 	return m.dto, m.dterr
 }
 
+func (m mockEC2) CreateTags(in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	if m.ctin != nil {
+		*m.ctin = append(*m.ctin, in)
+	}
+	return &ec2.CreateTagsOutput{}, m.cterr
+}
+
 func (m mockEC2) DescribeLaunchTemplateVersions(*ec2.DescribeLaunchTemplateVersionsInput) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
 	return m.dltvo, m.dltverr
 }
 
+func (m mockEC2) DescribeInstanceTypeOfferingsPages(in *ec2.DescribeInstanceTypeOfferingsInput, f func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool) error {
+	for i, page := range m.ditopo {
+		f(page, i == len(m.ditopo)-1)
+	}
+	return m.ditoperr
+}
+
+func (m mockEC2) DescribeSpotInstanceRequestsPages(in *ec2.DescribeSpotInstanceRequestsInput, f func(*ec2.DescribeSpotInstanceRequestsOutput, bool) bool) error {
+	if m.dsiro != nil {
+		f(m.dsiro, true)
+	}
+	return m.dsirerr
+}
+
 func (m mockEC2) WaitUntilInstanceRunning(*ec2.DescribeInstancesInput) error {
 	return m.wuirerr
 }
 
+func (m mockEC2) CreateSnapshots(in *ec2.CreateSnapshotsInput) (*ec2.CreateSnapshotsOutput, error) {
+	if m.cssin != nil {
+		*m.cssin = append(*m.cssin, in)
+	}
+	return m.csso, m.csserr
+}
+
+func (m mockEC2) DescribeSnapshotsPages(in *ec2.DescribeSnapshotsInput, f func(*ec2.DescribeSnapshotsOutput, bool) bool) error {
+	if m.dspo != nil {
+		f(m.dspo, true)
+	}
+	return m.dsperr
+}
+
+func (m mockEC2) DeleteSnapshot(in *ec2.DeleteSnapshotInput) (*ec2.DeleteSnapshotOutput, error) {
+	if m.dsin != nil {
+		*m.dsin = append(*m.dsin, in)
+	}
+	return &ec2.DeleteSnapshotOutput{}, m.dserr
+}
+
+func (m mockEC2) CreateVolume(*ec2.CreateVolumeInput) (*ec2.Volume, error) {
+	if m.cvcalls != nil {
+		*m.cvcalls++
+	}
+	return nil, m.cverr
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockASG struct {
@@ -116,9 +298,13 @@ type mockASG struct {
 	// Detach Instances
 	dio   *autoscaling.DetachInstancesOutput
 	dierr error
+	// the inputs of the DetachInstances calls
+	diin *[]*autoscaling.DetachInstancesInput
 	// Terminate Instances
 	tiiasgo   *autoscaling.TerminateInstanceInAutoScalingGroupOutput
 	tiiasgerr error
+	// the inputs of the TerminateInstanceInAutoScalingGroup calls
+	tiiasgin *[]*autoscaling.TerminateInstanceInAutoScalingGroupInput
 	// Attach Instances
 	aio   *autoscaling.AttachInstancesOutput
 	aierr error
@@ -138,6 +324,10 @@ type mockASG struct {
 	// Describe AutoScalingInstances
 	dasio   *autoscaling.DescribeAutoScalingInstancesOutput
 	dasierr error
+	// lifecycle states by instance ID, used instead of dasio when set
+	dasiStates map[string]string
+	// health statuses by instance ID, reported along the dasiStates
+	dasiHealth map[string]string
 
 	// DescribeLifecycleHooks
 	dlho   *autoscaling.DescribeLifecycleHooksOutput
@@ -146,13 +336,111 @@ type mockASG struct {
 	// CreateOrUpdateTags
 	couto   *autoscaling.CreateOrUpdateTagsOutput
 	couterr error
+	// number of CreateOrUpdateTags calls
+	coutcalls *int
+
+	// DeleteTags
+	delto   *autoscaling.DeleteTagsOutput
+	delterr error
+	// number of DeleteTags calls
+	deltcalls *int
+
+	// DescribeScheduledActions
+	dsao   *autoscaling.DescribeScheduledActionsOutput
+	dsaerr error
+
+	// DescribePolicies
+	dpo   *autoscaling.DescribePoliciesOutput
+	dperr error
+
+	// EnterStandby
+	esbo   *autoscaling.EnterStandbyOutput
+	esberr error
+
+	// ExitStandby
+	exsbo   *autoscaling.ExitStandbyOutput
+	exsberr error
+
+	// DescribeScalingActivities
+	dsaco   *autoscaling.DescribeScalingActivitiesOutput
+	dsacerr error
+
+	// SetInstanceProtection
+	siperr error
+	// the inputs of the SetInstanceProtection calls
+	sipin *[]*autoscaling.SetInstanceProtectionInput
+
+	// ResumeProcesses
+	rperr error
+	// the inputs of the ResumeProcesses calls
+	rpin *[]*autoscaling.ScalingProcessQuery
+}
+
+func (m mockASG) SuspendProcesses(*autoscaling.ScalingProcessQuery) (*autoscaling.SuspendProcessesOutput, error) {
+	return &autoscaling.SuspendProcessesOutput{}, nil
 }
 
-func (m mockASG) DetachInstances(*autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
+func (m mockASG) ResumeProcesses(in *autoscaling.ScalingProcessQuery) (*autoscaling.ResumeProcessesOutput, error) {
+	if m.rpin != nil {
+		*m.rpin = append(*m.rpin, in)
+	}
+	return &autoscaling.ResumeProcessesOutput{}, m.rperr
+}
+
+func (m mockASG) DescribeScalingActivitiesPages(input *autoscaling.DescribeScalingActivitiesInput, function func(*autoscaling.DescribeScalingActivitiesOutput, bool) bool) error {
+	if m.dsaco != nil {
+		function(m.dsaco, true)
+	}
+	return m.dsacerr
+}
+
+func (m mockASG) SetInstanceProtection(in *autoscaling.SetInstanceProtectionInput) (*autoscaling.SetInstanceProtectionOutput, error) {
+	if m.sipin != nil {
+		*m.sipin = append(*m.sipin, in)
+	}
+	return &autoscaling.SetInstanceProtectionOutput{}, m.siperr
+}
+
+func (m mockASG) DeleteTags(*autoscaling.DeleteTagsInput) (*autoscaling.DeleteTagsOutput, error) {
+	if m.deltcalls != nil {
+		*m.deltcalls++
+	}
+	return m.delto, m.delterr
+}
+
+func (m mockASG) DescribeScheduledActionsPages(input *autoscaling.DescribeScheduledActionsInput, function func(*autoscaling.DescribeScheduledActionsOutput, bool) bool) error {
+	if m.dsao != nil {
+		function(m.dsao, true)
+	}
+	return m.dsaerr
+}
+
+func (m mockASG) DescribePoliciesPages(input *autoscaling.DescribePoliciesInput, function func(*autoscaling.DescribePoliciesOutput, bool) bool) error {
+	if m.dpo != nil {
+		function(m.dpo, true)
+	}
+	return m.dperr
+}
+
+func (m mockASG) EnterStandby(*autoscaling.EnterStandbyInput) (*autoscaling.EnterStandbyOutput, error) {
+	return m.esbo, m.esberr
+}
+
+func (m mockASG) ExitStandby(*autoscaling.ExitStandbyInput) (*autoscaling.ExitStandbyOutput, error) {
+	return m.exsbo, m.exsberr
+}
+
+func (m mockASG) DetachInstances(in *autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
+	if m.diin != nil {
+		*m.diin = append(*m.diin, in)
+	}
 	return m.dio, m.dierr
 }
 
-func (m mockASG) TerminateInstanceInAutoScalingGroup(*autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+func (m mockASG) TerminateInstanceInAutoScalingGroup(in *autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	if m.tiiasgin != nil {
+		*m.tiiasgin = append(*m.tiiasgin, in)
+	}
 	return m.tiiasgo, m.tiiasgerr
 }
 
@@ -183,6 +471,19 @@ func (m mockASG) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoS
 }
 
 func (m mockASG) DescribeAutoScalingInstances(inout *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	if m.dasiStates != nil {
+		out := &autoscaling.DescribeAutoScalingInstancesOutput{}
+		for _, id := range inout.InstanceIds {
+			if state, ok := m.dasiStates[aws.StringValue(id)]; ok {
+				out.AutoScalingInstances = append(out.AutoScalingInstances, &autoscaling.InstanceDetails{
+					InstanceId:     id,
+					LifecycleState: aws.String(state),
+					HealthStatus:   aws.String(m.dasiHealth[aws.StringValue(id)]),
+				})
+			}
+		}
+		return out, m.dasierr
+	}
 	return m.dasio, m.dasierr
 }
 
@@ -191,6 +492,9 @@ func (m mockASG) DescribeLifecycleHooks(*autoscaling.DescribeLifecycleHooksInput
 }
 
 func (m mockASG) CreateOrUpdateTags(*autoscaling.CreateOrUpdateTagsInput) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	if m.coutcalls != nil {
+		*m.coutcalls++
+	}
 	return m.couto, m.couterr
 }
 
@@ -201,12 +505,56 @@ type mockCloudFormation struct {
 	// DescribeStacks
 	dso   *cloudformation.DescribeStacksOutput
 	dserr error
+	// DescribeStackSet
+	dsserr error
+	// CreateStackSet
+	cssin *[]*cloudformation.CreateStackSetInput
+	// UpdateStackSet
+	ussin *[]*cloudformation.UpdateStackSetInput
+	// ListStackInstancesPages
+	lsio *cloudformation.ListStackInstancesOutput
+	// CreateStackInstances
+	csiin *[]*cloudformation.CreateStackInstancesInput
+	// DescribeStackSetOperation, the status of all the operations
+	dssostatus string
 }
 
 func (m mockCloudFormation) DescribeStacks(*cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
 	return m.dso, m.dserr
 }
 
+func (m mockCloudFormation) DescribeStackSet(*cloudformation.DescribeStackSetInput) (*cloudformation.DescribeStackSetOutput, error) {
+	return &cloudformation.DescribeStackSetOutput{}, m.dsserr
+}
+
+func (m mockCloudFormation) CreateStackSet(in *cloudformation.CreateStackSetInput) (*cloudformation.CreateStackSetOutput, error) {
+	*m.cssin = append(*m.cssin, in)
+	return &cloudformation.CreateStackSetOutput{}, nil
+}
+
+func (m mockCloudFormation) UpdateStackSet(in *cloudformation.UpdateStackSetInput) (*cloudformation.UpdateStackSetOutput, error) {
+	*m.ussin = append(*m.ussin, in)
+	return &cloudformation.UpdateStackSetOutput{OperationId: aws.String("update")}, nil
+}
+
+func (m mockCloudFormation) ListStackInstancesPages(in *cloudformation.ListStackInstancesInput, f func(*cloudformation.ListStackInstancesOutput, bool) bool) error {
+	if m.lsio != nil {
+		f(m.lsio, true)
+	}
+	return nil
+}
+
+func (m mockCloudFormation) CreateStackInstances(in *cloudformation.CreateStackInstancesInput) (*cloudformation.CreateStackInstancesOutput, error) {
+	*m.csiin = append(*m.csiin, in)
+	return &cloudformation.CreateStackInstancesOutput{OperationId: aws.String("create")}, nil
+}
+
+func (m mockCloudFormation) DescribeStackSetOperation(*cloudformation.DescribeStackSetOperationInput) (*cloudformation.DescribeStackSetOperationOutput, error) {
+	return &cloudformation.DescribeStackSetOperationOutput{
+		StackSetOperation: &cloudformation.StackSetOperation{Status: aws.String(m.dssostatus)},
+	}, nil
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockSQS struct {
@@ -214,16 +562,375 @@ type mockSQS struct {
 	// SendMessage
 	smo   *sqs.SendMessageOutput
 	smerr error
+	smin  *[]*sqs.SendMessageInput
 
 	//DeleteMessage
 	dmo   *sqs.DeleteMessageOutput
 	dmerr error
 }
 
-func (m mockSQS) SendMessage(*sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+func (m mockSQS) SendMessage(in *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	if m.smin != nil {
+		*m.smin = append(*m.smin, in)
+	}
 	return m.smo, m.smerr
 }
 
 func (m mockSQS) DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
 	return m.dmo, m.dmerr
 }
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockEKS struct {
+	eksiface.EKSAPI
+	// DescribeNodegroup
+	dno   *eks.DescribeNodegroupOutput
+	dnerr error
+}
+
+func (m mockEKS) DescribeNodegroup(*eks.DescribeNodegroupInput) (*eks.DescribeNodegroupOutput, error) {
+	return m.dno, m.dnerr
+}
+
+type mockELB struct {
+	elbiface.ELBAPI
+	// DescribeInstanceHealth
+	diho   *elb.DescribeInstanceHealthOutput
+	diherr error
+	// DescribeLoadBalancerAttributes
+	dlbao   *elb.DescribeLoadBalancerAttributesOutput
+	dlbaerr error
+	// DeregisterInstancesFromLoadBalancer
+	difserr error
+	// number of DeregisterInstancesFromLoadBalancer calls
+	difscalls *int
+}
+
+func (m mockELB) DescribeInstanceHealth(*elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error) {
+	return m.diho, m.diherr
+}
+
+func (m mockELB) DescribeLoadBalancerAttributes(*elb.DescribeLoadBalancerAttributesInput) (*elb.DescribeLoadBalancerAttributesOutput, error) {
+	return m.dlbao, m.dlbaerr
+}
+
+func (m mockELB) DeregisterInstancesFromLoadBalancer(*elb.DeregisterInstancesFromLoadBalancerInput) (*elb.DeregisterInstancesFromLoadBalancerOutput, error) {
+	if m.difscalls != nil {
+		*m.difscalls++
+	}
+	return &elb.DeregisterInstancesFromLoadBalancerOutput{}, m.difserr
+}
+
+type mockELBV2 struct {
+	elbv2iface.ELBV2API
+	// DescribeTargetHealth
+	dtho   *elbv2.DescribeTargetHealthOutput
+	dtherr error
+	// DescribeTargetGroupAttributes
+	dtgao   *elbv2.DescribeTargetGroupAttributesOutput
+	dtgaerr error
+	// DeregisterTargets
+	dterr error
+	// number of DeregisterTargets calls
+	dtcalls *int
+}
+
+func (m mockELBV2) DescribeTargetHealth(*elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	return m.dtho, m.dtherr
+}
+
+func (m mockELBV2) DescribeTargetGroupAttributes(*elbv2.DescribeTargetGroupAttributesInput) (*elbv2.DescribeTargetGroupAttributesOutput, error) {
+	return m.dtgao, m.dtgaerr
+}
+
+func (m mockELBV2) DeregisterTargets(*elbv2.DeregisterTargetsInput) (*elbv2.DeregisterTargetsOutput, error) {
+	if m.dtcalls != nil {
+		*m.dtcalls++
+	}
+	return &elbv2.DeregisterTargetsOutput{}, m.dterr
+}
+
+type mockServiceDiscovery struct {
+	servicediscoveryiface.ServiceDiscoveryAPI
+	// ListNamespacesPages
+	lno   *servicediscovery.ListNamespacesOutput
+	lnerr error
+	// ListServicesPages
+	lso   *servicediscovery.ListServicesOutput
+	lserr error
+	// GetInstance
+	gio   *servicediscovery.GetInstanceOutput
+	gierr error
+	// RegisterInstance
+	rierr error
+	riin  *[]*servicediscovery.RegisterInstanceInput
+	// DeregisterInstance
+	dierr error
+	diin  *[]*servicediscovery.DeregisterInstanceInput
+}
+
+func (m mockServiceDiscovery) ListNamespacesPages(in *servicediscovery.ListNamespacesInput, f func(*servicediscovery.ListNamespacesOutput, bool) bool) error {
+	if m.lno != nil {
+		f(m.lno, true)
+	}
+	return m.lnerr
+}
+
+func (m mockServiceDiscovery) ListServicesPages(in *servicediscovery.ListServicesInput, f func(*servicediscovery.ListServicesOutput, bool) bool) error {
+	if m.lso != nil {
+		f(m.lso, true)
+	}
+	return m.lserr
+}
+
+func (m mockServiceDiscovery) GetInstance(*servicediscovery.GetInstanceInput) (*servicediscovery.GetInstanceOutput, error) {
+	return m.gio, m.gierr
+}
+
+func (m mockServiceDiscovery) RegisterInstance(in *servicediscovery.RegisterInstanceInput) (*servicediscovery.RegisterInstanceOutput, error) {
+	if m.riin != nil {
+		*m.riin = append(*m.riin, in)
+	}
+	return &servicediscovery.RegisterInstanceOutput{}, m.rierr
+}
+
+func (m mockServiceDiscovery) DeregisterInstance(in *servicediscovery.DeregisterInstanceInput) (*servicediscovery.DeregisterInstanceOutput, error) {
+	if m.diin != nil {
+		*m.diin = append(*m.diin, in)
+	}
+	return &servicediscovery.DeregisterInstanceOutput{}, m.dierr
+}
+
+type mockSSM struct {
+	ssmiface.SSMAPI
+	// GetParameter
+	gpo   *ssm.GetParameterOutput
+	gperr error
+	// number of GetParameter calls
+	gpcalls *int
+	// DescribeInstanceInformation
+	diio   *ssm.DescribeInstanceInformationOutput
+	diierr error
+}
+
+func (m mockSSM) DescribeInstanceInformation(*ssm.DescribeInstanceInformationInput) (*ssm.DescribeInstanceInformationOutput, error) {
+	return m.diio, m.diierr
+}
+
+func (m mockSSM) GetParameter(*ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	if m.gpcalls != nil {
+		*m.gpcalls++
+	}
+	return m.gpo, m.gperr
+}
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockCostExplorer struct {
+	costexploreriface.CostExplorerAPI
+	// GetCostAndUsage, returning the pages in order
+	gcauo   []*costexplorer.GetCostAndUsageOutput
+	gcauerr error
+}
+
+func (m mockCostExplorer) GetCostAndUsage(in *costexplorer.GetCostAndUsageInput) (*costexplorer.GetCostAndUsageOutput, error) {
+	if m.gcauerr != nil {
+		return nil, m.gcauerr
+	}
+	page := 0
+	if in.NextPageToken != nil {
+		page, _ = strconv.Atoi(*in.NextPageToken)
+	}
+	return m.gcauo[page], nil
+}
+
+type mockPricing struct {
+	pricingiface.PricingAPI
+	// GetProductsPages
+	gpo   *pricing.GetProductsOutput
+	gperr error
+	// number of GetProductsPages calls
+	gpcalls *int
+}
+
+func (m mockPricing) GetProductsPages(in *pricing.GetProductsInput, fn func(*pricing.GetProductsOutput, bool) bool) error {
+	if m.gpcalls != nil {
+		*m.gpcalls++
+	}
+	if m.gperr != nil {
+		return m.gperr
+	}
+	fn(m.gpo, true)
+	return nil
+}
+
+type mockS3 struct {
+	s3iface.S3API
+	// GetObject, returning the content as the object body
+	goContent string
+	goerr     error
+	// PutObject, recording the object bodies by key
+	poBodies map[string][]byte
+	poerr    error
+}
+
+func (m mockS3) GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if m.goerr != nil {
+		return nil, m.goerr
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(m.goContent))}, nil
+}
+
+func (m mockS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if m.poerr != nil {
+		return nil, m.poerr
+	}
+	if m.poBodies != nil {
+		body, _ := ioutil.ReadAll(in.Body)
+		m.poBodies[aws.StringValue(in.Key)] = body
+	}
+	return &s3.PutObjectOutput{}, nil
+}
+
+type mockCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	// PutMetricData error
+	pmderr error
+	// PutMetricAlarm error
+	pmaerr error
+	// names of the called API methods
+	calls *[]string
+	// PutMetricData inputs
+	pmdin *[]*cloudwatch.PutMetricDataInput
+}
+
+func (m mockCloudWatch) PutMetricData(in *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error) {
+	*m.calls = append(*m.calls, "PutMetricData")
+	if m.pmdin != nil {
+		*m.pmdin = append(*m.pmdin, in)
+	}
+	return &cloudwatch.PutMetricDataOutput{}, m.pmderr
+}
+
+func (m mockCloudWatch) PutMetricAlarm(*cloudwatch.PutMetricAlarmInput) (*cloudwatch.PutMetricAlarmOutput, error) {
+	*m.calls = append(*m.calls, "PutMetricAlarm")
+	return &cloudwatch.PutMetricAlarmOutput{}, m.pmaerr
+}
+
+type mockIAM struct {
+	iamiface.IAMAPI
+	// GetRole
+	gro   *iam.GetRoleOutput
+	grerr error
+	// SimulatePrincipalPolicyPages
+	sppo   *iam.SimulatePolicyResponse
+	spperr error
+}
+
+func (m mockIAM) GetRole(*iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	return m.gro, m.grerr
+}
+
+func (m mockIAM) SimulatePrincipalPolicyPages(in *iam.SimulatePrincipalPolicyInput, f func(*iam.SimulatePolicyResponse, bool) bool) error {
+	if m.spperr != nil {
+		return m.spperr
+	}
+	f(m.sppo, true)
+	return nil
+}
+
+type mockSTS struct {
+	stsiface.STSAPI
+	// GetCallerIdentity
+	gcio   *sts.GetCallerIdentityOutput
+	gcierr error
+}
+
+func (m mockSTS) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return m.gcio, m.gcierr
+}
+
+type mockSNS struct {
+	snsiface.SNSAPI
+	// Publish
+	perr error
+	pin  *[]*sns.PublishInput
+}
+
+func (m mockSNS) Publish(in *sns.PublishInput) (*sns.PublishOutput, error) {
+	if m.pin != nil {
+		*m.pin = append(*m.pin, in)
+	}
+	return &sns.PublishOutput{}, m.perr
+}
+
+type mockEventBridge struct {
+	eventbridgeiface.EventBridgeAPI
+	// PutEvents
+	peo   *eventbridge.PutEventsOutput
+	peerr error
+	pein  *[]*eventbridge.PutEventsInput
+}
+
+func (m mockEventBridge) PutEvents(in *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+	if m.pein != nil {
+		*m.pein = append(*m.pein, in)
+	}
+	if m.peo == nil {
+		return &eventbridge.PutEventsOutput{}, m.peerr
+	}
+	return m.peo, m.peerr
+}
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockOrganizations struct {
+	organizationsiface.OrganizationsAPI
+	// ListRootsPages
+	lro   *organizations.ListRootsOutput
+	lrerr error
+
+	// ListAccountsForParentPages, by parent ID
+	lafpo   map[string]*organizations.ListAccountsForParentOutput
+	lafperr error
+
+	// ListOrganizationalUnitsForParentPages, by parent ID
+	loufpo map[string]*organizations.ListOrganizationalUnitsForParentOutput
+}
+
+func (m mockOrganizations) ListRootsPages(in *organizations.ListRootsInput, fn func(*organizations.ListRootsOutput, bool) bool) error {
+	if m.lro != nil {
+		fn(m.lro, true)
+	}
+	return m.lrerr
+}
+
+func (m mockOrganizations) ListAccountsForParentPages(in *organizations.ListAccountsForParentInput, fn func(*organizations.ListAccountsForParentOutput, bool) bool) error {
+	if out, found := m.lafpo[aws.StringValue(in.ParentId)]; found {
+		fn(out, true)
+	}
+	return m.lafperr
+}
+
+func (m mockOrganizations) ListOrganizationalUnitsForParentPages(in *organizations.ListOrganizationalUnitsForParentInput, fn func(*organizations.ListOrganizationalUnitsForParentOutput, bool) bool) error {
+	if out, found := m.loufpo[aws.StringValue(in.ParentId)]; found {
+		fn(out, true)
+	}
+	return nil
+}
+
+// mockClock is a Clock whose time only advances when sleeping
+type mockClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (m *mockClock) Now() time.Time {
+	return m.now
+}
+
+func (m *mockClock) Sleep(d time.Duration) {
+	m.slept += d
+	m.now = m.now.Add(d)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// kubernetesClusterTagPrefix prefixes the tag identifying the cluster of the
+// self-managed Kubernetes nodes
+const kubernetesClusterTagPrefix = "kubernetes.io/cluster/"
+
+// isEKSBackedGroup determines if the instances of the group are Kubernetes
+// nodes, either of an EKS managed nodegroup or of a self-managed one.
+func isEKSBackedGroup(group *autoscaling.Group) bool {
+	for _, tag := range group.Tags {
+		key := aws.StringValue(tag.Key)
+		if key == eksClusterNameTag || strings.HasPrefix(key, kubernetesClusterTagPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeTerminationHandlerQueue returns the URL of the SQS queue of the
+// aws-node-termination-handler draining the nodes of the group, which can be
+// set on the group as a tag, overriding the global configuration.
+func (s *SpotTermination) nodeTerminationHandlerQueue(group *autoscaling.Group) string {
+	for _, tag := range group.Tags {
+		if aws.StringValue(tag.Key) == NodeTerminationHandlerQueueTag {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return s.conf.NodeTerminationHandlerQueue
+}
+
+// notifyNodeTerminationHandler forwards the interruption event of a node from
+// an EKS-backed group to the SQS queue processed by the
+// aws-node-termination-handler running in its cluster, which then cordons and
+// drains the node. The handler consumes the events in their EventBridge
+// format, so they're forwarded unchanged.
+func (s *SpotTermination) notifyNodeTerminationHandler(event *events.CloudWatchEvent, instanceID *string) error {
+	if s.sqsSvc == nil || event == nil {
+		return nil
+	}
+
+	asgName, err := s.getAsgName(instanceID)
+	if err != nil || asgName == "" {
+		return err
+	}
+
+	out, err := s.asSvc.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgName)},
+	})
+	if err != nil {
+		log.Printf("Failed to get ASG using ASG name %s with err: %s\n", asgName, err.Error())
+		return err
+	}
+	if len(out.AutoScalingGroups) == 0 || !isEKSBackedGroup(out.AutoScalingGroups[0]) {
+		return nil
+	}
+
+	queue := s.nodeTerminationHandlerQueue(out.AutoScalingGroups[0])
+	if queue == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Couldn't encode the event of instance", *instanceID, err.Error())
+		return err
+	}
+
+	log.Printf("%s Notifying the node termination handler queue %s of the %s of instance %s",
+		asgName, queue, event.DetailType, *instanceID)
+
+	if _, err := s.sqsSvc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(queue),
+		MessageBody: aws.String(string(body)),
+	}); err != nil {
+		log.Printf("Failed to notify the node termination handler of instance %s: %s\n", *instanceID, err.Error())
+		return err
+	}
+	return nil
+}
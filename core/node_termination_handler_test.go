@@ -0,0 +1,164 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func Test_isEKSBackedGroup(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []*autoscaling.TagDescription
+		want bool
+	}{
+		{
+			name: "no tags",
+		},
+		{
+			name: "EKS managed nodegroup",
+			tags: []*autoscaling.TagDescription{{Key: aws.String(eksClusterNameTag), Value: aws.String("prod")}},
+			want: true,
+		},
+		{
+			name: "self-managed nodes",
+			tags: []*autoscaling.TagDescription{{Key: aws.String("kubernetes.io/cluster/prod"), Value: aws.String("owned")}},
+			want: true,
+		},
+		{
+			name: "other tags",
+			tags: []*autoscaling.TagDescription{{Key: aws.String("Name"), Value: aws.String("web")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isEKSBackedGroup(&autoscaling.Group{Tags: tt.tags}); got != tt.want {
+				t.Errorf("isEKSBackedGroup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifyNodeTerminationHandler(t *testing.T) {
+	eksGroup := &autoscaling.Group{
+		AutoScalingGroupName: aws.String("asg"),
+		Tags:                 []*autoscaling.TagDescription{{Key: aws.String(eksClusterNameTag), Value: aws.String("prod")}},
+	}
+	event := &events.CloudWatchEvent{
+		Version:    "0",
+		ID:         "7e5fd0b7-fdd1-4a6e-b6fe-b4d3a9e4a2b0",
+		DetailType: "EC2 Spot Instance Interruption Warning",
+		Source:     "aws.ec2",
+		Region:     "us-east-1",
+		Detail:     json.RawMessage(`{"instance-id":"i-spot","instance-action":"terminate"}`),
+	}
+	groupInstance := &autoscaling.DescribeAutoScalingInstancesOutput{
+		AutoScalingInstances: []*autoscaling.InstanceDetails{{AutoScalingGroupName: aws.String("asg")}},
+	}
+
+	tests := []struct {
+		name      string
+		group     *autoscaling.Group
+		conf      *Config
+		dasio     *autoscaling.DescribeAutoScalingInstancesOutput
+		smerr     error
+		wantErr   bool
+		wantQueue string
+	}{
+		{
+			name:  "instance not in a group",
+			group: eksGroup,
+			conf:  &Config{NodeTerminationHandlerQueue: "https://sqs/nth"},
+			dasio: &autoscaling.DescribeAutoScalingInstancesOutput{},
+		},
+		{
+			name:  "group not backing Kubernetes nodes",
+			group: &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+			conf:  &Config{NodeTerminationHandlerQueue: "https://sqs/nth"},
+			dasio: groupInstance,
+		},
+		{
+			name:  "no queue configured",
+			group: eksGroup,
+			conf:  &Config{},
+			dasio: groupInstance,
+		},
+		{
+			name:      "globally configured queue",
+			group:     eksGroup,
+			conf:      &Config{NodeTerminationHandlerQueue: "https://sqs/nth"},
+			dasio:     groupInstance,
+			wantQueue: "https://sqs/nth",
+		},
+		{
+			name: "queue overridden by the group",
+			group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				Tags: []*autoscaling.TagDescription{
+					{Key: aws.String("kubernetes.io/cluster/prod"), Value: aws.String("owned")},
+					{Key: aws.String(NodeTerminationHandlerQueueTag), Value: aws.String("https://sqs/prod-nth")},
+				},
+			},
+			conf:      &Config{NodeTerminationHandlerQueue: "https://sqs/nth"},
+			dasio:     groupInstance,
+			wantQueue: "https://sqs/prod-nth",
+		},
+		{
+			name:      "failing to send the message",
+			group:     eksGroup,
+			conf:      &Config{NodeTerminationHandlerQueue: "https://sqs/nth"},
+			dasio:     groupInstance,
+			smerr:     errors.New("access denied"),
+			wantErr:   true,
+			wantQueue: "https://sqs/nth",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []*sqs.SendMessageInput
+			s := &SpotTermination{
+				asSvc: mockASG{
+					dasio: tt.dasio,
+					dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{tt.group}},
+				},
+				sqsSvc: mockSQS{smerr: tt.smerr, smin: &sent},
+				conf:   tt.conf,
+			}
+
+			err := s.notifyNodeTerminationHandler(event, aws.String("i-spot"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("notifyNodeTerminationHandler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantQueue == "" {
+				if len(sent) != 0 {
+					t.Errorf("notifyNodeTerminationHandler() sent %v, expected no messages", sent)
+				}
+				return
+			}
+			if len(sent) != 1 {
+				t.Fatalf("notifyNodeTerminationHandler() sent %d messages, expected 1", len(sent))
+			}
+			if queue := aws.StringValue(sent[0].QueueUrl); queue != tt.wantQueue {
+				t.Errorf("notifyNodeTerminationHandler() sent to %s, expected %s", queue, tt.wantQueue)
+			}
+
+			var forwarded events.CloudWatchEvent
+			if err := json.Unmarshal([]byte(aws.StringValue(sent[0].MessageBody)), &forwarded); err != nil {
+				t.Fatalf("notifyNodeTerminationHandler() sent an invalid message: %s", err.Error())
+			}
+			if forwarded.ID != event.ID || forwarded.DetailType != event.DetailType ||
+				string(forwarded.Detail) != string(event.Detail) {
+				t.Errorf("notifyNodeTerminationHandler() forwarded %v, expected %v", forwarded, *event)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+)
+
+// organizationRoleSessionName is the session name of the roles assumed in the
+// member accounts, showing up in their CloudTrail logs.
+const organizationRoleSessionName = "AutoSpotting"
+
+// organizationAccount is an active member account of the organization.
+type organizationAccount struct {
+	id   string
+	name string
+
+	// the IDs of the organizational units containing the account, from the
+	// root of the organization down to its direct parent
+	parents []string
+}
+
+// accountSummary is the outcome of processing a member account, aggregated
+// in the summary of the organization.
+type accountSummary struct {
+	account         organizationAccount
+	savings         float64
+	recapEntries    int
+	budgetExhausted bool
+}
+
+func connectOrganizations(conf *Config) organizationsiface.OrganizationsAPI {
+	sess, err := newSession(conf.MainRegion, conf)
+	if err != nil {
+		panic(err)
+	}
+
+	return organizations.New(sess, conf.serviceConfig(organizations.EndpointsID, conf.MainRegion))
+}
+
+// organizationRoleARN returns the ARN of the role assumed in the member
+// account, in the partition of the main region.
+func organizationRoleARN(region, account, role string) string {
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", regionPartition(region), account, role)
+}
+
+// parseOrganizationUnits parses the comma separated list of organizational
+// unit, root or account IDs.
+func parseOrganizationUnits(units string) []string {
+	return strings.FieldsFunc(units, func(c rune) bool {
+		return c == ',' || c == ' '
+	})
+}
+
+// isAccountSelected determines whether the account is processed according to
+// the organizational units it belongs to. The excluded units take precedence
+// over the included ones, and all accounts are included unless configured
+// otherwise.
+func isAccountSelected(account organizationAccount, include, exclude []string) bool {
+	ids := append([]string{account.id}, account.parents...)
+
+	matches := func(units []string) bool {
+		for _, unit := range units {
+			for _, id := range ids {
+				if unit == id {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if matches(exclude) {
+		return false
+	}
+	return len(include) == 0 || matches(include)
+}
+
+// listOrganizationAccounts enumerates the active member accounts of the
+// organization, walking its organizational units from the roots down.
+func (a *AutoSpotting) listOrganizationAccounts() ([]organizationAccount, error) {
+	var roots []*organizations.Root
+	err := a.organizationsConn.ListRootsPages(&organizations.ListRootsInput{},
+		func(page *organizations.ListRootsOutput, lastPage bool) bool {
+			roots = append(roots, page.Roots...)
+			return true
+		})
+	if err != nil {
+		log.Println("Failed to list the roots of the organization:", err.Error())
+		return nil, err
+	}
+
+	var accounts []organizationAccount
+	for _, root := range roots {
+		if err := a.walkOrganizationalUnit(aws.StringValue(root.Id), nil, &accounts); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+func (a *AutoSpotting) walkOrganizationalUnit(parent string, path []string, accounts *[]organizationAccount) error {
+	path = append(append([]string(nil), path...), parent)
+
+	err := a.organizationsConn.ListAccountsForParentPages(
+		&organizations.ListAccountsForParentInput{ParentId: aws.String(parent)},
+		func(page *organizations.ListAccountsForParentOutput, lastPage bool) bool {
+			for _, account := range page.Accounts {
+				if aws.StringValue(account.Status) != organizations.AccountStatusActive {
+					debug.Println("Skipping account", aws.StringValue(account.Id), "in state", aws.StringValue(account.Status))
+					continue
+				}
+				*accounts = append(*accounts, organizationAccount{
+					id:      aws.StringValue(account.Id),
+					name:    aws.StringValue(account.Name),
+					parents: path,
+				})
+			}
+			return true
+		})
+	if err != nil {
+		log.Println("Failed to list the accounts of", parent, err.Error())
+		return err
+	}
+
+	var units []string
+	err = a.organizationsConn.ListOrganizationalUnitsForParentPages(
+		&organizations.ListOrganizationalUnitsForParentInput{ParentId: aws.String(parent)},
+		func(page *organizations.ListOrganizationalUnitsForParentOutput, lastPage bool) bool {
+			for _, ou := range page.OrganizationalUnits {
+				units = append(units, aws.StringValue(ou.Id))
+			}
+			return true
+		})
+	if err != nil {
+		log.Println("Failed to list the organizational units of", parent, err.Error())
+		return err
+	}
+
+	for _, unit := range units {
+		if err := a.walkOrganizationalUnit(unit, path, accounts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forAccount returns the instance processing the member account, which
+// assumes the configured role in it. It's kept for the next runs of the same
+// process, and has its own budgets, reports and region data, since the spot
+// prices of the availability zones differ between accounts. The execution
+// limits of the current run are taken from the organization instance.
+func (a *AutoSpotting) forAccount(account organizationAccount) *AutoSpotting {
+	if aa, found := a.accounts[account.id]; found {
+		aa.config.inheritRunSettings(a.config)
+		return aa
+	}
+
+	conf := *a.config
+	conf.accountID = account.id
+	conf.roleARN = organizationRoleARN(conf.MainRegion, account.id, conf.OrganizationRole)
+	conf.FinalRecap = make(map[string][]string)
+	conf.apiCalls = newAPICallBudget(conf.MaxAPICalls)
+	conf.swapLimiter = newSwapLimiter(conf.MaxConcurrentSwaps)
+	conf.RegionDataCache = ""
+	conf.regionData = newRegionDataCache(&conf)
+
+	aa := &AutoSpotting{
+		config:      &conf,
+		mainEC2Conn: connectEC2(conf.MainRegion, &conf),
+	}
+	if err := aa.loadProfiles(); err != nil {
+		log.Println("Failed to load the profiles of account", account.id, err.Error())
+	}
+
+	if a.accounts == nil {
+		a.accounts = make(map[string]*AutoSpotting)
+	}
+	a.accounts[account.id] = aa
+	return aa
+}
+
+// processOrganization runs against each selected member account of the
+// organization in turn, and aggregates their reports into a summary.
+func (a *AutoSpotting) processOrganization() {
+	accounts, err := a.listOrganizationAccounts()
+	if err != nil {
+		log.Println("Failed to enumerate the accounts of the organization:", err.Error())
+		return
+	}
+
+	include := parseOrganizationUnits(a.config.OrganizationUnitsInclude)
+	exclude := parseOrganizationUnits(a.config.OrganizationUnitsExclude)
+
+	var summaries []accountSummary
+	for _, account := range accounts {
+		if !isAccountSelected(account, include, exclude) {
+			debug.Println("Skipping account", account.id, "not selected by the organizational unit filters")
+			continue
+		}
+
+		aa := a.forAccount(account)
+
+		log.Printf("####### BEGIN ACCOUNT %s (%s) #######", account.id, account.name)
+		aa.ProcessCronEvent()
+		log.Printf("####### END ACCOUNT %s (%s) #######", account.id, account.name)
+
+		summary := accountSummary{
+			account:         account,
+			savings:         totalSavings,
+			budgetExhausted: aa.config.errorBudget.isExhausted(),
+		}
+		for _, entries := range aa.config.FinalRecap {
+			summary.recapEntries += len(entries)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	totalSavings = a.logOrganizationSummary(summaries)
+	a.emitHeartbeat()
+}
+
+// logOrganizationSummary logs the outcome of each processed account, and
+// returns the savings across the organization.
+func (a *AutoSpotting) logOrganizationSummary(summaries []accountSummary) float64 {
+	currency := a.config.reportingCurrency()
+
+	var savings float64
+	log.Println("####### BEGIN ORGANIZATION SUMMARY #######")
+	for _, s := range summaries {
+		status := ""
+		if s.budgetExhausted {
+			status = ", error budget exhausted"
+		}
+		log.Printf("%s (%s): hourly savings %s, %d final recap entries%s",
+			s.account.id, s.account.name, currency.format(s.savings, 4), s.recapEntries, status)
+		savings += s.savings
+	}
+	log.Printf("Total hourly savings across %d accounts: %s", len(summaries), currency.format(savings, 4))
+	return savings
+}
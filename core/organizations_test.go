@@ -0,0 +1,229 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+func Test_organizationRoleARN(t *testing.T) {
+	tests := []struct {
+		name   string
+		region string
+		want   string
+	}{
+		{
+			name:   "commercial partition",
+			region: "us-east-1",
+			want:   "arn:aws:iam::123456789012:role/AutoSpotting",
+		},
+		{
+			name:   "China partition",
+			region: "cn-north-1",
+			want:   "arn:aws-cn:iam::123456789012:role/AutoSpotting",
+		},
+		{
+			name:   "GovCloud partition",
+			region: "us-gov-west-1",
+			want:   "arn:aws-us-gov:iam::123456789012:role/AutoSpotting",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := organizationRoleARN(tt.region, "123456789012", "AutoSpotting"); got != tt.want {
+				t.Errorf("organizationRoleARN() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isAccountSelected(t *testing.T) {
+	account := organizationAccount{
+		id:      "111111111111",
+		parents: []string{"r-ab12", "ou-ab12-prod", "ou-ab12-payments"},
+	}
+
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		want    bool
+	}{
+		{
+			name: "no filters",
+			want: true,
+		},
+		{
+			name:    "included by its direct parent",
+			include: "ou-ab12-payments",
+			want:    true,
+		},
+		{
+			name:    "included by a nesting unit",
+			include: "ou-ab12-dev, ou-ab12-prod",
+			want:    true,
+		},
+		{
+			name:    "not included",
+			include: "ou-ab12-dev",
+		},
+		{
+			name:    "excluded by a nesting unit",
+			exclude: "ou-ab12-prod",
+		},
+		{
+			name:    "excluded by its ID",
+			include: "r-ab12",
+			exclude: "111111111111",
+		},
+		{
+			name:    "excluded unit taking precedence",
+			include: "ou-ab12-payments",
+			exclude: "ou-ab12-prod",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := isAccountSelected(account, parseOrganizationUnits(tt.include), parseOrganizationUnits(tt.exclude))
+			if got != tt.want {
+				t.Errorf("isAccountSelected() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoSpotting_listOrganizationAccounts(t *testing.T) {
+	account := func(id, status string) *organizations.Account {
+		return &organizations.Account{Id: aws.String(id), Name: aws.String("account-" + id), Status: aws.String(status)}
+	}
+
+	tests := []struct {
+		name    string
+		orgs    mockOrganizations
+		want    []organizationAccount
+		wantErr bool
+	}{
+		{
+			name: "nested organizational units",
+			orgs: mockOrganizations{
+				lro: &organizations.ListRootsOutput{Roots: []*organizations.Root{{Id: aws.String("r-ab12")}}},
+				lafpo: map[string]*organizations.ListAccountsForParentOutput{
+					"r-ab12": {Accounts: []*organizations.Account{
+						account("100000000000", organizations.AccountStatusActive),
+					}},
+					"ou-ab12-payments": {Accounts: []*organizations.Account{
+						account("200000000000", organizations.AccountStatusActive),
+						account("300000000000", organizations.AccountStatusSuspended),
+					}},
+				},
+				loufpo: map[string]*organizations.ListOrganizationalUnitsForParentOutput{
+					"r-ab12": {OrganizationalUnits: []*organizations.OrganizationalUnit{{Id: aws.String("ou-ab12-prod")}}},
+					"ou-ab12-prod": {OrganizationalUnits: []*organizations.OrganizationalUnit{
+						{Id: aws.String("ou-ab12-payments")},
+					}},
+				},
+			},
+			want: []organizationAccount{
+				{id: "100000000000", name: "account-100000000000", parents: []string{"r-ab12"}},
+				{id: "200000000000", name: "account-200000000000",
+					parents: []string{"r-ab12", "ou-ab12-prod", "ou-ab12-payments"}},
+			},
+		},
+		{
+			name: "failing to list the roots",
+			orgs: mockOrganizations{
+				lrerr: errors.New("AWSOrganizationsNotInUseException"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "failing to list the accounts",
+			orgs: mockOrganizations{
+				lro:     &organizations.ListRootsOutput{Roots: []*organizations.Root{{Id: aws.String("r-ab12")}}},
+				lafperr: errors.New("AccessDeniedException"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &AutoSpotting{organizationsConn: tt.orgs}
+
+			got, err := a.listOrganizationAccounts()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listOrganizationAccounts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listOrganizationAccounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoSpotting_logOrganizationSummary(t *testing.T) {
+	a := &AutoSpotting{config: &Config{}}
+
+	got := a.logOrganizationSummary([]accountSummary{
+		{account: organizationAccount{id: "100000000000"}, savings: 1.5, recapEntries: 2},
+		{account: organizationAccount{id: "200000000000"}, savings: 0.25, budgetExhausted: true},
+	})
+
+	if got != 1.75 {
+		t.Errorf("logOrganizationSummary() = %v, want 1.75", got)
+	}
+}
+
+func TestAutoSpotting_forAccount(t *testing.T) {
+	a := &AutoSpotting{config: &Config{MainRegion: "us-east-1", OrganizationRole: "AutoSpotting"}}
+	account := organizationAccount{id: "123456789012"}
+
+	first := a.forAccount(account)
+	if first.config.roleARN != "arn:aws:iam::123456789012:role/AutoSpotting" {
+		t.Errorf("forAccount() role = %s", first.config.roleARN)
+	}
+
+	// the next run of a warm Lambda function has a new deadline
+	deadline := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	a.SetExecutionBudget(deadline, 1024)
+	a.config.ReadOnly = true
+
+	got := a.forAccount(account)
+	if got != first {
+		t.Fatalf("forAccount() didn't reuse the instance of the account")
+	}
+	if !got.config.executionDeadline.Equal(deadline) || got.config.memoryLimitMB != 1024 || !got.config.ReadOnly {
+		t.Errorf("forAccount() kept the limits of the previous run: %v, %d, %v",
+			got.config.executionDeadline, got.config.memoryLimitMB, got.config.ReadOnly)
+	}
+}
+
+func TestConfig_recapTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Config
+		want string
+	}{
+		{name: "global", want: "FINAL RECAP"},
+		{name: "profile", conf: Config{profileName: "payments"}, want: "FINAL RECAP OF PROFILE payments"},
+		{name: "account", conf: Config{accountID: "123456789012"}, want: "FINAL RECAP OF ACCOUNT 123456789012"},
+		{
+			name: "profile of an account",
+			conf: Config{accountID: "123456789012", profileName: "payments"},
+			want: "FINAL RECAP OF ACCOUNT 123456789012 OF PROFILE payments",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.conf.recapTitle(); got != tt.want {
+				t.Errorf("recapTitle() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
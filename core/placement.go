@@ -0,0 +1,69 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// spotPlacement returns the placement of the spot instance, copied from the
+// replaced instance and falling back to the placement group of the
+// AutoScaling group.
+func (i *instance) spotPlacement() *ec2.Placement {
+	if i.Placement == nil {
+		if i.asg == nil || i.asg.Group == nil || i.asg.PlacementGroup == nil {
+			return nil
+		}
+		return &ec2.Placement{GroupName: i.asg.PlacementGroup}
+	}
+
+	p := &ec2.Placement{
+		Affinity:         i.Placement.Affinity,
+		AvailabilityZone: i.Placement.AvailabilityZone,
+		GroupName:        i.Placement.GroupName,
+		PartitionNumber:  i.Placement.PartitionNumber,
+		SpreadDomain:     i.Placement.SpreadDomain,
+		Tenancy:          i.Placement.Tenancy,
+	}
+
+	if aws.StringValue(p.GroupName) == "" && i.asg != nil && i.asg.Group != nil {
+		p.GroupName = i.asg.PlacementGroup
+	}
+	return p
+}
+
+// processLaunchTemplatePlacement applies the placement preferences of the
+// launch template to the spot instance, or explicitly overrides the ones which
+// can't be used by spot instances, since the launch template is also passed
+// to the RunInstances call.
+func (i *instance) processLaunchTemplatePlacement(retval *ec2.RunInstancesInput, ltData *ec2.ResponseLaunchTemplateData) {
+	if ltp := ltData.Placement; ltp != nil {
+		if aws.StringValue(ltp.GroupName) != "" {
+			if retval.Placement == nil {
+				retval.Placement = &ec2.Placement{}
+			}
+			if aws.StringValue(retval.Placement.GroupName) == "" {
+				retval.Placement.GroupName = ltp.GroupName
+			}
+		}
+
+		if ltp.HostResourceGroupArn != nil || ltp.HostId != nil {
+			log.Println(i.region.name, "Ignoring the Dedicated Host placement of the launch template",
+				aws.StringValue(i.asg.LaunchTemplate.LaunchTemplateId), "which isn't supported for spot instances")
+		}
+	}
+
+	if crs := ltData.CapacityReservationSpecification; crs != nil &&
+		(crs.CapacityReservationTarget != nil ||
+			aws.StringValue(crs.CapacityReservationPreference) == ec2.CapacityReservationPreferenceOpen) {
+		log.Println(i.region.name, "Ignoring the capacity reservation preferences of the launch template",
+			aws.StringValue(i.asg.LaunchTemplate.LaunchTemplateId), "since spot instances can't use capacity reservations")
+		retval.CapacityReservationSpecification = &ec2.CapacityReservationSpecification{
+			CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceNone),
+		}
+	}
+}
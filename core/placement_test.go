@@ -0,0 +1,155 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instance_spotPlacement(t *testing.T) {
+	tests := []struct {
+		name           string
+		placement      *ec2.Placement
+		placementGroup *string
+		expected       *ec2.Placement
+	}{
+		{name: "no placement"},
+		{
+			name:           "group placement group",
+			placementGroup: aws.String("cluster"),
+			expected:       &ec2.Placement{GroupName: aws.String("cluster")},
+		},
+		{
+			name: "instance placement",
+			placement: &ec2.Placement{
+				AvailabilityZone: aws.String("us-east-1a"),
+				GroupName:        aws.String("partitions"),
+				PartitionNumber:  aws.Int64(2),
+				Tenancy:          aws.String(ec2.TenancyDedicated),
+			},
+			placementGroup: aws.String("cluster"),
+			expected: &ec2.Placement{
+				AvailabilityZone: aws.String("us-east-1a"),
+				GroupName:        aws.String("partitions"),
+				PartitionNumber:  aws.Int64(2),
+				Tenancy:          aws.String(ec2.TenancyDedicated),
+			},
+		},
+		{
+			name:           "instance placement without placement group",
+			placement:      &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			placementGroup: aws.String("cluster"),
+			expected: &ec2.Placement{
+				AvailabilityZone: aws.String("us-east-1a"),
+				GroupName:        aws.String("cluster"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-foo"), Placement: tt.placement},
+				region:   &region{name: "us-east-1"},
+				asg: &autoScalingGroup{
+					Group: &autoscaling.Group{PlacementGroup: tt.placementGroup},
+				},
+			}
+			if got := i.spotPlacement(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("spotPlacement() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_processLaunchTemplatePlacement(t *testing.T) {
+	tests := []struct {
+		name              string
+		placement         *ec2.Placement
+		ltData            *ec2.ResponseLaunchTemplateData
+		expectedPlacement *ec2.Placement
+		expectedCRS       *ec2.CapacityReservationSpecification
+	}{
+		{
+			name:   "no preferences",
+			ltData: &ec2.ResponseLaunchTemplateData{},
+		},
+		{
+			name: "placement group",
+			ltData: &ec2.ResponseLaunchTemplateData{
+				Placement: &ec2.LaunchTemplatePlacement{GroupName: aws.String("cluster")},
+			},
+			expectedPlacement: &ec2.Placement{GroupName: aws.String("cluster")},
+		},
+		{
+			name:      "placement group of the instance",
+			placement: &ec2.Placement{GroupName: aws.String("spread")},
+			ltData: &ec2.ResponseLaunchTemplateData{
+				Placement: &ec2.LaunchTemplatePlacement{GroupName: aws.String("cluster")},
+			},
+			expectedPlacement: &ec2.Placement{GroupName: aws.String("spread")},
+		},
+		{
+			name: "targeted capacity reservation",
+			ltData: &ec2.ResponseLaunchTemplateData{
+				CapacityReservationSpecification: &ec2.LaunchTemplateCapacityReservationSpecificationResponse{
+					CapacityReservationTarget: &ec2.CapacityReservationTargetResponse{
+						CapacityReservationId: aws.String("cr-123"),
+					},
+				},
+			},
+			expectedCRS: &ec2.CapacityReservationSpecification{
+				CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceNone),
+			},
+		},
+		{
+			name: "open capacity reservations",
+			ltData: &ec2.ResponseLaunchTemplateData{
+				CapacityReservationSpecification: &ec2.LaunchTemplateCapacityReservationSpecificationResponse{
+					CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceOpen),
+				},
+			},
+			expectedCRS: &ec2.CapacityReservationSpecification{
+				CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceNone),
+			},
+		},
+		{
+			name: "no capacity reservations",
+			ltData: &ec2.ResponseLaunchTemplateData{
+				CapacityReservationSpecification: &ec2.LaunchTemplateCapacityReservationSpecificationResponse{
+					CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceNone),
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				region: &region{name: "us-east-1"},
+				asg: &autoScalingGroup{
+					Group: &autoscaling.Group{
+						LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+							LaunchTemplateId: aws.String("lt-123"),
+						},
+					},
+				},
+			}
+			retval := &ec2.RunInstancesInput{Placement: tt.placement}
+			i.processLaunchTemplatePlacement(retval, tt.ltData)
+
+			if !reflect.DeepEqual(retval.Placement, tt.expectedPlacement) {
+				t.Errorf("processLaunchTemplatePlacement() placement = %v, expected %v",
+					retval.Placement, tt.expectedPlacement)
+			}
+			if !reflect.DeepEqual(retval.CapacityReservationSpecification, tt.expectedCRS) {
+				t.Errorf("processLaunchTemplatePlacement() capacity reservation = %v, expected %v",
+					retval.CapacityReservationSpecification, tt.expectedCRS)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+const (
+	// windowsPlatform is the platform of the Windows instances.
+	windowsPlatform = "windows"
+
+	// rhelPlatform is the platform of the Red Hat Enterprise Linux instances.
+	rhelPlatform = "rhel"
+
+	// susePlatform is the platform of the SUSE Linux Enterprise Server
+	// instances.
+	susePlatform = "suse"
+
+	// linuxPlatformDetails are the platform details of the Linux AMIs without
+	// license surcharges.
+	linuxPlatformDetails = "Linux/UNIX"
+)
+
+// imagePlatforms maps the platform details of the AMIs to the platforms
+// priced differently from Linux. Variants such as RHEL with SQL Server aren't
+// covered by the static pricing data, so they're priced as Linux.
+var imagePlatforms = map[string]string{
+	"Red Hat Enterprise Linux": rhelPlatform,
+	"SUSE Linux":               susePlatform,
+}
+
+// platformPricing describes where the prices of the instances running a
+// platform with license surcharges are found.
+type platformPricing struct {
+	// the product description of the platform's spot prices
+	spotProduct string
+
+	// the on-demand price of the platform from the static pricing data
+	onDemand func(ec2instancesinfo.RegionPrices) float64
+}
+
+// platforms are the platforms priced differently from Linux, keyed by the
+// platform name.
+var platforms = map[string]platformPricing{
+	windowsPlatform: {
+		spotProduct: "Windows (Amazon VPC)",
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.MSWin.OnDemand },
+	},
+	rhelPlatform: {
+		spotProduct: "Red Hat Enterprise Linux (Amazon VPC)",
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.RHEL.OnDemand },
+	},
+	susePlatform: {
+		spotProduct: "SUSE Linux (Amazon VPC)",
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.SLES.OnDemand },
+	},
+}
+
+// platform returns the platform of the instance if it's priced differently
+// from Linux, or an empty string otherwise. Windows is reported by the
+// instance itself, while the other platforms are determined from the platform
+// details of its AMI.
+func (i *instance) platform() string {
+	if strings.EqualFold(aws.StringValue(i.Platform), windowsPlatform) {
+		return windowsPlatform
+	}
+	if i.region == nil || i.ImageId == nil {
+		return ""
+	}
+	return imagePlatforms[i.region.imagePlatformDetails(*i.ImageId)]
+}
+
+// spotProduct returns the spot product description matching the platform of
+// the instance. The instances whose platform can't be determined use the
+// globally configured spot product description.
+func (i *instance) spotProduct() string {
+	if p, found := platforms[i.platform()]; found {
+		return p.spotProduct
+	}
+
+	// the instances known to run Linux are priced as Linux even when another
+	// spot product is configured globally, such as for Windows groups
+	if i.region != nil && i.ImageId != nil &&
+		i.region.imagePlatformDetails(*i.ImageId) == linuxPlatformDetails {
+		return DefaultSpotProductDescription
+	}
+	return i.region.conf.SpotProductDescription
+}
+
+// usesDefaultPricing determines if the instance is priced using the spot
+// product description configured globally, whose prices are fetched along
+// with the instance type information.
+func (i *instance) usesDefaultPricing() bool {
+	if i.region == nil || i.region.conf == nil {
+		return true
+	}
+	return i.spotProduct() == i.region.conf.SpotProductDescription
+}
+
+// onDemandPriceOf returns the on-demand price of the instance type for the
+// platform of the instance, in its Local Zone or Wavelength Zone if known.
+func (i *instance) onDemandPriceOf(t instanceTypeInformation) float64 {
+	if price, found := i.zoneOnDemandPrice(t); found {
+		return price
+	}
+	if price, found := t.pricing.platformOnDemand[i.platform()]; found {
+		return price
+	}
+	return t.pricing.onDemand
+}
+
+// spotPriceOf returns the current spot price of the instance type in the
+// instance's availability zone for the platform of the instance.
+func (i *instance) spotPriceOf(t instanceTypeInformation) float64 {
+	return i.spotPricesOf(t)[i.availabilityZone()]
+}
+
+// spotPricesOf returns the current spot prices of the instance type in all
+// the availability zones of the region for the platform of the instance.
+func (i *instance) spotPricesOf(t instanceTypeInformation) spotPriceMap {
+	if i.usesDefaultPricing() {
+		return t.pricing.spot
+	}
+	return i.region.spotPricesByAZ(i.spotProduct(), t.instanceType)
+}
+
+// premiumOf returns the configured spot product premium, which doesn't apply
+// to the platforms whose prices already include their license surcharges.
+func (i *instance) premiumOf(t instanceTypeInformation) float64 {
+	if _, found := platforms[i.platform()]; found {
+		return 0
+	}
+	return t.pricing.premium
+}
+
+// imagePlatformDetails returns the platform details of the AMI, describing it
+// once per run.
+func (r *region) imagePlatformDetails(imageID string) string {
+	r.imagePlatformDetailsLock.Lock()
+	defer r.imagePlatformDetailsLock.Unlock()
+
+	if r.imagesPlatformDetails == nil {
+		r.imagesPlatformDetails = make(map[string]string)
+	}
+
+	details, found := r.imagesPlatformDetails[imageID]
+	if !found {
+		resp, err := r.services.ec2.DescribeImages(&ec2.DescribeImagesInput{
+			ImageIds: []*string{aws.String(imageID)},
+		})
+		if err != nil {
+			log.Println(r.name, "Couldn't describe the image", imageID, err.Error())
+		} else if resp != nil && len(resp.Images) > 0 {
+			details = aws.StringValue(resp.Images[0].PlatformDetails)
+		}
+		r.imagesPlatformDetails[imageID] = details
+	}
+	return details
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instance_platform(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform *string
+		damio    *ec2.DescribeImagesOutput
+		damierr  error
+		expected string
+	}{
+		{name: "linux", damio: &ec2.DescribeImagesOutput{}, expected: ""},
+		{name: "windows", platform: aws.String("windows"), expected: windowsPlatform},
+		{name: "windows mixed case", platform: aws.String("Windows"), expected: windowsPlatform},
+		{
+			name: "rhel",
+			damio: &ec2.DescribeImagesOutput{Images: []*ec2.Image{
+				{PlatformDetails: aws.String("Red Hat Enterprise Linux")},
+			}},
+			expected: rhelPlatform,
+		},
+		{
+			name: "suse",
+			damio: &ec2.DescribeImagesOutput{Images: []*ec2.Image{
+				{PlatformDetails: aws.String("SUSE Linux")},
+			}},
+			expected: susePlatform,
+		},
+		{
+			name: "rhel with sql server",
+			damio: &ec2.DescribeImagesOutput{Images: []*ec2.Image{
+				{PlatformDetails: aws.String("Red Hat Enterprise Linux with SQL Server Standard")},
+			}},
+			expected: "",
+		},
+		{name: "image description error", damierr: errors.New("error"), expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{Platform: tt.platform, ImageId: aws.String("ami-123")},
+				region: &region{
+					services: connections{ec2: mockEC2{damio: tt.damio, damierr: tt.damierr}},
+				},
+			}
+			if got := i.platform(); got != tt.expected {
+				t.Errorf("platform() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_onDemandPriceOf(t *testing.T) {
+	info := instanceTypeInformation{
+		instanceType: "m5.large",
+		pricing: prices{
+			onDemand:         0.096,
+			platformOnDemand: map[string]float64{windowsPlatform: 0.188},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		platform *string
+		expected float64
+	}{
+		{name: "linux", expected: 0.096},
+		{name: "windows", platform: aws.String("windows"), expected: 0.188},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{Instance: &ec2.Instance{Platform: tt.platform}}
+			if got := i.onDemandPriceOf(info); got != tt.expected {
+				t.Errorf("onDemandPriceOf() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_spotPriceOf(t *testing.T) {
+	now := time.Now()
+	info := instanceTypeInformation{
+		instanceType: "m5.large",
+		pricing: prices{
+			spot:    spotPriceMap{"us-east-1a": 0.035},
+			premium: 0.01,
+		},
+	}
+
+	linuxPrices := []*ec2.DescribeSpotPriceHistoryOutput{{
+		SpotPriceHistory: []*ec2.SpotPrice{{
+			InstanceType:     aws.String("m5.large"),
+			AvailabilityZone: aws.String("us-east-1a"),
+			SpotPrice:        aws.String("0.036"),
+			Timestamp:        aws.Time(now.Add(-1 * time.Hour)),
+		}},
+	}}
+
+	tests := []struct {
+		name            string
+		platform        *string
+		product         string
+		damio           *ec2.DescribeImagesOutput
+		dsphpo          []*ec2.DescribeSpotPriceHistoryOutput
+		expectedSpot    float64
+		expectedPremium float64
+	}{
+		{
+			name:            "linux",
+			expectedSpot:    0.035,
+			expectedPremium: 0.01,
+		},
+		{
+			name:            "linux AMI",
+			damio:           &ec2.DescribeImagesOutput{Images: []*ec2.Image{{PlatformDetails: aws.String("Linux/UNIX")}}},
+			expectedSpot:    0.035,
+			expectedPremium: 0.01,
+		},
+		{
+			name:            "linux AMI with another spot product configured",
+			product:         "Windows (Amazon VPC)",
+			damio:           &ec2.DescribeImagesOutput{Images: []*ec2.Image{{PlatformDetails: aws.String("Linux/UNIX")}}},
+			dsphpo:          linuxPrices,
+			expectedSpot:    0.036,
+			expectedPremium: 0.01,
+		},
+		{
+			name:            "unknown AMI with another spot product configured",
+			product:         "Windows (Amazon VPC)",
+			damio:           &ec2.DescribeImagesOutput{},
+			expectedSpot:    0.035,
+			expectedPremium: 0.01,
+		},
+		{
+			name:     "windows",
+			platform: aws.String("windows"),
+			dsphpo: []*ec2.DescribeSpotPriceHistoryOutput{{
+				SpotPriceHistory: []*ec2.SpotPrice{
+					{
+						InstanceType:     aws.String("m5.large"),
+						AvailabilityZone: aws.String("us-east-1a"),
+						SpotPrice:        aws.String("0.120"),
+						Timestamp:        aws.Time(now.Add(-2 * time.Hour)),
+					},
+					{
+						InstanceType:     aws.String("m5.large"),
+						AvailabilityZone: aws.String("us-east-1a"),
+						SpotPrice:        aws.String("0.125"),
+						Timestamp:        aws.Time(now.Add(-1 * time.Hour)),
+					},
+				},
+			}},
+			expectedSpot:    0.125,
+			expectedPremium: 0,
+		},
+		{
+			name:            "windows without spot prices",
+			platform:        aws.String("windows"),
+			expectedSpot:    0,
+			expectedPremium: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := tt.product
+			if product == "" {
+				product = "Linux/UNIX (Amazon VPC)"
+			}
+			var imageID *string
+			if tt.damio != nil {
+				imageID = aws.String("ami-123")
+			}
+			i := &instance{
+				Instance: &ec2.Instance{
+					Platform:  tt.platform,
+					ImageId:   imageID,
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				region: &region{
+					name: "us-east-1",
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{
+							SpotProductDescription: product,
+						},
+					},
+					services: connections{ec2: mockEC2{dsphpo: tt.dsphpo, damio: tt.damio}},
+				},
+			}
+			if got := i.spotPriceOf(info); got != tt.expectedSpot {
+				t.Errorf("spotPriceOf() = %v, expected %v", got, tt.expectedSpot)
+			}
+			if got := i.premiumOf(info); got != tt.expectedPremium {
+				t.Errorf("premiumOf() = %v, expected %v", got, tt.expectedPremium)
+			}
+		})
+	}
+}
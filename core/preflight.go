@@ -0,0 +1,311 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// requiredActions are the IAM actions needed by every run.
+var requiredActions = []string{
+	"autoscaling:AttachInstances",
+	"autoscaling:CompleteLifecycleAction",
+	"autoscaling:CreateOrUpdateTags",
+	"autoscaling:DeleteTags",
+	"autoscaling:DescribeAutoScalingGroups",
+	"autoscaling:DescribeAutoScalingInstances",
+	"autoscaling:DescribeLaunchConfigurations",
+	"autoscaling:DescribeLifecycleHooks",
+	"autoscaling:DescribePolicies",
+	"autoscaling:DescribeScalingActivities",
+	"autoscaling:DescribeTags",
+	"autoscaling:DetachInstances",
+	"autoscaling:EnterStandby",
+	"autoscaling:ExitStandby",
+	"autoscaling:ResumeProcesses",
+	"autoscaling:SuspendProcesses",
+	"autoscaling:TerminateInstanceInAutoScalingGroup",
+	"autoscaling:UpdateAutoScalingGroup",
+	"cloudformation:DescribeStacks",
+	"ec2:CancelSpotInstanceRequests",
+	"ec2:CreateTags",
+	"ec2:CreateVolume",
+	"ec2:DeleteTags",
+	"ec2:DescribeImages",
+	"ec2:DescribeInstanceAttribute",
+	"ec2:DescribeInstanceStatus",
+	"ec2:DescribeInstanceTypeOfferings",
+	"ec2:DescribeInstanceTypes",
+	"ec2:DescribeInstances",
+	"ec2:DescribeLaunchTemplateVersions",
+	"ec2:DescribeRegions",
+	"ec2:DescribeSpotPriceHistory",
+	"ec2:ModifyInstanceAttribute",
+	"ec2:RunInstances",
+	"ec2:TerminateInstances",
+	"eks:DescribeNodegroup",
+	"elasticloadbalancing:DeregisterInstancesFromLoadBalancer",
+	"elasticloadbalancing:DeregisterTargets",
+	"elasticloadbalancing:DescribeInstanceHealth",
+	"elasticloadbalancing:DescribeLoadBalancerAttributes",
+	"elasticloadbalancing:DescribeTargetGroupAttributes",
+	"elasticloadbalancing:DescribeTargetHealth",
+	"iam:PassRole",
+	"route53:ChangeResourceRecordSets",
+	"route53:CreateHealthCheck",
+	"route53:DeleteHealthCheck",
+	"route53:GetHealthCheck",
+	"route53:GetHostedZone",
+	"route53:UpdateHealthCheck",
+	"servicediscovery:DeregisterInstance",
+	"servicediscovery:GetInstance",
+	"servicediscovery:ListNamespaces",
+	"servicediscovery:ListServices",
+	"servicediscovery:RegisterInstance",
+	"ssm:DescribeInstanceInformation",
+	"ssm:GetParameter",
+}
+
+// readOnlyActions are the IAM actions needed in read-only mode.
+var readOnlyActions = []string{
+	"autoscaling:DescribeAutoScalingGroups",
+	"autoscaling:DescribeLaunchConfigurations",
+	"autoscaling:DescribePolicies",
+	"ec2:DescribeImages",
+	"ec2:DescribeInstanceAttribute",
+	"ec2:DescribeInstanceTypeOfferings",
+	"ec2:DescribeInstanceTypes",
+	"ec2:DescribeInstances",
+	"ec2:DescribeLaunchTemplateVersions",
+	"ec2:DescribeRegions",
+	"ec2:DescribeSpotPriceHistory",
+	"ssm:GetParameter",
+}
+
+// dryRunChecks check the EC2 permissions which can be verified using
+// DryRun API calls, which don't depend on the IAM simulation permissions.
+var dryRunChecks = map[string]func(ec2iface.EC2API) error{
+	"ec2:DescribeInstances": func(svc ec2iface.EC2API) error {
+		_, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{DryRun: aws.Bool(true)})
+		return err
+	},
+	"ec2:DescribeRegions": func(svc ec2iface.EC2API) error {
+		_, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{DryRun: aws.Bool(true)})
+		return err
+	},
+	"ec2:DescribeSpotPriceHistory": func(svc ec2iface.EC2API) error {
+		_, err := svc.DescribeSpotPriceHistory(&ec2.DescribeSpotPriceHistoryInput{DryRun: aws.Bool(true)})
+		return err
+	},
+	"ec2:DescribeInstanceTypes": func(svc ec2iface.EC2API) error {
+		_, err := svc.DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{DryRun: aws.Bool(true)})
+		return err
+	},
+}
+
+// requiredActionsFor returns the IAM actions needed with the given
+// configuration, sorted alphabetically.
+func requiredActionsFor(conf *Config) []string {
+	if conf.ReadOnly {
+		actions := append(priceSourceActions(conf), readOnlyActions...)
+		sort.Strings(actions)
+		return actions
+	}
+
+	actions := append(priceSourceActions(conf), requiredActions...)
+
+	if conf.SavingsReconciliationInterval > 0 {
+		actions = append(actions, "ce:GetCostAndUsage")
+	}
+	if conf.HeartbeatMetric || conf.APICallMetrics {
+		actions = append(actions, "cloudwatch:PutMetricData")
+	}
+	if conf.HeartbeatMetric && conf.HeartbeatAlarmStaleness > 0 {
+		actions = append(actions, "cloudwatch:PutMetricAlarm")
+	}
+	if conf.ScheduledActionWindow > 0 {
+		actions = append(actions, "autoscaling:DescribeScheduledActions")
+	}
+	if conf.SpotCoverageTopic != "" {
+		actions = append(actions, "sns:Publish")
+	}
+	if conf.TerminationEventBus != "" {
+		actions = append(actions, "events:PutEvents")
+	}
+	if conf.ScaleInProtection != NoScaleInProtection ||
+		conf.KubernetesAutoscalerPolicy == CoordinateKubernetesAutoscalerPolicy {
+		actions = append(actions, "autoscaling:SetInstanceProtection")
+	}
+	if conf.OrganizationRole != "" {
+		actions = append(actions, "organizations:ListAccountsForParent",
+			"organizations:ListOrganizationalUnitsForParent", "organizations:ListRoots", "sts:AssumeRole")
+	}
+	if conf.SnapshotBeforeTerminate {
+		actions = append(actions, "ec2:CreateSnapshots", "ec2:DeleteSnapshot", "ec2:DescribeSnapshots")
+	}
+	if conf.SQSQueueURL != "" {
+		actions = append(actions, "sqs:DeleteMessage", "sqs:ReceiveMessage", "sqs:SendMessage")
+	} else if conf.NodeTerminationHandlerQueue != "" {
+		actions = append(actions, "sqs:SendMessage")
+	}
+
+	sort.Strings(actions)
+	return actions
+}
+
+// priceSourceActions returns the IAM actions needed by the configured price
+// sources, in both the regular and the read-only mode.
+func priceSourceActions(conf *Config) []string {
+	var actions []string
+	if conf.PricingAPI {
+		actions = append(actions, "pricing:GetProducts")
+	}
+	if conf.PriceOverrideFile != "" {
+		actions = append(actions, "s3:GetObject")
+	}
+	if strings.HasPrefix(conf.RegionDataCache, "s3://") {
+		actions = append(actions, "s3:GetObject", "s3:PutObject")
+	}
+	return actions
+}
+
+// runPermissionPreflight checks all the permissions needed by the run and
+// reports the missing ones up front, instead of failing in the middle of
+// replacing instances.
+func (a *AutoSpotting) runPermissionPreflight() {
+	sess, err := newSession(a.config.MainRegion, a.config)
+	if err != nil {
+		log.Println("Failed to check the IAM permissions:", err.Error())
+		return
+	}
+
+	missing, err := checkPermissions(a.mainEC2Conn,
+		iam.New(sess, a.config.serviceConfig(iam.EndpointsID, a.config.MainRegion)),
+		sts.New(sess, a.config.serviceConfig(sts.EndpointsID, a.config.MainRegion)),
+		requiredActionsFor(a.config))
+
+	if err != nil {
+		log.Println("Couldn't simulate the IAM permissions, only some of them were checked:", err.Error())
+	}
+
+	log.Print(permissionReport(missing))
+}
+
+// checkPermissions returns the actions which aren't allowed, checked using
+// DryRun EC2 API calls and by simulating the IAM policies of the current
+// principal. An error is returned when the simulation isn't possible.
+func checkPermissions(ec2Svc ec2iface.EC2API, iamSvc iamiface.IAMAPI, stsSvc stsiface.STSAPI,
+	actions []string) ([]string, error) {
+
+	denied := make(map[string]bool)
+
+	for _, action := range actions {
+		check, found := dryRunChecks[action]
+		if !found {
+			continue
+		}
+
+		var aerr awserr.Error
+		if err := check(ec2Svc); errors.As(err, &aerr) && aerr.Code() == "UnauthorizedOperation" {
+			denied[action] = true
+		}
+	}
+
+	simulated, err := simulatePermissions(iamSvc, stsSvc, actions)
+	for _, action := range simulated {
+		denied[action] = true
+	}
+
+	var missing []string
+	for action := range denied {
+		missing = append(missing, action)
+	}
+	sort.Strings(missing)
+	return missing, err
+}
+
+// simulatePermissions returns the actions denied by the IAM policies of the
+// current principal.
+func simulatePermissions(iamSvc iamiface.IAMAPI, stsSvc stsiface.STSAPI, actions []string) ([]string, error) {
+	identity, err := stsSvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	principal, err := principalARN(iamSvc, aws.StringValue(identity.Arn))
+	if err != nil {
+		return nil, err
+	}
+
+	var denied []string
+	err = iamSvc.SimulatePrincipalPolicyPages(
+		&iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(principal),
+			ActionNames:     aws.StringSlice(actions),
+		},
+		func(page *iam.SimulatePolicyResponse, lastPage bool) bool {
+			for _, result := range page.EvaluationResults {
+				if aws.StringValue(result.EvalDecision) != iam.PolicyEvaluationDecisionTypeAllowed {
+					denied = append(denied, aws.StringValue(result.EvalActionName))
+				}
+			}
+			return true
+		})
+	return denied, err
+}
+
+// principalARN converts the ARN of the assumed role session, as returned by
+// GetCallerIdentity when running from Lambda or ECS, into the ARN of the IAM
+// role, including its path, as needed by the IAM policy simulation.
+func principalARN(iamSvc iamiface.IAMAPI, identity string) (string, error) {
+	parsed, err := arn.Parse(identity)
+	if err != nil {
+		return "", err
+	}
+
+	if parsed.Service != "sts" || !strings.HasPrefix(parsed.Resource, "assumed-role/") {
+		return identity, nil
+	}
+
+	parts := strings.Split(parsed.Resource, "/")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("unexpected assumed role ARN %s", identity)
+	}
+
+	role, err := iamSvc.GetRole(&iam.GetRoleInput{RoleName: aws.String(parts[1])})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(role.Role.Arn), nil
+}
+
+// permissionReport lists the missing permissions in a single block.
+func permissionReport(missing []string) string {
+	if len(missing) == 0 {
+		return "All the required IAM permissions are allowed\n"
+	}
+
+	var b strings.Builder
+	b.WriteString("####### BEGIN MISSING IAM PERMISSIONS #######\n")
+	b.WriteString("The following actions aren't allowed, the runs will fail when needing them:\n")
+	for _, action := range missing {
+		b.WriteString("\t" + action + "\n")
+	}
+	b.WriteString("####### END MISSING IAM PERMISSIONS #######\n")
+	return b.String()
+}
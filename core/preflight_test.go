@@ -0,0 +1,201 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func Test_requiredActionsFor(t *testing.T) {
+	tests := []struct {
+		name        string
+		conf        *Config
+		included    []string
+		notIncluded []string
+	}{
+		{
+			name:        "default configuration",
+			conf:        &Config{},
+			included:    []string{"ec2:RunInstances", "autoscaling:AttachInstances"},
+			notIncluded: []string{"ce:GetCostAndUsage", "cloudwatch:PutMetricData", "sqs:ReceiveMessage", "pricing:GetProducts"},
+		},
+		{
+			name:        "read-only mode",
+			conf:        &Config{ReadOnly: true, HeartbeatMetric: true},
+			included:    []string{"ec2:DescribeInstances", "autoscaling:DescribeAutoScalingGroups"},
+			notIncluded: []string{"ec2:RunInstances", "autoscaling:AttachInstances", "cloudwatch:PutMetricData"},
+		},
+		{
+			name:        "read-only mode with price sources",
+			conf:        &Config{ReadOnly: true, PricingAPI: true, PriceOverrideFile: "s3://bucket/prices.csv"},
+			included:    []string{"ec2:DescribeInstances", "pricing:GetProducts", "s3:GetObject"},
+			notIncluded: []string{"ec2:RunInstances"},
+		},
+		{
+			name: "optional features",
+			conf: &Config{
+				SavingsReconciliationInterval: time.Hour,
+				HeartbeatMetric:               true,
+				HeartbeatAlarmStaleness:       time.Hour,
+				SQSQueueURL:                   "https://sqs.us-east-1.amazonaws.com/123456789012/AutoSpotting.fifo",
+			},
+			included: []string{"ce:GetCostAndUsage", "cloudwatch:PutMetricData",
+				"cloudwatch:PutMetricAlarm", "sqs:ReceiveMessage"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(requiredActionsFor(tt.conf), " ") + " "
+			for _, action := range tt.included {
+				if !strings.Contains(got, action+" ") {
+					t.Errorf("requiredActionsFor() = %v, missing %s", got, action)
+				}
+			}
+			for _, action := range tt.notIncluded {
+				if strings.Contains(got, action+" ") {
+					t.Errorf("requiredActionsFor() = %v, unexpected %s", got, action)
+				}
+			}
+		})
+	}
+}
+
+func Test_checkPermissions(t *testing.T) {
+	actions := []string{"ec2:DescribeInstances", "ec2:DescribeRegions", "ec2:RunInstances"}
+
+	identity := &sts.GetCallerIdentityOutput{
+		Arn: aws.String("arn:aws:sts::123456789012:assumed-role/AutoSpotting-LambdaExecutionRole/AutoSpotting"),
+	}
+	role := &iam.GetRoleOutput{
+		Role: &iam.Role{Arn: aws.String("arn:aws:iam::123456789012:role/lambda/AutoSpotting-LambdaExecutionRole")},
+	}
+
+	tests := []struct {
+		name     string
+		ec2      mockEC2
+		iam      mockIAM
+		sts      mockSTS
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name: "all allowed",
+			ec2: mockEC2{
+				dryrunerr: awserr.New("DryRunOperation", "", nil),
+				drerr:     awserr.New("DryRunOperation", "", nil),
+			},
+			iam: mockIAM{
+				gro: role,
+				sppo: &iam.SimulatePolicyResponse{
+					EvaluationResults: []*iam.EvaluationResult{
+						{EvalActionName: aws.String("ec2:RunInstances"), EvalDecision: aws.String("allowed")},
+					},
+				},
+			},
+			sts: mockSTS{gcio: identity},
+		},
+		{
+			name: "denied by dry run and simulation",
+			ec2: mockEC2{
+				dryrunerr: awserr.New("UnauthorizedOperation", "", nil),
+				drerr:     awserr.New("DryRunOperation", "", nil),
+			},
+			iam: mockIAM{
+				gro: role,
+				sppo: &iam.SimulatePolicyResponse{
+					EvaluationResults: []*iam.EvaluationResult{
+						{EvalActionName: aws.String("ec2:DescribeInstances"), EvalDecision: aws.String("implicitDeny")},
+						{EvalActionName: aws.String("ec2:RunInstances"), EvalDecision: aws.String("explicitDeny")},
+					},
+				},
+			},
+			sts:      mockSTS{gcio: identity},
+			expected: []string{"ec2:DescribeInstances", "ec2:RunInstances"},
+		},
+		{
+			name: "simulation not allowed",
+			ec2: mockEC2{
+				dryrunerr: awserr.New("DryRunOperation", "", nil),
+				drerr:     awserr.New("UnauthorizedOperation", "", nil),
+			},
+			iam:      mockIAM{gro: role, spperr: errors.New("AccessDenied")},
+			sts:      mockSTS{gcio: identity},
+			expected: []string{"ec2:DescribeRegions"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkPermissions(tt.ec2, tt.iam, tt.sts, actions)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPermissions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("checkPermissions() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_principalARN(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity string
+		iam      mockIAM
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "IAM user",
+			identity: "arn:aws:iam::123456789012:user/admin",
+			expected: "arn:aws:iam::123456789012:user/admin",
+		},
+		{
+			name:     "assumed role",
+			identity: "arn:aws:sts::123456789012:assumed-role/AutoSpotting/session",
+			iam: mockIAM{gro: &iam.GetRoleOutput{
+				Role: &iam.Role{Arn: aws.String("arn:aws:iam::123456789012:role/lambda/AutoSpotting")},
+			}},
+			expected: "arn:aws:iam::123456789012:role/lambda/AutoSpotting",
+		},
+		{
+			name:     "invalid ARN",
+			identity: "invalid",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := principalARN(tt.iam, tt.identity)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("principalARN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("principalARN() = %s, expected %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_permissionReport(t *testing.T) {
+	if got := permissionReport(nil); strings.Contains(got, "MISSING") {
+		t.Errorf("permissionReport() = %q, expected no missing permissions", got)
+	}
+
+	got := permissionReport([]string{"ec2:RunInstances", "iam:PassRole"})
+	for _, expected := range []string{"BEGIN MISSING IAM PERMISSIONS", "\tec2:RunInstances\n", "\tiam:PassRole\n"} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("permissionReport() = %q, missing %q", got, expected)
+		}
+	}
+}
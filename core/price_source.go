@@ -0,0 +1,389 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+// pricingAPIRegion is the region where the Pricing API endpoint is hosted.
+const pricingAPIRegion = "us-east-1"
+
+// priceSourceRetryInterval is how long a price source that failed to load is
+// skipped before trying to load it again.
+const priceSourceRetryInterval = 10 * time.Minute
+
+// priceSource provides the hourly on-demand prices of the instance types. The
+// platform is empty for Linux, otherwise one of the platforms priced
+// differently from Linux.
+type priceSource interface {
+	name() string
+	onDemandPrice(region, instanceType, platform string) (float64, bool)
+}
+
+// priceSources are resolved in order, the first source knowing the price of
+// an instance type providing it.
+type priceSources []priceSource
+
+func (ps priceSources) onDemandPrice(region, instanceType, platform string) float64 {
+	for _, s := range ps {
+		if price, found := s.onDemandPrice(region, instanceType, platform); found {
+			return price
+		}
+	}
+	return 0
+}
+
+// newPriceSources sets up the configured price sources: the override file,
+// the Pricing API and finally the static data shipped with AutoSpotting.
+func newPriceSources(conf *Config) priceSources {
+	var sources priceSources
+
+	if conf.PriceOverrideFile != "" {
+		sess, err := newSession(conf.MainRegion, conf)
+		if err != nil {
+			panic(err)
+		}
+		svc := s3.New(sess, conf.serviceConfig(s3.EndpointsID, conf.MainRegion))
+
+		sources = append(sources, newOverridePriceSource(svc, conf.PriceOverrideFile, conf.getClock()))
+	}
+
+	if conf.PricingAPI {
+		sess, err := newSession(pricingAPIRegion, conf)
+		if err != nil {
+			panic(err)
+		}
+		sources = append(sources, &pricingAPIPriceSource{
+			conn:  pricing.New(sess, conf.serviceConfig(pricing.EndpointsID, pricingAPIRegion)),
+			clock: conf.getClock(),
+		})
+	}
+
+	sources = append(sources, newStaticPriceSource(conf.InstanceData))
+
+	names := make([]string, 0, len(sources))
+	for _, s := range sources {
+		names = append(names, s.name())
+	}
+	log.Println("Resolving the on-demand prices from:", strings.Join(names, ", "))
+
+	return sources
+}
+
+// staticPriceSource provides the prices from the data of ec2instances.info
+// shipped with AutoSpotting.
+type staticPriceSource struct {
+	// the prices keyed by instance type and region
+	prices map[string]map[string]ec2instancesinfo.RegionPrices
+}
+
+func newStaticPriceSource(data *ec2instancesinfo.InstanceData) *staticPriceSource {
+	s := &staticPriceSource{prices: make(map[string]map[string]ec2instancesinfo.RegionPrices)}
+	if data == nil {
+		return s
+	}
+	for _, it := range *data {
+		s.prices[it.InstanceType] = it.Pricing
+	}
+	return s
+}
+
+func (s *staticPriceSource) name() string {
+	return "static data"
+}
+
+func (s *staticPriceSource) onDemandPrice(region, instanceType, platform string) (float64, bool) {
+	rp, found := s.prices[instanceType][region]
+	if !found {
+		return 0, false
+	}
+
+	price := rp.Linux.OnDemand
+	if platform != "" {
+		p, found := platforms[platform]
+		if !found {
+			return 0, false
+		}
+		price = p.onDemand(rp)
+	}
+	return price, price > 0
+}
+
+// pricingAPIOperatingSystems maps the operating systems reported by the
+// Pricing API to the platforms.
+var pricingAPIOperatingSystems = map[string]string{
+	"Linux":   "",
+	"Windows": windowsPlatform,
+	"RHEL":    rhelPlatform,
+	"SUSE":    susePlatform,
+}
+
+// pricingAPIPriceSource provides the public prices from the AWS Pricing API,
+// lazily fetched once for each region. Failed fetches are retried after
+// priceSourceRetryInterval.
+type pricingAPIPriceSource struct {
+	conn  pricingiface.PricingAPI
+	clock Clock
+
+	sync.Mutex
+	// the prices keyed by region, then by instance type and platform
+	prices map[string]map[string]float64
+	// the time of the last failed fetch, keyed by region
+	failedAt map[string]time.Time
+}
+
+func (s *pricingAPIPriceSource) name() string {
+	return "Pricing API"
+}
+
+func (s *pricingAPIPriceSource) onDemandPrice(region, instanceType, platform string) (float64, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.prices == nil {
+		s.prices = make(map[string]map[string]float64)
+		s.failedAt = make(map[string]time.Time)
+	}
+
+	prices, found := s.prices[region]
+	failedAt, failed := s.failedAt[region]
+	if !found || failed && s.clock.Now().Sub(failedAt) >= priceSourceRetryInterval {
+		var err error
+		if prices, err = s.fetch(region); err != nil {
+			log.Println("Couldn't fetch the on-demand prices in", region, "from the Pricing API,",
+				"retrying in", priceSourceRetryInterval, "-", err.Error())
+			// remember failures as well, to avoid retrying for each instance type
+			s.failedAt[region] = s.clock.Now()
+		} else {
+			delete(s.failedAt, region)
+		}
+		s.prices[region] = prices
+	}
+
+	price, found := prices[instanceType+"/"+platform]
+	return price, found
+}
+
+func (s *pricingAPIPriceSource) fetch(region string) (map[string]float64, error) {
+	filter := func(field, value string) *pricing.Filter {
+		return &pricing.Filter{
+			Type:  aws.String(pricing.FilterTypeTermMatch),
+			Field: aws.String(field),
+			Value: aws.String(value),
+		}
+	}
+
+	prices := make(map[string]float64)
+	err := s.conn.GetProductsPages(&pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		Filters: []*pricing.Filter{
+			filter("regionCode", region),
+			filter("tenancy", "Shared"),
+			filter("preInstalledSw", "NA"),
+			filter("capacitystatus", "Used"),
+			filter("licenseModel", "No License required"),
+		},
+	}, func(page *pricing.GetProductsOutput, lastPage bool) bool {
+		for _, product := range page.PriceList {
+			instanceType, os, price, ok := parsePricingAPIProduct(product)
+			if !ok {
+				continue
+			}
+			if platform, found := pricingAPIOperatingSystems[os]; found {
+				prices[instanceType+"/"+platform] = price
+			}
+		}
+		return true
+	})
+	return prices, err
+}
+
+// parsePricingAPIProduct extracts the instance type, operating system and
+// hourly on-demand price from a product returned by the Pricing API.
+func parsePricingAPIProduct(product aws.JSONValue) (string, string, float64, bool) {
+	object := func(v interface{}, key string) map[string]interface{} {
+		m, _ := v.(map[string]interface{})
+		o, _ := m[key].(map[string]interface{})
+		return o
+	}
+
+	attributes := object(product["product"], "attributes")
+	instanceType, _ := attributes["instanceType"].(string)
+	os, _ := attributes["operatingSystem"].(string)
+
+	for _, term := range object(product["terms"], "OnDemand") {
+		for _, dimension := range object(term, "priceDimensions") {
+			usd, _ := object(dimension, "pricePerUnit")["USD"].(string)
+			if price, err := strconv.ParseFloat(usd, 64); err == nil && price > 0 {
+				return instanceType, os, price, instanceType != ""
+			}
+		}
+	}
+	return "", "", 0, false
+}
+
+// overridePrice is an entry of the price override file. An empty region
+// applies to all the regions, and an empty platform stands for Linux.
+type overridePrice struct {
+	Region       string  `json:"region"`
+	InstanceType string  `json:"instance_type"`
+	Platform     string  `json:"platform"`
+	OnDemand     float64 `json:"on_demand"`
+}
+
+// overridePriceSource provides the prices given in a file, such as privately
+// negotiated prices or internal chargeback rates. When the file fails to
+// load, it is loaded again after priceSourceRetryInterval.
+type overridePriceSource struct {
+	svc   s3iface.S3API
+	url   string
+	clock Clock
+
+	sync.Mutex
+	// the prices keyed by region, instance type and platform
+	prices map[string]float64
+	// the time of the last failed load, zero once loaded
+	failedAt time.Time
+}
+
+// newOverridePriceSource loads the price override file, keeping the source
+// around to retry later if the file can't be loaded yet.
+func newOverridePriceSource(svc s3iface.S3API, s3URL string, clock Clock) *overridePriceSource {
+	s := &overridePriceSource{svc: svc, url: s3URL, clock: clock}
+	s.load()
+	return s
+}
+
+func (s *overridePriceSource) load() {
+	loaded, err := loadOverridePriceSource(s.svc, s.url)
+	if err != nil {
+		log.Println("Couldn't load the price override file, retrying in",
+			priceSourceRetryInterval, "-", err.Error())
+		s.failedAt = s.clock.Now()
+		return
+	}
+	s.prices = loaded.prices
+	s.failedAt = time.Time{}
+}
+
+// loadOverridePriceSource loads the price override file from the given S3 URL,
+// in the JSON format if its name ends with .json, otherwise as CSV.
+func loadOverridePriceSource(svc s3iface.S3API, s3URL string) (*overridePriceSource, error) {
+	bucket, key, err := parseS3URL(s3URL)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't download %s: %w", s3URL, err)
+	}
+	defer out.Body.Close()
+
+	var entries []overridePrice
+	if strings.HasSuffix(strings.ToLower(key), ".json") {
+		err = json.NewDecoder(out.Body).Decode(&entries)
+	} else {
+		entries, err = parseOverridePricesCSV(out.Body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %w", s3URL, err)
+	}
+
+	s := &overridePriceSource{prices: make(map[string]float64)}
+	for _, e := range entries {
+		if e.InstanceType == "" || e.OnDemand <= 0 {
+			return nil, fmt.Errorf("invalid price of %q in %s: %v", e.InstanceType, s3URL, e.OnDemand)
+		}
+		platform := strings.ToLower(e.Platform)
+		if platform == "linux" {
+			platform = ""
+		}
+		s.prices[e.Region+"/"+e.InstanceType+"/"+platform] = e.OnDemand
+	}
+	log.Println("Loaded", len(s.prices), "on-demand prices from", s3URL)
+	return s, nil
+}
+
+// parseOverridePricesCSV parses the CSV price override file, having the
+// region, instance_type, platform and on_demand columns, in this order, and
+// an optional header.
+func parseOverridePricesCSV(r io.Reader) ([]overridePrice, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []overridePrice
+	for n, record := range records {
+		if n == 0 && record[1] == "instance_type" {
+			continue
+		}
+		price, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+		entries = append(entries, overridePrice{
+			Region:       record[0],
+			InstanceType: record[1],
+			Platform:     record[2],
+			OnDemand:     price,
+		})
+	}
+	return entries, nil
+}
+
+// parseS3URL splits a s3://bucket/key URL into the bucket and the key.
+func parseS3URL(s3URL string) (string, string, error) {
+	u, err := url.Parse(s3URL)
+	if err != nil {
+		return "", "", err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return "", "", errors.New("expected a s3://bucket/key URL, got " + s3URL)
+	}
+	return u.Host, key, nil
+}
+
+func (s *overridePriceSource) name() string {
+	return "override file"
+}
+
+func (s *overridePriceSource) onDemandPrice(region, instanceType, platform string) (float64, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.failedAt.IsZero() && s.clock.Now().Sub(s.failedAt) >= priceSourceRetryInterval {
+		s.load()
+	}
+
+	if price, found := s.prices[region+"/"+instanceType+"/"+platform]; found {
+		return price, true
+	}
+	price, found := s.prices["/"+instanceType+"/"+platform]
+	return price, found
+}
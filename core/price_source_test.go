@@ -0,0 +1,289 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+func pricingAPIProduct(instanceType, os, usd string) aws.JSONValue {
+	return aws.JSONValue{
+		"product": map[string]interface{}{
+			"attributes": map[string]interface{}{
+				"instanceType":    instanceType,
+				"operatingSystem": os,
+			},
+		},
+		"terms": map[string]interface{}{
+			"OnDemand": map[string]interface{}{
+				"SKU.TERM": map[string]interface{}{
+					"priceDimensions": map[string]interface{}{
+						"SKU.TERM.RATE": map[string]interface{}{
+							"pricePerUnit": map[string]interface{}{"USD": usd},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func Test_priceSources_onDemandPrice(t *testing.T) {
+	var data ec2instancesinfo.InstanceData
+	data = append(data, ec2instancesinfo.InstanceData{{}}...)
+	data[0].InstanceType = "m5.large"
+	rp := ec2instancesinfo.RegionPrices{}
+	rp.Linux.OnDemand = 0.096
+	data[0].Pricing = map[string]ec2instancesinfo.RegionPrices{"us-east-1": rp}
+
+	var calls int
+	api := &pricingAPIPriceSource{conn: mockPricing{
+		gpo: &pricing.GetProductsOutput{PriceList: []aws.JSONValue{
+			pricingAPIProduct("m5.large", "Linux", "0.0960000000"),
+			pricingAPIProduct("c5.large", "Linux", "0.0850000000"),
+			pricingAPIProduct("c5.large", "Windows", "0.1770000000"),
+			pricingAPIProduct("c5.large", "Linux with SQL Std", "0.5000000000"),
+			pricingAPIProduct("c5.xlarge", "Linux", "0.0000000000"),
+		}},
+		gpcalls: &calls,
+	}}
+
+	override := &overridePriceSource{prices: map[string]float64{
+		"us-east-1/m5.large/": 0.07,
+		"/m5.large/windows":   0.15,
+		"eu-west-1/c5.large/": 0.09,
+	}}
+
+	sources := priceSources{override, api, newStaticPriceSource(&data)}
+
+	tests := []struct {
+		name         string
+		region       string
+		instanceType string
+		platform     string
+		expected     float64
+	}{
+		{name: "override in the region", region: "us-east-1", instanceType: "m5.large", expected: 0.07},
+		{name: "override in all regions", region: "us-east-1", instanceType: "m5.large", platform: windowsPlatform, expected: 0.15},
+		{name: "override in another region", region: "us-east-1", instanceType: "c5.large", expected: 0.085},
+		{name: "Pricing API platform", region: "us-east-1", instanceType: "c5.large", platform: windowsPlatform, expected: 0.177},
+		{name: "Pricing API without price", region: "us-east-1", instanceType: "c5.xlarge"},
+		{name: "static data", region: "us-east-1", instanceType: "m5.large", platform: rhelPlatform},
+		{name: "unknown", region: "us-east-1", instanceType: "x1.large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sources.onDemandPrice(tt.region, tt.instanceType, tt.platform); got != tt.expected {
+				t.Errorf("onDemandPrice() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+
+	if calls != 1 {
+		t.Errorf("the Pricing API was called %d times, expected once per region", calls)
+	}
+}
+
+func Test_staticPriceSource_onDemandPrice(t *testing.T) {
+	var data ec2instancesinfo.InstanceData
+	data = append(data, ec2instancesinfo.InstanceData{{}}...)
+	data[0].InstanceType = "m5.large"
+	rp := ec2instancesinfo.RegionPrices{}
+	rp.Linux.OnDemand = 0.096
+	rp.MSWin.OnDemand = 0.188
+	data[0].Pricing = map[string]ec2instancesinfo.RegionPrices{"us-east-1": rp}
+
+	s := newStaticPriceSource(&data)
+
+	tests := []struct {
+		name          string
+		region        string
+		platform      string
+		expected      float64
+		expectedFound bool
+	}{
+		{name: "linux", region: "us-east-1", expected: 0.096, expectedFound: true},
+		{name: "windows", region: "us-east-1", platform: windowsPlatform, expected: 0.188, expectedFound: true},
+		{name: "not priced platform", region: "us-east-1", platform: susePlatform},
+		{name: "other region", region: "eu-west-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := s.onDemandPrice(tt.region, "m5.large", tt.platform)
+			if got != tt.expected || found != tt.expectedFound {
+				t.Errorf("onDemandPrice() = %v, %v expected %v, %v", got, found, tt.expected, tt.expectedFound)
+			}
+		})
+	}
+}
+
+func Test_pricingAPIPriceSource_fetchError(t *testing.T) {
+	var calls int
+	clock := &mockClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := &pricingAPIPriceSource{
+		conn:  mockPricing{gperr: errors.New("AccessDenied"), gpcalls: &calls},
+		clock: clock,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, found := s.onDemandPrice("us-east-1", "m5.large", ""); found {
+			t.Errorf("onDemandPrice() found a price despite the API error")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("the Pricing API was called %d times, expected once", calls)
+	}
+
+	// the failed fetch is retried once the retry interval passed
+	s.conn = mockPricing{
+		gpo:     &pricing.GetProductsOutput{PriceList: []aws.JSONValue{pricingAPIProduct("m5.large", "Linux", "0.096")}},
+		gpcalls: &calls,
+	}
+	clock.Sleep(priceSourceRetryInterval - time.Second)
+	if _, found := s.onDemandPrice("us-east-1", "m5.large", ""); found || calls != 1 {
+		t.Errorf("onDemandPrice() retried before the retry interval, %d calls", calls)
+	}
+
+	clock.Sleep(time.Second)
+	for i := 0; i < 2; i++ {
+		if price, found := s.onDemandPrice("us-east-1", "m5.large", ""); !found || price != 0.096 {
+			t.Errorf("onDemandPrice() = %v, %v after the retry, expected 0.096, true", price, found)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("the Pricing API was called %d times, expected twice", calls)
+	}
+}
+
+func Test_overridePriceSource_retry(t *testing.T) {
+	clock := &mockClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := newOverridePriceSource(mockS3{goerr: errors.New("AccessDenied")}, "s3://bucket/prices.csv", clock)
+
+	if _, found := s.onDemandPrice("us-east-1", "m5.large", ""); found {
+		t.Errorf("onDemandPrice() found a price despite the download error")
+	}
+
+	s.svc = mockS3{goContent: "us-east-1,m5.large,,0.07\n"}
+	clock.Sleep(priceSourceRetryInterval - time.Second)
+	if _, found := s.onDemandPrice("us-east-1", "m5.large", ""); found {
+		t.Errorf("onDemandPrice() reloaded the file before the retry interval")
+	}
+
+	clock.Sleep(time.Second)
+	if price, found := s.onDemandPrice("us-east-1", "m5.large", ""); !found || price != 0.07 {
+		t.Errorf("onDemandPrice() = %v, %v after the retry, expected 0.07, true", price, found)
+	}
+	if !s.failedAt.IsZero() {
+		t.Errorf("the override file is still marked as failed after loading it")
+	}
+}
+
+func Test_loadOverridePriceSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		content     string
+		s3err       error
+		expected    map[string]float64
+		expectedErr bool
+	}{
+		{
+			name: "CSV",
+			url:  "s3://bucket/prices.csv",
+			content: "region,instance_type,platform,on_demand\n" +
+				"us-east-1,m5.large,,0.07\n" +
+				",m5.large,Linux,0.08\n" +
+				"us-east-1,m5.large,Windows,0.15\n",
+			expected: map[string]float64{
+				"us-east-1/m5.large/":        0.07,
+				"/m5.large/":                 0.08,
+				"us-east-1/m5.large/windows": 0.15,
+			},
+		},
+		{
+			name:     "JSON",
+			url:      "s3://bucket/path/prices.JSON",
+			content:  `[{"region": "us-east-1", "instance_type": "m5.large", "on_demand": 0.07}]`,
+			expected: map[string]float64{"us-east-1/m5.large/": 0.07},
+		},
+		{
+			name:        "invalid CSV price",
+			url:         "s3://bucket/prices.csv",
+			content:     "us-east-1,m5.large,,cheap\n",
+			expectedErr: true,
+		},
+		{
+			name:        "missing CSV column",
+			url:         "s3://bucket/prices.csv",
+			content:     "us-east-1,m5.large,0.07\n",
+			expectedErr: true,
+		},
+		{
+			name:        "missing price",
+			url:         "s3://bucket/prices.json",
+			content:     `[{"instance_type": "m5.large"}]`,
+			expectedErr: true,
+		},
+		{
+			name:        "download error",
+			url:         "s3://bucket/prices.csv",
+			s3err:       errors.New("NoSuchKey"),
+			expectedErr: true,
+		},
+		{
+			name:        "invalid URL",
+			url:         "https://bucket/prices.csv",
+			expectedErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := loadOverridePriceSource(mockS3{goContent: tt.content, goerr: tt.s3err}, tt.url)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("loadOverridePriceSource() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(s.prices) != len(tt.expected) {
+				t.Errorf("loadOverridePriceSource() = %v, expected %v", s.prices, tt.expected)
+			}
+			for key, price := range tt.expected {
+				if s.prices[key] != price {
+					t.Errorf("price of %s = %v, expected %v", key, s.prices[key], price)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseS3URL(t *testing.T) {
+	tests := []struct {
+		url            string
+		expectedBucket string
+		expectedKey    string
+		expectedErr    bool
+	}{
+		{url: "s3://bucket/prices.csv", expectedBucket: "bucket", expectedKey: "prices.csv"},
+		{url: "s3://bucket/a/b/prices.json", expectedBucket: "bucket", expectedKey: "a/b/prices.json"},
+		{url: "s3://bucket/", expectedErr: true},
+		{url: "s3:///prices.csv", expectedErr: true},
+		{url: "bucket/prices.csv", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			bucket, key, err := parseS3URL(tt.url)
+			if (err != nil) != tt.expectedErr || bucket != tt.expectedBucket || key != tt.expectedKey {
+				t.Errorf("parseS3URL() = %q, %q, %v expected %q, %q, error %v",
+					bucket, key, err, tt.expectedBucket, tt.expectedKey, tt.expectedErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// estimatedSavings estimates the hourly savings of replacing the running
+// on-demand instances of the group, as the sum of the differences between
+// the on-demand and spot prices of their instance types. It only relies on
+// the data collected while scanning the region, before the group is loaded.
+func (a *autoScalingGroup) estimatedSavings() float64 {
+	var savings float64
+	for _, member := range a.Instances {
+		i := a.region.instances.get(aws.StringValue(member.InstanceId))
+		if i == nil || i.isSpot() || i.stateName() != ec2.InstanceStateNameRunning {
+			continue
+		}
+
+		// the instance type may not be available as spot in the AZ
+		spotPrice := i.spotPriceOf(i.typeInfo)
+		if spotPrice <= 0 {
+			continue
+		}
+
+		if delta := i.onDemandPriceOf(i.typeInfo) - spotPrice; delta > 0 {
+			savings += delta
+		}
+	}
+	return savings
+}
+
+// prioritizedASGList returns the groups enabled for processing, starting
+// with those below the spot coverage threshold, then ordered by their
+// estimated savings when the run has a deadline, so that the most valuable
+// replacements are started first in case the run can't finish all of them in
+// time.
+func (r *region) prioritizedASGList() []*autoScalingGroup {
+	asgs := r.enabledASGList()
+	hasDeadline := r.conf.executionBudget.remaining() >= 0
+	threshold := r.conf.SpotCoverageThreshold
+	if !hasDeadline && threshold <= 0 {
+		return asgs
+	}
+
+	savings := make(map[*autoScalingGroup]float64, len(asgs))
+	drifted := make(map[*autoScalingGroup]bool, len(asgs))
+	for _, asg := range asgs {
+		if hasDeadline {
+			savings[asg] = asg.estimatedSavings()
+			debug.Println(r.name, asg.name, "Estimated savings:", savings[asg])
+		}
+		if threshold > 0 {
+			drifted[asg] = asg.estimatedSpotCoverage() < threshold
+		}
+	}
+
+	sort.SliceStable(asgs, func(i, j int) bool {
+		if drifted[asgs[i]] != drifted[asgs[j]] {
+			return drifted[asgs[i]]
+		}
+		return savings[asgs[i]] > savings[asgs[j]]
+	})
+
+	log.Println(r.name, "Processing the groups in the order of their spot coverage and estimated savings")
+	return asgs
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func priorityTestRegion(deadline time.Time) *region {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	conf := &Config{clock: &mockClock{now: now}, executionDeadline: deadline}
+	conf.executionBudget = newExecutionBudget(conf)
+
+	r := &region{conf: conf, instances: makeInstances()}
+
+	add := func(id, lifecycle, state string, onDemand, spot float64) {
+		i := &instance{
+			Instance: &ec2.Instance{
+				InstanceId: aws.String(id),
+				Placement:  &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				State:      &ec2.InstanceState{Name: aws.String(state)},
+			},
+			region: r,
+			typeInfo: instanceTypeInformation{pricing: prices{
+				onDemand: onDemand,
+				spot:     spotPriceMap{"us-east-1a": spot},
+			}},
+		}
+		if lifecycle != "" {
+			i.InstanceLifecycle = aws.String(lifecycle)
+		}
+		r.instances.add(i)
+	}
+	add("i-small1", "", ec2.InstanceStateNameRunning, 0.1, 0.03)
+	add("i-small2", "", ec2.InstanceStateNameRunning, 0.1, 0.03)
+	add("i-large", "", ec2.InstanceStateNameRunning, 0.8, 0.2)
+	add("i-spot", Spot, ec2.InstanceStateNameRunning, 0.8, 0.2)
+	add("i-stopped", "", ec2.InstanceStateNameStopped, 0.8, 0.2)
+	add("i-no-spot", "", ec2.InstanceStateNameRunning, 0.8, 0)
+
+	group := func(name string, ids ...string) *autoScalingGroup {
+		a := &autoScalingGroup{name: name, region: r, Group: &autoscaling.Group{}}
+		for _, id := range ids {
+			a.Instances = append(a.Instances, &autoscaling.Instance{InstanceId: aws.String(id)})
+		}
+		return a
+	}
+	r.enabledASGs = []*autoScalingGroup{
+		group("none", "i-spot", "i-stopped", "i-no-spot", "i-missing"),
+		group("small", "i-small1", "i-small2"),
+		group("large", "i-large", "i-spot"),
+	}
+	return r
+}
+
+func Test_autoScalingGroup_estimatedSavings(t *testing.T) {
+	r := priorityTestRegion(time.Time{})
+
+	expected := map[string]float64{"none": 0, "small": 0.14, "large": 0.6}
+	for _, a := range r.enabledASGs {
+		if got := a.estimatedSavings(); math.Abs(got-expected[a.name]) > 1e-9 {
+			t.Errorf("%s estimatedSavings() = %v, expected %v", a.name, got, expected[a.name])
+		}
+	}
+}
+
+func Test_autoScalingGroup_estimatedSpotCoverage(t *testing.T) {
+	r := priorityTestRegion(time.Time{})
+
+	expected := map[string]float64{"none": 50, "small": 0, "large": 50}
+	for _, a := range r.enabledASGs {
+		if got := a.estimatedSpotCoverage(); got != expected[a.name] {
+			t.Errorf("%s estimatedSpotCoverage() = %v, expected %v", a.name, got, expected[a.name])
+		}
+	}
+}
+
+func Test_region_prioritizedASGList(t *testing.T) {
+	tests := []struct {
+		name      string
+		deadline  time.Time
+		threshold float64
+		expected  []string
+	}{
+		{
+			name:     "no deadline",
+			expected: []string{"none", "small", "large"},
+		},
+		{
+			name:     "deadline",
+			deadline: time.Date(2021, 1, 1, 0, 10, 0, 0, time.UTC),
+			expected: []string{"large", "small", "none"},
+		},
+		{
+			name:      "below the spot coverage threshold",
+			threshold: 40,
+			expected:  []string{"small", "none", "large"},
+		},
+		{
+			name:      "below the spot coverage threshold with a deadline",
+			deadline:  time.Date(2021, 1, 1, 0, 10, 0, 0, time.UTC),
+			threshold: 40,
+			expected:  []string{"small", "large", "none"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := priorityTestRegion(tt.deadline)
+			r.conf.SpotCoverageThreshold = tt.threshold
+
+			var got []string
+			for _, a := range r.prioritizedASGList() {
+				got = append(got, a.name)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("prioritizedASGList() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
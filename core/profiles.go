@@ -0,0 +1,160 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// profile is a named configuration profile, such as the one of a business
+// unit sharing the deployment with others. Each profile selects its own
+// groups, regions, notification targets and budgets, overriding the global
+// configuration, and is processed in isolation from the other profiles.
+type profile struct {
+	Name                  string  `json:"name"`
+	FilterByTags          string  `json:"tag_filters"`
+	TagFilteringMode      string  `json:"tag_filtering_mode"`
+	Regions               string  `json:"regions"`
+	SpotCoverageTopic     string  `json:"spot_coverage_topic"`
+	SpotCoverageThreshold float64 `json:"spot_coverage_threshold"`
+	TerminationEventBus   string  `json:"termination_event_bus"`
+	MaxErrors             int64   `json:"max_errors"`
+	MaxErrorRate          float64 `json:"max_error_rate"`
+	MaxAPICalls           int64   `json:"max_api_calls"`
+	MaxConcurrentSwaps    int     `json:"max_concurrent_swaps"`
+}
+
+// parseProfiles parses the JSON list of configuration profiles.
+func parseProfiles(value string) ([]profile, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var profiles []profile
+	if err := json.Unmarshal([]byte(value), &profiles); err != nil {
+		return nil, fmt.Errorf("couldn't parse the profiles: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range profiles {
+		switch {
+		case p.Name == "":
+			return nil, fmt.Errorf("profile without a name")
+		case seen[p.Name]:
+			return nil, fmt.Errorf("duplicate profile %s", p.Name)
+		case p.TagFilteringMode != "" && p.TagFilteringMode != "opt-in" && p.TagFilteringMode != "opt-out":
+			return nil, fmt.Errorf("invalid tag_filtering_mode %s of profile %s", p.TagFilteringMode, p.Name)
+		case p.SpotCoverageThreshold < 0 || p.SpotCoverageThreshold > 100:
+			return nil, fmt.Errorf("invalid spot_coverage_threshold %v of profile %s", p.SpotCoverageThreshold, p.Name)
+		case p.MaxErrors < 0 || p.MaxErrorRate < 0 || p.MaxAPICalls < 0 || p.MaxConcurrentSwaps < 0:
+			return nil, fmt.Errorf("negative budget of profile %s", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return profiles, nil
+}
+
+// config derives the configuration of the profile from the global one, with
+// its own budgets and reports. The caches of the region data and prices are
+// shared with the other profiles.
+func (p profile) config(global *Config) *Config {
+	conf := *global
+	conf.profileName = p.Name
+	conf.FinalRecap = make(map[string][]string)
+
+	if p.FilterByTags != "" {
+		conf.FilterByTags = p.FilterByTags
+	}
+	if p.TagFilteringMode != "" {
+		conf.TagFilteringMode = p.TagFilteringMode
+	}
+	if p.Regions != "" {
+		conf.Regions = p.Regions
+	}
+	if p.SpotCoverageTopic != "" {
+		conf.SpotCoverageTopic = p.SpotCoverageTopic
+	}
+	if p.SpotCoverageThreshold != 0 {
+		conf.SpotCoverageThreshold = p.SpotCoverageThreshold
+	}
+	if p.TerminationEventBus != "" {
+		conf.TerminationEventBus = p.TerminationEventBus
+	}
+	if p.MaxErrors != 0 {
+		conf.MaxErrors = p.MaxErrors
+	}
+	if p.MaxErrorRate != 0 {
+		conf.MaxErrorRate = p.MaxErrorRate
+	}
+	if p.MaxAPICalls != 0 {
+		conf.MaxAPICalls = p.MaxAPICalls
+	}
+	if p.MaxConcurrentSwaps != 0 {
+		conf.MaxConcurrentSwaps = p.MaxConcurrentSwaps
+	}
+
+	conf.apiCalls = newAPICallBudget(conf.MaxAPICalls)
+	conf.swapLimiter = newSwapLimiter(conf.MaxConcurrentSwaps)
+	return &conf
+}
+
+// loadProfiles sets up the configuration profiles, reusing the connections
+// of the main region unless the profile needs its own.
+func (a *AutoSpotting) loadProfiles() error {
+	profiles, err := parseProfiles(a.config.Profiles)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range profiles {
+		conf := p.config(a.config)
+		pa := &AutoSpotting{
+			config:           conf,
+			mainEC2Conn:      a.mainEC2Conn,
+			costExplorerConn: a.costExplorerConn,
+			cloudWatchConn:   a.cloudWatchConn,
+			snsConn:          a.snsConn,
+		}
+		if conf.SpotCoverageTopic != a.config.SpotCoverageTopic {
+			pa.snsConn = connectSNS(conf, conf.SpotCoverageTopic)
+		}
+		log.Printf("Loaded profile %s processing the groups matching %q in the regions %q",
+			p.Name, conf.FilterByTags, conf.Regions)
+		a.profiles = append(a.profiles, pa)
+	}
+	return nil
+}
+
+// processProfiles runs each profile in turn, so that the errors and budgets
+// of a profile don't affect the others, and reports each of them separately.
+func (a *AutoSpotting) processProfiles() {
+	var savings float64
+	for _, pa := range a.profiles {
+		// the profiles are set up before the execution limits of the run are
+		// known
+		pa.config.inheritRunSettings(a.config)
+
+		log.Printf("####### BEGIN PROFILE %s #######", pa.config.profileName)
+		pa.ProcessCronEvent()
+		log.Printf("####### END PROFILE %s #######", pa.config.profileName)
+		savings += totalSavings
+	}
+	totalSavings = savings
+}
+
+// recapTitle names the final recap after the member account and the profile
+// it belongs to, if any.
+func (cfg *Config) recapTitle() string {
+	title := "FINAL RECAP"
+	if cfg.accountID != "" {
+		title += " OF ACCOUNT " + cfg.accountID
+	}
+	if cfg.profileName != "" {
+		title += " OF PROFILE " + cfg.profileName
+	}
+	return title
+}
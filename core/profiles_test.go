@@ -0,0 +1,139 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []profile
+		wantErr bool
+	}{
+		{
+			name: "no profiles",
+		},
+		{
+			name:  "profiles",
+			value: `[{"name":"payments","tag_filters":"team=payments","max_errors":5},{"name":"search","regions":"eu-*"}]`,
+			want: []profile{
+				{Name: "payments", FilterByTags: "team=payments", MaxErrors: 5},
+				{Name: "search", Regions: "eu-*"},
+			},
+		},
+		{
+			name:    "invalid JSON",
+			value:   `{"name":"payments"}`,
+			wantErr: true,
+		},
+		{
+			name:    "profile without a name",
+			value:   `[{"tag_filters":"team=payments"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate profiles",
+			value:   `[{"name":"payments"},{"name":"payments"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid tag filtering mode",
+			value:   `[{"name":"payments","tag_filtering_mode":"opt-maybe"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid spot coverage threshold",
+			value:   `[{"name":"payments","spot_coverage_threshold":120}]`,
+			wantErr: true,
+		},
+		{
+			name:    "negative budget",
+			value:   `[{"name":"payments","max_api_calls":-1}]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProfiles(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProfiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProfiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_profile_config(t *testing.T) {
+	global := &Config{
+		FilterByTags:       "spot-enabled=true",
+		TagFilteringMode:   "opt-in",
+		Regions:            "us-*",
+		MaxErrors:          10,
+		MaxAPICalls:        5000,
+		MaxConcurrentSwaps: 20,
+		FinalRecap:         map[string][]string{"us-east-1": {"global recap"}},
+	}
+
+	p := profile{
+		Name:                "payments",
+		FilterByTags:        "team=payments",
+		Regions:             "eu-*",
+		TerminationEventBus: "payments",
+		MaxErrors:           5,
+	}
+
+	got := p.config(global)
+
+	if got.profileName != "payments" || got.FilterByTags != "team=payments" || got.Regions != "eu-*" ||
+		got.TerminationEventBus != "payments" || got.MaxErrors != 5 {
+		t.Errorf("config() didn't apply the overrides of the profile: %+v", got)
+	}
+	if got.TagFilteringMode != "opt-in" || got.MaxAPICalls != 5000 || got.MaxConcurrentSwaps != 20 {
+		t.Errorf("config() didn't keep the global configuration: %+v", got)
+	}
+	if len(got.FinalRecap) != 0 {
+		t.Errorf("config() shares the final recap with the global configuration: %v", got.FinalRecap)
+	}
+	if got.apiCalls == nil || got.apiCalls == global.apiCalls || got.swapLimiter == nil {
+		t.Errorf("config() didn't set up the budgets of the profile")
+	}
+	if global.FilterByTags != "spot-enabled=true" || global.profileName != "" {
+		t.Errorf("config() changed the global configuration: %+v", global)
+	}
+}
+
+func TestAutoSpotting_loadProfiles(t *testing.T) {
+	a := &AutoSpotting{config: &Config{
+		Profiles:     `[{"name":"payments","tag_filters":"team=payments"},{"name":"search"}]`,
+		FilterByTags: "spot-enabled=true",
+	}}
+
+	if err := a.loadProfiles(); err != nil {
+		t.Fatalf("loadProfiles() error = %v", err)
+	}
+
+	var names, filters []string
+	for _, pa := range a.profiles {
+		names = append(names, pa.config.profileName)
+		filters = append(filters, pa.config.FilterByTags)
+		if pa.config.recapTitle() != "FINAL RECAP OF PROFILE "+pa.config.profileName {
+			t.Errorf("recapTitle() = %s", pa.config.recapTitle())
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"payments", "search"}) {
+		t.Errorf("loadProfiles() loaded %v", names)
+	}
+	if !reflect.DeepEqual(filters, []string{"team=payments", "spot-enabled=true"}) {
+		t.Errorf("loadProfiles() tag filters = %v", filters)
+	}
+	if a.config.recapTitle() != "FINAL RECAP" {
+		t.Errorf("recapTitle() = %s, expected FINAL RECAP", a.config.recapTitle())
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// The active checks which can determine when the spot instances are ready to
+// be attached to their groups, instead of waiting for the grace period.
+const (
+	// StatusChecksReadiness requires the EC2 instance and system status checks
+	// of the spot instances to pass
+	StatusChecksReadiness = "status_checks"
+
+	// SSMAgentReadiness requires the SSM agent of the spot instances to be
+	// online, which usually happens at the end of their boot process
+	SSMAgentReadiness = "ssm_agent"
+)
+
+// readinessChecks lists all the available readiness checks.
+var readinessChecks = []string{
+	StatusChecksReadiness,
+	SSMAgentReadiness,
+}
+
+// parseReadinessChecks validates the comma-separated list of readiness checks,
+// returning an error for unknown checks.
+func parseReadinessChecks(value string) ([]string, error) {
+	known := make(map[string]bool, len(readinessChecks))
+	for _, check := range readinessChecks {
+		known[check] = true
+	}
+
+	var checks []string
+	for _, check := range strings.Split(value, ",") {
+		check = strings.TrimSpace(check)
+		if check == "" {
+			continue
+		}
+		if !known[check] {
+			return nil, fmt.Errorf("unknown readiness check %q, expected one of %s",
+				check, strings.Join(readinessChecks, ","))
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// activeReadinessChecks returns the readiness checks configured for the group.
+func (a *autoScalingGroup) activeReadinessChecks() []string {
+	checks, err := parseReadinessChecks(a.config.ReadinessChecks)
+	if err != nil {
+		return nil
+	}
+	return checks
+}
+
+// passesReadinessChecks determines if the running spot instance passes all
+// the given readiness checks.
+func (i *instance) passesReadinessChecks(checks []string) bool {
+	for _, check := range checks {
+		var ready bool
+		var err error
+
+		switch check {
+		case StatusChecksReadiness:
+			ready, err = i.passesStatusChecks()
+		case SSMAgentReadiness:
+			ready, err = i.hasOnlineSSMAgent()
+		}
+
+		if err != nil {
+			log.Println("Couldn't run the", check, "readiness check of the spot instance",
+				aws.StringValue(i.InstanceId), err.Error())
+			return false
+		}
+		if !ready {
+			log.Println("The spot instance", aws.StringValue(i.InstanceId), "didn't pass the", check,
+				"readiness check yet, waiting for it to be ready before we can attach it to the group...")
+			return false
+		}
+	}
+
+	log.Println("The spot instance", aws.StringValue(i.InstanceId),
+		"passed the readiness checks and is ready to attach to the group.")
+	return true
+}
+
+func (i *instance) passesStatusChecks() (bool, error) {
+	resp, err := i.region.services.ec2.DescribeInstanceStatus(&ec2.DescribeInstanceStatusInput{
+		InstanceIds: []*string{i.InstanceId},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if resp == nil || len(resp.InstanceStatuses) == 0 {
+		return false, nil
+	}
+
+	s := resp.InstanceStatuses[0]
+	return s.InstanceStatus != nil && s.SystemStatus != nil &&
+		aws.StringValue(s.InstanceStatus.Status) == ec2.SummaryStatusOk &&
+		aws.StringValue(s.SystemStatus.Status) == ec2.SummaryStatusOk, nil
+}
+
+func (i *instance) hasOnlineSSMAgent() (bool, error) {
+	resp, err := i.region.services.ssm.DescribeInstanceInformation(&ssm.DescribeInstanceInformationInput{
+		Filters: []*ssm.InstanceInformationStringFilter{
+			{
+				Key:    aws.String("InstanceIds"),
+				Values: []*string{i.InstanceId},
+			},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if resp == nil || len(resp.InstanceInformationList) == 0 {
+		return false, nil
+	}
+	return aws.StringValue(resp.InstanceInformationList[0].PingStatus) == ssm.PingStatusOnline, nil
+}
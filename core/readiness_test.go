@@ -0,0 +1,181 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func Test_parseReadinessChecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+		wantErr  bool
+	}{
+		{name: "empty"},
+		{
+			name:     "both checks",
+			value:    "status_checks, ssm_agent",
+			expected: []string{StatusChecksReadiness, SSMAgentReadiness},
+		},
+		{name: "unknown check", value: "status_checks,elb", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReadinessChecks(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseReadinessChecks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseReadinessChecks() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_passesReadinessChecks(t *testing.T) {
+	okStatus := &ec2.DescribeInstanceStatusOutput{
+		InstanceStatuses: []*ec2.InstanceStatus{{
+			InstanceStatus: &ec2.InstanceStatusSummary{Status: aws.String(ec2.SummaryStatusOk)},
+			SystemStatus:   &ec2.InstanceStatusSummary{Status: aws.String(ec2.SummaryStatusOk)},
+		}},
+	}
+	initializingStatus := &ec2.DescribeInstanceStatusOutput{
+		InstanceStatuses: []*ec2.InstanceStatus{{
+			InstanceStatus: &ec2.InstanceStatusSummary{Status: aws.String(ec2.SummaryStatusInitializing)},
+			SystemStatus:   &ec2.InstanceStatusSummary{Status: aws.String(ec2.SummaryStatusOk)},
+		}},
+	}
+	onlineAgent := &ssm.DescribeInstanceInformationOutput{
+		InstanceInformationList: []*ssm.InstanceInformation{{PingStatus: aws.String(ssm.PingStatusOnline)}},
+	}
+
+	tests := []struct {
+		name     string
+		checks   []string
+		diso     *ec2.DescribeInstanceStatusOutput
+		diserr   error
+		diio     *ssm.DescribeInstanceInformationOutput
+		diierr   error
+		expected bool
+	}{
+		{name: "no checks", expected: true},
+		{
+			name:     "status checks passed",
+			checks:   []string{StatusChecksReadiness},
+			diso:     okStatus,
+			expected: true,
+		},
+		{
+			name:     "status checks initializing",
+			checks:   []string{StatusChecksReadiness},
+			diso:     initializingStatus,
+			expected: false,
+		},
+		{
+			name:     "status checks unavailable",
+			checks:   []string{StatusChecksReadiness},
+			diso:     &ec2.DescribeInstanceStatusOutput{},
+			expected: false,
+		},
+		{
+			name:     "status checks error",
+			checks:   []string{StatusChecksReadiness},
+			diserr:   errors.New("throttled"),
+			expected: false,
+		},
+		{
+			name:     "SSM agent online",
+			checks:   []string{SSMAgentReadiness},
+			diio:     onlineAgent,
+			expected: true,
+		},
+		{
+			name:     "SSM agent not registered yet",
+			checks:   []string{SSMAgentReadiness},
+			diio:     &ssm.DescribeInstanceInformationOutput{},
+			expected: false,
+		},
+		{
+			name:     "SSM agent error",
+			checks:   []string{SSMAgentReadiness},
+			diierr:   errors.New("denied"),
+			expected: false,
+		},
+		{
+			name:     "all checks passed",
+			checks:   []string{StatusChecksReadiness, SSMAgentReadiness},
+			diso:     okStatus,
+			diio:     onlineAgent,
+			expected: true,
+		},
+		{
+			name:     "one check failed",
+			checks:   []string{StatusChecksReadiness, SSMAgentReadiness},
+			diso:     okStatus,
+			diio:     &ssm.DescribeInstanceInformationOutput{},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-spot")},
+				region: &region{
+					services: connections{
+						ec2: mockEC2{diso: tt.diso, diserr: tt.diserr},
+						ssm: mockSSM{diio: tt.diio, diierr: tt.diierr},
+					},
+				},
+			}
+			if got := i.passesReadinessChecks(tt.checks); got != tt.expected {
+				t.Errorf("passesReadinessChecks() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isReadyToAttach_readinessChecks(t *testing.T) {
+	launchTime := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId: aws.String("i-spot"),
+			LaunchTime: aws.Time(launchTime),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		},
+		region: &region{
+			services: connections{
+				ec2: mockEC2{diso: &ec2.DescribeInstanceStatusOutput{
+					InstanceStatuses: []*ec2.InstanceStatus{{
+						InstanceStatus: &ec2.InstanceStatusSummary{Status: aws.String(ec2.SummaryStatusOk)},
+						SystemStatus:   &ec2.InstanceStatusSummary{Status: aws.String(ec2.SummaryStatusOk)},
+					}},
+				}},
+			},
+		},
+	}
+	asg := &autoScalingGroup{
+		name:   "asg",
+		Group:  &autoscaling.Group{HealthCheckGracePeriod: aws.Int64(3600)},
+		config: AutoScalingConfig{ReadinessChecks: StatusChecksReadiness},
+		region: &region{conf: &Config{
+			clock: &mockClock{now: launchTime.Add(time.Minute)},
+		}},
+	}
+
+	// the passed readiness checks take precedence over the grace period
+	if !i.isReadyToAttach(asg) {
+		t.Errorf("isReadyToAttach() = false, expected true within the grace period")
+	}
+}
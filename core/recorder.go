@@ -0,0 +1,205 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// apiCall is a single AWS API call as persisted in the recordings file
+type apiCall struct {
+	Service   string          `json:"service"`
+	Operation string          `json:"operation"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	Output    json.RawMessage `json:"output,omitempty"`
+	Error     *apiCallError   `json:"error,omitempty"`
+}
+
+type apiCallError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiRecorder captures the AWS API calls made through the sessions it's
+// attached to, or replays previously captured calls instead of sending them
+// to AWS. Replaying a recording allows running the full region, group and
+// instance processing offline, for reproducing issues and regression testing.
+type apiRecorder struct {
+	sync.Mutex
+
+	file      string
+	replaying bool
+
+	calls []apiCall
+	used  []bool
+}
+
+// newAPIRecorder creates a recorder which saves the calls into the given file
+func newAPIRecorder(file string) *apiRecorder {
+	return &apiRecorder{file: file}
+}
+
+// loadAPIReplayer creates a recorder which replays the calls from the given
+// file
+func loadAPIReplayer(file string) (*apiRecorder, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API recordings from %s: %s", file, err.Error())
+	}
+
+	r := &apiRecorder{file: file, replaying: true}
+	if err := json.Unmarshal(data, &r.calls); err != nil {
+		return nil, fmt.Errorf("failed to parse API recordings from %s: %s", file, err.Error())
+	}
+	r.used = make([]bool, len(r.calls))
+	return r, nil
+}
+
+// setupAPIRecorder initializes the recording or replaying of the AWS API
+// calls, if configured.
+func (cfg *Config) setupAPIRecorder() error {
+	switch {
+	case cfg.ReplayAPICalls != "":
+		r, err := loadAPIReplayer(cfg.ReplayAPICalls)
+		if err != nil {
+			return err
+		}
+		log.Println("Replaying AWS API calls from", cfg.ReplayAPICalls)
+		cfg.apiRecorder = r
+	case cfg.RecordAPICalls != "":
+		log.Println("Recording AWS API calls to", cfg.RecordAPICalls)
+		cfg.apiRecorder = newAPIRecorder(cfg.RecordAPICalls)
+	}
+	return nil
+}
+
+// attach registers the recording or replaying handlers on the handlers of a
+// session, which are inherited by all the service clients created from it.
+func (r *apiRecorder) attach(h *request.Handlers) {
+	if r == nil {
+		return
+	}
+
+	if !r.replaying {
+		h.Complete.PushBack(r.record)
+		return
+	}
+
+	// Replayed requests are neither signed, since there may be no credentials
+	// available, nor sent to AWS.
+	h.Validate.PushFront(func(req *request.Request) {
+		req.Handlers.Sign.Clear()
+	})
+	h.Send.Clear()
+	h.Send.PushBack(r.replay)
+}
+
+func (r *apiRecorder) record(req *request.Request) {
+	call := apiCall{
+		Service:   req.ClientInfo.ServiceName,
+		Operation: req.Operation.Name,
+	}
+
+	call.Input, _ = json.Marshal(req.Params)
+
+	if req.Error != nil {
+		call.Error = &apiCallError{Message: req.Error.Error()}
+		if aerr, ok := req.Error.(awserr.Error); ok {
+			call.Error.Code, call.Error.Message = aerr.Code(), aerr.Message()
+		}
+	} else if req.Data != nil {
+		call.Output, _ = json.Marshal(req.Data)
+	}
+
+	r.Lock()
+	r.calls = append(r.calls, call)
+	r.Unlock()
+}
+
+func (r *apiRecorder) replay(req *request.Request) {
+	// the responses are already unmarshaled from the recording
+	req.Handlers.UnmarshalMeta.Clear()
+	req.Handlers.ValidateResponse.Clear()
+	req.Handlers.Unmarshal.Clear()
+	req.Handlers.UnmarshalError.Clear()
+	req.Retryable = aws.Bool(false)
+
+	input, _ := json.Marshal(req.Params)
+
+	call := r.next(req.ClientInfo.ServiceName, req.Operation.Name, input)
+	if call == nil {
+		req.Error = awserr.New("MissingRecording",
+			fmt.Sprintf("no recorded response for %s %s", req.ClientInfo.ServiceName, req.Operation.Name), nil)
+		return
+	}
+
+	if call.Error != nil {
+		req.Error = awserr.New(call.Error.Code, call.Error.Message, nil)
+		return
+	}
+
+	if req.Data != nil && len(call.Output) > 0 {
+		if err := json.Unmarshal(call.Output, req.Data); err != nil {
+			req.Error = awserr.New("InvalidRecording", "failed to parse recorded response", err)
+		}
+	}
+}
+
+// next returns the first unused recorded call matching the service, operation
+// and input, falling back to the first unused call of the same operation,
+// since some inputs such as timestamps change between runs.
+func (r *apiRecorder) next(service, operation string, input []byte) *apiCall {
+	r.Lock()
+	defer r.Unlock()
+
+	fallback := -1
+	for i, c := range r.calls {
+		if r.used[i] || c.Service != service || c.Operation != operation {
+			continue
+		}
+		if string(c.Input) == string(input) {
+			r.used[i] = true
+			return &r.calls[i]
+		}
+		if fallback < 0 {
+			fallback = i
+		}
+	}
+
+	if fallback < 0 {
+		return nil
+	}
+	r.used[fallback] = true
+	return &r.calls[fallback]
+}
+
+// save persists the recorded calls, it does nothing when replaying.
+func (r *apiRecorder) save() error {
+	if r == nil || r.replaying {
+		return nil
+	}
+
+	r.Lock()
+	data, err := json.MarshalIndent(r.calls, "", "  ")
+	r.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(r.file, data, 0600); err != nil {
+		log.Println("Failed to save the AWS API recordings to", r.file, err.Error())
+		return err
+	}
+	log.Println("Saved the AWS API recordings to", r.file)
+	return nil
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+const describeRegionsResponse = `<DescribeRegionsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <regionInfo>
+    <item>
+      <regionName>us-east-1</regionName>
+      <regionEndpoint>ec2.us-east-1.amazonaws.com</regionEndpoint>
+    </item>
+  </regionInfo>
+</DescribeRegionsResponse>`
+
+const unauthorizedResponse = `<Response><Errors><Error><Code>UnauthorizedOperation</Code>` +
+	`<Message>denied</Message></Error></Errors><RequestID>x</RequestID></Response>`
+
+func Test_apiRecorder_recordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") == "DescribeRegions" {
+			w.Write([]byte(describeRegionsResponse))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(unauthorizedResponse))
+	}))
+	defer server.Close()
+
+	for _, v := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		defer os.Setenv(v, os.Getenv(v))
+		os.Setenv(v, "test")
+	}
+
+	f, err := ioutil.TempFile("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// record the calls against the fake endpoint
+	conf := &Config{EndpointURL: server.URL, RecordAPICalls: f.Name()}
+	if err := conf.setupAPIRecorder(); err != nil {
+		t.Fatalf("setupAPIRecorder() unexpected error: %v", err)
+	}
+
+	sess, err := newSession("us-east-1", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := ec2.New(sess, conf.serviceConfig(ec2.EndpointsID, "us-east-1"))
+
+	if _, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{}); err != nil {
+		t.Fatalf("DescribeRegions() unexpected error: %v", err)
+	}
+	svc.DescribeInstances(&ec2.DescribeInstancesInput{})
+
+	if err := conf.apiRecorder.save(); err != nil {
+		t.Fatalf("save() unexpected error: %v", err)
+	}
+
+	// replay them without any endpoint being reachable
+	server.Close()
+
+	conf = &Config{ReplayAPICalls: f.Name()}
+	if err := conf.setupAPIRecorder(); err != nil {
+		t.Fatalf("setupAPIRecorder() unexpected error: %v", err)
+	}
+
+	sess, err = newSession("us-east-1", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc = ec2.New(sess, conf.serviceConfig(ec2.EndpointsID, "us-east-1"))
+
+	regions, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		t.Fatalf("replayed DescribeRegions() unexpected error: %v", err)
+	}
+	if len(regions.Regions) != 1 || aws.StringValue(regions.Regions[0].RegionName) != "us-east-1" {
+		t.Errorf("replayed DescribeRegions() = %v", regions)
+	}
+
+	_, err = svc.DescribeInstances(&ec2.DescribeInstancesInput{})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "UnauthorizedOperation" {
+		t.Errorf("replayed DescribeInstances() error = %v, expected UnauthorizedOperation", err)
+	}
+
+	_, err = svc.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "MissingRecording" {
+		t.Errorf("DescribeRegions() error = %v, expected MissingRecording", err)
+	}
+}
+
+func Test_apiRecorder_next(t *testing.T) {
+	r := &apiRecorder{
+		replaying: true,
+		calls: []apiCall{
+			{Service: "ec2", Operation: "DescribeInstances", Input: []byte(`{"InstanceIds":["i-1"]}`)},
+			{Service: "ec2", Operation: "DescribeInstances", Input: []byte(`{"InstanceIds":["i-2"]}`)},
+			{Service: "autoscaling", Operation: "DescribeAutoScalingGroups"},
+		},
+	}
+	r.used = make([]bool, len(r.calls))
+
+	tests := []struct {
+		name      string
+		service   string
+		operation string
+		input     string
+		expected  *apiCall
+	}{
+		{
+			name:      "exact input match",
+			service:   "ec2",
+			operation: "DescribeInstances",
+			input:     `{"InstanceIds":["i-2"]}`,
+			expected:  &r.calls[1],
+		},
+		{
+			name:      "fallback to the first unused call",
+			service:   "ec2",
+			operation: "DescribeInstances",
+			input:     `{"InstanceIds":["i-3"]}`,
+			expected:  &r.calls[0],
+		},
+		{
+			name:      "all calls used",
+			service:   "ec2",
+			operation: "DescribeInstances",
+			input:     `{"InstanceIds":["i-1"]}`,
+			expected:  nil,
+		},
+		{
+			name:      "unknown operation",
+			service:   "ec2",
+			operation: "RunInstances",
+			expected:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.next(tt.service, tt.operation, []byte(tt.input)); got != tt.expected {
+				t.Errorf("next() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_loadAPIReplayer_errors(t *testing.T) {
+	f, err := ioutil.TempFile("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("not json")
+	f.Close()
+
+	for _, file := range []string{f.Name(), "/nonexistent/recording.json"} {
+		if _, err := loadAPIReplayer(file); err == nil {
+			t.Errorf("loadAPIReplayer() expected error for %s", file)
+		}
+	}
+}
+
+// Test_replay_onDemandReplacement runs the region, group and instance
+// processing against a recording of a group with a single on-demand instance,
+// which is expected to get a spot replacement.
+func Test_replay_onDemandReplacement(t *testing.T) {
+	conf := &Config{
+		MainRegion:     "us-east-1",
+		ReplayAPICalls: "testdata/replay/ondemand_replacement.json",
+		FinalRecap:     make(map[string][]string),
+		InstanceData: &ec2instancesinfo.InstanceData{
+			0: {
+				InstanceType:             "m5.large",
+				VCPU:                     2,
+				Memory:                   8,
+				LinuxVirtualizationTypes: []string{"HVM"},
+				Pricing: map[string]ec2instancesinfo.RegionPrices{
+					"us-east-1": {
+						Linux: ec2instancesinfo.Pricing{OnDemand: 0.096},
+					},
+				},
+			},
+		},
+		AutoScalingConfig: AutoScalingConfig{
+			CronSchedule:            "* *",
+			CronTimezone:            "UTC",
+			CronScheduleState:       "on",
+			OnDemandPriceMultiplier: 1,
+		},
+	}
+
+	if err := conf.setupAPIRecorder(); err != nil {
+		t.Fatalf("setupAPIRecorder() unexpected error: %v", err)
+	}
+
+	r := &region{name: "us-east-1", conf: conf}
+	r.services.connect(r.name, conf)
+	r.setupAsgFilters()
+	r.scanForEnabledAutoScalingGroups()
+
+	if len(r.enabledASGs) != 1 {
+		t.Fatalf("enabled groups = %d, expected 1", len(r.enabledASGs))
+	}
+
+	r.determineInstanceTypeInformation(conf)
+	if got := r.instanceTypeInformation["m5.large"].pricing.spot["us-east-1a"]; got != 0.035 {
+		t.Errorf("spot price = %v, expected 0.035", got)
+	}
+
+	if err := r.scanInstances(); err != nil {
+		t.Fatalf("scanInstances() unexpected error: %v", err)
+	}
+
+	asg := r.enabledASGs[0]
+	asg.config = conf.AutoScalingConfig
+
+	action := asg.cronEventAction()
+	lsr, ok := action.(launchSpotReplacement)
+	if !ok {
+		t.Fatalf("cronEventAction() = %#v, expected launchSpotReplacement", action)
+	}
+
+	if id := aws.StringValue(lsr.target.onDemandInstance.InstanceId); id != "i-0ondemand" {
+		t.Errorf("replacement target = %s, expected i-0ondemand", id)
+	}
+
+	for i, used := range conf.apiRecorder.used {
+		if !used {
+			t.Errorf("recorded call %s wasn't replayed", conf.apiRecorder.calls[i].Operation)
+		}
+	}
+}
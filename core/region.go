@@ -4,10 +4,10 @@
 package autospotting
 
 import (
-	"errors"
 	"fmt"
 	"log"
 	"path/filepath"
+	runtimedebug "runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
@@ -36,11 +36,54 @@ type region struct {
 
 	instances instances
 
-	enabledASGs []autoScalingGroup
-	services    connections
+	// The groups enabled for processing, shared by pointer between the
+	// processing of the groups and of their instances.
+	enabledASGs     []*autoScalingGroup
+	enabledASGsLock sync.RWMutex
+
+	services connections
 
 	tagsToFilterASGsBy []Tag
 
+	// The instance types offered in each Local Zone or Wavelength Zone, lazily
+	// populated when processing instances running in such zones.
+	zoneInstanceTypes     map[string]map[string]bool
+	zoneInstanceTypesLock sync.Mutex
+
+	// The termination protection of the instances, keyed by instance ID and
+	// lazily populated during the run.
+	terminationProtection     map[string]bool
+	terminationProtectionLock sync.Mutex
+
+	// The launch template versions used during the run, keyed by the launch
+	// template ID and version, including the symbolic ones like $Latest.
+	launchTemplateVersions     map[string]*ec2.LaunchTemplateVersion
+	launchTemplateVersionsLock sync.Mutex
+
+	// The AMI IDs of the SSM parameters used as AMI aliases in the launch
+	// templates, keyed by the parameter name and resolved once per run.
+	imageAliases     map[string]string
+	imageAliasesLock sync.Mutex
+
+	// The memoized outcome of the instance type compatibility checks, which
+	// only depend on the current and candidate instance types and on the
+	// configuration of the group.
+	typeCompatibility     map[typeCompatibilityKey]bool
+	typeCompatibilityLock sync.Mutex
+
+	// When the spot prices of the region were last fetched
+	spotPricesFetchedAt time.Time
+
+	// The current spot prices by spot product, lazily fetched for the
+	// instances running platforms such as Windows and for checking the live
+	// spot prices before bidding.
+	spotPriceBook spotPriceBook
+
+	// The platform details of the AMIs used by the instances, keyed by image
+	// ID and lazily described for pricing the instances by their platform.
+	imagesPlatformDetails    map[string]string
+	imagePlatformDetailsLock sync.Mutex
+
 	wg sync.WaitGroup
 }
 
@@ -49,6 +92,13 @@ type prices struct {
 	spot         spotPriceMap
 	ebsSurcharge float64
 	premium      float64
+
+	// The on-demand prices of the platforms with license surcharges, keyed by
+	// the platform name
+	platformOnDemand map[string]float64
+
+	// The spot price history statistics, keyed by availability zone
+	spotStats map[string]spotPriceStats
 }
 
 // The key in this map is the availavility zone
@@ -77,10 +127,54 @@ func (r *region) enabled() bool {
 	return false
 }
 
+// onDemandPriceMultiplier returns the on-demand price multiplier of the
+// region, using the first matching per-region override and falling back to
+// the global value.
+func (r *region) onDemandPriceMultiplier() float64 {
+	overrides := replaceWhitespace(r.conf.RegionalOnDemandPriceMultipliers)
+	if overrides == "" {
+		return r.conf.OnDemandPriceMultiplier
+	}
+
+	for _, override := range strings.Split(overrides, ",") {
+		regionAndValue := strings.SplitN(override, "=", 2)
+		if len(regionAndValue) != 2 {
+			log.Println("Ignoring invalid regional on-demand price multiplier", override)
+			continue
+		}
+
+		match, err := filepath.Match(regionAndValue[0], r.name)
+		if err != nil {
+			log.Println("Ignoring invalid region glob in regional on-demand price multiplier", override)
+			continue
+		}
+		if !match {
+			continue
+		}
+
+		multiplier, err := strconv.ParseFloat(regionAndValue[1], 64)
+		if err != nil || multiplier <= 0 {
+			log.Println("Ignoring invalid regional on-demand price multiplier", override)
+			continue
+		}
+		return multiplier
+	}
+
+	return r.conf.OnDemandPriceMultiplier
+}
+
+// groupDefaultConfig returns the default configuration of the groups from
+// the region, which can be overridden using tags on each group.
+func (r *region) groupDefaultConfig() AutoScalingConfig {
+	cfg := r.conf.AutoScalingConfig
+	cfg.OnDemandPriceMultiplier = r.onDemandPriceMultiplier()
+	return cfg
+}
+
 func (r *region) processRegion() {
 
 	log.Println("Creating connections to the required AWS services in", r.name)
-	r.services.connect(r.name, r.conf.MainRegion)
+	r.services.connect(r.name, r.conf)
 	// only process the regions where we have AutoScaling groups set to be handled
 
 	// setup the filters for asg matching
@@ -95,14 +189,29 @@ func (r *region) processRegion() {
 		log.Println("Scanning full instance information in", r.name)
 		r.determineInstanceTypeInformation(r.conf)
 
-		log.Println("Scanning instances in", r.name)
-		err := r.scanInstances()
-		if err != nil {
-			log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+		if r.conf.StreamingScan {
+			log.Println("Scanning instances and processing enabled AutoScaling groups in", r.name)
+			if err := r.scanAndProcessEnabledAutoScalingGroups(); err != nil {
+				log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+			}
+		} else {
+			log.Println("Scanning instances in", r.name)
+			err := r.scanInstances()
+			if err != nil {
+				log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+			}
+
+			log.Println("Processing enabled AutoScaling groups in", r.name)
+			r.processEnabledAutoScalingGroups()
+		}
+
+		if r.snapshotsEnabled() {
+			r.deleteExpiredSnapshots()
 		}
 
-		log.Println("Processing enabled AutoScaling groups in", r.name)
-		r.processEnabledAutoScalingGroups()
+		if r.conf.executionBudget.allows(r.name+" chaos testing", true) {
+			r.injectChaos()
+		}
 	} else {
 		log.Println(r.name, "has no enabled AutoScaling groups")
 	}
@@ -111,7 +220,7 @@ func (r *region) processRegion() {
 func (r *region) setupAsgFilters() {
 	filters := replaceWhitespace(r.conf.FilterByTags)
 	if len(filters) == 0 {
-		r.tagsToFilterASGsBy = []Tag{{Key: "spot-enabled", Value: "true"}}
+		r.tagsToFilterASGsBy = []Tag{{Key: r.conf.tagKey(spotEnabledTag), Value: "true"}}
 		return
 	}
 
@@ -123,7 +232,7 @@ func (r *region) setupAsgFilters() {
 	}
 
 	if len(r.tagsToFilterASGsBy) == 0 {
-		r.tagsToFilterASGsBy = []Tag{{Key: "spot-enabled", Value: "true"}}
+		r.tagsToFilterASGsBy = []Tag{{Key: r.conf.tagKey(spotEnabledTag), Value: "true"}}
 	}
 }
 
@@ -159,15 +268,7 @@ func (r *region) processDescribeInstancesPage(page *ec2.DescribeInstancesOutput,
 func (r *region) scanInstances() error {
 	svc := r.services.ec2
 	input := &ec2.DescribeInstancesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("instance-state-name"),
-				Values: []*string{
-					aws.String("running"),
-					aws.String("pending"),
-				},
-			},
-		},
+		Filters: []*ec2.Filter{runningOrPendingInstancesFilter()},
 	}
 
 	r.instances = makeInstances()
@@ -211,27 +312,51 @@ func (r *region) scanInstance(instanceID *string) error {
 
 func (r *region) addInstance(inst *ec2.Instance) {
 	r.instances.add(&instance{
-		Instance: inst,
+		Instance: slimInstance(inst),
 		typeInfo: r.instanceTypeInformation[*inst.InstanceType],
 		region:   r,
+		slim:     true,
 	})
 }
 
+// determineInstanceTypeInformation loads the instance type information and
+// prices of the region, which are shared with the other steps of the run
+// through the region data cache.
 func (r *region) determineInstanceTypeInformation(cfg *Config) {
+	if cfg.regionData != nil {
+		cfg.regionData.load(r, func() { r.fetchInstanceTypeInformation(cfg) })
+		return
+	}
+	r.fetchInstanceTypeInformation(cfg)
+}
+
+func (r *region) fetchInstanceTypeInformation(cfg *Config) {
 
 	r.instanceTypeInformation = make(map[string]instanceTypeInformation)
 
 	var info instanceTypeInformation
 
+	sources := cfg.priceSources
+	if sources == nil {
+		sources = priceSources{newStaticPriceSource(cfg.InstanceData)}
+	}
+
 	for _, it := range *cfg.InstanceData {
 
 		var price prices
 
 		// populate on-demand information
-		price.onDemand = it.Pricing[r.name].Linux.OnDemand * cfg.OnDemandPriceMultiplier
+		price.onDemand = sources.onDemandPrice(r.name, it.InstanceType, "") * r.onDemandPriceMultiplier()
 		price.spot = make(spotPriceMap)
+		price.spotStats = make(map[string]spotPriceStats)
 		price.ebsSurcharge = it.Pricing[r.name].EBSSurcharge
 		price.premium = r.conf.SpotProductPremium
+		price.platformOnDemand = make(map[string]float64)
+		for name := range platforms {
+			if onDemand := sources.onDemandPrice(r.name, it.InstanceType, name); onDemand > 0 {
+				price.platformOnDemand[name] = onDemand * r.onDemandPriceMultiplier()
+			}
+		}
 
 		// if at this point the instance price is still zero, then that
 		// particular instance type doesn't even exist in the current
@@ -256,10 +381,13 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 				info.instanceStoreDeviceSize = it.Storage.Size
 				info.instanceStoreDeviceCount = it.Storage.Devices
 				info.instanceStoreIsSSD = it.Storage.SSD
+				info.instanceStoreIsNVMe = it.Storage.NVMeSSD
 			}
 			r.instanceTypeInformation[it.InstanceType] = info
 		}
 	}
+
+	r.describeInstanceTypes()
 	// this is safe to do once outside of the loop because the call will only
 	// return entries about the available instance types, so no invalid instance
 	// types would be returned
@@ -274,28 +402,30 @@ func (r *region) requestSpotPrices() error {
 
 	s := spotPrices{conn: r.services}
 
-	// Retrieve all current spot prices from the current region.
+	// Retrieve all current spot prices from the current region, as well as
+	// their history when it's used for ranking the candidates.
 	// TODO: add support for other OSes
-	err := s.fetch(r.conf.SpotProductDescription, 0, nil, nil)
+	duration := time.Duration(r.conf.SpotPriceHistoryDays) * 24 * time.Hour
+	end := time.Now()
+
+	err := s.fetch(r.conf.SpotProductDescription, duration, nil, nil)
 
 	if err != nil {
-		return errors.New("Couldn't fetch spot prices in " + r.name)
+		return fmt.Errorf("couldn't fetch spot prices in %s: %w", r.name, err)
 	}
 
 	// log.Println("Spot Price list in ", r.name, ":\n", s.data)
 
-	for _, priceInfo := range s.data {
+	r.spotPricesFetchedAt = r.conf.getClock().Now()
 
-		instType, az := *priceInfo.InstanceType, *priceInfo.AvailabilityZone
+	latest, stats := summarizeSpotPriceHistory(s.data, end.Add(-duration), end)
 
-		// failure to parse this means that the instance is not available on the
-		// spot market
-		price, err := strconv.ParseFloat(*priceInfo.SpotPrice, 64)
-		if err != nil {
-			debug.Println(r.name, "Instance type ", instType,
-				"is not available on the spot market")
-			continue
-		}
+	// the live spot prices are only queried again after the refresh interval
+	r.spotPriceBook.set(r.conf.SpotProductDescription, latest, r.spotPricesFetchedAt)
+
+	for key, price := range latest {
+
+		instType, az := key.instanceType, key.availabilityZone
 
 		if r.instanceTypeInformation[instType].pricing.spot == nil {
 			debug.Println(r.name, "Instance data missing for", instType, "in", az,
@@ -305,6 +435,9 @@ func (r *region) requestSpotPrices() error {
 
 		r.instanceTypeInformation[instType].pricing.spot[az] = price
 
+		if duration > 0 {
+			r.instanceTypeInformation[instType].pricing.spotStats[az] = stats[key]
+		}
 	}
 
 	return nil
@@ -378,9 +511,9 @@ func (r *region) isStackUpdating(stackName *string) (string, bool) {
 }
 
 func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
-	tagsToMatch []Tag) []autoScalingGroup {
+	tagsToMatch []Tag) []*autoScalingGroup {
 
-	var asgs []autoScalingGroup
+	var asgs []*autoScalingGroup
 	var optInFilterMode = (r.conf.TagFilteringMode != "opt-out")
 
 	tagCloudFormationStackName := Tag{Key: "aws:cloudformation:stack-name", Value: "*"}
@@ -389,9 +522,12 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 		asgName := *group.AutoScalingGroupName
 
 		if group.MixedInstancesPolicy != nil {
-			debug.Printf("Skipping group %s because it's using a mixed instances policy",
-				asgName)
-			continue
+			if !isOnDemandMixedInstancesPolicy(group.MixedInstancesPolicy) {
+				debug.Printf("Skipping group %s because it's using a mixed instances policy "+
+					"which isn't launching only on-demand instances from a launch template", asgName)
+				continue
+			}
+			useMixedInstancesPolicyLaunchTemplate(group)
 		}
 
 		groupMatchesExpectedTags := isASGWithMatchingTags(group, tagsToMatch)
@@ -417,7 +553,7 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 		log.Printf("Enabling group %s for processing because its tags, the "+
 			"currently configured  filtering mode (%s) and tag filters are aligned\n",
 			asgName, r.conf.TagFilteringMode)
-		asgs = append(asgs, autoScalingGroup{
+		asgs = append(asgs, &autoScalingGroup{
 			Group:  group,
 			name:   asgName,
 			region: r,
@@ -437,7 +573,7 @@ func (r *region) scanForEnabledAutoScalingGroups() {
 			pageNum++
 			debug.Println("Processing page", pageNum, "of DescribeAutoScalingGroupsPages for", r.name)
 			matchingAsgs := r.findMatchingASGsInPageOfResults(page.AutoScalingGroups, r.tagsToFilterASGsBy)
-			r.enabledASGs = append(r.enabledASGs, matchingAsgs...)
+			r.addEnabledASGs(matchingAsgs...)
 			return true
 		},
 	)
@@ -448,37 +584,151 @@ func (r *region) scanForEnabledAutoScalingGroups() {
 
 }
 
+// addEnabledASGs adds groups to the ones enabled for processing.
+func (r *region) addEnabledASGs(asgs ...*autoScalingGroup) {
+	r.enabledASGsLock.Lock()
+	defer r.enabledASGsLock.Unlock()
+	r.enabledASGs = append(r.enabledASGs, asgs...)
+}
+
+// enabledASGList returns a snapshot of the groups enabled for processing,
+// safe to iterate over while more groups are being added.
+func (r *region) enabledASGList() []*autoScalingGroup {
+	r.enabledASGsLock.RLock()
+	defer r.enabledASGsLock.RUnlock()
+	return append([]*autoScalingGroup(nil), r.enabledASGs...)
+}
+
 func (r *region) hasEnabledAutoScalingGroups() bool {
+	r.enabledASGsLock.RLock()
+	defer r.enabledASGsLock.RUnlock()
 
 	return len(r.enabledASGs) > 0
 
 }
 
 func (r *region) processEnabledAutoScalingGroups() {
-	for _, asg := range r.enabledASGs {
+	for _, asg := range r.prioritizedASGList() {
+		r.processEnabledAutoScalingGroup(asg)
+	}
+	r.wg.Wait()
+}
+
+// processEnabledAutoScalingGroup starts processing the group in the
+// background, the caller is expected to wait for it using r.wg.
+func (r *region) processEnabledAutoScalingGroup(asg *autoScalingGroup) {
+	if !r.conf.executionBudget.allows(r.name+" "+asg.name, false) {
+		log.Println(r.name, asg.name, "Execution budget exhausted, skipping group")
+		return
+	}
 
-		// Pass default configs to the group
-		asg.config = r.conf.AutoScalingConfig
+	// Pass default configs to the group
+	asg.config = r.groupDefaultConfig()
 
-		r.wg.Add(1)
-		go func(a autoScalingGroup) {
-			action := a.cronEventAction()
-			action.run()
-			r.wg.Done()
-		}(asg)
+	r.wg.Add(1)
+	go func(a *autoScalingGroup) {
+		defer r.wg.Done()
+		defer r.recoverFromGroupPanic(a)
+		r.runAction(a, a.cronEventAction())
+		a.saveSkipReasons()
+	}(asg)
+}
+
+// recoverFromGroupPanic is deferred while processing each group, so that a
+// panic caused by a single misconfigured group is reported and counted as a
+// failed action instead of aborting the processing of all the other groups.
+func (r *region) recoverFromGroupPanic(a *autoScalingGroup) {
+	p := recover()
+	if p == nil {
+		return
 	}
-	r.wg.Wait()
+
+	log.Printf("%s %s Recovered from panic while processing the group: %v\n%s",
+		r.name, a.name, p, runtimedebug.Stack())
+
+	recapText := fmt.Sprintf("%s Failed processing the group [panic: %v]", a.name, p)
+	r.conf.addRecap(r.name, recapText)
+
+	r.conf.errorBudget.record(fmt.Errorf("panic: %v", p))
+}
+
+// actionsBlocked tells why the current run can't change the groups anymore:
+// the error budget or the API call budget was exhausted, or the daemon
+// replica lost its leadership.
+func (r *region) actionsBlocked() (string, bool) {
+	switch {
+	case r.conf.errorBudget.isExhausted():
+		return "error budget exhausted", true
+	case r.conf.apiCalls.isExhausted():
+		return "API call budget exhausted", true
+	case r.conf.leadershipLost():
+		return "leadership lost", true
+	}
+	return "", false
+}
+
+// runAction executes the action determined for a group, unless the error
+// budget or the API call budget of the current run was exhausted, or the
+// daemon replica lost its leadership, in which case the action is only
+// reported.
+func (r *region) runAction(a *autoScalingGroup, action runer) {
+	if _, skip := action.(skipRun); skip {
+		return
+	}
+
+	if reason, blocked := r.actionsBlocked(); blocked {
+		log.Printf("%s %s Not executing action %T: %s", r.name, a.name, action, reason)
+		recapText := fmt.Sprintf("%s Skipped action %T [%s]", a.name, action, reason)
+		r.conf.addRecap(r.name, recapText)
+		return
+	}
+
+	err := action.run()
+	if handlingOf(err) == alertError {
+		log.Printf("%s %s Action %T failed and needs attention: %s", r.name, a.name, action, err.Error())
+		recapText := fmt.Sprintf("%s Failed action %T [needs attention: %s]", a.name, action, err.Error())
+		r.conf.addRecap(r.name, recapText)
+	}
+
+	r.conf.errorBudget.record(err)
 }
 
 func (r *region) findEnabledASGByName(name string) *autoScalingGroup {
+	r.enabledASGsLock.RLock()
+	defer r.enabledASGsLock.RUnlock()
+
 	for _, asg := range r.enabledASGs {
 		if asg.name == name {
-			return &asg
+			return asg
 		}
 	}
 	return nil
 }
 
+// loadEnabledASG returns the enabled group with the given name, scanning its
+// instances and loading its configuration the first time it's needed, so
+// that it can be bound to multiple instances processed concurrently.
+func (r *region) loadEnabledASG(name string) *autoScalingGroup {
+	asg := r.findEnabledASGByName(name)
+	if asg == nil {
+		return nil
+	}
+
+	asg.loadLock.Lock()
+	defer asg.loadLock.Unlock()
+
+	if !asg.loaded {
+		asg.config = r.groupDefaultConfig()
+		asg.scanInstances()
+		asg.loadDefaultConfig()
+		asg.loadConfigFromTags()
+		asg.loadLaunchConfiguration()
+		asg.loadLaunchTemplate()
+		asg.loaded = true
+	}
+	return asg
+}
+
 func (r *region) sqsSendMessageOnInstanceLaunch(asgName, instanceID, instanceState *string, instanceLifecycle string) error {
 	inputJSON := "{\"version\":\"0\",\"id\":\"890abcde-f123-4567-890a-bcdef1234567\"," +
 		"\"detail-type\":\"EC2 Instance State-change Notification\",\"source\":\"aws.events\"," +
@@ -532,7 +782,7 @@ func (r *region) sqsDeleteMessage(instanceID *string, instanceLifecycle string)
 
 func (r *region) calculateSavings() float64 {
 	savings := 0.0
-	r.services.connect(r.name, r.conf.MainRegion)
+	r.services.connect(r.name, r.conf)
 
 	log.Println("Scanning full instance information in", r.name)
 	r.determineInstanceTypeInformation(r.conf)
@@ -545,7 +795,7 @@ func (r *region) calculateSavings() float64 {
 
 	log.Println("Calculating AutoSpotting savings in", r.name)
 
-	for inst := range r.instances.instances() {
+	for _, inst := range r.instances.instances() {
 
 		if inst.isSpot() && inst.isLaunchedByAutoSpotting() {
 			is := inst.getSavings()
@@ -554,6 +804,6 @@ func (r *region) calculateSavings() float64 {
 			savings += is
 		}
 	}
-	log.Printf("Total savings in %s: %f\n", r.name, savings)
+	log.Printf("Total savings in %s: %s\n", r.name, r.conf.reportingCurrency().format(savings, 4))
 	return savings
 }
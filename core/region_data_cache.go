@@ -0,0 +1,466 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// DefaultRegionDataCacheTTL is how long the data of the regions is reused by
+// the next runs.
+const DefaultRegionDataCacheTTL = 30 * time.Minute
+
+// regionDataCache shares the instance type information and prices of the
+// regions, including their spot prices, across the steps of a run which need
+// them, such as calculating the savings and processing the groups. It's kept
+// in memory for the next runs of the same process, such as the invocations of
+// a warm Lambda function, and optionally persisted to S3 or to a local
+// directory for the other processes, being reused within its TTL.
+type regionDataCache struct {
+	sync.Mutex
+	entries map[string]*regionDataEntry
+
+	// run is incremented at the start of each run
+	run uint64
+
+	// the persistent store of the cache, nil when it's only kept in memory
+	store regionDataStore
+	ttl   time.Duration
+
+	// fingerprint identifies the configuration the data depends on, the
+	// persisted data of a different configuration being ignored
+	fingerprint string
+}
+
+// regionDataEntry is the data of a region, loaded once per run.
+type regionDataEntry struct {
+	sync.Mutex
+	loaded              bool
+	run                 uint64
+	instanceTypes       map[string]instanceTypeInformation
+	spotPricesFetchedAt time.Time
+}
+
+func newRegionDataCache(conf *Config) *regionDataCache {
+	c := &regionDataCache{
+		entries:     make(map[string]*regionDataEntry),
+		ttl:         conf.RegionDataCacheTTL,
+		fingerprint: regionDataFingerprint(conf),
+	}
+
+	if conf.RegionDataCache == "" {
+		return c
+	}
+
+	store, err := newRegionDataStore(conf)
+	if err != nil {
+		log.Println("Not persisting the region data:", err.Error())
+		return c
+	}
+	c.store = store
+	return c
+}
+
+// regionDataFingerprint summarizes the configuration the prices depend on.
+func regionDataFingerprint(conf *Config) string {
+	return fmt.Sprintf("%s|%s|%d|%v|%s|%v|%s|%t",
+		conf.Version, conf.SpotProductDescription, conf.SpotPriceHistoryDays,
+		conf.OnDemandPriceMultiplier, conf.RegionalOnDemandPriceMultipliers,
+		conf.SpotProductPremium, conf.PriceOverrideFile, conf.PricingAPI)
+}
+
+// startRun starts a new run, which reuses the data loaded by the previous
+// runs only while within the TTL.
+func (c *regionDataCache) startRun() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	c.run++
+	c.Unlock()
+}
+
+func (c *regionDataCache) entry(region string) (*regionDataEntry, uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, found := c.entries[region]
+	if !found {
+		e = &regionDataEntry{}
+		c.entries[region] = e
+	}
+	return e, c.run
+}
+
+// load sets the instance type information and prices of the region from the
+// cache, calling fetch for populating them on the region when missing or
+// stale. The concurrent loads of the same region wait for a single fetch.
+func (c *regionDataCache) load(r *region, fetch func()) {
+	e, run := c.entry(r.name)
+
+	e.Lock()
+	defer e.Unlock()
+
+	now := r.conf.getClock().Now()
+	ttl := c.effectiveTTL(r.conf.SpotPriceTTL)
+
+	switch {
+	case e.loaded && e.run == run:
+	case e.loaded && isFresh(e.spotPricesFetchedAt, now, ttl):
+		log.Println(r.name, "Reusing the region data fetched at", e.spotPricesFetchedAt.Format(time.RFC3339))
+	default:
+		if !c.loadPersisted(r.name, e, now, ttl) {
+			fetch()
+			e.instanceTypes, e.spotPricesFetchedAt = r.instanceTypeInformation, r.spotPricesFetchedAt
+			// the data is incomplete when the spot prices couldn't be fetched
+			if !e.spotPricesFetchedAt.IsZero() {
+				c.persist(r.name, e)
+			}
+		}
+		e.loaded = true
+	}
+	e.run = run
+
+	r.instanceTypeInformation, r.spotPricesFetchedAt = e.instanceTypes, e.spotPricesFetchedAt
+}
+
+// effectiveTTL is the TTL of the cached data, which is bounded by the maximum
+// age of the spot prices used for bidding.
+func (c *regionDataCache) effectiveTTL(spotPriceTTL time.Duration) time.Duration {
+	if spotPriceTTL > 0 && spotPriceTTL < c.ttl {
+		return spotPriceTTL
+	}
+	return c.ttl
+}
+
+// isFresh tells if the data fetched at the given time can still be reused.
+func isFresh(fetchedAt, now time.Time, ttl time.Duration) bool {
+	return ttl > 0 && !fetchedAt.IsZero() && now.Sub(fetchedAt) <= ttl
+}
+
+// loadPersisted loads the data of the region from the persistent store, if it
+// was persisted for the same configuration within the TTL.
+func (c *regionDataCache) loadPersisted(region string, e *regionDataEntry, now time.Time, ttl time.Duration) bool {
+	if c.store == nil || ttl <= 0 {
+		return false
+	}
+
+	body, err := c.store.get(region)
+	if err != nil {
+		if err != errRegionDataNotFound {
+			log.Println(region, "Couldn't load the cached region data:", err.Error())
+		}
+		return false
+	}
+
+	var data persistedRegionData
+	if err := json.Unmarshal(body, &data); err != nil {
+		log.Println(region, "Ignoring the invalid cached region data:", err.Error())
+		return false
+	}
+
+	if data.Fingerprint != c.fingerprint {
+		debug.Println(region, "Ignoring the region data cached for a different configuration")
+		return false
+	}
+
+	if !isFresh(data.SpotPricesFetchedAt, now, ttl) {
+		debug.Println(region, "Ignoring the region data cached", now.Sub(data.SpotPricesFetchedAt).Round(time.Second), "ago")
+		return false
+	}
+
+	e.instanceTypes = make(map[string]instanceTypeInformation, len(data.InstanceTypes))
+	for name, it := range data.InstanceTypes {
+		e.instanceTypes[name] = it.instanceTypeInformation()
+	}
+	e.spotPricesFetchedAt = data.SpotPricesFetchedAt
+
+	log.Println(region, "Loaded the region data cached in", c.store, "at", data.SpotPricesFetchedAt.Format(time.RFC3339))
+	return true
+}
+
+// persist saves the data of the region to the persistent store.
+func (c *regionDataCache) persist(region string, e *regionDataEntry) {
+	if c.store == nil || c.ttl <= 0 {
+		return
+	}
+
+	data := persistedRegionData{
+		Fingerprint:         c.fingerprint,
+		SpotPricesFetchedAt: e.spotPricesFetchedAt,
+		InstanceTypes:       make(map[string]persistedInstanceType, len(e.instanceTypes)),
+	}
+	for name, info := range e.instanceTypes {
+		data.InstanceTypes[name] = newPersistedInstanceType(info)
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		log.Println(region, "Couldn't encode the region data:", err.Error())
+		return
+	}
+
+	if err := c.store.put(region, body); err != nil {
+		log.Println(region, "Couldn't persist the region data:", err.Error())
+	}
+}
+
+// errRegionDataNotFound is returned by the stores for the regions whose data
+// wasn't persisted yet.
+var errRegionDataNotFound = errors.New("region data not found")
+
+// regionDataStore persists the data of the regions between the runs.
+type regionDataStore interface {
+	get(region string) ([]byte, error)
+	put(region string, data []byte) error
+	String() string
+}
+
+// newRegionDataStore returns the store of the RegionDataCache location, which
+// is either an S3 URL prefix or a local directory.
+func newRegionDataStore(conf *Config) (regionDataStore, error) {
+	if !strings.HasPrefix(conf.RegionDataCache, "s3://") {
+		dir := filepath.Clean(conf.RegionDataCache)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		return dirRegionDataStore{dir: dir}, nil
+	}
+
+	bucket, prefix, err := parseS3URL(strings.TrimSuffix(conf.RegionDataCache, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := newSession(conf.MainRegion, conf)
+	if err != nil {
+		panic(err)
+	}
+	return s3RegionDataStore{
+		svc:    s3.New(sess, conf.serviceConfig(s3.EndpointsID, conf.MainRegion)),
+		bucket: bucket,
+		prefix: prefix + "/",
+	}, nil
+}
+
+// s3RegionDataStore persists the data of each region as a JSON object under
+// an S3 prefix.
+type s3RegionDataStore struct {
+	svc    s3iface.S3API
+	bucket string
+	prefix string
+}
+
+func (s s3RegionDataStore) key(region string) string {
+	return s.prefix + region + ".json"
+}
+
+func (s s3RegionDataStore) get(region string) ([]byte, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(region)),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, errRegionDataNotFound
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (s s3RegionDataStore) put(region string, data []byte) error {
+	_, err := s.svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(region)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (s s3RegionDataStore) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+// dirRegionDataStore persists the data of each region as a JSON file in a
+// local directory, such as /tmp on Lambda.
+type dirRegionDataStore struct {
+	dir string
+}
+
+func (d dirRegionDataStore) path(region string) string {
+	return filepath.Join(d.dir, region+".json")
+}
+
+func (d dirRegionDataStore) get(region string) ([]byte, error) {
+	data, err := ioutil.ReadFile(d.path(region))
+	if os.IsNotExist(err) {
+		return nil, errRegionDataNotFound
+	}
+	return data, err
+}
+
+// put writes the file atomically, for the concurrent runs sharing the
+// directory to never read it partially written.
+func (d dirRegionDataStore) put(region string, data []byte) error {
+	f, err := ioutil.TempFile(d.dir, region+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), d.path(region))
+}
+
+func (d dirRegionDataStore) String() string {
+	return d.dir
+}
+
+// persistedRegionData is the data of a region persisted in S3.
+type persistedRegionData struct {
+	Fingerprint         string
+	SpotPricesFetchedAt time.Time
+	InstanceTypes       map[string]persistedInstanceType
+}
+
+// persistedInstanceType is the serializable form of the information and
+// prices of an instance type.
+type persistedInstanceType struct {
+	InstanceType             string
+	VCPU                     int
+	Cores                    int
+	PhysicalProcessor        string
+	GPU                      int
+	Memory                   float32
+	VirtualizationTypes      []string
+	HasInstanceStore         bool
+	InstanceStoreDeviceSize  float32
+	InstanceStoreDeviceCount int
+	InstanceStoreIsSSD       bool
+	InstanceStoreIsNVMe      bool
+	HasEBSOptimization       bool
+	EBSThroughput            float32
+	EBSBaselineThroughput    float64
+	EBSBaselineIOPS          int64
+
+	AcceleratorManufacturer string
+	AcceleratorModel        string
+	AcceleratorCount        int
+	AcceleratorMemory       int64
+
+	OnDemand         float64
+	Spot             map[string]float64
+	EBSSurcharge     float64
+	Premium          float64
+	PlatformOnDemand map[string]float64
+	SpotAverage      map[string]float64
+	SpotVolatility   map[string]float64
+}
+
+func newPersistedInstanceType(info instanceTypeInformation) persistedInstanceType {
+	p := persistedInstanceType{
+		InstanceType:             info.instanceType,
+		VCPU:                     info.vCPU,
+		Cores:                    info.cores,
+		PhysicalProcessor:        info.PhysicalProcessor,
+		GPU:                      info.GPU,
+		Memory:                   info.memory,
+		VirtualizationTypes:      info.virtualizationTypes,
+		HasInstanceStore:         info.hasInstanceStore,
+		InstanceStoreDeviceSize:  info.instanceStoreDeviceSize,
+		InstanceStoreDeviceCount: info.instanceStoreDeviceCount,
+		InstanceStoreIsSSD:       info.instanceStoreIsSSD,
+		InstanceStoreIsNVMe:      info.instanceStoreIsNVMe,
+		HasEBSOptimization:       info.hasEBSOptimization,
+		EBSThroughput:            info.EBSThroughput,
+		EBSBaselineThroughput:    info.ebsBaselineThroughput,
+		EBSBaselineIOPS:          info.ebsBaselineIOPS,
+		AcceleratorManufacturer:  info.accelerator.manufacturer,
+		AcceleratorModel:         info.accelerator.model,
+		AcceleratorCount:         info.accelerator.count,
+		AcceleratorMemory:        info.accelerator.memory,
+		OnDemand:                 info.pricing.onDemand,
+		Spot:                     info.pricing.spot,
+		EBSSurcharge:             info.pricing.ebsSurcharge,
+		Premium:                  info.pricing.premium,
+		PlatformOnDemand:         info.pricing.platformOnDemand,
+		SpotAverage:              make(map[string]float64, len(info.pricing.spotStats)),
+		SpotVolatility:           make(map[string]float64, len(info.pricing.spotStats)),
+	}
+	for az, stats := range info.pricing.spotStats {
+		p.SpotAverage[az] = stats.average
+		p.SpotVolatility[az] = stats.volatility
+	}
+	return p
+}
+
+func (p persistedInstanceType) instanceTypeInformation() instanceTypeInformation {
+	info := instanceTypeInformation{
+		instanceType:             p.InstanceType,
+		vCPU:                     p.VCPU,
+		cores:                    p.Cores,
+		PhysicalProcessor:        p.PhysicalProcessor,
+		GPU:                      p.GPU,
+		memory:                   p.Memory,
+		virtualizationTypes:      p.VirtualizationTypes,
+		hasInstanceStore:         p.HasInstanceStore,
+		instanceStoreDeviceSize:  p.InstanceStoreDeviceSize,
+		instanceStoreDeviceCount: p.InstanceStoreDeviceCount,
+		instanceStoreIsSSD:       p.InstanceStoreIsSSD,
+		instanceStoreIsNVMe:      p.InstanceStoreIsNVMe,
+		hasEBSOptimization:       p.HasEBSOptimization,
+		EBSThroughput:            p.EBSThroughput,
+		ebsBaselineThroughput:    p.EBSBaselineThroughput,
+		ebsBaselineIOPS:          p.EBSBaselineIOPS,
+		accelerator: acceleratorInformation{
+			manufacturer: p.AcceleratorManufacturer,
+			model:        p.AcceleratorModel,
+			count:        p.AcceleratorCount,
+			memory:       p.AcceleratorMemory,
+		},
+		pricing: prices{
+			onDemand:         p.OnDemand,
+			spot:             spotPriceMap(p.Spot),
+			ebsSurcharge:     p.EBSSurcharge,
+			premium:          p.Premium,
+			platformOnDemand: p.PlatformOnDemand,
+			spotStats:        make(map[string]spotPriceStats, len(p.SpotAverage)),
+		},
+	}
+	if info.pricing.spot == nil {
+		info.pricing.spot = make(spotPriceMap)
+	}
+	if info.pricing.platformOnDemand == nil {
+		info.pricing.platformOnDemand = make(map[string]float64)
+	}
+	for az, average := range p.SpotAverage {
+		info.pricing.spotStats[az] = spotPriceStats{average: average, volatility: p.SpotVolatility[az]}
+	}
+	return info
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func testRegionData() map[string]instanceTypeInformation {
+	return map[string]instanceTypeInformation{
+		"m5.large": {
+			instanceType:        "m5.large",
+			vCPU:                2,
+			memory:              8,
+			virtualizationTypes: []string{"hvm"},
+			hasEBSOptimization:  true,
+			accelerator:         acceleratorInformation{manufacturer: "nvidia", model: "t4", count: 1, memory: 16384},
+			pricing: prices{
+				onDemand:         0.096,
+				spot:             spotPriceMap{"us-east-1a": 0.035},
+				premium:          0.01,
+				platformOnDemand: map[string]float64{"windows": 0.188},
+				spotStats:        map[string]spotPriceStats{"us-east-1a": {average: 0.04, volatility: 0.002}},
+			},
+		},
+	}
+}
+
+func Test_regionDataCache_load(t *testing.T) {
+	clock := &mockClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	conf := &Config{RegionDataCacheTTL: 30 * time.Minute, clock: clock}
+	c := newRegionDataCache(conf)
+
+	var fetchesLock sync.Mutex
+	fetches := make(map[string]int)
+
+	load := func(name string) *region {
+		r := &region{name: name, conf: conf}
+		c.load(r, func() {
+			fetchesLock.Lock()
+			fetches[name]++
+			fetchesLock.Unlock()
+			r.instanceTypeInformation = testRegionData()
+			r.spotPricesFetchedAt = clock.Now()
+		})
+		return r
+	}
+
+	c.startRun()
+	fetchedAt := clock.Now()
+
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		for _, name := range []string{"us-east-1", "eu-west-1"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				r := load(name)
+				if !reflect.DeepEqual(r.instanceTypeInformation, testRegionData()) || !r.spotPricesFetchedAt.Equal(fetchedAt) {
+					t.Errorf("load() set %v fetched at %v", r.instanceTypeInformation, r.spotPricesFetchedAt)
+				}
+			}(name)
+		}
+	}
+	wg.Wait()
+
+	if fetches["us-east-1"] != 1 || fetches["eu-west-1"] != 1 {
+		t.Errorf("load() fetched %v, expected once per region", fetches)
+	}
+
+	tests := []struct {
+		name            string
+		elapsed         time.Duration
+		ttl             time.Duration
+		expectedFetches int
+	}{
+		{name: "next run within the TTL", elapsed: 10 * time.Minute, ttl: 30 * time.Minute, expectedFetches: 1},
+		{name: "next run after the TTL", elapsed: 25 * time.Minute, ttl: 30 * time.Minute, expectedFetches: 2},
+		{name: "next run without TTL", elapsed: time.Minute, expectedFetches: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.now = clock.now.Add(tt.elapsed)
+			c.ttl = tt.ttl
+
+			c.startRun()
+			load("us-east-1")
+			load("us-east-1")
+
+			if fetches["us-east-1"] != tt.expectedFetches {
+				t.Errorf("load() fetched %d times, expected %d", fetches["us-east-1"], tt.expectedFetches)
+			}
+		})
+	}
+}
+
+func Test_regionDataCache_persisted(t *testing.T) {
+	conf := &Config{Version: "1.0", clock: &mockClock{now: time.Date(2021, 1, 1, 0, 10, 0, 0, time.UTC)}}
+	fetchedAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	bodies := make(map[string][]byte)
+	writer := &regionDataCache{
+		entries:     make(map[string]*regionDataEntry),
+		store:       s3RegionDataStore{svc: mockS3{poBodies: bodies}, bucket: "bucket", prefix: "cache/"},
+		ttl:         DefaultRegionDataCacheTTL,
+		fingerprint: regionDataFingerprint(conf),
+	}
+	writer.load(&region{name: "us-east-1", conf: conf}, func() {})
+	if len(bodies) != 0 {
+		t.Fatalf("load() persisted %v, expected nothing without fetched data", bodies)
+	}
+
+	writer.startRun()
+	r := &region{name: "us-east-1", conf: conf}
+	writer.load(r, func() {
+		r.instanceTypeInformation = testRegionData()
+		r.spotPricesFetchedAt = fetchedAt
+	})
+	body, found := bodies["cache/us-east-1.json"]
+	if !found {
+		t.Fatalf("load() persisted %v, expected cache/us-east-1.json", bodies)
+	}
+
+	reader := &regionDataCache{
+		entries:     make(map[string]*regionDataEntry),
+		store:       s3RegionDataStore{svc: mockS3{goContent: string(body)}, bucket: "bucket", prefix: "cache/"},
+		ttl:         DefaultRegionDataCacheTTL,
+		fingerprint: regionDataFingerprint(conf),
+	}
+	r = &region{name: "us-east-1", conf: conf}
+	reader.load(r, func() { t.Errorf("load() fetched the persisted region data") })
+
+	if !reflect.DeepEqual(r.instanceTypeInformation, testRegionData()) {
+		t.Errorf("load() = %+v, expected %+v", r.instanceTypeInformation, testRegionData())
+	}
+	if !r.spotPricesFetchedAt.Equal(fetchedAt) {
+		t.Errorf("load() spot prices fetched at %v, expected %v", r.spotPricesFetchedAt, fetchedAt)
+	}
+}
+
+func Test_regionDataCache_loadPersisted(t *testing.T) {
+	now := time.Date(2021, 1, 1, 1, 0, 0, 0, time.UTC)
+
+	persisted := func(fingerprint string, age time.Duration) string {
+		data, _ := json.Marshal(persistedRegionData{
+			Fingerprint:         fingerprint,
+			SpotPricesFetchedAt: now.Add(-age),
+			InstanceTypes: map[string]persistedInstanceType{
+				"m5.large": newPersistedInstanceType(testRegionData()["m5.large"]),
+			},
+		})
+		return string(data)
+	}
+
+	tests := []struct {
+		name     string
+		store    mockS3
+		noStore  bool
+		ttl      time.Duration
+		expected bool
+	}{
+		{
+			name:     "within the TTL",
+			store:    mockS3{goContent: persisted("v1", 10*time.Minute)},
+			ttl:      30 * time.Minute,
+			expected: true,
+		},
+		{
+			name:  "expired",
+			store: mockS3{goContent: persisted("v1", 40*time.Minute)},
+			ttl:   30 * time.Minute,
+		},
+		{
+			name:  "different configuration",
+			store: mockS3{goContent: persisted("v2", 10*time.Minute)},
+			ttl:   30 * time.Minute,
+		},
+		{
+			name:  "not persisted yet",
+			store: mockS3{goerr: awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)},
+			ttl:   30 * time.Minute,
+		},
+		{
+			name:  "invalid data",
+			store: mockS3{goContent: "{"},
+			ttl:   30 * time.Minute,
+		},
+		{
+			name:  "disabled TTL",
+			store: mockS3{goContent: persisted("v1", 10*time.Minute)},
+		},
+		{
+			name:    "kept in memory",
+			noStore: true,
+			ttl:     30 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &regionDataCache{ttl: tt.ttl, fingerprint: "v1"}
+			if !tt.noStore {
+				c.store = s3RegionDataStore{svc: tt.store, bucket: "bucket"}
+			}
+
+			e := &regionDataEntry{}
+			if got := c.loadPersisted("us-east-1", e, now, tt.ttl); got != tt.expected {
+				t.Errorf("loadPersisted() = %v, expected %v", got, tt.expected)
+			}
+			if tt.expected && !reflect.DeepEqual(e.instanceTypes, testRegionData()) {
+				t.Errorf("loadPersisted() loaded %+v, expected %+v", e.instanceTypes, testRegionData())
+			}
+		})
+	}
+}
+
+func Test_regionDataCache_effectiveTTL(t *testing.T) {
+	tests := []struct {
+		name         string
+		ttl          time.Duration
+		spotPriceTTL time.Duration
+		expected     time.Duration
+	}{
+		{name: "no spot price TTL", ttl: 30 * time.Minute, expected: 30 * time.Minute},
+		{name: "longer spot price TTL", ttl: 30 * time.Minute, spotPriceTTL: time.Hour, expected: 30 * time.Minute},
+		{name: "shorter spot price TTL", ttl: 30 * time.Minute, spotPriceTTL: 10 * time.Minute, expected: 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &regionDataCache{ttl: tt.ttl}
+			if got := c.effectiveTTL(tt.spotPriceTTL); got != tt.expected {
+				t.Errorf("effectiveTTL() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_dirRegionDataStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &Config{RegionDataCache: filepath.Join(dir, "cache")}
+	store, err := newRegionDataStore(conf)
+	if err != nil {
+		t.Fatalf("newRegionDataStore() error = %v", err)
+	}
+
+	if _, err := store.get("us-east-1"); err != errRegionDataNotFound {
+		t.Errorf("get() error = %v, expected %v", err, errRegionDataNotFound)
+	}
+
+	for _, data := range []string{"first", "second"} {
+		if err := store.put("us-east-1", []byte(data)); err != nil {
+			t.Fatalf("put() error = %v", err)
+		}
+		got, err := store.get("us-east-1")
+		if err != nil || string(got) != data {
+			t.Errorf("get() = %q, %v, expected %q", got, err, data)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "cache", "*"))
+	if len(files) != 1 {
+		t.Errorf("put() left the files %v, expected only the region file", files)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The features which aren't available in all the regions and zones.
+const (
+	// io2VolumeFeature is the support of the io2 EBS volume type
+	io2VolumeFeature = "io2"
+
+	// gp3VolumeFeature is the support of the gp3 EBS volume type
+	gp3VolumeFeature = "gp3"
+)
+
+// knownUnavailableFeatures lists the regions where the features are known
+// not to be available, used when their availability couldn't be probed.
+var knownUnavailableFeatures = map[string][]string{
+	io2VolumeFeature: {
+		"us-gov-west-1",
+		"us-gov-east-1",
+		"sa-east-1",
+		"cn-north-1",
+		"cn-northwest-1",
+		"eu-south-1",
+		"af-south-1",
+		"eu-west-3",
+		"ap-northeast-3",
+	},
+}
+
+// featureProbes determine if a feature is available in a zone, using DryRun
+// API calls.
+var featureProbes = map[string]func(r *region, az string) (bool, error){
+	io2VolumeFeature: volumeTypeProbe(ec2.VolumeTypeIo2),
+	gp3VolumeFeature: volumeTypeProbe(ec2.VolumeTypeGp3),
+}
+
+// volumeTypeProbe checks if the volume type is supported in a zone by
+// creating a volume of that type with DryRun, which needs the
+// ec2:CreateVolume permission.
+func volumeTypeProbe(volumeType string) func(r *region, az string) (bool, error) {
+	return func(r *region, az string) (bool, error) {
+		input := &ec2.CreateVolumeInput{
+			DryRun:           aws.Bool(true),
+			AvailabilityZone: aws.String(az),
+			VolumeType:       aws.String(volumeType),
+			Size:             aws.Int64(10),
+		}
+		if volumeType == ec2.VolumeTypeIo2 {
+			input.Iops = aws.Int64(100)
+		}
+		_, err := r.services.ec2.CreateVolume(input)
+
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) {
+			return false, err
+		}
+		switch aerr.Code() {
+		case "DryRunOperation":
+			return true, nil
+		case "InvalidParameterValue", "InvalidParameterCombination", "UnsupportedOperation":
+			return false, nil
+		}
+		return false, err
+	}
+}
+
+// regionFeatures determines the features available in each region and zone,
+// from the configured overrides, the DryRun probes and the list of regions
+// known not to support them, in this order. The probed features are
+// remembered for the lifetime of the process.
+type regionFeatures struct {
+	sync.Mutex
+
+	// the configured availability, keyed by region and feature
+	overrides map[string]bool
+
+	// the probed availability, keyed by zone and feature
+	probed map[string]bool
+}
+
+func newRegionFeatures(conf *Config) *regionFeatures {
+	overrides, err := parseRegionFeatures(conf.RegionFeatures)
+	if err != nil {
+		log.Println("Ignoring the region features:", err.Error())
+	}
+	return &regionFeatures{
+		overrides: overrides,
+		probed:    make(map[string]bool),
+	}
+}
+
+// parseRegionFeatures parses the comma-separated list of features enabled or
+// disabled in regions, such as eu-west-3:io2=true,af-south-1:gp3=false.
+func parseRegionFeatures(value string) (map[string]bool, error) {
+	overrides := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		regionFeature, availability := splitKeyValue(entry, "=")
+		region, feature := splitKeyValue(regionFeature, ":")
+		available, err := strconv.ParseBool(availability)
+		if region == "" || feature == "" || err != nil {
+			return nil, fmt.Errorf("invalid region feature %q, expected region:feature=true|false", entry)
+		}
+		overrides[region+"/"+feature] = available
+	}
+	return overrides, nil
+}
+
+func splitKeyValue(s, sep string) (string, string) {
+	parts := strings.SplitN(s, sep, 2)
+	if len(parts) != 2 {
+		return strings.TrimSpace(s), ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// hasFeature determines if the feature is available in the given zone of the
+// region.
+func (r *region) hasFeature(feature, az string) bool {
+	if r.conf == nil || r.conf.regionFeatures == nil {
+		return isKnownAvailable(feature, r.name)
+	}
+	f := r.conf.regionFeatures
+	key := az + "/" + feature
+
+	f.Lock()
+	available, overridden := f.overrides[r.name+"/"+feature]
+	if !overridden {
+		available, overridden = f.probed[key]
+	}
+	f.Unlock()
+
+	if overridden {
+		return available
+	}
+
+	probe, found := featureProbes[feature]
+	if !found || az == "" {
+		return isKnownAvailable(feature, r.name)
+	}
+
+	// the probe runs without holding the lock, so that the other groups
+	// aren't blocked by its API call
+	available, err := probe(r, az)
+	if err != nil {
+		available = isKnownAvailable(feature, r.name)
+		log.Println(r.name, "Couldn't probe the availability of", feature, "in", az+", assuming",
+			available, "from the known regions:", err.Error())
+
+		// the probes failing for lack of permissions aren't retried
+		var aerr awserr.Error
+		if !errors.As(err, &aerr) || aerr.Code() != "UnauthorizedOperation" {
+			return available
+		}
+	}
+
+	debug.Println(r.name, "Feature", feature, "available in", az+":", available)
+	f.Lock()
+	f.probed[key] = available
+	f.Unlock()
+	return available
+}
+
+// isKnownAvailable tells if the feature isn't known to be unavailable in the
+// region.
+func isKnownAvailable(feature, region string) bool {
+	for _, r := range knownUnavailableFeatures[feature] {
+		if r == region {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func Test_parseRegionFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected map[string]bool
+		wantErr  bool
+	}{
+		{
+			name:     "empty",
+			value:    "",
+			expected: map[string]bool{},
+		},
+		{
+			name:  "multiple features",
+			value: "eu-west-3:io2=true, af-south-1:gp3=false,",
+			expected: map[string]bool{
+				"eu-west-3/io2":  true,
+				"af-south-1/gp3": false,
+			},
+		},
+		{
+			name:    "missing availability",
+			value:   "eu-west-3:io2",
+			wantErr: true,
+		},
+		{
+			name:    "missing feature",
+			value:   "eu-west-3=true",
+			wantErr: true,
+		},
+		{
+			name:    "invalid availability",
+			value:   "eu-west-3:io2=maybe",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRegionFeatures(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRegionFeatures() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseRegionFeatures() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_region_hasFeature(t *testing.T) {
+	tests := []struct {
+		name           string
+		region         string
+		regionFeatures string
+		noFeatures     bool
+		feature        string
+		az             string
+		probeErr       error
+		expected       bool
+		expectedProbes int
+		expectedCached bool
+	}{
+		{
+			name:       "without probing, known to be available",
+			region:     "us-east-1",
+			noFeatures: true,
+			feature:    io2VolumeFeature,
+			az:         "us-east-1a",
+			expected:   true,
+		},
+		{
+			name:       "without probing, known to be unavailable",
+			region:     "eu-west-3",
+			noFeatures: true,
+			feature:    io2VolumeFeature,
+			az:         "eu-west-3a",
+			expected:   false,
+		},
+		{
+			name:           "overridden availability",
+			region:         "eu-west-3",
+			regionFeatures: "eu-west-3:io2=true",
+			feature:        io2VolumeFeature,
+			az:             "eu-west-3a",
+			probeErr:       awserr.New("InvalidParameterValue", "not supported", nil),
+			expected:       true,
+		},
+		{
+			name:           "probed as available",
+			region:         "eu-west-3",
+			feature:        io2VolumeFeature,
+			az:             "eu-west-3a",
+			probeErr:       awserr.New("DryRunOperation", "would have succeeded", nil),
+			expected:       true,
+			expectedProbes: 1,
+			expectedCached: true,
+		},
+		{
+			name:           "probed as unavailable",
+			region:         "us-east-1",
+			feature:        gp3VolumeFeature,
+			az:             "us-east-1a",
+			probeErr:       awserr.New("InvalidParameterValue", "not supported", nil),
+			expected:       false,
+			expectedProbes: 1,
+			expectedCached: true,
+		},
+		{
+			name:           "probe not authorized",
+			region:         "eu-west-3",
+			feature:        io2VolumeFeature,
+			az:             "eu-west-3a",
+			probeErr:       awserr.New("UnauthorizedOperation", "not authorized", nil),
+			expected:       false,
+			expectedProbes: 1,
+			expectedCached: true,
+		},
+		{
+			name:           "probe throttled",
+			region:         "us-east-1",
+			feature:        io2VolumeFeature,
+			az:             "us-east-1a",
+			probeErr:       awserr.New("RequestLimitExceeded", "throttled", nil),
+			expected:       true,
+			expectedProbes: 1,
+		},
+		{
+			name:           "probe failing without AWS error",
+			region:         "us-east-1",
+			feature:        gp3VolumeFeature,
+			az:             "us-east-1a",
+			probeErr:       errors.New("connection reset"),
+			expected:       true,
+			expectedProbes: 1,
+		},
+		{
+			name:     "unknown zone",
+			region:   "eu-west-3",
+			feature:  io2VolumeFeature,
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{RegionFeatures: tt.regionFeatures}
+			if !tt.noFeatures {
+				conf.regionFeatures = newRegionFeatures(conf)
+			}
+
+			probes := 0
+			r := &region{
+				name: tt.region,
+				conf: conf,
+				services: connections{
+					ec2: mockEC2{cverr: tt.probeErr, cvcalls: &probes},
+				},
+			}
+
+			if got := r.hasFeature(tt.feature, tt.az); got != tt.expected {
+				t.Errorf("hasFeature() = %v, expected %v", got, tt.expected)
+			}
+			r.hasFeature(tt.feature, tt.az)
+
+			expectedProbes := tt.expectedProbes
+			if tt.expectedProbes > 0 && !tt.expectedCached {
+				expectedProbes = 2 * tt.expectedProbes
+			}
+			if probes != expectedProbes {
+				t.Errorf("hasFeature() probed %d times, expected %d", probes, expectedProbes)
+			}
+		})
+	}
+}
+
+func Test_region_hasFeature_probeWithoutLock(t *testing.T) {
+	r := &region{
+		name: "eu-west-3",
+		conf: &Config{regionFeatures: &regionFeatures{
+			overrides: map[string]bool{"eu-west-3/io2": true},
+			probed:    make(map[string]bool),
+		}},
+	}
+
+	saved := featureProbes
+	defer func() { featureProbes = saved }()
+
+	// the probe checks another feature, which blocks if the lock is held
+	done := make(chan bool)
+	featureProbes = map[string]func(r *region, az string) (bool, error){
+		gp3VolumeFeature: func(r *region, az string) (bool, error) {
+			return r.hasFeature(io2VolumeFeature, az), nil
+		},
+	}
+	go func() { done <- r.hasFeature(gp3VolumeFeature, "eu-west-3a") }()
+
+	select {
+	case got := <-done:
+		if !got {
+			t.Errorf("hasFeature() = false, expected true")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hasFeature() held the lock while probing")
+	}
+}
+
+func Test_isKnownAvailable(t *testing.T) {
+	tests := []struct {
+		name     string
+		feature  string
+		region   string
+		expected bool
+	}{
+		{name: "io2 in us-east-1", feature: io2VolumeFeature, region: "us-east-1", expected: true},
+		{name: "io2 in eu-west-3", feature: io2VolumeFeature, region: "eu-west-3", expected: false},
+		{name: "gp3 in eu-west-3", feature: gp3VolumeFeature, region: "eu-west-3", expected: true},
+		{name: "unknown feature", feature: "unknown", region: "eu-west-3", expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isKnownAvailable(tt.feature, tt.region); got != tt.expected {
+				t.Errorf("isKnownAvailable() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -4,9 +4,14 @@
 package autospotting
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -159,6 +164,7 @@ func TestAsgFiltersSetupOnRegion(t *testing.T) {
 func TestOnDemandPriceMultiplier(t *testing.T) {
 	tests := []struct {
 		multiplier float64
+		regional   string
 		want       float64
 	}{
 		{
@@ -173,9 +179,15 @@ func TestOnDemandPriceMultiplier(t *testing.T) {
 			multiplier: 0.99,
 			want:       0.04356,
 		},
+		{
+			multiplier: 1.0,
+			regional:   "eu-west-1=0.5,us-*=2",
+			want:       0.088,
+		},
 	}
 	for _, tt := range tests {
 		cfg := &Config{
+			RegionalOnDemandPriceMultipliers: tt.regional,
 			InstanceData: &ec2instancesinfo.InstanceData{
 				0: {
 					InstanceType: "m1.small",
@@ -214,6 +226,58 @@ func TestOnDemandPriceMultiplier(t *testing.T) {
 	}
 }
 
+func Test_region_onDemandPriceMultiplier(t *testing.T) {
+	tests := []struct {
+		name     string
+		regional string
+		want     float64
+	}{
+		{
+			name: "no overrides",
+			want: 0.9,
+		},
+		{
+			name:     "exact region",
+			regional: "eu-west-1=0.5, us-east-1=0.7",
+			want:     0.7,
+		},
+		{
+			name:     "first matching glob",
+			regional: "us-*=0.6,us-east-1=0.7",
+			want:     0.6,
+		},
+		{
+			name:     "no matching region",
+			regional: "eu-*=0.5",
+			want:     0.9,
+		},
+		{
+			name:     "invalid entries are ignored",
+			regional: "us-east-1,us-east-1=text,us-east-1=-1,[=2,us-east-1=1.2",
+			want:     1.2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{
+					RegionalOnDemandPriceMultipliers: tt.regional,
+					AutoScalingConfig: AutoScalingConfig{
+						OnDemandPriceMultiplier: 0.9,
+					},
+				},
+			}
+			if got := r.onDemandPriceMultiplier(); got != tt.want {
+				t.Errorf("onDemandPriceMultiplier() = %v, want %v", got, tt.want)
+			}
+			if got := r.groupDefaultConfig().OnDemandPriceMultiplier; got != tt.want {
+				t.Errorf("groupDefaultConfig().OnDemandPriceMultiplier = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestDefaultASGFiltering(t *testing.T) {
 	tests := []struct {
 		tregion  *region
@@ -622,6 +686,43 @@ func TestFilterAsgs(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Test processing mixed groups launching only on-demand instances",
+			want: []string{"asg1", "asg2"},
+			tregion: &region{
+				tagsToFilterASGsBy: []Tag{{Key: "spot-enabled", Value: "true"}},
+				conf:               &Config{},
+				services: connections{
+					autoScaling: mockASG{
+						dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+							AutoScalingGroups: []*autoscaling.Group{
+								{
+									MixedInstancesPolicy: mixedInstancesPolicy(aws.Int64(100), "m5.large"),
+									Tags: []*autoscaling.TagDescription{
+										{Key: aws.String("spot-enabled"), Value: aws.String("true"), ResourceId: aws.String("asg1")},
+									},
+									AutoScalingGroupName: aws.String("asg1"),
+								},
+								{
+									MixedInstancesPolicy: mixedInstancesPolicy(nil, "m5.large"),
+									Tags: []*autoscaling.TagDescription{
+										{Key: aws.String("spot-enabled"), Value: aws.String("true"), ResourceId: aws.String("asg2")},
+									},
+									AutoScalingGroupName: aws.String("asg2"),
+								},
+								{
+									MixedInstancesPolicy: mixedInstancesPolicy(aws.Int64(20), "m5.large"),
+									Tags: []*autoscaling.TagDescription{
+										{Key: aws.String("spot-enabled"), Value: aws.String("true"), ResourceId: aws.String("asg3")},
+									},
+									AutoScalingGroupName: aws.String("asg3"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -759,7 +860,7 @@ func Test_region_scanInstances(t *testing.T) {
 				t.Errorf("region.scanInstances() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
-			for inst := range r.instances.instances() {
+			for _, inst := range r.instances.instances() {
 				wantedInstance := tt.wantInstances.get(*inst.InstanceId).Instance
 
 				if !reflect.DeepEqual(inst.Instance, wantedInstance) {
@@ -844,3 +945,241 @@ func Test_region_processDescribeInstancesPage(t *testing.T) {
 		})
 	}
 }
+
+type mockAction struct {
+	err  error
+	runs *int
+}
+
+func (m mockAction) run() error {
+	*m.runs++
+	return m.err
+}
+
+func Test_region_runAction(t *testing.T) {
+	tests := []struct {
+		name         string
+		budget       *errorBudget
+		apiCalls     *apiCallBudget
+		leadership   context.Context
+		err          error
+		expectedRuns int
+		expectRecap  bool
+		expectFailed bool
+	}{
+		{
+			name:         "no error budget",
+			budget:       nil,
+			expectedRuns: 1,
+		},
+		{
+			name:         "error budget available",
+			budget:       newErrorBudget(1, 0),
+			err:          errors.New("failed"),
+			expectedRuns: 1,
+			expectFailed: true,
+		},
+		{
+			name:         "expected error not accounted",
+			budget:       newErrorBudget(1, 0),
+			err:          fmt.Errorf("i-1: %w", ErrProtectedInstance),
+			expectedRuns: 1,
+		},
+		{
+			name:         "error needing attention",
+			budget:       newErrorBudget(1, 0),
+			err:          fmt.Errorf("launch failed: %w", ErrPermissionDenied),
+			expectedRuns: 1,
+			expectRecap:  true,
+			expectFailed: true,
+		},
+		{
+			name:         "error budget exhausted",
+			budget:       &errorBudget{exhausted: true},
+			expectedRuns: 0,
+			expectRecap:  true,
+			expectFailed: true,
+		},
+		{
+			name:         "API call budget exhausted",
+			apiCalls:     &apiCallBudget{exhausted: true},
+			expectedRuns: 0,
+			expectRecap:  true,
+		},
+		{
+			name:         "leadership held",
+			leadership:   context.Background(),
+			expectedRuns: 1,
+		},
+		{
+			name: "leadership lost",
+			leadership: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			}(),
+			expectedRuns: 0,
+			expectRecap:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs := 0
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{
+					FinalRecap:  map[string][]string{},
+					errorBudget: tt.budget,
+					apiCalls:    tt.apiCalls,
+					leadership:  tt.leadership,
+				},
+			}
+
+			r.runAction(&autoScalingGroup{name: "asg"}, mockAction{err: tt.err, runs: &runs})
+
+			if runs != tt.expectedRuns {
+				t.Errorf("runAction() executed the action %d times, expected %d",
+					runs, tt.expectedRuns)
+			}
+
+			if got := len(r.conf.FinalRecap["us-east-1"]) > 0; got != tt.expectRecap {
+				t.Errorf("runAction() recap = %v, expected recap %v",
+					r.conf.FinalRecap, tt.expectRecap)
+			}
+
+			if tt.expectFailed != tt.budget.isExhausted() && tt.budget != nil {
+				t.Errorf("runAction() recorded the failure in the error budget: %v, expected %v",
+					tt.budget.isExhausted(), tt.expectFailed)
+			}
+		})
+	}
+}
+
+func Test_region_recoverFromGroupPanic(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{
+			FinalRecap:  map[string][]string{},
+			errorBudget: newErrorBudget(1, 0),
+		},
+	}
+
+	func() {
+		defer r.recoverFromGroupPanic(&autoScalingGroup{name: "asg"})
+		var lt *autoscaling.LaunchTemplateSpecification
+		_ = *lt.Version
+	}()
+
+	if len(r.conf.FinalRecap["us-east-1"]) != 1 {
+		t.Errorf("recoverFromGroupPanic() recap = %v, expected the panic to be reported",
+			r.conf.FinalRecap)
+	}
+
+	if !r.conf.errorBudget.isExhausted() {
+		t.Errorf("recoverFromGroupPanic() didn't record the panic in the error budget")
+	}
+
+	// nothing is reported without a panic
+	func() {
+		defer r.recoverFromGroupPanic(&autoScalingGroup{name: "asg"})
+	}()
+
+	if len(r.conf.FinalRecap["us-east-1"]) != 1 {
+		t.Errorf("recoverFromGroupPanic() recap = %v, expected a single entry",
+			r.conf.FinalRecap)
+	}
+}
+
+func Test_region_loadEnabledASG(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{
+			AutoScalingConfig: AutoScalingConfig{OnDemandPriceMultiplier: 1},
+		},
+		instances: makeInstancesWithCatalog(instanceMap{}),
+	}
+	r.addEnabledASGs(
+		&autoScalingGroup{name: "asg1", Group: &autoscaling.Group{}, region: r},
+		&autoScalingGroup{name: "asg2", Group: &autoscaling.Group{}, region: r},
+	)
+
+	if got := r.loadEnabledASG("missing"); got != nil {
+		t.Errorf("loadEnabledASG() = %v, expected nil", got)
+	}
+
+	var wg sync.WaitGroup
+	loaded := make([]*autoScalingGroup, 20)
+	for n := range loaded {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			loaded[n] = r.loadEnabledASG("asg1")
+		}(n)
+	}
+	wg.Wait()
+
+	expected := r.findEnabledASGByName("asg1")
+	for n, asg := range loaded {
+		if asg != expected {
+			t.Errorf("loadEnabledASG() #%d returned a different group than %p", n, expected)
+		}
+	}
+	if !expected.loaded {
+		t.Errorf("group %s wasn't marked as loaded", expected.name)
+	}
+	if r.findEnabledASGByName("asg2").loaded {
+		t.Errorf("group asg2 was loaded without being requested")
+	}
+}
+
+func Test_region_loadEnabledASG_otherGroupLoading(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{
+			AutoScalingConfig: AutoScalingConfig{OnDemandPriceMultiplier: 1},
+		},
+		instances: makeInstancesWithCatalog(instanceMap{}),
+	}
+	r.addEnabledASGs(
+		&autoScalingGroup{name: "asg1", Group: &autoscaling.Group{}, region: r},
+		&autoScalingGroup{name: "asg2", Group: &autoscaling.Group{}, region: r},
+	)
+
+	// asg1 is still being loaded, which shouldn't hold the loading of asg2
+	asg1 := r.findEnabledASGByName("asg1")
+	asg1.loadLock.Lock()
+	defer asg1.loadLock.Unlock()
+
+	done := make(chan *autoScalingGroup)
+	go func() { done <- r.loadEnabledASG("asg2") }()
+
+	select {
+	case asg := <-done:
+		if asg == nil || !asg.loaded {
+			t.Errorf("loadEnabledASG() = %v, expected the loaded group asg2", asg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("loadEnabledASG() waited for the loading of another group")
+	}
+}
+
+func Test_region_loadEnabledASG_afterCronLoad(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{
+			AutoScalingConfig: AutoScalingConfig{OnDemandPriceMultiplier: 1},
+		},
+		instances: makeInstancesWithCatalog(instanceMap{}),
+	}
+	asg := &autoScalingGroup{name: "asg1", Group: &autoscaling.Group{}, region: r}
+	r.addEnabledASGs(asg)
+
+	asg.config = r.groupDefaultConfig()
+	asg.loadConfiguration()
+	asg.config.OnDemandPriceMultiplier = 2
+
+	// the instances swapped by the cron run bind to the group already loaded
+	if got := r.loadEnabledASG("asg1"); got != asg || got.config.OnDemandPriceMultiplier != 2 {
+		t.Errorf("loadEnabledASG() reloaded the configuration of the group loaded by the cron run")
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// suspendedSwapProcesses are the scaling processes suspended by AutoSpotting
+// while replacing instances.
+var suspendedSwapProcesses = []string{"Terminate", "AZRebalance"}
+
+// groupRevert is the outcome of reverting a group to on-demand instances.
+type groupRevert struct {
+	region string
+	name   string
+
+	// the tags changed for disabling AutoSpotting on the group
+	disabledBy []string
+
+	// the restored MaxSize, empty when it wasn't changed
+	maxSize string
+
+	// the scaling processes resumed
+	resumed []string
+
+	// the spot instances terminated for being replaced by on-demand ones
+	replaced []string
+
+	// the spot instances left to be replaced by the next runs
+	remaining int
+
+	errors []string
+}
+
+// Revert converts the groups given in RevertASGs back to on-demand instances
+// and writes a report of their restored state. AutoSpotting is disabled on
+// them by removing its tags, their MaxSize and suspended processes are
+// restored, and the spot instances it launched are terminated for being
+// replaced by the groups with on-demand instances of their original type.
+func (a *AutoSpotting) Revert(w io.Writer) error {
+	a.config.FinalRecap = make(map[string][]string)
+	a.config.addDefaultFilteringMode()
+	a.config.addDefaultFilter()
+
+	names := make(map[string]bool)
+	for _, name := range strings.Split(replaceWhitespace(a.config.RevertASGs), ",") {
+		if name != "" {
+			names[name] = true
+		}
+	}
+
+	allRegions, err := a.getRegions()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	var resultsLock sync.Mutex
+	var results []groupRevert
+
+	for _, name := range allRegions {
+		r := &region{name: name, conf: a.config}
+		if !r.enabled() {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.services.connect(r.name, r.conf)
+			reverted := r.revertGroups(names)
+			resultsLock.Lock()
+			results = append(results, reverted...)
+			resultsLock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].region != results[j].region {
+			return results[i].region < results[j].region
+		}
+		return results[i].name < results[j].name
+	})
+
+	if err := writeRevertReport(w, results); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		delete(names, r.name)
+	}
+	if len(names) > 0 {
+		missing := make([]string, 0, len(names))
+		for name := range names {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("AutoScaling groups %s weren't found in the enabled regions", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// revertGroups reverts the given groups of the region to on-demand instances.
+func (r *region) revertGroups(names map[string]bool) []groupRevert {
+	var groups []*autoscaling.Group
+	err := r.services.autoScaling.DescribeAutoScalingGroupsPages(
+		&autoscaling.DescribeAutoScalingGroupsInput{},
+		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			for _, group := range page.AutoScalingGroups {
+				if names[aws.StringValue(group.AutoScalingGroupName)] {
+					groups = append(groups, group)
+				}
+			}
+			return true
+		},
+	)
+	if err != nil {
+		log.Println("Failed to describe AutoScalingGroups in", r.name, err.Error())
+		return nil
+	}
+
+	if len(groups) == 0 {
+		return nil
+	}
+
+	r.setupAsgFilters()
+
+	if err := r.scanInstances(); err != nil {
+		log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+		return nil
+	}
+
+	var results []groupRevert
+	for _, group := range groups {
+		asg := &autoScalingGroup{
+			Group:  group,
+			name:   aws.StringValue(group.AutoScalingGroupName),
+			region: r,
+			config: r.groupDefaultConfig(),
+		}
+		asg.scanInstances()
+		asg.loadDefaultConfig()
+		asg.loadConfigFromTags()
+		results = append(results, asg.revert())
+	}
+	return results
+}
+
+// revert converts the group back to on-demand instances. The spot instances
+// are replaced in batches of the group's surge, the next runs replacing the
+// remaining ones.
+func (a *autoScalingGroup) revert() groupRevert {
+	log.Println(a.region.name, a.name, "Reverting the group to on-demand instances")
+
+	result := groupRevert{region: a.region.name, name: a.name}
+
+	disabledBy, err := a.disableAutoSpotting()
+	result.disabledBy = disabledBy
+	if err != nil {
+		// the spot instances would otherwise be replaced again
+		result.errors = append(result.errors, "couldn't disable AutoSpotting: "+err.Error())
+		return result
+	}
+
+	if original := a.getOwnTagValue(OriginalMaxSizeTag); original != nil {
+		maxSize, err := strconv.ParseInt(*original, 10, 64)
+		if err == nil && aws.Int64Value(a.MaxSize) > maxSize {
+			if err := a.setAutoScalingMaxSize(maxSize); err != nil {
+				result.errors = append(result.errors, "couldn't restore the MaxSize: "+err.Error())
+			} else {
+				result.maxSize = fmt.Sprintf("%d (was %d)", maxSize, aws.Int64Value(a.MaxSize))
+				a.MaxSize = aws.Int64(maxSize)
+			}
+		}
+	}
+
+	resumed, err := a.resumeSuspendedSwapProcesses()
+	result.resumed = resumed
+	if err != nil {
+		result.errors = append(result.errors, "couldn't resume the processes: "+err.Error())
+	}
+
+	var spotInstances []*instance
+	for _, i := range a.instances.instances() {
+		if i.isSpot() && i.isLaunchedByAutoSpotting() && i.stateName() == ec2.InstanceStateNameRunning {
+			spotInstances = append(spotInstances, i)
+		}
+	}
+
+	batch := int(a.maxSizeIncrement())
+	for n, i := range spotInstances {
+		if n >= batch {
+			result.remaining += len(spotInstances) - n
+			break
+		}
+
+		id := aws.StringValue(i.InstanceId)
+		log.Printf("%s %s Replacing spot instance %s with an on-demand %s instance",
+			a.region.name, a.name, id, aws.StringValue(i.tagValue(originalInstanceTypeTag)))
+
+		a.deregisterFromServiceDiscovery(id)
+		a.drainInstance(id)
+		a.region.annotateTermination(id, a.name, revertedTermination, "")
+		if err := a.terminateInstanceInAutoScalingGroup(i.InstanceId, false, false); err != nil {
+			result.errors = append(result.errors, fmt.Sprintf("couldn't terminate %s: %s", id, err.Error()))
+			result.remaining++
+			continue
+		}
+		result.replaced = append(result.replaced, id)
+	}
+
+	return result
+}
+
+// disableAutoSpotting removes the tags enabling and configuring AutoSpotting
+// on the group, or sets the filter tags opting the group out when running in
+// the opt-out tag filtering mode, and returns the changed tag keys.
+func (a *autoScalingGroup) disableAutoSpotting() ([]string, error) {
+	optOut := a.region.conf.TagFilteringMode == "opt-out"
+	configPrefix := a.region.conf.tagKey(groupConfigTagPrefix)
+
+	// the keys are already within the tag namespace
+	groupTag := func(key, value string) *autoscaling.Tag {
+		return &autoscaling.Tag{
+			Key:               aws.String(key),
+			Value:             aws.String(value),
+			PropagateAtLaunch: aws.Bool(false),
+			ResourceId:        aws.String(a.name),
+			ResourceType:      aws.String("auto-scaling-group"),
+		}
+	}
+
+	filterKeys := make(map[string]bool)
+	var optOutTags []*autoscaling.Tag
+	for _, tag := range a.region.tagsToFilterASGsBy {
+		filterKeys[tag.Key] = true
+		if optOut {
+			optOutTags = append(optOutTags, groupTag(tag.Key, tag.Value))
+		}
+	}
+
+	var deletedTags []*autoscaling.Tag
+	for _, tag := range a.Tags {
+		key := aws.StringValue(tag.Key)
+		if (filterKeys[key] && !optOut) || (!filterKeys[key] && strings.HasPrefix(key, configPrefix)) {
+			deletedTags = append(deletedTags, groupTag(key, ""))
+		}
+	}
+
+	var changed []string
+	if len(optOutTags) > 0 {
+		if _, err := a.region.services.autoScaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+			Tags: optOutTags,
+		}); err != nil {
+			return nil, err
+		}
+		for _, tag := range optOutTags {
+			changed = append(changed, aws.StringValue(tag.Key)+"="+aws.StringValue(tag.Value))
+		}
+	}
+
+	if len(deletedTags) > 0 {
+		if _, err := a.region.services.autoScaling.DeleteTags(&autoscaling.DeleteTagsInput{
+			Tags: deletedTags,
+		}); err != nil {
+			return changed, err
+		}
+		for _, tag := range deletedTags {
+			changed = append(changed, "-"+aws.StringValue(tag.Key))
+		}
+	}
+	return changed, nil
+}
+
+// resumeSuspendedSwapProcesses resumes the scaling processes suspended by
+// AutoSpotting while replacing instances, if left suspended.
+func (a *autoScalingGroup) resumeSuspendedSwapProcesses() ([]string, error) {
+	var suspended []*string
+	var names []string
+	for _, p := range a.SuspendedProcesses {
+		for _, name := range suspendedSwapProcesses {
+			if aws.StringValue(p.ProcessName) == name {
+				suspended = append(suspended, p.ProcessName)
+				names = append(names, name)
+			}
+		}
+	}
+
+	if len(suspended) == 0 {
+		return nil, nil
+	}
+
+	if _, err := a.region.services.autoScaling.ResumeProcesses(&autoscaling.ScalingProcessQuery{
+		AutoScalingGroupName: a.AutoScalingGroupName,
+		ScalingProcesses:     suspended,
+	}); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// writeRevertReport writes the restored state of the reverted groups as a
+// table.
+func writeRevertReport(w io.Writer, results []groupRevert) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	orDash := func(values []string) string {
+		if len(values) == 0 {
+			return "-"
+		}
+		return strings.Join(values, ", ")
+	}
+
+	fmt.Fprintln(tw, "REGION\tGROUP\tTAGS CHANGED\tMAXSIZE RESTORED\tPROCESSES RESUMED\tSPOT REPLACED\tSPOT LEFT\tERRORS")
+
+	var remaining int
+	for _, r := range results {
+		remaining += r.remaining
+
+		maxSize := r.maxSize
+		if maxSize == "" {
+			maxSize = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			r.region, r.name, orDash(r.disabledBy), maxSize, orDash(r.resumed),
+			orDash(r.replaced), r.remaining, orDash(r.errors))
+	}
+
+	if remaining > 0 {
+		fmt.Fprintf(tw, "\n%d spot instances left, run the revert again once the replacements are in service\n", remaining)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_disableAutoSpotting(t *testing.T) {
+	tests := []struct {
+		name            string
+		conf            *Config
+		filters         []Tag
+		tags            []*autoscaling.TagDescription
+		couterr         error
+		delterr         error
+		expected        []string
+		expectedCreates int
+		expectedDeletes int
+		expectedErr     bool
+	}{
+		{
+			name:    "opt-in",
+			conf:    &Config{TagFilteringMode: "opt-in"},
+			filters: []Tag{{Key: "spot-enabled", Value: "true"}},
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+				{Key: aws.String(SkipReasonsTag), Value: aws.String("protected=1")},
+				{Key: aws.String("autospotting_min_on_demand_number"), Value: aws.String("1")},
+				{Key: aws.String("team"), Value: aws.String("platform")},
+			},
+			expected:        []string{"-spot-enabled", "-" + SkipReasonsTag, "-autospotting_min_on_demand_number"},
+			expectedDeletes: 1,
+		},
+		{
+			name:    "opt-in within a tag namespace",
+			conf:    &Config{TagFilteringMode: "opt-in", TagKeyPrefix: "acme:"},
+			filters: []Tag{{Key: "acme:spot-enabled", Value: "true"}},
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String("acme:spot-enabled"), Value: aws.String("true")},
+				{Key: aws.String("acme:min_on_demand_number"), Value: aws.String("1")},
+				{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+			},
+			expected:        []string{"-acme:spot-enabled", "-acme:min_on_demand_number"},
+			expectedDeletes: 1,
+		},
+		{
+			name:    "opt-out",
+			conf:    &Config{TagFilteringMode: "opt-out"},
+			filters: []Tag{{Key: "spot-enabled", Value: "false"}},
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String("autospotting_min_on_demand_number"), Value: aws.String("1")},
+			},
+			expected:        []string{"spot-enabled=false", "-autospotting_min_on_demand_number"},
+			expectedCreates: 1,
+			expectedDeletes: 1,
+		},
+		{
+			name:            "no tags to delete",
+			conf:            &Config{TagFilteringMode: "opt-in"},
+			filters:         []Tag{{Key: "spot-enabled", Value: "true"}},
+			tags:            []*autoscaling.TagDescription{},
+			expectedDeletes: 0,
+		},
+		{
+			name:    "deletion error",
+			conf:    &Config{TagFilteringMode: "opt-in"},
+			filters: []Tag{{Key: "spot-enabled", Value: "true"}},
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+			},
+			delterr:         errors.New("access denied"),
+			expectedDeletes: 1,
+			expectedErr:     true,
+		},
+		{
+			name:            "opt-out error",
+			conf:            &Config{TagFilteringMode: "opt-out"},
+			filters:         []Tag{{Key: "spot-enabled", Value: "false"}},
+			couterr:         errors.New("access denied"),
+			expectedCreates: 1,
+			expectedErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var creates, deletes int
+
+			a := &autoScalingGroup{
+				name:  "asg",
+				Group: &autoscaling.Group{Tags: tt.tags},
+				region: &region{
+					conf:               tt.conf,
+					tagsToFilterASGsBy: tt.filters,
+					services: connections{autoScaling: mockASG{
+						couterr: tt.couterr, coutcalls: &creates,
+						delterr: tt.delterr, deltcalls: &deletes,
+					}},
+				},
+			}
+
+			got, err := a.disableAutoSpotting()
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("disableAutoSpotting() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("disableAutoSpotting() = %v, expected %v", got, tt.expected)
+			}
+			if creates != tt.expectedCreates || deletes != tt.expectedDeletes {
+				t.Errorf("disableAutoSpotting() made %d/%d tag creations/deletions, expected %d/%d",
+					creates, deletes, tt.expectedCreates, tt.expectedDeletes)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_resumeSuspendedSwapProcesses(t *testing.T) {
+	tests := []struct {
+		name      string
+		suspended []string
+		rperr     error
+		expected  []string
+		expectErr bool
+	}{
+		{name: "nothing suspended"},
+		{
+			name:      "only other processes suspended",
+			suspended: []string{"Launch"},
+		},
+		{
+			name:      "swap processes left suspended",
+			suspended: []string{"Launch", "Terminate", "AZRebalance"},
+			expected:  []string{"Terminate", "AZRebalance"},
+		},
+		{
+			name:      "resume error",
+			suspended: []string{"Terminate"},
+			rperr:     errors.New("throttled"),
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resumed []*autoscaling.ScalingProcessQuery
+
+			group := &autoscaling.Group{AutoScalingGroupName: aws.String("asg")}
+			for _, p := range tt.suspended {
+				group.SuspendedProcesses = append(group.SuspendedProcesses,
+					&autoscaling.SuspendedProcess{ProcessName: aws.String(p)})
+			}
+			a := &autoScalingGroup{
+				name:  "asg",
+				Group: group,
+				region: &region{services: connections{autoScaling: mockASG{
+					rperr: tt.rperr, rpin: &resumed,
+				}}},
+			}
+
+			got, err := a.resumeSuspendedSwapProcesses()
+			if (err != nil) != tt.expectErr {
+				t.Errorf("resumeSuspendedSwapProcesses() error = %v, expected error %v", err, tt.expectErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("resumeSuspendedSwapProcesses() = %v, expected %v", got, tt.expected)
+			}
+			if len(tt.expected) > 0 && len(resumed) != 1 {
+				t.Errorf("resumeSuspendedSwapProcesses() made %d calls, expected 1", len(resumed))
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_revert(t *testing.T) {
+	launchedTags := []*ec2.Tag{
+		{Key: aws.String(launchedByAutoSpottingTag), Value: aws.String("true")},
+		{Key: aws.String(originalInstanceTypeTag), Value: aws.String("m5.large")},
+	}
+	newInstance := func(id, lifecycle string, tags []*ec2.Tag) *instance {
+		return &instance{Instance: &ec2.Instance{
+			InstanceId:        aws.String(id),
+			InstanceLifecycle: aws.String(lifecycle),
+			State:             &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Tags:              tags,
+		}}
+	}
+
+	tests := []struct {
+		name              string
+		surge             int64
+		maxSize           int64
+		tags              []*autoscaling.TagDescription
+		delterr           error
+		tiiasgerr         error
+		expectedMaxSize   string
+		expectedReplaced  []string
+		expectedRemaining int
+		expectedErrors    int
+	}{
+		{
+			name:              "replaced in batches",
+			maxSize:           3,
+			expectedReplaced:  []string{"i-spot1"},
+			expectedRemaining: 1,
+		},
+		{
+			name:             "replaced with surge, MaxSize restored",
+			surge:            2,
+			maxSize:          5,
+			tags:             []*autoscaling.TagDescription{{Key: aws.String(OriginalMaxSizeTag), Value: aws.String("3")}},
+			expectedMaxSize:  "3 (was 5)",
+			expectedReplaced: []string{"i-spot1", "i-spot2"},
+		},
+		{
+			name:              "AutoSpotting couldn't be disabled",
+			maxSize:           3,
+			tags:              []*autoscaling.TagDescription{{Key: aws.String(SkipReasonsTag), Value: aws.String("protected=1")}},
+			delterr:           errors.New("access denied"),
+			expectedRemaining: 0,
+			expectedErrors:    1,
+		},
+		{
+			name:              "termination error",
+			maxSize:           3,
+			tiiasgerr:         errors.New("throttled"),
+			expectedRemaining: 2,
+			expectedErrors:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name:               "us-east-1",
+				conf:               &Config{TagFilteringMode: "opt-in"},
+				tagsToFilterASGsBy: []Tag{{Key: "spot-enabled", Value: "true"}},
+				services: connections{autoScaling: mockASG{
+					delterr:   tt.delterr,
+					tiiasgerr: tt.tiiasgerr,
+					dlho:      &autoscaling.DescribeLifecycleHooksOutput{},
+				}, ec2: mockEC2{}},
+			}
+			a := &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{
+					AutoScalingGroupName: aws.String("asg"),
+					MaxSize:              aws.Int64(tt.maxSize),
+					Tags:                 tt.tags,
+				},
+				region:    r,
+				instances: makeInstances(),
+				config:    AutoScalingConfig{Surge: tt.surge},
+			}
+			for _, i := range []*instance{
+				newInstance("i-ondemand", "", nil),
+				newInstance("i-external", Spot, nil),
+				newInstance("i-spot1", Spot, launchedTags),
+				newInstance("i-spot2", Spot, launchedTags),
+			} {
+				i.region, i.asg = r, a
+				a.instances.add(i)
+			}
+
+			got := a.revert()
+
+			if got.maxSize != tt.expectedMaxSize {
+				t.Errorf("revert() restored MaxSize %q, expected %q", got.maxSize, tt.expectedMaxSize)
+			}
+			if !reflect.DeepEqual(got.replaced, tt.expectedReplaced) {
+				t.Errorf("revert() replaced %v, expected %v", got.replaced, tt.expectedReplaced)
+			}
+			if got.remaining != tt.expectedRemaining {
+				t.Errorf("revert() left %d spot instances, expected %d", got.remaining, tt.expectedRemaining)
+			}
+			if len(got.errors) != tt.expectedErrors {
+				t.Errorf("revert() errors = %v, expected %d errors", got.errors, tt.expectedErrors)
+			}
+		})
+	}
+}
+
+func Test_writeRevertReport(t *testing.T) {
+	results := []groupRevert{
+		{
+			region:     "us-east-1",
+			name:       "asg",
+			disabledBy: []string{"-spot-enabled"},
+			maxSize:    "3 (was 4)",
+			replaced:   []string{"i-spot1"},
+			remaining:  2,
+		},
+		{region: "us-east-1", name: "done"},
+	}
+
+	var out bytes.Buffer
+	if err := writeRevertReport(&out, results); err != nil {
+		t.Fatalf("writeRevertReport() error = %v", err)
+	}
+
+	for _, s := range []string{"-spot-enabled", "3 (was 4)", "i-spot1", "2 spot instances left"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("writeRevertReport() = %q, expected to contain %q", out.String(), s)
+		}
+	}
+}
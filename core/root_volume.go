@@ -0,0 +1,91 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// resizeRootVolume grows the root volume of the spot instance to the size
+// configured for the group and sets its provisioned IOPS, leaving the launch
+// configuration or template unchanged. The size and type not set by the launch
+// configuration or template are those of the root device mapping of the AMI.
+func (i *instance) resizeRootVolume(rii *ec2.RunInstancesInput, imageRoot *ec2.BlockDeviceMapping) {
+	size, iops := i.asg.config.RootVolumeSize, i.asg.config.RootVolumeIOPS
+	if size == 0 && iops == 0 {
+		return
+	}
+
+	rootDevice := aws.StringValue(i.RootDeviceName)
+	if rootDevice == "" {
+		log.Println("Couldn't determine the root device of", aws.StringValue(i.InstanceId),
+			"keeping its root volume unchanged")
+		return
+	}
+
+	var root *ec2.BlockDeviceMapping
+	for _, bdm := range rii.BlockDeviceMappings {
+		if aws.StringValue(bdm.DeviceName) == rootDevice {
+			root = bdm
+			break
+		}
+	}
+
+	// the root volume only mapped by the AMI is overridden by its device name,
+	// inheriting the snapshot and the other settings of the AMI mapping
+	if root == nil {
+		root = &ec2.BlockDeviceMapping{DeviceName: aws.String(rootDevice), Ebs: &ec2.EbsBlockDevice{}}
+		defer func() {
+			if root.Ebs.VolumeSize != nil || root.Ebs.Iops != nil {
+				rii.BlockDeviceMappings = append(rii.BlockDeviceMappings, root)
+			}
+		}()
+	}
+
+	if root.Ebs == nil {
+		if root.VirtualName != nil {
+			log.Println("The root device", rootDevice, "of", aws.StringValue(i.InstanceId),
+				"isn't an EBS volume, keeping it unchanged")
+			return
+		}
+		root.Ebs = &ec2.EbsBlockDevice{}
+	}
+
+	currentSize, volumeType := root.Ebs.VolumeSize, root.Ebs.VolumeType
+	if imageRoot != nil && imageRoot.Ebs != nil {
+		if currentSize == nil {
+			currentSize = imageRoot.Ebs.VolumeSize
+		}
+		if volumeType == nil {
+			volumeType = imageRoot.Ebs.VolumeType
+		}
+	}
+
+	switch {
+	case size == 0:
+	case currentSize == nil:
+		// the volume can't be smaller than the snapshot of the AMI
+		log.Println("Couldn't determine the root volume size of the spot instance replacing",
+			aws.StringValue(i.InstanceId), "keeping it unchanged")
+	case size > aws.Int64Value(currentSize):
+		log.Println("Growing the root volume of the spot instance replacing",
+			aws.StringValue(i.InstanceId), "to", size, "GiB")
+		root.Ebs.VolumeSize = aws.Int64(size)
+	}
+
+	if iops > 0 {
+		if !supportsIOPS(aws.StringValue(volumeType)) {
+			log.Println("The", aws.StringValue(volumeType), "root volume of the spot instance replacing",
+				aws.StringValue(i.InstanceId), "doesn't support provisioned IOPS, keeping them unchanged")
+			return
+		}
+		log.Println("Setting the root volume IOPS of the spot instance replacing",
+			aws.StringValue(i.InstanceId), "to", iops)
+		root.Ebs.VolumeType = volumeType
+		root.Ebs.Iops = aws.Int64(iops)
+	}
+}
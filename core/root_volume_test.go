@@ -0,0 +1,208 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instance_resizeRootVolume(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int64
+		iops       int64
+		rootDevice *string
+		imageRoot  *ec2.BlockDeviceMapping
+		bdms       []*ec2.BlockDeviceMapping
+		expected   []*ec2.BlockDeviceMapping
+	}{
+		{
+			name:       "not configured",
+			rootDevice: aws.String("/dev/xvda"),
+			bdms: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+			},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+			},
+		},
+		{
+			name: "unknown root device",
+			size: 50,
+			bdms: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+			},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+			},
+		},
+		{
+			name:       "growing the root volume",
+			size:       50,
+			rootDevice: aws.String("/dev/xvda"),
+			bdms: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+				{DeviceName: aws.String("/dev/xvdb"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+			},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(50)}},
+				{DeviceName: aws.String("/dev/xvdb"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+			},
+		},
+		{
+			name:       "not shrinking the root volume",
+			size:       50,
+			rootDevice: aws.String("/dev/xvda"),
+			bdms: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(100)}},
+			},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(100)}},
+			},
+		},
+		{
+			name:       "root volume only mapped by the AMI",
+			size:       50,
+			rootDevice: aws.String("/dev/xvda"),
+			imageRoot:  &ec2.BlockDeviceMapping{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+			bdms: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvdb"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+			},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvdb"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8)}},
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(50)}},
+			},
+		},
+		{
+			name:       "not shrinking the root volume of the AMI",
+			size:       50,
+			rootDevice: aws.String("/dev/xvda"),
+			imageRoot:  &ec2.BlockDeviceMapping{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(100)}},
+			bdms: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{DeleteOnTermination: aws.Bool(true)}},
+			},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{DeleteOnTermination: aws.Bool(true)}},
+			},
+		},
+		{
+			name:       "unknown size of the AMI root volume",
+			size:       50,
+			rootDevice: aws.String("/dev/xvda"),
+			bdms:       []*ec2.BlockDeviceMapping{},
+			expected:   []*ec2.BlockDeviceMapping{},
+		},
+		{
+			name:       "IOPS of the AMI root volume type",
+			iops:       6000,
+			rootDevice: aws.String("/dev/xvda"),
+			imageRoot:  &ec2.BlockDeviceMapping{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8), VolumeType: aws.String("gp3")}},
+			bdms:       []*ec2.BlockDeviceMapping{},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeType: aws.String("gp3"), Iops: aws.Int64(6000)}},
+			},
+		},
+		{
+			name:       "instance store root device",
+			size:       50,
+			rootDevice: aws.String("/dev/sda1"),
+			bdms: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/sda1"), VirtualName: aws.String("ephemeral0")},
+			},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/sda1"), VirtualName: aws.String("ephemeral0")},
+			},
+		},
+		{
+			name:       "setting the IOPS",
+			size:       50,
+			iops:       6000,
+			rootDevice: aws.String("/dev/xvda"),
+			bdms: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8), VolumeType: aws.String("gp3")}},
+			},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(50), VolumeType: aws.String("gp3"), Iops: aws.Int64(6000)}},
+			},
+		},
+		{
+			name:       "volume type without provisioned IOPS",
+			iops:       6000,
+			rootDevice: aws.String("/dev/xvda"),
+			bdms: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+			},
+			expected: []*ec2.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{VolumeSize: aws.Int64(8), VolumeType: aws.String("gp2")}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId:     aws.String("i-123"),
+					RootDeviceName: tt.rootDevice,
+				},
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{RootVolumeSize: tt.size, RootVolumeIOPS: tt.iops},
+				},
+			}
+			rii := &ec2.RunInstancesInput{BlockDeviceMappings: tt.bdms}
+
+			i.resizeRootVolume(rii, tt.imageRoot)
+
+			if !reflect.DeepEqual(rii.BlockDeviceMappings, tt.expected) {
+				t.Errorf("resizeRootVolume() = %v, expected %v", rii.BlockDeviceMappings, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadRootVolumeSettings(t *testing.T) {
+	tests := []struct {
+		name         string
+		tags         map[string]string
+		expectedSize int64
+		expectedIOPS int64
+	}{
+		{name: "no tags"},
+		{
+			name:         "valid tags",
+			tags:         map[string]string{RootVolumeSizeTag: "50", RootVolumeIOPSTag: "6000"},
+			expectedSize: 50,
+			expectedIOPS: 6000,
+		},
+		{
+			name:         "invalid tags",
+			tags:         map[string]string{RootVolumeSizeTag: "large", RootVolumeIOPSTag: "-1"},
+			expectedSize: 0,
+			expectedIOPS: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{conf: &Config{}},
+			}
+			for key, value := range tt.tags {
+				a.Tags = append(a.Tags, &autoscaling.TagDescription{Key: aws.String(key), Value: aws.String(value)})
+			}
+
+			a.loadRootVolumeSize()
+			a.loadRootVolumeIOPS()
+
+			if a.config.RootVolumeSize != tt.expectedSize || a.config.RootVolumeIOPS != tt.expectedIOPS {
+				t.Errorf("loaded size %d and IOPS %d, expected %d and %d",
+					a.config.RootVolumeSize, a.config.RootVolumeIOPS, tt.expectedSize, tt.expectedIOPS)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/costexplorer/costexploreriface"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+const (
+	// costExplorerRegion is the only region serving the Cost Explorer API
+	costExplorerRegion = "us-east-1"
+
+	// costExplorerDateFormat is the date format used by the Cost Explorer API
+	costExplorerDateFormat = "2006-01-02"
+)
+
+// savingsReconciliation compares the savings projected from the current spot
+// and on-demand prices with the savings actually realized according to the
+// billing data for a given day.
+type savingsReconciliation struct {
+	day       string
+	projected float64
+	realized  float64
+	currency  reportingCurrency
+}
+
+func (s savingsReconciliation) String() string {
+	return fmt.Sprintf("Savings on %s: projected %s, realized %s, difference %s",
+		s.day, s.currency.format(s.projected, 2), s.currency.format(s.realized, 2),
+		s.currency.format(s.realized-s.projected, 2))
+}
+
+func connectCostExplorer(conf *Config) costexploreriface.CostExplorerAPI {
+	sess, err := newSession(costExplorerRegion, conf)
+	if err != nil {
+		panic(err)
+	}
+
+	return costexplorer.New(sess, conf.serviceConfig(costexplorer.EndpointsID, costExplorerRegion))
+}
+
+// reconcileSavingsIfDue compares the projected hourly savings of the current
+// run with the savings realized during the previous day, at most once per
+// configured reconciliation interval.
+func (a *AutoSpotting) reconcileSavingsIfDue(hourlySavings float64) {
+	interval := a.config.SavingsReconciliationInterval
+	if interval <= 0 || a.costExplorerConn == nil {
+		return
+	}
+
+	now := a.config.getClock().Now()
+	if !a.config.lastSavingsReconciliation.IsZero() &&
+		now.Sub(a.config.lastSavingsReconciliation) < interval {
+		return
+	}
+
+	day := now.UTC().AddDate(0, 0, -1)
+
+	realized, err := realizedSavings(a.costExplorerConn, a.config.InstanceData,
+		a.config.tagKey(launchedByAutoSpottingTag), day)
+	if err != nil {
+		log.Println("Failed to reconcile the savings with the Cost Explorer data:", err.Error())
+		return
+	}
+	a.config.lastSavingsReconciliation = now
+
+	log.Println(savingsReconciliation{
+		day:       day.Format(costExplorerDateFormat),
+		projected: hourlySavings * 24,
+		realized:  realized,
+		currency:  a.config.reportingCurrency(),
+	})
+}
+
+// realizedSavings returns the difference between the on-demand cost and the
+// billed cost of the spot instances launched by AutoSpotting during the given
+// day. The launched-by-autospotting tag needs to be activated as a cost
+// allocation tag for the billing data to be available.
+func realizedSavings(svc costexploreriface.CostExplorerAPI, data *ec2instancesinfo.InstanceData,
+	tagKey string, day time.Time) (float64, error) {
+
+	input := &costexplorer.GetCostAndUsageInput{
+		TimePeriod: &costexplorer.DateInterval{
+			Start: aws.String(day.Format(costExplorerDateFormat)),
+			End:   aws.String(day.AddDate(0, 0, 1).Format(costExplorerDateFormat)),
+		},
+		Granularity: aws.String(costexplorer.GranularityDaily),
+		Metrics:     []*string{aws.String("UnblendedCost"), aws.String("UsageQuantity")},
+		Filter: &costexplorer.Expression{
+			And: []*costexplorer.Expression{
+				{
+					Dimensions: &costexplorer.DimensionValues{
+						Key:    aws.String(costexplorer.DimensionPurchaseType),
+						Values: []*string{aws.String("Spot Instances")},
+					},
+				},
+				{
+					Tags: &costexplorer.TagValues{
+						Key:    aws.String(tagKey),
+						Values: []*string{aws.String("true")},
+					},
+				},
+			},
+		},
+		GroupBy: []*costexplorer.GroupDefinition{
+			{Type: aws.String(costexplorer.GroupDefinitionTypeDimension), Key: aws.String(costexplorer.DimensionRegion)},
+			{Type: aws.String(costexplorer.GroupDefinitionTypeDimension), Key: aws.String(costexplorer.DimensionInstanceType)},
+		},
+	}
+
+	savings := 0.0
+	for {
+		out, err := svc.GetCostAndUsage(input)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, result := range out.ResultsByTime {
+			for _, group := range result.Groups {
+				if len(group.Keys) != 2 {
+					continue
+				}
+				region, instanceType := aws.StringValue(group.Keys[0]), aws.StringValue(group.Keys[1])
+
+				cost, hours := metricAmount(group.Metrics["UnblendedCost"]), metricAmount(group.Metrics["UsageQuantity"])
+
+				onDemand := onDemandPrice(data, region, instanceType)
+				if onDemand == 0 {
+					debug.Println("Missing on-demand price for", instanceType, "in", region)
+					continue
+				}
+				savings += onDemand*hours - cost
+			}
+		}
+
+		if aws.StringValue(out.NextPageToken) == "" {
+			return savings, nil
+		}
+		input.NextPageToken = out.NextPageToken
+	}
+}
+
+func metricAmount(m *costexplorer.MetricValue) float64 {
+	if m == nil {
+		return 0
+	}
+	amount, err := strconv.ParseFloat(aws.StringValue(m.Amount), 64)
+	if err != nil {
+		return 0
+	}
+	return amount
+}
+
+func onDemandPrice(data *ec2instancesinfo.InstanceData, region, instanceType string) float64 {
+	if data == nil {
+		return 0
+	}
+	for _, it := range *data {
+		if it.InstanceType == instanceType {
+			return it.Pricing[region].Linux.OnDemand
+		}
+	}
+	return 0
+}
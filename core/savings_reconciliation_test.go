@@ -0,0 +1,169 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+func costExplorerGroup(region, instanceType, cost, hours string) *costexplorer.Group {
+	return &costexplorer.Group{
+		Keys: []*string{aws.String(region), aws.String(instanceType)},
+		Metrics: map[string]*costexplorer.MetricValue{
+			"UnblendedCost": {Amount: aws.String(cost), Unit: aws.String("USD")},
+			"UsageQuantity": {Amount: aws.String(hours), Unit: aws.String("Hrs")},
+		},
+	}
+}
+
+func Test_realizedSavings(t *testing.T) {
+	data := &ec2instancesinfo.InstanceData{
+		0: {
+			InstanceType: "m5.large",
+			Pricing: map[string]ec2instancesinfo.RegionPrices{
+				"us-east-1": {Linux: ec2instancesinfo.Pricing{OnDemand: 0.1}},
+				"eu-west-1": {Linux: ec2instancesinfo.Pricing{OnDemand: 0.2}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		ce       mockCostExplorer
+		expected float64
+		wantErr  bool
+	}{
+		{
+			name:    "API error",
+			ce:      mockCostExplorer{gcauerr: errors.New("AccessDeniedException")},
+			wantErr: true,
+		},
+		{
+			name: "multiple pages and regions",
+			ce: mockCostExplorer{
+				gcauo: []*costexplorer.GetCostAndUsageOutput{
+					{
+						ResultsByTime: []*costexplorer.ResultByTime{
+							{Groups: []*costexplorer.Group{
+								costExplorerGroup("us-east-1", "m5.large", "1.0", "48"),
+							}},
+						},
+						NextPageToken: aws.String("1"),
+					},
+					{
+						ResultsByTime: []*costexplorer.ResultByTime{
+							{Groups: []*costexplorer.Group{
+								costExplorerGroup("eu-west-1", "m5.large", "0.8", "24"),
+							}},
+						},
+					},
+				},
+			},
+			// (0.1 * 48 - 1.0) + (0.2 * 24 - 0.8)
+			expected: 7.8,
+		},
+		{
+			name: "unknown instance type ignored",
+			ce: mockCostExplorer{
+				gcauo: []*costexplorer.GetCostAndUsageOutput{
+					{
+						ResultsByTime: []*costexplorer.ResultByTime{
+							{Groups: []*costexplorer.Group{
+								costExplorerGroup("us-east-1", "x9.huge", "1.0", "10"),
+								costExplorerGroup("us-east-1", "m5.large", "0.5", "10"),
+							}},
+						},
+					},
+				},
+			},
+			expected: 0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := realizedSavings(tt.ce, data, launchedByAutoSpottingTag, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("realizedSavings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.expected) > 0.000001 {
+				t.Errorf("realizedSavings() = %f, expected %f", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAutoSpotting_reconcileSavingsIfDue(t *testing.T) {
+	now := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+	ce := mockCostExplorer{
+		gcauo: []*costexplorer.GetCostAndUsageOutput{{}},
+	}
+
+	tests := []struct {
+		name         string
+		interval     time.Duration
+		last         time.Time
+		ce           mockCostExplorer
+		expectedLast time.Time
+	}{
+		{
+			name:         "disabled",
+			interval:     0,
+			ce:           ce,
+			expectedLast: time.Time{},
+		},
+		{
+			name:         "first reconciliation",
+			interval:     24 * time.Hour,
+			ce:           ce,
+			expectedLast: now,
+		},
+		{
+			name:         "not due yet",
+			interval:     24 * time.Hour,
+			last:         now.Add(-time.Hour),
+			ce:           ce,
+			expectedLast: now.Add(-time.Hour),
+		},
+		{
+			name:         "due",
+			interval:     24 * time.Hour,
+			last:         now.Add(-25 * time.Hour),
+			ce:           ce,
+			expectedLast: now,
+		},
+		{
+			name:         "failed reconciliation is retried on the next run",
+			interval:     24 * time.Hour,
+			last:         now.Add(-25 * time.Hour),
+			ce:           mockCostExplorer{gcauerr: errors.New("ThrottlingException")},
+			expectedLast: now.Add(-25 * time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &AutoSpotting{
+				config: &Config{
+					SavingsReconciliationInterval: tt.interval,
+					lastSavingsReconciliation:     tt.last,
+					clock:                         &mockClock{now: now},
+				},
+				costExplorerConn: tt.ce,
+			}
+
+			a.reconcileSavingsIfDue(1.5)
+
+			if !a.config.lastSavingsReconciliation.Equal(tt.expectedLast) {
+				t.Errorf("lastSavingsReconciliation = %v, expected %v",
+					a.config.lastSavingsReconciliation, tt.expectedLast)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const (
+	// NoScaleInProtection attaches the spot instances without protecting them
+	// from scale-in, and skips the on-demand instances protected from it
+	NoScaleInProtection = "none"
+
+	// GroupScaleInProtection protects the spot instances from scale-in when
+	// the group protects its new instances
+	GroupScaleInProtection = "group"
+
+	// InstanceScaleInProtection protects the spot instances from scale-in
+	// when the on-demand instances they replace are protected, which are
+	// then no longer skipped
+	InstanceScaleInProtection = "instance"
+)
+
+func isValidScaleInProtection(protection string) bool {
+	switch protection {
+	case NoScaleInProtection, GroupScaleInProtection, InstanceScaleInProtection:
+		return true
+	}
+	return false
+}
+
+// isBlockedByScaleInProtection tells whether the scale-in protection of the
+// instance prevents its replacement within this group.
+func (a *autoScalingGroup) isBlockedByScaleInProtection(i *instance) bool {
+	return i.isProtectedFromScaleIn() && a.scaleInProtection() != InstanceScaleInProtection
+}
+
+// copyScaleInProtection protects the spot instance attached to the group
+// from scale-in, mirroring either the NewInstancesProtectedFromScaleIn
+// setting of the group or the protection of the on-demand instance it
+// replaces, as configured for the group.
+func (i *instance) copyScaleInProtection(odInstance *instance) error {
+	asg := odInstance.asg
+	if asg == nil {
+		return nil
+	}
+
+	var protected bool
+	switch asg.scaleInProtection() {
+	case GroupScaleInProtection:
+		protected = asg.Group != nil && aws.BoolValue(asg.NewInstancesProtectedFromScaleIn)
+	case InstanceScaleInProtection:
+		protected = odInstance.isProtectedFromScaleIn()
+	}
+	if !protected {
+		return nil
+	}
+
+	log.Printf("Protecting spot instance %s replacing on-demand instance %s from scale-in in the group %s",
+		aws.StringValue(i.InstanceId), aws.StringValue(odInstance.InstanceId), asg.name)
+
+	_, err := i.region.services.autoScaling.SetInstanceProtection(
+		&autoscaling.SetInstanceProtectionInput{
+			AutoScalingGroupName: aws.String(asg.name),
+			InstanceIds:          []*string{i.InstanceId},
+			ProtectedFromScaleIn: aws.Bool(true),
+		})
+	if err != nil {
+		log.Printf("Couldn't protect spot instance %s from scale-in: %s",
+			aws.StringValue(i.InstanceId), err.Error())
+		return err
+	}
+
+	i.protected = true
+	return nil
+}
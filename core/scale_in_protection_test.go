@@ -0,0 +1,164 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_isBlockedByScaleInProtection(t *testing.T) {
+	tests := []struct {
+		name       string
+		protection string
+		protected  bool
+		want       bool
+	}{
+		{name: "unprotected instance", protection: NoScaleInProtection},
+		{name: "protected instance", protection: NoScaleInProtection, protected: true, want: true},
+		{name: "protected instance, default configuration", protected: true, want: true},
+		{name: "protected instance mirrored by the group", protection: GroupScaleInProtection, protected: true, want: true},
+		{name: "protected instance mirrored by its replacement", protection: InstanceScaleInProtection, protected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{Instances: []*autoscaling.Instance{{
+					InstanceId:           aws.String("i-od"),
+					ProtectedFromScaleIn: aws.Bool(tt.protected),
+				}}},
+				config: AutoScalingConfig{ScaleInProtection: tt.protection},
+			}
+			i := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-od")}, asg: a}
+
+			if got := a.isBlockedByScaleInProtection(i); got != tt.want {
+				t.Errorf("isBlockedByScaleInProtection() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_copyScaleInProtection(t *testing.T) {
+	tests := []struct {
+		name           string
+		protection     string
+		groupProtected bool
+		odProtected    bool
+		siperr         error
+		wantErr        bool
+		wantProtected  bool
+	}{
+		{
+			name:           "disabled",
+			protection:     NoScaleInProtection,
+			groupProtected: true,
+			odProtected:    true,
+		},
+		{
+			name:           "group protecting its new instances",
+			protection:     GroupScaleInProtection,
+			groupProtected: true,
+			wantProtected:  true,
+		},
+		{
+			name:        "group not protecting its new instances",
+			protection:  GroupScaleInProtection,
+			odProtected: true,
+		},
+		{
+			name:          "protected on-demand instance",
+			protection:    InstanceScaleInProtection,
+			odProtected:   true,
+			wantProtected: true,
+		},
+		{
+			name:           "unprotected on-demand instance",
+			protection:     InstanceScaleInProtection,
+			groupProtected: true,
+		},
+		{
+			name:        "failing to protect the spot instance",
+			protection:  InstanceScaleInProtection,
+			odProtected: true,
+			siperr:      errors.New("throttled"),
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inputs []*autoscaling.SetInstanceProtectionInput
+			r := &region{services: connections{autoScaling: mockASG{siperr: tt.siperr, sipin: &inputs}}}
+			a := &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{
+					NewInstancesProtectedFromScaleIn: aws.Bool(tt.groupProtected),
+					Instances: []*autoscaling.Instance{{
+						InstanceId:           aws.String("i-od"),
+						ProtectedFromScaleIn: aws.Bool(tt.odProtected),
+					}},
+				},
+				region: r,
+				config: AutoScalingConfig{ScaleInProtection: tt.protection},
+			}
+			od := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-od")}, asg: a, region: r}
+			spot := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-spot")}, region: r}
+
+			if err := spot.copyScaleInProtection(od); (err != nil) != tt.wantErr {
+				t.Errorf("copyScaleInProtection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if spot.protected != tt.wantProtected {
+				t.Errorf("copyScaleInProtection() protected = %v, want %v", spot.protected, tt.wantProtected)
+			}
+
+			wantCalls := 0
+			if tt.wantProtected || tt.wantErr {
+				wantCalls = 1
+			}
+			if len(inputs) != wantCalls {
+				t.Fatalf("copyScaleInProtection() made %d SetInstanceProtection calls, want %d", len(inputs), wantCalls)
+			}
+			if wantCalls == 1 && (aws.StringValue(inputs[0].InstanceIds[0]) != "i-spot" ||
+				aws.StringValue(inputs[0].AutoScalingGroupName) != "asg" ||
+				!aws.BoolValue(inputs[0].ProtectedFromScaleIn)) {
+				t.Errorf("copyScaleInProtection() called SetInstanceProtection with %v", inputs[0])
+			}
+		})
+	}
+}
+
+func TestLoadScaleInProtection(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected string
+	}{
+		{name: "no tag", expected: NoScaleInProtection},
+		{name: "group", tagValue: aws.String(GroupScaleInProtection), expected: GroupScaleInProtection},
+		{name: "instance", tagValue: aws.String(InstanceScaleInProtection), expected: InstanceScaleInProtection},
+		{name: "invalid tag", tagValue: aws.String("always"), expected: NoScaleInProtection},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+					ScaleInProtection: NoScaleInProtection,
+				}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(ScaleInProtectionTag), Value: tt.tagValue}}
+			}
+
+			a.loadScaleInProtection()
+
+			if a.config.ScaleInProtection != tt.expected {
+				t.Errorf("ScaleInProtection = %s, expected %s", a.config.ScaleInProtection, tt.expected)
+			}
+		})
+	}
+}
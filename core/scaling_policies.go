@@ -0,0 +1,87 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// instanceBoundMetrics are the predefined target tracking metrics averaged
+// across the instances of the group, which are skewed when the instances are
+// replaced with instance types of different sizes.
+var instanceBoundMetrics = map[string]bool{
+	autoscaling.MetricTypeAsgaverageCpuutilization: true,
+	autoscaling.MetricTypeAsgaverageNetworkIn:      true,
+	autoscaling.MetricTypeAsgaverageNetworkOut:     true,
+	autoscaling.MetricTypeAlbrequestCountPerTarget: true,
+}
+
+// loadScalingPolicies determines the scaling policies of the group which are
+// sensitive to the size of its instances: predictive scaling, which forecasts
+// the number of instances needed for the load, and target tracking on metrics
+// averaged across the instances.
+func (a *autoScalingGroup) loadScalingPolicies() {
+	if a.scalingPoliciesLoaded {
+		return
+	}
+	a.scalingPoliciesLoaded = true
+
+	err := a.region.services.autoScaling.DescribePoliciesPages(
+		&autoscaling.DescribePoliciesInput{
+			AutoScalingGroupName: aws.String(a.name),
+		},
+		func(page *autoscaling.DescribePoliciesOutput, lastPage bool) bool {
+			for _, p := range page.ScalingPolicies {
+				if reason, sensitive := sizeSensitivity(p); sensitive {
+					a.sizeSensitivePolicies = append(a.sizeSensitivePolicies, reason)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		log.Println(a.name, "Couldn't describe the scaling policies:", err.Error())
+	}
+}
+
+// sizeSensitivity describes why the scaling policy is sensitive to the size
+// of the instances of the group, if it is.
+func sizeSensitivity(p *autoscaling.ScalingPolicy) (string, bool) {
+	name := aws.StringValue(p.PolicyName)
+
+	switch aws.StringValue(p.PolicyType) {
+	case "PredictiveScaling":
+		return fmt.Sprintf("predictive scaling policy %s", name), true
+
+	case "TargetTrackingScaling":
+		if p.TargetTrackingConfiguration == nil ||
+			p.TargetTrackingConfiguration.PredefinedMetricSpecification == nil {
+			return "", false
+		}
+		metric := aws.StringValue(p.TargetTrackingConfiguration.PredefinedMetricSpecification.PredefinedMetricType)
+		if instanceBoundMetrics[metric] {
+			return fmt.Sprintf("target tracking policy %s on %s", name, metric), true
+		}
+	}
+	return "", false
+}
+
+// requiresSameSize determines if the instances of the group can only be
+// replaced with instance types of the same size, because of its scaling
+// policies.
+func (a *autoScalingGroup) requiresSameSize() bool {
+	return a != nil && a.config.SameSizeForScalingPolicies && len(a.sizeSensitivePolicies) > 0
+}
+
+// isSizeCompatible checks if the candidate instance type has the same number
+// of vCPUs and memory as the replaced instance, when the group requires it.
+func (i *instance) isSizeCompatible(candidate instanceTypeInformation) bool {
+	if !i.asg.requiresSameSize() {
+		return true
+	}
+	return candidate.vCPU == i.typeInfo.vCPU && candidate.memory == i.typeInfo.memory
+}
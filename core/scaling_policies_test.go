@@ -0,0 +1,149 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func targetTrackingPolicy(name, metric string) *autoscaling.ScalingPolicy {
+	return &autoscaling.ScalingPolicy{
+		PolicyName: aws.String(name),
+		PolicyType: aws.String("TargetTrackingScaling"),
+		TargetTrackingConfiguration: &autoscaling.TargetTrackingConfiguration{
+			PredefinedMetricSpecification: &autoscaling.PredefinedMetricSpecification{
+				PredefinedMetricType: aws.String(metric),
+			},
+		},
+	}
+}
+
+func Test_sizeSensitivity(t *testing.T) {
+	tests := []struct {
+		name              string
+		policy            *autoscaling.ScalingPolicy
+		expectedReason    string
+		expectedSensitive bool
+	}{
+		{
+			name:              "predictive scaling",
+			policy:            &autoscaling.ScalingPolicy{PolicyName: aws.String("forecast"), PolicyType: aws.String("PredictiveScaling")},
+			expectedReason:    "predictive scaling policy forecast",
+			expectedSensitive: true,
+		},
+		{
+			name:              "target tracking on network",
+			policy:            targetTrackingPolicy("net", autoscaling.MetricTypeAsgaverageNetworkIn),
+			expectedReason:    "target tracking policy net on ASGAverageNetworkIn",
+			expectedSensitive: true,
+		},
+		{
+			name: "target tracking on a custom metric",
+			policy: &autoscaling.ScalingPolicy{
+				PolicyName: aws.String("queue"),
+				PolicyType: aws.String("TargetTrackingScaling"),
+				TargetTrackingConfiguration: &autoscaling.TargetTrackingConfiguration{
+					CustomizedMetricSpecification: &autoscaling.CustomizedMetricSpecification{},
+				},
+			},
+		},
+		{
+			name:   "simple scaling",
+			policy: &autoscaling.ScalingPolicy{PolicyName: aws.String("step"), PolicyType: aws.String("SimpleScaling")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, sensitive := sizeSensitivity(tt.policy)
+			if reason != tt.expectedReason || sensitive != tt.expectedSensitive {
+				t.Errorf("sizeSensitivity() = %q, %v, expected %q, %v",
+					reason, sensitive, tt.expectedReason, tt.expectedSensitive)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadScalingPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		asg      mockASG
+		expected []string
+	}{
+		{
+			name: "size sensitive policies",
+			asg: mockASG{dpo: &autoscaling.DescribePoliciesOutput{
+				ScalingPolicies: []*autoscaling.ScalingPolicy{
+					targetTrackingPolicy("cpu", autoscaling.MetricTypeAsgaverageCpuutilization),
+					{PolicyName: aws.String("step"), PolicyType: aws.String("StepScaling")},
+				},
+			}},
+			expected: []string{"target tracking policy cpu on ASGAverageCPUUtilization"},
+		},
+		{name: "describe error", asg: mockASG{dperr: errors.New("describe")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:   "asg",
+				region: &region{services: connections{autoScaling: tt.asg}},
+			}
+			a.loadScalingPolicies()
+			// loaded only once
+			a.loadScalingPolicies()
+
+			if !reflect.DeepEqual(a.sizeSensitivePolicies, tt.expected) {
+				t.Errorf("sizeSensitivePolicies = %v, expected %v", a.sizeSensitivePolicies, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isSizeCompatible(t *testing.T) {
+	current := instanceTypeInformation{instanceType: "m5.large", vCPU: 2, memory: 8}
+
+	tests := []struct {
+		name      string
+		asg       *autoScalingGroup
+		candidate instanceTypeInformation
+		expected  bool
+	}{
+		{
+			name:      "not required",
+			asg:       &autoScalingGroup{config: AutoScalingConfig{SameSizeForScalingPolicies: true}},
+			candidate: instanceTypeInformation{instanceType: "m5.xlarge", vCPU: 4, memory: 16},
+			expected:  true,
+		},
+		{
+			name: "same size required",
+			asg: &autoScalingGroup{
+				config:                AutoScalingConfig{SameSizeForScalingPolicies: true},
+				sizeSensitivePolicies: []string{"predictive scaling policy forecast"},
+			},
+			candidate: instanceTypeInformation{instanceType: "m5.xlarge", vCPU: 4, memory: 16},
+			expected:  false,
+		},
+		{
+			name: "same size candidate",
+			asg: &autoScalingGroup{
+				config:                AutoScalingConfig{SameSizeForScalingPolicies: true},
+				sizeSensitivePolicies: []string{"predictive scaling policy forecast"},
+			},
+			candidate: instanceTypeInformation{instanceType: "m5a.large", vCPU: 2, memory: 8},
+			expected:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{typeInfo: current, asg: tt.asg}
+			if got := i.isSizeCompatible(tt.candidate); got != tt.expected {
+				t.Errorf("isSizeCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
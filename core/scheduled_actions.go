@@ -0,0 +1,85 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/robfig/cron/v3"
+)
+
+// nearScheduledAction determines if any scheduled action of the group runs
+// within the configured window around the current time, returning its name.
+// Swaps suspend some of the scaling processes of the group, which may break
+// the scheduled scaling activities happening meanwhile.
+func (a *autoScalingGroup) nearScheduledAction() (string, bool) {
+	window := a.region.conf.ScheduledActionWindow
+	if window <= 0 {
+		return "", false
+	}
+
+	var actions []*autoscaling.ScheduledUpdateGroupAction
+	err := a.region.services.autoScaling.DescribeScheduledActionsPages(
+		&autoscaling.DescribeScheduledActionsInput{
+			AutoScalingGroupName: aws.String(a.name),
+		},
+		func(page *autoscaling.DescribeScheduledActionsOutput, lastPage bool) bool {
+			actions = append(actions, page.ScheduledUpdateGroupActions...)
+			return true
+		})
+	if err != nil {
+		// better to proceed with the swap than to block it forever
+		log.Println(a.name, "Couldn't describe the scheduled actions:", err.Error())
+		return "", false
+	}
+
+	now := a.region.conf.getClock().Now()
+	for _, action := range actions {
+		if runsWithin(action, now.Add(-window), now.Add(window)) {
+			return aws.StringValue(action.ScheduledActionName), true
+		}
+	}
+	return "", false
+}
+
+// runsWithin determines if the scheduled action runs between the from and to
+// times, considering the recurrence of the recurring actions.
+func runsWithin(action *autoscaling.ScheduledUpdateGroupAction, from, to time.Time) bool {
+	start, end := aws.TimeValue(action.StartTime), aws.TimeValue(action.EndTime)
+
+	if aws.StringValue(action.Recurrence) == "" {
+		return !start.Before(from) && !start.After(to)
+	}
+
+	if (!start.IsZero() && start.After(to)) || (!end.IsZero() && end.Before(from)) {
+		return false
+	}
+
+	tz, err := time.LoadLocation(aws.StringValue(action.TimeZone))
+	if err != nil {
+		log.Println("Invalid time zone of the scheduled action", aws.StringValue(action.ScheduledActionName), err.Error())
+		tz = time.UTC
+	}
+
+	sched, err := cron.ParseStandard(aws.StringValue(action.Recurrence))
+	if err != nil {
+		log.Println("Invalid recurrence of the scheduled action", aws.StringValue(action.ScheduledActionName), err.Error())
+		return false
+	}
+
+	next := sched.Next(from.In(tz).Add(-time.Second))
+	return !next.After(to)
+}
+
+// scheduledActionError is returned when a swap is postponed because of a
+// nearby scheduled action of the group.
+func (a *autoScalingGroup) scheduledActionError(action string) error {
+	log.Printf("%s Postponing the swap, the scheduled action %s runs within %s",
+		a.name, action, a.region.conf.ScheduledActionWindow)
+	return fmt.Errorf("scheduled action %s of the group %s: %w", action, a.name, ErrScalingActivity)
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func Test_runsWithin(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	from, to := now.Add(-10*time.Minute), now.Add(10*time.Minute)
+
+	tests := []struct {
+		name     string
+		action   *autoscaling.ScheduledUpdateGroupAction
+		expected bool
+	}{
+		{
+			name:     "one-off action within the window",
+			action:   &autoscaling.ScheduledUpdateGroupAction{StartTime: aws.Time(now.Add(5 * time.Minute))},
+			expected: true,
+		},
+		{
+			name:     "one-off action outside the window",
+			action:   &autoscaling.ScheduledUpdateGroupAction{StartTime: aws.Time(now.Add(time.Hour))},
+			expected: false,
+		},
+		{
+			name:     "recurring action within the window",
+			action:   &autoscaling.ScheduledUpdateGroupAction{Recurrence: aws.String("5 12 * * *")},
+			expected: true,
+		},
+		{
+			name:     "recurring action which just ran",
+			action:   &autoscaling.ScheduledUpdateGroupAction{Recurrence: aws.String("55 11 * * *")},
+			expected: true,
+		},
+		{
+			name:     "recurring action outside the window",
+			action:   &autoscaling.ScheduledUpdateGroupAction{Recurrence: aws.String("0 18 * * *")},
+			expected: false,
+		},
+		{
+			name: "recurring action in another time zone",
+			action: &autoscaling.ScheduledUpdateGroupAction{
+				Recurrence: aws.String("0 14 * * *"),
+				TimeZone:   aws.String("Europe/Berlin"),
+			},
+			expected: true,
+		},
+		{
+			name: "recurring action which ended",
+			action: &autoscaling.ScheduledUpdateGroupAction{
+				Recurrence: aws.String("5 12 * * *"),
+				EndTime:    aws.Time(now.Add(-24 * time.Hour)),
+			},
+			expected: false,
+		},
+		{
+			name:     "invalid recurrence",
+			action:   &autoscaling.ScheduledUpdateGroupAction{Recurrence: aws.String("every day")},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runsWithin(tt.action, from, to); got != tt.expected {
+				t.Errorf("runsWithin() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_nearScheduledAction(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	actions := &autoscaling.DescribeScheduledActionsOutput{
+		ScheduledUpdateGroupActions: []*autoscaling.ScheduledUpdateGroupAction{
+			{ScheduledActionName: aws.String("nightly"), Recurrence: aws.String("0 0 * * *")},
+			{ScheduledActionName: aws.String("lunch"), Recurrence: aws.String("5 12 * * *")},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		window         time.Duration
+		asg            mockASG
+		expectedAction string
+		expectedNear   bool
+	}{
+		{name: "disabled", asg: mockASG{dsao: actions}},
+		{name: "near scheduled action", window: 10 * time.Minute, asg: mockASG{dsao: actions},
+			expectedAction: "lunch", expectedNear: true},
+		{name: "no scheduled actions nearby", window: time.Minute, asg: mockASG{dsao: actions}},
+		{name: "describe error", window: 10 * time.Minute, asg: mockASG{dsaerr: errors.New("describe")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "asg",
+				region: &region{
+					conf: &Config{
+						clock:                 &mockClock{now: now},
+						ScheduledActionWindow: tt.window,
+					},
+					services: connections{autoScaling: tt.asg},
+				},
+			}
+			action, near := a.nearScheduledAction()
+			if action != tt.expectedAction || near != tt.expectedNear {
+				t.Errorf("nearScheduledAction() = %q, %v, expected %q, %v",
+					action, near, tt.expectedAction, tt.expectedNear)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+)
+
+// ServiceDiscoveryTag is the name of the tag set on the AutoScaling Group with
+// the AWS Cloud Map service its instances are registered to, given either as
+// namespace/service names or as the ID of the service.
+const ServiceDiscoveryTag = "autospotting_service_discovery"
+
+// The attributes of the Cloud Map instances identifying the EC2 instances.
+const (
+	cloudMapIPv4Attribute       = "AWS_INSTANCE_IPV4"
+	cloudMapIPv6Attribute       = "AWS_INSTANCE_IPV6"
+	cloudMapInstanceIDAttribute = "AWS_EC2_INSTANCE_ID"
+)
+
+// serviceDiscoveryService returns the ID of the Cloud Map service configured
+// on the group, which is empty when the tag isn't set. The namespace and
+// service names are resolved to the ID of the service.
+func (a *autoScalingGroup) serviceDiscoveryService() (string, error) {
+	if a.Group == nil {
+		return "", nil
+	}
+
+	tagValue := a.getOwnTagValue(ServiceDiscoveryTag)
+	if tagValue == nil || *tagValue == "" {
+		return "", nil
+	}
+
+	if !strings.Contains(*tagValue, "/") {
+		return *tagValue, nil
+	}
+
+	parts := strings.SplitN(*tagValue, "/", 2)
+	namespace, service := parts[0], parts[1]
+	svc := a.region.services.serviceDiscovery
+
+	var namespaceID string
+	err := svc.ListNamespacesPages(&servicediscovery.ListNamespacesInput{},
+		func(page *servicediscovery.ListNamespacesOutput, lastPage bool) bool {
+			for _, ns := range page.Namespaces {
+				if aws.StringValue(ns.Name) == namespace {
+					namespaceID = aws.StringValue(ns.Id)
+					return false
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return "", err
+	}
+	if namespaceID == "" {
+		return "", fmt.Errorf("couldn't find the Cloud Map namespace %s", namespace)
+	}
+
+	var serviceID string
+	err = svc.ListServicesPages(&servicediscovery.ListServicesInput{
+		Filters: []*servicediscovery.ServiceFilter{{
+			Name:      aws.String(servicediscovery.ServiceFilterNameNamespaceId),
+			Condition: aws.String(servicediscovery.FilterConditionEq),
+			Values:    []*string{aws.String(namespaceID)},
+		}},
+	}, func(page *servicediscovery.ListServicesOutput, lastPage bool) bool {
+		for _, s := range page.Services {
+			if aws.StringValue(s.Name) == service {
+				serviceID = aws.StringValue(s.Id)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	if serviceID == "" {
+		return "", fmt.Errorf("couldn't find the Cloud Map service %s in the namespace %s", service, namespace)
+	}
+	return serviceID, nil
+}
+
+// isCloudMapNotFound determines if the Cloud Map service or instance doesn't
+// exist.
+func isCloudMapNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) &&
+		(aerr.Code() == servicediscovery.ErrCodeInstanceNotFound ||
+			aerr.Code() == servicediscovery.ErrCodeServiceNotFound)
+}
+
+// registerInServiceDiscovery registers the spot instance to the Cloud Map
+// service configured on the group once it's healthy, if the on-demand instance
+// it replaces is registered to it. The attributes of the on-demand instance
+// are copied, apart from the ones identifying the EC2 instance. An error is
+// returned when the spot instance couldn't be registered, so that the
+// on-demand instance is kept until a later run.
+func (a *autoScalingGroup) registerInServiceDiscovery(spot *instance, odInstanceID string) error {
+	serviceID, err := a.serviceDiscoveryService()
+	if err != nil {
+		log.Printf("Couldn't determine the Cloud Map service of the group %s: %s", a.name, err.Error())
+		return err
+	}
+	if serviceID == "" {
+		return nil
+	}
+
+	svc := a.region.services.serviceDiscovery
+
+	out, err := svc.GetInstance(&servicediscovery.GetInstanceInput{
+		ServiceId:  aws.String(serviceID),
+		InstanceId: aws.String(odInstanceID),
+	})
+	if isCloudMapNotFound(err) {
+		log.Printf("On-demand instance %s isn't registered to the Cloud Map service %s", odInstanceID, serviceID)
+		return nil
+	}
+	if err != nil {
+		log.Printf("Couldn't describe the Cloud Map instance %s: %s", odInstanceID, err.Error())
+		return err
+	}
+
+	spotID := aws.StringValue(spot.InstanceId)
+	attributes := make(map[string]*string)
+	if out.Instance != nil {
+		for key, value := range out.Instance.Attributes {
+			attributes[key] = value
+		}
+	}
+
+	if _, found := attributes[cloudMapIPv4Attribute]; found {
+		attributes[cloudMapIPv4Attribute] = spot.PrivateIpAddress
+	}
+	if _, found := attributes[cloudMapIPv6Attribute]; found {
+		delete(attributes, cloudMapIPv6Attribute)
+		if ipv6 := spot.ipv6Address(); ipv6 != "" {
+			attributes[cloudMapIPv6Attribute] = aws.String(ipv6)
+		}
+	}
+	if _, found := attributes[cloudMapInstanceIDAttribute]; found {
+		attributes[cloudMapInstanceIDAttribute] = spot.InstanceId
+	}
+
+	log.Printf("Registering spot instance %s to the Cloud Map service %s", spotID, serviceID)
+	if _, err := svc.RegisterInstance(&servicediscovery.RegisterInstanceInput{
+		ServiceId:  aws.String(serviceID),
+		InstanceId: spot.InstanceId,
+		Attributes: attributes,
+	}); err != nil {
+		log.Printf("Couldn't register spot instance %s to the Cloud Map service %s: %s",
+			spotID, serviceID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// deregisterFromServiceDiscovery deregisters the on-demand instance from the
+// Cloud Map service configured on the group before it's terminated. The
+// instance is terminated anyway when that fails.
+func (a *autoScalingGroup) deregisterFromServiceDiscovery(odInstanceID string) {
+	serviceID, err := a.serviceDiscoveryService()
+	if err != nil {
+		log.Printf("Couldn't determine the Cloud Map service of the group %s: %s", a.name, err.Error())
+		return
+	}
+	if serviceID == "" {
+		return
+	}
+
+	_, err = a.region.services.serviceDiscovery.DeregisterInstance(&servicediscovery.DeregisterInstanceInput{
+		ServiceId:  aws.String(serviceID),
+		InstanceId: aws.String(odInstanceID),
+	})
+	switch {
+	case isCloudMapNotFound(err):
+		return
+	case err != nil:
+		log.Printf("Couldn't deregister on-demand instance %s from the Cloud Map service %s: %s",
+			odInstanceID, serviceID, err.Error())
+	default:
+		log.Printf("Deregistered on-demand instance %s from the Cloud Map service %s", odInstanceID, serviceID)
+	}
+}
+
+// ipv6Address returns the first IPv6 address of the instance, if any.
+func (i *instance) ipv6Address() string {
+	for _, ni := range i.NetworkInterfaces {
+		for _, addr := range ni.Ipv6Addresses {
+			if ip := aws.StringValue(addr.Ipv6Address); ip != "" {
+				return ip
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/servicediscovery"
+)
+
+func Test_autoScalingGroup_serviceDiscoveryService(t *testing.T) {
+	sd := mockServiceDiscovery{
+		lno: &servicediscovery.ListNamespacesOutput{
+			Namespaces: []*servicediscovery.NamespaceSummary{
+				{Name: aws.String("other.local"), Id: aws.String("ns-other")},
+				{Name: aws.String("example.local"), Id: aws.String("ns-1")},
+			},
+		},
+		lso: &servicediscovery.ListServicesOutput{
+			Services: []*servicediscovery.ServiceSummary{
+				{Name: aws.String("api"), Id: aws.String("srv-api")},
+				{Name: aws.String("web"), Id: aws.String("srv-web")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		tagValue    *string
+		expected    string
+		expectedErr bool
+	}{
+		{name: "no tag"},
+		{name: "service ID", tagValue: aws.String("srv-123"), expected: "srv-123"},
+		{name: "namespace and service names", tagValue: aws.String("example.local/web"), expected: "srv-web"},
+		{name: "unknown namespace", tagValue: aws.String("missing.local/web"), expectedErr: true},
+		{name: "unknown service", tagValue: aws.String("example.local/db"), expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:   "asg",
+				Group:  &autoscaling.Group{},
+				region: &region{conf: &Config{}, services: connections{serviceDiscovery: sd}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(ServiceDiscoveryTag), Value: tt.tagValue}}
+			}
+
+			got, err := a.serviceDiscoveryService()
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("serviceDiscoveryService() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if got != tt.expected {
+				t.Errorf("serviceDiscoveryService() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_registerInServiceDiscovery(t *testing.T) {
+	notFound := awserr.New(servicediscovery.ErrCodeInstanceNotFound, "not found", nil)
+
+	tests := []struct {
+		name               string
+		tagValue           *string
+		gio                *servicediscovery.GetInstanceOutput
+		gierr              error
+		rierr              error
+		expectedAttributes map[string]*string
+		expectedErr        bool
+	}{
+		{name: "no service configured"},
+		{
+			name:     "on-demand instance not registered",
+			tagValue: aws.String("srv-1"),
+			gierr:    notFound,
+		},
+		{
+			name:     "registered on-demand instance",
+			tagValue: aws.String("srv-1"),
+			gio: &servicediscovery.GetInstanceOutput{Instance: &servicediscovery.Instance{
+				Id: aws.String("i-od"),
+				Attributes: map[string]*string{
+					"AWS_INSTANCE_IPV4":   aws.String("10.0.0.1"),
+					"AWS_INSTANCE_IPV6":   aws.String("2001:db8::1"),
+					"AWS_INSTANCE_PORT":   aws.String("8080"),
+					"AWS_EC2_INSTANCE_ID": aws.String("i-od"),
+					"stage":               aws.String("prod"),
+				},
+			}},
+			expectedAttributes: map[string]*string{
+				"AWS_INSTANCE_IPV4":   aws.String("10.0.0.2"),
+				"AWS_INSTANCE_PORT":   aws.String("8080"),
+				"AWS_EC2_INSTANCE_ID": aws.String("i-spot"),
+				"stage":               aws.String("prod"),
+			},
+		},
+		{
+			name:        "describe error",
+			tagValue:    aws.String("srv-1"),
+			gierr:       errors.New("throttled"),
+			expectedErr: true,
+		},
+		{
+			name:     "registration error",
+			tagValue: aws.String("srv-1"),
+			gio: &servicediscovery.GetInstanceOutput{Instance: &servicediscovery.Instance{
+				Attributes: map[string]*string{"AWS_INSTANCE_IPV4": aws.String("10.0.0.1")},
+			}},
+			rierr:              errors.New("access denied"),
+			expectedAttributes: map[string]*string{"AWS_INSTANCE_IPV4": aws.String("10.0.0.2")},
+			expectedErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var registered []*servicediscovery.RegisterInstanceInput
+
+			a := &autoScalingGroup{
+				name:  "asg",
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{}, services: connections{
+					serviceDiscovery: mockServiceDiscovery{
+						gio: tt.gio, gierr: tt.gierr, rierr: tt.rierr, riin: &registered,
+					},
+				}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(ServiceDiscoveryTag), Value: tt.tagValue}}
+			}
+
+			spot := &instance{Instance: &ec2.Instance{
+				InstanceId:       aws.String("i-spot"),
+				PrivateIpAddress: aws.String("10.0.0.2"),
+			}}
+
+			err := a.registerInServiceDiscovery(spot, "i-od")
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("registerInServiceDiscovery() error = %v, expected error %v", err, tt.expectedErr)
+			}
+
+			if tt.expectedAttributes == nil {
+				if len(registered) != 0 {
+					t.Errorf("registerInServiceDiscovery() registered %v, expected no registration", registered)
+				}
+				return
+			}
+			if len(registered) != 1 {
+				t.Fatalf("registerInServiceDiscovery() registered %d instances, expected 1", len(registered))
+			}
+			if got := aws.StringValue(registered[0].InstanceId); got != "i-spot" {
+				t.Errorf("registered instance %s, expected i-spot", got)
+			}
+			if !reflect.DeepEqual(registered[0].Attributes, tt.expectedAttributes) {
+				t.Errorf("registered attributes %v, expected %v", registered[0].Attributes, tt.expectedAttributes)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_deregisterFromServiceDiscovery(t *testing.T) {
+	var deregistered []*servicediscovery.DeregisterInstanceInput
+
+	a := &autoScalingGroup{
+		name: "asg",
+		Group: &autoscaling.Group{Tags: []*autoscaling.TagDescription{
+			{Key: aws.String(ServiceDiscoveryTag), Value: aws.String("srv-1")},
+		}},
+		region: &region{conf: &Config{}, services: connections{
+			serviceDiscovery: mockServiceDiscovery{diin: &deregistered},
+		}},
+	}
+
+	a.deregisterFromServiceDiscovery("i-od")
+
+	expected := []*servicediscovery.DeregisterInstanceInput{
+		{ServiceId: aws.String("srv-1"), InstanceId: aws.String("i-od")},
+	}
+	if !reflect.DeepEqual(deregistered, expected) {
+		t.Errorf("deregisterFromServiceDiscovery() = %v, expected %v", deregistered, expected)
+	}
+}
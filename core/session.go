@@ -0,0 +1,102 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// newSession creates an AWS session in the given region, using the HTTP client
+// configured with the proxy and CA bundle settings. The session assumes the
+// role of the member account when processing an organization.
+func newSession(region string, conf *Config) (*session.Session, error) {
+	client, err := newHTTPClient(conf)
+	if err != nil {
+		log.Println("Failed to configure the HTTP client:", err.Error())
+		return nil, err
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:     aws.String(region),
+		HTTPClient: client,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf != nil && conf.roleARN != "" {
+		sess.Config.Credentials = stscreds.NewCredentials(sess, conf.roleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = organizationRoleSessionName
+		})
+	}
+
+	if conf != nil {
+		conf.apiRecorder.attach(&sess.Handlers)
+		conf.apiCalls.attach(&sess.Handlers)
+	}
+	return sess, nil
+}
+
+// newHTTPClient builds the HTTP client used for all the AWS API calls. It
+// honors the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables unless
+// an explicit proxy is configured, and trusts the certificates from the custom
+// CA bundle in addition to the system ones, as needed when egressing through
+// TLS-inspecting proxies.
+func newHTTPClient(conf *Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if conf == nil {
+		return &http.Client{Transport: transport}, nil
+	}
+
+	if conf.ProxyURL != "" {
+		u, err := url.Parse(conf.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %s", err.Error())
+		}
+		debug.Println("Using proxy", u.Host)
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	if conf.CustomCABundle != "" {
+		pool, err := loadCertPool(conf.CustomCABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// loadCertPool returns the system certificate pool extended with the
+// certificates found in the given PEM file.
+func loadCertPool(bundle string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle %s: %s", bundle, err.Error())
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		debug.Println("Couldn't load the system certificate pool, using only the CA bundle")
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid certificates found in CA bundle %s", bundle)
+	}
+	return pool, nil
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func Test_newHTTPClient_proxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     *Config
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "no configuration",
+			conf:     nil,
+			expected: "",
+		},
+		{
+			name:     "explicit proxy",
+			conf:     &Config{ProxyURL: "http://proxy.example.com:3128"},
+			expected: "http://proxy.example.com:3128",
+		},
+		{
+			name:    "invalid proxy",
+			conf:    &Config{ProxyURL: "://proxy"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newHTTPClient(tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newHTTPClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, "https://ec2.us-east-1.amazonaws.com", nil)
+			proxy, err := client.Transport.(*http.Transport).Proxy(req)
+			if err != nil {
+				t.Fatalf("unexpected proxy error: %v", err)
+			}
+
+			got := ""
+			if proxy != nil {
+				got = proxy.String()
+			}
+			if tt.expected != "" && got != tt.expected {
+				t.Errorf("newHTTPClient() proxy = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_newHTTPClient_customCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	f, err := ioutil.TempFile("", "ca-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	f.Close()
+
+	client, err := newHTTPClient(&Config{CustomCABundle: f.Name()})
+	if err != nil {
+		t.Fatalf("newHTTPClient() unexpected error: %v", err)
+	}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed, the CA bundle isn't trusted: %v", err)
+	}
+	resp.Body.Close()
+
+	// the server's certificate isn't trusted by default
+	defaultClient, _ := newHTTPClient(&Config{})
+	if _, err := defaultClient.Get(server.URL); err == nil {
+		t.Errorf("request succeeded without the CA bundle")
+	}
+}
+
+func Test_newHTTPClient_invalidCABundle(t *testing.T) {
+	f, err := ioutil.TempFile("", "ca-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("not a certificate")
+	f.Close()
+
+	for _, bundle := range []string{f.Name(), "/nonexistent/ca-bundle.pem"} {
+		if _, err := newHTTPClient(&Config{CustomCABundle: bundle}); err == nil {
+			t.Errorf("newHTTPClient() expected error for CA bundle %s", bundle)
+		}
+	}
+}
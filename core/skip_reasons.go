@@ -0,0 +1,185 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// SkipReasonsTag is set on the groups having on-demand instances which
+// couldn't be replaced by the last run, recording how many of them were
+// skipped for each reason, so that it's persisted across runs.
+const SkipReasonsTag = "autospotting_skip_reasons"
+
+// The reasons for which the on-demand instances are skipped.
+const (
+	skipProtectedFromScaleIn     = "protected-from-scale-in"
+	skipProtectedFromTermination = "protected-from-termination"
+	skipDedicatedTenancy         = "dedicated-tenancy"
+	skipDedicatedHost            = "dedicated-host"
+	skipPriceIncompatible        = "price-incompatible"
+	skipAllowedListMismatch      = "allowed-list-mismatch"
+	skipNoCapacity               = "no-capacity"
+	skipKubernetesAutoscaler     = "kubernetes-autoscaler"
+)
+
+// skipReasons counts the on-demand instances of a group skipped for each
+// reason during a run.
+type skipReasons struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+// skipReasonOf determines the skip reason corresponding to the error returned
+// when replacing an on-demand instance, if any.
+func skipReasonOf(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrNotPriceCompatible):
+		return skipPriceIncompatible
+	case errors.Is(err, ErrNoCapacity):
+		return skipNoCapacity
+	}
+	return ""
+}
+
+// compatibilitySkipReason determines why no compatible spot instance types
+// were found for the instance, telling apart the allowed and disallowed
+// instance types lists excluding all the instance types of the region.
+func (i *instance) compatibilitySkipReason(err error, allowedList, disallowedList []string) string {
+	reason := skipReasonOf(err)
+	if reason != skipPriceIncompatible {
+		return reason
+	}
+
+	for instanceType := range i.region.instanceTypeInformation {
+		if i.isAllowed(instanceType, allowedList, disallowedList) {
+			return reason
+		}
+	}
+	return skipAllowedListMismatch
+}
+
+// recordSkipReason counts an on-demand instance skipped for the given reason.
+func (a *autoScalingGroup) recordSkipReason(reason string) {
+	if reason == "" {
+		return
+	}
+
+	a.skipReasons.Lock()
+	defer a.skipReasons.Unlock()
+
+	if a.skipReasons.counts == nil {
+		a.skipReasons.counts = make(map[string]int)
+	}
+	a.skipReasons.counts[reason]++
+}
+
+// recordProtectedInstances counts the running on-demand instances of the
+// group which are skipped because of their protection or tenancy.
+func (a *autoScalingGroup) recordProtectedInstances() {
+	for _, i := range a.instances.instances() {
+		if i.isSpot() || i.stateName() != ec2.InstanceStateNameRunning {
+			continue
+		}
+
+		switch {
+		case a.isBlockedByScaleInProtection(i):
+			a.recordSkipReason(skipProtectedFromScaleIn)
+		case a.isBlockedByTerminationProtection(i):
+			a.recordSkipReason(skipProtectedFromTermination)
+		case i.isOnDedicatedHost():
+			a.recordSkipReason(skipDedicatedHost)
+		case !i.isTenancyReplaceable():
+			a.recordSkipReason(skipDedicatedTenancy)
+		case a.isBlockedByKubernetesAutoscaler():
+			a.recordSkipReason(skipKubernetesAutoscaler)
+		}
+	}
+}
+
+// formatSkipReasons renders the skip reasons sorted by name, as stored in the
+// group tag.
+func formatSkipReasons(counts map[string]int) string {
+	reasons := make([]string, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, count))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, " ")
+}
+
+// parseSkipReasons parses the skip reasons stored in the group tag, ignoring
+// the malformed entries.
+func parseSkipReasons(value string) map[string]int {
+	counts := make(map[string]int)
+	for _, entry := range strings.Fields(value) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if count, err := strconv.Atoi(parts[1]); err == nil {
+			counts[parts[0]] += count
+		}
+	}
+	return counts
+}
+
+// saveSkipReasons persists the skip reasons of the current run in the group
+// tag, or removes the tag once no instances are skipped anymore. The tag is
+// only updated when its value changes.
+func (a *autoScalingGroup) saveSkipReasons() {
+	a.skipReasons.Lock()
+	value := formatSkipReasons(a.skipReasons.counts)
+	a.skipReasons.Unlock()
+
+	current := a.getOwnTagValue(SkipReasonsTag)
+	if (current == nil && value == "") || (current != nil && *current == value) {
+		return
+	}
+
+	var err error
+	if value == "" {
+		_, err = a.region.services.autoScaling.DeleteTags(&autoscaling.DeleteTagsInput{
+			Tags: []*autoscaling.Tag{a.groupTag(SkipReasonsTag, "")},
+		})
+	} else {
+		log.Println(a.name, "Skipped on-demand instances:", value)
+		_, err = a.region.services.autoScaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+			Tags: []*autoscaling.Tag{a.groupTag(SkipReasonsTag, value)},
+		})
+	}
+	if err != nil {
+		log.Println(a.name, "Couldn't save the skip reasons:", err.Error())
+	}
+}
+
+// skipReasonsReport aggregates the skip reasons of the groups, as reported by
+// the analysis.
+func skipReasonsReport(results []groupAnalysis) string {
+	total := make(map[string]int)
+	for _, r := range results {
+		for reason, count := range parseSkipReasons(r.skipReasons) {
+			total[reason] += count
+		}
+	}
+	return formatSkipReasons(total)
+}
+
+// lastSkipReasons returns the skip reasons recorded by the last run which
+// processed the group.
+func (a *autoScalingGroup) lastSkipReasons() string {
+	return aws.StringValue(a.getOwnTagValue(SkipReasonsTag))
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_skipReasonOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "no error"},
+		{name: "price", err: ErrNotPriceCompatible, expected: skipPriceIncompatible},
+		{
+			name:     "capacity",
+			err:      &launchError{reason: capacityLaunchFailure, err: errors.New("InsufficientInstanceCapacity")},
+			expected: skipNoCapacity,
+		},
+		{name: "wrapped capacity", err: fmt.Errorf("launch: %w", ErrNoCapacity), expected: skipNoCapacity},
+		{name: "other error", err: errors.New("other")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := skipReasonOf(tt.err); got != tt.expected {
+				t.Errorf("skipReasonOf() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_compatibilitySkipReason(t *testing.T) {
+	i := &instance{region: &region{instanceTypeInformation: map[string]instanceTypeInformation{
+		"m5.large": {instanceType: "m5.large"},
+		"c5.large": {instanceType: "c5.large"},
+	}}}
+
+	tests := []struct {
+		name           string
+		err            error
+		allowedList    []string
+		disallowedList []string
+		expected       string
+	}{
+		{name: "no lists", err: ErrNotPriceCompatible, expected: skipPriceIncompatible},
+		{name: "allowed types", err: ErrNotPriceCompatible, allowedList: []string{"m5.*"}, expected: skipPriceIncompatible},
+		{name: "no allowed types", err: ErrNotPriceCompatible, allowedList: []string{"r5.*"}, expected: skipAllowedListMismatch},
+		{name: "all disallowed", err: ErrNotPriceCompatible, disallowedList: []string{"*.large"}, expected: skipAllowedListMismatch},
+		{name: "other error", err: errors.New("other"), allowedList: []string{"r5.*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := i.compatibilitySkipReason(tt.err, tt.allowedList, tt.disallowedList); got != tt.expected {
+				t.Errorf("compatibilitySkipReason() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_recordProtectedInstances(t *testing.T) {
+	running := &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)}
+
+	a := &autoScalingGroup{
+		name: "asg",
+		Group: &autoscaling.Group{Instances: []*autoscaling.Instance{
+			{InstanceId: aws.String("i-1"), ProtectedFromScaleIn: aws.Bool(true)},
+			{InstanceId: aws.String("i-2"), ProtectedFromScaleIn: aws.Bool(true)},
+			{InstanceId: aws.String("i-5"), ProtectedFromScaleIn: aws.Bool(true)},
+		}},
+		instances: makeInstances(),
+		config:    AutoScalingConfig{SkipTerminationProtectionCheck: true},
+	}
+	for _, i := range []*instance{
+		{Instance: &ec2.Instance{InstanceId: aws.String("i-1"), State: running}},
+		{Instance: &ec2.Instance{InstanceId: aws.String("i-2"), State: running}},
+		{Instance: &ec2.Instance{
+			InstanceId: aws.String("i-3"),
+			State:      running,
+			Placement:  &ec2.Placement{Tenancy: aws.String(ec2.TenancyDedicated)},
+		}},
+		{Instance: &ec2.Instance{InstanceId: aws.String("i-4"), State: running}},
+		{Instance: &ec2.Instance{
+			InstanceId: aws.String("i-6"),
+			State:      running,
+			Placement:  &ec2.Placement{Tenancy: aws.String(ec2.TenancyHost), HostId: aws.String("h-123")},
+		}},
+		{Instance: &ec2.Instance{InstanceId: aws.String("i-5"), State: running, InstanceLifecycle: aws.String(Spot)}},
+	} {
+		i.asg, i.region = a, &region{name: "us-east-1"}
+		a.instances.add(i)
+	}
+
+	a.recordProtectedInstances()
+
+	expected := map[string]int{skipProtectedFromScaleIn: 2, skipDedicatedTenancy: 1, skipDedicatedHost: 1}
+	if !reflect.DeepEqual(a.skipReasons.counts, expected) {
+		t.Errorf("skip reasons = %v, expected %v", a.skipReasons.counts, expected)
+	}
+}
+
+func Test_parseSkipReasons(t *testing.T) {
+	counts := map[string]int{skipNoCapacity: 2, skipProtectedFromScaleIn: 1}
+
+	value := formatSkipReasons(counts)
+	if value != "no-capacity=2 protected-from-scale-in=1" {
+		t.Errorf("formatSkipReasons() = %q", value)
+	}
+
+	if got := parseSkipReasons(value + " malformed other=x"); !reflect.DeepEqual(got, counts) {
+		t.Errorf("parseSkipReasons() = %v, expected %v", got, counts)
+	}
+}
+
+func Test_autoScalingGroup_saveSkipReasons(t *testing.T) {
+	tests := []struct {
+		name            string
+		tagValue        *string
+		counts          map[string]int
+		expectedUpdates int
+		expectedDeletes int
+	}{
+		{name: "nothing skipped"},
+		{name: "newly skipped", counts: map[string]int{skipNoCapacity: 1}, expectedUpdates: 1},
+		{name: "unchanged", tagValue: aws.String("no-capacity=1"), counts: map[string]int{skipNoCapacity: 1}},
+		{name: "changed", tagValue: aws.String("no-capacity=1"), counts: map[string]int{skipNoCapacity: 2}, expectedUpdates: 1},
+		{name: "no longer skipped", tagValue: aws.String("no-capacity=1"), expectedDeletes: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updates, deletes int
+			a := &autoScalingGroup{
+				name:  "asg",
+				Group: &autoscaling.Group{},
+				region: &region{services: connections{autoScaling: mockASG{
+					coutcalls: &updates,
+					deltcalls: &deletes,
+				}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(SkipReasonsTag), Value: tt.tagValue}}
+			}
+			a.skipReasons.counts = tt.counts
+
+			a.saveSkipReasons()
+
+			if updates != tt.expectedUpdates || deletes != tt.expectedDeletes {
+				t.Errorf("saveSkipReasons() updated the tag %d times and deleted it %d times, expected %d and %d",
+					updates, deletes, tt.expectedUpdates, tt.expectedDeletes)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// DefaultSnapshotRetention is how long the snapshots of the replaced
+// on-demand instances are kept for by default
+const DefaultSnapshotRetention = 7 * 24 * time.Hour
+
+// snapshotReplacedInstance snapshots all the EBS volumes of the on-demand
+// instance replaced by the spot instance before it's terminated, when enabled
+// for the group. The snapshots are tagged with the replacement context and
+// with their expiration time, after which they're deleted by a later run.
+func (a *autoScalingGroup) snapshotReplacedInstance(odInstanceID, spotInstanceID string) error {
+	if !a.config.SnapshotBeforeTerminate {
+		return nil
+	}
+
+	conf := a.region.conf
+	expiresAt := conf.getClock().Now().Add(conf.SnapshotRetention)
+
+	log.Printf("%s Snapshotting the volumes of on-demand instance %s before terminating it",
+		a.name, odInstanceID)
+
+	out, err := a.region.services.ec2.CreateSnapshots(&ec2.CreateSnapshotsInput{
+		Description: aws.String(fmt.Sprintf("Volumes of %s replaced by %s in %s",
+			odInstanceID, spotInstanceID, a.name)),
+		InstanceSpecification: &ec2.InstanceSpecification{
+			InstanceId: aws.String(odInstanceID),
+		},
+		CopyTagsFromSource: aws.String(ec2.CopyTagsFromSourceVolume),
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeSnapshot),
+			Tags: []*ec2.Tag{
+				{Key: aws.String(conf.tagKey(snapshotOfInstanceTag)), Value: aws.String(odInstanceID)},
+				{Key: aws.String(conf.tagKey(snapshotOfASGTag)), Value: aws.String(a.name)},
+				{Key: aws.String(conf.tagKey(replacedBySpotInstanceTag)), Value: aws.String(spotInstanceID)},
+				{Key: aws.String(conf.tagKey(snapshotExpiresAtTag)), Value: aws.String(expiresAt.UTC().Format(time.RFC3339))},
+			},
+		}},
+	})
+	if err != nil {
+		log.Printf("%s Couldn't snapshot the volumes of on-demand instance %s: %s",
+			a.name, odInstanceID, err.Error())
+		return fmt.Errorf("couldn't snapshot the volumes of on-demand instance %s: %w", odInstanceID, err)
+	}
+
+	var ids []string
+	for _, s := range out.Snapshots {
+		ids = append(ids, aws.StringValue(s.SnapshotId))
+	}
+	log.Printf("%s Created the snapshots %s of on-demand instance %s, expiring at %s",
+		a.name, strings.Join(ids, ","), odInstanceID, expiresAt.Format(time.RFC3339))
+	return nil
+}
+
+// snapshotsEnabled determines if the snapshots of the replaced instances are
+// enabled globally or for any of the enabled groups of the region.
+func (r *region) snapshotsEnabled() bool {
+	if r.conf.SnapshotBeforeTerminate {
+		return true
+	}
+
+	r.enabledASGsLock.RLock()
+	defer r.enabledASGsLock.RUnlock()
+
+	for _, asg := range r.enabledASGs {
+		if asg.config.SnapshotBeforeTerminate {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteExpiredSnapshots deletes the snapshots of the replaced instances
+// which are past their expiration time.
+func (r *region) deleteExpiredSnapshots() {
+	now := r.conf.getClock().Now()
+	expiresAtTag := r.conf.tagKey(snapshotExpiresAtTag)
+
+	var expired []*ec2.Snapshot
+	err := r.services.ec2.DescribeSnapshotsPages(&ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("tag-key"),
+			Values: []*string{aws.String(expiresAtTag)},
+		}},
+	}, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		for _, s := range page.Snapshots {
+			if isSnapshotExpired(s, expiresAtTag, now) {
+				expired = append(expired, s)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		log.Println(r.name, "Couldn't describe the snapshots of the replaced instances:", err.Error())
+		return
+	}
+
+	for _, s := range expired {
+		id := aws.StringValue(s.SnapshotId)
+		log.Println(r.name, "Deleting expired snapshot", id)
+		if _, err := r.services.ec2.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: s.SnapshotId}); err != nil {
+			log.Println(r.name, "Couldn't delete expired snapshot", id+":", err.Error())
+		}
+	}
+}
+
+// isSnapshotExpired determines if the snapshot is past the expiration time
+// set by its tag, keeping the snapshots whose expiration time is invalid.
+func isSnapshotExpired(s *ec2.Snapshot, expiresAtTag string, now time.Time) bool {
+	for _, tag := range s.Tags {
+		if aws.StringValue(tag.Key) != expiresAtTag {
+			continue
+		}
+
+		expiresAt, err := time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+		if err != nil {
+			log.Println("Ignoring the invalid expiration time of snapshot",
+				aws.StringValue(s.SnapshotId)+":", err.Error())
+			return false
+		}
+		return !now.Before(expiresAt)
+	}
+	return false
+}
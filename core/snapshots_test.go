@@ -0,0 +1,192 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_snapshotReplacedInstance(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		enabled       bool
+		csserr        error
+		wantErr       bool
+		wantSnapshots int
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:          "enabled",
+			enabled:       true,
+			wantSnapshots: 1,
+		},
+		{
+			name:          "failing snapshots",
+			enabled:       true,
+			csserr:        errors.New("snapshot limit exceeded"),
+			wantErr:       true,
+			wantSnapshots: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inputs []*ec2.CreateSnapshotsInput
+			a := &autoScalingGroup{
+				name: "asg",
+				region: &region{
+					name: "us-east-1",
+					conf: &Config{
+						SnapshotRetention: 48 * time.Hour,
+						clock:             &mockClock{now: now},
+					},
+					services: connections{ec2: mockEC2{
+						csso: &ec2.CreateSnapshotsOutput{
+							Snapshots: []*ec2.SnapshotInfo{{SnapshotId: aws.String("snap-1")}},
+						},
+						csserr: tt.csserr,
+						cssin:  &inputs,
+					}},
+				},
+				config: AutoScalingConfig{SnapshotBeforeTerminate: tt.enabled},
+			}
+
+			err := a.snapshotReplacedInstance("i-ondemand", "i-spot")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("snapshotReplacedInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(inputs) != tt.wantSnapshots {
+				t.Fatalf("snapshotReplacedInstance() created %d snapshots, expected %d", len(inputs), tt.wantSnapshots)
+			}
+			if tt.wantSnapshots == 0 {
+				return
+			}
+
+			in := inputs[0]
+			if aws.StringValue(in.InstanceSpecification.InstanceId) != "i-ondemand" {
+				t.Errorf("snapshotReplacedInstance() snapshotted %s, expected i-ondemand",
+					aws.StringValue(in.InstanceSpecification.InstanceId))
+			}
+
+			expectedTags := []*ec2.Tag{
+				{Key: aws.String("snapshot-of-instance"), Value: aws.String("i-ondemand")},
+				{Key: aws.String("snapshot-of-asg"), Value: aws.String("asg")},
+				{Key: aws.String("replaced-by-spot-instance"), Value: aws.String("i-spot")},
+				{Key: aws.String("snapshot-expires-at"), Value: aws.String("2021-01-03T00:00:00Z")},
+			}
+			if !reflect.DeepEqual(in.TagSpecifications[0].Tags, expectedTags) {
+				t.Errorf("snapshotReplacedInstance() tags = %v, expected %v", in.TagSpecifications[0].Tags, expectedTags)
+			}
+		})
+	}
+}
+
+func Test_region_deleteExpiredSnapshots(t *testing.T) {
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	snapshot := func(id string, expiresAt string) *ec2.Snapshot {
+		return &ec2.Snapshot{
+			SnapshotId: aws.String(id),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("Name"), Value: aws.String("data")},
+				{Key: aws.String(snapshotExpiresAtTag), Value: aws.String(expiresAt)},
+			},
+		}
+	}
+
+	var deleted []*ec2.DeleteSnapshotInput
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{clock: &mockClock{now: now}},
+		services: connections{ec2: mockEC2{
+			dspo: &ec2.DescribeSnapshotsOutput{
+				Snapshots: []*ec2.Snapshot{
+					snapshot("snap-expired", "2021-01-09T00:00:00Z"),
+					snapshot("snap-expiring-now", "2021-01-10T00:00:00Z"),
+					snapshot("snap-valid", "2021-01-11T00:00:00Z"),
+					snapshot("snap-invalid", "tomorrow"),
+					{SnapshotId: aws.String("snap-untagged")},
+				},
+			},
+			dsin: &deleted,
+		}},
+	}
+
+	r.deleteExpiredSnapshots()
+
+	var ids []string
+	for _, in := range deleted {
+		ids = append(ids, aws.StringValue(in.SnapshotId))
+	}
+	expected := []string{"snap-expired", "snap-expiring-now"}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("deleteExpiredSnapshots() deleted %v, expected %v", ids, expected)
+	}
+}
+
+func Test_region_snapshotsEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   bool
+		group    bool
+		expected bool
+	}{
+		{name: "disabled"},
+		{name: "enabled globally", global: true, expected: true},
+		{name: "enabled for a group", group: true, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				conf: &Config{AutoScalingConfig: AutoScalingConfig{SnapshotBeforeTerminate: tt.global}},
+				enabledASGs: []*autoScalingGroup{
+					{name: "other"},
+					{name: "asg", config: AutoScalingConfig{SnapshotBeforeTerminate: tt.group}},
+				},
+			}
+			if got := r.snapshotsEnabled(); got != tt.expected {
+				t.Errorf("snapshotsEnabled() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadSnapshotBeforeTerminate(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected bool
+	}{
+		{name: "no tag", expected: true},
+		{name: "disabled by tag", tagValue: aws.String("false"), expected: false},
+		{name: "invalid tag", tagValue: aws.String("maybe"), expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{SnapshotBeforeTerminate: true}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(SnapshotBeforeTerminateTag), Value: tt.tagValue}}
+			}
+
+			a.loadSnapshotBeforeTerminate()
+
+			if a.config.SnapshotBeforeTerminate != tt.expected {
+				t.Errorf("SnapshotBeforeTerminate = %v, expected %v", a.config.SnapshotBeforeTerminate, tt.expected)
+			}
+		})
+	}
+}
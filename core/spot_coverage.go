@@ -0,0 +1,201 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// groupCoverage is the spot coverage of a group, as the share of its running
+// instances which are spot instances.
+type groupCoverage struct {
+	region string
+	name   string
+	spot   int64
+	total  int64
+
+	// target is the highest coverage allowed by the minimum number of
+	// on-demand instances of the group, as percentage
+	target float64
+}
+
+// coveragePercentage returns the percentage of spot instances, an empty
+// group being fully covered.
+func coveragePercentage(spot, total int64) float64 {
+	if total <= 0 {
+		return 100
+	}
+	return float64(spot) * 100 / float64(total)
+}
+
+func (g groupCoverage) percentage() float64 {
+	return coveragePercentage(g.spot, g.total)
+}
+
+// drift is how many percentage points the coverage is below its target.
+func (g groupCoverage) drift() float64 {
+	if d := g.target - g.percentage(); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (g groupCoverage) String() string {
+	return fmt.Sprintf("%s %s: %.1f%% spot coverage (%d of %d instances), target %.1f%%",
+		g.region, g.name, g.percentage(), g.spot, g.total, g.target)
+}
+
+// spotCoverageReport collects the spot coverage of all the groups processed
+// during the current run.
+type spotCoverageReport struct {
+	sync.Mutex
+	groups []groupCoverage
+}
+
+func newSpotCoverageReport() *spotCoverageReport {
+	return &spotCoverageReport{}
+}
+
+func (r *spotCoverageReport) record(g groupCoverage) {
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.groups = append(r.groups, g)
+}
+
+// belowThreshold returns the groups whose coverage drifted below both their
+// target and the threshold, the lowest coverage first.
+func (r *spotCoverageReport) belowThreshold(threshold float64) []groupCoverage {
+	if r == nil || threshold <= 0 {
+		return nil
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	var groups []groupCoverage
+	for _, g := range r.groups {
+		if g.drift() > 0 && g.percentage() < threshold {
+			groups = append(groups, g)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].percentage() < groups[j].percentage()
+	})
+	return groups
+}
+
+// spotCoverage returns the spot coverage of the group, once its instances
+// were scanned and its configuration was loaded.
+func (a *autoScalingGroup) spotCoverage() groupCoverage {
+	onDemand, total := a.alreadyRunningInstanceCount(false, nil)
+
+	minOnDemand := a.minOnDemand
+	if minOnDemand > total {
+		minOnDemand = total
+	}
+
+	return groupCoverage{
+		region: a.region.name,
+		name:   a.name,
+		spot:   total - onDemand,
+		total:  total,
+		target: coveragePercentage(total-minOnDemand, total),
+	}
+}
+
+// reportSpotCoverage logs the spot coverage of the group and its drift from
+// the target, such as after scaling out with on-demand instances, and reports
+// the groups whose coverage dropped below the configured threshold.
+func (a *autoScalingGroup) reportSpotCoverage() {
+	coverage := a.spotCoverage()
+	log.Println(coverage)
+
+	if drift := coverage.drift(); drift > 0 {
+		log.Printf("%s %s Spot coverage drifted %.1f percentage points below its target",
+			a.region.name, a.name, drift)
+	}
+
+	conf := a.region.conf
+	conf.spotCoverage.record(coverage)
+
+	if threshold := conf.SpotCoverageThreshold; threshold > 0 &&
+		coverage.drift() > 0 && coverage.percentage() < threshold {
+		recapText := fmt.Sprintf("%s Spot coverage %.1f%% below the threshold of %.1f%%",
+			a.name, coverage.percentage(), threshold)
+		conf.addRecap(a.region.name, recapText)
+	}
+}
+
+// estimatedSpotCoverage estimates the spot coverage of the group from the
+// data collected while scanning the region, before the group is loaded.
+func (a *autoScalingGroup) estimatedSpotCoverage() float64 {
+	var spot, total int64
+	for _, member := range a.Instances {
+		i := a.region.instances.get(aws.StringValue(member.InstanceId))
+		if i == nil || i.stateName() != ec2.InstanceStateNameRunning {
+			continue
+		}
+		total++
+		if i.isSpot() {
+			spot++
+		}
+	}
+	return coveragePercentage(spot, total)
+}
+
+func connectSNS(conf *Config, topic string) snsiface.SNSAPI {
+	region := conf.MainRegion
+	if parsed, err := arn.Parse(topic); err == nil {
+		region = parsed.Region
+	}
+
+	sess, err := newSession(region, conf)
+	if err != nil {
+		panic(err)
+	}
+
+	return sns.New(sess, conf.serviceConfig(sns.EndpointsID, region))
+}
+
+// notifySpotCoverage publishes the groups whose spot coverage dropped below
+// the threshold to the configured SNS topic at the end of the run.
+func (a *AutoSpotting) notifySpotCoverage() {
+	if a.snsConn == nil {
+		return
+	}
+
+	groups := a.config.spotCoverage.belowThreshold(a.config.SpotCoverageThreshold)
+	if len(groups) == 0 {
+		return
+	}
+
+	lines := make([]string, 0, len(groups))
+	for _, g := range groups {
+		lines = append(lines, g.String())
+	}
+
+	_, err := a.snsConn.Publish(&sns.PublishInput{
+		TopicArn: aws.String(a.config.SpotCoverageTopic),
+		Subject: aws.String(fmt.Sprintf("AutoSpotting: %d groups below %.1f%% spot coverage",
+			len(groups), a.config.SpotCoverageThreshold)),
+		Message: aws.String(strings.Join(lines, "\n")),
+	})
+	if err != nil {
+		log.Println("Failed to publish the spot coverage notification:", err.Error())
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+func Test_autoScalingGroup_reportSpotCoverage(t *testing.T) {
+	tests := []struct {
+		name           string
+		lifecycles     []string
+		minOnDemand    int64
+		threshold      float64
+		expected       groupCoverage
+		expectedDrift  float64
+		expectedRecaps int
+	}{
+		{
+			name:      "empty group",
+			threshold: 80,
+			expected:  groupCoverage{region: "us-east-1", name: "asg", target: 100},
+		},
+		{
+			name:        "on target",
+			lifecycles:  []string{"", Spot, Spot, Spot},
+			minOnDemand: 1,
+			threshold:   80,
+			expected:    groupCoverage{region: "us-east-1", name: "asg", spot: 3, total: 4, target: 75},
+		},
+		{
+			name:           "drifted after scaling out",
+			lifecycles:     []string{"", "", "", Spot},
+			minOnDemand:    1,
+			threshold:      80,
+			expected:       groupCoverage{region: "us-east-1", name: "asg", spot: 1, total: 4, target: 75},
+			expectedDrift:  50,
+			expectedRecaps: 1,
+		},
+		{
+			name:          "drifted without threshold",
+			lifecycles:    []string{"", "", "", Spot},
+			expected:      groupCoverage{region: "us-east-1", name: "asg", spot: 1, total: 4, target: 100},
+			expectedDrift: 75,
+		},
+		{
+			name:        "on-demand instances required",
+			lifecycles:  []string{"", ""},
+			minOnDemand: 5,
+			threshold:   80,
+			expected:    groupCoverage{region: "us-east-1", name: "asg", total: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{
+				SpotCoverageThreshold: tt.threshold,
+				FinalRecap:            map[string][]string{},
+				spotCoverage:          newSpotCoverageReport(),
+			}
+			a := &autoScalingGroup{
+				name:        "asg",
+				region:      &region{name: "us-east-1", conf: conf},
+				instances:   makeInstances(),
+				minOnDemand: tt.minOnDemand,
+			}
+			for n, lifecycle := range tt.lifecycles {
+				i := &instance{Instance: &ec2.Instance{
+					InstanceId: aws.String(string(rune('a' + n))),
+					State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				}}
+				if lifecycle != "" {
+					i.InstanceLifecycle = aws.String(lifecycle)
+				}
+				a.instances.add(i)
+			}
+
+			a.reportSpotCoverage()
+
+			if !reflect.DeepEqual(conf.spotCoverage.groups, []groupCoverage{tt.expected}) {
+				t.Errorf("recorded coverage %v, expected %v", conf.spotCoverage.groups, tt.expected)
+			}
+			if got := tt.expected.drift(); got != tt.expectedDrift {
+				t.Errorf("drift() = %v, expected %v", got, tt.expectedDrift)
+			}
+			if got := len(conf.FinalRecap["us-east-1"]); got != tt.expectedRecaps {
+				t.Errorf("reported %d recap entries, expected %d", got, tt.expectedRecaps)
+			}
+		})
+	}
+}
+
+func TestAutoSpotting_notifySpotCoverage(t *testing.T) {
+	report := newSpotCoverageReport()
+	report.record(groupCoverage{region: "us-east-1", name: "covered", spot: 4, total: 4, target: 100})
+	report.record(groupCoverage{region: "us-east-1", name: "low", spot: 1, total: 4, target: 100})
+	report.record(groupCoverage{region: "eu-west-1", name: "lowest", spot: 0, total: 4, target: 100})
+	report.record(groupCoverage{region: "eu-west-1", name: "on-demand", spot: 0, total: 2, target: 0})
+
+	tests := []struct {
+		name      string
+		threshold float64
+		expected  []*sns.PublishInput
+	}{
+		{name: "threshold disabled"},
+		{
+			name:      "groups below the threshold",
+			threshold: 50,
+			expected: []*sns.PublishInput{{
+				TopicArn: aws.String("arn:aws:sns:us-east-1:123456789012:alerts"),
+				Subject:  aws.String("AutoSpotting: 2 groups below 50.0% spot coverage"),
+				Message: aws.String("eu-west-1 lowest: 0.0% spot coverage (0 of 4 instances), target 100.0%\n" +
+					"us-east-1 low: 25.0% spot coverage (1 of 4 instances), target 100.0%"),
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var published []*sns.PublishInput
+			a := &AutoSpotting{
+				config: &Config{
+					SpotCoverageThreshold: tt.threshold,
+					SpotCoverageTopic:     "arn:aws:sns:us-east-1:123456789012:alerts",
+					spotCoverage:          report,
+				},
+				snsConn: mockSNS{pin: &published},
+			}
+
+			a.notifySpotCoverage()
+
+			if !reflect.DeepEqual(published, tt.expected) {
+				t.Errorf("notifySpotCoverage() published %v, expected %v", published, tt.expected)
+			}
+		})
+	}
+}
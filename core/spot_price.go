@@ -4,7 +4,12 @@
 package autospotting
 
 import (
+	"fmt"
 	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -50,3 +55,195 @@ func (s *spotPrices) fetch(product string,
 
 	return nil
 }
+
+// spotPriceRefreshInterval is the minimum time between two queries of the
+// current spot prices of a product in a region, which rate-limits the
+// DescribeSpotPriceHistory calls made for checking the live spot prices.
+const spotPriceRefreshInterval = time.Minute
+
+// spotPriceBook holds the current spot prices of a region by spot product
+// description. The prices of each product are queried for all the instance
+// types and availability zones of the region at once, using a single
+// paginated call.
+type spotPriceBook struct {
+	sync.Mutex
+	products map[string]*productSpotPrices
+}
+
+// productSpotPrices are the current spot prices of a spot product, keyed by
+// instance type and availability zone.
+type productSpotPrices struct {
+	prices    map[spotPriceKey]float64
+	fetchedAt time.Time
+	err       error
+}
+
+// set records the prices of the product fetched at the given time.
+func (b *spotPriceBook) set(product string, prices map[spotPriceKey]float64, fetchedAt time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.products == nil {
+		b.products = make(map[string]*productSpotPrices)
+	}
+	b.products[product] = &productSpotPrices{prices: prices, fetchedAt: fetchedAt}
+}
+
+// currentSpotPrices returns the current spot prices of the product in the
+// region. They're queried the first time they're needed and then again once
+// older than maxAge, but never more often than the refresh interval. A zero
+// maxAge keeps them for the rest of the run.
+func (r *region) currentSpotPrices(product string, maxAge time.Duration) (map[spotPriceKey]float64, error) {
+	b := &r.spotPriceBook
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.products == nil {
+		b.products = make(map[string]*productSpotPrices)
+	}
+
+	now := r.conf.getClock().Now()
+	if p, found := b.products[product]; found {
+		age := now.Sub(p.fetchedAt)
+		if maxAge <= 0 || age < maxAge || age < spotPriceRefreshInterval {
+			return p.prices, p.err
+		}
+	}
+
+	s := spotPrices{conn: r.services}
+	if err := s.fetch(product, 0, nil, nil); err != nil {
+		// the failure is also kept for the refresh interval, so that the
+		// throttled calls aren't retried for each instance type
+		b.products[product] = &productSpotPrices{fetchedAt: now, err: err}
+		return nil, err
+	}
+
+	latest, _ := summarizeSpotPriceHistory(s.data, now, now)
+	b.products[product] = &productSpotPrices{prices: latest, fetchedAt: now}
+	return latest, nil
+}
+
+// spotPricesByAZ returns the current spot prices of the instance type for the
+// product, keyed by availability zone.
+func (r *region) spotPricesByAZ(product, instanceType string) spotPriceMap {
+	latest, err := r.currentSpotPrices(product, 0)
+	if err != nil {
+		log.Println(r.name, "Couldn't fetch the", product, "spot prices:", err.Error())
+	}
+
+	prices := make(spotPriceMap)
+	for key, price := range latest {
+		if key.instanceType == instanceType {
+			prices[key.availabilityZone] = price
+		}
+	}
+	return prices
+}
+
+// liveSpotPrice returns the current spot price of an instance type in an
+// availability zone for the given spot product description, refreshed at most
+// once per refresh interval for all the instance types of the region.
+func (r *region) liveSpotPrice(product, instanceType, availabilityZone string) (float64, error) {
+	latest, err := r.currentSpotPrices(product, spotPriceRefreshInterval)
+	if err != nil {
+		return 0, err
+	}
+
+	price, found := latest[spotPriceKey{instanceType, availabilityZone}]
+	if !found {
+		return 0, fmt.Errorf("no spot price found for %s in %s", instanceType, availabilityZone)
+	}
+	return price, nil
+}
+
+// spotPriceStats summarizes the price history of a spot pool.
+type spotPriceStats struct {
+	// the time-weighted average price
+	average float64
+
+	// the time-weighted standard deviation of the price
+	volatility float64
+}
+
+// spotPriceKey identifies a spot pool by instance type and availability zone.
+type spotPriceKey struct {
+	instanceType     string
+	availabilityZone string
+}
+
+// summarizeSpotPriceHistory returns the latest price and the statistics of
+// each spot pool, weighting each price by how long it was in effect between
+// start and end.
+func summarizeSpotPriceHistory(data []*ec2.SpotPrice, start, end time.Time) (map[spotPriceKey]float64, map[spotPriceKey]spotPriceStats) {
+	history := make(map[spotPriceKey][]*ec2.SpotPrice)
+	for _, p := range data {
+		if p.InstanceType == nil || p.AvailabilityZone == nil {
+			continue
+		}
+
+		// failure to parse this means that the instance is not available on the
+		// spot market
+		if _, err := strconv.ParseFloat(aws.StringValue(p.SpotPrice), 64); err != nil {
+			debug.Println("Instance type", aws.StringValue(p.InstanceType),
+				"is not available on the spot market")
+			continue
+		}
+		key := spotPriceKey{*p.InstanceType, *p.AvailabilityZone}
+		history[key] = append(history[key], p)
+	}
+
+	latest := make(map[spotPriceKey]float64)
+	stats := make(map[spotPriceKey]spotPriceStats)
+
+	for key, points := range history {
+		sort.SliceStable(points, func(i, j int) bool {
+			return aws.TimeValue(points[i].Timestamp).Before(aws.TimeValue(points[j].Timestamp))
+		})
+
+		var values, weights []float64
+		for i, p := range points {
+			from, to := aws.TimeValue(p.Timestamp), end
+			if i+1 < len(points) {
+				to = aws.TimeValue(points[i+1].Timestamp)
+			}
+			if from.Before(start) {
+				from = start
+			}
+			if !to.After(from) {
+				continue
+			}
+			price, _ := strconv.ParseFloat(*p.SpotPrice, 64)
+			values = append(values, price)
+			weights = append(weights, to.Sub(from).Seconds())
+		}
+
+		latest[key], _ = strconv.ParseFloat(*points[len(points)-1].SpotPrice, 64)
+		if len(values) == 0 {
+			stats[key] = spotPriceStats{average: latest[key]}
+			continue
+		}
+		stats[key] = weightedStats(values, weights)
+	}
+
+	return latest, stats
+}
+
+func weightedStats(values, weights []float64) spotPriceStats {
+	var sum, total float64
+	for i, v := range values {
+		sum += v * weights[i]
+		total += weights[i]
+	}
+	average := sum / total
+
+	var variance float64
+	for i, v := range values {
+		variance += weights[i] * (v - average) * (v - average)
+	}
+
+	return spotPriceStats{
+		average:    average,
+		volatility: math.Sqrt(variance / total),
+	}
+}
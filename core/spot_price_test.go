@@ -5,6 +5,7 @@ package autospotting
 
 import (
 	"errors"
+	"math"
 	"testing"
 	"time"
 
@@ -110,3 +111,212 @@ func Test_fetch(t *testing.T) {
 		})
 	}
 }
+
+func Test_summarizeSpotPriceHistory(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(4 * time.Hour)
+
+	data := []*ec2.SpotPrice{
+		{
+			InstanceType:     aws.String("m5.large"),
+			AvailabilityZone: aws.String("us-east-1a"),
+			SpotPrice:        aws.String("0.03"),
+			Timestamp:        aws.Time(start.Add(2 * time.Hour)),
+		},
+		{
+			InstanceType:     aws.String("m5.large"),
+			AvailabilityZone: aws.String("us-east-1a"),
+			SpotPrice:        aws.String("0.01"),
+			Timestamp:        aws.Time(start.Add(-time.Hour)),
+		},
+		{
+			InstanceType:     aws.String("c5.large"),
+			AvailabilityZone: aws.String("us-east-1a"),
+			SpotPrice:        aws.String("0.02"),
+			Timestamp:        aws.Time(start.Add(-time.Hour)),
+		},
+		{
+			InstanceType:     aws.String("c5.large"),
+			AvailabilityZone: aws.String("us-east-1b"),
+			SpotPrice:        aws.String("invalid"),
+			Timestamp:        aws.Time(start),
+		},
+	}
+
+	latest, stats := summarizeSpotPriceHistory(data, start, end)
+
+	m5 := spotPriceKey{"m5.large", "us-east-1a"}
+	c5 := spotPriceKey{"c5.large", "us-east-1a"}
+
+	if len(latest) != 2 || latest[m5] != 0.03 || latest[c5] != 0.02 {
+		t.Errorf("latest = %v, expected 0.03 for m5.large and 0.02 for c5.large", latest)
+	}
+
+	if math.Abs(stats[m5].average-0.02) > 1e-9 || math.Abs(stats[m5].volatility-0.01) > 1e-9 {
+		t.Errorf("m5.large stats = %+v, expected average 0.02 and volatility 0.01", stats[m5])
+	}
+
+	if stats[c5].average != 0.02 || stats[c5].volatility != 0 {
+		t.Errorf("c5.large stats = %+v, expected average 0.02 and no volatility", stats[c5])
+	}
+}
+
+func Test_weightedStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []float64
+		weights  []float64
+		expected spotPriceStats
+	}{
+		{
+			name:     "constant price",
+			values:   []float64{0.5, 0.5},
+			weights:  []float64{1, 3},
+			expected: spotPriceStats{average: 0.5},
+		},
+		{
+			name:     "equal weights",
+			values:   []float64{1, 3},
+			weights:  []float64{2, 2},
+			expected: spotPriceStats{average: 2, volatility: 1},
+		},
+		{
+			name:     "uneven weights",
+			values:   []float64{1, 5},
+			weights:  []float64{3, 1},
+			expected: spotPriceStats{average: 2, volatility: math.Sqrt(3)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := weightedStats(tt.values, tt.weights)
+			if math.Abs(got.average-tt.expected.average) > 1e-9 ||
+				math.Abs(got.volatility-tt.expected.volatility) > 1e-9 {
+				t.Errorf("weightedStats() = %+v, expected %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_region_currentSpotPrices(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	prices := []*ec2.DescribeSpotPriceHistoryOutput{{
+		SpotPriceHistory: []*ec2.SpotPrice{
+			{
+				InstanceType:     aws.String("m5.large"),
+				AvailabilityZone: aws.String("us-east-1a"),
+				SpotPrice:        aws.String("0.04"),
+				Timestamp:        aws.Time(start.Add(-time.Hour)),
+			},
+			{
+				InstanceType:     aws.String("m5.large"),
+				AvailabilityZone: aws.String("us-east-1b"),
+				SpotPrice:        aws.String("0.05"),
+				Timestamp:        aws.Time(start.Add(-time.Hour)),
+			},
+		},
+	}}
+
+	type query struct {
+		elapsed time.Duration
+		maxAge  time.Duration
+	}
+
+	tests := []struct {
+		name          string
+		dsphperr      error
+		seeded        bool
+		queries       []query
+		expectedCalls int
+		expectErr     bool
+	}{
+		{
+			name:          "kept for the run",
+			queries:       []query{{}, {elapsed: time.Hour}},
+			expectedCalls: 1,
+		},
+		{
+			name: "refreshed once older than the maximum age",
+			queries: []query{
+				{maxAge: 2 * time.Minute},
+				{elapsed: time.Minute, maxAge: 2 * time.Minute},
+				{elapsed: 2 * time.Minute, maxAge: 2 * time.Minute},
+			},
+			expectedCalls: 2,
+		},
+		{
+			name: "not refreshed more often than the refresh interval",
+			queries: []query{
+				{maxAge: time.Second},
+				{elapsed: 30 * time.Second, maxAge: time.Second},
+				{elapsed: 30 * time.Second, maxAge: time.Second},
+			},
+			expectedCalls: 2,
+		},
+		{
+			name:          "seeded by the spot prices of the region",
+			seeded:        true,
+			queries:       []query{{maxAge: spotPriceRefreshInterval}, {elapsed: 30 * time.Second, maxAge: spotPriceRefreshInterval}},
+			expectedCalls: 0,
+		},
+		{
+			name:          "failure kept for the refresh interval",
+			dsphperr:      errors.New("throttled"),
+			queries:       []query{{maxAge: time.Second}, {elapsed: 30 * time.Second, maxAge: time.Second}},
+			expectedCalls: 1,
+			expectErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			clock := &mockClock{now: start}
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{clock: clock},
+				services: connections{ec2: mockEC2{
+					dsphpo:    prices,
+					dsphperr:  tt.dsphperr,
+					dsphcalls: &calls,
+				}},
+			}
+			if tt.seeded {
+				r.spotPriceBook.set("Linux/UNIX (Amazon VPC)",
+					map[spotPriceKey]float64{{"m5.large", "us-east-1a"}: 0.04}, start)
+			}
+
+			var err error
+			var latest map[spotPriceKey]float64
+			for _, q := range tt.queries {
+				clock.now = clock.now.Add(q.elapsed)
+				latest, err = r.currentSpotPrices("Linux/UNIX (Amazon VPC)", q.maxAge)
+			}
+
+			if calls != tt.expectedCalls {
+				t.Errorf("currentSpotPrices() made %d calls, expected %d", calls, tt.expectedCalls)
+			}
+			if (err != nil) != tt.expectErr {
+				t.Errorf("currentSpotPrices() error = %v, expected error %v", err, tt.expectErr)
+			}
+			if !tt.expectErr && latest[spotPriceKey{"m5.large", "us-east-1a"}] != 0.04 {
+				t.Errorf("currentSpotPrices() = %v, expected the m5.large price in us-east-1a", latest)
+			}
+		})
+	}
+}
+
+func Test_region_spotPricesByAZ(t *testing.T) {
+	r := &region{name: "us-east-1", conf: &Config{}}
+	r.spotPriceBook.set("Windows (Amazon VPC)", map[spotPriceKey]float64{
+		{"m5.large", "us-east-1a"}: 0.12,
+		{"m5.large", "us-east-1b"}: 0.13,
+		{"c5.large", "us-east-1a"}: 0.11,
+	}, time.Now())
+
+	got := r.spotPricesByAZ("Windows (Amazon VPC)", "m5.large")
+	expected := spotPriceMap{"us-east-1a": 0.12, "us-east-1b": 0.13}
+	if len(got) != len(expected) || got["us-east-1a"] != 0.12 || got["us-east-1b"] != 0.13 {
+		t.Errorf("spotPricesByAZ() = %v, expected %v", got, expected)
+	}
+}
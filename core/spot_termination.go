@@ -15,6 +15,10 @@ import (
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
 const (
@@ -27,21 +31,28 @@ const (
 type SpotTermination struct {
 	asSvc           autoscalingiface.AutoScalingAPI
 	ec2Svc          ec2iface.EC2API
+	eventsSvc       eventbridgeiface.EventBridgeAPI
+	sqsSvc          sqsiface.SQSAPI
+	region          string
 	SleepMultiplier time.Duration
+	conf            *Config
 }
 
-func newSpotTermination(region string) SpotTermination {
+func newSpotTermination(region string, conf *Config) SpotTermination {
 
 	log.Println("Connection to region ", region)
 
-	session := session.Must(
-		session.NewSession(&aws.Config{Region: aws.String(region)}))
+	session := session.Must(newSession(region, conf))
 
 	return SpotTermination{
 
-		asSvc:           autoscaling.New(session),
-		ec2Svc:          ec2.New(session),
+		asSvc:           autoscaling.New(session, conf.serviceConfig(autoscaling.EndpointsID, region)),
+		ec2Svc:          ec2.New(session, conf.serviceConfig(ec2.EndpointsID, region)),
+		eventsSvc:       eventbridge.New(session, conf.serviceConfig(eventbridge.EndpointsID, region)),
+		sqsSvc:          sqs.New(session, conf.serviceConfig(sqs.EndpointsID, region)),
+		region:          region,
 		SleepMultiplier: 1,
+		conf:            conf,
 	}
 }
 
@@ -69,20 +80,22 @@ func (s *SpotTermination) detachInstance(instanceID *string, asgName string, eve
 
 	if eventType != InstanceRebalanceRecommendationCode {
 		s.deleteTagInstanceLaunchedForAsg(instanceID)
-		s.delayedTermination(instanceID, 14)
+		s.delayedTermination(instanceID, asgName, 14)
 	}
 
 	return nil
 }
 
 // delayedTermination is used to terminate instances that were marked as being in danger of being terminated.
-func (s *SpotTermination) delayedTermination(instanceID *string, minutes time.Duration) error {
+func (s *SpotTermination) delayedTermination(instanceID *string, asgName string, minutes time.Duration) error {
 
 	log.Printf("Terminating instance %s with %d minutes delay, sleeping...\n",
 		*instanceID, minutes)
 
 	time.Sleep(minutes * time.Minute * s.SleepMultiplier)
 
+	s.annotateTermination(*instanceID, asgName)
+
 	log.Println("Terminating instance", *instanceID)
 	// terminate the spot instance
 	terminateParams := ec2.TerminateInstancesInput{
@@ -104,6 +117,8 @@ func (s *SpotTermination) terminateInstance(instanceID *string, asgName string)
 	log.Println(asgName,
 		"Terminating instance:",
 		*instanceID)
+	s.annotateTermination(*instanceID, asgName)
+
 	// terminate the spot instance
 	terminateParams := autoscaling.TerminateInstanceInAutoScalingGroupInput{
 		InstanceId:                     instanceID,
@@ -117,6 +132,17 @@ func (s *SpotTermination) terminateInstance(instanceID *string, asgName string)
 	return nil
 }
 
+// annotateTermination records that the instance is terminated because of its
+// upcoming spot interruption.
+func (s *SpotTermination) annotateTermination(instanceID, asgName string) {
+	annotateTermination(s.conf, s.ec2Svc, s.eventsSvc, terminationAnnotation{
+		InstanceID: instanceID,
+		Region:     s.region,
+		Group:      asgName,
+		Reason:     spotInterruptionTermination,
+	})
+}
+
 func (s *SpotTermination) getAsgName(instanceID *string) (string, error) {
 	asParams := autoscaling.DescribeAutoScalingInstancesInput{
 		InstanceIds: []*string{instanceID},
@@ -166,24 +192,25 @@ func (s *SpotTermination) executeAction(instanceID *string, terminationNotificat
 }
 
 func (s *SpotTermination) deleteTagInstanceLaunchedForAsg(instanceID *string) error {
+	key := s.conf.tagKey(launchedForASGTag)
 	ec2Params := ec2.DeleteTagsInput{
 		Resources: []*string{
 			aws.String(*instanceID),
 		},
 		Tags: []*ec2.Tag{
 			{
-				Key: aws.String("launched-for-asg"),
+				Key: aws.String(key),
 			},
 		},
 	}
 	_, err := s.ec2Svc.DeleteTags(&ec2Params)
 
 	if err != nil {
-		log.Printf("Failed to delete Tag 'launched-for-asg' from spot instance %s with err: %s\n", *instanceID, err.Error())
+		log.Printf("Failed to delete Tag '%s' from spot instance %s with err: %s\n", key, *instanceID, err.Error())
 		return err
 	}
 
-	log.Printf("Tag 'launched-for-asg' deleted from spot instance %s", *instanceID)
+	log.Printf("Tag '%s' deleted from spot instance %s", key, *instanceID)
 
 	return nil
 }
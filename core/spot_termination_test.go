@@ -17,7 +17,7 @@ import (
 func TestNewSpotTermination(t *testing.T) {
 
 	region := "foo"
-	spotTermination := newSpotTermination(region)
+	spotTermination := newSpotTermination(region, &Config{})
 
 	if spotTermination.asSvc == nil || spotTermination.ec2Svc == nil {
 		t.Errorf("Unable to connect to region %s", region)
@@ -199,7 +199,8 @@ func TestTerminateInstance(t *testing.T) {
 		{
 			name: "When TerminateInstance returns error",
 			spotTermination: &SpotTermination{
-				asSvc: mockASG{tiiasgerr: errors.New("")},
+				asSvc:  mockASG{tiiasgerr: errors.New("")},
+				ec2Svc: mockEC2{},
 			},
 			expectedError: errors.New(""),
 		},
@@ -212,6 +213,7 @@ func TestTerminateInstance(t *testing.T) {
 						StatusCode:           &statusCode,
 					},
 				}},
+				ec2Svc: mockEC2{},
 			},
 			expectedError: nil,
 		},
@@ -377,6 +379,7 @@ func TestExecuteAction(t *testing.T) {
 						},
 					},
 				},
+				ec2Svc: mockEC2{},
 			},
 			expectedError:                 nil,
 			terminationNotificationAction: "auto",
@@ -393,6 +396,7 @@ func TestExecuteAction(t *testing.T) {
 						},
 					},
 				},
+				ec2Svc: mockEC2{},
 			},
 			expectedError:                 nil,
 			terminationNotificationAction: TerminateTerminationNotificationAction,
@@ -0,0 +1,144 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// maxFilterValues is the maximum number of values accepted by a filter of the
+// DescribeInstances API call.
+const maxFilterValues = 200
+
+// scanAndProcessEnabledAutoScalingGroups scans only the instances of the
+// enabled groups, along with the spot instances launched for them which
+// weren't attached yet, and starts processing each group as soon as all its
+// instances were scanned, while the next pages of instances are still being
+// fetched.
+func (r *region) scanAndProcessEnabledAutoScalingGroups() error {
+	r.instances = makeInstances()
+
+	// the unattached spot instances need to be known before processing the
+	// groups they were launched for
+	err := r.services.ec2.DescribeInstancesPages(
+		&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				runningOrPendingInstancesFilter(),
+				{
+					Name:   aws.String("tag-key"),
+					Values: []*string{aws.String(r.conf.tagKey(launchedForASGTag))},
+				},
+			},
+		},
+		r.processDescribeInstancesPage)
+
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[string]map[string]bool)
+
+	for _, asg := range r.enabledASGList() {
+		missing := make(map[string]bool)
+		for _, inst := range asg.Instances {
+			id := aws.StringValue(inst.InstanceId)
+			if r.instances.get(id) == nil {
+				missing[id] = true
+			}
+		}
+
+		if len(missing) == 0 {
+			r.processEnabledAutoScalingGroup(asg)
+			continue
+		}
+		pending[asg.name] = missing
+	}
+
+	// any groups left over after the scan, such as those with instances
+	// terminated in the meantime, are processed at the end
+	defer func() {
+		for name := range pending {
+			if asg := r.findEnabledASGByName(name); asg != nil {
+				r.processEnabledAutoScalingGroup(asg)
+			}
+		}
+		r.wg.Wait()
+	}()
+
+	var names []string
+	for name := range pending {
+		names = append(names, name)
+	}
+
+	for start := 0; start < len(names); start += maxFilterValues {
+		end := start + maxFilterValues
+		if end > len(names) {
+			end = len(names)
+		}
+
+		err := r.services.ec2.DescribeInstancesPages(
+			&ec2.DescribeInstancesInput{
+				Filters: []*ec2.Filter{
+					runningOrPendingInstancesFilter(),
+					{
+						Name:   aws.String("tag:aws:autoscaling:groupName"),
+						Values: aws.StringSlice(names[start:end]),
+					},
+				},
+			},
+			func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				r.processDescribeInstancesPage(page, lastPage)
+				r.processCompletedAutoScalingGroups(page, pending)
+				return true
+			})
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processCompletedAutoScalingGroups starts processing the groups whose last
+// missing instances were found in the given page of instances.
+func (r *region) processCompletedAutoScalingGroups(page *ec2.DescribeInstancesOutput,
+	pending map[string]map[string]bool) {
+
+	for _, res := range page.Reservations {
+		for _, inst := range res.Instances {
+			i := &instance{Instance: inst}
+			belongs, asgName := i.belongsToAnASG()
+			if !belongs {
+				continue
+			}
+
+			missing, found := pending[aws.StringValue(asgName)]
+			if !found {
+				continue
+			}
+			delete(missing, aws.StringValue(inst.InstanceId))
+
+			if len(missing) > 0 {
+				continue
+			}
+			delete(pending, aws.StringValue(asgName))
+
+			if asg := r.findEnabledASGByName(aws.StringValue(asgName)); asg != nil {
+				debug.Println(r.name, "Scanned all the instances of", asg.name)
+				r.processEnabledAutoScalingGroup(asg)
+			}
+		}
+	}
+}
+
+func runningOrPendingInstancesFilter() *ec2.Filter {
+	return &ec2.Filter{
+		Name: aws.String("instance-state-name"),
+		Values: []*string{
+			aws.String("running"),
+			aws.String("pending"),
+		},
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func streamedInstance(id, asgName string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:   aws.String(id),
+		InstanceType: aws.String("m5.large"),
+		Tags: []*ec2.Tag{
+			{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String(asgName)},
+		},
+	}
+}
+
+func Test_region_processCompletedAutoScalingGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		pending  map[string]map[string]bool
+		page     *ec2.DescribeInstancesOutput
+		expected map[string]map[string]bool
+	}{
+		{
+			name: "group still missing instances",
+			pending: map[string]map[string]bool{
+				"asg1": {"i-1": true, "i-2": true},
+			},
+			page: &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{
+					{Instances: []*ec2.Instance{streamedInstance("i-1", "asg1")}},
+				},
+			},
+			expected: map[string]map[string]bool{
+				"asg1": {"i-2": true},
+			},
+		},
+		{
+			name: "group completed",
+			pending: map[string]map[string]bool{
+				"asg1": {"i-1": true},
+				"asg2": {"i-3": true},
+			},
+			page: &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{
+					{Instances: []*ec2.Instance{
+						streamedInstance("i-1", "asg1"),
+						streamedInstance("i-4", "other"),
+					}},
+				},
+			},
+			expected: map[string]map[string]bool{
+				"asg2": {"i-3": true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1"}
+			r.processCompletedAutoScalingGroups(tt.page, tt.pending)
+
+			if !reflect.DeepEqual(tt.pending, tt.expected) {
+				t.Errorf("pending = %v, expected %v", tt.pending, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_region_scanAndProcessEnabledAutoScalingGroups(t *testing.T) {
+	unattached := &ec2.Instance{
+		InstanceId:        aws.String("i-spot"),
+		InstanceType:      aws.String("m5.large"),
+		InstanceLifecycle: aws.String(Spot),
+		Tags: []*ec2.Tag{
+			{Key: aws.String("launched-for-asg"), Value: aws.String("asg1")},
+		},
+	}
+
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{},
+		services: connections{
+			ec2: mockEC2{
+				dio: &ec2.DescribeInstancesOutput{
+					Reservations: []*ec2.Reservation{
+						{Instances: []*ec2.Instance{
+							unattached,
+							streamedInstance("i-1", "asg1"),
+							streamedInstance("i-2", "asg1"),
+						}},
+					},
+				},
+			},
+		},
+	}
+
+	r.addEnabledASGs(
+		&autoScalingGroup{
+			name:   "asg1",
+			region: r,
+			Group: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg1"),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-1")},
+					{InstanceId: aws.String("i-2")},
+				},
+			},
+		},
+		&autoScalingGroup{
+			name:   "asg2",
+			region: r,
+			Group:  &autoscaling.Group{AutoScalingGroupName: aws.String("asg2")},
+		},
+	)
+
+	if err := r.scanAndProcessEnabledAutoScalingGroups(); err != nil {
+		t.Fatalf("scanAndProcessEnabledAutoScalingGroups() unexpected error: %v", err)
+	}
+
+	for _, id := range []string{"i-spot", "i-1", "i-2"} {
+		if r.instances.get(id) == nil {
+			t.Errorf("instance %s missing from the catalog", id)
+		}
+	}
+
+	for name, expected := range map[string][]string{
+		"asg1": {"i-1", "i-2"},
+		"asg2": nil,
+	} {
+		asg := r.findEnabledASGByName(name)
+		if asg.instances == nil {
+			t.Errorf("group %s wasn't processed", name)
+			continue
+		}
+
+		var got []string
+		for _, i := range asg.instances.instances() {
+			got = append(got, aws.StringValue(i.InstanceId))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("group %s instances = %v, expected %v", name, got, expected)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// surgeSwap is a spot instance of a batch, together with the on-demand
+// instance it replaces.
+type surgeSwap struct {
+	spot     *instance
+	onDemand *instance
+}
+
+// surgeEnabled determines if the group replaces its on-demand instances in
+// batches. The batches need the swaps to be done by the current run, so they
+// aren't used when the swaps are delegated to the SQS queue.
+func (a *autoScalingGroup) surgeEnabled() bool {
+	return a.config.Surge > 1 && len(a.region.conf.SQSQueueURL) == 0
+}
+
+// maxSizeIncrement is the largest temporary increase of the MaxSize of the
+// group done by a swap.
+func (a *autoScalingGroup) maxSizeIncrement() int64 {
+	if a.config.Surge > 1 {
+		return a.config.Surge
+	}
+	return 1
+}
+
+// unattachedInstancesLaunchedForThisASG returns all the spot instances launched
+// for the group which weren't attached to it yet.
+func (a *autoScalingGroup) unattachedInstancesLaunchedForThisASG() []*instance {
+	var unattached []*instance
+	for _, inst := range a.region.instances.instances() {
+		if aws.StringValue(inst.getReplacementTargetASGName()) == a.name && !a.hasMemberInstance(inst) {
+			unattached = append(unattached, inst)
+		}
+	}
+	return unattached
+}
+
+// surgeEventAction decides the next step of the rolling replacement of the
+// group: the spot instances of the current batch are swapped once all of them
+// are ready, otherwise a new batch is launched.
+func (a *autoScalingGroup) surgeEventAction() runer {
+	spotInstances := a.unattachedInstancesLaunchedForThisASG()
+
+	if len(spotInstances) > 0 {
+		for _, spotInstance := range spotInstances {
+			if !spotInstance.isReadyToAttach(a) {
+				log.Printf("Spot instance %s not yet ready, waiting for the whole batch of %s",
+					aws.StringValue(spotInstance.InstanceId), a.name)
+				return skipRun{"spot instance replacements exist but not ready"}
+			}
+		}
+
+		if int64(len(spotInstances)) > a.config.Surge {
+			spotInstances = spotInstances[:a.config.Surge]
+		}
+		log.Println(a.region.name, "Found", len(spotInstances), "spot instances, attaching them to", a.name)
+		return swapSpotInstances{target{asg: a}, spotInstances}
+	}
+
+	onDemandInstances := a.surgeCandidates()
+	if len(onDemandInstances) == 0 {
+		log.Println(a.region.name, a.name,
+			"No running unprotected on-demand instances were found, nothing to do here...")
+		return skipRun{reason: "no-instances-to-replace"}
+	}
+
+	a.loadLaunchConfiguration()
+	a.loadLaunchTemplate()
+
+	return launchSpotReplacements{target{asg: a}, onDemandInstances}
+}
+
+// surgeCandidates returns the on-demand instances replaced by the next batch,
+// up to the surge of the group but without going below its minimum number of
+// on-demand instances.
+func (a *autoScalingGroup) surgeCandidates() []*instance {
+	count := a.config.Surge
+	if onDemandRunning, _ := a.alreadyRunningInstanceCount(false, nil); onDemandRunning-a.minOnDemand < count {
+		count = onDemandRunning - a.minOnDemand
+	}
+
+	var candidates []*instance
+	var candidateIDs []*string
+	for _, i := range a.instances.instances() {
+		if int64(len(candidates)) >= count {
+			break
+		}
+
+		if !a.isInstanceMatching(i, nil, true, true) {
+			continue
+		}
+
+		// the whole batch counts against the EKS disruption budget
+		if allowed, reason := a.eksDisruptionAllowed(append(candidateIDs, i.InstanceId)...); !allowed {
+			debug.Println(a.name, "skipping instance", aws.StringValue(i.InstanceId), reason)
+			continue
+		}
+
+		if !a.kubernetesAutoscalerAllowsReplacement(i) {
+			continue
+		}
+		candidates = append(candidates, i)
+		candidateIDs = append(candidateIDs, i.InstanceId)
+	}
+	return candidates
+}
+
+// replaceOnDemandInstancesWithSpot swaps a batch of spot instances: they're all
+// attached to the group, temporarily raising its capacity, and each on-demand
+// instance is terminated once its replacement is healthy. The swaps failing
+// are left for the next runs, while the others proceed.
+func (a *autoScalingGroup) replaceOnDemandInstancesWithSpot(spotInstances []*instance) error {
+	for _, action := range []string{AttachSpotAction, TerminateOnDemandAction} {
+		if a.isActionDisabled(action) {
+			return a.actionDisabledError(action)
+		}
+	}
+
+	if action, near := a.nearScheduledAction(); near {
+		return a.scheduledActionError(action)
+	}
+
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	var swaps []surgeSwap
+	var replacedIDs []*string
+	for _, spotInstance := range spotInstances {
+		odInstance, err := spotInstance.replacementTarget(a)
+		if err != nil {
+			fail(err)
+			continue
+		}
+
+		if allowed, reason := a.eksDisruptionAllowed(append(replacedIDs, odInstance.InstanceId)...); !allowed {
+			log.Printf("Not replacing on-demand instance %s from the group %s yet: %s",
+				aws.StringValue(odInstance.InstanceId), a.name, reason)
+			fail(fmt.Errorf("replacing %s would exceed the EKS nodegroup %w",
+				aws.StringValue(odInstance.InstanceId), ErrDisruptionBudgetExceeded))
+			continue
+		}
+		swaps = append(swaps, surgeSwap{spot: spotInstance, onDemand: odInstance})
+		replacedIDs = append(replacedIDs, odInstance.InstanceId)
+	}
+
+	if len(swaps) == 0 {
+		return firstErr
+	}
+
+	desiredCapacity, maxSize := aws.Int64Value(a.DesiredCapacity), aws.Int64Value(a.MaxSize)
+
+	// the desired capacity may have changed since the group was loaded
+	if current, err := a.currentDesiredCapacity(); err == nil {
+		desiredCapacity = current
+	}
+
+	// the capacity can't be raised when the on-demand instances are configured
+	// to be taken out of the group first, or when there's no room left below
+	// a MaxSize which can't be increased
+	headroom := maxSize - desiredCapacity
+	if a.config.SwapStrategy == TerminateFirstSwapStrategy ||
+		(headroom <= 0 && a.isActionDisabled(ModifyMaxSizeAction)) {
+		log.Println(a.name, "Can't raise the capacity of the group, swapping the instances one at a time")
+		for _, s := range swaps {
+			if err := a.replaceOnDemandInstanceWithSpot(aws.StringValue(s.spot.InstanceId)); err != nil {
+				fail(err)
+			}
+		}
+		return firstErr
+	}
+
+	if int64(len(swaps)) > headroom && a.isActionDisabled(ModifyMaxSizeAction) {
+		swaps = swaps[:headroom]
+	}
+
+	allowed, release := a.region.conf.swapLimiter.acquireBatch(a.name, len(swaps))
+	defer release()
+	swaps = swaps[:allowed]
+
+	replacements := make(map[string]string, len(swaps))
+	for _, s := range swaps {
+		replacements[aws.StringValue(s.onDemand.InstanceId)] = aws.StringValue(s.spot.InstanceId)
+	}
+
+	// deferred before resuming the processes, so that it runs after them
+	defer a.correlateScalingActivities(a.region.conf.getClock().Now(), replacements)
+
+	a.suspendProcesses()
+	defer a.resumeProcesses()
+
+	surge := int64(len(swaps))
+	if desiredCapacity+surge > maxSize {
+		log.Println(a.name, "Temporarily increasing MaxSize by", desiredCapacity+surge-maxSize)
+		if err := a.increaseMaxSize(maxSize, desiredCapacity+surge-maxSize); err != nil {
+			return err
+		}
+		defer a.restoreMaxSize(maxSize)
+	}
+
+	var attached []surgeSwap
+	expectedCapacity := desiredCapacity
+
+	for _, s := range swaps {
+		spotInstanceID := aws.StringValue(s.spot.InstanceId)
+		s.spot.migrateLegacyTags()
+
+		log.Printf("Attaching spot instance %s to the group %s", spotInstanceID, a.name)
+		if err := a.attachSpotInstance(spotInstanceID, true); err != nil {
+			log.Printf("Spot instance %s couldn't be attached to the group %s, terminating it...",
+				spotInstanceID, a.name)
+			if !a.isActionDisabled(TerminateSpotAction) {
+				a.region.annotateTermination(spotInstanceID, a.name, failedSwapTermination, "")
+				s.spot.terminate()
+			}
+			fail(fmt.Errorf("couldn't attach spot instance %s: %w", spotInstanceID, err))
+			continue
+		}
+		expectedCapacity++
+
+		if err := s.spot.copyTerminationProtection(s.onDemand); err != nil {
+			fail(fmt.Errorf("couldn't copy termination protection from on-demand instance %s: %w",
+				aws.StringValue(s.onDemand.InstanceId), err))
+			continue
+		}
+
+		if err := s.spot.copyScaleInProtection(s.onDemand); err != nil {
+			fail(fmt.Errorf("couldn't copy scale-in protection from on-demand instance %s: %w",
+				aws.StringValue(s.onDemand.InstanceId), err))
+			continue
+		}
+		attached = append(attached, s)
+	}
+
+	if err := a.completeSurgeSwaps(attached, expectedCapacity); err != nil {
+		fail(err)
+	}
+	return firstErr
+}
+
+// completeSurgeSwaps terminates the on-demand instances replaced by the spot
+// instances attached to the group, once each spot instance is healthy. The
+// desired capacity is expected to have been raised to expectedCapacity by the
+// attachments.
+func (a *autoScalingGroup) completeSurgeSwaps(attached []surgeSwap, expectedCapacity int64) error {
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, s := range attached {
+		odInstanceID := s.onDemand.InstanceId
+
+		// the on-demand instance is otherwise left running and replaced later,
+		// while the unhealthy spot instance is removed from the group
+		if err := a.waitForHealthyInstance(s.spot.InstanceId); err != nil {
+			log.Printf("Keeping on-demand instance %s in the group %s: %s", *odInstanceID, a.name, err.Error())
+			fail(err)
+			if removeErr := a.removeUnhealthySpotInstance(s.spot); removeErr != nil {
+				log.Println(a.name, removeErr.Error())
+				continue
+			}
+			expectedCapacity--
+			continue
+		}
+
+		if err := a.registerInServiceDiscovery(s.spot, *odInstanceID); err != nil {
+			log.Printf("Keeping on-demand instance %s in the group %s", *odInstanceID, a.name)
+			fail(fmt.Errorf("couldn't register spot instance %s to Cloud Map: %w",
+				aws.StringValue(s.spot.InstanceId), err))
+			continue
+		}
+
+		if err := a.snapshotReplacedInstance(*odInstanceID, aws.StringValue(s.spot.InstanceId)); err != nil {
+			log.Printf("Keeping on-demand instance %s in the group %s", *odInstanceID, a.name)
+			fail(err)
+			continue
+		}
+
+		log.Printf("Terminating on-demand instance %s from the group %s", *odInstanceID, a.name)
+		if err := a.terminateReplacedOnDemandInstance(odInstanceID, aws.StringValue(s.spot.InstanceId), expectedCapacity); err != nil {
+			if errors.Is(err, ErrScalingActivity) {
+				// the group is scaling out, so the remaining on-demand
+				// instances are kept as well
+				fail(err)
+				break
+			}
+			if errors.Is(err, ErrLifecycleHookTimeout) {
+				// the on-demand instance is still being terminated
+				fail(err)
+				expectedCapacity--
+				continue
+			}
+			fail(fmt.Errorf("couldn't terminate on-demand instance %s: %w", *odInstanceID, err))
+			continue
+		}
+		expectedCapacity--
+
+		recapText := fmt.Sprintf("%s OnDemand instance %s replaced with spot instance %s",
+			a.name, *odInstanceID, aws.StringValue(s.spot.InstanceId))
+		a.region.conf.addRecap(a.region.name, recapText)
+	}
+
+	return firstErr
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+)
+
+func Test_autoScalingGroup_loadSurge(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected int64
+	}{
+		{name: "no tag", expected: 2},
+		{name: "valid tag", tagValue: aws.String("5"), expected: 5},
+		{name: "disabled by tag", tagValue: aws.String("0"), expected: 0},
+		{name: "negative tag", tagValue: aws.String("-1"), expected: 2},
+		{name: "invalid tag", tagValue: aws.String("many"), expected: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+					Surge: 2,
+				}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(SurgeTag), Value: tt.tagValue}}
+			}
+
+			a.loadSurge()
+
+			if a.config.Surge != tt.expected {
+				t.Errorf("Surge = %d, expected %d", a.config.Surge, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_surgeEnabled(t *testing.T) {
+	tests := []struct {
+		name                     string
+		surge                    int64
+		sqsQueueURL              string
+		expected                 bool
+		expectedMaxSizeIncrement int64
+	}{
+		{name: "disabled", expectedMaxSizeIncrement: 1},
+		{name: "one at a time", surge: 1, expectedMaxSizeIncrement: 1},
+		{name: "enabled", surge: 3, expected: true, expectedMaxSizeIncrement: 3},
+		{name: "swaps delegated to SQS", surge: 3, sqsQueueURL: "https://sqs", expectedMaxSizeIncrement: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				config: AutoScalingConfig{Surge: tt.surge},
+				region: &region{conf: &Config{SQSQueueURL: tt.sqsQueueURL}},
+			}
+			if got := a.surgeEnabled(); got != tt.expected {
+				t.Errorf("surgeEnabled() = %v, expected %v", got, tt.expected)
+			}
+			if got := a.maxSizeIncrement(); got != tt.expectedMaxSizeIncrement {
+				t.Errorf("maxSizeIncrement() = %d, expected %d", got, tt.expectedMaxSizeIncrement)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_surgeEventAction(t *testing.T) {
+	now := time.Now()
+
+	onDemand := func(id string) *instance {
+		return &instance{Instance: &ec2.Instance{
+			InstanceId: aws.String(id),
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		}}
+	}
+	spot := func(id string, launchTime time.Time) *instance {
+		return &instance{Instance: &ec2.Instance{
+			InstanceId:        aws.String(id),
+			InstanceLifecycle: aws.String(Spot),
+			LaunchTime:        aws.Time(launchTime),
+			Placement:         &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			State:             &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("launched-for-asg"), Value: aws.String("asg")},
+			},
+		}}
+	}
+
+	tests := []struct {
+		name              string
+		tags              []*autoscaling.TagDescription
+		eks               mockEKS
+		members           []*instance
+		unattached        []*instance
+		surge             int64
+		minOnDemand       int64
+		expectedSkip      string
+		expectedLaunches  int
+		expectedSwapCount int
+	}{
+		{
+			name:             "launches a batch",
+			members:          []*instance{onDemand("i-od1"), onDemand("i-od2"), onDemand("i-od3")},
+			surge:            2,
+			expectedLaunches: 2,
+		},
+		{
+			name:             "keeps the minimum number of on-demand instances",
+			members:          []*instance{onDemand("i-od1"), onDemand("i-od2"), onDemand("i-od3")},
+			surge:            3,
+			minOnDemand:      2,
+			expectedLaunches: 1,
+		},
+		{
+			name: "keeps the batch within the EKS disruption budget",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(eksClusterNameTag), Value: aws.String("cluster")},
+				{Key: aws.String(eksNodegroupNameTag), Value: aws.String("nodegroup")},
+			},
+			eks: mockEKS{dno: &eks.DescribeNodegroupOutput{
+				Nodegroup: &eks.Nodegroup{
+					Status:       aws.String(eks.NodegroupStatusActive),
+					UpdateConfig: &eks.NodegroupUpdateConfig{MaxUnavailable: aws.Int64(2)},
+				},
+			}},
+			members:          []*instance{onDemand("i-od1"), onDemand("i-od2"), onDemand("i-od3")},
+			surge:            3,
+			expectedLaunches: 2,
+		},
+		{
+			name:         "nothing to replace",
+			members:      []*instance{onDemand("i-od1")},
+			surge:        2,
+			minOnDemand:  1,
+			expectedSkip: "no-instances-to-replace",
+		},
+		{
+			name:         "waits for the whole batch",
+			members:      []*instance{onDemand("i-od1"), onDemand("i-od2")},
+			unattached:   []*instance{spot("i-spot1", now.Add(-time.Hour)), spot("i-spot2", now)},
+			surge:        2,
+			expectedSkip: "spot instance replacements exist but not ready",
+		},
+		{
+			name:              "swaps the ready batch",
+			members:           []*instance{onDemand("i-od1"), onDemand("i-od2")},
+			unattached:        []*instance{spot("i-spot1", now.Add(-time.Hour)), spot("i-spot2", now.Add(-time.Hour))},
+			surge:             2,
+			expectedSwapCount: 2,
+		},
+		{
+			name:    "swaps at most the surge",
+			members: []*instance{onDemand("i-od1"), onDemand("i-od2")},
+			unattached: []*instance{
+				spot("i-spot1", now.Add(-time.Hour)),
+				spot("i-spot2", now.Add(-time.Hour)),
+				spot("i-spot3", now.Add(-time.Hour)),
+			},
+			surge:             2,
+			expectedSwapCount: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				conf: &Config{
+					clock: &mockClock{now: now},
+				},
+				instances: makeInstances(),
+				services:  connections{eks: tt.eks},
+			}
+			a := &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{
+					AutoScalingGroupName:   aws.String("asg"),
+					HealthCheckGracePeriod: aws.Int64(60),
+					Tags:                   tt.tags,
+				},
+				region:      r,
+				instances:   makeInstances(),
+				minOnDemand: tt.minOnDemand,
+				config: AutoScalingConfig{
+					Surge:                          tt.surge,
+					SkipTerminationProtectionCheck: true,
+				},
+			}
+			for _, i := range tt.members {
+				i.asg, i.region = a, r
+				a.instances.add(i)
+				r.instances.add(i)
+				a.Instances = append(a.Instances, &autoscaling.Instance{
+					InstanceId:     i.InstanceId,
+					LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+				})
+			}
+			for _, i := range tt.unattached {
+				i.region = r
+				r.instances.add(i)
+			}
+
+			switch action := a.surgeEventAction().(type) {
+			case skipRun:
+				if action.reason != tt.expectedSkip {
+					t.Errorf("surgeEventAction() skipped with %q, expected %q", action.reason, tt.expectedSkip)
+				}
+			case launchSpotReplacements:
+				if len(action.onDemandInstances) != tt.expectedLaunches {
+					t.Errorf("surgeEventAction() launched %d replacements, expected %d",
+						len(action.onDemandInstances), tt.expectedLaunches)
+				}
+			case swapSpotInstances:
+				if len(action.spotInstances) != tt.expectedSwapCount {
+					t.Errorf("surgeEventAction() swapped %d instances, expected %d",
+						len(action.spotInstances), tt.expectedSwapCount)
+				}
+			default:
+				t.Errorf("surgeEventAction() = %T, unexpected", action)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_completeSurgeSwaps(t *testing.T) {
+	var detached []*autoscaling.DetachInstancesInput
+	var terminated []*ec2.TerminateInstancesInput
+	var terminatedInGroup []*autoscaling.TerminateInstanceInAutoScalingGroupInput
+
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{clock: &mockClock{now: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)}},
+		services: connections{
+			autoScaling: mockASG{
+				// the unhealthy spot instance was detached, decrementing the
+				// capacity raised from 2 to 4 by the attachments
+				dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+					AutoScalingGroups: []*autoscaling.Group{{DesiredCapacity: aws.Int64(3)}},
+				},
+				dasiStates: map[string]string{
+					"i-spot1": autoscaling.LifecycleStateInService,
+					"i-spot2": autoscaling.LifecycleStateInService,
+				},
+				dasiHealth: map[string]string{"i-spot1": "UNHEALTHY", "i-spot2": "HEALTHY"},
+				dlho:       &autoscaling.DescribeLifecycleHooksOutput{},
+				diin:       &detached,
+				tiiasgin:   &terminatedInGroup,
+			},
+			ec2: mockEC2{tiin: &terminated},
+		},
+	}
+	a := &autoScalingGroup{
+		name:   "asg",
+		Group:  &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+		region: r,
+	}
+	newInstance := func(id string) *instance {
+		return &instance{
+			Instance: &ec2.Instance{
+				InstanceId: aws.String(id),
+				State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			},
+			region: r,
+		}
+	}
+
+	err := a.completeSurgeSwaps([]surgeSwap{
+		{spot: newInstance("i-spot1"), onDemand: newInstance("i-od1")},
+		{spot: newInstance("i-spot2"), onDemand: newInstance("i-od2")},
+	}, 4)
+	if !errors.Is(err, ErrInstanceNotHealthy) {
+		t.Errorf("completeSurgeSwaps() error = %v, expected %v", err, ErrInstanceNotHealthy)
+	}
+
+	if len(detached) != 1 || aws.StringValue(detached[0].InstanceIds[0]) != "i-spot1" ||
+		!aws.BoolValue(detached[0].ShouldDecrementDesiredCapacity) {
+		t.Errorf("completeSurgeSwaps() detached %v, expected i-spot1 decrementing the capacity", detached)
+	}
+	if len(terminated) != 1 || aws.StringValue(terminated[0].InstanceIds[0]) != "i-spot1" {
+		t.Errorf("completeSurgeSwaps() terminated %v, expected only i-spot1", terminated)
+	}
+	if len(terminatedInGroup) != 1 || aws.StringValue(terminatedInGroup[0].InstanceId) != "i-od2" ||
+		!aws.BoolValue(terminatedInGroup[0].ShouldDecrementDesiredCapacity) {
+		t.Errorf("completeSurgeSwaps() terminated %v in the group, expected i-od2 decrementing the capacity",
+			terminatedInGroup)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"sync"
+)
+
+// swapLimiter caps the number of swaps in flight across all the regions, in
+// order to protect the systems shared by the instances, such as configuration
+// management or service discovery, from registration storms.
+type swapLimiter struct {
+	slots chan struct{}
+
+	// serializes the batches taking several slots, so that they can't
+	// deadlock each other while holding only some of the slots they need
+	batches sync.Mutex
+}
+
+// newSwapLimiter returns a limiter allowing the given number of concurrent
+// swaps, or nil when they're unlimited.
+func newSwapLimiter(maxSwaps int) *swapLimiter {
+	if maxSwaps <= 0 {
+		return nil
+	}
+	return &swapLimiter{slots: make(chan struct{}, maxSwaps)}
+}
+
+// acquire waits until a swap can be started, returning the function which
+// needs to be called once the swap is done.
+func (s *swapLimiter) acquire(name string) func() {
+	if s == nil {
+		return func() {}
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		log.Println(name, "Waiting for some of the", cap(s.slots), "swaps in progress to finish")
+		s.slots <- struct{}{}
+	}
+
+	return func() { <-s.slots }
+}
+
+// acquireBatch waits until a batch of swaps can be started, returning the
+// number of swaps allowed, which is capped to the number of slots, and the
+// function which needs to be called once the swaps are done.
+func (s *swapLimiter) acquireBatch(name string, swaps int) (int, func()) {
+	if s == nil {
+		return swaps, func() {}
+	}
+
+	if swaps > cap(s.slots) {
+		swaps = cap(s.slots)
+	}
+
+	s.batches.Lock()
+	defer s.batches.Unlock()
+
+	releases := make([]func(), 0, swaps)
+	for n := 0; n < swaps; n++ {
+		releases = append(releases, s.acquire(name))
+	}
+
+	return swaps, func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+}
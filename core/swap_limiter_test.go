@@ -0,0 +1,94 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_newSwapLimiter(t *testing.T) {
+	if l := newSwapLimiter(0); l != nil {
+		t.Errorf("newSwapLimiter(0) = %v, expected nil", l)
+	}
+
+	// the unlimited limiter doesn't block
+	var l *swapLimiter
+	for n := 0; n < 100; n++ {
+		l.acquire("test")
+	}
+}
+
+func Test_swapLimiter_acquire(t *testing.T) {
+	const maxSwaps = 3
+
+	l := newSwapLimiter(maxSwaps)
+
+	var wg sync.WaitGroup
+	var inFlight, maxInFlight int32
+
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := l.acquire("test")
+			defer release()
+
+			current := atomic.AddInt32(&inFlight, 1)
+			for {
+				seen := atomic.LoadInt32(&maxInFlight)
+				if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight > maxSwaps {
+		t.Errorf("acquire() allowed %d concurrent swaps, expected at most %d", maxInFlight, maxSwaps)
+	}
+	if len(l.slots) != 0 {
+		t.Errorf("acquire() left %d slots taken after all the swaps finished", len(l.slots))
+	}
+}
+
+func Test_swapLimiter_acquireBatch(t *testing.T) {
+	var unlimited *swapLimiter
+	if allowed, _ := unlimited.acquireBatch("test", 5); allowed != 5 {
+		t.Errorf("acquireBatch() allowed %d swaps, expected 5", allowed)
+	}
+
+	l := newSwapLimiter(3)
+
+	allowed, release := l.acquireBatch("test", 5)
+	if allowed != 3 {
+		t.Errorf("acquireBatch() allowed %d swaps, expected 3", allowed)
+	}
+	if len(l.slots) != 3 {
+		t.Errorf("acquireBatch() took %d slots, expected 3", len(l.slots))
+	}
+	release()
+
+	// concurrent batches don't deadlock while competing for the slots
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, release := l.acquireBatch("test", 2)
+			time.Sleep(time.Millisecond)
+			release()
+		}()
+	}
+	wg.Wait()
+
+	if len(l.slots) != 0 {
+		t.Errorf("acquireBatch() left %d slots taken after all the swaps finished", len(l.slots))
+	}
+}
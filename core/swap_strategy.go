@@ -0,0 +1,106 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const (
+	// AttachFirstSwapStrategy attaches the spot instance to the group before
+	// terminating the on-demand instance it replaces
+	AttachFirstSwapStrategy = "attach_first"
+
+	// TerminateFirstSwapStrategy takes the on-demand instance out of the group
+	// before attaching its spot replacement, without ever exceeding the
+	// capacity of the group
+	TerminateFirstSwapStrategy = "terminate_first"
+
+	// OverlapSwapStrategy attaches the spot instance to the group and keeps
+	// the on-demand instance running until the spot instance is reported
+	// healthy by the group, for services which can't tolerate any capacity
+	// reduction
+	OverlapSwapStrategy = "overlap"
+
+	// minHealthyInstanceWait is the minimum time the overlap strategy waits for
+	// the spot instances to become healthy, for the groups with short grace
+	// periods
+	minHealthyInstanceWait = 5 * time.Minute
+)
+
+func isValidSwapStrategy(strategy string) bool {
+	switch strategy {
+	case AttachFirstSwapStrategy, TerminateFirstSwapStrategy, OverlapSwapStrategy:
+		return true
+	}
+	return false
+}
+
+// waitForHealthyInstance waits for the instance attached to the group to be
+// reported healthy by it and by all the load balancers attached to it, even
+// for the groups not using the load balancer health checks. It waits for up
+// to the readiness grace period of the group, but for at least
+// minHealthyInstanceWait.
+func (a *autoScalingGroup) waitForHealthyInstance(instanceID *string) error {
+	clock := a.region.conf.getClock()
+
+	timeout := time.Duration(a.readinessGracePeriod()) * time.Second
+	if timeout < minHealthyInstanceWait {
+		timeout = minHealthyInstanceWait
+	}
+	deadline := clock.Now().Add(timeout)
+
+	for {
+		result, err := a.region.services.autoScaling.DescribeAutoScalingInstances(
+			&autoscaling.DescribeAutoScalingInstancesInput{
+				InstanceIds: []*string{instanceID},
+			})
+		if err != nil {
+			log.Println(err.Error())
+		} else if len(result.AutoScalingInstances) > 0 &&
+			strings.EqualFold(aws.StringValue(result.AutoScalingInstances[0].HealthStatus), "healthy") &&
+			a.isHealthyInLoadBalancers(aws.StringValue(instanceID)) {
+			log.Printf("Spot instance %s is healthy in the group %s", aws.StringValue(instanceID), a.name)
+			return nil
+		}
+
+		if !clock.Now().Before(deadline) {
+			break
+		}
+		log.Printf("Waiting for spot instance %s to be healthy in the group %s",
+			aws.StringValue(instanceID), a.name)
+		clock.Sleep(10 * time.Second)
+	}
+
+	return fmt.Errorf("spot instance %s wasn't healthy in the group %s after %s: %w",
+		aws.StringValue(instanceID), a.name, timeout, ErrInstanceNotHealthy)
+}
+
+// removeUnhealthySpotInstance detaches the spot instance which didn't become
+// healthy from the group, decrementing the desired capacity raised by its
+// attachment, and terminates it. The on-demand instance it was meant to
+// replace is kept running and replaced by a new spot instance later.
+func (a *autoScalingGroup) removeUnhealthySpotInstance(spot *instance) error {
+	spotInstanceID := aws.StringValue(spot.InstanceId)
+
+	log.Printf("Detaching unhealthy spot instance %s from the group %s", spotInstanceID, a.name)
+	if err := a.detachInstance(spotInstanceID); err != nil {
+		return fmt.Errorf("couldn't detach unhealthy spot instance %s: %w", spotInstanceID, err)
+	}
+
+	if a.isActionDisabled(TerminateSpotAction) {
+		return nil
+	}
+	a.region.annotateTermination(spotInstanceID, a.name, failedSwapTermination, "")
+	if err := spot.terminate(); err != nil {
+		log.Printf("Couldn't terminate unhealthy spot instance %s: %s", spotInstanceID, err.Error())
+	}
+	return nil
+}
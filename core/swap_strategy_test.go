@@ -0,0 +1,226 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func Test_autoScalingGroup_waitForHealthyInstance(t *testing.T) {
+	tests := []struct {
+		name          string
+		dasio         *autoscaling.DescribeAutoScalingInstancesOutput
+		dasierr       error
+		dtho          *elbv2.DescribeTargetHealthOutput
+		gracePeriod   int64
+		expectedErr   error
+		expectedSlept time.Duration
+	}{
+		{
+			name: "healthy",
+			dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+				AutoScalingInstances: []*autoscaling.InstanceDetails{
+					{HealthStatus: aws.String("HEALTHY")},
+				},
+			},
+		},
+		{
+			name: "unhealthy within the minimum wait",
+			dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+				AutoScalingInstances: []*autoscaling.InstanceDetails{
+					{HealthStatus: aws.String("UNHEALTHY")},
+				},
+			},
+			gracePeriod:   60,
+			expectedErr:   ErrInstanceNotHealthy,
+			expectedSlept: minHealthyInstanceWait,
+		},
+		{
+			name:          "not in the group within the grace period",
+			dasio:         &autoscaling.DescribeAutoScalingInstancesOutput{},
+			gracePeriod:   600,
+			expectedErr:   ErrInstanceNotHealthy,
+			expectedSlept: 10 * time.Minute,
+		},
+		{
+			name: "healthy in the group but not in its target group",
+			dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+				AutoScalingInstances: []*autoscaling.InstanceDetails{
+					{HealthStatus: aws.String("HEALTHY")},
+				},
+			},
+			dtho: &elbv2.DescribeTargetHealthOutput{
+				TargetHealthDescriptions: []*elbv2.TargetHealthDescription{
+					{TargetHealth: &elbv2.TargetHealth{State: aws.String(elbv2.TargetHealthStateEnumInitial)}},
+				},
+			},
+			expectedErr:   ErrInstanceNotHealthy,
+			expectedSlept: minHealthyInstanceWait,
+		},
+		{
+			name:          "describe error",
+			dasierr:       errors.New("throttled"),
+			expectedErr:   ErrInstanceNotHealthy,
+			expectedSlept: minHealthyInstanceWait,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &mockClock{now: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)}
+			a := &autoScalingGroup{
+				name:  "asg",
+				Group: &autoscaling.Group{HealthCheckGracePeriod: aws.Int64(tt.gracePeriod)},
+				region: &region{
+					conf: &Config{clock: clock},
+					services: connections{
+						autoScaling: mockASG{dasio: tt.dasio, dasierr: tt.dasierr},
+						elbv2:       mockELBV2{dtho: tt.dtho},
+					},
+				},
+			}
+			if tt.dtho != nil {
+				a.TargetGroupARNs = []*string{aws.String("arn:tg")}
+			}
+
+			err := a.waitForHealthyInstance(aws.String("i-spot"))
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("waitForHealthyInstance() error = %v, expected %v", err, tt.expectedErr)
+			}
+			if clock.slept != tt.expectedSlept {
+				t.Errorf("waitForHealthyInstance() waited %s, expected %s", clock.slept, tt.expectedSlept)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadSwapStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		expected string
+	}{
+		{name: "no tag", expected: AttachFirstSwapStrategy},
+		{name: "valid tag", tagValue: aws.String(OverlapSwapStrategy), expected: OverlapSwapStrategy},
+		{name: "invalid tag", tagValue: aws.String("blue_green"), expected: AttachFirstSwapStrategy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+					SwapStrategy: AttachFirstSwapStrategy,
+				}}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(SwapStrategyTag), Value: tt.tagValue}}
+			}
+
+			a.loadSwapStrategy()
+
+			if a.config.SwapStrategy != tt.expected {
+				t.Errorf("SwapStrategy = %q, expected %q", a.config.SwapStrategy, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_swapWithGroupMember_overlapUnhealthy(t *testing.T) {
+	var detached []*autoscaling.DetachInstancesInput
+	var terminated []*ec2.TerminateInstancesInput
+
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{
+			AutoScalingConfig: AutoScalingConfig{OnDemandPriceMultiplier: 1},
+			clock:             &mockClock{now: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)},
+		},
+		instanceTypeInformation: map[string]instanceTypeInformation{},
+		instances:               makeInstances(),
+		services: connections{
+			autoScaling: mockASG{
+				dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+					AutoScalingGroups: []*autoscaling.Group{{DesiredCapacity: aws.Int64(1)}},
+				},
+				dasiStates: map[string]string{"i-spot": autoscaling.LifecycleStateInService},
+				dasiHealth: map[string]string{"i-spot": "UNHEALTHY"},
+				diin:       &detached,
+			},
+			ec2: mockEC2{
+				dio: &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+					Instances: []*ec2.Instance{{
+						InstanceId:   aws.String("i-od"),
+						InstanceType: aws.String("m5.large"),
+						Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+						State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+						Tags: []*ec2.Tag{
+							{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("asg")},
+						},
+					}},
+				}}},
+				tiin: &terminated,
+			},
+		},
+	}
+	asg := &autoScalingGroup{
+		name: "asg",
+		Group: &autoscaling.Group{
+			AutoScalingGroupName: aws.String("asg"),
+			DesiredCapacity:      aws.Int64(1),
+			MaxSize:              aws.Int64(1),
+			Instances:            []*autoscaling.Instance{{InstanceId: aws.String("i-od")}},
+		},
+		region:    r,
+		instances: makeInstances(),
+		loaded:    true,
+		config: AutoScalingConfig{
+			OnDemandPriceMultiplier:        1,
+			SwapStrategy:                   OverlapSwapStrategy,
+			SkipTerminationProtectionCheck: true,
+		},
+	}
+	r.addEnabledASGs(asg)
+	asg.instances.add(&instance{
+		Instance: &ec2.Instance{
+			InstanceId: aws.String("i-od"),
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		},
+		asg:    asg,
+		region: r,
+	})
+
+	spot := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:        aws.String("i-spot"),
+			InstanceLifecycle: aws.String(Spot),
+			State:             &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Tags: []*ec2.Tag{
+				{Key: aws.String(launchedForReplacingInstanceTag), Value: aws.String("i-od")},
+			},
+		},
+		region: r,
+	}
+
+	if _, err := spot.swapWithGroupMember(asg); !errors.Is(err, ErrInstanceNotHealthy) {
+		t.Fatalf("swapWithGroupMember() error = %v, expected %v", err, ErrInstanceNotHealthy)
+	}
+
+	if len(detached) != 1 || aws.StringValue(detached[0].InstanceIds[0]) != "i-spot" ||
+		!aws.BoolValue(detached[0].ShouldDecrementDesiredCapacity) {
+		t.Errorf("swapWithGroupMember() detached %v, expected i-spot decrementing the capacity", detached)
+	}
+	if len(terminated) != 1 || aws.StringValue(terminated[0].InstanceIds[0]) != "i-spot" {
+		t.Errorf("swapWithGroupMember() terminated %v, expected only i-spot", terminated)
+	}
+	if got := aws.Int64Value(asg.MaxSize); got != 1 {
+		t.Errorf("swapWithGroupMember() left the MaxSize at %d, expected it restored to 1", got)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import "strings"
+
+// The keys of the tags set by AutoSpotting on the spot instances it launches,
+// and of the tag enabling it on the groups by default.
+const (
+	spotEnabledTag                  = "spot-enabled"
+	launchedByAutoSpottingTag       = "launched-by-autospotting"
+	launchedForASGTag               = "launched-for-asg"
+	launchedForReplacingInstanceTag = "launched-for-replacing-instance"
+	autoSpottingVersionTag          = "autospotting-version"
+	originalInstanceTypeTag         = "original-instance-type"
+	launchTemplateIDTag             = "LaunchTemplateID"
+	launchTemplateVersionTag        = "LaunchTemplateVersion"
+	launchConfigurationNameTag      = "LaunchConfigurationName"
+	adoptedByAutoSpottingTag        = "adopted-by-autospotting"
+	snapshotOfInstanceTag           = "snapshot-of-instance"
+	snapshotOfASGTag                = "snapshot-of-asg"
+	replacedBySpotInstanceTag       = "replaced-by-spot-instance"
+	snapshotExpiresAtTag            = "snapshot-expires-at"
+	terminatedByAutoSpottingTag     = "terminated-by-autospotting"
+	terminatedAtTag                 = "terminated-by-autospotting-at"
+	terminatedForReplacementByTag   = "terminated-for-replacement-by"
+	terminatedByRunTag              = "terminated-by-autospotting-run"
+)
+
+// groupConfigTagPrefix is the prefix of the tags overriding the configuration
+// on a group level.
+const groupConfigTagPrefix = "autospotting_"
+
+// tagKey returns the key of a tag read or written by AutoSpotting within the
+// configured tag namespace. The autospotting_ prefix of the group
+// configuration tags is replaced by the namespace, while the other keys are
+// just prefixed with it.
+func (cfg *Config) tagKey(key string) string {
+	if cfg == nil || cfg.TagKeyPrefix == "" {
+		return key
+	}
+	return cfg.TagKeyPrefix + strings.TrimPrefix(key, groupConfigTagPrefix)
+}
+
+// tagKey returns the key of a tag of the instance within the configured tag
+// namespace.
+func (i *instance) tagKey(key string) string {
+	if i.region == nil {
+		return key
+	}
+	return i.region.conf.tagKey(key)
+}
+
+// tagValue returns the value of the instance tag with the given key, within
+// the configured tag namespace.
+func (i *instance) tagValue(key string) *string {
+	key = i.tagKey(key)
+	for _, tag := range i.Tags {
+		if tag.Key != nil && *tag.Key == key {
+			return tag.Value
+		}
+	}
+	return nil
+}
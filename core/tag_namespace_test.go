@@ -0,0 +1,133 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestConfig_tagKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     *Config
+		key      string
+		expected string
+	}{
+		{name: "no configuration", key: SurgeTag, expected: "autospotting_surge"},
+		{name: "no prefix", conf: &Config{}, key: launchedForASGTag, expected: "launched-for-asg"},
+		{
+			name:     "group configuration tag",
+			conf:     &Config{TagKeyPrefix: "mycorp:autospotting/"},
+			key:      OnDemandNumberLong,
+			expected: "mycorp:autospotting/min_on_demand_number",
+		},
+		{
+			name:     "instance tag",
+			conf:     &Config{TagKeyPrefix: "mycorp:autospotting/"},
+			key:      launchedByAutoSpottingTag,
+			expected: "mycorp:autospotting/launched-by-autospotting",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.conf.tagKey(tt.key); got != tt.expected {
+				t.Errorf("tagKey() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTagKeyPrefix(t *testing.T) {
+	r := &region{conf: &Config{TagKeyPrefix: "mycorp:as/"}}
+
+	a := &autoScalingGroup{
+		name:   "asg",
+		region: r,
+		Group: &autoscaling.Group{Tags: []*autoscaling.TagDescription{
+			{Key: aws.String(SurgeTag), Value: aws.String("2")},
+			{Key: aws.String("mycorp:as/swap_strategy"), Value: aws.String(OverlapSwapStrategy)},
+		}},
+	}
+
+	if got := a.getOwnTagValue(SurgeTag); got != nil {
+		t.Errorf("getOwnTagValue() = %q, expected the unprefixed tag to be ignored", *got)
+	}
+	if got := aws.StringValue(a.getOwnTagValue(SwapStrategyTag)); got != OverlapSwapStrategy {
+		t.Errorf("getOwnTagValue() = %q, expected %q", got, OverlapSwapStrategy)
+	}
+	if got := aws.StringValue(a.groupTag(SkipReasonsTag, "").Key); got != "mycorp:as/skip_reasons" {
+		t.Errorf("groupTag() key = %q", got)
+	}
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:   aws.String("i-1"),
+			InstanceType: aws.String("m5.large"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("mycorp:as/launched-for-asg"), Value: aws.String("old")},
+				{Key: aws.String("launched-by-autospotting"), Value: aws.String("true")},
+				{Key: aws.String("team"), Value: aws.String("web")},
+			},
+		},
+		region: r,
+		asg:    a,
+	}
+
+	if got := aws.StringValue(i.getReplacementTargetASGName()); got != "old" {
+		t.Errorf("getReplacementTargetASGName() = %q, expected the prefixed tag", got)
+	}
+	if i.isLaunchedByAutoSpotting() {
+		t.Errorf("isLaunchedByAutoSpotting() = true, expected the unprefixed tag to be ignored")
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range i.generateTagsList()[0].Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	expected := map[string]string{
+		"mycorp:as/launched-by-autospotting":        "true",
+		"mycorp:as/launched-for-asg":                "asg",
+		"mycorp:as/launched-for-replacing-instance": "i-1",
+		"mycorp:as/original-instance-type":          "m5.large",
+		"mycorp:as/autospotting-tag-schema":         currentTagSchemaVersion,
+		"team":                                      "web",
+	}
+	for key, value := range expected {
+		if tags[key] != value {
+			t.Errorf("tag %s = %q, expected %q", key, tags[key], value)
+		}
+	}
+	if _, found := tags["launched-by-autospotting"]; found {
+		t.Errorf("generateTagsList() copied the unprefixed AutoSpotting tag: %v", tags)
+	}
+	if len(tags) != len(expected) {
+		t.Errorf("generateTagsList() = %v, expected %v", tags, expected)
+	}
+
+	r.conf.addDefaultFilter()
+	if r.conf.FilterByTags != "mycorp:as/spot-enabled=true" {
+		t.Errorf("addDefaultFilter() = %q", r.conf.FilterByTags)
+	}
+}
+
+func TestTagKeyPrefix_eksNodegroupNames(t *testing.T) {
+	a := &autoScalingGroup{
+		name:   "asg",
+		region: &region{conf: &Config{TagKeyPrefix: "mycorp:as/"}},
+		Group: &autoscaling.Group{Tags: []*autoscaling.TagDescription{
+			{Key: aws.String(eksClusterNameTag), Value: aws.String("cluster")},
+			{Key: aws.String(eksNodegroupNameTag), Value: aws.String("nodegroup")},
+		}},
+	}
+
+	cluster, nodegroup := a.eksNodegroupNames()
+	if aws.StringValue(cluster) != "cluster" || aws.StringValue(nodegroup) != "nodegroup" {
+		t.Errorf("eksNodegroupNames() = %v, %v, expected the EKS tags to be read without the prefix",
+			aws.StringValue(cluster), aws.StringValue(nodegroup))
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// tagSchemaVersionTag records the version of the tags set by AutoSpotting
+	// on the spot instances it launched.
+	tagSchemaVersionTag = "autospotting-tag-schema"
+
+	// currentTagSchemaVersion is the version of the tags written by the
+	// current release. It needs to be increased when adding entries to
+	// legacyTagKeys.
+	currentTagSchemaVersion = "2"
+)
+
+// legacyTagKeys maps the tag keys written by older releases to their current
+// keys, so that the spot instances launched by them are still recognized and
+// can be migrated.
+var legacyTagKeys = map[string]string{
+	// misspelled by the releases before the tag schema was versioned
+	"LaunchConfiguationName": launchConfigurationNameTag,
+}
+
+// canonicalTagKey returns the current key of a tag written by AutoSpotting,
+// translating the legacy keys.
+func canonicalTagKey(key string) string {
+	if current, found := legacyTagKeys[key]; found {
+		return current
+	}
+	return key
+}
+
+// migrateLegacyTags replaces the legacy tags of a spot instance launched by
+// an older release with their current keys, and records the current tag
+// schema version, so that only the current tags are used going forward. It's
+// done for the instances AutoSpotting acts on, the instances already having
+// the current schema version being skipped without any API calls.
+func (i *instance) migrateLegacyTags() error {
+	if aws.StringValue(i.tagValue(tagSchemaVersionTag)) == currentTagSchemaVersion {
+		return nil
+	}
+
+	present := make(map[string]bool)
+	for _, tag := range i.Tags {
+		present[aws.StringValue(tag.Key)] = true
+	}
+
+	var created, deleted []*ec2.Tag
+	for _, tag := range i.Tags {
+		key := aws.StringValue(tag.Key)
+		current, legacy := legacyTagKeys[key]
+		if !legacy {
+			continue
+		}
+		deleted = append(deleted, &ec2.Tag{Key: tag.Key})
+		if current = i.tagKey(current); !present[current] {
+			present[current] = true
+			created = append(created, &ec2.Tag{Key: aws.String(current), Value: tag.Value})
+		}
+	}
+
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	created = append(created, &ec2.Tag{
+		Key:   aws.String(i.tagKey(tagSchemaVersionTag)),
+		Value: aws.String(currentTagSchemaVersion),
+	})
+
+	var keys []string
+	for _, tag := range deleted {
+		keys = append(keys, aws.StringValue(tag.Key))
+	}
+	log.Printf("Migrating the legacy tags %s of instance %s to the tag schema version %s",
+		strings.Join(keys, ", "), aws.StringValue(i.InstanceId), currentTagSchemaVersion)
+
+	svc := i.region.services.ec2
+	if _, err := svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{i.InstanceId},
+		Tags:      created,
+	}); err != nil {
+		log.Println("Couldn't migrate the tags of instance", aws.StringValue(i.InstanceId), err.Error())
+		return err
+	}
+
+	if _, err := svc.DeleteTags(&ec2.DeleteTagsInput{
+		Resources: []*string{i.InstanceId},
+		Tags:      deleted,
+	}); err != nil {
+		log.Println("Couldn't delete the legacy tags of instance", aws.StringValue(i.InstanceId), err.Error())
+		return err
+	}
+
+	// keep the in-memory tags in sync with the instance
+	var tags []*ec2.Tag
+	for _, tag := range i.Tags {
+		if _, legacy := legacyTagKeys[aws.StringValue(tag.Key)]; !legacy {
+			tags = append(tags, tag)
+		}
+	}
+	i.Tags = append(tags, created...)
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_isReservedTagKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		prefix   string
+		expected bool
+	}{
+		{key: "LaunchConfigurationName", expected: true},
+		{key: "LaunchConfiguationName", expected: true},
+		{key: "autospotting-tag-schema", expected: true},
+		{key: "aws:autoscaling:groupName", expected: true},
+		{key: "team"},
+		{key: "mycorp:as/launched-for-asg", prefix: "mycorp:as/", expected: true},
+		{key: "mycorp:as/team", prefix: "mycorp:as/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := isReservedTagKey(tt.key, tt.prefix); got != tt.expected {
+				t.Errorf("isReservedTagKey() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_migrateLegacyTags(t *testing.T) {
+	tag := func(key, value string) *ec2.Tag {
+		return &ec2.Tag{Key: aws.String(key), Value: aws.String(value)}
+	}
+	schemaTag := tag(tagSchemaVersionTag, currentTagSchemaVersion)
+
+	tests := []struct {
+		name            string
+		tags            []*ec2.Tag
+		cterr           error
+		expectedCreated []*ec2.Tag
+		expectedDeleted []*ec2.Tag
+		expectedTags    []*ec2.Tag
+		expectedErr     bool
+	}{
+		{
+			name:         "current schema",
+			tags:         []*ec2.Tag{tag("LaunchConfiguationName", "lc"), schemaTag},
+			expectedTags: []*ec2.Tag{tag("LaunchConfiguationName", "lc"), schemaTag},
+		},
+		{
+			name:         "no legacy tags",
+			tags:         []*ec2.Tag{tag("launched-for-asg", "asg")},
+			expectedTags: []*ec2.Tag{tag("launched-for-asg", "asg")},
+		},
+		{
+			name:            "legacy tag",
+			tags:            []*ec2.Tag{tag("launched-for-asg", "asg"), tag("LaunchConfiguationName", "lc")},
+			expectedCreated: []*ec2.Tag{tag("LaunchConfigurationName", "lc"), schemaTag},
+			expectedDeleted: []*ec2.Tag{{Key: aws.String("LaunchConfiguationName")}},
+			expectedTags: []*ec2.Tag{tag("launched-for-asg", "asg"),
+				tag("LaunchConfigurationName", "lc"), schemaTag},
+		},
+		{
+			name:            "legacy tag next to the current one",
+			tags:            []*ec2.Tag{tag("LaunchConfigurationName", "new"), tag("LaunchConfiguationName", "old")},
+			expectedCreated: []*ec2.Tag{schemaTag},
+			expectedDeleted: []*ec2.Tag{{Key: aws.String("LaunchConfiguationName")}},
+			expectedTags:    []*ec2.Tag{tag("LaunchConfigurationName", "new"), schemaTag},
+		},
+		{
+			name:            "failed migration",
+			tags:            []*ec2.Tag{tag("LaunchConfiguationName", "lc")},
+			cterr:           errors.New("UnauthorizedOperation"),
+			expectedCreated: []*ec2.Tag{tag("LaunchConfigurationName", "lc"), schemaTag},
+			expectedTags:    []*ec2.Tag{tag("LaunchConfiguationName", "lc")},
+			expectedErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []*ec2.CreateTagsInput
+			var deleted []*ec2.DeleteTagsInput
+
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-1"), Tags: tt.tags},
+				region: &region{
+					conf:     &Config{},
+					services: connections{ec2: mockEC2{cterr: tt.cterr, ctin: &created, dtin: &deleted}},
+				},
+			}
+
+			if err := i.migrateLegacyTags(); (err != nil) != tt.expectedErr {
+				t.Errorf("migrateLegacyTags() error = %v, expected error %v", err, tt.expectedErr)
+			}
+
+			var gotCreated, gotDeleted []*ec2.Tag
+			for _, in := range created {
+				gotCreated = append(gotCreated, in.Tags...)
+			}
+			for _, in := range deleted {
+				gotDeleted = append(gotDeleted, in.Tags...)
+			}
+
+			if !reflect.DeepEqual(gotCreated, tt.expectedCreated) {
+				t.Errorf("created tags %v, expected %v", gotCreated, tt.expectedCreated)
+			}
+			if !reflect.DeepEqual(gotDeleted, tt.expectedDeleted) {
+				t.Errorf("deleted tags %v, expected %v", gotDeleted, tt.expectedDeleted)
+			}
+			if !reflect.DeepEqual(i.Tags, tt.expectedTags) {
+				t.Errorf("instance tags %v, expected %v", i.Tags, tt.expectedTags)
+			}
+		})
+	}
+}
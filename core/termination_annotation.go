@@ -0,0 +1,140 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+)
+
+// The reasons for which AutoSpotting terminates instances, set in the
+// terminated-by-autospotting tag.
+const (
+	// the on-demand instance was replaced by a spot instance
+	replacedBySpotTermination = "replaced-by-spot"
+
+	// the spot instance is no longer needed by its group
+	unneededSpotTermination = "unneeded-spot"
+
+	// the spot instance couldn't be swapped with its on-demand instance
+	failedSwapTermination = "failed-swap"
+
+	// the spot instance was reverted to on-demand capacity
+	revertedTermination = "reverted-to-on-demand"
+
+	// the spot instance wasn't attached to any group
+	orphanTermination = "orphan"
+
+	// the spot instance is about to be interrupted
+	spotInterruptionTermination = "spot-interruption"
+)
+
+const (
+	// terminationEventSource and terminationEventDetailType identify the
+	// events published for the terminated instances
+	terminationEventSource     = "autospotting"
+	terminationEventDetailType = "AutoSpotting Instance Termination"
+)
+
+// terminationAnnotation describes why and when AutoSpotting terminated an
+// instance, so that post-mortems can attribute the termination to it.
+type terminationAnnotation struct {
+	InstanceID string    `json:"instance-id"`
+	Region     string    `json:"region"`
+	Group      string    `json:"autoscaling-group,omitempty"`
+	Reason     string    `json:"reason"`
+	ReplacedBy string    `json:"replaced-by,omitempty"`
+	RunID      string    `json:"run-id,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// newRunID generates the identifier of a run, set on the instances it
+// terminates.
+func newRunID(now time.Time) string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return now.UTC().Format("20060102T150405Z")
+	}
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// annotateTermination records the reason for terminating the instance before
+// it's terminated, in its tags, in the logs and as an EventBridge event when
+// an event bus is configured. Failing to annotate the termination doesn't
+// prevent it.
+func (r *region) annotateTermination(instanceID, group, reason, replacedBy string) {
+	annotateTermination(r.conf, r.services.ec2, r.services.eventBridge, terminationAnnotation{
+		InstanceID: instanceID,
+		Region:     r.name,
+		Group:      group,
+		Reason:     reason,
+		ReplacedBy: replacedBy,
+	})
+}
+
+func annotateTermination(conf *Config, ec2Svc ec2iface.EC2API, events eventbridgeiface.EventBridgeAPI, t terminationAnnotation) {
+	if conf == nil {
+		conf = &Config{}
+	}
+	t.RunID = conf.runID
+	t.Time = conf.getClock().Now().UTC()
+
+	log.Printf("%s Terminating instance %s of the group %s: reason=%s replaced-by=%s run-id=%s",
+		t.Region, t.InstanceID, t.Group, t.Reason, t.ReplacedBy, t.RunID)
+
+	tags := []*ec2.Tag{
+		{Key: aws.String(conf.tagKey(terminatedByAutoSpottingTag)), Value: aws.String(t.Reason)},
+		{Key: aws.String(conf.tagKey(terminatedAtTag)), Value: aws.String(t.Time.Format(time.RFC3339))},
+	}
+	if t.ReplacedBy != "" {
+		tags = append(tags, &ec2.Tag{Key: aws.String(conf.tagKey(terminatedForReplacementByTag)), Value: aws.String(t.ReplacedBy)})
+	}
+	if t.RunID != "" {
+		tags = append(tags, &ec2.Tag{Key: aws.String(conf.tagKey(terminatedByRunTag)), Value: aws.String(t.RunID)})
+	}
+
+	if _, err := ec2Svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(t.InstanceID)},
+		Tags:      tags,
+	}); err != nil {
+		log.Println("Couldn't tag the termination reason of", t.InstanceID+":", err.Error())
+	}
+
+	if conf.TerminationEventBus == "" || events == nil {
+		return
+	}
+
+	detail, err := json.Marshal(t)
+	if err != nil {
+		log.Println("Couldn't encode the termination event of", t.InstanceID+":", err.Error())
+		return
+	}
+
+	out, err := events.PutEvents(&eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{{
+			EventBusName: aws.String(conf.TerminationEventBus),
+			Source:       aws.String(terminationEventSource),
+			DetailType:   aws.String(terminationEventDetailType),
+			Detail:       aws.String(string(detail)),
+			Time:         aws.Time(t.Time),
+		}},
+	})
+	if err == nil && aws.Int64Value(out.FailedEntryCount) > 0 && len(out.Entries) > 0 {
+		err = fmt.Errorf("%s: %s", aws.StringValue(out.Entries[0].ErrorCode),
+			aws.StringValue(out.Entries[0].ErrorMessage))
+	}
+	if err != nil {
+		log.Println("Couldn't publish the termination event of", t.InstanceID+":", err.Error())
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+)
+
+func Test_newRunID(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	got := newRunID(now)
+	if !regexp.MustCompile(`^20210304T050607Z-[0-9a-f]{8}$`).MatchString(got) {
+		t.Errorf("newRunID() = %q, unexpected format", got)
+	}
+	if other := newRunID(now); other == got {
+		t.Errorf("newRunID() generated the same ID twice: %q", got)
+	}
+}
+
+func Test_annotateTermination(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		conf       *Config
+		annotation terminationAnnotation
+		cterr      error
+		peo        *eventbridge.PutEventsOutput
+		wantTags   map[string]string
+		wantEvent  bool
+	}{
+		{
+			name: "replaced instance",
+			conf: &Config{clock: &mockClock{now: now}, runID: "run-1"},
+			annotation: terminationAnnotation{
+				InstanceID: "i-od",
+				Region:     "us-east-1",
+				Group:      "asg",
+				Reason:     replacedBySpotTermination,
+				ReplacedBy: "i-spot",
+			},
+			wantTags: map[string]string{
+				"terminated-by-autospotting":     replacedBySpotTermination,
+				"terminated-by-autospotting-at":  "2021-01-01T00:00:00Z",
+				"terminated-for-replacement-by":  "i-spot",
+				"terminated-by-autospotting-run": "run-1",
+			},
+		},
+		{
+			name: "without replacement and run ID, within the tag namespace",
+			conf: &Config{clock: &mockClock{now: now}, TagKeyPrefix: "acme:"},
+			annotation: terminationAnnotation{
+				InstanceID: "i-spot",
+				Region:     "us-east-1",
+				Reason:     orphanTermination,
+			},
+			wantTags: map[string]string{
+				"acme:terminated-by-autospotting":    orphanTermination,
+				"acme:terminated-by-autospotting-at": "2021-01-01T00:00:00Z",
+			},
+		},
+		{
+			name: "failing tagging",
+			conf: &Config{clock: &mockClock{now: now}},
+			annotation: terminationAnnotation{
+				InstanceID: "i-spot",
+				Reason:     unneededSpotTermination,
+			},
+			cterr: errors.New("tag limit exceeded"),
+			wantTags: map[string]string{
+				"terminated-by-autospotting":    unneededSpotTermination,
+				"terminated-by-autospotting-at": "2021-01-01T00:00:00Z",
+			},
+		},
+		{
+			name: "published to the event bus",
+			conf: &Config{clock: &mockClock{now: now}, runID: "run-1", TerminationEventBus: "bus"},
+			annotation: terminationAnnotation{
+				InstanceID: "i-spot",
+				Region:     "us-east-1",
+				Group:      "asg",
+				Reason:     spotInterruptionTermination,
+			},
+			wantTags: map[string]string{
+				"terminated-by-autospotting":     spotInterruptionTermination,
+				"terminated-by-autospotting-at":  "2021-01-01T00:00:00Z",
+				"terminated-by-autospotting-run": "run-1",
+			},
+			wantEvent: true,
+		},
+		{
+			name: "event rejected by the event bus",
+			conf: &Config{clock: &mockClock{now: now}, TerminationEventBus: "bus"},
+			annotation: terminationAnnotation{
+				InstanceID: "i-spot",
+				Reason:     failedSwapTermination,
+			},
+			peo: &eventbridge.PutEventsOutput{
+				FailedEntryCount: aws.Int64(1),
+				Entries: []*eventbridge.PutEventsResultEntry{{
+					ErrorCode: aws.String("InternalFailure"),
+				}},
+			},
+			wantTags: map[string]string{
+				"terminated-by-autospotting":    failedSwapTermination,
+				"terminated-by-autospotting-at": "2021-01-01T00:00:00Z",
+			},
+			wantEvent: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tagInputs []*ec2.CreateTagsInput
+			var eventInputs []*eventbridge.PutEventsInput
+
+			annotateTermination(tt.conf,
+				mockEC2{cterr: tt.cterr, ctin: &tagInputs},
+				mockEventBridge{peo: tt.peo, pein: &eventInputs},
+				tt.annotation)
+
+			if len(tagInputs) != 1 {
+				t.Fatalf("annotateTermination() tagged %d times, want 1", len(tagInputs))
+			}
+			if got := aws.StringValue(tagInputs[0].Resources[0]); got != tt.annotation.InstanceID {
+				t.Errorf("annotateTermination() tagged %s, want %s", got, tt.annotation.InstanceID)
+			}
+			tags := make(map[string]string)
+			for _, tag := range tagInputs[0].Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			if !reflect.DeepEqual(tags, tt.wantTags) {
+				t.Errorf("annotateTermination() tags = %v, want %v", tags, tt.wantTags)
+			}
+
+			if !tt.wantEvent {
+				if len(eventInputs) != 0 {
+					t.Errorf("annotateTermination() published %d events, want none", len(eventInputs))
+				}
+				return
+			}
+			if len(eventInputs) != 1 || len(eventInputs[0].Entries) != 1 {
+				t.Fatalf("annotateTermination() published %v, want a single event", eventInputs)
+			}
+
+			entry := eventInputs[0].Entries[0]
+			if aws.StringValue(entry.EventBusName) != tt.conf.TerminationEventBus ||
+				aws.StringValue(entry.Source) != terminationEventSource ||
+				aws.StringValue(entry.DetailType) != terminationEventDetailType {
+				t.Errorf("annotateTermination() published the unexpected event %v", entry)
+			}
+
+			var detail terminationAnnotation
+			if err := json.Unmarshal([]byte(aws.StringValue(entry.Detail)), &detail); err != nil {
+				t.Fatalf("annotateTermination() published invalid event detail: %s", err.Error())
+			}
+			want := tt.annotation
+			want.RunID = tt.conf.runID
+			want.Time = now
+			if !reflect.DeepEqual(detail, want) {
+				t.Errorf("annotateTermination() event detail = %+v, want %+v", detail, want)
+			}
+		})
+	}
+}
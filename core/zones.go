@@ -0,0 +1,121 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// isEdgeZone tells whether the given zone is a Local Zone or a Wavelength
+// Zone. Unlike the regular Availability Zones, named after the region followed
+// by a single letter(us-east-1a), these are named after the parent region
+// followed by a location identifier, such as us-west-2-lax-1a or
+// us-east-1-wl1-bos-wlz-1.
+func isEdgeZone(region, az string) bool {
+	return region != "" && strings.HasPrefix(az, region+"-")
+}
+
+// edgeZonePriceLocation returns the location under which the on-demand prices
+// of the Local Zone or Wavelength Zone are published, which for Local Zones is
+// their zone group, such as us-west-2-lax-1 for us-west-2-lax-1a, while
+// Wavelength Zones are priced under their own name.
+func edgeZonePriceLocation(az string) string {
+	if n := len(az); n > 0 && az[n-1] >= 'a' && az[n-1] <= 'z' {
+		return az[:n-1]
+	}
+	return az
+}
+
+// zoneOnDemandPrice returns the on-demand price of the instance type in the
+// Local Zone or Wavelength Zone of the instance, which is usually higher than
+// in the parent region, when known by the price sources.
+func (i *instance) zoneOnDemandPrice(t instanceTypeInformation) (float64, bool) {
+	if i.region == nil || i.region.conf == nil || i.region.conf.priceSources == nil {
+		return 0, false
+	}
+
+	az := i.availabilityZone()
+	if !isEdgeZone(i.region.name, az) {
+		return 0, false
+	}
+
+	price := i.region.conf.priceSources.onDemandPrice(edgeZonePriceLocation(az), t.instanceType, i.platform())
+	if price <= 0 {
+		debug.Println("Unknown on-demand price of", t.instanceType, "in", az, "using the price of", i.region.name)
+		return 0, false
+	}
+	return price * i.region.onDemandPriceMultiplier(), true
+}
+
+// instanceTypesOfferedInZone returns the set of instance types offered in the
+// given zone. The results are cached for the duration of the run, since all
+// the groups from the region may need them concurrently.
+func (r *region) instanceTypesOfferedInZone(az string) (map[string]bool, error) {
+	r.zoneInstanceTypesLock.Lock()
+	defer r.zoneInstanceTypesLock.Unlock()
+
+	if offered, ok := r.zoneInstanceTypes[az]; ok {
+		return offered, nil
+	}
+
+	offered := make(map[string]bool)
+
+	err := r.services.ec2.DescribeInstanceTypeOfferingsPages(
+		&ec2.DescribeInstanceTypeOfferingsInput{
+			LocationType: aws.String(ec2.LocationTypeAvailabilityZone),
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("location"),
+					Values: []*string{aws.String(az)},
+				},
+			},
+		},
+		func(page *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
+			for _, o := range page.InstanceTypeOfferings {
+				offered[aws.StringValue(o.InstanceType)] = true
+			}
+			return true
+		})
+
+	if err != nil {
+		log.Println(r.name, "Failed to describe the instance types offered in", az, err.Error())
+		return nil, err
+	}
+
+	if r.zoneInstanceTypes == nil {
+		r.zoneInstanceTypes = make(map[string]map[string]bool)
+	}
+	r.zoneInstanceTypes[az] = offered
+
+	debug.Println(r.name, "Instance types offered in", az, offered)
+	return offered, nil
+}
+
+// isOfferedInZone restricts the candidates for instances running in Local
+// Zones and Wavelength Zones to the instance types actually offered in the
+// instance's zone, which are usually a small subset of those available in the
+// parent region. Instances from regular Availability Zones aren't restricted.
+func (i *instance) isOfferedInZone(spotCandidate instanceTypeInformation) bool {
+	az := i.availabilityZone()
+	if !isEdgeZone(i.region.name, az) {
+		return true
+	}
+
+	offered, err := i.region.instanceTypesOfferedInZone(az)
+	if err != nil {
+		// better safe than sorry
+		debug.Println("\tUnknown instance type offerings in", az)
+		return false
+	}
+
+	if !offered[spotCandidate.instanceType] {
+		debug.Println("\tNot offered in", az)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_isEdgeZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		region   string
+		az       string
+		expected bool
+	}{
+		{
+			name:     "regular availability zone",
+			region:   "us-west-2",
+			az:       "us-west-2a",
+			expected: false,
+		},
+		{
+			name:     "local zone",
+			region:   "us-west-2",
+			az:       "us-west-2-lax-1a",
+			expected: true,
+		},
+		{
+			name:     "wavelength zone",
+			region:   "us-east-1",
+			az:       "us-east-1-wl1-bos-wlz-1",
+			expected: true,
+		},
+		{
+			name:     "local zone of another region",
+			region:   "us-east-1",
+			az:       "us-west-2-lax-1a",
+			expected: false,
+		},
+		{
+			name:     "missing region",
+			region:   "",
+			az:       "us-west-2-lax-1a",
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isEdgeZone(tt.region, tt.az); got != tt.expected {
+				t.Errorf("isEdgeZone() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isOfferedInZone(t *testing.T) {
+	offerings := []*ec2.DescribeInstanceTypeOfferingsOutput{
+		{
+			InstanceTypeOfferings: []*ec2.InstanceTypeOffering{
+				{InstanceType: aws.String("t3.medium")},
+			},
+		},
+		{
+			InstanceTypeOfferings: []*ec2.InstanceTypeOffering{
+				{InstanceType: aws.String("c5.large")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		az        string
+		candidate string
+		ec2       mockEC2
+		expected  bool
+	}{
+		{
+			name:      "regular availability zone",
+			az:        "us-west-2a",
+			candidate: "m5.24xlarge",
+			ec2:       mockEC2{ditoperr: errors.New("should not be called")},
+			expected:  true,
+		},
+		{
+			name:      "offered in local zone",
+			az:        "us-west-2-lax-1a",
+			candidate: "c5.large",
+			ec2:       mockEC2{ditopo: offerings},
+			expected:  true,
+		},
+		{
+			name:      "not offered in local zone",
+			az:        "us-west-2-lax-1a",
+			candidate: "m5.24xlarge",
+			ec2:       mockEC2{ditopo: offerings},
+			expected:  false,
+		},
+		{
+			name:      "offerings can't be described",
+			az:        "us-west-2-lax-1a",
+			candidate: "c5.large",
+			ec2:       mockEC2{ditoperr: errors.New("denied")},
+			expected:  false,
+		},
+		{
+			name:      "unknown placement",
+			candidate: "c5.large",
+			ec2:       mockEC2{ditoperr: errors.New("should not be called")},
+			expected:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{},
+				region: &region{
+					name:     "us-west-2",
+					services: connections{ec2: tt.ec2},
+				},
+			}
+			if tt.az != "" {
+				i.Placement = &ec2.Placement{AvailabilityZone: aws.String(tt.az)}
+			}
+			candidate := instanceTypeInformation{instanceType: tt.candidate}
+			if got := i.isOfferedInZone(candidate); got != tt.expected {
+				t.Errorf("isOfferedInZone() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_region_instanceTypesOfferedInZone_cached(t *testing.T) {
+	r := &region{
+		name: "us-west-2",
+		services: connections{ec2: mockEC2{
+			ditopo: []*ec2.DescribeInstanceTypeOfferingsOutput{{
+				InstanceTypeOfferings: []*ec2.InstanceTypeOffering{
+					{InstanceType: aws.String("t3.medium")},
+				},
+			}},
+		}},
+	}
+
+	if _, err := r.instanceTypesOfferedInZone("us-west-2-lax-1a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// subsequent calls should be served from the cache
+	r.services.ec2 = mockEC2{ditoperr: errors.New("should not be called")}
+	offered, err := r.instanceTypesOfferedInZone("us-west-2-lax-1a")
+	if err != nil || !offered["t3.medium"] {
+		t.Errorf("instanceTypesOfferedInZone() = %v, %v expected cached offerings", offered, err)
+	}
+}
+
+func Test_edgeZonePriceLocation(t *testing.T) {
+	tests := []struct {
+		az       string
+		expected string
+	}{
+		{az: "us-west-2-lax-1a", expected: "us-west-2-lax-1"},
+		{az: "us-east-1-wl1-bos-wlz-1", expected: "us-east-1-wl1-bos-wlz-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.az, func(t *testing.T) {
+			if got := edgeZonePriceLocation(tt.az); got != tt.expected {
+				t.Errorf("edgeZonePriceLocation() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_onDemandPriceOf_edgeZone(t *testing.T) {
+	sources := priceSources{&overridePriceSource{prices: map[string]float64{
+		"us-west-2-lax-1/c5.large/": 0.1,
+	}}}
+	candidate := instanceTypeInformation{
+		instanceType: "c5.large",
+		pricing:      prices{onDemand: 0.085},
+	}
+
+	tests := []struct {
+		name     string
+		az       string
+		sources  priceSources
+		expected float64
+	}{
+		{name: "local zone price", az: "us-west-2-lax-1a", sources: sources, expected: 0.1},
+		{name: "regular availability zone", az: "us-west-2a", sources: sources, expected: 0.085},
+		{name: "unknown local zone price", az: "us-west-2-den-1a", sources: sources, expected: 0.085},
+		{name: "no price sources", az: "us-west-2-lax-1a", expected: 0.085},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{AvailabilityZone: aws.String(tt.az)},
+				},
+				region: &region{
+					name: "us-west-2",
+					conf: &Config{AutoScalingConfig: AutoScalingConfig{OnDemandPriceMultiplier: 1}, priceSources: tt.sources},
+				},
+			}
+			if got := i.onDemandPriceOf(candidate); got != tt.expected {
+				t.Errorf("onDemandPriceOf() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}